@@ -0,0 +1,237 @@
+package columnar
+
+import (
+	"context"
+	"math"
+	"os"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/VorobevPavel-dev/congenial-disco/tokenizer"
+)
+
+func TestIntEncoding(t *testing.T) {
+	inputs := [][]int{
+		{},
+		{42},
+		{1, 2, 3, 4, 5, 6, 7, 8},
+		{-5, 100, -1000, 0, 7, 7, 7},
+		{math.MaxInt64, math.MinInt64, 0, math.MaxInt64},
+	}
+	var many []int
+	for value := 0; value < 1000; value++ {
+		many = append(many, value*value%977-400)
+	}
+	inputs = append(inputs, many)
+	for testCase, input := range inputs {
+		actual, err := decodeInts(encodeInts(input), len(input))
+		if err != nil {
+			t.Errorf("Decoding failed on set #%d: %v", testCase, err)
+			continue
+		}
+		if !reflect.DeepEqual(actual, input) && len(input) != 0 {
+			t.Errorf("Assertion failed on set #%d. Expected: %v, got: %v", testCase, input, actual)
+		}
+	}
+	// Values growing by the same step are stored in a few bytes
+	sequence := make([]int, 4096)
+	for index := range sequence {
+		sequence[index] = 1000000 + index
+	}
+	if size := len(encodeInts(sequence)); size > 16 {
+		t.Errorf("Expected sequence to be bit-packed, got %d bytes", size)
+	}
+}
+
+func TestTextEncoding(t *testing.T) {
+	var repeated, distinct []string
+	for index := 0; index < 1000; index++ {
+		repeated = append(repeated, "status"+strconv.Itoa(index/100))
+		distinct = append(distinct, "value"+strconv.Itoa(index%10))
+	}
+	inputs := [][]string{{}, {""}, {"a", "b", "a"}, repeated, distinct}
+	expectedEncodings := []string{
+		RunLengthDictionaryEncoding,
+		DictionaryEncoding,
+		DictionaryEncoding,
+		RunLengthDictionaryEncoding,
+		DictionaryEncoding,
+	}
+	for testCase, input := range inputs {
+		encoding, data := encodeTexts(input)
+		if encoding != expectedEncodings[testCase] {
+			t.Errorf("Expected %s encoding on set #%d, got: %s", expectedEncodings[testCase], testCase, encoding)
+		}
+		actual, err := decodeTexts(encoding, data, len(input))
+		if err != nil {
+			t.Errorf("Decoding failed on set #%d: %v", testCase, err)
+			continue
+		}
+		if !reflect.DeepEqual(actual, input) && len(input) != 0 {
+			t.Errorf("Assertion failed on set #%d. Expected: %v, got: %v", testCase, input, actual)
+		}
+	}
+	if _, err := decodeTexts(RunLengthDictionaryEncoding, []byte{200, 1}, 3); err == nil {
+		t.Errorf("Expected error on corrupted chunk")
+	}
+}
+
+func TestZoneMap(t *testing.T) {
+	zone := ZoneMap{Min: 10, Max: 20}
+	inputs := []struct {
+		operator string
+		value    interface{}
+	}{
+		{"=", 5}, {"=", 15}, {"<", 10}, {"<=", 10}, {">", 20}, {">=", 20}, {"<>", 15}, {"=", "15"},
+	}
+	expectedOutputs := []bool{true, false, true, false, true, false, false, false}
+	for testCase, input := range inputs {
+		if actual := zone.Excludes(input.operator, input.value); actual != expectedOutputs[testCase] {
+			t.Errorf("Assertion failed on set #%d. Expected: %v, got: %v", testCase, expectedOutputs[testCase], actual)
+		}
+	}
+	if !(ZoneMap{Min: 1, Max: 1}).Excludes("<>", 1) || !(ZoneMap{Nulls: 3}).Excludes(">", 0) {
+		t.Errorf("Expected constant and NULL chunks to be excluded")
+	}
+}
+
+func TestTable(t *testing.T) {
+	directory := t.TempDir()
+	columns := []Column{{Name: "id", Type: tokenizer.IntType}, {Name: "status", Type: tokenizer.TextType}}
+	table, err := Create(directory, columns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Create(directory, columns); err == nil {
+		t.Errorf("Expected error on creating table twice")
+	}
+	if _, err := Create(t.TempDir(), []Column{{Name: "a", Type: "float"}}); err == nil {
+		t.Errorf("Expected error on unsupported type")
+	}
+	var rows [][]interface{}
+	for id := 0; id < 10000; id++ {
+		var status interface{} = "new"
+		switch {
+		case id%7 == 0:
+			status = nil
+		case id >= 5000:
+			status = "done"
+		}
+		rows = append(rows, []interface{}{id, status})
+	}
+	if err := table.Append(rows); err != nil {
+		t.Fatal(err)
+	}
+	if err := table.Append([][]interface{}{{"1", "new"}}); err == nil {
+		t.Errorf("Expected error on value of wrong type")
+	}
+	if err := table.Append([][]interface{}{{10000, nil}}); err != nil {
+		t.Fatal(err)
+	}
+	rows = append(rows, []interface{}{10000, nil})
+
+	// Table is read back after reopening
+	table, err = Open(directory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if table.RowCount() != len(rows) || len(table.Chunks()) != 4 {
+		t.Fatalf("Expected %d rows in 4 chunks, got %d rows in %d chunks",
+			len(rows), table.RowCount(), len(table.Chunks()))
+	}
+	if zone := table.Chunks()[0].Columns[0].Zone; zone.Min != 0 || zone.Max != ChunkSize-1 {
+		t.Errorf("Unexpected zone map of the first chunk: %+v", zone)
+	}
+
+	inputs := [][]Predicate{
+		nil,
+		{{Column: "id", Operator: ">=", Value: 9000}},
+		{{Column: "id", Operator: "<", Value: 100}, {Column: "status", Operator: "=", Value: "new"}},
+		{{Column: "status", Operator: "=", Value: "done"}},
+		{{Column: "status", Operator: "=", Value: "other"}},
+	}
+	expectedSkipped := []int{0, 2, 3, 2, 4}
+	for testCase, predicates := range inputs {
+		scanner, err := table.Scan([]string{"status", "id"}, predicates)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var read [][]interface{}
+		for {
+			chunk, err := scanner.Next()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if chunk == nil {
+				break
+			}
+			for row := 0; row < chunk.Rows; row++ {
+				read = append(read, []interface{}{chunk.Columns[1].Value(row), chunk.Columns[0].Value(row)})
+			}
+		}
+		if err := scanner.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if scanner.Skipped != expectedSkipped[testCase] {
+			t.Errorf("Expected %d skipped chunks on set #%d, got: %d",
+				expectedSkipped[testCase], testCase, scanner.Skipped)
+		}
+		if testCase == 0 && !reflect.DeepEqual(read, rows) {
+			t.Errorf("Rows read from table differ from written ones")
+		}
+	}
+	if _, err := table.Scan([]string{"unknown"}, nil); err == nil {
+		t.Errorf("Expected error on unknown column")
+	}
+}
+
+// countdownContext is done after Err is called given number of times
+type countdownContext struct {
+	context.Context
+	calls int
+}
+
+func (cc *countdownContext) Err() error {
+	if cc.calls--; cc.calls < 0 {
+		return context.Canceled
+	}
+	return nil
+}
+
+func TestAppendRollback(t *testing.T) {
+	directory := t.TempDir()
+	table, err := Create(directory, []Column{{Name: "id", Type: tokenizer.IntType}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := table.Append([][]interface{}{{1}, {2}}); err != nil {
+		t.Fatal(err)
+	}
+	before, err := os.Stat(table.columnFile(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rows [][]interface{}
+	for id := 0; id < 3*ChunkSize; id++ {
+		rows = append(rows, []interface{}{id})
+	}
+	// Append is canceled after two chunks are written
+	ctx := &countdownContext{Context: context.Background(), calls: 2}
+	if err := table.AppendContext(ctx, rows); err != context.Canceled {
+		t.Fatalf("Expected canceled append, got: %v", err)
+	}
+	after, err := os.Stat(table.columnFile(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after.Size() != before.Size() {
+		t.Errorf("Expected column file to be truncated to %d bytes, got: %d", before.Size(), after.Size())
+	}
+	if table, err = Open(directory); err != nil {
+		t.Fatal(err)
+	}
+	if table.RowCount() != 2 {
+		t.Errorf("Expected rows of canceled append to be invisible, got %d rows", table.RowCount())
+	}
+}
@@ -0,0 +1,282 @@
+package columnar
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/bits"
+)
+
+// Encodings of column chunks
+const (
+	// DeltaEncoding stores the first int, the smallest difference between
+	// neighbouring values and bit-packed excesses of differences over it
+	DeltaEncoding = "delta"
+	// DictionaryEncoding stores distinct texts once and bit-packed indexes
+	// of texts in the dictionary
+	DictionaryEncoding = "dictionary"
+	// RunLengthDictionaryEncoding stores distinct texts once and runs of
+	// equal indexes of texts in the dictionary
+	RunLengthDictionaryEncoding = "rle-dictionary"
+)
+
+var errCorrupted = errors.New("column chunk is corrupted")
+
+// buffer appends encoded values to bytes
+type buffer struct {
+	bytes []byte
+}
+
+func (b *buffer) uvarint(value uint64) {
+	var encoded [binary.MaxVarintLen64]byte
+	b.bytes = append(b.bytes, encoded[:binary.PutUvarint(encoded[:], value)]...)
+}
+
+func (b *buffer) word(value uint64) {
+	var encoded [8]byte
+	binary.LittleEndian.PutUint64(encoded[:], value)
+	b.bytes = append(b.bytes, encoded[:]...)
+}
+
+func (b *buffer) text(value string) {
+	b.uvarint(uint64(len(value)))
+	b.bytes = append(b.bytes, value...)
+}
+
+// packed appends values using width bits for every value
+func (b *buffer) packed(values []uint64, width int) {
+	b.bytes = append(b.bytes, byte(width))
+	var current uint64
+	var used int
+	for _, value := range values {
+		current |= value << used
+		used += width
+		if used >= 64 {
+			b.word(current)
+			// Remaining high bits of value start the next word
+			used -= 64
+			current = value >> (width - used)
+		}
+	}
+	if used > 0 {
+		b.word(current)
+	}
+}
+
+// reader reads values appended by buffer
+type reader struct {
+	bytes []byte
+	err   error
+}
+
+func (r *reader) uvarint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	value, size := binary.Uvarint(r.bytes)
+	if size <= 0 {
+		r.err = errCorrupted
+		return 0
+	}
+	r.bytes = r.bytes[size:]
+	return value
+}
+
+func (r *reader) take(size int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if size < 0 || size > len(r.bytes) {
+		r.err = errCorrupted
+		return nil
+	}
+	result := r.bytes[:size]
+	r.bytes = r.bytes[size:]
+	return result
+}
+
+func (r *reader) text() string {
+	return string(r.take(int(r.uvarint())))
+}
+
+// packed reads count values written by buffer.packed
+func (r *reader) packed(count int) []uint64 {
+	header := r.take(1)
+	if r.err != nil {
+		return nil
+	}
+	width := int(header[0])
+	if width > 64 {
+		r.err = errCorrupted
+		return nil
+	}
+	words := r.take((count*width + 63) / 64 * 8)
+	if r.err != nil {
+		return nil
+	}
+	values := make([]uint64, count)
+	if width == 0 {
+		return values
+	}
+	mask := uint64(1)<<width - 1
+	if width == 64 {
+		mask = ^uint64(0)
+	}
+	for index := range values {
+		start := index * width
+		word, offset := start/64, start%64
+		value := binary.LittleEndian.Uint64(words[word*8:]) >> offset
+		if offset+width > 64 {
+			value |= binary.LittleEndian.Uint64(words[(word+1)*8:]) << (64 - offset)
+		}
+		values[index] = value & mask
+	}
+	return values
+}
+
+// width returns number of bits needed to store the largest of values
+func width(values []uint64) int {
+	var result int
+	for _, value := range values {
+		if length := bits.Len64(value); length > result {
+			result = length
+		}
+	}
+	return result
+}
+
+func zigzag(value int64) uint64 { return uint64(value<<1) ^ uint64(value>>63) }
+
+func unzigzag(value uint64) int64 { return int64(value>>1) ^ -int64(value&1) }
+
+// encodeInts encodes non-NULL ints of chunk with delta encoding. Differences
+// are computed with wrapping arithmetic, so they never overflow
+func encodeInts(values []int) []byte {
+	result := &buffer{}
+	if len(values) == 0 {
+		return result.bytes
+	}
+	result.uvarint(zigzag(int64(values[0])))
+	deltas := make([]uint64, len(values)-1)
+	var minimum int64
+	for index := 1; index < len(values); index++ {
+		delta := int64(uint64(values[index]) - uint64(values[index-1]))
+		deltas[index-1] = uint64(delta)
+		if index == 1 || delta < minimum {
+			minimum = delta
+		}
+	}
+	// Values growing by the same step need no bits at all
+	for index := range deltas {
+		deltas[index] -= uint64(minimum)
+	}
+	result.uvarint(zigzag(minimum))
+	result.packed(deltas, width(deltas))
+	return result.bytes
+}
+
+func decodeInts(bytes []byte, count int) ([]int, error) {
+	values := make([]int, count)
+	if count == 0 {
+		return values, nil
+	}
+	input := &reader{bytes: bytes}
+	values[0] = int(unzigzag(input.uvarint()))
+	if count == 1 {
+		return values, input.err
+	}
+	minimum := uint64(unzigzag(input.uvarint()))
+	deltas := input.packed(count - 1)
+	if input.err != nil {
+		return nil, input.err
+	}
+	for index, delta := range deltas {
+		values[index+1] = int(uint64(values[index]) + minimum + delta)
+	}
+	return values, nil
+}
+
+// encodeTexts encodes non-NULL texts of chunk with dictionary. Indexes are
+// stored as runs when there are at least twice less runs than values
+func encodeTexts(values []string) (string, []byte) {
+	dictionary := map[string]uint64{}
+	var distinct []string
+	codes := make([]uint64, len(values))
+	runs := 0
+	for index, value := range values {
+		code, ok := dictionary[value]
+		if !ok {
+			code = uint64(len(distinct))
+			dictionary[value] = code
+			distinct = append(distinct, value)
+		}
+		codes[index] = code
+		if index == 0 || codes[index-1] != code {
+			runs++
+		}
+	}
+	result := &buffer{}
+	result.uvarint(uint64(len(distinct)))
+	for _, value := range distinct {
+		result.text(value)
+	}
+	if runs*2 > len(values) {
+		result.packed(codes, width(codes))
+		return DictionaryEncoding, result.bytes
+	}
+	result.uvarint(uint64(runs))
+	for start := 0; start < len(codes); {
+		end := start + 1
+		for end < len(codes) && codes[end] == codes[start] {
+			end++
+		}
+		result.uvarint(codes[start])
+		result.uvarint(uint64(end - start))
+		start = end
+	}
+	return RunLengthDictionaryEncoding, result.bytes
+}
+
+func decodeTexts(encoding string, bytes []byte, count int) ([]string, error) {
+	input := &reader{bytes: bytes}
+	size := input.uvarint()
+	if size > uint64(count) {
+		return nil, errCorrupted
+	}
+	distinct := make([]string, size)
+	for index := range distinct {
+		distinct[index] = input.text()
+	}
+	var codes []uint64
+	switch encoding {
+	case DictionaryEncoding:
+		codes = input.packed(count)
+	case RunLengthDictionaryEncoding:
+		runs := input.uvarint()
+		for run := uint64(0); run < runs && input.err == nil; run++ {
+			code, length := input.uvarint(), input.uvarint()
+			if length > uint64(count-len(codes)) {
+				return nil, errCorrupted
+			}
+			for ; length > 0; length-- {
+				codes = append(codes, code)
+			}
+		}
+	default:
+		return nil, fmt.Errorf("unknown encoding %s of text column", encoding)
+	}
+	if input.err != nil {
+		return nil, input.err
+	}
+	if len(codes) != count {
+		return nil, errCorrupted
+	}
+	values := make([]string, count)
+	for index, code := range codes {
+		if code >= uint64(len(distinct)) {
+			return nil, errCorrupted
+		}
+		values[index] = distinct[code]
+	}
+	return values, nil
+}
@@ -0,0 +1,208 @@
+package columnar
+
+import (
+	"fmt"
+	"os"
+)
+
+// Predicate is a comparison of column with constant value. Scans skip chunks
+// which zone maps show that no row of chunk matches predicate
+type Predicate struct {
+	Column   string
+	Operator string
+	Value    interface{}
+}
+
+// Excludes checks if no value described by zone map can satisfy comparison
+// with value. Comparisons with NULL are never true, so chunk of NULLs is
+// always excluded
+func (zm ZoneMap) Excludes(operator string, value interface{}) bool {
+	if zm.Min == nil {
+		return true
+	}
+	lower, lowerOk := compare(zm.Min, value)
+	upper, upperOk := compare(zm.Max, value)
+	if !lowerOk || !upperOk {
+		return false
+	}
+	switch operator {
+	case "=":
+		return lower > 0 || upper < 0
+	case "<>", "!=":
+		return lower == 0 && upper == 0
+	case "<":
+		return lower >= 0
+	case "<=":
+		return lower > 0
+	case ">":
+		return upper <= 0
+	case ">=":
+		return upper < 0
+	}
+	return false
+}
+
+// compare compares values of the same type, ok is false for values of
+// different types
+func compare(first interface{}, second interface{}) (order int, ok bool) {
+	switch value := first.(type) {
+	case int:
+		other, ok := second.(int)
+		if !ok {
+			return 0, false
+		}
+		switch {
+		case value < other:
+			return -1, true
+		case value > other:
+			return 1, true
+		}
+		return 0, true
+	case string:
+		other, ok := second.(string)
+		if !ok {
+			return 0, false
+		}
+		switch {
+		case value < other:
+			return -1, true
+		case value > other:
+			return 1, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+// ChunkData holds decoded columns of chunk
+type ChunkData struct {
+	Rows    int
+	Columns []*ColumnData
+}
+
+// Scanner reads chunks of table one by one
+type Scanner struct {
+	table      *Table
+	chunks     []*Chunk
+	indexes    []int
+	predicates []Predicate
+	targets    []int
+	files      map[int]*os.File
+	next       int
+	// Skipped is a number of chunks skipped by zone maps
+	Skipped int
+}
+
+// Scan returns scanner reading given columns of table rows. Chunks appended
+// after Scan are not read by scanner
+func (t *Table) Scan(columns []string, predicates []Predicate) (*Scanner, error) {
+	scanner := &Scanner{table: t, chunks: t.Chunks(), predicates: predicates, files: map[int]*os.File{}}
+	for _, name := range columns {
+		index, err := t.column(name)
+		if err != nil {
+			return nil, err
+		}
+		scanner.indexes = append(scanner.indexes, index)
+	}
+	for _, predicate := range predicates {
+		index, err := t.column(predicate.Column)
+		if err != nil {
+			return nil, err
+		}
+		scanner.targets = append(scanner.targets, index)
+	}
+	return scanner, nil
+}
+
+// ScanPartition returns scanner reading only chunks which indexes give
+// partition as remainder of division by number of partitions, so scanners
+// of all partitions read every chunk once
+func (t *Table) ScanPartition(columns []string, predicates []Predicate, partition, partitions int) (*Scanner, error) {
+	scanner, err := t.Scan(columns, predicates)
+	if err != nil {
+		return nil, err
+	}
+	var chunks []*Chunk
+	for index, chunk := range scanner.chunks {
+		if index%partitions == partition {
+			chunks = append(chunks, chunk)
+		}
+	}
+	scanner.chunks = chunks
+	return scanner, nil
+}
+
+func (t *Table) column(name string) (int, error) {
+	for index, column := range t.metadata.Columns {
+		if column.Name == name {
+			return index, nil
+		}
+	}
+	return -1, fmt.Errorf("column %s does not exist in table in %s", name, t.directory)
+}
+
+// Next returns the next chunk which can match predicates or nil after the
+// last one
+func (s *Scanner) Next() (*ChunkData, error) {
+	for ; s.next < len(s.chunks); s.next++ {
+		chunk := s.chunks[s.next]
+		if s.excluded(chunk) {
+			s.Skipped++
+			continue
+		}
+		s.next++
+		result := &ChunkData{Rows: chunk.Rows}
+		for _, index := range s.indexes {
+			data, err := s.read(index, chunk)
+			if err != nil {
+				return nil, err
+			}
+			result.Columns = append(result.Columns, data)
+		}
+		return result, nil
+	}
+	return nil, nil
+}
+
+func (s *Scanner) excluded(chunk *Chunk) bool {
+	for position, predicate := range s.predicates {
+		if chunk.Columns[s.targets[position]].Zone.Excludes(predicate.Operator, predicate.Value) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Scanner) read(index int, chunk *Chunk) (*ColumnData, error) {
+	file, ok := s.files[index]
+	if !ok {
+		var err error
+		if file, err = os.Open(s.table.columnFile(index)); err != nil {
+			return nil, err
+		}
+		s.files[index] = file
+	}
+	location := chunk.Columns[index]
+	data := make([]byte, location.Size)
+	if _, err := file.ReadAt(data, location.Offset); err != nil {
+		return nil, err
+	}
+	column := s.table.metadata.Columns[index]
+	result, err := decodeColumn(column, location, chunk.Rows, data)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read column %s: %v", column.Name, err)
+	}
+	return result, nil
+}
+
+// Close closes files opened by scanner
+func (s *Scanner) Close() error {
+	var result error
+	for index, file := range s.files {
+		if err := file.Close(); err != nil && result == nil {
+			result = err
+		}
+		delete(s.files, index)
+	}
+	return result
+}
@@ -0,0 +1,436 @@
+// Package columnar stores tables by columns. Rows of table are split into
+// chunks, every column of chunk is encoded separately and described by zone
+// map which lets scans skip chunks not matching predicates.
+package columnar
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+
+	"github.com/VorobevPavel-dev/congenial-disco/tokenizer"
+)
+
+// ChunkSize is a maximal number of rows in a chunk
+const ChunkSize = 4096
+
+const metadataFile = "table.json"
+
+// Column is a column of table
+type Column struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+// ZoneMap describes values of column in a chunk. Min and Max are nil when
+// all values are NULL
+type ZoneMap struct {
+	Nulls int         `json:"nulls"`
+	Min   interface{} `json:"min,omitempty"`
+	Max   interface{} `json:"max,omitempty"`
+}
+
+// ColumnChunk is a location of encoded column of chunk in column file
+type ColumnChunk struct {
+	Offset   int64   `json:"offset"`
+	Size     int     `json:"size"`
+	Encoding string  `json:"encoding"`
+	Zone     ZoneMap `json:"zone"`
+}
+
+// Chunk is a part of table rows
+type Chunk struct {
+	Rows    int            `json:"rows"`
+	Columns []*ColumnChunk `json:"columns"`
+}
+
+type metadata struct {
+	Columns []Column `json:"columns"`
+	Chunks  []*Chunk `json:"chunks"`
+}
+
+// Table is a table stored in directory. Every column is stored in its own
+// file, chunks and their zone maps are listed in metadata file which is
+// replaced after new chunks are written, so partially written chunks are
+// never visible
+type Table struct {
+	directory string
+	mutex     sync.RWMutex
+	metadata  metadata
+}
+
+// Create creates empty table in directory
+func Create(directory string, columns []Column) (*Table, error) {
+	names := map[string]bool{}
+	for _, column := range columns {
+		if column.Type != tokenizer.IntType && column.Type != tokenizer.TextType {
+			return nil, fmt.Errorf("unsupported type %s of column %s", column.Type, column.Name)
+		}
+		if names[column.Name] {
+			return nil, fmt.Errorf("column %s is defined twice", column.Name)
+		}
+		names[column.Name] = true
+	}
+	if err := os.MkdirAll(directory, 0755); err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(filepath.Join(directory, metadataFile)); err == nil {
+		return nil, fmt.Errorf("table already exists in %s", directory)
+	}
+	table := &Table{directory: directory, metadata: metadata{Columns: columns}}
+	for index := range columns {
+		file, err := os.OpenFile(table.columnFile(index), os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+		if err != nil {
+			return nil, err
+		}
+		if err := file.Close(); err != nil {
+			return nil, err
+		}
+	}
+	if err := table.saveMetadata(table.metadata); err != nil {
+		return nil, err
+	}
+	return table, nil
+}
+
+// Open opens table created in directory
+func Open(directory string) (*Table, error) {
+	data, err := os.ReadFile(filepath.Join(directory, metadataFile))
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	table := &Table{directory: directory}
+	if err := decoder.Decode(&table.metadata); err != nil {
+		return nil, fmt.Errorf("cannot read metadata of table in %s: %v", directory, err)
+	}
+	// Bounds of int columns are decoded as numbers, they are converted back
+	// to ints to be compared with values
+	for _, chunk := range table.metadata.Chunks {
+		if len(chunk.Columns) != len(table.metadata.Columns) {
+			return nil, fmt.Errorf("chunk of table in %s has %d columns instead of %d",
+				directory, len(chunk.Columns), len(table.metadata.Columns))
+		}
+		for index, column := range chunk.Columns {
+			if table.metadata.Columns[index].Type != tokenizer.IntType {
+				continue
+			}
+			for _, bound := range []*interface{}{&column.Zone.Min, &column.Zone.Max} {
+				if number, ok := (*bound).(json.Number); ok {
+					value, err := strconv.Atoi(number.String())
+					if err != nil {
+						return nil, fmt.Errorf("cannot read zone map of table in %s: %v", directory, err)
+					}
+					*bound = value
+				}
+			}
+		}
+	}
+	return table, nil
+}
+
+func (t *Table) columnFile(index int) string {
+	return filepath.Join(t.directory, strconv.Itoa(index)+".column")
+}
+
+// saveMetadata writes metadata to temporary file and renames it to replace
+// previous version at once
+func (t *Table) saveMetadata(metadata metadata) error {
+	data, err := json.Marshal(metadata)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(t.directory, metadataFile)
+	file, err := os.Create(path + ".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// Columns returns columns of table
+func (t *Table) Columns() []Column {
+	return append([]Column{}, t.metadata.Columns...)
+}
+
+// RowCount returns number of rows of table
+func (t *Table) RowCount() int {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	var result int
+	for _, chunk := range t.metadata.Chunks {
+		result += chunk.Rows
+	}
+	return result
+}
+
+// Chunks returns chunks of table
+func (t *Table) Chunks() []*Chunk {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	return append([]*Chunk{}, t.metadata.Chunks...)
+}
+
+// Append adds rows to table. Rows are written as new chunks of at most
+// ChunkSize rows, so rows should be appended in large groups
+func (t *Table) Append(rows [][]interface{}) error {
+	return t.AppendContext(context.Background(), rows)
+}
+
+// AppendContext is Append which is aborted with error of context once it is
+// done. Rows of aborted or failed append are never visible and column files
+// are truncated back to their previous sizes
+func (t *Table) AppendContext(ctx context.Context, rows [][]interface{}) (err error) {
+	columns := t.metadata.Columns
+	for _, row := range rows {
+		if len(row) != len(columns) {
+			return fmt.Errorf("expected %d values in row, got: %d", len(columns), len(row))
+		}
+		for index, value := range row {
+			if err := checkValue(columns[index], value); err != nil {
+				return err
+			}
+		}
+	}
+	if len(rows) == 0 {
+		return nil
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	files := make([]*os.File, len(columns))
+	offsets := make([]int64, len(columns))
+	defer func() {
+		for index, file := range files {
+			if file == nil {
+				continue
+			}
+			if err != nil {
+				file.Truncate(offsets[index])
+			}
+			file.Close()
+		}
+	}()
+	for index := range columns {
+		file, err := os.OpenFile(t.columnFile(index), os.O_WRONLY, 0644)
+		if err != nil {
+			return err
+		}
+		if offsets[index], err = file.Seek(0, 2); err != nil {
+			file.Close()
+			return err
+		}
+		files[index] = file
+	}
+	// Offsets are sizes of files before append, written is where the next
+	// chunk is written
+	written := append([]int64{}, offsets...)
+	updated := t.metadata
+	updated.Chunks = append([]*Chunk{}, t.metadata.Chunks...)
+	for start := 0; start < len(rows); start += ChunkSize {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		end := start + ChunkSize
+		if end > len(rows) {
+			end = len(rows)
+		}
+		chunk := &Chunk{Rows: end - start}
+		for index, column := range columns {
+			values := make([]interface{}, end-start)
+			for position, row := range rows[start:end] {
+				values[position] = row[index]
+			}
+			encoded, data := encodeColumn(column.Type, values)
+			if _, err := files[index].Write(data); err != nil {
+				return err
+			}
+			encoded.Offset = written[index]
+			written[index] += int64(len(data))
+			chunk.Columns = append(chunk.Columns, encoded)
+		}
+		updated.Chunks = append(updated.Chunks, chunk)
+	}
+	for _, file := range files {
+		if err := file.Sync(); err != nil {
+			return err
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := t.saveMetadata(updated); err != nil {
+		return err
+	}
+	t.metadata = updated
+	return nil
+}
+
+func checkValue(column Column, value interface{}) error {
+	switch value.(type) {
+	case nil:
+		return nil
+	case int:
+		if column.Type == tokenizer.IntType {
+			return nil
+		}
+	case string:
+		if column.Type == tokenizer.TextType {
+			return nil
+		}
+	}
+	return fmt.Errorf("cannot store %v (%T) in column %s of type %s", value, value, column.Name, column.Type)
+}
+
+// encodeColumn encodes values of column in chunk. Encoded column starts with
+// number of NULLs followed by bitmap of NULLs if there are any. Values which
+// are not NULL follow them
+func encodeColumn(datatype string, values []interface{}) (*ColumnChunk, []byte) {
+	result := &ColumnChunk{}
+	nulls := make([]uint64, (len(values)+63)/64)
+	var ints []int
+	var texts []string
+	for index, value := range values {
+		switch typed := value.(type) {
+		case nil:
+			nulls[index/64] |= 1 << (index % 64)
+			result.Zone.Nulls++
+			continue
+		case int:
+			ints = append(ints, typed)
+			if result.Zone.Min == nil || typed < result.Zone.Min.(int) {
+				result.Zone.Min = typed
+			}
+			if result.Zone.Max == nil || typed > result.Zone.Max.(int) {
+				result.Zone.Max = typed
+			}
+		case string:
+			texts = append(texts, typed)
+			if result.Zone.Min == nil || typed < result.Zone.Min.(string) {
+				result.Zone.Min = typed
+			}
+			if result.Zone.Max == nil || typed > result.Zone.Max.(string) {
+				result.Zone.Max = typed
+			}
+		}
+	}
+	output := &buffer{}
+	output.uvarint(uint64(result.Zone.Nulls))
+	if result.Zone.Nulls > 0 {
+		for _, word := range nulls {
+			output.word(word)
+		}
+	}
+	var payload []byte
+	if datatype == tokenizer.IntType {
+		result.Encoding, payload = DeltaEncoding, encodeInts(ints)
+	} else {
+		result.Encoding, payload = encodeTexts(texts)
+	}
+	output.bytes = append(output.bytes, payload...)
+	result.Size = len(output.bytes)
+	return result, output.bytes
+}
+
+// ColumnData holds decoded values of column in a chunk. Values of rows are
+// stored in slice matching Type at indexes of rows, NULLs are marked in Nulls
+// bitmap
+type ColumnData struct {
+	Type  string
+	Ints  []int
+	Texts []string
+	Nulls []uint64
+}
+
+// IsNull checks if value of row is NULL
+func (cd *ColumnData) IsNull(row int) bool {
+	return cd.Nulls[row/64]&(1<<(row%64)) != 0
+}
+
+// Value returns value of row as int, string or nil for NULL
+func (cd *ColumnData) Value(row int) interface{} {
+	switch {
+	case cd.IsNull(row):
+		return nil
+	case cd.Type == tokenizer.IntType:
+		return cd.Ints[row]
+	}
+	return cd.Texts[row]
+}
+
+func decodeColumn(column Column, chunk *ColumnChunk, rows int, data []byte) (*ColumnData, error) {
+	input := &reader{bytes: data}
+	result := &ColumnData{Type: column.Type, Nulls: make([]uint64, (rows+63)/64)}
+	nulls := int(input.uvarint())
+	if nulls > rows {
+		return nil, errCorrupted
+	}
+	if nulls > 0 {
+		for index := range result.Nulls {
+			word := input.take(8)
+			if input.err != nil {
+				return nil, input.err
+			}
+			result.Nulls[index] = binary.LittleEndian.Uint64(word)
+		}
+	}
+	if input.err != nil {
+		return nil, input.err
+	}
+	// Values are stored densely, they are moved to indexes of their rows
+	var err error
+	position := 0
+	if column.Type == tokenizer.IntType {
+		if chunk.Encoding != DeltaEncoding {
+			return nil, fmt.Errorf("unknown encoding %s of int column", chunk.Encoding)
+		}
+		var values []int
+		if values, err = decodeInts(input.bytes, rows-nulls); err != nil {
+			return nil, err
+		}
+		result.Ints = make([]int, rows)
+		for row := 0; row < rows; row++ {
+			if nulls == 0 || !result.IsNull(row) {
+				if position == len(values) {
+					return nil, errCorrupted
+				}
+				result.Ints[row] = values[position]
+				position++
+			}
+		}
+	} else {
+		var values []string
+		if values, err = decodeTexts(chunk.Encoding, input.bytes, rows-nulls); err != nil {
+			return nil, err
+		}
+		result.Texts = make([]string, rows)
+		for row := 0; row < rows; row++ {
+			if nulls == 0 || !result.IsNull(row) {
+				if position == len(values) {
+					return nil, errCorrupted
+				}
+				result.Texts[row] = values[position]
+				position++
+			}
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,304 @@
+// Package driver registers "disco" driver of database/sql. Data source name
+// is a path of directory of database, which is opened in process:
+//
+//	db, err := sql.Open("disco", "/var/lib/disco")
+//
+// Connections to one directory share the database, which is closed with the
+// last of them. Data source names of "disco://host:port" form are reserved
+// for connections to disco server and are rejected, since there is no
+// server yet.
+package driver
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/VorobevPavel-dev/congenial-disco/parser"
+	"github.com/VorobevPavel-dev/congenial-disco/planner"
+	"github.com/VorobevPavel-dev/congenial-disco/session"
+	"github.com/VorobevPavel-dev/congenial-disco/tokenizer"
+)
+
+const networkPrefix = "disco://"
+
+func init() {
+	sql.Register("disco", &Driver{})
+}
+
+// Driver opens connections to databases stored in directories
+type Driver struct{}
+
+var (
+	mutex sync.Mutex
+	// databases are open databases keyed by absolute paths of directories
+	databases = map[string]*database{}
+)
+
+// database counts connections of open database
+type database struct {
+	*session.Database
+	path        string
+	connections int
+}
+
+func (d *Driver) Open(name string) (driver.Conn, error) {
+	if strings.HasPrefix(name, networkPrefix) {
+		return nil, fmt.Errorf("cannot connect to %s: connections to disco server are not supported", name)
+	}
+	path, err := filepath.Abs(name)
+	if err != nil {
+		return nil, err
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	opened, ok := databases[path]
+	if !ok {
+		db, err := session.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		opened = &database{Database: db, path: path}
+		databases[path] = opened
+	}
+	opened.connections++
+	return &conn{database: opened, session: opened.NewSession()}, nil
+}
+
+// release closes database after its last connection is closed
+func (d *database) release() error {
+	mutex.Lock()
+	defer mutex.Unlock()
+	if d.connections--; d.connections != 0 {
+		return nil
+	}
+	delete(databases, d.path)
+	return d.Close()
+}
+
+// conn is a session of database. Statements of connection are executed one
+// at a time, as database/sql never uses connection concurrently
+type conn struct {
+	database *database
+	session  *session.Session
+}
+
+func (c *conn) Prepare(query string) (driver.Stmt, error) {
+	return c.PrepareContext(context.Background(), query)
+}
+
+func (c *conn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	prepared, err := parser.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	return &stmt{conn: c, prepared: prepared}, nil
+}
+
+func (c *conn) Close() error {
+	err := c.session.Close()
+	if releaseErr := c.database.release(); err == nil {
+		err = releaseErr
+	}
+	return err
+}
+
+func (c *conn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+// BeginTx starts transaction of isolation level of options. Levels weaker
+// than repeatable read get snapshot isolation too
+func (c *conn) BeginTx(ctx context.Context, options driver.TxOptions) (driver.Tx, error) {
+	if options.ReadOnly {
+		return nil, errors.New("read-only transactions are not supported")
+	}
+	request := "begin;"
+	switch sql.IsolationLevel(options.Isolation) {
+	case sql.LevelDefault, sql.LevelReadUncommitted, sql.LevelReadCommitted, sql.LevelRepeatableRead, sql.LevelSnapshot:
+	case sql.LevelSerializable:
+		request = "begin isolation level serializable;"
+	default:
+		return nil, fmt.Errorf("isolation level %s is not supported", sql.IsolationLevel(options.Isolation))
+	}
+	if _, err := c.control(ctx, request); err != nil {
+		return nil, err
+	}
+	return &tx{conn: c}, nil
+}
+
+// control executes transaction control statement
+func (c *conn) control(ctx context.Context, request string) (*session.Result, error) {
+	statement, err := parser.Parse(request)
+	if err != nil {
+		return nil, err
+	}
+	return c.session.Execute(ctx, statement)
+}
+
+type tx struct {
+	conn *conn
+}
+
+func (t *tx) Commit() error {
+	_, err := t.conn.control(context.Background(), "commit;")
+	return err
+}
+
+func (t *tx) Rollback() error {
+	_, err := t.conn.control(context.Background(), "rollback;")
+	return err
+}
+
+// stmt binds arguments to prepared statement and executes it in session of
+// connection
+type stmt struct {
+	conn     *conn
+	prepared *parser.PreparedStatement
+}
+
+func (s *stmt) Close() error { return nil }
+
+// NumInput returns number of parameters. Named parameters are not counted,
+// since arguments are matched with them by names
+func (s *stmt) NumInput() int {
+	for _, parameter := range s.prepared.Parameters {
+		if !isNumber(parameter.Name) {
+			return -1
+		}
+	}
+	return len(s.prepared.Parameters)
+}
+
+func isNumber(name string) bool {
+	for _, symbol := range name {
+		if symbol < '0' || symbol > '9' {
+			return false
+		}
+	}
+	return name != ""
+}
+
+func (s *stmt) Exec(args []driver.Value) (driver.Result, error) {
+	return s.ExecContext(context.Background(), namedValues(args))
+}
+
+func (s *stmt) Query(args []driver.Value) (driver.Rows, error) {
+	return s.QueryContext(context.Background(), namedValues(args))
+}
+
+func (s *stmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	result, err := s.execute(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+	return driver.RowsAffected(result.RowsAffected), nil
+}
+
+func (s *stmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	result, err := s.execute(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+	return &rows{columns: result.Columns, rows: result.Rows}, nil
+}
+
+// execute binds arguments and executes statement. Queries are aborted once
+// context is done
+func (s *stmt) execute(ctx context.Context, args []driver.NamedValue) (*session.Result, error) {
+	arguments := make([]interface{}, len(args))
+	for index, arg := range args {
+		value := arg.Value
+		if bytes, ok := value.([]byte); ok {
+			value = string(bytes)
+		}
+		if arg.Name != "" {
+			value = parser.Named(arg.Name, value)
+		}
+		arguments[index] = value
+	}
+	statement, err := s.prepared.Bind(arguments...)
+	if err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return s.conn.session.Execute(ctx, statement)
+}
+
+func namedValues(args []driver.Value) []driver.NamedValue {
+	result := make([]driver.NamedValue, len(args))
+	for index, value := range args {
+		result[index] = driver.NamedValue{Ordinal: index + 1, Value: value}
+	}
+	return result
+}
+
+// rows returns rows of query result, which is read by session at once
+type rows struct {
+	columns []planner.Column
+	rows    []planner.Row
+}
+
+func (r *rows) Columns() []string {
+	names := make([]string, len(r.columns))
+	for index, column := range r.columns {
+		names[index] = column.Name
+	}
+	return names
+}
+
+func (r *rows) Close() error {
+	r.rows = nil
+	return nil
+}
+
+// Next converts integers to int64, which database/sql expects
+func (r *rows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	for index, value := range r.rows[0] {
+		if number, ok := value.(int); ok {
+			value = int64(number)
+		}
+		dest[index] = value
+	}
+	r.rows = r.rows[1:]
+	return nil
+}
+
+// ColumnTypeDatabaseTypeName returns type of column as it is written in
+// CREATE TABLE statement
+func (r *rows) ColumnTypeDatabaseTypeName(index int) string {
+	return strings.ToUpper(r.columns[index].Type)
+}
+
+func (r *rows) ColumnTypeScanType(index int) reflect.Type {
+	switch r.columns[index].Type {
+	case tokenizer.IntType:
+		return reflect.TypeOf(sql.NullInt64{})
+	case tokenizer.TextType:
+		return reflect.TypeOf(sql.NullString{})
+	case "bool":
+		return reflect.TypeOf(sql.NullBool{})
+	}
+	return reflect.TypeOf(new(interface{})).Elem()
+}
+
+// ColumnTypeNullable reports that every column may be NULL, since columns
+// of tables have no NOT NULL constraints
+func (r *rows) ColumnTypeNullable(index int) (nullable, ok bool) {
+	return true, true
+}
@@ -0,0 +1,196 @@
+package driver
+
+import (
+	"context"
+	"database/sql"
+	"reflect"
+	"testing"
+
+	"github.com/VorobevPavel-dev/congenial-disco/engine"
+)
+
+func openDB(t *testing.T, directory string) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("disco", directory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Ping(); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// names returns names of users ordered by id
+func names(t *testing.T, db *sql.DB) []string {
+	t.Helper()
+	rows, err := db.Query("select name from users order by id;")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var result []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			t.Fatal(err)
+		}
+		result = append(result, name)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+func TestDriver(t *testing.T) {
+	directory := t.TempDir()
+	db := openDB(t, directory)
+	if _, err := db.Exec("create table users (id int, name text, city int);"); err != nil {
+		t.Fatal(err)
+	}
+	insert, err := db.Prepare("insert into users values (?, ?, ?);")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, user := range []struct {
+		id   int
+		name string
+		city int
+	}{{1, "alice", 1}, {2, "bob", 2}, {3, "carol", 1}} {
+		result, err := insert.Exec(user.id, user.name, user.city)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if affected, _ := result.RowsAffected(); affected != 1 {
+			t.Errorf("Expected one inserted row, got: %d", affected)
+		}
+	}
+	if _, err := insert.Exec(4, "dave"); err == nil {
+		t.Errorf("Expected error on missing argument")
+	}
+	insert.Close()
+
+	// Parameters and column types
+	rows, err := db.Query("select id, name from users where city = $1 order by id;", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	types, err := rows.ColumnTypes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for index, expected := range []struct {
+		name, database string
+		scan           reflect.Type
+	}{
+		{"id", "INT", reflect.TypeOf(sql.NullInt64{})},
+		{"name", "TEXT", reflect.TypeOf(sql.NullString{})},
+	} {
+		nullable, ok := types[index].Nullable()
+		if types[index].Name() != expected.name || types[index].DatabaseTypeName() != expected.database ||
+			types[index].ScanType() != expected.scan || !nullable || !ok {
+			t.Errorf("Unexpected type of column #%d: %+v", index, types[index])
+		}
+	}
+	var users []string
+	for rows.Next() {
+		var (
+			id   int64
+			name string
+		)
+		if err := rows.Scan(&id, &name); err != nil {
+			t.Fatal(err)
+		}
+		users = append(users, name)
+	}
+	rows.Close()
+	if expected := []string{"alice", "carol"}; !reflect.DeepEqual(users, expected) {
+		t.Errorf("Expected %v, got: %v", expected, users)
+	}
+	var name string
+	if err := db.QueryRow("select name from users where id = :id;", sql.Named("id", 2)).Scan(&name); err != nil || name != "bob" {
+		t.Errorf("Expected bob, got: %s %v", name, err)
+	}
+
+	// Transactions
+	for _, commit := range []bool{false, true} {
+		tx, err := db.Begin()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tx.Exec("insert into users values (4, 'dave', 2);"); err != nil {
+			t.Fatal(err)
+		}
+		if count := len(names(t, db)); count != 3 {
+			t.Errorf("Expected changes of transaction to be hidden, got %d users", count)
+		}
+		if commit {
+			err = tx.Commit()
+		} else {
+			err = tx.Rollback()
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if users := names(t, db); len(users) != 4 {
+		t.Errorf("Expected committed user, got: %v", users)
+	}
+	first, err := db.BeginTx(context.Background(), &sql.TxOptions{Isolation: sql.LevelSerializable})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := db.BeginTx(context.Background(), &sql.TxOptions{Isolation: sql.LevelSerializable})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for index, tx := range []*sql.Tx{first, second} {
+		var count int
+		if err := tx.QueryRow("select count(*) from users where city = 3;").Scan(&count); err != nil || count != 0 {
+			t.Fatalf("Expected no users of city, got: %d %v", count, err)
+		}
+		if _, err := tx.Exec("insert into users values (?, 'erin', 3);", 5+index); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := first.Commit(); !engine.IsRetryable(err) {
+		t.Errorf("Expected serialization failure, got: %v", err)
+	}
+	if err := second.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.BeginTx(context.Background(), &sql.TxOptions{ReadOnly: true}); err == nil {
+		t.Errorf("Expected error on read-only transaction")
+	}
+
+	// Canceled queries fail
+	ctx, cancel := context.WithCancel(context.Background())
+	conn, err := db.Conn(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	if _, err := conn.QueryContext(ctx, "select name from users;"); err != context.Canceled {
+		t.Errorf("Expected canceled query, got: %v", err)
+	}
+	conn.Close()
+
+	// Tables and their types survive restart
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	db = openDB(t, directory)
+	defer db.Close()
+	if users, expected := names(t, db), []string{"alice", "bob", "carol", "dave", "erin"}; !reflect.DeepEqual(users, expected) {
+		t.Errorf("Expected %v after restart, got: %v", expected, users)
+	}
+
+	network, err := sql.Open("disco", "disco://localhost:5432")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := network.Ping(); err == nil {
+		t.Errorf("Expected error on connecting to server")
+	}
+}
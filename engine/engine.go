@@ -0,0 +1,352 @@
+// Package engine stores tables of the database. Storage engines keep rows
+// differently, heap engine in memory and LSM engine in LSM tree, but are used
+// the same way through transactions, so tables of one database may live in
+// different engines.
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/VorobevPavel-dev/congenial-disco/parser"
+)
+
+// SerializationFailure is SQLSTATE code of errors of transactions failed
+// because of concurrent ones. Such transactions may succeed when retried
+const SerializationFailure = "40001"
+
+// Error is an error with SQLSTATE code, by which clients tell errors they
+// can handle
+type Error struct {
+	Code    string
+	Message string
+}
+
+func (e *Error) Error() string { return e.Message }
+
+var (
+	// ErrWriteConflict is returned by Commit when rows changed by
+	// transaction were changed by another transaction committed after it
+	// began
+	ErrWriteConflict = &Error{Code: SerializationFailure, Message: "could not serialize access due to concurrent update"}
+	// ErrSerializationFailure is returned by Commit of serializable
+	// transaction which read or wrote rows in a way no serial order of
+	// concurrent transactions allows
+	ErrSerializationFailure = &Error{
+		Code:    SerializationFailure,
+		Message: "could not serialize access due to read/write dependencies among transactions",
+	}
+)
+
+// IsRetryable checks if transaction failed with err may succeed when it is
+// retried from the beginning
+func IsRetryable(err error) bool {
+	var typed *Error
+	return errors.As(err, &typed) && typed.Code == SerializationFailure
+}
+
+// StorageEngine keeps tables. Executor reads and changes them only through
+// transactions, so engines storing rows differently are interchangeable
+type StorageEngine interface {
+	CreateTable(statement *parser.CreateTableStatement) error
+	Begin() (Transaction, error)
+	Close() error
+}
+
+// Row is a tuple of values. Values are int, string, bool or nil for NULL
+type Row []interface{}
+
+// Iterator returns rows one by one. Next returns nil row when there are no
+// more rows
+type Iterator interface {
+	Next() (Row, error)
+	Close() error
+}
+
+// Transaction reads tables as they were when it began together with its own
+// changes, which become visible to others after Commit. Rows are identified
+// by value of their first column
+type Transaction interface {
+	// Scan returns iterator over rows of table consisting of values of
+	// given columns in the same order
+	Scan(table string, columns []string) (Iterator, error)
+	// Columns returns names of columns of table
+	Columns(table string) ([]string, error)
+	// Insert adds rows with values of all columns of table
+	Insert(table string, rows []Row) error
+	// Get returns row with given key or nil when there is no such row
+	Get(table string, key interface{}) (Row, error)
+	// Delete removes row with given key if it exists
+	Delete(table string, key interface{}) error
+	// Savepoint returns a mark of changes made so far. RollbackTo undoes
+	// changes made after the mark was taken, marks taken after it are no
+	// longer valid
+	Savepoint() (int, error)
+	RollbackTo(savepoint int) error
+	Commit() error
+	Rollback() error
+}
+
+// rowKey checks that row fits columns of table and returns its key
+func rowKey(table string, columns []string, row Row) (interface{}, error) {
+	if len(row) != len(columns) {
+		return nil, fmt.Errorf("expected %d values for table %s, got %d", len(columns), table, len(row))
+	}
+	if row[0] == nil {
+		return nil, fmt.Errorf("key %s of table %s is NULL", columns[0], table)
+	}
+	return row[0], nil
+}
+
+// columnIndexes returns positions of columns among names of columns of table
+func columnIndexes(table string, names, columns []string) ([]int, error) {
+	indexes := make([]int, len(columns))
+	for position, column := range columns {
+		indexes[position] = -1
+		for index, name := range names {
+			if name == column {
+				indexes[position] = index
+				break
+			}
+		}
+		if indexes[position] == -1 {
+			return nil, fmt.Errorf("column %s does not exist in table %s", column, table)
+		}
+	}
+	return indexes, nil
+}
+
+// rowsIterator returns values of columns at indexes of rows kept in memory
+type rowsIterator struct {
+	rows    []Row
+	indexes []int
+}
+
+func (ri *rowsIterator) Next() (Row, error) {
+	if len(ri.rows) == 0 {
+		return nil, nil
+	}
+	source := ri.rows[0]
+	ri.rows = ri.rows[1:]
+	row := make(Row, len(ri.indexes))
+	for position, index := range ri.indexes {
+		row[position] = source[index]
+	}
+	return row, nil
+}
+
+func (ri *rowsIterator) Close() error { return nil }
+
+// Engines picks storage engine of table by storage set by WITH clause of
+// CREATE TABLE statement. Tables without storage option go to engine of
+// row storage
+type Engines struct {
+	engines map[string]StorageEngine
+
+	mutex  sync.Mutex
+	tables map[string]StorageEngine
+
+	dependencies dependencies
+}
+
+// NewEngines returns Engines with engines keyed by storage names
+func NewEngines(engines map[string]StorageEngine) *Engines {
+	return &Engines{
+		engines:      engines,
+		tables:       map[string]StorageEngine{},
+		dependencies: dependencies{transactions: map[*serializableTransaction]bool{}},
+	}
+}
+
+func (e *Engines) engine(storage string) (StorageEngine, error) {
+	if storage == "" {
+		storage = parser.RowStorage
+	}
+	engine, ok := e.engines[storage]
+	if !ok {
+		return nil, fmt.Errorf("storage %s is not available", storage)
+	}
+	return engine, nil
+}
+
+func (e *Engines) CreateTable(statement *parser.CreateTableStatement) error {
+	engine, err := e.engine(statement.Storage)
+	if err != nil {
+		return err
+	}
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	for _, other := range e.engines {
+		if other == engine {
+			continue
+		}
+		// Tables of all engines share one namespace
+		transaction, err := other.Begin()
+		if err != nil {
+			return err
+		}
+		_, err = transaction.Columns(statement.Name.Value)
+		transaction.Rollback()
+		if err == nil {
+			return fmt.Errorf("table %s already exists", statement.Name.Value)
+		}
+	}
+	if err := engine.CreateTable(statement); err != nil {
+		return err
+	}
+	e.tables[statement.Name.Value] = engine
+	return nil
+}
+
+// Begin starts transaction spanning all engines. Its Commit commits
+// transactions of engines one by one, so it is atomic only for changes of
+// one engine
+func (e *Engines) Begin() (Transaction, error) {
+	result := &enginesTransaction{engines: e, transactions: map[StorageEngine]Transaction{}}
+	for _, engine := range e.engines {
+		if _, ok := result.transactions[engine]; ok {
+			continue
+		}
+		transaction, err := engine.Begin()
+		if err != nil {
+			result.Rollback()
+			return nil, err
+		}
+		result.transactions[engine] = transaction
+		result.order = append(result.order, transaction)
+	}
+	return result, nil
+}
+
+func (e *Engines) Close() error {
+	var result error
+	closed := map[StorageEngine]bool{}
+	for _, engine := range e.engines {
+		if closed[engine] {
+			continue
+		}
+		closed[engine] = true
+		if err := engine.Close(); err != nil && result == nil {
+			result = err
+		}
+	}
+	return result
+}
+
+// enginesTransaction routes operations to transaction of engine of table
+type enginesTransaction struct {
+	engines      *Engines
+	transactions map[StorageEngine]Transaction
+	order        []Transaction
+	// savepoints keep savepoints of transactions of engines in their order
+	savepoints [][]int
+}
+
+// transaction returns transaction of engine which has table
+func (et *enginesTransaction) transaction(table string) (Transaction, error) {
+	et.engines.mutex.Lock()
+	engine, ok := et.engines.tables[table]
+	et.engines.mutex.Unlock()
+	if ok {
+		return et.transactions[engine], nil
+	}
+	// Tables created before restart are found by asking every engine
+	for engine, transaction := range et.transactions {
+		if _, err := transaction.Columns(table); err == nil {
+			et.engines.mutex.Lock()
+			et.engines.tables[table] = engine
+			et.engines.mutex.Unlock()
+			return transaction, nil
+		}
+	}
+	return nil, fmt.Errorf("table %s does not exist", table)
+}
+
+func (et *enginesTransaction) Scan(table string, columns []string) (Iterator, error) {
+	transaction, err := et.transaction(table)
+	if err != nil {
+		return nil, err
+	}
+	return transaction.Scan(table, columns)
+}
+
+func (et *enginesTransaction) Columns(table string) ([]string, error) {
+	transaction, err := et.transaction(table)
+	if err != nil {
+		return nil, err
+	}
+	return transaction.Columns(table)
+}
+
+func (et *enginesTransaction) Insert(table string, rows []Row) error {
+	transaction, err := et.transaction(table)
+	if err != nil {
+		return err
+	}
+	return transaction.Insert(table, rows)
+}
+
+func (et *enginesTransaction) Get(table string, key interface{}) (Row, error) {
+	transaction, err := et.transaction(table)
+	if err != nil {
+		return nil, err
+	}
+	return transaction.Get(table, key)
+}
+
+func (et *enginesTransaction) Delete(table string, key interface{}) error {
+	transaction, err := et.transaction(table)
+	if err != nil {
+		return err
+	}
+	return transaction.Delete(table, key)
+}
+
+func (et *enginesTransaction) Savepoint() (int, error) {
+	savepoints := make([]int, len(et.order))
+	for index, transaction := range et.order {
+		savepoint, err := transaction.Savepoint()
+		if err != nil {
+			return 0, err
+		}
+		savepoints[index] = savepoint
+	}
+	et.savepoints = append(et.savepoints, savepoints)
+	return len(et.savepoints) - 1, nil
+}
+
+func (et *enginesTransaction) RollbackTo(savepoint int) error {
+	if savepoint < 0 || savepoint >= len(et.savepoints) {
+		return fmt.Errorf("savepoint %d does not exist", savepoint)
+	}
+	for index, transaction := range et.order {
+		if err := transaction.RollbackTo(et.savepoints[savepoint][index]); err != nil {
+			return err
+		}
+	}
+	et.savepoints = et.savepoints[:savepoint+1]
+	return nil
+}
+
+func (et *enginesTransaction) Commit() error {
+	for index, transaction := range et.order {
+		if err := transaction.Commit(); err != nil {
+			for _, rest := range et.order[index+1:] {
+				rest.Rollback()
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+func (et *enginesTransaction) Rollback() error {
+	var result error
+	for _, transaction := range et.order {
+		if err := transaction.Rollback(); err != nil && result == nil {
+			result = err
+		}
+	}
+	return result
+}
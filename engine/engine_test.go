@@ -0,0 +1,592 @@
+package engine
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/VorobevPavel-dev/congenial-disco/lsm"
+	"github.com/VorobevPavel-dev/congenial-disco/parser"
+)
+
+func createTable(t *testing.T, storage StorageEngine, request string) error {
+	t.Helper()
+	statement, err := parser.Parse(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return storage.CreateTable(statement.CreateTableStatement)
+}
+
+// scanAll reads all rows of table with given columns
+func scanAll(t *testing.T, transaction Transaction, table string, columns ...string) []Row {
+	t.Helper()
+	iterator, err := transaction.Scan(table, columns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer iterator.Close()
+	var rows []Row
+	for {
+		row, err := iterator.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if row == nil {
+			return rows
+		}
+		rows = append(rows, row)
+	}
+}
+
+func TestRowEncoding(t *testing.T) {
+	rows := []Row{
+		{},
+		{1, -1, 0},
+		{"alice", "", nil},
+		{true, false, 1 << 40, "it's"},
+	}
+	var buffer []byte
+	for _, row := range rows {
+		var err error
+		if buffer, err = AppendRow(buffer, row); err != nil {
+			t.Fatal(err)
+		}
+	}
+	reader := bytes.NewReader(buffer)
+	for index, expected := range rows {
+		row, err := ReadRow(reader)
+		if err != nil || !reflect.DeepEqual(row, expected) {
+			t.Errorf("Assertion failed on set #%d. Expected: %v, got: %v (%v)", index, expected, row, err)
+		}
+	}
+	if _, err := AppendRow(nil, Row{1.5}); err == nil {
+		t.Errorf("Expected error on encoding float")
+	}
+	if _, err := ReadRow(bytes.NewReader([]byte{1, 9})); err != ErrCorruptedRow {
+		t.Errorf("Expected corrupted row, got: %v", err)
+	}
+}
+
+func TestEngines(t *testing.T) {
+	directory := t.TempDir()
+	options := &lsm.Options{MemtableSize: 4096, Level0Files: 2, LevelSize: 16 << 10, TableSize: 4096}
+	openEngines := func() *Engines {
+		t.Helper()
+		engine, err := OpenLSMEngine(directory, options)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return NewEngines(map[string]StorageEngine{parser.RowStorage: NewHeapEngine(), parser.LSMStorage: engine})
+	}
+	engines := openEngines()
+	for _, request := range []string{
+		"create table users (id int, name text, city int);",
+		"create table orders (id int, user int, amount int) with (storage = lsm);",
+	} {
+		if err := createTable(t, engines, request); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := createTable(t, engines, "create table orders (id int) with (storage = row);"); err == nil {
+		t.Errorf("Expected error on creating table existing in another engine")
+	}
+	if err := createTable(t, engines, "create table events (id int) with (storage = column);"); err == nil {
+		t.Errorf("Expected error on storage without engine")
+	}
+
+	transaction, err := engines.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := transaction.Insert("users", []Row{{1, "alice", 1}, {2, "bob", nil}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := transaction.Insert("users", []Row{{3, "carol"}}); err == nil {
+		t.Errorf("Expected error on row without all columns")
+	}
+	// Many writes go through flushes and compactions of LSM tree
+	for id := 1; id <= 1000; id++ {
+		if err := transaction.Insert("orders", []Row{{id, id%2 + 1, id * 10}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := transaction.Insert("orders", []Row{{5, 1, 1}}); err == nil {
+		t.Errorf("Expected error on duplicate key")
+	}
+	if err := transaction.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	for id := 1001; id <= 3000; id++ {
+		transaction, _ := engines.Begin()
+		if err := transaction.Insert("orders", []Row{{id, 3, id}}); err != nil {
+			t.Fatal(err)
+		}
+		if err := transaction.Delete("orders", id-1000); err != nil {
+			t.Fatal(err)
+		}
+		if err := transaction.Commit(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Transactions see tables as of their beginning and conflicting commits
+	// fail
+	for _, table := range []string{"users", "orders"} {
+		first, _ := engines.Begin()
+		second, _ := engines.Begin()
+		key := 2
+		if table == "orders" {
+			key = 2500
+		}
+		if err := first.Delete(table, key); err != nil {
+			t.Fatal(err)
+		}
+		if err := first.Commit(); err != nil {
+			t.Fatal(err)
+		}
+		if row, err := second.Get(table, key); err != nil || row == nil {
+			t.Errorf("Expected row %d of %s to be visible to older transaction, got: %v %v", key, table, row, err)
+		}
+		if err := second.Delete(table, key); err != nil {
+			t.Fatal(err)
+		}
+		if err := second.Commit(); err != ErrWriteConflict {
+			t.Errorf("Expected write conflict on %s, got: %v", table, err)
+		}
+	}
+
+	transaction, _ = engines.Begin()
+	if err := transaction.Insert("orders", []Row{{1, 1, 1}}); err != nil {
+		t.Fatal(err)
+	}
+	// Scans merge committed rows with rows changed by transaction
+	if rows := scanAll(t, transaction, "users", "name"); !reflect.DeepEqual(rows, []Row{{"alice"}}) {
+		t.Errorf("Unexpected users: %v", rows)
+	}
+	orders := scanAll(t, transaction, "orders", "amount", "id")
+	if len(orders) != 1000 || !reflect.DeepEqual(orders[0], Row{1, 1}) {
+		t.Errorf("Expected 1000 orders starting with the inserted one, got %d: %v", len(orders), orders[0])
+	}
+	if _, err := transaction.Scan("orders", []string{"title"}); err == nil {
+		t.Errorf("Expected error on scanning unknown column")
+	}
+	if row, _ := transaction.Get("orders", 2999); !reflect.DeepEqual(row, Row{2999, 3, 2999}) {
+		t.Errorf("Unexpected row: %v", row)
+	}
+	if err := transaction.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	if err := engines.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Tables of LSM engine survive restart
+	reopened := openEngines()
+	defer reopened.Close()
+	transaction, _ = reopened.Begin()
+	defer transaction.Rollback()
+	if _, err := transaction.Columns("users"); err == nil {
+		t.Errorf("Expected tables of heap engine to be lost")
+	}
+	if rows := scanAll(t, transaction, "orders", "id"); len(rows) != 999 {
+		t.Errorf("Expected 999 orders, got: %d", len(rows))
+	}
+}
+
+func TestHeapVersions(t *testing.T) {
+	heap := NewHeapEngine()
+	if err := createTable(t, heap, "create table counters (id int, value int);"); err != nil {
+		t.Fatal(err)
+	}
+	transaction, _ := heap.Begin()
+	for id := 0; id < 100; id++ {
+		if err := transaction.Insert("counters", []Row{{id, 0}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := transaction.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	old, _ := heap.Begin()
+	defer old.Rollback()
+	// Every commit changes one row: rows are updated, deleted and inserted
+	// again many times, so that versions are dropped and keys are vacuumed
+	for step := 1; step <= 1000; step++ {
+		transaction, _ := heap.Begin()
+		id := step % 100
+		if err := transaction.Delete("counters", id); err != nil {
+			t.Fatal(err)
+		}
+		if step%3 != 0 {
+			if err := transaction.Insert("counters", []Row{{id, step}}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := transaction.Commit(); err != nil {
+			t.Fatal(err)
+		}
+		if step%3 == 0 {
+			transaction, _ = heap.Begin()
+			if err := transaction.Insert("counters", []Row{{id, -step}}); err != nil {
+				t.Fatal(err)
+			}
+			if err := transaction.Commit(); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	rows := scanAll(t, old, "counters", "value")
+	if len(rows) != 100 {
+		t.Errorf("Expected 100 rows visible to old transaction, got: %d", len(rows))
+	}
+	for _, row := range rows {
+		if row[0] != 0 {
+			t.Errorf("Expected rows of old transaction to be unchanged, got: %v", row)
+			break
+		}
+	}
+	current, _ := heap.Begin()
+	defer current.Rollback()
+	for id := 0; id < 100; id++ {
+		step := 900 + id
+		if id == 0 {
+			step = 1000
+		}
+		expected := Row{id, step}
+		if step%3 == 0 {
+			expected = Row{id, -step}
+		}
+		if row, _ := current.Get("counters", id); !reflect.DeepEqual(row, expected) {
+			t.Errorf("Assertion failed on set #%d. Expected: %v, got: %v", id, expected, row)
+		}
+	}
+	if rows := scanAll(t, current, "counters", "id"); len(rows) != 100 {
+		t.Errorf("Expected 100 rows, got: %d", len(rows))
+	}
+
+	// Keys of deleted rows are forgotten when no transaction can see them
+	old.Rollback()
+	current.Rollback()
+	for id := 0; id < 100; id++ {
+		transaction, _ := heap.Begin()
+		if err := transaction.Delete("counters", id); err != nil {
+			t.Fatal(err)
+		}
+		if err := transaction.Commit(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if keys := len(heap.tables["counters"].keys); keys > 50 {
+		t.Errorf("Expected keys of deleted rows to be vacuumed, got %d keys", keys)
+	}
+}
+
+// BenchmarkHeapCommit commits one changed row of a big table at a time, so
+// it shows whether commits depend on size of tables
+func BenchmarkHeapCommit(b *testing.B) {
+	for _, size := range []int{1000, 100000} {
+		b.Run(strconv.Itoa(size), func(b *testing.B) {
+			heap := NewHeapEngine()
+			statement, _ := parser.Parse("create table counters (id int, value int);")
+			if err := heap.CreateTable(statement.CreateTableStatement); err != nil {
+				b.Fatal(err)
+			}
+			transaction, _ := heap.Begin()
+			for id := 0; id < size; id++ {
+				transaction.Insert("counters", []Row{{id, 0}})
+			}
+			if err := transaction.Commit(); err != nil {
+				b.Fatal(err)
+			}
+			b.ResetTimer()
+			for index := 0; index < b.N; index++ {
+				transaction, _ := heap.Begin()
+				id := index % size
+				transaction.Delete("counters", id)
+				transaction.Insert("counters", []Row{{id, index}})
+				if err := transaction.Commit(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// TestConcurrentTransactions moves amounts between accounts from many
+// goroutines while others read all accounts. Readers see snapshots, so the
+// total never changes for them, and writers retry on conflicts
+func TestConcurrentTransactions(t *testing.T) {
+	tree, err := OpenLSMEngine(t.TempDir(), &lsm.Options{MemtableSize: 4096})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+	heap := NewHeapEngine()
+	const accounts, total = 10, 1000
+	for name, storage := range map[string]StorageEngine{"heap": heap, "lsm": tree} {
+		t.Run(name, func(t *testing.T) {
+			if err := createTable(t, storage, "create table accounts (id int, amount int);"); err != nil {
+				t.Fatal(err)
+			}
+			transaction, _ := storage.Begin()
+			for id := 0; id < accounts; id++ {
+				if err := transaction.Insert("accounts", []Row{{id, total / accounts}}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := transaction.Commit(); err != nil {
+				t.Fatal(err)
+			}
+
+			var group sync.WaitGroup
+			errs := make(chan error, 8)
+			for worker := 0; worker < 4; worker++ {
+				group.Add(2)
+				go func(worker int) {
+					defer group.Done()
+					for step := 0; step < 200; step++ {
+						from, to := (worker+step)%accounts, (worker+step*3+1)%accounts
+						if from == to {
+							continue
+						}
+						if err := transfer(storage, from, to); err != nil && err != ErrWriteConflict {
+							errs <- err
+							return
+						}
+					}
+				}(worker)
+				go func() {
+					defer group.Done()
+					for step := 0; step < 200; step++ {
+						transaction, _ := storage.Begin()
+						sum := 0
+						iterator, err := transaction.Scan("accounts", []string{"amount"})
+						if err != nil {
+							errs <- err
+							return
+						}
+						for {
+							row, err := iterator.Next()
+							if err != nil {
+								errs <- err
+								return
+							}
+							if row == nil {
+								break
+							}
+							sum += row[0].(int)
+						}
+						iterator.Close()
+						transaction.Rollback()
+						if sum != total {
+							errs <- fmt.Errorf("expected total %d, got: %d", total, sum)
+							return
+						}
+					}
+				}()
+			}
+			group.Wait()
+			close(errs)
+			for err := range errs {
+				t.Error(err)
+			}
+		})
+	}
+
+	// Once no transaction reads old versions, commits drop them
+	for id := 0; id < accounts; id++ {
+		if err := transfer(heap, id, (id+1)%accounts); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for key, newest := range heap.tables["accounts"].versions {
+		length := 0
+		for version := newest; version != nil; version = version.older {
+			length++
+		}
+		if length > 2 {
+			t.Errorf("Expected obsolete versions of %v to be dropped, got %d versions", key, length)
+		}
+	}
+}
+
+// transfer moves one from account to another in a transaction of its own
+func transfer(storage StorageEngine, from, to int) error {
+	transaction, err := storage.Begin()
+	if err != nil {
+		return err
+	}
+	for _, change := range []struct{ id, delta int }{{from, -1}, {to, 1}} {
+		row, err := transaction.Get("accounts", change.id)
+		if err == nil {
+			err = transaction.Delete("accounts", change.id)
+		}
+		if err == nil {
+			err = transaction.Insert("accounts", []Row{{change.id, row[1].(int) + change.delta}})
+		}
+		if err != nil {
+			transaction.Rollback()
+			return err
+		}
+	}
+	return transaction.Commit()
+}
+
+// TestSerializable runs the same random schedules of transactions taking
+// doctors off call while at least two of them are on call. Snapshot
+// isolation lets concurrent transactions take the last doctors off at once
+// (write skew), serializable transactions must never do it
+func TestSerializable(t *testing.T) {
+	const doctors, rounds = 3, 300
+	for _, serializable := range []bool{false, true} {
+		heap := NewHeapEngine()
+		engines := NewEngines(map[string]StorageEngine{parser.RowStorage: heap})
+		if err := createTable(t, engines, "create table doctors (id int, on_call int);"); err != nil {
+			t.Fatal(err)
+		}
+		begin := engines.Begin
+		if serializable {
+			begin = engines.BeginSerializable
+		}
+		random := rand.New(rand.NewSource(42))
+		var anomalies, failures int
+		for round := 0; round < rounds; round++ {
+			transaction, _ := engines.Begin()
+			for id := 0; id < doctors; id++ {
+				transaction.Delete("doctors", id)
+				transaction.Insert("doctors", []Row{{id, 1}})
+			}
+			if err := transaction.Commit(); err != nil {
+				t.Fatal(err)
+			}
+			// Every doctor runs begin, read, write and commit steps, steps of
+			// doctors are interleaved randomly
+			transactions := make([]Transaction, doctors)
+			steps := make([]int, doctors)
+			for done := 0; done < doctors; {
+				id := random.Intn(doctors)
+				var err error
+				switch steps[id] {
+				case 0:
+					transactions[id], err = begin()
+				case 1:
+					onCall := 0
+					for _, row := range scanAll(t, transactions[id], "doctors", "on_call") {
+						if row[0] == 1 {
+							onCall++
+						}
+					}
+					if onCall < 2 {
+						steps[id] = 2
+					}
+				case 2:
+					if err = transactions[id].Delete("doctors", id); err == nil {
+						err = transactions[id].Insert("doctors", []Row{{id, 0}})
+					}
+				case 3:
+					err = transactions[id].Commit()
+				default:
+					continue
+				}
+				switch {
+				case IsRetryable(err):
+					failures++
+					steps[id] = 0
+					continue
+				case err != nil:
+					t.Fatal(err)
+				}
+				if steps[id]++; steps[id] == 4 {
+					done++
+				}
+			}
+			transaction, _ = engines.Begin()
+			onCall := 0
+			for _, row := range scanAll(t, transaction, "doctors", "on_call") {
+				if row[0] == 1 {
+					onCall++
+				}
+			}
+			transaction.Rollback()
+			if onCall == 0 {
+				anomalies++
+			}
+		}
+		switch {
+		case serializable && anomalies != 0:
+			t.Errorf("Expected no write skew of serializable transactions, got %d of %d rounds", anomalies, rounds)
+		case serializable && failures == 0:
+			t.Errorf("Expected serialization failures")
+		case !serializable && anomalies == 0:
+			t.Errorf("Expected write skew of snapshot isolation")
+		}
+		if len(engines.dependencies.transactions) != 0 {
+			t.Errorf("Expected finished transactions to be forgotten, got: %d", len(engines.dependencies.transactions))
+		}
+	}
+}
+
+func TestSavepoints(t *testing.T) {
+	tree, err := OpenLSMEngine(t.TempDir(), &lsm.Options{MemtableSize: 4096})
+	if err != nil {
+		t.Fatal(err)
+	}
+	engines := NewEngines(map[string]StorageEngine{parser.RowStorage: NewHeapEngine(), parser.LSMStorage: tree})
+	defer engines.Close()
+	for _, request := range []string{
+		"create table users (id int, name text);",
+		"create table orders (id int, user int) with (storage = lsm);",
+	} {
+		if err := createTable(t, engines, request); err != nil {
+			t.Fatal(err)
+		}
+	}
+	transaction, _ := engines.Begin()
+	mustChange := func(err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	mustChange(transaction.Insert("users", []Row{{1, "alice"}, {2, "bob"}}))
+	mustChange(transaction.Insert("orders", []Row{{1, 1}}))
+	first, err := transaction.Savepoint()
+	mustChange(err)
+	mustChange(transaction.Delete("users", 1))
+	mustChange(transaction.Insert("users", []Row{{1, "carol"}, {3, "dave"}}))
+	second, err := transaction.Savepoint()
+	mustChange(err)
+	mustChange(transaction.Delete("orders", 1))
+	mustChange(transaction.Insert("orders", []Row{{2, 3}}))
+
+	mustChange(transaction.RollbackTo(first))
+	if err := transaction.RollbackTo(second); err == nil {
+		t.Errorf("Expected savepoint taken after the restored one to be invalid")
+	}
+	// The restored savepoint stays valid
+	mustChange(transaction.Insert("users", []Row{{4, "erin"}}))
+	mustChange(transaction.RollbackTo(first))
+	mustChange(transaction.Insert("orders", []Row{{3, 2}}))
+	mustChange(transaction.Commit())
+	if err := transaction.RollbackTo(first); err == nil {
+		t.Errorf("Expected error on savepoint of committed transaction")
+	}
+
+	transaction, _ = engines.Begin()
+	defer transaction.Rollback()
+	expected := map[string][]Row{
+		"users":  {{1, "alice"}, {2, "bob"}},
+		"orders": {{1, 1}, {3, 2}},
+	}
+	for table, rows := range expected {
+		columns, _ := transaction.Columns(table)
+		if scanned := scanAll(t, transaction, table, columns...); !reflect.DeepEqual(scanned, rows) {
+			t.Errorf("Expected rows of %s: %v, got: %v", table, rows, scanned)
+		}
+	}
+}
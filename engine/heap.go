@@ -0,0 +1,372 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/VorobevPavel-dev/congenial-disco/parser"
+)
+
+var errTransactionDone = errors.New("transaction is already committed or rolled back")
+
+// heapVersion is a row of key committed with commit number version, deleted
+// rows are nil. Versions of key are chained from the newest to the oldest
+type heapVersion struct {
+	row     Row
+	version uint64
+	older   *heapVersion
+}
+
+// visible returns the newest version of chain committed not later than
+// commit number version
+func (hv *heapVersion) visible(version uint64) *heapVersion {
+	for hv != nil && hv.version > version {
+		hv = hv.older
+	}
+	return hv
+}
+
+// heapTable keeps versions of rows by keys and keys in insertion order.
+// Commits add versions of changed keys only, so transactions read tables as
+// of their beginning without copying them
+type heapTable struct {
+	columns  []string
+	keys     []interface{}
+	versions map[interface{}]*heapVersion
+	// dead counts deletions since keys were vacuumed
+	dead int
+}
+
+// HeapEngine is a StorageEngine keeping rows of tables in memory
+type HeapEngine struct {
+	mutex   sync.RWMutex
+	tables  map[string]*heapTable
+	version uint64
+	// running counts running transactions by commit numbers they read
+	running map[uint64]int
+}
+
+func NewHeapEngine() *HeapEngine {
+	return &HeapEngine{tables: map[string]*heapTable{}, running: map[uint64]int{}}
+}
+
+func (he *HeapEngine) CreateTable(statement *parser.CreateTableStatement) error {
+	he.mutex.Lock()
+	defer he.mutex.Unlock()
+	name := statement.Name.Value
+	if _, ok := he.tables[name]; ok {
+		return fmt.Errorf("table %s already exists", name)
+	}
+	table := &heapTable{versions: map[interface{}]*heapVersion{}}
+	for _, column := range statement.Cols {
+		table.columns = append(table.columns, column.Name.Value)
+	}
+	tables := make(map[string]*heapTable, len(he.tables)+1)
+	for other, data := range he.tables {
+		tables[other] = data
+	}
+	tables[name] = table
+	he.tables = tables
+	return nil
+}
+
+func (he *HeapEngine) Begin() (Transaction, error) {
+	he.mutex.Lock()
+	defer he.mutex.Unlock()
+	he.running[he.version]++
+	return &heapTransaction{
+		engine:  he,
+		tables:  he.tables,
+		version: he.version,
+		changes: changes{writes: map[string]*writeSet{}},
+	}, nil
+}
+
+func (he *HeapEngine) Close() error { return nil }
+
+// oldest returns commit number read by the oldest running transaction or
+// the last commit number when there are none. Versions older than it are
+// not visible to anyone
+func (he *HeapEngine) oldest() uint64 {
+	result := he.version
+	for version := range he.running {
+		if version < result {
+			result = version
+		}
+	}
+	return result
+}
+
+// finish forgets transaction reading commit number version
+func (he *HeapEngine) finish(version uint64) {
+	he.mutex.Lock()
+	defer he.mutex.Unlock()
+	if he.running[version]--; he.running[version] == 0 {
+		delete(he.running, version)
+	}
+}
+
+// writeSet keeps rows changed by transaction in table in order of changes,
+// deleted rows are nil
+type writeSet struct {
+	rows  map[interface{}]Row
+	order []interface{}
+}
+
+func (ws *writeSet) set(key interface{}, row Row) {
+	if _, ok := ws.rows[key]; !ok {
+		ws.order = append(ws.order, key)
+	}
+	ws.rows[key] = row
+}
+
+// changes keeps write sets of tables changed by transaction together with
+// journal of changes, so that changes made after a savepoint can be undone
+type changes struct {
+	writes  map[string]*writeSet
+	journal []change
+}
+
+// change keeps row of key of table before it was changed, existed is false
+// for keys changed for the first time
+type change struct {
+	table   string
+	key     interface{}
+	row     Row
+	existed bool
+}
+
+func (c *changes) set(table string, key interface{}, row Row) {
+	writes, ok := c.writes[table]
+	if !ok {
+		writes = &writeSet{rows: map[interface{}]Row{}}
+		c.writes[table] = writes
+	}
+	previous, existed := writes.rows[key]
+	c.journal = append(c.journal, change{table: table, key: key, row: previous, existed: existed})
+	writes.set(key, row)
+}
+
+// savepoint returns number of changes made so far
+func (c *changes) savepoint() int {
+	return len(c.journal)
+}
+
+// rollbackTo undoes changes in reverse order until there are savepoint of
+// them. Keys changed for the first time were added to the end of order of
+// their write sets, so they are the last ones there when undone
+func (c *changes) rollbackTo(savepoint int) error {
+	if savepoint < 0 || savepoint > len(c.journal) {
+		return fmt.Errorf("savepoint %d does not exist", savepoint)
+	}
+	for len(c.journal) > savepoint {
+		last := c.journal[len(c.journal)-1]
+		c.journal = c.journal[:len(c.journal)-1]
+		writes := c.writes[last.table]
+		if last.existed {
+			writes.rows[last.key] = last.row
+			continue
+		}
+		delete(writes.rows, last.key)
+		writes.order = writes.order[:len(writes.order)-1]
+	}
+	return nil
+}
+
+type heapTransaction struct {
+	changes
+	engine  *HeapEngine
+	tables  map[string]*heapTable
+	version uint64
+	done    bool
+}
+
+func (ht *heapTransaction) table(name string) (*heapTable, error) {
+	if ht.done {
+		return nil, errTransactionDone
+	}
+	table, ok := ht.tables[name]
+	if !ok {
+		return nil, fmt.Errorf("table %s does not exist", name)
+	}
+	return table, nil
+}
+
+func (ht *heapTransaction) Columns(table string) ([]string, error) {
+	data, err := ht.table(table)
+	if err != nil {
+		return nil, err
+	}
+	return data.columns, nil
+}
+
+// Scan reads committed rows not changed by transaction followed by rows it
+// inserted
+func (ht *heapTransaction) Scan(table string, columns []string) (Iterator, error) {
+	data, err := ht.table(table)
+	if err != nil {
+		return nil, err
+	}
+	indexes, err := columnIndexes(table, data.columns, columns)
+	if err != nil {
+		return nil, err
+	}
+	writes := ht.writes[table]
+	var rows []Row
+	ht.engine.mutex.RLock()
+	for _, key := range data.keys {
+		if writes != nil {
+			if _, ok := writes.rows[key]; ok {
+				continue
+			}
+		}
+		if visible := data.versions[key].visible(ht.version); visible != nil && visible.row != nil {
+			rows = append(rows, visible.row)
+		}
+	}
+	ht.engine.mutex.RUnlock()
+	if writes != nil {
+		for _, key := range writes.order {
+			if row := writes.rows[key]; row != nil {
+				rows = append(rows, row)
+			}
+		}
+	}
+	return &rowsIterator{rows: rows, indexes: indexes}, nil
+}
+
+func (ht *heapTransaction) Get(table string, key interface{}) (Row, error) {
+	data, err := ht.table(table)
+	if err != nil {
+		return nil, err
+	}
+	if writes, ok := ht.writes[table]; ok {
+		if row, ok := writes.rows[key]; ok {
+			return row, nil
+		}
+	}
+	ht.engine.mutex.RLock()
+	defer ht.engine.mutex.RUnlock()
+	if visible := data.versions[key].visible(ht.version); visible != nil {
+		return visible.row, nil
+	}
+	return nil, nil
+}
+
+func (ht *heapTransaction) Insert(table string, rows []Row) error {
+	data, err := ht.table(table)
+	if err != nil {
+		return err
+	}
+	for _, row := range rows {
+		key, err := rowKey(table, data.columns, row)
+		if err != nil {
+			return err
+		}
+		if existing, err := ht.Get(table, key); err != nil || existing != nil {
+			if err == nil {
+				err = fmt.Errorf("duplicate key %v in table %s", key, table)
+			}
+			return err
+		}
+		ht.set(table, key, row)
+	}
+	return nil
+}
+
+func (ht *heapTransaction) Delete(table string, key interface{}) error {
+	existing, err := ht.Get(table, key)
+	if err != nil || existing == nil {
+		return err
+	}
+	ht.set(table, key, nil)
+	return nil
+}
+
+// Commit adds versions of changed keys, so its cost depends on the number
+// of changes only. It fails if any changed key was changed by transaction
+// committed after this one began
+func (ht *heapTransaction) Commit() error {
+	if ht.done {
+		return errTransactionDone
+	}
+	ht.done = true
+	engine := ht.engine
+	defer engine.finish(ht.version)
+	engine.mutex.Lock()
+	defer engine.mutex.Unlock()
+	for table, writes := range ht.writes {
+		data := ht.tables[table]
+		for _, key := range writes.order {
+			if newest := data.versions[key]; newest != nil && newest.version > ht.version {
+				return ErrWriteConflict
+			}
+		}
+	}
+	engine.version++
+	oldest := engine.oldest()
+	for table, writes := range ht.writes {
+		data := ht.tables[table]
+		for _, key := range writes.order {
+			newest, exists := data.versions[key]
+			if !exists {
+				data.keys = append(data.keys, key)
+			}
+			// Versions hidden by the oldest visible one are dropped
+			if visible := newest.visible(oldest); visible != nil {
+				visible.older = nil
+			}
+			data.versions[key] = &heapVersion{row: writes.rows[key], version: engine.version, older: newest}
+		}
+		data.vacuum(oldest, writes)
+	}
+	return nil
+}
+
+// vacuum forgets keys deleted before commit number oldest once deletions
+// since the last vacuum make up a half of keys, so that keys of deleted rows
+// are not scanned forever while each commit pays for its own deletions only
+func (ht *heapTable) vacuum(oldest uint64, writes *writeSet) {
+	for _, key := range writes.order {
+		if writes.rows[key] == nil {
+			ht.dead++
+		}
+	}
+	if ht.dead*2 < len(ht.keys) {
+		return
+	}
+	keys := make([]interface{}, 0, len(ht.keys)-ht.dead)
+	for _, key := range ht.keys {
+		newest := ht.versions[key]
+		if newest.row == nil && newest.version <= oldest {
+			delete(ht.versions, key)
+			continue
+		}
+		keys = append(keys, key)
+	}
+	ht.keys = keys
+	ht.dead = 0
+}
+
+func (ht *heapTransaction) Savepoint() (int, error) {
+	if ht.done {
+		return 0, errTransactionDone
+	}
+	return ht.savepoint(), nil
+}
+
+func (ht *heapTransaction) RollbackTo(savepoint int) error {
+	if ht.done {
+		return errTransactionDone
+	}
+	return ht.rollbackTo(savepoint)
+}
+
+func (ht *heapTransaction) Rollback() error {
+	if !ht.done {
+		ht.done = true
+		ht.engine.finish(ht.version)
+	}
+	return nil
+}
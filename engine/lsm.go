@@ -0,0 +1,322 @@
+package engine
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"sort"
+
+	"github.com/VorobevPavel-dev/congenial-disco/lsm"
+	"github.com/VorobevPavel-dev/congenial-disco/parser"
+)
+
+// Keys of LSM engine. Columns of table are kept under catalog prefix and
+// name of table, rows under row prefix, name of table, zero byte and
+// encoded key of row, so rows of table are neighbours ordered by keys
+const (
+	catalogPrefix = 'c'
+	rowPrefix     = 'r'
+)
+
+// Tags of encoded keys of rows
+const (
+	intKeyTag byte = iota + 1
+	textKeyTag
+)
+
+func catalogKey(table string) []byte {
+	return append([]byte{catalogPrefix}, table...)
+}
+
+// tableKeys returns the first key of rows of table and the key after the
+// last one
+func tableKeys(table string) (start, end []byte) {
+	start = append(append([]byte{rowPrefix}, table...), 0)
+	end = append(append([]byte{rowPrefix}, table...), 1)
+	return start, end
+}
+
+// rowStorageKey encodes key of row so that encoded keys are ordered like values
+func rowStorageKey(table string, key interface{}) ([]byte, error) {
+	result, _ := tableKeys(table)
+	switch typed := key.(type) {
+	case int:
+		var encoded [8]byte
+		binary.BigEndian.PutUint64(encoded[:], uint64(typed)^1<<63)
+		return append(append(result, intKeyTag), encoded[:]...), nil
+	case string:
+		return append(append(result, textKeyTag), typed...), nil
+	}
+	return nil, fmt.Errorf("key %v of table %s must be int or text", key, table)
+}
+
+// LSMEngine is a StorageEngine keeping tables in LSM tree, which suits
+// tables with many writes
+type LSMEngine struct {
+	db *lsm.DB
+}
+
+// OpenLSMEngine opens engine stored in directory
+func OpenLSMEngine(directory string, options *lsm.Options) (*LSMEngine, error) {
+	db, err := lsm.Open(directory, options)
+	if err != nil {
+		return nil, err
+	}
+	return &LSMEngine{db: db}, nil
+}
+
+func (le *LSMEngine) CreateTable(statement *parser.CreateTableStatement) error {
+	var columns Row
+	for _, column := range statement.Cols {
+		columns = append(columns, column.Name.Value)
+	}
+	value, err := AppendRow(nil, columns)
+	if err != nil {
+		return err
+	}
+	// Commit against snapshot fails if table was created concurrently
+	snapshot := le.db.NewSnapshot()
+	defer snapshot.Release()
+	if _, ok, err := snapshot.Get(catalogKey(statement.Name.Value)); err != nil || ok {
+		if err == nil {
+			err = fmt.Errorf("table %s already exists", statement.Name.Value)
+		}
+		return err
+	}
+	batch := &lsm.Batch{}
+	batch.Put(catalogKey(statement.Name.Value), value)
+	if err := le.db.Commit(batch, snapshot); err == lsm.ErrConflict {
+		return fmt.Errorf("table %s already exists", statement.Name.Value)
+	} else if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (le *LSMEngine) Begin() (Transaction, error) {
+	return &lsmTransaction{
+		db:       le.db,
+		snapshot: le.db.NewSnapshot(),
+		batch:    &lsm.Batch{},
+		columns:  map[string][]string{},
+		changes:  changes{writes: map[string]*writeSet{}},
+	}, nil
+}
+
+func (le *LSMEngine) Close() error { return le.db.Close() }
+
+// lsmTransaction reads snapshot of database and keeps its changes in batch
+// written at once by Commit. Write sets of tables are keyed by encoded keys.
+// Every change adds one write to batch, so savepoints of changes are
+// lengths of batch too
+type lsmTransaction struct {
+	changes
+	db       *lsm.DB
+	snapshot *lsm.Snapshot
+	batch    *lsm.Batch
+	columns  map[string][]string
+	done     bool
+}
+
+func (lt *lsmTransaction) Columns(table string) ([]string, error) {
+	if lt.done {
+		return nil, errTransactionDone
+	}
+	if columns, ok := lt.columns[table]; ok {
+		return columns, nil
+	}
+	value, ok, err := lt.snapshot.Get(catalogKey(table))
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("table %s does not exist", table)
+	}
+	decoded, err := ReadRow(bytes.NewReader(value))
+	if err != nil {
+		return nil, err
+	}
+	columns := make([]string, len(decoded))
+	for index, column := range decoded {
+		columns[index] = column.(string)
+	}
+	lt.columns[table] = columns
+	return columns, nil
+}
+
+func (lt *lsmTransaction) Get(table string, key interface{}) (Row, error) {
+	if _, err := lt.Columns(table); err != nil {
+		return nil, err
+	}
+	encoded, err := rowStorageKey(table, key)
+	if err != nil {
+		return nil, err
+	}
+	if writes, ok := lt.writes[table]; ok {
+		if row, ok := writes.rows[string(encoded)]; ok {
+			return row, nil
+		}
+	}
+	value, ok, err := lt.snapshot.Get(encoded)
+	if err != nil || !ok {
+		return nil, err
+	}
+	return ReadRow(bytes.NewReader(value))
+}
+
+func (lt *lsmTransaction) Insert(table string, rows []Row) error {
+	columns, err := lt.Columns(table)
+	if err != nil {
+		return err
+	}
+	for _, row := range rows {
+		key, err := rowKey(table, columns, row)
+		if err != nil {
+			return err
+		}
+		if existing, err := lt.Get(table, key); err != nil || existing != nil {
+			if err == nil {
+				err = fmt.Errorf("duplicate key %v in table %s", key, table)
+			}
+			return err
+		}
+		encoded, _ := rowStorageKey(table, key)
+		value, err := AppendRow(nil, row)
+		if err != nil {
+			return err
+		}
+		lt.batch.Put(encoded, value)
+		lt.set(table, string(encoded), row)
+	}
+	return nil
+}
+
+func (lt *lsmTransaction) Delete(table string, key interface{}) error {
+	existing, err := lt.Get(table, key)
+	if err != nil || existing == nil {
+		return err
+	}
+	encoded, _ := rowStorageKey(table, key)
+	lt.batch.Delete(encoded)
+	lt.set(table, string(encoded), nil)
+	return nil
+}
+
+// Scan reads rows of table in order of keys
+func (lt *lsmTransaction) Scan(table string, columns []string) (Iterator, error) {
+	names, err := lt.Columns(table)
+	if err != nil {
+		return nil, err
+	}
+	indexes, err := columnIndexes(table, names, columns)
+	if err != nil {
+		return nil, err
+	}
+	start, end := tableKeys(table)
+	scanner, err := lt.snapshot.Scan(start, end)
+	if err != nil {
+		return nil, err
+	}
+	result := &lsmIterator{scanner: scanner, indexes: indexes}
+	if writes, ok := lt.writes[table]; ok {
+		result.writes = writes.rows
+		for key := range writes.rows {
+			result.keys = append(result.keys, key.(string))
+		}
+		sort.Strings(result.keys)
+	}
+	result.advance()
+	return result, nil
+}
+
+// Commit writes batch of transaction at once unless rows it changed were
+// changed after it began
+func (lt *lsmTransaction) Commit() error {
+	if lt.done {
+		return errTransactionDone
+	}
+	lt.done = true
+	defer lt.snapshot.Release()
+	if err := lt.db.Commit(lt.batch, lt.snapshot); err == lsm.ErrConflict {
+		return ErrWriteConflict
+	} else if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (lt *lsmTransaction) Savepoint() (int, error) {
+	if lt.done {
+		return 0, errTransactionDone
+	}
+	return lt.savepoint(), nil
+}
+
+func (lt *lsmTransaction) RollbackTo(savepoint int) error {
+	if lt.done {
+		return errTransactionDone
+	}
+	if err := lt.rollbackTo(savepoint); err != nil {
+		return err
+	}
+	lt.batch.Truncate(savepoint)
+	return nil
+}
+
+func (lt *lsmTransaction) Rollback() error {
+	if !lt.done {
+		lt.done = true
+		lt.snapshot.Release()
+	}
+	return nil
+}
+
+// lsmIterator merges rows of snapshot with rows changed by transaction in
+// order of encoded keys
+type lsmIterator struct {
+	scanner *lsm.Iterator
+	scanned bool
+	indexes []int
+	writes  map[interface{}]Row
+	keys    []string
+}
+
+// advance moves scanner to the next key
+func (li *lsmIterator) advance() { li.scanned = li.scanner.Next() }
+
+func (li *lsmIterator) Next() (Row, error) {
+	for {
+		var row Row
+		switch {
+		case li.scanned && (len(li.keys) == 0 || string(li.scanner.Key()) < li.keys[0]):
+			if _, changed := li.writes[string(li.scanner.Key())]; changed {
+				li.advance()
+				continue
+			}
+			decoded, err := ReadRow(bytes.NewReader(li.scanner.Value()))
+			if err != nil {
+				return nil, err
+			}
+			row = decoded
+			li.advance()
+		case len(li.keys) != 0:
+			row = li.writes[li.keys[0]]
+			if li.scanned && string(li.scanner.Key()) == li.keys[0] {
+				li.advance()
+			}
+			li.keys = li.keys[1:]
+			if row == nil {
+				continue
+			}
+		default:
+			return nil, li.scanner.Err()
+		}
+		projected := make(Row, len(li.indexes))
+		for position, index := range li.indexes {
+			projected[position] = row[index]
+		}
+		return projected, nil
+	}
+}
+
+func (li *lsmIterator) Close() error { return li.scanner.Close() }
@@ -0,0 +1,97 @@
+package engine
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/VorobevPavel-dev/congenial-disco/utility"
+)
+
+// Tags of values of encoded rows
+const (
+	nullTag byte = iota
+	intTag
+	textTag
+	trueTag
+	falseTag
+)
+
+// AppendRow encodes row with tagged values and appends it to buffer. The
+// encoding is shared by rows of storage engines and spill files of executor
+func AppendRow(buffer []byte, row Row) ([]byte, error) {
+	buffer = utility.AppendUvarint(buffer, uint64(len(row)))
+	for _, value := range row {
+		switch typed := value.(type) {
+		case nil:
+			buffer = append(buffer, nullTag)
+		case int:
+			buffer = utility.AppendVarint(append(buffer, intTag), int64(typed))
+		case string:
+			buffer = utility.AppendUvarint(append(buffer, textTag), uint64(len(typed)))
+			buffer = append(buffer, typed...)
+		case bool:
+			if typed {
+				buffer = append(buffer, trueTag)
+			} else {
+				buffer = append(buffer, falseTag)
+			}
+		default:
+			return nil, fmt.Errorf("cannot encode value %v (%T)", value, value)
+		}
+	}
+	return buffer, nil
+}
+
+// ErrCorruptedRow is returned by ReadRow when encoded row is malformed
+var ErrCorruptedRow = errors.New("encoded row is corrupted")
+
+// RowReader is a source of encoded rows
+type RowReader interface {
+	io.Reader
+	io.ByteReader
+}
+
+// ReadRow decodes row encoded by AppendRow, it returns io.EOF when there are
+// no more rows
+func ReadRow(reader RowReader) (Row, error) {
+	length, err := binary.ReadUvarint(reader)
+	if err != nil {
+		return nil, err
+	}
+	if length > 1<<20 {
+		return nil, ErrCorruptedRow
+	}
+	row := make(Row, length)
+	for index := range row {
+		tag, err := reader.ReadByte()
+		if err != nil {
+			return nil, ErrCorruptedRow
+		}
+		switch tag {
+		case nullTag:
+		case intTag:
+			value, err := binary.ReadVarint(reader)
+			if err != nil {
+				return nil, ErrCorruptedRow
+			}
+			row[index] = int(value)
+		case textTag:
+			size, err := binary.ReadUvarint(reader)
+			if err != nil {
+				return nil, ErrCorruptedRow
+			}
+			text := make([]byte, size)
+			if _, err := io.ReadFull(reader, text); err != nil {
+				return nil, ErrCorruptedRow
+			}
+			row[index] = string(text)
+		case trueTag, falseTag:
+			row[index] = tag == trueTag
+		default:
+			return nil, ErrCorruptedRow
+		}
+	}
+	return row, nil
+}
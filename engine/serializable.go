@@ -0,0 +1,204 @@
+package engine
+
+import "sync"
+
+// dependencies detects read/write dependencies among concurrent
+// serializable transactions (serializable snapshot isolation). Transaction
+// which read rows a concurrent one wrote must be serialized before it. A
+// cycle of such dependencies, which snapshot isolation allows, always has a
+// transaction with both incoming and outgoing dependency, so commits which
+// would make such a transaction are failed. This also fails some
+// serializable schedules, but never allows a non-serializable one.
+type dependencies struct {
+	mutex sync.Mutex
+	// clock orders beginnings and commits of transactions
+	clock uint64
+	// transactions are running serializable transactions and committed ones
+	// concurrent with some running transaction
+	transactions map[*serializableTransaction]bool
+}
+
+// serializableTransaction records rows read and written by transaction.
+// Scans read whole tables, so that rows inserted into them concurrently
+// are dependencies too
+type serializableTransaction struct {
+	Transaction
+	dependencies *dependencies
+	// begin and commit are times on clock of dependencies, commit is zero
+	// for running transactions
+	begin, commit uint64
+	reads         map[string]map[interface{}]bool
+	scans         map[string]bool
+	writes        map[string]map[interface{}]bool
+	// in is set once a concurrent transaction read rows this one wrote, out
+	// once this one read rows a concurrent one wrote
+	in, out bool
+}
+
+// BeginSerializable starts transaction spanning all engines like Begin
+// does. Commit of the transaction fails with ErrSerializationFailure if
+// together with other serializable transactions it might produce result
+// which no serial order of them produces. Transactions started with Begin
+// are not tracked and are not protected from such anomalies
+func (e *Engines) BeginSerializable() (Transaction, error) {
+	e.dependencies.mutex.Lock()
+	defer e.dependencies.mutex.Unlock()
+	// Transaction begins on clock before it takes snapshot, so it is
+	// concurrent with every transaction committed after its snapshot
+	e.dependencies.clock++
+	begin := e.dependencies.clock
+	transaction, err := e.Begin()
+	if err != nil {
+		return nil, err
+	}
+	result := &serializableTransaction{
+		Transaction:  transaction,
+		dependencies: &e.dependencies,
+		begin:        begin,
+		reads:        map[string]map[interface{}]bool{},
+		scans:        map[string]bool{},
+		writes:       map[string]map[interface{}]bool{},
+	}
+	e.dependencies.transactions[result] = true
+	return result, nil
+}
+
+// record adds key of table to set of keys of transaction
+func (st *serializableTransaction) record(keys map[string]map[interface{}]bool, table string, key interface{}) {
+	st.dependencies.mutex.Lock()
+	defer st.dependencies.mutex.Unlock()
+	if keys[table] == nil {
+		keys[table] = map[interface{}]bool{}
+	}
+	keys[table][key] = true
+}
+
+func (st *serializableTransaction) Scan(table string, columns []string) (Iterator, error) {
+	st.dependencies.mutex.Lock()
+	st.scans[table] = true
+	st.dependencies.mutex.Unlock()
+	return st.Transaction.Scan(table, columns)
+}
+
+func (st *serializableTransaction) Get(table string, key interface{}) (Row, error) {
+	st.record(st.reads, table, key)
+	return st.Transaction.Get(table, key)
+}
+
+func (st *serializableTransaction) Insert(table string, rows []Row) error {
+	for _, row := range rows {
+		if len(row) != 0 {
+			st.record(st.writes, table, row[0])
+		}
+	}
+	return st.Transaction.Insert(table, rows)
+}
+
+func (st *serializableTransaction) Delete(table string, key interface{}) error {
+	st.record(st.writes, table, key)
+	return st.Transaction.Delete(table, key)
+}
+
+// readsWritesOf checks if transaction read any of rows written by other
+// one
+func (st *serializableTransaction) readsWritesOf(other *serializableTransaction) bool {
+	for table, keys := range other.writes {
+		if st.scans[table] {
+			return true
+		}
+		for key := range keys {
+			if st.reads[table][key] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Commit fails if transaction would get both incoming and outgoing
+// dependencies or would give the second one to a committed transaction
+func (st *serializableTransaction) Commit() error {
+	d := st.dependencies
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if !d.transactions[st] {
+		return errTransactionDone
+	}
+	var readers, writers []*serializableTransaction
+	in, out := st.in, st.out
+	for other := range d.transactions {
+		if other == st || other.commit != 0 && other.commit < st.begin {
+			continue
+		}
+		if other.readsWritesOf(st) {
+			readers = append(readers, other)
+			in = true
+			if other.commit != 0 && other.in {
+				return st.abort()
+			}
+		}
+		if st.readsWritesOf(other) {
+			writers = append(writers, other)
+			out = true
+			if other.commit != 0 && other.out {
+				return st.abort()
+			}
+		}
+	}
+	if in && out {
+		return st.abort()
+	}
+	if err := st.Transaction.Commit(); err != nil {
+		d.forget(st)
+		return err
+	}
+	st.in, st.out = in, out
+	for _, reader := range readers {
+		reader.out = true
+	}
+	for _, writer := range writers {
+		writer.in = true
+	}
+	d.clock++
+	st.commit = d.clock
+	d.vacuum()
+	return nil
+}
+
+// abort rolls transaction back on serialization failure
+func (st *serializableTransaction) abort() error {
+	st.Transaction.Rollback()
+	st.dependencies.forget(st)
+	return ErrSerializationFailure
+}
+
+func (st *serializableTransaction) Rollback() error {
+	st.dependencies.mutex.Lock()
+	defer st.dependencies.mutex.Unlock()
+	if st.commit == 0 {
+		st.dependencies.forget(st)
+	}
+	return st.Transaction.Rollback()
+}
+
+// forget removes transaction which did not commit
+func (d *dependencies) forget(transaction *serializableTransaction) {
+	delete(d.transactions, transaction)
+	d.vacuum()
+}
+
+// vacuum forgets committed transactions which are not concurrent with any
+// running one
+func (d *dependencies) vacuum() {
+	oldest := d.clock + 1
+	for transaction := range d.transactions {
+		if transaction.commit == 0 && transaction.begin < oldest {
+			oldest = transaction.begin
+		}
+	}
+	for transaction := range d.transactions {
+		if transaction.commit != 0 && transaction.commit < oldest {
+			delete(d.transactions, transaction)
+		}
+	}
+}
@@ -0,0 +1,319 @@
+package function
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/VorobevPavel-dev/congenial-disco/tokenizer"
+)
+
+const (
+	intType  = tokenizer.IntType
+	textType = tokenizer.TextType
+)
+
+// dateLayouts are formats of dates stored in text columns
+var dateLayouts = []string{"2006-01-02 15:04:05", "2006-01-02"}
+
+var (
+	// Builtin is a registry of all built-in functions
+	Builtin = newBuiltinRegistry()
+	// now returns current time, it is replaced in tests
+	now = time.Now
+)
+
+func newBuiltinRegistry() *Registry {
+	registry := NewRegistry()
+	for _, function := range builtinFunctions() {
+		if err := registry.Register(function); err != nil {
+			panic(err)
+		}
+	}
+	return registry
+}
+
+func signature(result string, arguments ...string) *Signature {
+	return &Signature{Arguments: arguments, Result: result}
+}
+
+func builtinFunctions() []*Function {
+	return []*Function{
+		// String functions
+		{Name: "upper", Signatures: []*Signature{signature(textType, textType)}, Call: strict(upper)},
+		{Name: "lower", Signatures: []*Signature{signature(textType, textType)}, Call: strict(lower)},
+		{Name: "length", Signatures: []*Signature{signature(intType, textType)}, Call: strict(length)},
+		{
+			Name:       "substr",
+			Signatures: []*Signature{signature(textType, textType, intType), signature(textType, textType, intType, intType)},
+			Call:       strict(substr),
+		},
+		{
+			Name:       "trim",
+			Signatures: []*Signature{signature(textType, textType), signature(textType, textType, textType)},
+			Call:       strict(trim),
+		},
+		{Name: "replace", Signatures: []*Signature{signature(textType, textType, textType, textType)}, Call: strict(replace)},
+
+		// Numeric functions
+		{Name: "abs", Signatures: []*Signature{signature(intType, intType)}, Call: strict(abs)},
+		{Name: "round", Signatures: []*Signature{signature(intType, intType), signature(intType, intType, intType)}, Call: strict(round)},
+		{Name: "floor", Signatures: []*Signature{signature(intType, intType)}, Call: strict(identity)},
+		{Name: "ceil", Signatures: []*Signature{signature(intType, intType)}, Call: strict(identity)},
+		{Name: "mod", Signatures: []*Signature{signature(intType, intType, intType)}, Call: strict(mod)},
+
+		// NULL handling
+		{
+			Name:       "coalesce",
+			Signatures: []*Signature{{Arguments: []string{AnyType}, Variadic: true, Result: AnyType}},
+			Call:       coalesce,
+		},
+		{Name: "nullif", Signatures: []*Signature{signature(AnyType, AnyType, AnyType)}, Call: nullif},
+
+		// CAST(x AS type) is called with type name as the second argument, so
+		// type of the second argument is the type of result
+		{
+			Name: "cast",
+			Signatures: []*Signature{
+				signature(intType, intType, intType),
+				signature(textType, intType, textType),
+				signature(intType, textType, intType),
+				signature(textType, textType, textType),
+			},
+			Call: strict(cast),
+		},
+
+		// Date functions, dates are stored as text in "YYYY-MM-DD[ hh:mm:ss]"
+		// format
+		{Name: "now", Signatures: []*Signature{signature(textType)}, Call: currentTime, Volatile: true},
+		{Name: "date", Signatures: []*Signature{signature(textType, textType)}, Call: strict(date)},
+		{Name: "year", Signatures: []*Signature{signature(intType, textType)}, Call: strict(datePart(year))},
+		{Name: "month", Signatures: []*Signature{signature(intType, textType)}, Call: strict(datePart(month))},
+		{Name: "day", Signatures: []*Signature{signature(intType, textType)}, Call: strict(datePart(day))},
+		{Name: "date_add", Signatures: []*Signature{signature(textType, textType, intType)}, Call: strict(dateAdd)},
+		{Name: "date_diff", Signatures: []*Signature{signature(intType, textType, textType)}, Call: strict(dateDiff)},
+
+		// Aggregate functions
+		{Name: "count", Kind: AggregateFunction, Signatures: []*Signature{signature(intType, AnyType)}},
+		{Name: "sum", Kind: AggregateFunction, Signatures: []*Signature{signature(intType, intType)}},
+		{Name: "avg", Kind: AggregateFunction, Signatures: []*Signature{signature(intType, intType)}},
+		{Name: "min", Kind: AggregateFunction, Signatures: []*Signature{signature(AnyType, AnyType)}},
+		{Name: "max", Kind: AggregateFunction, Signatures: []*Signature{signature(AnyType, AnyType)}},
+
+		// Window functions
+		{Name: "row_number", Kind: WindowFunction, Signatures: []*Signature{signature(intType)}},
+		{Name: "rank", Kind: WindowFunction, Signatures: []*Signature{signature(intType)}},
+		{Name: "dense_rank", Kind: WindowFunction, Signatures: []*Signature{signature(intType)}},
+		{Name: "lag", Kind: WindowFunction, Signatures: offsetSignatures()},
+		{Name: "lead", Kind: WindowFunction, Signatures: offsetSignatures()},
+		{Name: "first_value", Kind: WindowFunction, Signatures: []*Signature{signature(AnyType, AnyType)}},
+	}
+}
+
+// offsetSignatures are signatures of LAG and LEAD: (value [, offset [, default]])
+func offsetSignatures() []*Signature {
+	return []*Signature{
+		signature(AnyType, AnyType),
+		signature(AnyType, AnyType, intType),
+		{Arguments: []string{AnyType, intType, AnyType}, Result: AnyType},
+	}
+}
+
+// strict wraps function, so that it returns NULL if any of arguments is NULL
+func strict(function func([]interface{}) (interface{}, error)) func([]interface{}) (interface{}, error) {
+	return func(arguments []interface{}) (interface{}, error) {
+		for _, argument := range arguments {
+			if argument == nil {
+				return nil, nil
+			}
+		}
+		return function(arguments)
+	}
+}
+
+func upper(arguments []interface{}) (interface{}, error) {
+	return strings.ToUpper(arguments[0].(string)), nil
+}
+
+func lower(arguments []interface{}) (interface{}, error) {
+	return strings.ToLower(arguments[0].(string)), nil
+}
+
+func length(arguments []interface{}) (interface{}, error) {
+	return utf8.RuneCountInString(arguments[0].(string)), nil
+}
+
+// substr returns part of string starting from 1-based position. Parts of
+// requested range outside of the string are ignored
+func substr(arguments []interface{}) (interface{}, error) {
+	value := []rune(arguments[0].(string))
+	start := arguments[1].(int)
+	end := len(value) + 1
+	if len(arguments) == 3 {
+		count := arguments[2].(int)
+		if count < 0 {
+			return nil, fmt.Errorf("negative substring length is not allowed")
+		}
+		if start+count < end {
+			end = start + count
+		}
+	}
+	if start < 1 {
+		start = 1
+	}
+	if start >= end {
+		return "", nil
+	}
+	return string(value[start-1 : end-1]), nil
+}
+
+func trim(arguments []interface{}) (interface{}, error) {
+	characters := " "
+	if len(arguments) == 2 {
+		characters = arguments[1].(string)
+	}
+	return strings.Trim(arguments[0].(string), characters), nil
+}
+
+func replace(arguments []interface{}) (interface{}, error) {
+	if arguments[1].(string) == "" {
+		return arguments[0], nil
+	}
+	return strings.ReplaceAll(arguments[0].(string), arguments[1].(string), arguments[2].(string)), nil
+}
+
+func abs(arguments []interface{}) (interface{}, error) {
+	if value := arguments[0].(int); value < 0 {
+		return -value, nil
+	}
+	return arguments[0], nil
+}
+
+// round rounds integer to given number of digits. Only negative number of
+// digits makes sense for integers: round(1250, -2) = 1300
+func round(arguments []interface{}) (interface{}, error) {
+	value := arguments[0].(int)
+	if len(arguments) == 1 || arguments[1].(int) >= 0 {
+		return value, nil
+	}
+	divisor := 1
+	for digits := arguments[1].(int); digits < 0; digits++ {
+		divisor *= 10
+	}
+	remainder := value % divisor
+	value -= remainder
+	// Halves are rounded away from zero
+	switch {
+	case remainder*2 >= divisor:
+		value += divisor
+	case remainder*2 <= -divisor:
+		value -= divisor
+	}
+	return value, nil
+}
+
+func identity(arguments []interface{}) (interface{}, error) {
+	return arguments[0], nil
+}
+
+func mod(arguments []interface{}) (interface{}, error) {
+	if arguments[1].(int) == 0 {
+		return nil, fmt.Errorf("division by zero")
+	}
+	return arguments[0].(int) % arguments[1].(int), nil
+}
+
+func coalesce(arguments []interface{}) (interface{}, error) {
+	for _, argument := range arguments {
+		if argument != nil {
+			return argument, nil
+		}
+	}
+	return nil, nil
+}
+
+func nullif(arguments []interface{}) (interface{}, error) {
+	if arguments[0] == arguments[1] {
+		return nil, nil
+	}
+	return arguments[0], nil
+}
+
+func cast(arguments []interface{}) (interface{}, error) {
+	switch value := arguments[0].(type) {
+	case int:
+		if arguments[1] == textType {
+			return strconv.Itoa(value), nil
+		}
+	case string:
+		if arguments[1] == intType {
+			result, err := strconv.Atoi(strings.TrimSpace(value))
+			if err != nil {
+				return nil, fmt.Errorf("cannot cast %q to %s", value, intType)
+			}
+			return result, nil
+		}
+	}
+	return arguments[0], nil
+}
+
+func currentTime(arguments []interface{}) (interface{}, error) {
+	return now().UTC().Format(dateLayouts[0]), nil
+}
+
+// parseDate parses date in one of supported layouts and returns it together
+// with the layout
+func parseDate(value string) (time.Time, string, error) {
+	for _, layout := range dateLayouts {
+		if result, err := time.Parse(layout, value); err == nil {
+			return result, layout, nil
+		}
+	}
+	return time.Time{}, "", fmt.Errorf("invalid date: %q", value)
+}
+
+func date(arguments []interface{}) (interface{}, error) {
+	value, _, err := parseDate(arguments[0].(string))
+	if err != nil {
+		return nil, err
+	}
+	return value.Format(dateLayouts[1]), nil
+}
+
+func year(value time.Time) int  { return value.Year() }
+func month(value time.Time) int { return int(value.Month()) }
+func day(value time.Time) int   { return value.Day() }
+
+func datePart(part func(time.Time) int) func([]interface{}) (interface{}, error) {
+	return func(arguments []interface{}) (interface{}, error) {
+		value, _, err := parseDate(arguments[0].(string))
+		if err != nil {
+			return nil, err
+		}
+		return part(value), nil
+	}
+}
+
+// dateAdd adds given number of days to date keeping its format
+func dateAdd(arguments []interface{}) (interface{}, error) {
+	value, layout, err := parseDate(arguments[0].(string))
+	if err != nil {
+		return nil, err
+	}
+	return value.AddDate(0, 0, arguments[1].(int)).Format(layout), nil
+}
+
+// dateDiff returns number of whole days from the first date to the second one
+func dateDiff(arguments []interface{}) (interface{}, error) {
+	from, _, err := parseDate(arguments[0].(string))
+	if err != nil {
+		return nil, err
+	}
+	to, _, err := parseDate(arguments[1].(string))
+	if err != nil {
+		return nil, err
+	}
+	return int(to.Sub(from).Hours() / 24), nil
+}
@@ -0,0 +1,165 @@
+package function
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/VorobevPavel-dev/congenial-disco/utility"
+)
+
+const (
+	// ScalarFunction will correspond to functions computed for every row
+	ScalarFunction Kind = iota
+	// AggregateFunction will correspond to functions computed over groups of
+	// rows (they can also be used as window functions)
+	AggregateFunction
+	// WindowFunction will correspond to functions which can be used only with
+	// OVER clause
+	WindowFunction
+)
+
+type Kind uint
+
+// AnyType matches argument of any type. All arguments of signature marked
+// with AnyType must have the same type, which is also a type of result if
+// result is marked with AnyType
+const AnyType string = "any"
+
+// Signature describes types of arguments accepted by function and type of its
+// result. Types are the ones of tokenizer (tokenizer.IntType,
+// tokenizer.TextType) or AnyType.
+type Signature struct {
+	Arguments []string
+	// Variadic signature accepts one or more arguments of the last type
+	Variadic bool
+	Result   string
+}
+
+// match checks if given argument types fit signature and returns result type
+func (s *Signature) match(types []string) (string, bool) {
+	if s.Variadic {
+		if len(types) < len(s.Arguments) {
+			return "", false
+		}
+	} else if len(types) != len(s.Arguments) {
+		return "", false
+	}
+	bound := AnyType
+	for index, actual := range types {
+		expected := s.Arguments[len(s.Arguments)-1]
+		if index < len(s.Arguments) {
+			expected = s.Arguments[index]
+		}
+		// Argument of unknown type (like "*" of count(*)) fits everything
+		if actual == AnyType {
+			continue
+		}
+		if expected == AnyType {
+			if bound != AnyType && bound != actual {
+				return "", false
+			}
+			bound = actual
+			continue
+		}
+		if expected != actual {
+			return "", false
+		}
+	}
+	if s.Result == AnyType {
+		return bound, true
+	}
+	return s.Result, true
+}
+
+// acceptsCount checks if signature can be called with given number of
+// arguments
+func (s *Signature) acceptsCount(count int) bool {
+	if s.Variadic {
+		return count >= len(s.Arguments)
+	}
+	return count == len(s.Arguments)
+}
+
+type Function struct {
+	Name       string
+	Kind       Kind
+	Signatures []*Signature
+	// Call evaluates scalar function. Arguments and result are int, string
+	// or nil for NULL. It is nil for aggregate and window functions, which
+	// are evaluated by the executor.
+	Call func(arguments []interface{}) (interface{}, error)
+	// Volatile is set for functions which may return different results for
+	// the same arguments (like now), so their calls can not be evaluated in
+	// advance
+	Volatile bool
+}
+
+// CheckArity checks if function can be called with given number of arguments
+func (f *Function) CheckArity(count int) error {
+	var counts []string
+	for _, signature := range f.Signatures {
+		if signature.acceptsCount(count) {
+			return nil
+		}
+		expected := fmt.Sprint(len(signature.Arguments))
+		if signature.Variadic {
+			expected += " or more"
+		}
+		if !utility.StringIsIn(expected, counts) {
+			counts = append(counts, expected)
+		}
+	}
+	return fmt.Errorf("function %s expects %s arguments, got: %d",
+		f.Name, strings.Join(counts, " or "), count)
+}
+
+// ResultType checks that given argument types match one of function
+// signatures and returns type of the result
+func (f *Function) ResultType(types []string) (string, error) {
+	if err := f.CheckArity(len(types)); err != nil {
+		return "", err
+	}
+	for _, signature := range f.Signatures {
+		if result, ok := signature.match(types); ok {
+			return result, nil
+		}
+	}
+	return "", fmt.Errorf("function %s can not be called with arguments of types (%s)",
+		f.Name, strings.Join(types, ", "))
+}
+
+// Registry is a set of functions available in requests. Function names are
+// case insensitive.
+type Registry struct {
+	functions map[string]*Function
+}
+
+func NewRegistry() *Registry {
+	return &Registry{functions: make(map[string]*Function)}
+}
+
+// Register adds function to registry. Returns error if function with the
+// same name is already registered
+func (r *Registry) Register(function *Function) error {
+	name := strings.ToLower(function.Name)
+	if _, ok := r.functions[name]; ok {
+		return fmt.Errorf("function %s is already registered", name)
+	}
+	if len(function.Signatures) == 0 {
+		return fmt.Errorf("function %s has no signatures", name)
+	}
+	if function.Kind == ScalarFunction && function.Call == nil {
+		return fmt.Errorf("scalar function %s has no implementation", name)
+	}
+	r.functions[name] = function
+	return nil
+}
+
+// Lookup returns registered function by its name
+func (r *Registry) Lookup(name string) (*Function, error) {
+	function, ok := r.functions[strings.ToLower(name)]
+	if !ok {
+		return nil, fmt.Errorf("function %s does not exist", name)
+	}
+	return function, nil
+}
@@ -0,0 +1,243 @@
+package function
+
+import (
+	"testing"
+	"time"
+
+	"github.com/VorobevPavel-dev/congenial-disco/tokenizer"
+)
+
+func TestRegistry(t *testing.T) {
+	t.Run("Test function lookup", func(t *testing.T) {
+		for _, name := range []string{"upper", "UPPER", "Coalesce", "row_number"} {
+			if _, err := Builtin.Lookup(name); err != nil {
+				t.Errorf("Function %s was not found: %v", name, err)
+			}
+		}
+		if function, err := Builtin.Lookup("unknown"); err == nil {
+			t.Errorf("Expected error on unknown function, got: %v", function)
+		}
+	})
+	t.Run("Test invalid registration", func(t *testing.T) {
+		registry := NewRegistry()
+		valid := &Function{Name: "f", Signatures: []*Signature{signature(intType)}, Call: identity}
+		functions := []*Function{
+			{Name: "g", Signatures: []*Signature{signature(intType)}},
+			{Name: "h", Call: identity},
+			{Name: "F", Signatures: []*Signature{signature(intType)}, Call: identity},
+		}
+		if err := registry.Register(valid); err != nil {
+			t.Fatalf("Registration failed: %v", err)
+		}
+		for index, function := range functions {
+			if err := registry.Register(function); err == nil {
+				t.Errorf("Expected error on function #%d", index)
+			}
+		}
+	})
+	t.Run("Test result type resolution", func(t *testing.T) {
+		inputs := []struct {
+			name     string
+			types    []string
+			expected string
+		}{
+			{"upper", []string{textType}, textType},
+			{"substr", []string{textType, intType}, textType},
+			{"substr", []string{textType, intType, intType}, textType},
+			{"coalesce", []string{intType, intType, intType}, intType},
+			{"nullif", []string{textType, textType}, textType},
+			{"cast", []string{textType, intType}, intType},
+			{"count", []string{AnyType}, intType},
+			{"max", []string{textType}, textType},
+			{"lag", []string{textType, intType, textType}, textType},
+		}
+		for index, input := range inputs {
+			function, _ := Builtin.Lookup(input.name)
+			actual, err := function.ResultType(input.types)
+			if err != nil {
+				t.Errorf("Resolution failed on set #%d: %v", index, err)
+				continue
+			}
+			if actual != input.expected {
+				t.Errorf("Unexpected result type on set #%d: expected: %s, got: %s",
+					index, input.expected, actual)
+			}
+		}
+	})
+	t.Run("Test invalid argument types", func(t *testing.T) {
+		inputs := []struct {
+			name  string
+			types []string
+		}{
+			{"upper", []string{intType}},
+			{"upper", []string{textType, textType}},
+			{"coalesce", nil},
+			{"coalesce", []string{intType, textType}},
+			{"mod", []string{intType}},
+			{"row_number", []string{intType}},
+		}
+		for index, input := range inputs {
+			function, _ := Builtin.Lookup(input.name)
+			if actual, err := function.ResultType(input.types); err == nil {
+				t.Errorf("Expected error on set #%d, got: %s", index, actual)
+			}
+		}
+	})
+}
+
+func TestBuiltinFunctions(t *testing.T) {
+	now = func() time.Time {
+		return time.Date(2021, 10, 3, 12, 30, 0, 0, time.UTC)
+	}
+	defer func() { now = time.Now }()
+
+	t.Run("Test function calls", func(t *testing.T) {
+		inputs := []struct {
+			name      string
+			arguments []interface{}
+			expected  interface{}
+		}{
+			{"upper", []interface{}{"Disco"}, "DISCO"},
+			{"lower", []interface{}{"Disco"}, "disco"},
+			{"length", []interface{}{"диско"}, 5},
+			{"substr", []interface{}{"congenial", 4}, "genial"},
+			{"substr", []interface{}{"congenial", 0, 4}, "con"},
+			{"substr", []interface{}{"congenial", 20, 4}, ""},
+			{"trim", []interface{}{"  disco "}, "disco"},
+			{"trim", []interface{}{"xxdiscox", "x"}, "disco"},
+			{"replace", []interface{}{"disco disco", "co", "k"}, "disk disk"},
+			{"upper", []interface{}{nil}, nil},
+			{"abs", []interface{}{-5}, 5},
+			{"round", []interface{}{1250, -2}, 1300},
+			{"round", []interface{}{-1249, -2}, -1200},
+			{"round", []interface{}{-1250, -2}, -1300},
+			{"round", []interface{}{17}, 17},
+			{"floor", []interface{}{7}, 7},
+			{"ceil", []interface{}{7}, 7},
+			{"mod", []interface{}{-7, 3}, -1},
+			{"coalesce", []interface{}{nil, nil, 3}, 3},
+			{"coalesce", []interface{}{nil}, nil},
+			{"nullif", []interface{}{1, 1}, nil},
+			{"nullif", []interface{}{1, 2}, 1},
+			{"cast", []interface{}{" 42", tokenizer.IntType}, 42},
+			{"cast", []interface{}{42, tokenizer.TextType}, "42"},
+			{"now", nil, "2021-10-03 12:30:00"},
+			{"date", []interface{}{"2021-10-03 12:30:00"}, "2021-10-03"},
+			{"year", []interface{}{"2021-10-03"}, 2021},
+			{"month", []interface{}{"2021-10-03"}, 10},
+			{"day", []interface{}{"2021-10-03 12:30:00"}, 3},
+			{"date_add", []interface{}{"2021-12-30", 3}, "2022-01-02"},
+			{"date_diff", []interface{}{"2021-12-30", "2022-01-02 10:00:00"}, 3},
+		}
+		for index, input := range inputs {
+			function, err := Builtin.Lookup(input.name)
+			if err != nil {
+				t.Errorf("Lookup failed on set #%d: %v", index, err)
+				continue
+			}
+			actual, err := function.Call(input.arguments)
+			if err != nil {
+				t.Errorf("Call failed on set #%d: %v", index, err)
+				continue
+			}
+			if actual != input.expected {
+				t.Errorf("Unexpected result on set #%d (%s): expected: %v, got: %v",
+					index, input.name, input.expected, actual)
+			}
+		}
+	})
+	t.Run("Test invalid function calls", func(t *testing.T) {
+		inputs := []struct {
+			name      string
+			arguments []interface{}
+		}{
+			{"mod", []interface{}{1, 0}},
+			{"substr", []interface{}{"disco", 1, -1}},
+			{"cast", []interface{}{"disco", tokenizer.IntType}},
+			{"year", []interface{}{"yesterday"}},
+		}
+		for index, input := range inputs {
+			function, _ := Builtin.Lookup(input.name)
+			if actual, err := function.Call(input.arguments); err == nil {
+				t.Errorf("Expected error on set #%d, got: %v", index, actual)
+			}
+		}
+	})
+}
+
+func TestLike(t *testing.T) {
+	t.Run("Test pattern matching", func(t *testing.T) {
+		inputs := []struct {
+			value, pattern, escape string
+			ignoreCase, expected   bool
+		}{
+			{"disco", "disco", "", false, true},
+			{"disco", "d%", "", false, true},
+			{"disco", "%sc%", "", false, true},
+			{"disco", "d_s_o", "", false, true},
+			{"disco", "d_s", "", false, false},
+			{"disco", "%o%o", "", false, false},
+			{"", "%", "", false, true},
+			{"", "_", "", false, false},
+			{"aXbXc", "a%b%c", "", false, true},
+			{"abcabd", "%abd", "", false, true},
+			{"100%", "100!%", "!", false, true},
+			{"1000", "100!%", "!", false, false},
+			{"a_b", "a\\_b", "\\", false, true},
+			{"DISCO", "d%o", "", false, false},
+			{"DISCO", "d%o", "", true, true},
+			{"диско", "д_ско", "", false, true},
+		}
+		for index, input := range inputs {
+			actual, err := Like(input.value, input.pattern, input.escape, input.ignoreCase)
+			if err != nil {
+				t.Errorf("Matching failed on set #%d: %v", index, err)
+				continue
+			}
+			if actual != input.expected {
+				t.Errorf("Unexpected result on set #%d: %q LIKE %q is %v",
+					index, input.value, input.pattern, actual)
+			}
+		}
+	})
+	t.Run("Test invalid patterns", func(t *testing.T) {
+		if _, err := Like("a", "a!", "!", false); err == nil {
+			t.Errorf("Expected error on pattern ending with escape character")
+		}
+		if _, err := Like("a", "a", "!!", false); err == nil {
+			t.Errorf("Expected error on long escape string")
+		}
+	})
+	t.Run("Test pattern prefix", func(t *testing.T) {
+		inputs := []struct {
+			pattern, escape, prefix string
+			exact                   bool
+		}{
+			{"abc%", "", "abc", false},
+			{"ab_c", "", "ab", false},
+			{"%abc", "", "", false},
+			{"abc", "", "abc", true},
+			{"a!%b%", "!", "a%b", false},
+		}
+		for index, input := range inputs {
+			prefix, exact, err := LikePrefix(input.pattern, input.escape)
+			if err != nil {
+				t.Errorf("Prefix extraction failed on set #%d: %v", index, err)
+				continue
+			}
+			if prefix != input.prefix || exact != input.exact {
+				t.Errorf("Unexpected prefix on set #%d: %q (exact: %v)", index, prefix, exact)
+			}
+		}
+	})
+	t.Run("Test prefix upper bound", func(t *testing.T) {
+		inputs := []string{"abc", "ab\U0010FFFF", "", "\U0010FFFF"}
+		expected := []string{"abd", "ac", "", ""}
+		for index := range inputs {
+			actual, ok := PrefixUpperBound(inputs[index])
+			if ok != (expected[index] != "") || actual != expected[index] {
+				t.Errorf("Unexpected bound on set #%d: %q", index, actual)
+			}
+		}
+	})
+}
@@ -0,0 +1,145 @@
+package function
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// AnySequenceWildcard matches any sequence of characters in LIKE pattern
+	AnySequenceWildcard = '%'
+	// AnyCharacterWildcard matches any single character in LIKE pattern
+	AnyCharacterWildcard = '_'
+)
+
+const (
+	surrogateMin = 0xD800
+	surrogateMax = 0xDFFF
+)
+
+const (
+	literalPatternItem patternItemKind = iota
+	anyCharacterPatternItem
+	anySequencePatternItem
+)
+
+type patternItemKind uint
+
+type patternItem struct {
+	kind      patternItemKind
+	character rune
+}
+
+// compilePattern splits LIKE pattern into literal characters and wildcards.
+// Character following escape character is always literal
+func compilePattern(pattern string, escape string) ([]patternItem, error) {
+	var escapeCharacter rune = -1
+	if escape != "" {
+		if utf8.RuneCountInString(escape) != 1 {
+			return nil, fmt.Errorf("escape string must be a single character, got: %q", escape)
+		}
+		escapeCharacter, _ = utf8.DecodeRuneInString(escape)
+	}
+	var (
+		items   []patternItem
+		escaped bool
+	)
+	for _, character := range pattern {
+		switch {
+		case escaped:
+			items = append(items, patternItem{kind: literalPatternItem, character: character})
+			escaped = false
+		case character == escapeCharacter:
+			escaped = true
+		case character == AnySequenceWildcard:
+			items = append(items, patternItem{kind: anySequencePatternItem})
+		case character == AnyCharacterWildcard:
+			items = append(items, patternItem{kind: anyCharacterPatternItem})
+		default:
+			items = append(items, patternItem{kind: literalPatternItem, character: character})
+		}
+	}
+	if escaped {
+		return nil, fmt.Errorf("LIKE pattern must not end with escape character: %q", pattern)
+	}
+	return items, nil
+}
+
+// Like checks if value matches LIKE pattern. Escape may be empty if pattern
+// has no escape character. ILIKE is Like with ignoreCase set.
+func Like(value string, pattern string, escape string, ignoreCase bool) (bool, error) {
+	if ignoreCase {
+		value = strings.ToLower(value)
+		pattern = strings.ToLower(pattern)
+		escape = strings.ToLower(escape)
+	}
+	items, err := compilePattern(pattern, escape)
+	if err != nil {
+		return false, err
+	}
+	characters := []rune(value)
+
+	// Greedy matching which returns to the last "%" on mismatch
+	var (
+		item, character         int
+		starItem, starCharacter = -1, 0
+	)
+	for character < len(characters) {
+		switch {
+		case item < len(items) && items[item].kind == anySequencePatternItem:
+			starItem, starCharacter = item, character
+			item++
+		case item < len(items) && (items[item].kind == anyCharacterPatternItem ||
+			items[item].character == characters[character]):
+			item++
+			character++
+		case starItem != -1:
+			starCharacter++
+			item, character = starItem+1, starCharacter
+		default:
+			return false, nil
+		}
+	}
+	for item < len(items) && items[item].kind == anySequencePatternItem {
+		item++
+	}
+	return item == len(items), nil
+}
+
+// LikePrefix returns literal prefix shared by all values matching pattern.
+// exact is set if pattern has no wildcards at all. It is used to replace
+// prefix LIKE ('abc%') with range scan.
+func LikePrefix(pattern string, escape string) (prefix string, exact bool, err error) {
+	items, err := compilePattern(pattern, escape)
+	if err != nil {
+		return "", false, err
+	}
+	var builder strings.Builder
+	for _, item := range items {
+		if item.kind != literalPatternItem {
+			return builder.String(), false, nil
+		}
+		builder.WriteRune(item.character)
+	}
+	return builder.String(), true, nil
+}
+
+// PrefixUpperBound returns the smallest string which is greater than every
+// string starting with prefix, so values with prefix are in [prefix, bound).
+// Returns false if there is no such string (prefix is empty or consists of
+// maximal characters only).
+func PrefixUpperBound(prefix string) (string, bool) {
+	characters := []rune(prefix)
+	for index := len(characters) - 1; index >= 0; index-- {
+		if characters[index] < utf8.MaxRune {
+			characters[index]++
+			// Surrogate halves are not valid characters
+			if characters[index] == surrogateMin {
+				characters[index] = surrogateMax + 1
+			}
+			return string(characters[:index+1]), true
+		}
+	}
+	return "", false
+}
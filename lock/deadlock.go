@@ -0,0 +1,139 @@
+package lock
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// WaitsFor returns waits-for graph of transactions: every waiting transaction
+// is mapped to transactions it waits for. Waiting request waits for holders of
+// incompatible locks and, since requests are granted in FIFO order, for all
+// requests queued before it.
+func (m *Manager) WaitsFor() map[TransactionID][]TransactionID {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.waitsFor()
+}
+
+func (m *Manager) waitsFor() map[TransactionID][]TransactionID {
+	graph := make(map[TransactionID][]TransactionID)
+	for _, state := range m.locks {
+		for index, req := range state.queue {
+			for holder, mode := range state.granted {
+				if holder != req.transaction && !mode.Compatible(req.mode) {
+					graph[req.transaction] = append(graph[req.transaction], holder)
+				}
+			}
+			for _, queued := range state.queue[:index] {
+				if queued.transaction != req.transaction {
+					graph[req.transaction] = append(graph[req.transaction], queued.transaction)
+				}
+			}
+		}
+	}
+	return graph
+}
+
+// DetectDeadlocks looks for cycles in waits-for graph and breaks each of them
+// by aborting the youngest transaction of the cycle. Aborted transactions get
+// ErrDeadlock from Lock. Returns aborted transactions.
+func (m *Manager) DetectDeadlocks() []TransactionID {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	var victims []TransactionID
+	for {
+		cycle := findCycle(m.waitsFor())
+		if cycle == nil {
+			return victims
+		}
+		victim := cycle[0]
+		for _, transaction := range cycle {
+			if transaction > victim {
+				victim = transaction
+			}
+		}
+		req := m.waiting[victim]
+		m.dequeue(req)
+		req.done <- ErrDeadlock
+		victims = append(victims, victim)
+	}
+}
+
+// DetectDeadlocksEvery runs DetectDeadlocks periodically until returned
+// function is called
+func (m *Manager) DetectDeadlocksEvery(interval time.Duration) (stop func()) {
+	var (
+		ticker = time.NewTicker(interval)
+		done   = make(chan struct{})
+		once   sync.Once
+	)
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				m.DetectDeadlocks()
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		once.Do(func() {
+			ticker.Stop()
+			close(done)
+		})
+	}
+}
+
+// findCycle returns transactions of any cycle of graph or nil if graph is
+// acyclic
+func findCycle(graph map[TransactionID][]TransactionID) []TransactionID {
+	const (
+		unvisited = iota
+		inProgress
+		visited
+	)
+	var (
+		state = make(map[TransactionID]int)
+		path  []TransactionID
+		visit func(transaction TransactionID) []TransactionID
+	)
+	visit = func(transaction TransactionID) []TransactionID {
+		state[transaction] = inProgress
+		path = append(path, transaction)
+		for _, next := range graph[transaction] {
+			switch state[next] {
+			case inProgress:
+				// Cycle is the part of path starting from next
+				for index := range path {
+					if path[index] == next {
+						return append([]TransactionID(nil), path[index:]...)
+					}
+				}
+			case unvisited:
+				if cycle := visit(next); cycle != nil {
+					return cycle
+				}
+			}
+		}
+		path = path[:len(path)-1]
+		state[transaction] = visited
+		return nil
+	}
+
+	// Traversal order is fixed to make choice of victims deterministic
+	transactions := make([]TransactionID, 0, len(graph))
+	for transaction := range graph {
+		transactions = append(transactions, transaction)
+	}
+	sort.Slice(transactions, func(i, j int) bool { return transactions[i] < transactions[j] })
+	for _, transaction := range transactions {
+		if state[transaction] == unvisited {
+			if cycle := visit(transaction); cycle != nil {
+				return cycle
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,214 @@
+package lock
+
+import (
+	"testing"
+	"time"
+)
+
+// waitForQueue waits until transaction is blocked on some lock
+func waitForQueue(t *testing.T, manager *Manager, transaction TransactionID) {
+	t.Helper()
+	for attempt := 0; attempt < 1000; attempt++ {
+		manager.mutex.Lock()
+		_, ok := manager.waiting[transaction]
+		manager.mutex.Unlock()
+		if ok {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("transaction %d is not waiting for lock", transaction)
+}
+
+func TestModes(t *testing.T) {
+	t.Run("Test mode compatibility", func(t *testing.T) {
+		compatible := [][2]Mode{
+			{IntentionShared, IntentionExclusive},
+			{IntentionShared, SharedIntentionExclusive},
+			{IntentionExclusive, IntentionExclusive},
+			{Shared, Shared},
+			{Shared, IntentionShared},
+		}
+		incompatible := [][2]Mode{
+			{IntentionShared, Exclusive},
+			{IntentionExclusive, Shared},
+			{Shared, SharedIntentionExclusive},
+			{SharedIntentionExclusive, SharedIntentionExclusive},
+			{Exclusive, IntentionShared},
+		}
+		for _, pair := range compatible {
+			if !pair[0].Compatible(pair[1]) || !pair[1].Compatible(pair[0]) {
+				t.Errorf("Expected %s and %s to be compatible", pair[0], pair[1])
+			}
+		}
+		for _, pair := range incompatible {
+			if pair[0].Compatible(pair[1]) || pair[1].Compatible(pair[0]) {
+				t.Errorf("Expected %s and %s to be incompatible", pair[0], pair[1])
+			}
+		}
+	})
+	t.Run("Test mode combination", func(t *testing.T) {
+		inputs := [][2]Mode{
+			{IntentionShared, Shared},
+			{Shared, IntentionExclusive},
+			{IntentionExclusive, SharedIntentionExclusive},
+			{Shared, Exclusive},
+			{Exclusive, IntentionShared},
+		}
+		expectedOutputs := []Mode{Shared, SharedIntentionExclusive, SharedIntentionExclusive, Exclusive, Exclusive}
+		for testCase := range inputs {
+			actualResult := inputs[testCase][0].Combine(inputs[testCase][1])
+			if actualResult != expectedOutputs[testCase] {
+				t.Errorf("Assertion failed on set #%d. Expected: %s, got: %s",
+					testCase, expectedOutputs[testCase], actualResult)
+			}
+		}
+	})
+}
+
+func TestManager(t *testing.T) {
+	t.Run("Test row lock takes intention lock on table", func(t *testing.T) {
+		manager := NewManager()
+		if err := manager.Lock(1, Row("test", "1"), Exclusive, 0); err != nil {
+			t.Fatalf("Lock failed: %v", err)
+		}
+		if mode, ok := manager.Held(1, Table("test")); !ok || mode != IntentionExclusive {
+			t.Errorf("Expected IX lock on table, got: %s (%v)", mode, ok)
+		}
+		// Other rows are still available
+		if err := manager.Lock(2, Row("test", "2"), Exclusive, time.Millisecond); err != nil {
+			t.Errorf("Lock of another row failed: %v", err)
+		}
+		if err := manager.Lock(3, Table("test"), Shared, time.Millisecond); err != ErrLockTimeout {
+			t.Errorf("Expected lock timeout, got: %v", err)
+		}
+		if err := manager.Lock(1, Table("test"), IntentionShared, 0); err != nil {
+			t.Errorf("Lock covered by held one failed: %v", err)
+		}
+		if err := manager.Lock(1, Row("test", "1"), IntentionShared, 0); err == nil {
+			t.Errorf("Expected error on intention lock of row")
+		}
+	})
+	t.Run("Test waiting request is granted on release", func(t *testing.T) {
+		manager := NewManager()
+		if err := manager.Lock(1, Table("test"), Exclusive, 0); err != nil {
+			t.Fatalf("Lock failed: %v", err)
+		}
+		result := make(chan error)
+		go func() { result <- manager.Lock(2, Table("test"), Shared, 0) }()
+		waitForQueue(t, manager, 2)
+		manager.Release(1)
+		if err := <-result; err != nil {
+			t.Errorf("Lock failed: %v", err)
+		}
+		if _, ok := manager.Held(1, Table("test")); ok {
+			t.Errorf("Lock was not released")
+		}
+	})
+	t.Run("Test requests are granted in FIFO order", func(t *testing.T) {
+		manager := NewManager()
+		if err := manager.Lock(1, Table("test"), Shared, 0); err != nil {
+			t.Fatalf("Lock failed: %v", err)
+		}
+		result := make(chan error)
+		go func() { result <- manager.Lock(2, Table("test"), Exclusive, 0) }()
+		waitForQueue(t, manager, 2)
+		// Shared request is compatible with held lock, but must not overtake
+		// the exclusive one
+		if err := manager.Lock(3, Table("test"), Shared, time.Millisecond); err != ErrLockTimeout {
+			t.Errorf("Expected lock timeout, got: %v", err)
+		}
+		manager.Release(1)
+		if err := <-result; err != nil {
+			t.Errorf("Lock failed: %v", err)
+		}
+	})
+	t.Run("Test lock upgrade", func(t *testing.T) {
+		manager := NewManager()
+		for _, transaction := range []TransactionID{1, 2} {
+			if err := manager.Lock(transaction, Table("test"), Shared, 0); err != nil {
+				t.Fatalf("Lock failed: %v", err)
+			}
+		}
+		result := make(chan error)
+		go func() { result <- manager.Lock(1, Table("test"), IntentionExclusive, 0) }()
+		waitForQueue(t, manager, 1)
+		manager.Release(2)
+		if err := <-result; err != nil {
+			t.Errorf("Lock failed: %v", err)
+		}
+		if mode, _ := manager.Held(1, Table("test")); mode != SharedIntentionExclusive {
+			t.Errorf("Expected SIX lock, got: %s", mode)
+		}
+	})
+	t.Run("Test deadlock detection aborts the youngest transaction", func(t *testing.T) {
+		manager := NewManager()
+		for _, transaction := range []TransactionID{1, 2, 3} {
+			if err := manager.Lock(transaction, Row("test", string(rune('a'+transaction))), Exclusive, 0); err != nil {
+				t.Fatalf("Lock failed: %v", err)
+			}
+		}
+		// 1 waits for 2, 2 waits for 3, 3 waits for 1
+		results := make(map[TransactionID]chan error)
+		for _, transaction := range []TransactionID{1, 2, 3} {
+			next := transaction%3 + 1
+			results[transaction] = make(chan error, 1)
+			go func(transaction TransactionID) {
+				results[transaction] <- manager.Lock(transaction, Row("test", string(rune('a'+next))), Exclusive, 0)
+			}(transaction)
+			waitForQueue(t, manager, transaction)
+		}
+		if victims := manager.DetectDeadlocks(); len(victims) != 1 || victims[0] != 3 {
+			t.Fatalf("Expected transaction 3 to be aborted, got: %v", victims)
+		}
+		if err := <-results[3]; err != ErrDeadlock {
+			t.Errorf("Expected deadlock error, got: %v", err)
+		}
+		manager.Release(3)
+		if err := <-results[2]; err != nil {
+			t.Errorf("Lock failed: %v", err)
+		}
+		manager.Release(2)
+		if err := <-results[1]; err != nil {
+			t.Errorf("Lock failed: %v", err)
+		}
+		if graph := manager.WaitsFor(); len(graph) != 0 {
+			t.Errorf("Expected empty waits-for graph, got: %v", graph)
+		}
+	})
+	t.Run("Test periodic deadlock detection", func(t *testing.T) {
+		manager := NewManager()
+		stop := manager.DetectDeadlocksEvery(time.Millisecond)
+		defer stop()
+		for _, transaction := range []TransactionID{1, 2} {
+			if err := manager.Lock(transaction, Table("test"), Shared, 0); err != nil {
+				t.Fatalf("Lock failed: %v", err)
+			}
+		}
+		// Both transactions try to upgrade their locks
+		results := make(chan error, 2)
+		for _, transaction := range []TransactionID{1, 2} {
+			go func(transaction TransactionID) {
+				err := manager.Lock(transaction, Table("test"), Exclusive, 0)
+				if err == ErrDeadlock {
+					manager.Release(transaction)
+				}
+				results <- err
+			}(transaction)
+		}
+		var deadlocks int
+		for index := 0; index < 2; index++ {
+			if err := <-results; err == ErrDeadlock {
+				deadlocks++
+			} else if err != nil {
+				t.Errorf("Lock failed: %v", err)
+			}
+		}
+		if deadlocks != 1 {
+			t.Errorf("Expected exactly one transaction to be aborted, got: %d", deadlocks)
+		}
+		if mode, _ := manager.Held(1, Table("test")); mode != Exclusive {
+			t.Errorf("Expected older transaction to get X lock, got: %s", mode)
+		}
+	})
+}
@@ -0,0 +1,227 @@
+package lock
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrDeadlock is returned to the transaction chosen as a victim of
+	// deadlock. The transaction must be rolled back.
+	ErrDeadlock = errors.New("deadlock detected")
+	// ErrLockTimeout is returned if lock was not granted in time
+	ErrLockTimeout = errors.New("lock wait timeout exceeded")
+)
+
+// TransactionID identifies transaction holding locks. Identifiers are
+// expected to grow with the start time of transactions, so the greatest one
+// belongs to the youngest transaction.
+type TransactionID uint64
+
+// Resource is either a whole table or a single row of table
+type Resource struct {
+	Table string
+	// Row is a key of row, empty for table locks
+	Row string
+}
+
+// Table returns resource of the whole table
+func Table(name string) Resource {
+	return Resource{Table: name}
+}
+
+// Row returns resource of a single row of table
+func Row(table string, key string) Resource {
+	return Resource{Table: table, Row: key}
+}
+
+// IsRow checks if resource is a row of table
+func (r Resource) IsRow() bool {
+	return r.Row != ""
+}
+
+func (r Resource) String() string {
+	if r.IsRow() {
+		return fmt.Sprintf("%s[%s]", r.Table, r.Row)
+	}
+	return r.Table
+}
+
+type request struct {
+	transaction TransactionID
+	resource    Resource
+	mode        Mode
+	// done receives result of request, it is buffered so that granting a
+	// lock never blocks
+	done chan error
+}
+
+type lockState struct {
+	granted map[TransactionID]Mode
+	// queue holds waiting requests in order they must be granted
+	queue []*request
+}
+
+// Manager grants table and row locks to transactions. Locks are held until
+// transaction releases all of them at once with Release (strict two-phase
+// locking). Waiting requests of one resource are granted in FIFO order, so
+// exclusive requests are not starved by a stream of shared ones.
+type Manager struct {
+	mutex sync.Mutex
+	locks map[Resource]*lockState
+	// waiting maps transaction to the only request it is blocked on
+	waiting map[TransactionID]*request
+	// held lists resources locked by transaction
+	held map[TransactionID][]Resource
+}
+
+func NewManager() *Manager {
+	return &Manager{
+		locks:   make(map[Resource]*lockState),
+		waiting: make(map[TransactionID]*request),
+		held:    make(map[TransactionID][]Resource),
+	}
+}
+
+// Lock blocks until transaction holds lock of given mode on resource. Before
+// locking a row, intention lock on its table is taken. Timeout limits time
+// spent waiting for every of the locks, zero timeout means waiting forever.
+// ErrDeadlock and ErrLockTimeout do not release locks already held, it is up
+// to caller to roll transaction back and call Release.
+func (m *Manager) Lock(transaction TransactionID, resource Resource, mode Mode, timeout time.Duration) error {
+	if resource.IsRow() {
+		if mode == IntentionShared || mode == IntentionExclusive || mode == SharedIntentionExclusive {
+			return fmt.Errorf("intention mode %s is not applicable to row %s", mode, resource)
+		}
+		if err := m.lock(transaction, Table(resource.Table), mode.intention(), timeout); err != nil {
+			return err
+		}
+	}
+	return m.lock(transaction, resource, mode, timeout)
+}
+
+func (m *Manager) lock(transaction TransactionID, resource Resource, mode Mode, timeout time.Duration) error {
+	m.mutex.Lock()
+	state, ok := m.locks[resource]
+	if !ok {
+		state = &lockState{granted: make(map[TransactionID]Mode)}
+		m.locks[resource] = state
+	}
+	held, upgrade := state.granted[transaction]
+	if upgrade {
+		if held.Covers(mode) {
+			m.mutex.Unlock()
+			return nil
+		}
+		mode = held.Combine(mode)
+	}
+	req := &request{transaction: transaction, resource: resource, mode: mode, done: make(chan error, 1)}
+	// Upgrades are not queued behind other requests, since those would wait
+	// for the lock already held by transaction anyway
+	if (upgrade || len(state.queue) == 0) && state.grantable(req) {
+		m.grant(state, req)
+		m.mutex.Unlock()
+		return nil
+	}
+	if upgrade {
+		state.queue = append([]*request{req}, state.queue...)
+	} else {
+		state.queue = append(state.queue, req)
+	}
+	m.waiting[transaction] = req
+	m.mutex.Unlock()
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	select {
+	case err := <-req.done:
+		return err
+	case <-expired:
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	// Request could be granted or aborted while mutex was not held
+	if m.waiting[transaction] != req {
+		return <-req.done
+	}
+	m.dequeue(req)
+	return ErrLockTimeout
+}
+
+// Release releases all locks of transaction and grants waiting requests which
+// became compatible
+func (m *Manager) Release(transaction TransactionID) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for _, resource := range m.held[transaction] {
+		state := m.locks[resource]
+		delete(state.granted, transaction)
+		m.wake(resource, state)
+	}
+	delete(m.held, transaction)
+}
+
+// Held returns mode of lock transaction holds on resource
+func (m *Manager) Held(transaction TransactionID, resource Resource) (Mode, bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	state, ok := m.locks[resource]
+	if !ok {
+		return 0, false
+	}
+	mode, ok := state.granted[transaction]
+	return mode, ok
+}
+
+// grantable checks if request is compatible with locks of other transactions
+func (ls *lockState) grantable(req *request) bool {
+	for holder, mode := range ls.granted {
+		if holder != req.transaction && !mode.Compatible(req.mode) {
+			return false
+		}
+	}
+	return true
+}
+
+func (m *Manager) grant(state *lockState, req *request) {
+	if _, ok := state.granted[req.transaction]; !ok {
+		m.held[req.transaction] = append(m.held[req.transaction], req.resource)
+	}
+	state.granted[req.transaction] = req.mode
+	req.done <- nil
+}
+
+// wake grants requests from the head of the queue until the first one which
+// is not compatible with granted locks
+func (m *Manager) wake(resource Resource, state *lockState) {
+	for len(state.queue) != 0 && state.grantable(state.queue[0]) {
+		req := state.queue[0]
+		state.queue = state.queue[1:]
+		delete(m.waiting, req.transaction)
+		m.grant(state, req)
+	}
+	if len(state.granted) == 0 && len(state.queue) == 0 {
+		delete(m.locks, resource)
+	}
+}
+
+// dequeue removes waiting request and grants the ones which were blocked by
+// it
+func (m *Manager) dequeue(req *request) {
+	delete(m.waiting, req.transaction)
+	state := m.locks[req.resource]
+	for index, queued := range state.queue {
+		if queued == req {
+			state.queue = append(state.queue[:index], state.queue[index+1:]...)
+			break
+		}
+	}
+	m.wake(req.resource, state)
+}
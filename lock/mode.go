@@ -0,0 +1,81 @@
+package lock
+
+const (
+	// IntentionShared is taken on a table before shared locks on its rows
+	IntentionShared Mode = iota
+	// IntentionExclusive is taken on a table before exclusive locks on its
+	// rows
+	IntentionExclusive
+	// Shared allows concurrent reads, but no writes
+	Shared
+	// SharedIntentionExclusive is Shared on a table combined with
+	// IntentionExclusive, i.e. table is read as a whole and some of its rows
+	// are updated
+	SharedIntentionExclusive
+	// Exclusive allows neither reads nor writes of other transactions
+	Exclusive
+)
+
+// Mode defines which locks of other transactions can be held on the same
+// resource at the same time
+type Mode uint
+
+var (
+	modeNames = []string{"IS", "IX", "S", "SIX", "X"}
+	// compatibility[held][requested] is set if modes can be held by two
+	// different transactions at the same time
+	compatibility = [][]bool{
+		{true, true, true, true, false},
+		{true, true, false, false, false},
+		{true, false, true, false, false},
+		{true, false, false, false, false},
+		{false, false, false, false, false},
+	}
+)
+
+func (m Mode) String() string {
+	if int(m) >= len(modeNames) {
+		return "unknown"
+	}
+	return modeNames[m]
+}
+
+// Compatible checks if lock of given mode can be granted to one transaction
+// while other transaction holds lock of mode m on the same resource
+func (m Mode) Compatible(other Mode) bool {
+	return compatibility[m][other]
+}
+
+// Covers checks if holder of lock of mode m has all the rights of mode other
+func (m Mode) Covers(other Mode) bool {
+	switch {
+	case m == other, m == Exclusive, other == IntentionShared:
+		return true
+	case m == SharedIntentionExclusive:
+		return other == IntentionExclusive || other == Shared
+	}
+	return false
+}
+
+// Combine returns the weakest mode covering both m and other. It is used
+// when transaction requests a lock on resource it has already locked.
+func (m Mode) Combine(other Mode) Mode {
+	switch {
+	case m.Covers(other):
+		return m
+	case other.Covers(m):
+		return other
+	}
+	// The only modes not covering each other are Shared and
+	// IntentionExclusive
+	return SharedIntentionExclusive
+}
+
+// intention returns mode of table lock which must be held before locking a
+// row of the table in mode m
+func (m Mode) intention() Mode {
+	if m == Shared || m == IntentionShared {
+		return IntentionShared
+	}
+	return IntentionExclusive
+}
@@ -0,0 +1,64 @@
+package lsm
+
+import "hash/fnv"
+
+// bloom is a bloom filter of keys of table. The last byte is a number of
+// hashes of every key, the rest are bits of filter
+type bloom []byte
+
+func bloomHash(key []byte) uint64 {
+	hash := fnv.New64a()
+	hash.Write(key)
+	return hash.Sum64()
+}
+
+// newBloom builds filter using about bitsPerKey bits for every key, which
+// gives about 1% of false positives for 10 bits
+func newBloom(hashes []uint64, bitsPerKey int) bloom {
+	// Optimal number of hashes is ln(2) of bits per key
+	count := bitsPerKey * 69 / 100
+	if count < 1 {
+		count = 1
+	}
+	if count > 30 {
+		count = 30
+	}
+	bits := len(hashes) * bitsPerKey
+	if bits < 64 {
+		bits = 64
+	}
+	size := (bits + 7) / 8
+	bits = size * 8
+	result := make(bloom, size+1)
+	for _, hash := range hashes {
+		// Hashes are derived from one hash by double hashing
+		delta := hash>>33 | hash<<31
+		for index := 0; index < count; index++ {
+			position := hash % uint64(bits)
+			result[position/8] |= 1 << (position % 8)
+			hash += delta
+		}
+	}
+	result[size] = byte(count)
+	return result
+}
+
+// mayContain checks if key can be in the set of filter. False result means
+// key is not there for sure
+func (b bloom) mayContain(key []byte) bool {
+	if len(b) < 2 {
+		return true
+	}
+	size, count := len(b)-1, int(b[len(b)-1])
+	bits := uint64(size * 8)
+	hash := bloomHash(key)
+	delta := hash>>33 | hash<<31
+	for index := 0; index < count; index++ {
+		position := hash % bits
+		if b[position/8]&(1<<(position%8)) == 0 {
+			return false
+		}
+		hash += delta
+	}
+	return true
+}
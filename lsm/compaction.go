@@ -0,0 +1,302 @@
+package lsm
+
+import (
+	"bytes"
+	"os"
+)
+
+// maybeFlush writes memtable to a table of level 0 when it is full and
+// compacts levels which grew too big
+func (db *DB) maybeFlush() error {
+	if db.memtable.size < db.options.MemtableSize {
+		return nil
+	}
+	if err := db.flush(); err != nil {
+		return err
+	}
+	return db.compact()
+}
+
+// flush writes memtable to a table of level 0 and starts a new log. Writes
+// of the old log are in the table once the manifest is saved, so the old log
+// is removed after that
+func (db *DB) flush() error {
+	if db.memtable.empty() {
+		return nil
+	}
+	iterator := db.memtable.iterator(nil)
+	outputs, err := db.writeTables(iterator, 0, false)
+	iterator.close()
+	if err != nil {
+		return err
+	}
+	logNumber := db.manifest.NextFile
+	db.manifest.NextFile++
+	log, err := openLog(logPath(db.directory, logNumber), db.options.SyncWrites)
+	if err != nil {
+		db.abandonTables(outputs)
+		return err
+	}
+	updated := db.manifest
+	updated.Levels = copyLevels(db.manifest.Levels)
+	updated.Levels[0] = append(updated.Levels[0], outputs...)
+	updated.Log = logNumber
+	updated.LastSequence = db.sequence
+	if err := saveManifest(db.directory, updated); err != nil {
+		log.close()
+		os.Remove(logPath(db.directory, logNumber))
+		db.abandonTables(outputs)
+		return err
+	}
+	previous := db.manifest.Log
+	db.manifest = updated
+	db.log.close()
+	db.log = log
+	os.Remove(logPath(db.directory, previous))
+	db.memtable = newMemtable()
+	return nil
+}
+
+// levelLimit returns the size of level which triggers its compaction
+func (db *DB) levelLimit(level int) int64 {
+	limit := db.options.LevelSize
+	for ; level > 1; level-- {
+		limit *= int64(db.options.LevelMultiplier)
+	}
+	return limit
+}
+
+// pickCompaction chooses tables to merge into the next level: all tables of
+// level 0 when there are too many of them, otherwise the next table after
+// the previous compaction of the first level exceeding its size
+func (db *DB) pickCompaction() (int, []*fileMeta) {
+	if len(db.manifest.Levels[0]) >= db.options.Level0Files {
+		return 0, db.manifest.Levels[0]
+	}
+	for level := 1; level < maxLevels-1; level++ {
+		var size int64
+		for _, file := range db.manifest.Levels[level] {
+			size += file.Size
+		}
+		if size <= db.levelLimit(level) {
+			continue
+		}
+		files := db.manifest.Levels[level]
+		chosen := files[0]
+		for _, file := range files {
+			if db.pointers[level] == nil || bytes.Compare(file.Smallest, db.pointers[level]) > 0 {
+				chosen = file
+				break
+			}
+		}
+		db.pointers[level] = chosen.Largest
+		return level, []*fileMeta{chosen}
+	}
+	return -1, nil
+}
+
+// keyRange returns the smallest and the largest keys of tables
+func keyRange(files []*fileMeta) (smallest, largest []byte) {
+	for _, file := range files {
+		if smallest == nil || bytes.Compare(file.Smallest, smallest) < 0 {
+			smallest = file.Smallest
+		}
+		if largest == nil || bytes.Compare(file.Largest, largest) > 0 {
+			largest = file.Largest
+		}
+	}
+	return smallest, largest
+}
+
+// overlapping returns tables of level with keys from smallest to largest
+func (db *DB) overlapping(level int, smallest, largest []byte) []*fileMeta {
+	var result []*fileMeta
+	for _, file := range db.manifest.Levels[level] {
+		if bytes.Compare(file.Largest, smallest) >= 0 && bytes.Compare(file.Smallest, largest) <= 0 {
+			result = append(result, file)
+		}
+	}
+	return result
+}
+
+// compact merges tables into deeper levels until all levels fit into their
+// limits
+func (db *DB) compact() error {
+	for {
+		level, inputs := db.pickCompaction()
+		if level < 0 {
+			return nil
+		}
+		smallest, largest := keyRange(inputs)
+		overlaps := db.overlapping(level+1, smallest, largest)
+		if level > 0 && len(overlaps) == 0 {
+			// Table which does not overlap tables of the next level is
+			// moved there without rewriting
+			if err := db.install(level, inputs, nil, inputs); err != nil {
+				return err
+			}
+			continue
+		}
+		var children []internalIterator
+		for _, file := range append(append([]*fileMeta{}, inputs...), overlaps...) {
+			children = append(children, db.tables[file.Number].iterator(nil))
+		}
+		merged := newMergingIterator(children)
+		outputs, err := db.writeTables(merged, level+1, true)
+		merged.close()
+		if err != nil {
+			return err
+		}
+		if err := db.install(level, inputs, overlaps, outputs); err != nil {
+			db.abandonTables(outputs)
+			return err
+		}
+		for _, file := range append(append([]*fileMeta{}, inputs...), overlaps...) {
+			db.tables[file.Number].unref()
+			delete(db.tables, file.Number)
+			os.Remove(tablePath(db.directory, file.Number))
+		}
+	}
+}
+
+// install replaces inputs of level and overlaps of the next level by
+// outputs in the next level and saves manifest
+func (db *DB) install(level int, inputs, overlaps, outputs []*fileMeta) error {
+	removed := map[uint64]bool{}
+	for _, file := range append(append([]*fileMeta{}, inputs...), overlaps...) {
+		removed[file.Number] = true
+	}
+	updated := db.manifest
+	updated.Levels = copyLevels(db.manifest.Levels)
+	for _, index := range []int{level, level + 1} {
+		var kept []*fileMeta
+		for _, file := range updated.Levels[index] {
+			if !removed[file.Number] {
+				kept = append(kept, file)
+			}
+		}
+		updated.Levels[index] = kept
+	}
+	updated.Levels[level+1] = append(updated.Levels[level+1], outputs...)
+	sortFiles(updated.Levels[level+1])
+	if err := saveManifest(db.directory, updated); err != nil {
+		return err
+	}
+	db.manifest = updated
+	return nil
+}
+
+func copyLevels(levels [][]*fileMeta) [][]*fileMeta {
+	result := make([][]*fileMeta, len(levels))
+	for index, level := range levels {
+		result[index] = append([]*fileMeta{}, level...)
+	}
+	return result
+}
+
+// abandonTables removes tables which were not installed
+func (db *DB) abandonTables(files []*fileMeta) {
+	for _, file := range files {
+		if reader, ok := db.tables[file.Number]; ok {
+			reader.unref()
+			delete(db.tables, file.Number)
+		}
+		os.Remove(tablePath(db.directory, file.Number))
+	}
+}
+
+// isBaseLevel checks that no level deeper than level holds key, so its
+// tombstone is not needed
+func (db *DB) isBaseLevel(key []byte, level int) bool {
+	for deeper := level + 1; deeper < maxLevels; deeper++ {
+		if len(db.overlapping(deeper, key, key)) != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// writeTables writes entries of input to tables for level. Compaction drops
+// versions hidden by newer versions visible to all snapshots and tombstones
+// of keys which are not in deeper levels. It splits output into tables of
+// TableSize keeping all versions of key in one table. Flush writes one
+// table with all entries. Written tables are opened
+func (db *DB) writeTables(input internalIterator, level int, compaction bool) ([]*fileMeta, error) {
+	var (
+		outputs []*fileMeta
+		writer  *tableWriter
+		number  uint64
+		last    []byte
+		covered bool
+	)
+	oldest := db.oldestSnapshot()
+	finish := func() error {
+		if err := writer.finish(); err != nil {
+			return err
+		}
+		outputs = append(outputs, &fileMeta{
+			Number:   number,
+			Size:     writer.size(),
+			Smallest: writer.smallest,
+			Largest:  writer.largest,
+		})
+		writer = nil
+		return nil
+	}
+	fail := func(err error) ([]*fileMeta, error) {
+		if writer != nil {
+			writer.abandon()
+		}
+		db.abandonTables(outputs)
+		return nil, err
+	}
+	for ; input.valid(); input.next() {
+		current := input.entry()
+		newKey := !bytes.Equal(current.key, last)
+		if newKey {
+			last, covered = append(last[:0], current.key...), false
+		}
+		if compaction {
+			if covered {
+				continue
+			}
+			if current.sequence <= oldest {
+				// All snapshots see this version, older ones are hidden
+				covered = true
+				if current.kind == deleteKind && db.isBaseLevel(current.key, level) {
+					continue
+				}
+			}
+			if newKey && writer != nil && writer.size() >= db.options.TableSize {
+				if err := finish(); err != nil {
+					return fail(err)
+				}
+			}
+		}
+		if writer == nil {
+			number = db.manifest.NextFile
+			db.manifest.NextFile++
+			var err error
+			if writer, err = newTableWriter(tablePath(db.directory, number), db.options); err != nil {
+				return fail(err)
+			}
+		}
+		if err := writer.add(current); err != nil {
+			return fail(err)
+		}
+	}
+	if err := input.err(); err != nil {
+		return fail(err)
+	}
+	if writer != nil {
+		if err := finish(); err != nil {
+			return fail(err)
+		}
+	}
+	for _, output := range outputs {
+		if err := db.openTable(output); err != nil {
+			return fail(err)
+		}
+	}
+	return outputs, nil
+}
@@ -0,0 +1,455 @@
+// Package lsm implements a log-structured merge tree. Writes go to the
+// write-ahead log and to memtable, which is flushed to an immutable sorted
+// table when it grows big. Tables are organized in levels and merged into
+// deeper levels by compaction, so writes never update data in place.
+package lsm
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// maxSequence is greater than sequence number of any entry
+const maxSequence = math.MaxUint64
+
+// maxLevels is a number of levels of tables
+const maxLevels = 7
+
+var (
+	// ErrClosed is returned by operations of closed database
+	ErrClosed = errors.New("database is closed")
+	// ErrConflict is returned by Commit when keys of batch were written
+	// after its snapshot
+	ErrConflict = errors.New("keys were changed by concurrent write")
+)
+
+// Options configure database. Zero fields take default values
+type Options struct {
+	// MemtableSize is a size of memtable in bytes which triggers flush
+	MemtableSize int
+	// BlockSize is a size of data block of table in bytes
+	BlockSize int
+	// BloomBitsPerKey is a number of bits of bloom filter for every key
+	BloomBitsPerKey int
+	// Level0Files is a number of tables of level 0 which triggers its
+	// compaction
+	Level0Files int
+	// LevelSize is a size of level 1 in bytes, every next level is
+	// LevelMultiplier times bigger
+	LevelSize       int64
+	LevelMultiplier int
+	// TableSize is a size of tables written by compaction
+	TableSize int64
+	// SyncWrites syncs the write-ahead log after every write
+	SyncWrites bool
+}
+
+func (o *Options) withDefaults() *Options {
+	result := Options{}
+	if o != nil {
+		result = *o
+	}
+	if result.MemtableSize <= 0 {
+		result.MemtableSize = 4 << 20
+	}
+	if result.BlockSize <= 0 {
+		result.BlockSize = 4 << 10
+	}
+	if result.BloomBitsPerKey <= 0 {
+		result.BloomBitsPerKey = 10
+	}
+	if result.Level0Files <= 0 {
+		result.Level0Files = 4
+	}
+	if result.LevelSize <= 0 {
+		result.LevelSize = 10 << 20
+	}
+	if result.LevelMultiplier <= 1 {
+		result.LevelMultiplier = 10
+	}
+	if result.TableSize <= 0 {
+		result.TableSize = 2 << 20
+	}
+	return &result
+}
+
+// Stats describes state of database
+type Stats struct {
+	// Files is a number of tables of every level
+	Files []int
+	// FilteredReads is a number of tables skipped by reads of single key
+	// because of bloom filters
+	FilteredReads int64
+}
+
+// DB is a key-value store in directory. It is safe for concurrent use
+type DB struct {
+	directory string
+	options   *Options
+
+	mutex     sync.Mutex
+	closed    bool
+	memtable  *memtable
+	log       *logWriter
+	manifest  manifest
+	tables    map[uint64]*tableReader
+	sequence  uint64
+	snapshots map[*Snapshot]struct{}
+	// pointers are the largest keys compacted at every level, so that
+	// compactions go round through the level
+	pointers [maxLevels][]byte
+
+	filtered int64
+}
+
+// Open opens database in directory creating it when it does not exist
+func Open(directory string, options *Options) (*DB, error) {
+	if err := os.MkdirAll(directory, 0755); err != nil {
+		return nil, err
+	}
+	db := &DB{
+		directory: directory,
+		options:   options.withDefaults(),
+		memtable:  newMemtable(),
+		tables:    map[uint64]*tableReader{},
+		snapshots: map[*Snapshot]struct{}{},
+	}
+	loaded, ok, err := loadManifest(directory)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		loaded = manifest{NextFile: 2, Log: 1, Levels: make([][]*fileMeta, maxLevels)}
+	}
+	db.manifest, db.sequence = loaded, loaded.LastSequence
+	for _, level := range loaded.Levels {
+		for _, file := range level {
+			if err := db.openTable(file); err != nil {
+				db.releaseTables()
+				return nil, err
+			}
+		}
+	}
+	err = replayLog(logPath(directory, loaded.Log), func(entries []*entry) {
+		for _, replayed := range entries {
+			db.memtable.add(replayed)
+			if replayed.sequence > db.sequence {
+				db.sequence = replayed.sequence
+			}
+		}
+	})
+	if err == nil {
+		db.log, err = openLog(logPath(directory, loaded.Log), db.options.SyncWrites)
+	}
+	if err == nil && !ok {
+		err = saveManifest(directory, db.manifest)
+	}
+	if err != nil {
+		db.releaseTables()
+		return nil, err
+	}
+	db.removeObsolete()
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+	if err := db.maybeFlush(); err != nil {
+		db.closeLocked()
+		return nil, err
+	}
+	return db, nil
+}
+
+func (db *DB) openTable(file *fileMeta) error {
+	reader, err := openTable(tablePath(db.directory, file.Number), file.Number)
+	if err != nil {
+		return err
+	}
+	reader.smallest, reader.largest = file.Smallest, file.Largest
+	db.tables[file.Number] = reader
+	return nil
+}
+
+// removeObsolete removes tables and logs left by interrupted flushes and
+// compactions
+func (db *DB) removeObsolete() {
+	names, err := os.ReadDir(db.directory)
+	if err != nil {
+		return
+	}
+	for _, name := range names {
+		extension := filepath.Ext(name.Name())
+		number, err := strconv.ParseUint(strings.TrimSuffix(name.Name(), extension), 10, 64)
+		if err != nil {
+			continue
+		}
+		_, live := db.tables[number]
+		if (extension == ".sst" && !live) || (extension == ".log" && number != db.manifest.Log) {
+			os.Remove(filepath.Join(db.directory, name.Name()))
+		}
+	}
+}
+
+func (db *DB) releaseTables() {
+	for _, reader := range db.tables {
+		reader.unref()
+	}
+	db.tables = map[uint64]*tableReader{}
+}
+
+// Close closes database. Memtable is not flushed, it is restored from the
+// log when database is opened again
+func (db *DB) Close() error {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+	if db.closed {
+		return ErrClosed
+	}
+	return db.closeLocked()
+}
+
+func (db *DB) closeLocked() error {
+	db.closed = true
+	db.releaseTables()
+	return db.log.close()
+}
+
+// Put sets value of key
+func (db *DB) Put(key, value []byte) error {
+	batch := &Batch{}
+	batch.Put(key, value)
+	return db.Write(batch)
+}
+
+// Delete removes key
+func (db *DB) Delete(key []byte) error {
+	batch := &Batch{}
+	batch.Delete(key)
+	return db.Write(batch)
+}
+
+// Write applies batch atomically
+func (db *DB) Write(batch *Batch) error {
+	return db.write(batch, nil)
+}
+
+// Commit applies batch if none of its keys were written after snapshot was
+// taken, otherwise it returns ErrConflict
+func (db *DB) Commit(batch *Batch, snapshot *Snapshot) error {
+	return db.write(batch, snapshot)
+}
+
+func (db *DB) write(batch *Batch, snapshot *Snapshot) error {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+	if db.closed {
+		return ErrClosed
+	}
+	if batch.Len() == 0 {
+		return nil
+	}
+	if snapshot != nil {
+		memtable, readers := db.candidates()
+		for _, written := range batch.entries {
+			latest, err := db.lookup(memtable, readers, written.key, maxSequence)
+			if err != nil {
+				releaseReaders(readers)
+				return err
+			}
+			if latest != nil && latest.sequence > snapshot.sequence {
+				releaseReaders(readers)
+				return ErrConflict
+			}
+		}
+		releaseReaders(readers)
+	}
+	entries := make([]*entry, len(batch.entries))
+	for index, written := range batch.entries {
+		copied := *written
+		copied.sequence = db.sequence + 1 + uint64(index)
+		entries[index] = &copied
+	}
+	if err := db.log.append(entries[0].sequence, entries); err != nil {
+		return err
+	}
+	for _, added := range entries {
+		db.memtable.add(added)
+	}
+	db.sequence += uint64(len(entries))
+	return db.maybeFlush()
+}
+
+// candidates returns memtable and tables which may hold key in order from
+// the newest. Tables are referenced and must be released by caller
+func (db *DB) candidates() (*memtable, []*tableReader) {
+	var readers []*tableReader
+	levelZero := db.manifest.Levels[0]
+	for index := len(levelZero) - 1; index >= 0; index-- {
+		readers = append(readers, db.tables[levelZero[index].Number])
+	}
+	for _, level := range db.manifest.Levels[1:] {
+		for _, file := range level {
+			readers = append(readers, db.tables[file.Number])
+		}
+	}
+	for _, reader := range readers {
+		reader.ref()
+	}
+	return db.memtable, readers
+}
+
+func releaseReaders(readers []*tableReader) {
+	for _, reader := range readers {
+		reader.unref()
+	}
+}
+
+// lookup returns the newest version of key not newer than sequence
+func (db *DB) lookup(memtable *memtable, readers []*tableReader, key []byte, sequence uint64) (*entry, error) {
+	if found, err := findVersion(memtable.iterator(key), key, sequence); found != nil || err != nil {
+		return found, err
+	}
+	for _, reader := range readers {
+		if bytes.Compare(key, reader.smallest) < 0 || bytes.Compare(key, reader.largest) > 0 {
+			continue
+		}
+		if !reader.filter.mayContain(key) {
+			atomic.AddInt64(&db.filtered, 1)
+			continue
+		}
+		found, err := findVersion(reader.iterator(key), key, sequence)
+		if found != nil || err != nil {
+			return found, err
+		}
+	}
+	return nil, nil
+}
+
+// findVersion returns the first version of key not newer than sequence and
+// closes iterator
+func findVersion(iterator internalIterator, key []byte, sequence uint64) (*entry, error) {
+	defer iterator.close()
+	for ; iterator.valid() && bytes.Equal(iterator.entry().key, key); iterator.next() {
+		if iterator.entry().sequence <= sequence {
+			return iterator.entry(), nil
+		}
+	}
+	return nil, iterator.err()
+}
+
+// Get returns value of key, ok is false when key does not exist
+func (db *DB) Get(key []byte) (value []byte, ok bool, err error) {
+	return db.get(key, maxSequence)
+}
+
+func (db *DB) get(key []byte, sequence uint64) ([]byte, bool, error) {
+	db.mutex.Lock()
+	if db.closed {
+		db.mutex.Unlock()
+		return nil, false, ErrClosed
+	}
+	memtable, readers := db.candidates()
+	db.mutex.Unlock()
+	defer releaseReaders(readers)
+	found, err := db.lookup(memtable, readers, key, sequence)
+	if err != nil || found == nil || found.kind == deleteKind {
+		return nil, false, err
+	}
+	return found.value, true, nil
+}
+
+// Scan iterates over keys from start to end, end is excluded. Nil start and
+// end mean no limits
+func (db *DB) Scan(start, end []byte) (*Iterator, error) {
+	return db.scan(start, end, maxSequence)
+}
+
+func (db *DB) scan(start, end []byte, sequence uint64) (*Iterator, error) {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+	if db.closed {
+		return nil, ErrClosed
+	}
+	if sequence == maxSequence {
+		sequence = db.sequence
+	}
+	children := []internalIterator{db.memtable.iterator(start)}
+	for _, level := range db.manifest.Levels {
+		for _, file := range level {
+			if (start != nil && bytes.Compare(file.Largest, start) < 0) || (end != nil && bytes.Compare(file.Smallest, end) >= 0) {
+				continue
+			}
+			children = append(children, db.tables[file.Number].iterator(start))
+		}
+	}
+	return &Iterator{merged: newMergingIterator(children), sequence: sequence, end: end}, nil
+}
+
+// Stats returns state of database
+func (db *DB) Stats() Stats {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+	result := Stats{FilteredReads: atomic.LoadInt64(&db.filtered)}
+	for _, level := range db.manifest.Levels {
+		result.Files = append(result.Files, len(level))
+	}
+	return result
+}
+
+// Snapshot is a consistent view of database at some moment. Compactions
+// keep versions of keys visible to snapshots until they are released
+type Snapshot struct {
+	db       *DB
+	sequence uint64
+}
+
+// NewSnapshot returns snapshot of the current state of database
+func (db *DB) NewSnapshot() *Snapshot {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+	snapshot := &Snapshot{db: db, sequence: db.sequence}
+	db.snapshots[snapshot] = struct{}{}
+	return snapshot
+}
+
+// Get returns value of key as it was at the moment of snapshot
+func (s *Snapshot) Get(key []byte) (value []byte, ok bool, err error) {
+	return s.db.get(key, s.sequence)
+}
+
+// Scan iterates over keys as they were at the moment of snapshot
+func (s *Snapshot) Scan(start, end []byte) (*Iterator, error) {
+	return s.db.scan(start, end, s.sequence)
+}
+
+// Release lets compactions drop versions kept for snapshot
+func (s *Snapshot) Release() {
+	s.db.mutex.Lock()
+	defer s.db.mutex.Unlock()
+	delete(s.db.snapshots, s)
+}
+
+// oldestSnapshot returns sequence number of the oldest snapshot or of the
+// last write when there are no snapshots
+func (db *DB) oldestSnapshot() uint64 {
+	result := db.sequence
+	for snapshot := range db.snapshots {
+		if snapshot.sequence < result {
+			result = snapshot.sequence
+		}
+	}
+	return result
+}
+
+// sortFiles orders tables of level by their keys
+func sortFiles(files []*fileMeta) {
+	sort.Slice(files, func(i, j int) bool {
+		return bytes.Compare(files[i].Smallest, files[j].Smallest) < 0
+	})
+}
@@ -0,0 +1,133 @@
+package lsm
+
+import (
+	"bytes"
+	"container/heap"
+)
+
+// internalIterator iterates over entries of memtable or table in order of
+// compareEntries
+type internalIterator interface {
+	valid() bool
+	entry() *entry
+	next()
+	err() error
+	close() error
+}
+
+// mergingIterator merges entries of several iterators in order
+type mergingIterator struct {
+	children []internalIterator
+	active   []internalIterator
+	failure  error
+}
+
+func newMergingIterator(children []internalIterator) *mergingIterator {
+	result := &mergingIterator{children: children}
+	for _, child := range children {
+		result.push(child)
+	}
+	heap.Init(result)
+	return result
+}
+
+// push adds child to active ones if it has entries
+func (mi *mergingIterator) push(child internalIterator) {
+	if child.valid() {
+		mi.active = append(mi.active, child)
+	} else if err := child.err(); err != nil && mi.failure == nil {
+		mi.failure = err
+	}
+}
+
+func (mi *mergingIterator) Len() int { return len(mi.active) }
+func (mi *mergingIterator) Less(i, j int) bool {
+	return compareEntries(mi.active[i].entry(), mi.active[j].entry()) < 0
+}
+func (mi *mergingIterator) Swap(i, j int) { mi.active[i], mi.active[j] = mi.active[j], mi.active[i] }
+func (mi *mergingIterator) Push(child interface{}) {
+	mi.active = append(mi.active, child.(internalIterator))
+}
+func (mi *mergingIterator) Pop() interface{} {
+	last := mi.active[len(mi.active)-1]
+	mi.active = mi.active[:len(mi.active)-1]
+	return last
+}
+
+func (mi *mergingIterator) valid() bool   { return mi.failure == nil && len(mi.active) != 0 }
+func (mi *mergingIterator) entry() *entry { return mi.active[0].entry() }
+func (mi *mergingIterator) err() error    { return mi.failure }
+
+func (mi *mergingIterator) next() {
+	smallest := mi.active[0]
+	smallest.next()
+	if smallest.valid() {
+		heap.Fix(mi, 0)
+		return
+	}
+	heap.Pop(mi)
+	if err := smallest.err(); err != nil && mi.failure == nil {
+		mi.failure = err
+	}
+}
+
+func (mi *mergingIterator) close() error {
+	var result error
+	for _, child := range mi.children {
+		if err := child.close(); err != nil && result == nil {
+			result = err
+		}
+	}
+	mi.children, mi.active = nil, nil
+	return result
+}
+
+// Iterator returns keys and values visible to its snapshot in order of
+// keys. Deleted keys are skipped
+type Iterator struct {
+	merged   *mergingIterator
+	sequence uint64
+	// end is a key after the last returned one, nil means no limit
+	end        []byte
+	key, value []byte
+	failure    error
+}
+
+// Next moves to the next key, it returns false after the last one or on
+// error
+func (it *Iterator) Next() bool {
+	for it.merged.valid() {
+		current := it.merged.entry()
+		if it.end != nil && bytes.Compare(current.key, it.end) >= 0 {
+			break
+		}
+		if current.sequence > it.sequence {
+			it.merged.next()
+			continue
+		}
+		// The newest visible version decides value of key, older versions
+		// are skipped
+		for it.merged.next(); it.merged.valid() && bytes.Equal(it.merged.entry().key, current.key); {
+			it.merged.next()
+		}
+		if current.kind == deleteKind {
+			continue
+		}
+		it.key, it.value = current.key, current.value
+		return true
+	}
+	it.key, it.value, it.failure = nil, nil, it.merged.err()
+	return false
+}
+
+// Key returns the current key. It must not be changed
+func (it *Iterator) Key() []byte { return it.key }
+
+// Value returns value of the current key. It must not be changed
+func (it *Iterator) Value() []byte { return it.value }
+
+// Err returns error which stopped iteration
+func (it *Iterator) Err() error { return it.failure }
+
+// Close releases tables read by iterator
+func (it *Iterator) Close() error { return it.merged.close() }
@@ -0,0 +1,143 @@
+package lsm
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"os"
+
+	"github.com/VorobevPavel-dev/congenial-disco/utility"
+)
+
+// Batch is a set of writes which are applied atomically
+type Batch struct {
+	entries []*entry
+}
+
+// Put sets value of key
+func (b *Batch) Put(key, value []byte) {
+	b.entries = append(b.entries, &entry{
+		key:   append([]byte{}, key...),
+		kind:  setKind,
+		value: append([]byte{}, value...),
+	})
+}
+
+// Delete removes key
+func (b *Batch) Delete(key []byte) {
+	b.entries = append(b.entries, &entry{key: append([]byte{}, key...), kind: deleteKind})
+}
+
+// Len returns number of writes of batch
+func (b *Batch) Len() int { return len(b.entries) }
+
+// Truncate drops writes made after batch had length writes
+func (b *Batch) Truncate(length int) {
+	if length < len(b.entries) {
+		b.entries = b.entries[:length]
+	}
+}
+
+// Write-ahead log keeps batches written to memtable, so that they are
+// restored after restart. Every batch is a record:
+//
+//	crc of payload (4 bytes) | length of payload (4 bytes) | payload
+//
+// Payload starts with sequence number of the first entry followed by number
+// of entries and entries themselves. Record torn by crash fails checksum and
+// is dropped with all records after it
+const recordHeaderSize = 8
+
+type logWriter struct {
+	file *os.File
+	sync bool
+}
+
+func openLog(path string, sync bool) (*logWriter, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &logWriter{file: file, sync: sync}, nil
+}
+
+func (lw *logWriter) append(sequence uint64, entries []*entry) error {
+	payload := make([]byte, recordHeaderSize+8)
+	binary.LittleEndian.PutUint64(payload[recordHeaderSize:], sequence)
+	payload = utility.AppendUvarint(payload, uint64(len(entries)))
+	for _, written := range entries {
+		payload = append(payload, written.kind)
+		payload = utility.AppendBytes(payload, written.key)
+		payload = utility.AppendBytes(payload, written.value)
+	}
+	binary.LittleEndian.PutUint32(payload[0:], crc32.ChecksumIEEE(payload[recordHeaderSize:]))
+	binary.LittleEndian.PutUint32(payload[4:], uint32(len(payload)-recordHeaderSize))
+	if _, err := lw.file.Write(payload); err != nil {
+		return err
+	}
+	if lw.sync {
+		return lw.file.Sync()
+	}
+	return nil
+}
+
+func (lw *logWriter) close() error { return lw.file.Close() }
+
+// replayLog passes entries of every complete record of log to apply. Torn
+// records at the end of log are cut off
+func replayLog(path string, apply func(entries []*entry)) error {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var valid int
+	for len(data)-valid >= recordHeaderSize {
+		checksum := binary.LittleEndian.Uint32(data[valid:])
+		length := int(binary.LittleEndian.Uint32(data[valid+4:]))
+		start := valid + recordHeaderSize
+		if length < 8 || length > len(data)-start || crc32.ChecksumIEEE(data[start:start+length]) != checksum {
+			break
+		}
+		entries, ok := decodeRecord(data[start : start+length])
+		if !ok {
+			break
+		}
+		apply(entries)
+		valid = start + length
+	}
+	if valid == len(data) {
+		return nil
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if err := file.Truncate(int64(valid)); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+func decodeRecord(payload []byte) ([]*entry, bool) {
+	sequence := binary.LittleEndian.Uint64(payload)
+	input := utility.NewDecoder(payload[8:], errCorruptedTable)
+	count := input.Uvarint()
+	var entries []*entry
+	for index := uint64(0); index < count && input.Err() == nil; index++ {
+		decoded := &entry{sequence: sequence + index}
+		if kind := input.Take(1); input.Err() == nil {
+			decoded.kind = kind[0]
+		}
+		decoded.key = input.Bytes()
+		decoded.value = input.Bytes()
+		entries = append(entries, decoded)
+	}
+	return entries, input.Err() == nil && input.Remaining() == 0
+}
@@ -0,0 +1,341 @@
+package lsm
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func key(index int) []byte { return []byte(fmt.Sprintf("key%06d", index)) }
+
+// scanAll returns keys and values of iterator as a map
+func scanAll(t *testing.T, iterator *Iterator, err error) (keys []string, values map[string]string) {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("Scan failed: %v", err)
+	}
+	defer iterator.Close()
+	values = map[string]string{}
+	for iterator.Next() {
+		keys = append(keys, string(iterator.Key()))
+		values[string(iterator.Key())] = string(iterator.Value())
+	}
+	if err := iterator.Err(); err != nil {
+		t.Fatalf("Scan failed: %v", err)
+	}
+	return keys, values
+}
+
+func TestBloom(t *testing.T) {
+	var hashes []uint64
+	for index := 0; index < 10000; index++ {
+		hashes = append(hashes, bloomHash(key(index)))
+	}
+	filter := newBloom(hashes, 10)
+	for index := 0; index < 10000; index++ {
+		if !filter.mayContain(key(index)) {
+			t.Fatalf("Expected filter to contain %s", key(index))
+		}
+	}
+	var positives int
+	for index := 10000; index < 20000; index++ {
+		if filter.mayContain(key(index)) {
+			positives++
+		}
+	}
+	if positives > 300 {
+		t.Errorf("Expected about 1%% of false positives, got %d of 10000", positives)
+	}
+}
+
+func TestTable(t *testing.T) {
+	options := (&Options{BlockSize: 256}).withDefaults()
+	path := filepath.Join(t.TempDir(), "000001.sst")
+	writer, err := newTableWriter(path, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var expected []*entry
+	for index := 0; index < 1000; index++ {
+		// Every key has two versions, the even ones are deleted
+		newer := &entry{key: key(index), sequence: uint64(2*index + 2), kind: setKind, value: []byte(strconv.Itoa(index))}
+		if index%2 == 0 {
+			newer.kind, newer.value = deleteKind, nil
+		}
+		older := &entry{key: key(index), sequence: uint64(2*index + 1), kind: setKind, value: []byte("old")}
+		expected = append(expected, newer, older)
+	}
+	for _, added := range expected {
+		if err := writer.add(added); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.finish(); err != nil {
+		t.Fatal(err)
+	}
+	reader, err := openTable(path, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.unref()
+	if len(reader.index) < 10 {
+		t.Errorf("Expected table to have many blocks, got %d", len(reader.index))
+	}
+	starts := [][]byte{nil, key(0), key(500), []byte("key000500a"), key(999), []byte("zzz")}
+	expectedOffsets := []int{0, 0, 1000, 1002, 1998, 2000}
+	for testCase, start := range starts {
+		iterator := reader.iterator(start)
+		var actual []*entry
+		for ; iterator.valid(); iterator.next() {
+			actual = append(actual, iterator.entry())
+		}
+		if err := iterator.err(); err != nil {
+			t.Errorf("Iteration failed on set #%d: %v", testCase, err)
+		}
+		iterator.close()
+		wanted := expected[expectedOffsets[testCase]:]
+		if len(actual) != len(wanted) || (len(actual) != 0 && compareEntries(actual[0], wanted[0]) != 0) ||
+			(len(actual) != 0 && (actual[0].kind != wanted[0].kind || string(actual[0].value) != string(wanted[0].value))) {
+			t.Errorf("Assertion failed on set #%d. Expected %d entries, got %d", testCase, len(wanted), len(actual))
+		}
+	}
+	// Damaged block is detected by checksum
+	data, _ := os.ReadFile(path)
+	data[10] ^= 0xff
+	os.WriteFile(path, data, 0644)
+	damaged, err := openTable(path, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer damaged.unref()
+	iterator := damaged.iterator(nil)
+	if iterator.valid() || iterator.err() != errCorruptedTable {
+		t.Errorf("Expected corrupted table error, got: %v", iterator.err())
+	}
+	iterator.close()
+}
+
+func TestReadWrite(t *testing.T) {
+	db, err := Open(t.TempDir(), &Options{MemtableSize: 4096, BlockSize: 512})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	expected := map[string]string{}
+	for index := 0; index < 2000; index++ {
+		value := "value" + strconv.Itoa(index)
+		if err := db.Put(key(index%700), []byte(value)); err != nil {
+			t.Fatal(err)
+		}
+		expected[string(key(index%700))] = value
+		if index%3 == 0 {
+			if err := db.Delete(key(index % 500)); err != nil {
+				t.Fatal(err)
+			}
+			delete(expected, string(key(index%500)))
+		}
+	}
+	for index := 0; index < 800; index++ {
+		value, ok, err := db.Get(key(index))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if wanted, exists := expected[string(key(index))]; ok != exists || string(value) != wanted {
+			t.Errorf("Assertion failed on key %s. Expected: %q %v, got: %q %v", key(index), wanted, exists, value, ok)
+		}
+	}
+	if db.Stats().FilteredReads == 0 {
+		t.Errorf("Expected bloom filters to skip tables")
+	}
+	iterator, err := db.Scan(key(100), key(600))
+	keys, values := scanAll(t, iterator, err)
+	for _, scanned := range keys {
+		if scanned < string(key(100)) || scanned >= string(key(600)) {
+			t.Errorf("Unexpected key %s outside of range", scanned)
+		}
+	}
+	for index := 100; index < 600; index++ {
+		if values[string(key(index))] != expected[string(key(index))] {
+			t.Errorf("Assertion failed on key %s. Expected: %q, got: %q", key(index), expected[string(key(index))], values[string(key(index))])
+		}
+	}
+	for index := 1; index < len(keys); index++ {
+		if keys[index-1] >= keys[index] {
+			t.Errorf("Expected keys in order, got %s before %s", keys[index-1], keys[index])
+		}
+	}
+}
+
+func TestBatchAndConflicts(t *testing.T) {
+	db, err := Open(t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	batch := &Batch{}
+	batch.Put([]byte("a"), []byte("1"))
+	batch.Put([]byte("b"), []byte("2"))
+	batch.Delete([]byte("a"))
+	if err := db.Write(batch); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, _ := db.Get([]byte("a")); ok {
+		t.Errorf("Expected the last write of batch to win")
+	}
+	first, second := db.NewSnapshot(), db.NewSnapshot()
+	defer first.Release()
+	defer second.Release()
+	update := &Batch{}
+	update.Put([]byte("b"), []byte("3"))
+	if err := db.Commit(update, first); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Commit(update, second); err != ErrConflict {
+		t.Errorf("Expected conflict, got: %v", err)
+	}
+	if value, _, _ := second.Get([]byte("b")); string(value) != "2" {
+		t.Errorf("Expected snapshot to see old value, got: %q", value)
+	}
+	if value, _, _ := db.Get([]byte("b")); string(value) != "3" {
+		t.Errorf("Expected new value, got: %q", value)
+	}
+}
+
+func TestRecovery(t *testing.T) {
+	directory := t.TempDir()
+	options := &Options{MemtableSize: 8192, BlockSize: 512}
+	db, err := Open(directory, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for index := 0; index < 1000; index++ {
+		if err := db.Put(key(index), []byte(strconv.Itoa(index))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// The last write is torn and leftovers of interrupted flush lie around
+	var logs []string
+	names, _ := os.ReadDir(directory)
+	for _, name := range names {
+		if filepath.Ext(name.Name()) == ".log" {
+			logs = append(logs, filepath.Join(directory, name.Name()))
+		}
+	}
+	if len(logs) != 1 {
+		t.Fatalf("Expected one log, got %v", logs)
+	}
+	info, _ := os.Stat(logs[0])
+	os.Truncate(logs[0], info.Size()-3)
+	os.WriteFile(filepath.Join(directory, "999999.sst"), []byte("garbage"), 0644)
+	db, err = Open(directory, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for index := 0; index < 1000; index++ {
+		value, ok, err := db.Get(key(index))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if index == 999 {
+			if ok {
+				t.Errorf("Expected torn write to be lost")
+			}
+		} else if string(value) != strconv.Itoa(index) {
+			t.Errorf("Assertion failed on key %s. Expected: %d, got: %q", key(index), index, value)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(directory, "999999.sst")); !os.IsNotExist(err) {
+		t.Errorf("Expected orphan table to be removed")
+	}
+	// Writes after recovery go to the same log and survive the next restart
+	if err := db.Put(key(999), []byte("again")); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+	db, err = Open(directory, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if value, _, _ := db.Get(key(999)); string(value) != "again" {
+		t.Errorf("Expected write after recovery to survive, got: %q", value)
+	}
+}
+
+func TestCompaction(t *testing.T) {
+	directory := t.TempDir()
+	options := &Options{
+		MemtableSize: 4096,
+		BlockSize:    512,
+		Level0Files:  2,
+		LevelSize:    16 << 10,
+		TableSize:    4096,
+	}
+	db, err := Open(directory, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	snapshot := db.NewSnapshot()
+	for round := 0; round < 5; round++ {
+		for index := 0; index < 1000; index++ {
+			if err := db.Put(key(index), []byte(fmt.Sprintf("%d-%d", round, index))); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	for index := 0; index < 1000; index += 2 {
+		if err := db.Delete(key(index)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files := db.Stats().Files
+	if files[2] == 0 {
+		t.Errorf("Expected compaction to fill level 2, got %v", files)
+	}
+	iterator, err := snapshot.Scan(nil, nil)
+	_, old := scanAll(t, iterator, err)
+	if len(old) != 0 {
+		t.Errorf("Expected snapshot taken before writes to be empty, got %d keys", len(old))
+	}
+	snapshot.Release()
+	check := func(db *DB) {
+		iterator, err := db.Scan(nil, nil)
+		keys, values := scanAll(t, iterator, err)
+		if len(keys) != 500 {
+			t.Errorf("Expected 500 keys, got %d", len(keys))
+		}
+		for index := 1; index < 1000; index += 2 {
+			if wanted := fmt.Sprintf("4-%d", index); values[string(key(index))] != wanted {
+				t.Errorf("Assertion failed on key %s. Expected: %q, got: %q", key(index), wanted, values[string(key(index))])
+			}
+		}
+	}
+	check(db)
+	db.Close()
+	db, err = Open(directory, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	check(db)
+	// Only tables of the manifest and the current log are left
+	names, _ := os.ReadDir(directory)
+	var count int
+	for _, name := range names {
+		if filepath.Ext(name.Name()) == ".sst" {
+			count++
+		}
+	}
+	var total int
+	for _, level := range db.Stats().Files {
+		total += level
+	}
+	if count != total {
+		t.Errorf("Expected %d tables in directory, got %d", total, count)
+	}
+}
@@ -0,0 +1,80 @@
+package lsm
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+const manifestFile = "MANIFEST"
+
+// fileMeta describes table of a level
+type fileMeta struct {
+	Number   uint64 `json:"number"`
+	Size     int64  `json:"size"`
+	Smallest []byte `json:"smallest"`
+	Largest  []byte `json:"largest"`
+}
+
+// manifest lists tables of every level and the log of memtable. It is
+// replaced at once after every flush and compaction, so tables and logs not
+// listed in it are leftovers of interrupted ones
+type manifest struct {
+	NextFile     uint64        `json:"next_file"`
+	LastSequence uint64        `json:"last_sequence"`
+	Log          uint64        `json:"log"`
+	Levels       [][]*fileMeta `json:"levels"`
+}
+
+func tablePath(directory string, number uint64) string {
+	return filepath.Join(directory, fmt.Sprintf("%06d.sst", number))
+}
+
+func logPath(directory string, number uint64) string {
+	return filepath.Join(directory, fmt.Sprintf("%06d.log", number))
+}
+
+// loadManifest reads manifest of directory, ok is false when there is none
+func loadManifest(directory string) (result manifest, ok bool, err error) {
+	data, err := os.ReadFile(filepath.Join(directory, manifestFile))
+	if os.IsNotExist(err) {
+		return manifest{}, false, nil
+	}
+	if err != nil {
+		return manifest{}, false, err
+	}
+	if err := json.Unmarshal(data, &result); err != nil {
+		return manifest{}, false, fmt.Errorf("cannot read manifest: %v", err)
+	}
+	for len(result.Levels) < maxLevels {
+		result.Levels = append(result.Levels, nil)
+	}
+	return result, true, nil
+}
+
+// saveManifest writes manifest to temporary file and renames it to replace
+// previous version at once
+func saveManifest(directory string, saved manifest) error {
+	data, err := json.Marshal(saved)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(directory, manifestFile)
+	file, err := os.Create(path + ".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
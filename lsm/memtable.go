@@ -0,0 +1,139 @@
+package lsm
+
+import (
+	"bytes"
+	"math/rand"
+	"sync"
+)
+
+// Kinds of entries
+const (
+	deleteKind byte = iota
+	setKind
+)
+
+// entry is a version of key. Versions of the same key are ordered from the
+// newest to the oldest by sequence numbers
+type entry struct {
+	key      []byte
+	sequence uint64
+	kind     byte
+	value    []byte
+}
+
+// compareEntries orders entries by keys and versions of the same key from
+// the newest one
+func compareEntries(first, second *entry) int {
+	if order := bytes.Compare(first.key, second.key); order != 0 {
+		return order
+	}
+	switch {
+	case first.sequence > second.sequence:
+		return -1
+	case first.sequence < second.sequence:
+		return 1
+	}
+	return 0
+}
+
+// size estimates memory taken by entry
+func (e *entry) size() int {
+	return len(e.key) + len(e.value) + 32
+}
+
+const maxHeight = 12
+
+type node struct {
+	entry *entry
+	next  []*node
+}
+
+// memtable keeps the latest writes in a skip list ordered like entries of
+// tables. Entries are never changed once they are added, so iterators of
+// memtable see entries added after they were created, which are newer than
+// their snapshots anyway
+type memtable struct {
+	mutex  sync.RWMutex
+	head   *node
+	height int
+	size   int
+	random *rand.Rand
+}
+
+func newMemtable() *memtable {
+	return &memtable{
+		head:   &node{next: make([]*node, maxHeight)},
+		height: 1,
+		random: rand.New(rand.NewSource(1)),
+	}
+}
+
+// findGreaterOrEqual returns the first node not less than target and fills
+// previous nodes at every level if previous is not nil
+func (m *memtable) findGreaterOrEqual(target *entry, previous []*node) *node {
+	current := m.head
+	for level := m.height - 1; level >= 0; level-- {
+		for current.next[level] != nil && compareEntries(current.next[level].entry, target) < 0 {
+			current = current.next[level]
+		}
+		if previous != nil {
+			previous[level] = current
+		}
+	}
+	return current.next[0]
+}
+
+func (m *memtable) add(added *entry) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	previous := make([]*node, maxHeight)
+	m.findGreaterOrEqual(added, previous)
+	height := 1
+	for height < maxHeight && m.random.Intn(4) == 0 {
+		height++
+	}
+	for level := m.height; level < height; level++ {
+		previous[level] = m.head
+	}
+	if height > m.height {
+		m.height = height
+	}
+	created := &node{entry: added, next: make([]*node, height)}
+	for level := 0; level < height; level++ {
+		created.next[level] = previous[level].next[level]
+		previous[level].next[level] = created
+	}
+	m.size += added.size()
+}
+
+func (m *memtable) empty() bool {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return m.head.next[0] == nil
+}
+
+// memtableIterator iterates over entries of memtable
+type memtableIterator struct {
+	memtable *memtable
+	current  *node
+}
+
+func (m *memtable) iterator(start []byte) *memtableIterator {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return &memtableIterator{
+		memtable: m,
+		current:  m.findGreaterOrEqual(&entry{key: start, sequence: maxSequence}, nil),
+	}
+}
+
+func (mi *memtableIterator) valid() bool   { return mi.current != nil }
+func (mi *memtableIterator) entry() *entry { return mi.current.entry }
+func (mi *memtableIterator) err() error    { return nil }
+func (mi *memtableIterator) close() error  { return nil }
+
+func (mi *memtableIterator) next() {
+	mi.memtable.mutex.RLock()
+	defer mi.memtable.mutex.RUnlock()
+	mi.current = mi.current.next[0]
+}
@@ -0,0 +1,314 @@
+package lsm
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"sort"
+	"sync/atomic"
+
+	"github.com/VorobevPavel-dev/congenial-disco/utility"
+)
+
+// Tables are immutable files of entries sorted like entries of memtable:
+//
+//	data block 1 | crc | ... | data block N | crc | index | filter | footer
+//
+// Data blocks hold entries encoded one after another, the index lists the
+// last entry and location of every block, the filter is a bloom filter of
+// keys and the footer of fixed size locates the index and the filter
+const (
+	footerSize  = 40
+	tableMagic  = 0x6c736d7461626c65
+	checksumLen = 4
+)
+
+var errCorruptedTable = errors.New("table is corrupted")
+
+// blockHandle locates data block and holds its last entry
+type blockHandle struct {
+	lastKey      []byte
+	lastSequence uint64
+	offset, size int64
+}
+
+// tableWriter writes sorted entries to a new table
+type tableWriter struct {
+	options *Options
+	file    *os.File
+	writer  *bufio.Writer
+	offset  int64
+
+	block    []byte
+	last     *entry
+	index    []blockHandle
+	hashes   []uint64
+	smallest []byte
+	largest  []byte
+	entries  int
+	finished bool
+}
+
+func newTableWriter(path string, options *Options) (*tableWriter, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &tableWriter{options: options, file: file, writer: bufio.NewWriter(file)}, nil
+}
+
+// add appends entry which must follow all entries added before
+func (tw *tableWriter) add(added *entry) error {
+	if tw.last == nil || string(tw.last.key) != string(added.key) {
+		tw.hashes = append(tw.hashes, bloomHash(added.key))
+		if tw.smallest == nil {
+			tw.smallest = append([]byte{}, added.key...)
+		}
+		tw.largest = append(tw.largest[:0], added.key...)
+	}
+	tw.block = utility.AppendBytes(tw.block, added.key)
+	tw.block = utility.AppendUvarint(tw.block, added.sequence)
+	tw.block = append(tw.block, added.kind)
+	tw.block = utility.AppendBytes(tw.block, added.value)
+	tw.last = added
+	tw.entries++
+	if len(tw.block) >= tw.options.BlockSize {
+		return tw.flushBlock()
+	}
+	return nil
+}
+
+// size returns number of bytes written so far
+func (tw *tableWriter) size() int64 { return tw.offset + int64(len(tw.block)) }
+
+func (tw *tableWriter) write(data []byte) error {
+	_, err := tw.writer.Write(data)
+	tw.offset += int64(len(data))
+	return err
+}
+
+func (tw *tableWriter) flushBlock() error {
+	if len(tw.block) == 0 {
+		return nil
+	}
+	handle := blockHandle{
+		lastKey:      append([]byte{}, tw.last.key...),
+		lastSequence: tw.last.sequence,
+		offset:       tw.offset,
+		size:         int64(len(tw.block)),
+	}
+	var checksum [checksumLen]byte
+	binary.LittleEndian.PutUint32(checksum[:], crc32.ChecksumIEEE(tw.block))
+	if err := tw.write(tw.block); err != nil {
+		return err
+	}
+	if err := tw.write(checksum[:]); err != nil {
+		return err
+	}
+	tw.index = append(tw.index, handle)
+	tw.block = tw.block[:0]
+	return nil
+}
+
+// finish writes the rest of table and syncs it to disk
+func (tw *tableWriter) finish() error {
+	if err := tw.flushBlock(); err != nil {
+		return err
+	}
+	index := utility.AppendUvarint(nil, uint64(len(tw.index)))
+	for _, handle := range tw.index {
+		index = utility.AppendBytes(index, handle.lastKey)
+		index = utility.AppendUvarint(index, handle.lastSequence)
+		index = utility.AppendUvarint(index, uint64(handle.offset))
+		index = utility.AppendUvarint(index, uint64(handle.size))
+	}
+	filter := newBloom(tw.hashes, tw.options.BloomBitsPerKey)
+	footer := make([]byte, footerSize)
+	binary.LittleEndian.PutUint64(footer[0:], uint64(tw.offset))
+	binary.LittleEndian.PutUint64(footer[8:], uint64(len(index)))
+	binary.LittleEndian.PutUint64(footer[16:], uint64(tw.offset+int64(len(index))))
+	binary.LittleEndian.PutUint64(footer[24:], uint64(len(filter)))
+	binary.LittleEndian.PutUint64(footer[32:], tableMagic)
+	for _, data := range [][]byte{index, filter, footer} {
+		if err := tw.write(data); err != nil {
+			return err
+		}
+	}
+	if err := tw.writer.Flush(); err != nil {
+		return err
+	}
+	if err := tw.file.Sync(); err != nil {
+		return err
+	}
+	tw.finished = true
+	return tw.file.Close()
+}
+
+// abandon removes table which is not finished
+func (tw *tableWriter) abandon() {
+	if !tw.finished {
+		tw.file.Close()
+	}
+	os.Remove(tw.file.Name())
+}
+
+// tableReader reads table. Index and filter are kept in memory, data blocks
+// are read on demand. Reader is shared by iterators and closed when the last
+// of them releases it
+type tableReader struct {
+	number uint64
+	file   *os.File
+	index  []blockHandle
+	filter bloom
+	refs   int32
+	// smallest and largest are keys of table set by database
+	smallest, largest []byte
+}
+
+func openTable(path string, number uint64) (*tableReader, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	reader, err := readTable(file, number)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("cannot open table %s: %v", path, err)
+	}
+	return reader, nil
+}
+
+func readTable(file *os.File, number uint64) (*tableReader, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() < footerSize {
+		return nil, errCorruptedTable
+	}
+	footer := make([]byte, footerSize)
+	if _, err := file.ReadAt(footer, info.Size()-footerSize); err != nil {
+		return nil, err
+	}
+	if binary.LittleEndian.Uint64(footer[32:]) != tableMagic {
+		return nil, errCorruptedTable
+	}
+	locations := make([]uint64, 4)
+	for index := range locations {
+		locations[index] = binary.LittleEndian.Uint64(footer[index*8:])
+	}
+	if locations[0]+locations[1] > uint64(info.Size()) || locations[2]+locations[3] > uint64(info.Size()) {
+		return nil, errCorruptedTable
+	}
+	index := make([]byte, locations[1])
+	if _, err := file.ReadAt(index, int64(locations[0])); err != nil {
+		return nil, err
+	}
+	filter := make([]byte, locations[3])
+	if _, err := file.ReadAt(filter, int64(locations[2])); err != nil {
+		return nil, err
+	}
+	reader := &tableReader{number: number, file: file, filter: filter, refs: 1}
+	input := utility.NewDecoder(index, errCorruptedTable)
+	count := input.Uvarint()
+	for block := uint64(0); block < count && input.Err() == nil; block++ {
+		reader.index = append(reader.index, blockHandle{
+			lastKey:      input.Bytes(),
+			lastSequence: input.Uvarint(),
+			offset:       int64(input.Uvarint()),
+			size:         int64(input.Uvarint()),
+		})
+	}
+	if input.Err() != nil {
+		return nil, input.Err()
+	}
+	return reader, nil
+}
+
+func (tr *tableReader) ref() { atomic.AddInt32(&tr.refs, 1) }
+
+// unref releases reader and closes its file when it is not used anymore
+func (tr *tableReader) unref() error {
+	if atomic.AddInt32(&tr.refs, -1) == 0 {
+		return tr.file.Close()
+	}
+	return nil
+}
+
+// readBlock reads and decodes entries of data block
+func (tr *tableReader) readBlock(handle blockHandle) ([]*entry, error) {
+	data := make([]byte, handle.size+checksumLen)
+	if _, err := tr.file.ReadAt(data, handle.offset); err != nil {
+		return nil, err
+	}
+	block := data[:handle.size]
+	if crc32.ChecksumIEEE(block) != binary.LittleEndian.Uint32(data[handle.size:]) {
+		return nil, errCorruptedTable
+	}
+	var result []*entry
+	input := utility.NewDecoder(block, errCorruptedTable)
+	for input.Remaining() != 0 && input.Err() == nil {
+		decoded := &entry{key: input.Bytes(), sequence: input.Uvarint()}
+		if kind := input.Take(1); input.Err() == nil {
+			decoded.kind = kind[0]
+		}
+		decoded.value = input.Bytes()
+		result = append(result, decoded)
+	}
+	if input.Err() != nil {
+		return nil, input.Err()
+	}
+	return result, nil
+}
+
+// tableIterator iterates over entries of table starting from the first
+// entry of the given key
+type tableIterator struct {
+	reader   *tableReader
+	block    int
+	entries  []*entry
+	position int
+	failure  error
+}
+
+func (tr *tableReader) iterator(start []byte) *tableIterator {
+	tr.ref()
+	target := &entry{key: start, sequence: maxSequence}
+	// The first block which last entry is not less than start
+	block := sort.Search(len(tr.index), func(index int) bool {
+		handle := tr.index[index]
+		return compareEntries(&entry{key: handle.lastKey, sequence: handle.lastSequence}, target) >= 0
+	})
+	iterator := &tableIterator{reader: tr, block: block - 1}
+	iterator.load()
+	for iterator.valid() && compareEntries(iterator.entry(), target) < 0 {
+		iterator.next()
+	}
+	return iterator
+}
+
+// load reads the next block
+func (ti *tableIterator) load() {
+	ti.entries, ti.position = nil, 0
+	for ti.failure == nil && len(ti.entries) == 0 {
+		ti.block++
+		if ti.block >= len(ti.reader.index) {
+			return
+		}
+		ti.entries, ti.failure = ti.reader.readBlock(ti.reader.index[ti.block])
+	}
+}
+
+func (ti *tableIterator) valid() bool   { return ti.failure == nil && ti.position < len(ti.entries) }
+func (ti *tableIterator) entry() *entry { return ti.entries[ti.position] }
+func (ti *tableIterator) err() error    { return ti.failure }
+func (ti *tableIterator) close() error  { return ti.reader.unref() }
+
+func (ti *tableIterator) next() {
+	if ti.position++; ti.position >= len(ti.entries) {
+		ti.load()
+	}
+}
@@ -0,0 +1,171 @@
+package pagestore
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"sync"
+	"time"
+)
+
+// masterFile keeps LSN of the last checkpoint with its checksum
+const masterFile = "master"
+
+func readMaster(fs FileSystem, path string) (LSN, error) {
+	file, err := fs.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	size, err := file.Size()
+	if err != nil || size == 0 {
+		return 0, err
+	}
+	data := make([]byte, 12)
+	if _, err := file.ReadAt(data, 0); err != nil {
+		return 0, fmt.Errorf("cannot read master record: %v", err)
+	}
+	if crc32.ChecksumIEEE(data[:8]) != binary.LittleEndian.Uint32(data[8:]) {
+		return 0, fmt.Errorf("master record is corrupted")
+	}
+	return LSN(binary.LittleEndian.Uint64(data)), nil
+}
+
+// saveMaster writes master record to temporary file and renames it to
+// replace previous version at once
+func (s *Store) saveMaster(checkpoint LSN) error {
+	data := make([]byte, 12)
+	binary.LittleEndian.PutUint64(data, uint64(checkpoint))
+	binary.LittleEndian.PutUint32(data[8:], crc32.ChecksumIEEE(data[:8]))
+	fs, path := s.options.FileSystem, s.path(masterFile)
+	file, err := fs.Open(path + ".tmp")
+	if err != nil {
+		return err
+	}
+	if err := file.Truncate(0); err != nil {
+		file.Close()
+		return err
+	}
+	if _, err := file.WriteAt(data, 0); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return fs.Rename(path+".tmp", path)
+}
+
+// Checkpoint writes dirty pages to disk, logs active transactions and dirty
+// pages and removes the beginning of log not needed by recovery anymore.
+// Transactions keep running while pages are written, pages changed meanwhile
+// stay dirty (fuzzy checkpoint)
+func (s *Store) Checkpoint() error {
+	s.checkpointing.Lock()
+	defer s.checkpointing.Unlock()
+	s.mutex.Lock()
+	if s.closed {
+		s.mutex.Unlock()
+		return ErrClosed
+	}
+	numbers := make([]uint64, 0, len(s.dirty))
+	for number := range s.dirty {
+		numbers = append(numbers, number)
+	}
+	s.mutex.Unlock()
+
+	// Pages are written one by one, so transactions wait for at most one
+	// page write
+	written := map[uint64]LSN{}
+	for _, number := range numbers {
+		s.mutex.Lock()
+		page, err := s.page(number)
+		if err == nil {
+			err = s.writePage(number)
+		}
+		if err != nil {
+			s.mutex.Unlock()
+			return err
+		}
+		written[number] = pageLSN(page)
+		s.mutex.Unlock()
+	}
+	if err := s.data.Sync(); err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for number, lsn := range written {
+		page, err := s.page(number)
+		if err != nil {
+			return err
+		}
+		if pageLSN(page) == lsn {
+			delete(s.dirty, number)
+		}
+	}
+	// Checkpoint starts a new segment, so that segments before it are
+	// removed once they are not needed
+	if err := s.log.rotate(); err != nil {
+		return err
+	}
+	checkpoint := &record{kind: checkpointRecord, transaction: s.nextTransaction}
+	for _, transaction := range s.transactions {
+		checkpoint.transactions = append(checkpoint.transactions, activeTransaction{id: transaction.id, last: transaction.last})
+	}
+	for number, recovery := range s.dirty {
+		checkpoint.pages = append(checkpoint.pages, dirtyPage{page: number, recovery: recovery})
+	}
+	lsn := s.log.append(checkpoint)
+	if err := s.log.flush(lsn); err != nil {
+		return err
+	}
+	if err := s.saveMaster(lsn); err != nil {
+		return err
+	}
+	// Redo needs records from the oldest recovery LSN of dirty pages, undo
+	// needs all records of active transactions
+	keep := lsn
+	for _, recovery := range s.dirty {
+		if recovery < keep {
+			keep = recovery
+		}
+	}
+	for _, transaction := range s.transactions {
+		if transaction.first != 0 && transaction.first < keep {
+			keep = transaction.first
+		}
+	}
+	return s.log.truncateHead(keep)
+}
+
+// CheckpointEvery runs Checkpoint periodically until returned function is
+// called. Failed checkpoints are retried on the next tick
+func (s *Store) CheckpointEvery(interval time.Duration) (stop func()) {
+	var (
+		ticker = time.NewTicker(interval)
+		done   = make(chan struct{})
+		once   sync.Once
+	)
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				s.Checkpoint()
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		once.Do(func() {
+			ticker.Stop()
+			close(done)
+		})
+	}
+}
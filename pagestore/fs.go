@@ -0,0 +1,94 @@
+package pagestore
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// File is a file of FileSystem
+type File interface {
+	io.ReaderAt
+	io.WriterAt
+	Size() (int64, error)
+	Truncate(size int64) error
+	// Sync makes written data durable
+	Sync() error
+	Close() error
+}
+
+// FileSystem stores files of store. Writes are durable only after Sync of
+// file, creation, removal and renaming of files are atomic and durable
+type FileSystem interface {
+	// Open opens file creating it when it does not exist
+	Open(name string) (File, error)
+	Remove(name string) error
+	Rename(from, to string) error
+	// List returns names of files of directory
+	List(directory string) ([]string, error)
+	MkdirAll(directory string) error
+}
+
+// OSFileSystem is FileSystem of operating system
+type OSFileSystem struct{}
+
+type osFile struct{ *os.File }
+
+func (f osFile) Size() (int64, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+// syncDirectory makes changes of entries of directory of file durable
+func syncDirectory(name string) error {
+	directory, err := os.Open(filepath.Dir(name))
+	if err != nil {
+		return err
+	}
+	defer directory.Close()
+	return directory.Sync()
+}
+
+func (OSFileSystem) Open(name string) (File, error) {
+	file, err := os.OpenFile(name, os.O_RDWR, 0644)
+	if os.IsNotExist(err) {
+		if file, err = os.OpenFile(name, os.O_CREATE|os.O_RDWR, 0644); err == nil {
+			err = syncDirectory(name)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return osFile{file}, nil
+}
+
+func (OSFileSystem) Remove(name string) error {
+	if err := os.Remove(name); err != nil {
+		return err
+	}
+	return syncDirectory(name)
+}
+
+func (OSFileSystem) Rename(from, to string) error {
+	if err := os.Rename(from, to); err != nil {
+		return err
+	}
+	return syncDirectory(to)
+}
+
+func (OSFileSystem) List(directory string) ([]string, error) {
+	entries, err := os.ReadDir(directory)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, len(entries))
+	for index, entry := range entries {
+		names[index] = entry.Name()
+	}
+	return names, nil
+}
+
+func (OSFileSystem) MkdirAll(directory string) error { return os.MkdirAll(directory, 0755) }
@@ -0,0 +1,577 @@
+package pagestore
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/VorobevPavel-dev/congenial-disco/lock"
+)
+
+var errCrashed = errors.New("process is killed")
+
+// operation is a write to file not synced yet, negative size truncates
+// file instead
+type operation struct {
+	offset int64
+	data   []byte
+	size   int64
+}
+
+func (o operation) apply(content []byte) []byte {
+	if o.size >= 0 {
+		if int64(len(content)) > o.size {
+			return content[:o.size]
+		}
+		return append(content, make([]byte, o.size-int64(len(content)))...)
+	}
+	if end := o.offset + int64(len(o.data)); end > int64(len(content)) {
+		content = append(content, make([]byte, end-int64(len(content)))...)
+	}
+	copy(content[o.offset:], o.data)
+	return content
+}
+
+type memoryFile struct {
+	durable []byte
+	current []byte
+	pending []operation
+}
+
+// faultFS is a FileSystem in memory which kills process at the given write
+// point. Every write, truncation, sync, creation, removal and rename is a
+// write point. Writes which were not synced reach disk partially: every of
+// them is either lost, torn or written completely
+type faultFS struct {
+	mutex   sync.Mutex
+	files   map[string]*memoryFile
+	random  *rand.Rand
+	points  int
+	crashAt int
+	crashed bool
+}
+
+func newFaultFS(seed int64) *faultFS {
+	return &faultFS{files: map[string]*memoryFile{}, random: rand.New(rand.NewSource(seed))}
+}
+
+// point passes write point, it returns errCrashed once process is killed
+func (fs *faultFS) point() error {
+	if fs.crashed {
+		return errCrashed
+	}
+	fs.points++
+	if fs.points == fs.crashAt {
+		fs.crashed = true
+		return errCrashed
+	}
+	return nil
+}
+
+// restart returns file system with content of files left on disk by crash
+func (fs *faultFS) restart() *faultFS {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+	fs.crashed = true
+	result := &faultFS{files: map[string]*memoryFile{}, random: fs.random}
+	for name, file := range fs.files {
+		content := append([]byte{}, file.durable...)
+		for _, written := range file.pending {
+			switch fs.random.Intn(3) {
+			case 0:
+				continue
+			case 1:
+				if written.size < 0 {
+					written.data = written.data[:fs.random.Intn(len(written.data)+1)]
+				}
+			}
+			content = written.apply(content)
+		}
+		result.files[name] = &memoryFile{durable: content, current: append([]byte{}, content...)}
+	}
+	return result
+}
+
+type faultFile struct {
+	fs   *faultFS
+	file *memoryFile
+}
+
+func (fs *faultFS) Open(name string) (File, error) {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+	file, ok := fs.files[name]
+	if !ok {
+		if err := fs.point(); err != nil {
+			return nil, err
+		}
+		file = &memoryFile{}
+		fs.files[name] = file
+	}
+	return &faultFile{fs: fs, file: file}, nil
+}
+
+func (fs *faultFS) Remove(name string) error {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+	if err := fs.point(); err != nil {
+		return err
+	}
+	if _, ok := fs.files[name]; !ok {
+		return os.ErrNotExist
+	}
+	delete(fs.files, name)
+	return nil
+}
+
+func (fs *faultFS) Rename(from, to string) error {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+	if err := fs.point(); err != nil {
+		return err
+	}
+	file, ok := fs.files[from]
+	if !ok {
+		return os.ErrNotExist
+	}
+	delete(fs.files, from)
+	fs.files[to] = file
+	return nil
+}
+
+func (fs *faultFS) List(directory string) ([]string, error) {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+	var names []string
+	for name := range fs.files {
+		if filepath.Dir(name) == filepath.Clean(directory) {
+			names = append(names, filepath.Base(name))
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (fs *faultFS) MkdirAll(directory string) error { return nil }
+
+func (f *faultFile) ReadAt(data []byte, offset int64) (int, error) {
+	f.fs.mutex.Lock()
+	defer f.fs.mutex.Unlock()
+	if f.fs.crashed {
+		return 0, errCrashed
+	}
+	if offset >= int64(len(f.file.current)) {
+		return 0, io.EOF
+	}
+	count := copy(data, f.file.current[offset:])
+	if count < len(data) {
+		return count, io.EOF
+	}
+	return count, nil
+}
+
+// change applies operation to file, operation interrupted by crash is
+// applied partially
+func (f *faultFile) change(changed operation) error {
+	f.fs.mutex.Lock()
+	defer f.fs.mutex.Unlock()
+	if f.fs.crashed {
+		return errCrashed
+	}
+	if err := f.fs.point(); err != nil {
+		// Write interrupted by kill reaches disk partially
+		if changed.size < 0 {
+			changed.data = append([]byte{}, changed.data[:f.fs.random.Intn(len(changed.data)+1)]...)
+			f.file.pending = append(f.file.pending, changed)
+		}
+		return err
+	}
+	changed.data = append([]byte{}, changed.data...)
+	f.file.current = changed.apply(f.file.current)
+	f.file.pending = append(f.file.pending, changed)
+	return nil
+}
+
+func (f *faultFile) WriteAt(data []byte, offset int64) (int, error) {
+	if err := f.change(operation{offset: offset, data: data, size: -1}); err != nil {
+		return 0, err
+	}
+	return len(data), nil
+}
+
+func (f *faultFile) Truncate(size int64) error {
+	return f.change(operation{size: size})
+}
+
+func (f *faultFile) Size() (int64, error) {
+	f.fs.mutex.Lock()
+	defer f.fs.mutex.Unlock()
+	if f.fs.crashed {
+		return 0, errCrashed
+	}
+	return int64(len(f.file.current)), nil
+}
+
+func (f *faultFile) Sync() error {
+	f.fs.mutex.Lock()
+	defer f.fs.mutex.Unlock()
+	if err := f.fs.point(); err != nil {
+		return err
+	}
+	f.file.durable = append(f.file.durable[:0], f.file.current...)
+	f.file.pending = nil
+	return nil
+}
+
+func (f *faultFile) Close() error { return nil }
+
+func encodeValue(value uint64) []byte {
+	data := make([]byte, 8)
+	binary.LittleEndian.PutUint64(data, value)
+	return data
+}
+
+func TestRecord(t *testing.T) {
+	inputs := []*record{
+		{kind: updateRecord, transaction: 7, previous: 100, page: 3, offset: 12, before: []byte("old"), after: []byte("new")},
+		{kind: compensationRecord, transaction: 7, previous: 200, page: 3, offset: 12, undoNext: 100, after: []byte("old")},
+		{kind: checkpointRecord, transaction: 9, transactions: []activeTransaction{{id: 7, last: 200}}, pages: []dirtyPage{{page: 3, recovery: 50}}},
+		{kind: endRecord, transaction: 8, previous: 40},
+	}
+	for testCase, input := range inputs {
+		encoded := input.encode(nil)
+		actual, err := decodeRecord(10, encoded[recordHeaderSize:])
+		if err != nil {
+			t.Errorf("Decoding failed on set #%d: %v", testCase, err)
+			continue
+		}
+		if actual.kind != input.kind || actual.transaction != input.transaction || actual.previous != input.previous ||
+			actual.undoNext != input.undoNext || string(actual.before) != string(input.before) || string(actual.after) != string(input.after) ||
+			len(actual.transactions) != len(input.transactions) || len(actual.pages) != len(input.pages) || actual.size != len(encoded) {
+			t.Errorf("Assertion failed on set #%d. Expected: %+v, got: %+v", testCase, input, actual)
+		}
+	}
+	if _, err := decodeRecord(10, []byte{byte(checkpointRecord) + 1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}); err == nil {
+		t.Errorf("Expected error on unknown kind of record")
+	}
+}
+
+func TestStore(t *testing.T) {
+	directory := t.TempDir()
+	store, err := Open(directory, &Options{CachePages: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	committed, _ := store.Begin()
+	for page := uint64(0); page < 5; page++ {
+		if err := committed.Write(page, 100, []byte("committed")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := committed.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if err := committed.Write(0, 0, []byte("late")); err != ErrTransactionDone {
+		t.Errorf("Expected error on write of committed transaction, got: %v", err)
+	}
+	if _, err := committed.Read(1, 0, 4); err != ErrTransactionDone {
+		t.Errorf("Expected error on read of committed transaction, got: %v", err)
+	}
+	// Late write and read leave no locks behind
+	store.options.LockTimeout = time.Millisecond
+	free, _ := store.Begin()
+	if err := free.Write(0, 0, []byte("free")); err != nil {
+		t.Errorf("Expected page not to be locked by committed transaction, got: %v", err)
+	}
+	if err := free.Write(1, 0, []byte("free")); err != nil {
+		t.Errorf("Expected page not to be locked by committed transaction, got: %v", err)
+	}
+	free.Rollback()
+	store.options.LockTimeout = 0
+	rolledBack, _ := store.Begin()
+	rolledBack.Write(1, 100, []byte("rollback!"))
+	rolledBack.Write(1, 100, []byte("twice...."))
+	if err := rolledBack.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	open, _ := store.Begin()
+	open.Write(2, 100, []byte("uncommitted"))
+	if err := open.Write(2, PageDataSize-2, []byte("out")); err == nil {
+		t.Errorf("Expected error on write out of page")
+	}
+	blocked, _ := store.Begin()
+	store.options.LockTimeout = time.Millisecond
+	if _, err := blocked.Read(2, 100, 9); err == nil {
+		t.Errorf("Expected page locked by another transaction to be unavailable")
+	}
+	blocked.Rollback()
+	if err := store.Checkpoint(); err != nil {
+		t.Fatal(err)
+	}
+	// Closing store without committing leaves transaction to recovery
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+	store, err = Open(directory, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	reader, _ := store.Begin()
+	defer reader.Rollback()
+	for page := uint64(0); page < 5; page++ {
+		if data, err := reader.Read(page, 100, 11); err != nil || string(data[:9]) != "committed" {
+			t.Errorf("Expected committed data on page %d, got: %q %v", page, data, err)
+		}
+	}
+}
+
+func TestDeadlock(t *testing.T) {
+	// Transactions wait for locks forever, only deadlock detection stops them
+	store, err := Open(t.TempDir(), &Options{DeadlockInterval: time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	first, _ := store.Begin()
+	second, _ := store.Begin()
+	if err := first.Write(1, 0, []byte("first")); err != nil {
+		t.Fatal(err)
+	}
+	if err := second.Write(2, 0, []byte("second")); err != nil {
+		t.Fatal(err)
+	}
+	// Transactions write pages locked by each other in opposite order
+	errs := make(chan error)
+	for _, write := range []func() error{
+		func() error { return first.Write(2, 0, []byte("first")) },
+		func() error { return second.Write(1, 0, []byte("second")) },
+	} {
+		go func(write func() error) { errs <- write() }(write)
+	}
+	// The younger transaction is the victim, its rollback lets the older one
+	// continue
+	if err := <-errs; err != lock.ErrDeadlock {
+		t.Fatalf("Expected deadlock, got: %v", err)
+	}
+	if err := second.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errs; err != nil {
+		t.Fatalf("Expected write of the older transaction to succeed, got: %v", err)
+	}
+	if err := first.Commit(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCheckpoint(t *testing.T) {
+	fs := newFaultFS(1)
+	store, err := Open("store", &Options{FileSystem: fs})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	segments := func() int {
+		names, _ := fs.List("store")
+		var count int
+		for _, name := range names {
+			if strings.HasPrefix(name, segmentPrefix) {
+				count++
+			}
+		}
+		return count
+	}
+	// Long transaction keeps log it needs for undo
+	long, _ := store.Begin()
+	long.Write(1000, 0, []byte("long"))
+	stop := store.CheckpointEvery(time.Millisecond)
+	for index := 0; index < 200; index++ {
+		transaction, _ := store.Begin()
+		if err := transaction.Write(uint64(index%10), 0, encodeValue(uint64(index))); err != nil {
+			t.Fatal(err)
+		}
+		if err := transaction.Commit(); err != nil {
+			t.Fatal(err)
+		}
+		if index%50 == 0 {
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+	stop()
+	if err := store.Checkpoint(); err != nil {
+		t.Fatal(err)
+	}
+	store.mutex.Lock()
+	start := store.log.start()
+	store.mutex.Unlock()
+	if start > long.first {
+		t.Errorf("Expected log from %d to be kept for active transaction, it starts at %d", long.first, start)
+	}
+	long.Commit()
+	if err := store.Checkpoint(); err != nil {
+		t.Fatal(err)
+	}
+	if count := segments(); count != 1 {
+		t.Errorf("Expected log to be truncated to one segment, got %d", count)
+	}
+	store.mutex.Lock()
+	dirty := len(store.dirty)
+	store.mutex.Unlock()
+	if dirty != 0 {
+		t.Errorf("Expected checkpoint to write all dirty pages, %d are left", dirty)
+	}
+}
+
+// TestCrashRecovery kills store at random write points and checks that
+// committed transactions survive and the others leave no trace
+func TestCrashRecovery(t *testing.T) {
+	const (
+		pages     = 8
+		slots     = 16
+		steps     = 60
+		crashRuns = 300
+	)
+	random := rand.New(rand.NewSource(42))
+	for run := 0; run < crashRuns; run++ {
+		fs := newFaultFS(int64(run))
+		fs.crashAt = 1 + random.Intn(500)
+		var (
+			committed = map[[2]int]uint64{}
+			// inDoubt are writes of transaction which commit was interrupted
+			inDoubt = map[[2]int]uint64{}
+			value   = uint64(1)
+		)
+		// Every run restarts store a few times, the last crash is at the end
+		for restart := 0; restart < 3; restart++ {
+			store, err := Open("store", &Options{FileSystem: fs, CachePages: 4, LockTimeout: time.Millisecond})
+			if err != nil {
+				if err != errCrashed {
+					t.Fatalf("Recovery failed on run #%d: %v", run, err)
+				}
+				fs = fs.restart()
+				continue
+			}
+			if len(inDoubt) != 0 {
+				var survived int
+				for _, key := range sortedKeys(inDoubt) {
+					if readValue(t, store, key) == inDoubt[key] {
+						survived++
+					}
+				}
+				if survived != 0 && survived != len(inDoubt) {
+					t.Fatalf("Transaction interrupted by crash is partially applied on run #%d", run)
+				}
+				if survived != 0 {
+					for key, written := range inDoubt {
+						committed[key] = written
+					}
+				}
+				inDoubt = map[[2]int]uint64{}
+			}
+			for page := 0; page < pages; page++ {
+				for slot := 0; slot < slots; slot++ {
+					key := [2]int{page, slot}
+					if actual := readValue(t, store, key); actual != committed[key] {
+						t.Fatalf("Assertion failed on run #%d, restart %d, page %d, slot %d. Expected: %d, got: %d",
+							run, restart, page, slot, committed[key], actual)
+					}
+				}
+			}
+
+			var open []*Transaction
+			locked := map[int]bool{}
+		workload:
+			for step := 0; step < steps; step++ {
+				if random.Intn(10) == 0 {
+					if err := store.Checkpoint(); err != nil {
+						break workload
+					}
+					continue
+				}
+				transaction, err := store.Begin()
+				if err != nil {
+					break workload
+				}
+				writes := map[[2]int]uint64{}
+				for count := 1 + random.Intn(4); count > 0; count-- {
+					page := random.Intn(pages)
+					if locked[page] {
+						continue
+					}
+					key := [2]int{page, random.Intn(slots)}
+					writes[key] = value
+					if err := transaction.Write(uint64(page), key[1]*8, encodeValue(value)); err != nil {
+						break workload
+					}
+					value++
+				}
+				switch choice := random.Intn(10); {
+				case choice < 6:
+					if err := transaction.Commit(); err != nil {
+						inDoubt = writes
+						break workload
+					}
+					for key, written := range writes {
+						committed[key] = written
+					}
+				case choice < 8:
+					if err := transaction.Rollback(); err != nil {
+						break workload
+					}
+				case len(open) < 2:
+					// Transaction stays open until crash
+					open = append(open, transaction)
+					for key := range writes {
+						locked[key[0]] = true
+					}
+				default:
+					if err := transaction.Rollback(); err != nil {
+						break workload
+					}
+				}
+			}
+			// Process is killed at the end if it was not killed before
+			fs.mutex.Lock()
+			fs.crashed = true
+			fs.mutex.Unlock()
+			fs = fs.restart()
+			if restart == 1 {
+				fs.crashAt = 1 + random.Intn(100)
+			}
+		}
+	}
+}
+
+func sortedKeys(values map[[2]int]uint64) [][2]int {
+	var keys [][2]int
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i][0] < keys[j][0] || (keys[i][0] == keys[j][0] && keys[i][1] < keys[j][1])
+	})
+	return keys
+}
+
+func readValue(t *testing.T, store *Store, key [2]int) uint64 {
+	t.Helper()
+	transaction, err := store.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer transaction.Rollback()
+	data, err := transaction.Read(uint64(key[0]), key[1]*8, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return binary.LittleEndian.Uint64(data)
+}
@@ -0,0 +1,144 @@
+package pagestore
+
+import (
+	"github.com/VorobevPavel-dev/congenial-disco/lock"
+)
+
+// Open opens store in directory and recovers it after crash
+func Open(directory string, options *Options) (*Store, error) {
+	options = options.withDefaults()
+	fs := options.FileSystem
+	if err := fs.MkdirAll(directory); err != nil {
+		return nil, err
+	}
+	s := &Store{
+		directory:       directory,
+		options:         options,
+		locks:           lock.NewManager(),
+		pages:           map[uint64][]byte{},
+		dirty:           map[uint64]LSN{},
+		transactions:    map[uint64]*Transaction{},
+		nextTransaction: 1,
+	}
+	checkpoint, err := readMaster(fs, s.path(masterFile))
+	if err != nil {
+		return nil, err
+	}
+	if s.data, err = fs.Open(s.path(dataFile)); err != nil {
+		return nil, err
+	}
+	if s.log, err = openWAL(fs, directory); err != nil {
+		s.data.Close()
+		return nil, err
+	}
+	if err := s.recover(checkpoint); err != nil {
+		s.log.close()
+		s.data.Close()
+		return nil, err
+	}
+	s.stopDetection = s.locks.DetectDeadlocksEvery(options.DeadlockInterval)
+	if err := s.Checkpoint(); err != nil {
+		s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// recover brings store to the state of the last durable record of log.
+// Analysis finds transactions active at crash and dirty pages, redo repeats
+// history from the oldest change which may be not on disk and undo rolls
+// back transactions which did not commit
+func (s *Store) recover(checkpoint LSN) error {
+	start := checkpoint
+	if start == 0 {
+		start = s.log.start()
+	}
+	committed := map[uint64]bool{}
+	end, err := s.log.scan(start, func(current *record) error {
+		if current.kind == checkpointRecord {
+			s.nextTransaction = current.transaction
+			for _, active := range current.transactions {
+				s.transactions[active.id] = &Transaction{store: s, id: active.id, last: active.last}
+			}
+			for _, dirty := range current.pages {
+				s.dirty[dirty.page] = dirty.recovery
+			}
+			return nil
+		}
+		if id := current.transaction; id != 0 {
+			transaction, ok := s.transactions[id]
+			if !ok {
+				transaction = &Transaction{store: s, id: id}
+				s.transactions[id] = transaction
+			}
+			transaction.last = current.lsn
+			if id >= s.nextTransaction {
+				s.nextTransaction = id + 1
+			}
+		}
+		switch current.kind {
+		case updateRecord, compensationRecord, imageRecord:
+			if _, dirty := s.dirty[current.page]; !dirty {
+				s.dirty[current.page] = current.lsn
+			}
+		case commitRecord:
+			committed[current.transaction] = true
+		case endRecord:
+			delete(s.transactions, current.transaction)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if err := s.log.truncateTail(end); err != nil {
+		return err
+	}
+
+	redo := end
+	for _, recovery := range s.dirty {
+		if recovery < redo {
+			redo = recovery
+		}
+	}
+	if redo < s.log.start() {
+		redo = s.log.start()
+	}
+	_, err = s.log.scan(redo, func(current *record) error {
+		if current.kind != updateRecord && current.kind != compensationRecord && current.kind != imageRecord {
+			return nil
+		}
+		if recovery, dirty := s.dirty[current.page]; !dirty || current.lsn < recovery {
+			return nil
+		}
+		page, err := s.page(current.page)
+		if err != nil {
+			return err
+		}
+		// Page on disk may be torn, image replaces it regardless of its LSN
+		if current.kind == imageRecord {
+			copy(page, current.after)
+			return nil
+		}
+		if pageLSN(page) < current.lsn {
+			copy(page[pageHeader+current.offset:], current.after)
+			setPageLSN(page, current.lsn)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Pages are locked by at most one transaction, so losers are undone one
+	// by one
+	for id, transaction := range s.transactions {
+		if !committed[id] {
+			if err := s.undo(transaction); err != nil {
+				return err
+			}
+		}
+		s.end(transaction)
+	}
+	return s.log.flush(s.log.end())
+}
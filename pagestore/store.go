@@ -0,0 +1,373 @@
+// Package pagestore stores fixed-size pages changed by transactions. Changes
+// are logged to the write-ahead log before pages reach disk, checkpoints
+// write dirty pages and cut the log, and recovery follows ARIES: analysis of
+// the log from the last checkpoint, redo of history and undo of
+// transactions which did not commit.
+//
+// Package is a building block of page-based storage engine: neither heap nor
+// LSM engine keeps tables in it yet, so the database does not use it.
+package pagestore
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/VorobevPavel-dev/congenial-disco/lock"
+)
+
+const (
+	// PageSize is a size of page on disk
+	PageSize = 4096
+	// pageHeader keeps LSN of the last record applied to page
+	pageHeader = 8
+	// PageDataSize is a number of bytes of page available to transactions
+	PageDataSize = PageSize - pageHeader
+)
+
+const dataFile = "data"
+
+var (
+	// ErrClosed is returned by operations of closed store
+	ErrClosed = errors.New("store is closed")
+	// ErrTransactionDone is returned by operations of committed or rolled
+	// back transaction
+	ErrTransactionDone = errors.New("transaction is already committed or rolled back")
+)
+
+// Options configure store. Zero fields take default values
+type Options struct {
+	// FileSystem keeps files of store, OSFileSystem by default
+	FileSystem FileSystem
+	// CachePages is a number of pages kept in memory
+	CachePages int
+	// LockTimeout limits waiting for locks of pages, zero means waiting
+	// forever
+	LockTimeout time.Duration
+	// DeadlockInterval is a period of deadlock detection, 100 milliseconds
+	// by default. Transactions waiting for each other are not stuck even
+	// without lock timeout: one of them gets lock.ErrDeadlock
+	DeadlockInterval time.Duration
+}
+
+func (o *Options) withDefaults() *Options {
+	result := Options{}
+	if o != nil {
+		result = *o
+	}
+	if result.FileSystem == nil {
+		result.FileSystem = OSFileSystem{}
+	}
+	if result.CachePages <= 0 {
+		result.CachePages = 1024
+	}
+	if result.DeadlockInterval <= 0 {
+		result.DeadlockInterval = 100 * time.Millisecond
+	}
+	return &result
+}
+
+func pageLSN(page []byte) LSN { return LSN(binary.LittleEndian.Uint64(page)) }
+
+func setPageLSN(page []byte, lsn LSN) { binary.LittleEndian.PutUint64(page, uint64(lsn)) }
+
+// Store is a file of pages. It is safe for concurrent use
+type Store struct {
+	directory string
+	options   *Options
+	locks     *lock.Manager
+	// stopDetection stops deadlock detection
+	stopDetection func()
+
+	mutex  sync.Mutex
+	closed bool
+	data   File
+	log    *wal
+	// pages are cached pages
+	pages map[uint64][]byte
+	// dirty maps pages changed after they were synced to disk to their
+	// recovery LSNs. Pages leave it only once their latest versions are
+	// synced, so redo always starts from image of page
+	dirty map[uint64]LSN
+	// transactions are active transactions
+	transactions    map[uint64]*Transaction
+	nextTransaction uint64
+
+	// checkpointing lets only one checkpoint run at once
+	checkpointing sync.Mutex
+}
+
+func (s *Store) path(name string) string { return filepath.Join(s.directory, name) }
+
+// page returns cached page reading it from disk if needed. Pages behind the
+// end of data file are zero
+func (s *Store) page(number uint64) ([]byte, error) {
+	if page, ok := s.pages[number]; ok {
+		return page, nil
+	}
+	if err := s.evict(); err != nil {
+		return nil, err
+	}
+	page := make([]byte, PageSize)
+	size, err := s.data.Size()
+	if err != nil {
+		return nil, err
+	}
+	if offset := int64(number) * PageSize; offset < size {
+		if _, err := s.data.ReadAt(page, offset); err != nil && offset+PageSize <= size {
+			return nil, err
+		}
+	}
+	s.pages[number] = page
+	return page, nil
+}
+
+// evict drops a page from full cache. Clean pages are dropped first, dirty
+// page is written to disk, but stays dirty until it is synced by checkpoint
+func (s *Store) evict() error {
+	if len(s.pages) < s.options.CachePages {
+		return nil
+	}
+	for number := range s.pages {
+		if _, dirty := s.dirty[number]; !dirty {
+			delete(s.pages, number)
+			return nil
+		}
+	}
+	for number := range s.pages {
+		if err := s.writePage(number); err != nil {
+			return err
+		}
+		delete(s.pages, number)
+		return nil
+	}
+	return nil
+}
+
+// writePage writes cached page to data file after records of its changes
+// are flushed to log
+func (s *Store) writePage(number uint64) error {
+	page := s.pages[number]
+	if err := s.log.flush(pageLSN(page)); err != nil {
+		return err
+	}
+	_, err := s.data.WriteAt(page, int64(number)*PageSize)
+	return err
+}
+
+// modify logs change of page by transaction and applies it. The first change
+// of clean page is preceded by its image
+func (s *Store) modify(transaction *Transaction, kind recordKind, number uint64, offset int, after []byte, undoNext LSN) error {
+	page, err := s.page(number)
+	if err != nil {
+		return err
+	}
+	if _, dirty := s.dirty[number]; !dirty {
+		image := &record{kind: imageRecord, page: number, after: append([]byte{}, page...)}
+		s.dirty[number] = s.log.append(image)
+	}
+	change := &record{
+		kind:        kind,
+		transaction: transaction.id,
+		previous:    transaction.last,
+		page:        number,
+		offset:      offset,
+		undoNext:    undoNext,
+		after:       append([]byte{}, after...),
+	}
+	if kind == updateRecord {
+		change.before = append([]byte{}, page[pageHeader+offset:pageHeader+offset+len(after)]...)
+	}
+	lsn := s.log.append(change)
+	copy(page[pageHeader+offset:], after)
+	setPageLSN(page, lsn)
+	transaction.last = lsn
+	if transaction.first == 0 {
+		transaction.first = lsn
+	}
+	return nil
+}
+
+// undo rolls changes of transaction back logging compensation records, so
+// that undo is not repeated if crash interrupts it
+func (s *Store) undo(transaction *Transaction) error {
+	for next := transaction.last; next != 0; {
+		current, err := s.log.read(next)
+		if err != nil {
+			return err
+		}
+		switch current.kind {
+		case updateRecord:
+			if err := s.modify(transaction, compensationRecord, current.page, current.offset, current.before, current.previous); err != nil {
+				return err
+			}
+			next = current.previous
+		case compensationRecord:
+			next = current.undoNext
+		default:
+			next = current.previous
+		}
+	}
+	return nil
+}
+
+// end logs the end of transaction and forgets it
+func (s *Store) end(transaction *Transaction) {
+	s.log.append(&record{kind: endRecord, transaction: transaction.id, previous: transaction.last})
+	delete(s.transactions, transaction.id)
+	transaction.done = true
+}
+
+// Begin starts transaction
+func (s *Store) Begin() (*Transaction, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.closed {
+		return nil, ErrClosed
+	}
+	transaction := &Transaction{store: s, id: s.nextTransaction}
+	s.nextTransaction++
+	s.transactions[transaction.id] = transaction
+	return transaction, nil
+}
+
+// Close closes store. Dirty pages are not written, the log has all their
+// changes
+func (s *Store) Close() error {
+	s.checkpointing.Lock()
+	defer s.checkpointing.Unlock()
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.closed {
+		return ErrClosed
+	}
+	s.closed = true
+	s.stopDetection()
+	err := s.log.flush(s.log.end())
+	if closeErr := s.log.close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := s.data.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Transaction changes pages atomically. Pages it reads and changes are
+// locked until it ends
+type Transaction struct {
+	store *Store
+	id    uint64
+	// first and last are LSNs of the first and the last records of
+	// transaction
+	first, last LSN
+	done        bool
+}
+
+func pageResource(number uint64) lock.Resource {
+	return lock.Row(dataFile, strconv.FormatUint(number, 10))
+}
+
+// check validates range of page data
+func check(offset, length int) error {
+	if offset < 0 || length < 0 || offset+length > PageDataSize {
+		return fmt.Errorf("range from %d of %d bytes is out of page", offset, length)
+	}
+	return nil
+}
+
+// Read returns length bytes of page starting from offset
+func (t *Transaction) Read(number uint64, offset, length int) ([]byte, error) {
+	if err := check(offset, length); err != nil {
+		return nil, err
+	}
+	if err := t.lock(number, lock.Shared); err != nil {
+		return nil, err
+	}
+	t.store.mutex.Lock()
+	defer t.store.mutex.Unlock()
+	if err := t.checkDone(); err != nil {
+		return nil, err
+	}
+	page, err := t.store.page(number)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte{}, page[pageHeader+offset:pageHeader+offset+length]...), nil
+}
+
+// Write changes bytes of page starting from offset
+func (t *Transaction) Write(number uint64, offset int, data []byte) error {
+	if err := check(offset, len(data)); err != nil {
+		return err
+	}
+	if err := t.lock(number, lock.Exclusive); err != nil {
+		return err
+	}
+	t.store.mutex.Lock()
+	defer t.store.mutex.Unlock()
+	if err := t.checkDone(); err != nil {
+		return err
+	}
+	return t.store.modify(t, updateRecord, number, offset, data, 0)
+}
+
+// lock locks page for transaction. Locks are not taken by transactions which
+// are done, since nothing would release them
+func (t *Transaction) lock(number uint64, mode lock.Mode) error {
+	t.store.mutex.Lock()
+	done := t.done
+	t.store.mutex.Unlock()
+	if done {
+		return ErrTransactionDone
+	}
+	return t.store.locks.Lock(lock.TransactionID(t.id), pageResource(number), mode, t.store.options.LockTimeout)
+}
+
+// checkDone returns ErrTransactionDone if transaction ended while it waited
+// for lock, releasing the lock it got. Store mutex must be held
+func (t *Transaction) checkDone() error {
+	if !t.done {
+		return nil
+	}
+	t.store.locks.Release(lock.TransactionID(t.id))
+	return ErrTransactionDone
+}
+
+// Commit makes changes of transaction durable
+func (t *Transaction) Commit() error {
+	s := t.store
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if t.done {
+		return ErrTransactionDone
+	}
+	t.last = s.log.append(&record{kind: commitRecord, transaction: t.id, previous: t.last})
+	if err := s.log.flush(t.last); err != nil {
+		return err
+	}
+	s.end(t)
+	s.locks.Release(lock.TransactionID(t.id))
+	return nil
+}
+
+// Rollback undoes changes of transaction
+func (t *Transaction) Rollback() error {
+	s := t.store
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if t.done {
+		return ErrTransactionDone
+	}
+	t.last = s.log.append(&record{kind: abortRecord, transaction: t.id, previous: t.last})
+	if err := s.undo(t); err != nil {
+		return err
+	}
+	s.end(t)
+	s.locks.Release(lock.TransactionID(t.id))
+	return nil
+}
@@ -0,0 +1,351 @@
+package pagestore
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/VorobevPavel-dev/congenial-disco/utility"
+)
+
+// LSN is a log sequence number, position of record in the write-ahead log.
+// Zero LSN refers to no record
+type LSN uint64
+
+// Kinds of log records
+type recordKind byte
+
+const (
+	// updateRecord changes bytes of page by transaction, it holds bytes
+	// before and after change to undo and redo it
+	updateRecord recordKind = iota + 1
+	// compensationRecord redoes undo of update, its undoNext is the next
+	// record of transaction to undo
+	compensationRecord
+	// imageRecord holds page before the first change after the page was
+	// written to disk. Redo starts from it, so pages torn by crash are
+	// restored
+	imageRecord
+	commitRecord
+	abortRecord
+	// endRecord follows the last record of committed or rolled back
+	// transaction
+	endRecord
+	// checkpointRecord lists active transactions and dirty pages
+	checkpointRecord
+)
+
+// record is a record of log. Fields not used by kind of record are zero
+type record struct {
+	lsn  LSN
+	size int
+	kind recordKind
+	// transaction is zero for images, checkpoint keeps the next identifier
+	// of transaction in it
+	transaction uint64
+	// previous is the previous record of the same transaction
+	previous LSN
+	page     uint64
+	offset   int
+	undoNext LSN
+	before   []byte
+	after    []byte
+	// active transactions and dirty pages of checkpoint
+	transactions []activeTransaction
+	pages        []dirtyPage
+}
+
+type activeTransaction struct {
+	id   uint64
+	last LSN
+}
+
+// dirtyPage is a page changed after it was written to disk. Its recovery
+// LSN is the first record which may be not applied to page on disk
+type dirtyPage struct {
+	page     uint64
+	recovery LSN
+}
+
+// Every record is stored as:
+//
+//	crc of payload (4 bytes) | length of payload (4 bytes) | payload
+//
+// A record torn by crash fails its checksum, so the log ends before it
+const (
+	recordHeaderSize = 8
+	maxRecordSize    = 1 << 24
+)
+
+var errInvalidRecord = errors.New("invalid log record")
+
+func (r *record) encode(buffer []byte) []byte {
+	start := len(buffer)
+	buffer = append(buffer, make([]byte, recordHeaderSize)...)
+	buffer = append(buffer, byte(r.kind))
+	for _, value := range []uint64{r.transaction, uint64(r.previous), r.page, uint64(r.offset), uint64(r.undoNext)} {
+		buffer = utility.AppendUvarint(buffer, value)
+	}
+	buffer = utility.AppendBytes(buffer, r.before)
+	buffer = utility.AppendBytes(buffer, r.after)
+	buffer = utility.AppendUvarint(buffer, uint64(len(r.transactions)))
+	for _, active := range r.transactions {
+		buffer = utility.AppendUvarint(utility.AppendUvarint(buffer, active.id), uint64(active.last))
+	}
+	buffer = utility.AppendUvarint(buffer, uint64(len(r.pages)))
+	for _, dirty := range r.pages {
+		buffer = utility.AppendUvarint(utility.AppendUvarint(buffer, dirty.page), uint64(dirty.recovery))
+	}
+	payload := buffer[start+recordHeaderSize:]
+	binary.LittleEndian.PutUint32(buffer[start:], crc32.ChecksumIEEE(payload))
+	binary.LittleEndian.PutUint32(buffer[start+4:], uint32(len(payload)))
+	return buffer
+}
+
+func decodeRecord(lsn LSN, payload []byte) (*record, error) {
+	if len(payload) == 0 {
+		return nil, errInvalidRecord
+	}
+	input := utility.NewDecoder(payload[1:], errInvalidRecord)
+	result := &record{
+		lsn:         lsn,
+		size:        recordHeaderSize + len(payload),
+		kind:        recordKind(payload[0]),
+		transaction: input.Uvarint(),
+		previous:    LSN(input.Uvarint()),
+		page:        input.Uvarint(),
+		offset:      int(input.Uvarint()),
+		undoNext:    LSN(input.Uvarint()),
+		before:      input.Bytes(),
+		after:       input.Bytes(),
+	}
+	for count := input.Uvarint(); count > 0 && input.Err() == nil; count-- {
+		result.transactions = append(result.transactions, activeTransaction{id: input.Uvarint(), last: LSN(input.Uvarint())})
+	}
+	for count := input.Uvarint(); count > 0 && input.Err() == nil; count-- {
+		result.pages = append(result.pages, dirtyPage{page: input.Uvarint(), recovery: LSN(input.Uvarint())})
+	}
+	if input.Err() != nil || input.Remaining() != 0 || result.kind < updateRecord || result.kind > checkpointRecord {
+		return nil, errInvalidRecord
+	}
+	return result, nil
+}
+
+// segment is a file of log starting with record of given LSN
+type segment struct {
+	start LSN
+	file  File
+}
+
+const segmentPrefix = "wal-"
+
+// wal is the write-ahead log split into segments, so that its beginning
+// not needed by recovery is removed with whole segments. Appended records
+// are buffered until flush. It is not safe for concurrent use
+type wal struct {
+	fs        FileSystem
+	directory string
+	segments  []*segment
+	// buffer holds records starting from LSN written
+	buffer  []byte
+	written LSN
+}
+
+func segmentPath(directory string, start LSN) string {
+	return filepath.Join(directory, fmt.Sprintf("%s%016x", segmentPrefix, uint64(start)))
+}
+
+// openWAL opens segments of log in directory. Records are read from files
+// until recovery finds the end of log and calls truncateTail
+func openWAL(fs FileSystem, directory string) (*wal, error) {
+	names, err := fs.List(directory)
+	if err != nil {
+		return nil, err
+	}
+	result := &wal{fs: fs, directory: directory}
+	var starts []LSN
+	for _, name := range names {
+		if !strings.HasPrefix(name, segmentPrefix) {
+			continue
+		}
+		start, err := strconv.ParseUint(strings.TrimPrefix(name, segmentPrefix), 16, 64)
+		if err != nil {
+			continue
+		}
+		starts = append(starts, LSN(start))
+	}
+	sort.Slice(starts, func(i, j int) bool { return starts[i] < starts[j] })
+	if len(starts) == 0 {
+		// LSN of the first record is not zero, which refers to no record
+		starts = append(starts, 1)
+	}
+	for _, start := range starts {
+		file, err := fs.Open(segmentPath(directory, start))
+		if err != nil {
+			result.close()
+			return nil, err
+		}
+		result.segments = append(result.segments, &segment{start: start, file: file})
+	}
+	last := result.segments[len(result.segments)-1]
+	size, err := last.file.Size()
+	if err != nil {
+		result.close()
+		return nil, err
+	}
+	result.written = last.start + LSN(size)
+	return result, nil
+}
+
+// start returns LSN of the first record of log
+func (w *wal) start() LSN { return w.segments[0].start }
+
+// end returns LSN of the next appended record
+func (w *wal) end() LSN { return w.written + LSN(len(w.buffer)) }
+
+// append adds record to buffer and returns its LSN
+func (w *wal) append(appended *record) LSN {
+	appended.lsn = w.end()
+	w.buffer = appended.encode(w.buffer)
+	return appended.lsn
+}
+
+// flush writes buffered records up to given LSN and syncs the log
+func (w *wal) flush(upto LSN) error {
+	if upto < w.written || len(w.buffer) == 0 {
+		return nil
+	}
+	last := w.segments[len(w.segments)-1]
+	if _, err := last.file.WriteAt(w.buffer, int64(w.written-last.start)); err != nil {
+		return err
+	}
+	if err := last.file.Sync(); err != nil {
+		return err
+	}
+	w.written += LSN(len(w.buffer))
+	w.buffer = w.buffer[:0]
+	return nil
+}
+
+// read returns record of given LSN, errInvalidRecord means there is no
+// valid record there
+func (w *wal) read(lsn LSN) (*record, error) {
+	if lsn >= w.written {
+		offset := int(lsn - w.written)
+		if offset+recordHeaderSize > len(w.buffer) {
+			return nil, errInvalidRecord
+		}
+		length := int(binary.LittleEndian.Uint32(w.buffer[offset+4:]))
+		return decodeRecord(lsn, w.buffer[offset+recordHeaderSize:offset+recordHeaderSize+length])
+	}
+	index := sort.Search(len(w.segments), func(index int) bool { return w.segments[index].start > lsn }) - 1
+	if index < 0 {
+		return nil, fmt.Errorf("record %d is removed from log", lsn)
+	}
+	current := w.segments[index]
+	header := make([]byte, recordHeaderSize)
+	if _, err := current.file.ReadAt(header, int64(lsn-current.start)); err == io.EOF {
+		return nil, errInvalidRecord
+	} else if err != nil {
+		return nil, err
+	}
+	length := binary.LittleEndian.Uint32(header[4:])
+	if length > maxRecordSize {
+		return nil, errInvalidRecord
+	}
+	payload := make([]byte, length)
+	if _, err := current.file.ReadAt(payload, int64(lsn-current.start)+recordHeaderSize); err == io.EOF {
+		return nil, errInvalidRecord
+	} else if err != nil {
+		return nil, err
+	}
+	if crc32.ChecksumIEEE(payload) != binary.LittleEndian.Uint32(header) {
+		return nil, errInvalidRecord
+	}
+	return decodeRecord(lsn, payload)
+}
+
+// scan passes records starting from given LSN to visit until the first
+// invalid record and returns its LSN
+func (w *wal) scan(from LSN, visit func(*record) error) (LSN, error) {
+	lsn := from
+	for {
+		current, err := w.read(lsn)
+		if err == errInvalidRecord {
+			return lsn, nil
+		}
+		if err != nil {
+			return lsn, err
+		}
+		if err := visit(current); err != nil {
+			return lsn, err
+		}
+		lsn += LSN(current.size)
+	}
+}
+
+// truncateTail cuts off records starting from given LSN, which were torn
+// or not flushed before crash
+func (w *wal) truncateTail(end LSN) error {
+	for len(w.segments) > 1 && w.segments[len(w.segments)-1].start > end {
+		last := w.segments[len(w.segments)-1]
+		last.file.Close()
+		if err := w.fs.Remove(segmentPath(w.directory, last.start)); err != nil {
+			return err
+		}
+		w.segments = w.segments[:len(w.segments)-1]
+	}
+	last := w.segments[len(w.segments)-1]
+	if err := last.file.Truncate(int64(end - last.start)); err != nil {
+		return err
+	}
+	if err := last.file.Sync(); err != nil {
+		return err
+	}
+	w.written, w.buffer = end, w.buffer[:0]
+	return nil
+}
+
+// rotate flushes log and starts a new segment
+func (w *wal) rotate() error {
+	if err := w.flush(w.end()); err != nil {
+		return err
+	}
+	if w.segments[len(w.segments)-1].start == w.written {
+		return nil
+	}
+	file, err := w.fs.Open(segmentPath(w.directory, w.written))
+	if err != nil {
+		return err
+	}
+	w.segments = append(w.segments, &segment{start: w.written, file: file})
+	return nil
+}
+
+// truncateHead removes segments which records are all before given LSN
+func (w *wal) truncateHead(lsn LSN) error {
+	for len(w.segments) > 1 && w.segments[1].start <= lsn {
+		if err := w.fs.Remove(segmentPath(w.directory, w.segments[0].start)); err != nil {
+			return err
+		}
+		w.segments[0].file.Close()
+		w.segments = w.segments[1:]
+	}
+	return nil
+}
+
+func (w *wal) close() error {
+	var result error
+	for _, current := range w.segments {
+		if err := current.file.Close(); err != nil && result == nil {
+			result = err
+		}
+	}
+	return result
+}
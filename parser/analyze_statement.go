@@ -0,0 +1,41 @@
+package parser
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/VorobevPavel-dev/congenial-disco/tokenizer"
+)
+
+// AnalyzeStatement collects statistics of a table or of all tables if table
+// is not specified:
+//
+//	ANALYZE [name];
+type AnalyzeStatement struct {
+	Table *tokenizer.Token `json:"table,omitempty"`
+}
+
+func (as *AnalyzeStatement) String() string {
+	bytes, _ := json.Marshal(as)
+	return string(bytes)
+}
+
+func (as *AnalyzeStatement) Equals(other *AnalyzeStatement) bool {
+	return tokensEqual(as.Table, other.Table)
+}
+
+func parseAnalyzeStatement(tokens []*tokenizer.Token) (*AnalyzeStatement, error) {
+	if !isToken(tokens, 0, tokenizer.TokenFromKeyword("analyze")) {
+		return nil, fmt.Errorf("expected ANALYZE keyword at %d", endPosition(tokens, 0))
+	}
+	statement := &AnalyzeStatement{}
+	position := 1
+	if table := tokenizer.AsIdentifier(tokenAt(tokens, position)); table != nil {
+		statement.Table = table
+		position++
+	}
+	if !isToken(tokens, position, tokenizer.TokenFromSymbol(";")) {
+		return nil, fmt.Errorf("cannot find \";\"  in the end of request")
+	}
+	return statement, nil
+}
@@ -0,0 +1,50 @@
+package parser
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/VorobevPavel-dev/congenial-disco/tokenizer"
+)
+
+// CancelStatement aborts a running query of any session by its id:
+//
+//	CANCEL [QUERY] id;
+//	KILL QUERY id;
+type CancelStatement struct {
+	Query tokenizer.Token `json:"query"`
+}
+
+func (cs *CancelStatement) String() string {
+	bytes, _ := json.Marshal(cs)
+	return string(bytes)
+}
+
+func (cs *CancelStatement) Equals(other *CancelStatement) bool {
+	return cs.Query.Equals(&other.Query)
+}
+
+func parseCancelStatement(tokens []*tokenizer.Token) (*CancelStatement, error) {
+	position := 1
+	switch {
+	case isToken(tokens, 0, tokenizer.TokenFromKeyword("cancel")):
+		if isToken(tokens, position, tokenizer.TokenFromKeyword("query")) {
+			position++
+		}
+	case isToken(tokens, 0, tokenizer.TokenFromKeyword("kill")):
+		if !isToken(tokens, position, tokenizer.TokenFromKeyword("query")) {
+			return nil, fmt.Errorf("expected QUERY keyword at %d", endPosition(tokens, position))
+		}
+		position++
+	default:
+		return nil, fmt.Errorf("expected CANCEL or KILL keyword at %d", endPosition(tokens, 0))
+	}
+	query := tokenAt(tokens, position)
+	if query == nil || query.Kind != tokenizer.NumericKind {
+		return nil, fmt.Errorf("expected query id at %d", endPosition(tokens, position))
+	}
+	if !isToken(tokens, position+1, tokenizer.TokenFromSymbol(";")) {
+		return nil, fmt.Errorf("cannot find \";\"  in the end of request")
+	}
+	return &CancelStatement{Query: *query}, nil
+}
@@ -0,0 +1,169 @@
+package parser
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/VorobevPavel-dev/congenial-disco/tokenizer"
+)
+
+// SetOperator is one of UNION [ALL], INTERSECT, EXCEPT
+type SetOperator struct {
+	Token tokenizer.Token `json:"token"`
+	All   bool            `json:"all"`
+}
+
+func (so *SetOperator) Equals(other *SetOperator) bool {
+	return so.Token.Equals(&other.Token) && so.All == other.All
+}
+
+// CompoundStatement is a chain of SELECT statements combined with set
+// operators, optionally preceded by WITH clause:
+//
+//	[WITH ...] Selects[0] Operators[0] Selects[1] Operators[1] Selects[2] ...
+//
+// All operators have the same precedence and are applied from left to right.
+type CompoundStatement struct {
+	With      *WithClause        `json:"with,omitempty"`
+	Selects   []*SelectStatement `json:"selects"`
+	Operators []*SetOperator     `json:"operators"`
+}
+
+func (cs *CompoundStatement) String() string {
+	bytes, _ := json.Marshal(cs)
+	return string(bytes)
+}
+
+func (cs *CompoundStatement) Equals(other *CompoundStatement) bool {
+	if len(cs.Selects) != len(other.Selects) || len(cs.Operators) != len(other.Operators) {
+		return false
+	}
+	for index := range cs.Selects {
+		if !cs.Selects[index].Equals(other.Selects[index]) {
+			return false
+		}
+	}
+	for index := range cs.Operators {
+		if !cs.Operators[index].Equals(other.Operators[index]) {
+			return false
+		}
+	}
+	return cs.With.Equals(other.With)
+}
+
+// CheckTypes verifies that columns on the same positions of every SELECT have
+// the same type
+func (cs *CompoundStatement) CheckTypes(schema Schema) error {
+	if cs.With != nil {
+		var err error
+		if schema, err = cs.With.Schema(schema); err != nil {
+			return err
+		}
+	}
+	for _, statement := range cs.Selects {
+		if err := statement.CheckTypes(schema); err != nil {
+			return err
+		}
+	}
+	expected, err := cs.Selects[0].ColumnTypes(schema)
+	if err != nil {
+		return err
+	}
+	for index, statement := range cs.Selects[1:] {
+		types, err := statement.ColumnTypes(schema)
+		if err != nil {
+			return err
+		}
+		for column := range types {
+			if !types[column].Equals(expected[column]) {
+				return fmt.Errorf("column #%d of %s has type %s, expected: %s",
+					column+1, cs.Operators[index].Token.Value, types[column].Value, expected[column].Value)
+			}
+		}
+	}
+	return nil
+}
+
+// parseSetOperator parses set operator on given position. Returns nil if there
+// is no set operator
+func parseSetOperator(tokens []*tokenizer.Token, position int) (*SetOperator, int, error) {
+	token := tokenAt(tokens, position)
+	switch {
+	case token == nil:
+		return nil, position, nil
+	case token.Equals(tokenizer.TokenFromKeyword("union")):
+		if isToken(tokens, position+1, tokenizer.TokenFromKeyword("all")) {
+			return &SetOperator{Token: *token, All: true}, position + 2, nil
+		}
+	case token.Equals(tokenizer.TokenFromKeyword("intersect")),
+		token.Equals(tokenizer.TokenFromKeyword("except")):
+		if isToken(tokens, position+1, tokenizer.TokenFromKeyword("all")) {
+			return nil, position, fmt.Errorf("ALL is supported only for UNION at %d", tokens[position+1].Position)
+		}
+	default:
+		return nil, position, nil
+	}
+	return &SetOperator{Token: *token}, position + 1, nil
+}
+
+func parseCompoundStatement(tokens []*tokenizer.Token) (*CompoundStatement, error) {
+	// [WITH ...] SELECT ... {UNION [ALL] | INTERSECT | EXCEPT} SELECT ... ;
+	statement, position, err := parseCompound(tokens, 0)
+	if err != nil {
+		return nil, err
+	}
+	if !isToken(tokens, position, tokenizer.TokenFromSymbol(";")) {
+		return nil, fmt.Errorf("cannot find \";\"  in the end of request")
+	}
+	return statement, nil
+}
+
+// parseCompound parses compound statement starting from given position and
+// returns it with the position of the first token after the statement
+func parseCompound(tokens []*tokenizer.Token, position int) (*CompoundStatement, int, error) {
+	var (
+		statement = &CompoundStatement{}
+		operator  *SetOperator
+		err       error
+	)
+	if isToken(tokens, position, tokenizer.TokenFromKeyword("with")) {
+		statement.With, position, err = parseWithClause(tokens, position)
+		if err != nil {
+			return nil, position, err
+		}
+	}
+	for {
+		var slct *SelectStatement
+		slct, position, err = parseSelect(tokens, position)
+		if err != nil {
+			return nil, position, err
+		}
+		if len(statement.Selects) > 0 && len(slct.Item) != len(statement.Selects[0].Item) {
+			return nil, position, fmt.Errorf("each SELECT of %s must have the same number of columns: expected %d, got: %d",
+				operator.Token.Value, len(statement.Selects[0].Item), len(slct.Item))
+		}
+		statement.Selects = append(statement.Selects, slct)
+
+		operator, position, err = parseSetOperator(tokens, position)
+		if err != nil {
+			return nil, position, err
+		}
+		if operator == nil {
+			break
+		}
+		statement.Operators = append(statement.Operators, operator)
+	}
+	if len(statement.Selects) > 1 {
+		operator := strings.ToUpper(statement.Operators[0].Token.Value)
+		for _, slct := range statement.Selects {
+			switch {
+			case slct.Locking != nil:
+				return nil, position, fmt.Errorf("FOR %s is not allowed with %s", strings.ToUpper(slct.Locking.Value), operator)
+			case slct.OrderBy != nil, slct.Limit != nil:
+				return nil, position, fmt.Errorf("ORDER BY and LIMIT are not allowed with %s", operator)
+			}
+		}
+	}
+	return statement, position, nil
+}
@@ -21,9 +21,19 @@ func (ct *CreateTableStatement) String() string {
 	return string(bytes)
 }
 
+// Storage formats of tables set by WITH (storage = ...) clause
+const (
+	RowStorage    = "row"
+	ColumnStorage = "column"
+	LSMStorage    = "lsm"
+)
+
 type CreateTableStatement struct {
 	Name tokenizer.Token
 	Cols []*ColumnDefinition
+	// Storage is empty for tables created without WITH clause, they are
+	// stored by rows
+	Storage string `json:",omitempty"`
 }
 
 func (ct *CreateTableStatement) Equals(other *CreateTableStatement) bool {
@@ -35,7 +45,7 @@ func (ct *CreateTableStatement) Equals(other *CreateTableStatement) bool {
 			return false
 		}
 	}
-	return ct.Name.Equals(&other.Name)
+	return ct.Name.Equals(&other.Name) && ct.Storage == other.Storage
 }
 
 func parseCreateTableStatement(tokens []*tokenizer.Token) (*CreateTableStatement, error) {
@@ -44,70 +54,116 @@ func parseCreateTableStatement(tokens []*tokenizer.Token) (*CreateTableStatement
 	// 	column2 datatype,
 	// 	column3 datatype,
 	//    ....
-	// );
+	// ) [WITH (storage = row | column | lsm)];
 
 	var (
 		tableName *tokenizer.Token
 		columns   []*ColumnDefinition
+		storage   string
 	)
 
 	currentToken := 0
 
 	// Process CREATE TABLE sequence
-	if !tokens[currentToken].Equals(tokenizer.TokenFromKeyword("create")) {
-		return nil, fmt.Errorf("expected CREATE keyword at %d", tokens[currentToken].Position)
+	if !isToken(tokens, currentToken, tokenizer.TokenFromKeyword("create")) {
+		return nil, fmt.Errorf("expected CREATE keyword at %d", endPosition(tokens, currentToken))
 	}
 	currentToken++
-	if !tokens[currentToken].Equals(tokenizer.TokenFromKeyword("table")) {
-		return nil, fmt.Errorf("expected TABLE keyword at %d", tokens[currentToken].Position)
+	if !isToken(tokens, currentToken, tokenizer.TokenFromKeyword("table")) {
+		return nil, fmt.Errorf("expected TABLE keyword at %d", endPosition(tokens, currentToken))
 	}
 	currentToken++
 
 	// Process table name
-	if tokens[currentToken].Equals(tokenizer.TokenFromSymbol("(")) {
+	if isToken(tokens, currentToken, tokenizer.TokenFromSymbol("(")) {
 		return nil, fmt.Errorf("expected \"(\" keyword at %d", tokens[currentToken].Position)
 	}
-	if tokens[currentToken].Kind != tokenizer.IdentifierKind {
-		return nil, fmt.Errorf("expected table name identifier at %d, got: %s", tokens[currentToken].Position, tokens[currentToken].String())
+	if tableName = tokenizer.AsIdentifier(tokenAt(tokens, currentToken)); tableName == nil {
+		return nil, fmt.Errorf("expected table name identifier at %d", endPosition(tokens, currentToken))
 	}
-	tableName = tokens[currentToken]
 	currentToken++
 
 	// Process set of column definitions
-	if !tokens[currentToken].Equals(tokenizer.TokenFromSymbol("(")) {
-		return nil, fmt.Errorf("expected \"(\" symbol at %d", tokens[currentToken].Position)
+	if !isToken(tokens, currentToken, tokenizer.TokenFromSymbol("(")) {
+		return nil, fmt.Errorf("expected \"(\" symbol at %d", endPosition(tokens, currentToken))
 	}
 	currentToken++
-	for !tokens[currentToken].Equals(tokenizer.TokenFromSymbol(")")) {
-		if currentToken == len(tokens)-1 {
-			return nil, fmt.Errorf("expected \")\" symbol at %d", tokens[currentToken].Position)
+	for !isToken(tokens, currentToken, tokenizer.TokenFromSymbol(")")) {
+		if currentToken >= len(tokens)-1 {
+			return nil, fmt.Errorf("expected \")\" symbol at %d", endPosition(tokens, currentToken))
 		}
 		if tokens[currentToken].Equals(tokenizer.TokenFromSymbol(",")) {
 			currentToken++
 			continue
 		}
 		// Process column name
-		if tokens[currentToken].Kind != tokenizer.IdentifierKind {
+		columnName := tokenizer.AsIdentifier(tokens[currentToken])
+		if columnName == nil {
 			return nil, fmt.Errorf("column names are only can be identifiers, got: %s", tokens[currentToken].String())
 		}
-		columnName := tokens[currentToken]
 		currentToken++
 
 		// Process column type
-		if tokens[currentToken].Kind != tokenizer.TypeKind {
-			return nil, fmt.Errorf("expected type of column, got: %s", tokens[currentToken].String())
+		columnType := tokenAt(tokens, currentToken)
+		if columnType == nil || columnType.Kind != tokenizer.TypeKind {
+			return nil, fmt.Errorf("expected type of column at %d", endPosition(tokens, currentToken))
 		}
-		columnType := tokens[currentToken]
 		columns = append(columns, &ColumnDefinition{Name: *columnName, Datatype: *columnType})
 		currentToken++
 	}
 	currentToken++
+	if isToken(tokens, currentToken, tokenizer.TokenFromKeyword(tokenizer.WithKeyword)) {
+		var err error
+		if storage, currentToken, err = parseStorageOptions(tokens, currentToken+1); err != nil {
+			return nil, err
+		}
+	}
 	if currentToken == len(tokens) {
 		return nil, fmt.Errorf("expected \";\" symbol at the end of request")
 	}
 
 	return &CreateTableStatement{
-		Name: *tableName,
-		Cols: columns,
+		Name:    *tableName,
+		Cols:    columns,
+		Storage: storage,
 	}, nil
 }
+
+// parseStorageOptions parses options of WITH clause starting with "(" at
+// given position and returns storage format and position after ")"
+func parseStorageOptions(tokens []*tokenizer.Token, position int) (string, int, error) {
+	if !isToken(tokens, position, tokenizer.TokenFromSymbol("(")) {
+		return "", position, fmt.Errorf("expected \"(\" symbol at %d", endPosition(tokens, position))
+	}
+	position++
+	var storage string
+	for {
+		option := tokenAt(tokens, position)
+		if option == nil || option.Kind != tokenizer.IdentifierKind {
+			return "", position, fmt.Errorf("expected option name at %d", endPosition(tokens, position))
+		}
+		if option.Value != "storage" {
+			return "", position, fmt.Errorf("unknown option %s at %d", option.Value, option.Position)
+		}
+		if storage != "" {
+			return "", position, fmt.Errorf("option storage is set twice at %d", option.Position)
+		}
+		if !isToken(tokens, position+1, tokenizer.TokenFromSymbol("=")) {
+			return "", position, fmt.Errorf("expected \"=\" symbol at %d", endPosition(tokens, position+1))
+		}
+		value := tokenAt(tokens, position+2)
+		if value == nil || (value.Value != RowStorage && value.Value != ColumnStorage && value.Value != LSMStorage) {
+			return "", position, fmt.Errorf("expected row, column or lsm storage at %d", endPosition(tokens, position+2))
+		}
+		storage = value.Value
+		position += 3
+		if !isToken(tokens, position, tokenizer.TokenFromSymbol(",")) {
+			break
+		}
+		position++
+	}
+	if !isToken(tokens, position, tokenizer.TokenFromSymbol(")")) {
+		return "", position, fmt.Errorf("expected \")\" symbol at %d", endPosition(tokens, position))
+	}
+	return storage, position + 1, nil
+}
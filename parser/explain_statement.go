@@ -0,0 +1,86 @@
+package parser
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/VorobevPavel-dev/congenial-disco/tokenizer"
+)
+
+// Formats of EXPLAIN output
+const (
+	ExplainTextFormat = "text"
+	ExplainJSONFormat = "json"
+)
+
+// ExplainStatement shows plan of statement:
+//
+//	EXPLAIN [ANALYZE] [FORMAT {TEXT | JSON}] statement;
+//
+// With ANALYZE statement is executed and actual number of rows and time spent
+// are shown for every operator.
+type ExplainStatement struct {
+	Analyze   bool       `json:"analyze,omitempty"`
+	Format    string     `json:"format"`
+	Statement *Statement `json:"statement"`
+}
+
+func (es *ExplainStatement) String() string {
+	bytes, _ := json.Marshal(es)
+	return string(bytes)
+}
+
+func (es *ExplainStatement) Equals(other *ExplainStatement) bool {
+	if es.Analyze != other.Analyze || es.Format != other.Format {
+		return false
+	}
+	compound, otherCompound := es.Statement.CompoundStatement, other.Statement.CompoundStatement
+	if compound != nil || otherCompound != nil {
+		return compound != nil && otherCompound != nil && compound.Equals(otherCompound)
+	}
+	return es.Statement.SelectStatement.Equals(other.Statement.SelectStatement)
+}
+
+func parseExplainStatement(tokens []*tokenizer.Token) (*ExplainStatement, error) {
+	if !isToken(tokens, 0, tokenizer.TokenFromKeyword("explain")) {
+		return nil, fmt.Errorf("expected EXPLAIN keyword at %d", endPosition(tokens, 0))
+	}
+	position := 1
+	statement := &ExplainStatement{Format: ExplainTextFormat}
+	if isToken(tokens, position, tokenizer.TokenFromKeyword("analyze")) {
+		statement.Analyze = true
+		position++
+	}
+	if isToken(tokens, position, tokenizer.TokenFromKeyword("format")) {
+		position++
+		// TEXT is a type name and JSON is an identifier, so only values of
+		// tokens are checked
+		format := tokenAt(tokens, position)
+		if format == nil || (format.Kind != tokenizer.TypeKind && format.Kind != tokenizer.IdentifierKind) {
+			return nil, fmt.Errorf("expected TEXT or JSON at %d", endPosition(tokens, position))
+		}
+		switch value := strings.ToLower(format.Value); value {
+		case ExplainTextFormat, ExplainJSONFormat:
+			statement.Format = value
+		default:
+			return nil, fmt.Errorf("unsupported EXPLAIN format %s at %d", format.Value, format.Position)
+		}
+		position++
+	}
+	if !isToken(tokens, position, tokenizer.TokenFromKeyword("select")) &&
+		!isToken(tokens, position, tokenizer.TokenFromKeyword("with")) {
+		return nil, fmt.Errorf("expected SELECT statement to explain at %d", endPosition(tokens, position))
+	}
+	explained, err := parseStatement(tokens[position:])
+	if err != nil {
+		return nil, err
+	}
+	// Only SELECT statements, possibly combined with set operators, can be
+	// planned
+	if explained.SelectStatement == nil && explained.CompoundStatement == nil {
+		return nil, fmt.Errorf("only SELECT statements can be explained")
+	}
+	statement.Statement = explained
+	return statement, nil
+}
@@ -0,0 +1,562 @@
+package parser
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/VorobevPavel-dev/congenial-disco/tokenizer"
+)
+
+const (
+	// LiteralExpression will correspond to numeric and string values (and to
+	// type of CAST)
+	LiteralExpression ExpressionKind = iota
+	// ColumnExpression will correspond to (optionally qualified) column names
+	ColumnExpression
+	// BinaryExpression will correspond to comparisons and AND/OR conjunctions
+	BinaryExpression
+	// UnaryExpression will correspond to NOT
+	UnaryExpression
+	// SubqueryExpression will correspond to scalar subqueries
+	SubqueryExpression
+	// ExistsExpression will correspond to EXISTS (SELECT ...)
+	ExistsExpression
+	// InExpression will correspond to x [NOT] IN (SELECT ...)
+	InExpression
+	// FunctionExpression will correspond to function calls (including window
+	// functions)
+	FunctionExpression
+	// CaseExpression will correspond to CASE [x] WHEN ... THEN ... [ELSE ...] END
+	CaseExpression
+	// LikeExpression will correspond to x [NOT] {LIKE | ILIKE} pattern [ESCAPE e]
+	LikeExpression
+	// BetweenExpression will correspond to x [NOT] BETWEEN low AND high
+	BetweenExpression
+	// ParameterExpression will correspond to placeholders of prepared
+	// statements
+	ParameterExpression
+)
+
+type ExpressionKind uint
+
+// Expression is a node of WHERE clauses and select lists. Which fields are set
+// depends on Kind:
+//
+//	LiteralExpression, ColumnExpression: Token (and Table for qualified columns)
+//	ParameterExpression: Token
+//	BinaryExpression: Token (operator), Left, Right
+//	UnaryExpression: Token (operator), Left
+//	SubqueryExpression, ExistsExpression: Subquery
+//	InExpression: Left, Subquery or Arguments (list of values), Not
+//	FunctionExpression: Token (function name), Arguments, Window
+//	CaseExpression: Left (optional operand), Arguments (WHEN and THEN
+//	  expressions one after another), Right (optional ELSE expression)
+//	LikeExpression: Token (LIKE or ILIKE), Left, Right (pattern), Arguments
+//	  (optional escape string), Not
+//	BetweenExpression: Left, Arguments (lower and upper bounds), Not
+type Expression struct {
+	Kind      ExpressionKind       `json:"kind"`
+	Token     *tokenizer.Token     `json:"token,omitempty"`
+	Table     *tokenizer.Token     `json:"table,omitempty"`
+	Left      *Expression          `json:"left,omitempty"`
+	Right     *Expression          `json:"right,omitempty"`
+	Subquery  *SelectStatement     `json:"subquery,omitempty"`
+	Not       bool                 `json:"not,omitempty"`
+	Arguments []*Expression        `json:"arguments,omitempty"`
+	Window    *WindowSpecification `json:"window,omitempty"`
+}
+
+func (e *Expression) String() string {
+	bytes, _ := json.Marshal(e)
+	return string(bytes)
+}
+
+func (e *Expression) Equals(other *Expression) bool {
+	if e == nil || other == nil {
+		return e == other
+	}
+	if e.Kind != other.Kind || e.Not != other.Not {
+		return false
+	}
+	if !tokensEqual(e.Token, other.Token) || !tokensEqual(e.Table, other.Table) {
+		return false
+	}
+	if !e.Left.Equals(other.Left) || !e.Right.Equals(other.Right) {
+		return false
+	}
+	if !expressionsEqual(e.Arguments, other.Arguments) || !e.Window.Equals(other.Window) {
+		return false
+	}
+	if e.Subquery == nil || other.Subquery == nil {
+		return e.Subquery == other.Subquery
+	}
+	return e.Subquery.Equals(other.Subquery)
+}
+
+// Children returns nested expressions of expression (not including the ones
+// of subqueries)
+func (e *Expression) Children() []*Expression {
+	var result []*Expression
+	for _, child := range []*Expression{e.Left, e.Right} {
+		if child != nil {
+			result = append(result, child)
+		}
+	}
+	result = append(result, e.Arguments...)
+	if e.Window != nil {
+		result = append(result, e.Window.PartitionBy...)
+		for _, term := range e.Window.OrderBy {
+			result = append(result, term.Expression)
+		}
+	}
+	return result
+}
+
+// expressionsEqual compares lists of expressions element by element
+func expressionsEqual(expressions []*Expression, other []*Expression) bool {
+	if len(expressions) != len(other) {
+		return false
+	}
+	for index := range expressions {
+		if !expressions[index].Equals(other[index]) {
+			return false
+		}
+	}
+	return true
+}
+
+// tokensEqual compares optional tokens, treating two missing tokens as equal
+func tokensEqual(token *tokenizer.Token, other *tokenizer.Token) bool {
+	if token == nil || other == nil {
+		return token == other
+	}
+	return token.Equals(other)
+}
+
+// tokenAt returns token on given position or nil if position is out of range
+func tokenAt(tokens []*tokenizer.Token, position int) *tokenizer.Token {
+	if position < 0 || position >= len(tokens) {
+		return nil
+	}
+	return tokens[position]
+}
+
+// isToken checks if token on given position equals to expected one
+func isToken(tokens []*tokenizer.Token, position int, expected *tokenizer.Token) bool {
+	token := tokenAt(tokens, position)
+	return token != nil && token.Equals(expected)
+}
+
+// endPosition returns position of the token to report in errors on position
+func endPosition(tokens []*tokenizer.Token, position int) int {
+	if token := tokenAt(tokens, position); token != nil {
+		return token.Position
+	}
+	if len(tokens) == 0 {
+		return 0
+	}
+	last := tokens[len(tokens)-1]
+	return last.Position + len(last.Value)
+}
+
+// startsSubquery checks if there is "(SELECT" sequence on given position
+func startsSubquery(tokens []*tokenizer.Token, position int) bool {
+	return isToken(tokens, position, tokenizer.TokenFromSymbol("(")) &&
+		isToken(tokens, position+1, tokenizer.TokenFromKeyword("select"))
+}
+
+// parseSubquery parses "(SELECT ...)" starting from given position and
+// returns statement with the position right after closing parenthesis
+func parseSubquery(tokens []*tokenizer.Token, position int) (*SelectStatement, int, error) {
+	if !startsSubquery(tokens, position) {
+		return nil, position, fmt.Errorf("expected subquery at %d", endPosition(tokens, position))
+	}
+	statement, position, err := parseSelect(tokens, position+1)
+	if err != nil {
+		return nil, position, err
+	}
+	if !isToken(tokens, position, tokenizer.TokenFromSymbol(")")) {
+		return nil, position, fmt.Errorf("expected \")\" symbol at %d", endPosition(tokens, position))
+	}
+	return statement, position + 1, nil
+}
+
+// parseExpression parses boolean expression (the one used in WHERE clause)
+// starting from given position and returns it with the position of the
+// first token which is not a part of expression
+func parseExpression(tokens []*tokenizer.Token, position int) (*Expression, int, error) {
+	return parseOrExpression(tokens, position)
+}
+
+func parseOrExpression(tokens []*tokenizer.Token, position int) (*Expression, int, error) {
+	left, position, err := parseAndExpression(tokens, position)
+	if err != nil {
+		return nil, position, err
+	}
+	for isToken(tokens, position, tokenizer.TokenFromKeyword("or")) {
+		operator := tokens[position]
+		var right *Expression
+		right, position, err = parseAndExpression(tokens, position+1)
+		if err != nil {
+			return nil, position, err
+		}
+		left = &Expression{Kind: BinaryExpression, Token: operator, Left: left, Right: right}
+	}
+	return left, position, nil
+}
+
+func parseAndExpression(tokens []*tokenizer.Token, position int) (*Expression, int, error) {
+	left, position, err := parseNotExpression(tokens, position)
+	if err != nil {
+		return nil, position, err
+	}
+	for isToken(tokens, position, tokenizer.TokenFromKeyword("and")) {
+		operator := tokens[position]
+		var right *Expression
+		right, position, err = parseNotExpression(tokens, position+1)
+		if err != nil {
+			return nil, position, err
+		}
+		left = &Expression{Kind: BinaryExpression, Token: operator, Left: left, Right: right}
+	}
+	return left, position, nil
+}
+
+func parseNotExpression(tokens []*tokenizer.Token, position int) (*Expression, int, error) {
+	if isToken(tokens, position, tokenizer.TokenFromKeyword("not")) {
+		operator := tokens[position]
+		operand, position, err := parseNotExpression(tokens, position+1)
+		if err != nil {
+			return nil, position, err
+		}
+		return &Expression{Kind: UnaryExpression, Token: operator, Left: operand}, position, nil
+	}
+	return parsePredicate(tokens, position)
+}
+
+func parsePredicate(tokens []*tokenizer.Token, position int) (*Expression, int, error) {
+	// EXISTS (SELECT ...)
+	if isToken(tokens, position, tokenizer.TokenFromKeyword("exists")) {
+		subquery, position, err := parseSubquery(tokens, position+1)
+		if err != nil {
+			return nil, position, err
+		}
+		return &Expression{Kind: ExistsExpression, Subquery: subquery}, position, nil
+	}
+
+	// Parenthesized boolean expression
+	if isToken(tokens, position, tokenizer.TokenFromSymbol("(")) && !startsSubquery(tokens, position) {
+		inner, position, err := parseExpression(tokens, position+1)
+		if err != nil {
+			return nil, position, err
+		}
+		if !isToken(tokens, position, tokenizer.TokenFromSymbol(")")) {
+			return nil, position, fmt.Errorf("expected \")\" symbol at %d", endPosition(tokens, position))
+		}
+		return inner, position + 1, nil
+	}
+
+	left, position, err := parseOperand(tokens, position)
+	if err != nil {
+		return nil, position, err
+	}
+
+	// x [NOT] {IN | LIKE | ILIKE | BETWEEN} ...
+	not := false
+	if isToken(tokens, position, tokenizer.TokenFromKeyword("not")) {
+		not = true
+		position++
+	}
+	switch token := tokenAt(tokens, position); {
+	case token == nil:
+	case token.Equals(tokenizer.TokenFromKeyword("in")):
+		return parseIn(tokens, position+1, left, not)
+	case token.Equals(tokenizer.TokenFromKeyword("like")),
+		token.Equals(tokenizer.TokenFromKeyword("ilike")):
+		return parseLike(tokens, position, left, not)
+	case token.Equals(tokenizer.TokenFromKeyword("between")):
+		return parseBetween(tokens, position+1, left, not)
+	}
+	if not {
+		return nil, position, fmt.Errorf("expected IN, LIKE, ILIKE or BETWEEN keyword at %d", endPosition(tokens, position))
+	}
+
+	operator, position, err := parseComparisonOperator(tokens, position)
+	if err != nil {
+		return nil, position, err
+	}
+	right, position, err := parseOperand(tokens, position)
+	if err != nil {
+		return nil, position, err
+	}
+	return &Expression{Kind: BinaryExpression, Token: operator, Left: left, Right: right}, position, nil
+}
+
+// parseComparisonOperator joins one- and two-symbol comparison operators
+// (=, <, >, <=, >=, <>, !=) into a single token
+func parseComparisonOperator(tokens []*tokenizer.Token, position int) (*tokenizer.Token, int, error) {
+	first := tokenAt(tokens, position)
+	if first == nil || first.Kind != tokenizer.SymbolKind {
+		return nil, position, fmt.Errorf("expected comparison operator at %d", endPosition(tokens, position))
+	}
+	second := tokenAt(tokens, position+1)
+	if second != nil && second.Kind == tokenizer.SymbolKind && second.Position == first.Position+len(first.Value) {
+		switch first.Value + second.Value {
+		case "<=", ">=", "<>", "!=":
+			return &tokenizer.Token{
+				Value:    first.Value + second.Value,
+				Kind:     tokenizer.SymbolKind,
+				Position: first.Position,
+			}, position + 2, nil
+		}
+	}
+	switch first.Value {
+	case "=", "<", ">":
+		return first, position + 1, nil
+	}
+	return nil, position, fmt.Errorf("expected comparison operator at %d, got: %s", first.Position, first.String())
+}
+
+// parseOperand parses a side of comparison: literal, column, function call,
+// CASE expression or scalar subquery
+func parseOperand(tokens []*tokenizer.Token, position int) (*Expression, int, error) {
+	token := tokenAt(tokens, position)
+	if token == nil {
+		return nil, position, fmt.Errorf("expected operand at %d", endPosition(tokens, position))
+	}
+	switch {
+	case startsSubquery(tokens, position):
+		subquery, position, err := parseSubquery(tokens, position)
+		if err != nil {
+			return nil, position, err
+		}
+		return &Expression{Kind: SubqueryExpression, Subquery: subquery}, position, nil
+	case token.Equals(tokenizer.TokenFromKeyword("case")):
+		return parseCase(tokens, position)
+	case token.Kind == tokenizer.NumericKind, token.Kind == tokenizer.StringKind:
+		return &Expression{Kind: LiteralExpression, Token: token}, position + 1, nil
+	case token.Kind == tokenizer.ParameterKind:
+		return &Expression{Kind: ParameterExpression, Token: token}, position + 1, nil
+	case tokenizer.AsIdentifier(token) != nil &&
+		isToken(tokens, position+1, tokenizer.TokenFromSymbol("(")):
+		return parseFunction(tokens, position)
+	case tokenizer.AsIdentifier(token) != nil:
+		return parseColumn(tokens, position)
+	}
+	return nil, position, fmt.Errorf("expected operand at %d, got: %s", token.Position, token.String())
+}
+
+// parseColumn parses column name which may be qualified with table name
+// (table.column)
+func parseColumn(tokens []*tokenizer.Token, position int) (*Expression, int, error) {
+	token := tokenizer.AsIdentifier(tokenAt(tokens, position))
+	if token == nil {
+		return nil, position, fmt.Errorf("expected column name at %d", endPosition(tokens, position))
+	}
+	if !isToken(tokens, position+1, tokenizer.TokenFromSymbol(".")) {
+		return &Expression{Kind: ColumnExpression, Token: token}, position + 1, nil
+	}
+	column := tokenizer.AsIdentifier(tokenAt(tokens, position+2))
+	if column == nil {
+		return nil, position + 2, fmt.Errorf("expected column name after \".\" at %d", endPosition(tokens, position+2))
+	}
+	return &Expression{Kind: ColumnExpression, Token: column, Table: token}, position + 3, nil
+}
+
+// parseFunction parses function call with optional OVER clause:
+//
+//	name([argument, ...]) [OVER (...)]
+func parseFunction(tokens []*tokenizer.Token, position int) (*Expression, int, error) {
+	name := tokenizer.AsIdentifier(tokenAt(tokens, position))
+	if name == nil {
+		return nil, position, fmt.Errorf("expected function name at %d", endPosition(tokens, position))
+	}
+	// Function names are case insensitive just like keywords
+	call := &Expression{
+		Kind:  FunctionExpression,
+		Token: &tokenizer.Token{Value: strings.ToLower(name.Value), Kind: name.Kind, Position: name.Position},
+	}
+	position++
+	if !isToken(tokens, position, tokenizer.TokenFromSymbol("(")) {
+		return nil, position, fmt.Errorf("expected \"(\" symbol at %d", endPosition(tokens, position))
+	}
+	position++
+
+	// CAST(x AS type) is stored as cast(x, type)
+	if call.Token.Value == "cast" {
+		argument, nextPosition, err := parseOperand(tokens, position)
+		if err != nil {
+			return nil, nextPosition, err
+		}
+		position = nextPosition
+		if !isToken(tokens, position, tokenizer.TokenFromKeyword("as")) {
+			return nil, position, fmt.Errorf("expected AS keyword at %d", endPosition(tokens, position))
+		}
+		datatype := tokenAt(tokens, position+1)
+		if datatype == nil || datatype.Kind != tokenizer.TypeKind {
+			return nil, position + 1, fmt.Errorf("expected type at %d", endPosition(tokens, position+1))
+		}
+		call.Arguments = []*Expression{argument, {Kind: LiteralExpression, Token: datatype}}
+		position += 2
+		if !isToken(tokens, position, tokenizer.TokenFromSymbol(")")) {
+			return nil, position, fmt.Errorf("expected \")\" symbol at %d", endPosition(tokens, position))
+		}
+	}
+
+	// count(*) is the only case where asterisk is an argument
+	if isToken(tokens, position, tokenizer.TokenFromSymbol("*")) &&
+		isToken(tokens, position+1, tokenizer.TokenFromSymbol(")")) {
+		call.Arguments = append(call.Arguments, &Expression{Kind: ColumnExpression, Token: tokens[position]})
+		position++
+	}
+	for !isToken(tokens, position, tokenizer.TokenFromSymbol(")")) {
+		if call.Arguments != nil {
+			if !isToken(tokens, position, tokenizer.TokenFromSymbol(",")) {
+				return nil, position, fmt.Errorf("expected \",\" symbol at %d", endPosition(tokens, position))
+			}
+			position++
+		}
+		argument, nextPosition, err := parseOperand(tokens, position)
+		if err != nil {
+			return nil, nextPosition, err
+		}
+		call.Arguments = append(call.Arguments, argument)
+		position = nextPosition
+	}
+	position++
+
+	if isToken(tokens, position, tokenizer.TokenFromKeyword("over")) {
+		var err error
+		call.Window, position, err = parseWindowSpecification(tokens, position+1)
+		if err != nil {
+			return nil, position, err
+		}
+	}
+	if err := checkWindowFunction(call); err != nil {
+		return nil, position, err
+	}
+	return call, position, nil
+}
+
+// parseIn parses list of values or subquery following IN keyword
+func parseIn(tokens []*tokenizer.Token, position int, left *Expression, not bool) (*Expression, int, error) {
+	expression := &Expression{Kind: InExpression, Left: left, Not: not}
+	if startsSubquery(tokens, position) {
+		var err error
+		expression.Subquery, position, err = parseSubquery(tokens, position)
+		if err != nil {
+			return nil, position, err
+		}
+		return expression, position, nil
+	}
+	if !isToken(tokens, position, tokenizer.TokenFromSymbol("(")) {
+		return nil, position, fmt.Errorf("expected \"(\" symbol at %d", endPosition(tokens, position))
+	}
+	position++
+	for {
+		value, nextPosition, err := parseOperand(tokens, position)
+		if err != nil {
+			return nil, nextPosition, err
+		}
+		expression.Arguments = append(expression.Arguments, value)
+		position = nextPosition
+		if !isToken(tokens, position, tokenizer.TokenFromSymbol(",")) {
+			break
+		}
+		position++
+	}
+	if !isToken(tokens, position, tokenizer.TokenFromSymbol(")")) {
+		return nil, position, fmt.Errorf("expected \")\" symbol at %d", endPosition(tokens, position))
+	}
+	return expression, position + 1, nil
+}
+
+// parseLike parses pattern and optional escape string starting from LIKE or
+// ILIKE keyword
+func parseLike(tokens []*tokenizer.Token, position int, left *Expression, not bool) (*Expression, int, error) {
+	expression := &Expression{Kind: LikeExpression, Token: tokens[position], Left: left, Not: not}
+	var err error
+	expression.Right, position, err = parseOperand(tokens, position+1)
+	if err != nil {
+		return nil, position, err
+	}
+	if isToken(tokens, position, tokenizer.TokenFromKeyword("escape")) {
+		escape := tokenAt(tokens, position+1)
+		if escape == nil || escape.Kind != tokenizer.StringKind || utf8.RuneCountInString(escape.Value) != 1 {
+			return nil, position + 1, fmt.Errorf("expected single character escape string at %d", endPosition(tokens, position+1))
+		}
+		expression.Arguments = []*Expression{{Kind: LiteralExpression, Token: escape}}
+		position += 2
+	}
+	return expression, position, nil
+}
+
+// parseBetween parses bounds following BETWEEN keyword
+func parseBetween(tokens []*tokenizer.Token, position int, left *Expression, not bool) (*Expression, int, error) {
+	low, position, err := parseOperand(tokens, position)
+	if err != nil {
+		return nil, position, err
+	}
+	if !isToken(tokens, position, tokenizer.TokenFromKeyword("and")) {
+		return nil, position, fmt.Errorf("expected AND keyword at %d", endPosition(tokens, position))
+	}
+	high, position, err := parseOperand(tokens, position+1)
+	if err != nil {
+		return nil, position, err
+	}
+	return &Expression{Kind: BetweenExpression, Left: left, Arguments: []*Expression{low, high}, Not: not}, position, nil
+}
+
+// parseCase parses CASE expression in both forms:
+//
+//	CASE WHEN condition THEN result [...] [ELSE result] END
+//	CASE operand WHEN value THEN result [...] [ELSE result] END
+func parseCase(tokens []*tokenizer.Token, position int) (*Expression, int, error) {
+	var (
+		expression = &Expression{Kind: CaseExpression}
+		err        error
+	)
+	position++
+	if !isToken(tokens, position, tokenizer.TokenFromKeyword("when")) {
+		expression.Left, position, err = parseOperand(tokens, position)
+		if err != nil {
+			return nil, position, err
+		}
+	}
+	for isToken(tokens, position, tokenizer.TokenFromKeyword("when")) {
+		var condition, result *Expression
+		// Simple CASE compares operand with values, searched one checks
+		// conditions
+		if expression.Left != nil {
+			condition, position, err = parseOperand(tokens, position+1)
+		} else {
+			condition, position, err = parseExpression(tokens, position+1)
+		}
+		if err != nil {
+			return nil, position, err
+		}
+		if !isToken(tokens, position, tokenizer.TokenFromKeyword("then")) {
+			return nil, position, fmt.Errorf("expected THEN keyword at %d", endPosition(tokens, position))
+		}
+		result, position, err = parseOperand(tokens, position+1)
+		if err != nil {
+			return nil, position, err
+		}
+		expression.Arguments = append(expression.Arguments, condition, result)
+	}
+	if expression.Arguments == nil {
+		return nil, position, fmt.Errorf("expected WHEN keyword at %d", endPosition(tokens, position))
+	}
+	if isToken(tokens, position, tokenizer.TokenFromKeyword("else")) {
+		expression.Right, position, err = parseOperand(tokens, position+1)
+		if err != nil {
+			return nil, position, err
+		}
+	}
+	if !isToken(tokens, position, tokenizer.TokenFromKeyword("end")) {
+		return nil, position, fmt.Errorf("expected END keyword at %d", endPosition(tokens, position))
+	}
+	return expression, position + 1, nil
+}
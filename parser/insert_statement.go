@@ -51,37 +51,38 @@ func parseInsertIntoStatement(tokens []*tokenizer.Token) (*InsertStatement, erro
 	currentToken := 0
 
 	//Process INSERT INTO sequense
-	if !tokens[currentToken].Equals(tokenizer.TokenFromKeyword("insert")) {
-		return nil, fmt.Errorf("expected INSERT keyword at %d", tokens[currentToken].Position)
+	if !isToken(tokens, currentToken, tokenizer.TokenFromKeyword("insert")) {
+		return nil, fmt.Errorf("expected INSERT keyword at %d", endPosition(tokens, currentToken))
 	}
 	currentToken++
-	if !tokens[currentToken].Equals(tokenizer.TokenFromKeyword("into")) {
-		return nil, fmt.Errorf("expected INTO keyword at %d", tokens[currentToken].Position)
+	if !isToken(tokens, currentToken, tokenizer.TokenFromKeyword("into")) {
+		return nil, fmt.Errorf("expected INTO keyword at %d", endPosition(tokens, currentToken))
 	}
 	currentToken++
 
 	//Process table name
-	if tokens[currentToken].Equals(tokenizer.TokenFromSymbol("(")) {
-		return nil, fmt.Errorf("expected table name at %d", tokens[currentToken].Position)
+	if token := tokenAt(tokens, currentToken); token == nil || token.Equals(tokenizer.TokenFromSymbol("(")) {
+		return nil, fmt.Errorf("expected table name at %d", endPosition(tokens, currentToken))
 	} else {
-		table = *tokens[currentToken]
+		table = *token
 	}
 
 	currentToken++
 
 	//Situation if column names specified
-	if tokens[currentToken].Equals(tokenizer.TokenFromSymbol("(")) {
+	if isToken(tokens, currentToken, tokenizer.TokenFromSymbol("(")) {
 		currentToken++
-		for !tokens[currentToken].Equals(tokenizer.TokenFromSymbol(")")) {
+		for !isToken(tokens, currentToken, tokenizer.TokenFromSymbol(")")) {
+			if currentToken >= len(tokens) {
+				return nil, fmt.Errorf("expected \")\" symbol at %d", endPosition(tokens, currentToken))
+			}
 			if tokens[currentToken].Equals(tokenizer.TokenFromSymbol(",")) {
+				currentToken++
 				continue
 			}
-			if currentToken == len(tokens) {
-				return nil, fmt.Errorf("expected \")\" symbol at %d", tokens[currentToken].Position)
-			}
-			tempToken := tokens[currentToken]
-			if tempToken.Kind != tokenizer.IdentifierKind {
-				return nil, fmt.Errorf("column names are only can be identifiers, got: %s", tempToken.String())
+			tempToken := tokenizer.AsIdentifier(tokens[currentToken])
+			if tempToken == nil {
+				return nil, fmt.Errorf("column names are only can be identifiers, got: %s", tokens[currentToken].String())
 			}
 			columnNames = append(columnNames, tempToken)
 			currentToken++
@@ -90,22 +91,22 @@ func parseInsertIntoStatement(tokens []*tokenizer.Token) (*InsertStatement, erro
 	}
 
 	// Process VALUES keyword
-	if !tokens[currentToken].Equals(tokenizer.TokenFromKeyword("values")) {
-		return nil, fmt.Errorf("expected VALUES keyword at %d", tokens[currentToken].Position)
+	if !isToken(tokens, currentToken, tokenizer.TokenFromKeyword("values")) {
+		return nil, fmt.Errorf("expected VALUES keyword at %d", endPosition(tokens, currentToken))
 	}
 	currentToken++
 
 	// Repeat but for values
-	if tokens[currentToken].Equals(tokenizer.TokenFromSymbol("(")) {
+	if isToken(tokens, currentToken, tokenizer.TokenFromSymbol("(")) {
 		currentToken++
-		for !tokens[currentToken].Equals(tokenizer.TokenFromSymbol(")")) {
+		for !isToken(tokens, currentToken, tokenizer.TokenFromSymbol(")")) {
+			if currentToken >= len(tokens) {
+				return nil, fmt.Errorf("expected \")\" symbol at %d", endPosition(tokens, currentToken))
+			}
 			if tokens[currentToken].Equals(tokenizer.TokenFromSymbol(",")) {
 				currentToken++
 				continue
 			}
-			if currentToken == len(tokens) {
-				return nil, fmt.Errorf("expected \")\" symbol at %d", tokens[currentToken].Position)
-			}
 			tempToken := tokens[currentToken]
 			// if tempToken.Kind != tokenizer.IdentifierKind || tempToken.Kind != tokenizer.NumericKind {
 			// 	return nil, fmt.Errorf("values can be only identifiers or numbers, got: %s", tempToken.String())
@@ -115,7 +116,7 @@ func parseInsertIntoStatement(tokens []*tokenizer.Token) (*InsertStatement, erro
 		}
 		currentToken++
 	} else {
-		return nil, fmt.Errorf("expected \"(\" symbol at %d", tokens[currentToken].Position)
+		return nil, fmt.Errorf("expected \"(\" symbol at %d", endPosition(tokens, currentToken))
 	}
 	return &InsertStatement{
 		Table:       table,
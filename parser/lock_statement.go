@@ -0,0 +1,102 @@
+package parser
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/VorobevPavel-dev/congenial-disco/lock"
+	"github.com/VorobevPavel-dev/congenial-disco/tokenizer"
+)
+
+// LockTableStatement explicitly locks a table until the end of transaction:
+//
+//	LOCK [TABLE] name [IN mode MODE];
+//
+// where mode is one of ROW SHARE, ROW EXCLUSIVE, SHARE, SHARE ROW EXCLUSIVE
+// and EXCLUSIVE (default).
+type LockTableStatement struct {
+	Table tokenizer.Token `json:"table"`
+	Mode  lock.Mode       `json:"mode"`
+}
+
+func (lts *LockTableStatement) String() string {
+	bytes, _ := json.Marshal(lts)
+	return string(bytes)
+}
+
+func (lts *LockTableStatement) Equals(other *LockTableStatement) bool {
+	return lts.Table.Equals(&other.Table) && lts.Mode == other.Mode
+}
+
+// RowLockMode returns mode of locks taken on rows read by statement with
+// FOR UPDATE or FOR SHARE clause. Returns false if rows are not locked.
+func (slct *SelectStatement) RowLockMode() (lock.Mode, bool) {
+	switch {
+	case slct.Locking == nil:
+		return 0, false
+	case slct.Locking.Value == tokenizer.UpdateKeyword:
+		return lock.Exclusive, true
+	}
+	return lock.Shared, true
+}
+
+func parseLockTableStatement(tokens []*tokenizer.Token) (*LockTableStatement, error) {
+	if !isToken(tokens, 0, tokenizer.TokenFromKeyword("lock")) {
+		return nil, fmt.Errorf("expected LOCK keyword at %d", endPosition(tokens, 0))
+	}
+	position := 1
+	if isToken(tokens, position, tokenizer.TokenFromKeyword("table")) {
+		position++
+	}
+	table := tokenizer.AsIdentifier(tokenAt(tokens, position))
+	if table == nil {
+		return nil, fmt.Errorf("expected table name identifier at %d", endPosition(tokens, position))
+	}
+	statement := &LockTableStatement{Table: *table, Mode: lock.Exclusive}
+	position++
+
+	if isToken(tokens, position, tokenizer.TokenFromKeyword("in")) {
+		var err error
+		statement.Mode, position, err = parseLockMode(tokens, position+1)
+		if err != nil {
+			return nil, err
+		}
+		if !isToken(tokens, position, tokenizer.TokenFromKeyword("mode")) {
+			return nil, fmt.Errorf("expected MODE keyword at %d", endPosition(tokens, position))
+		}
+		position++
+	}
+
+	if !isToken(tokens, position, tokenizer.TokenFromSymbol(";")) {
+		return nil, fmt.Errorf("cannot find \";\"  in the end of request")
+	}
+	return statement, nil
+}
+
+// parseLockMode parses name of table lock mode. Longer names are checked
+// first, since SHARE is a prefix of SHARE ROW EXCLUSIVE.
+func parseLockMode(tokens []*tokenizer.Token, position int) (lock.Mode, int, error) {
+	modes := []struct {
+		keywords []string
+		mode     lock.Mode
+	}{
+		{[]string{tokenizer.ShareKeyword, tokenizer.RowKeyword, tokenizer.ExclusiveKeyword}, lock.SharedIntentionExclusive},
+		{[]string{tokenizer.RowKeyword, tokenizer.ExclusiveKeyword}, lock.IntentionExclusive},
+		{[]string{tokenizer.RowKeyword, tokenizer.ShareKeyword}, lock.IntentionShared},
+		{[]string{tokenizer.ShareKeyword}, lock.Shared},
+		{[]string{tokenizer.ExclusiveKeyword}, lock.Exclusive},
+	}
+	for _, candidate := range modes {
+		matched := true
+		for index, keyword := range candidate.keywords {
+			if !isToken(tokens, position+index, tokenizer.TokenFromKeyword(keyword)) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return candidate.mode, position + len(candidate.keywords), nil
+		}
+	}
+	return 0, position, fmt.Errorf("expected lock mode at %d", endPosition(tokens, position))
+}
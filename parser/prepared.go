@@ -0,0 +1,471 @@
+package parser
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/VorobevPavel-dev/congenial-disco/tokenizer"
+)
+
+// Parameter is a placeholder of value in prepared statement
+type Parameter struct {
+	// Name is a number of parameter for "?" and "$n" placeholders and a name
+	// without colon for ":name" ones
+	Name string `json:"name"`
+	// Type is set by PreparedStatement.InferTypes
+	Type *tokenizer.Token `json:"type,omitempty"`
+}
+
+// NamedArgument is a value of ":name" parameter
+type NamedArgument struct {
+	Name  string
+	Value interface{}
+}
+
+// Named creates value of ":name" parameter
+func Named(name string, value interface{}) NamedArgument {
+	return NamedArgument{Name: name, Value: value}
+}
+
+// PreparedStatement is a statement parsed once and executed many times with
+// different values of parameters. Parameters of one statement must be either
+// positional ("?"), numbered ("$1") or named (":name").
+type PreparedStatement struct {
+	Statement *Statement `json:"statement"`
+	// Parameters are ordered by their numbers. Positional parameters are
+	// numbered in order they appear in request, named ones are ordered by
+	// their first appearance
+	Parameters []*Parameter `json:"parameters"`
+	named      bool
+	// names maps positions of placeholders in request to parameter names
+	names map[int]string
+}
+
+func (ps *PreparedStatement) String() string {
+	bytes, _ := json.Marshal(ps)
+	return string(bytes)
+}
+
+// Prepare parses request with placeholders instead of values
+func Prepare(request string) (*PreparedStatement, error) {
+	tokens := tokenizer.ParseTokenSequence(request)
+	if tokens == nil || len(*tokens) == 0 {
+		return nil, fmt.Errorf("empty request")
+	}
+	statement, err := parseStatement(*tokens)
+	if err != nil {
+		return nil, err
+	}
+	prepared := &PreparedStatement{Statement: statement, names: make(map[int]string)}
+
+	var (
+		style   byte
+		numbers = make(map[int]bool)
+	)
+	for _, token := range *tokens {
+		if token.Kind != tokenizer.ParameterKind {
+			continue
+		}
+		if style != 0 && token.Value[0] != style {
+			return nil, fmt.Errorf("placeholders of different styles are mixed at %d", token.Position)
+		}
+		style = token.Value[0]
+		var name string
+		switch style {
+		case '?':
+			name = strconv.Itoa(len(prepared.names) + 1)
+		case '$':
+			name = token.Value[1:]
+			number, _ := strconv.Atoi(name)
+			numbers[number] = true
+		case ':':
+			name = token.Value[1:]
+			prepared.named = true
+		}
+		prepared.names[token.Position] = name
+		if !prepared.hasParameter(name) {
+			prepared.Parameters = append(prepared.Parameters, &Parameter{Name: name})
+		}
+	}
+	if style == '$' {
+		for number := 1; number <= len(numbers); number++ {
+			if !numbers[number] {
+				return nil, fmt.Errorf("parameter $%d is not used", number)
+			}
+		}
+		sort.Slice(prepared.Parameters, func(i, j int) bool {
+			first, _ := strconv.Atoi(prepared.Parameters[i].Name)
+			second, _ := strconv.Atoi(prepared.Parameters[j].Name)
+			return first < second
+		})
+	}
+	return prepared, nil
+}
+
+func (ps *PreparedStatement) hasParameter(name string) bool {
+	for _, parameter := range ps.Parameters {
+		if parameter.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// InferTypes sets types of parameters from the context they are used in:
+// columns and values they are compared with, columns of INSERT they are
+// values for and text for LIKE patterns. Returns error if type of some
+// parameter is ambiguous or can not be inferred.
+func (ps *PreparedStatement) InferTypes(schema Schema) error {
+	inference := &typeInference{names: ps.names, types: make(map[string]*tokenizer.Token)}
+	var err error
+	switch statement := ps.Statement; {
+	case statement.InsertStatement != nil:
+		err = inference.insertStatement(statement.InsertStatement, schema)
+	case statement.SelectStatement != nil:
+		err = inference.selectStatement(statement.SelectStatement, schema)
+	case statement.CompoundStatement != nil:
+		err = inference.compoundStatement(statement.CompoundStatement, schema)
+	}
+	if err != nil {
+		return err
+	}
+	for _, parameter := range ps.Parameters {
+		datatype, ok := inference.types[parameter.Name]
+		if !ok {
+			return fmt.Errorf("cannot infer type of parameter %s", parameter.Name)
+		}
+		parameter.Type = datatype
+	}
+	return nil
+}
+
+// Bind returns copy of statement with placeholders replaced by given values.
+// Values are substituted as literal tokens and are never tokenized, so they
+// can not change structure of statement. Named parameters accept NamedArgument
+// values only, the other ones accept values in order of parameters. Supported
+// values are integers and strings.
+func (ps *PreparedStatement) Bind(arguments ...interface{}) (*Statement, error) {
+	values := make(map[string]*tokenizer.Token, len(arguments))
+	for index, argument := range arguments {
+		name := strconv.Itoa(index + 1)
+		if named, ok := argument.(NamedArgument); ok {
+			if !ps.named {
+				return nil, fmt.Errorf("named argument %s is passed to statement without named parameters", named.Name)
+			}
+			name, argument = named.Name, named.Value
+		} else if ps.named {
+			return nil, fmt.Errorf("argument #%d must be named", index+1)
+		}
+		if _, ok := values[name]; ok {
+			return nil, fmt.Errorf("parameter %s is bound more than once", name)
+		}
+		value, err := literalToken(argument)
+		if err != nil {
+			return nil, fmt.Errorf("parameter %s: %v", name, err)
+		}
+		values[name] = value
+	}
+
+	for _, parameter := range ps.Parameters {
+		value, ok := values[parameter.Name]
+		if !ok {
+			return nil, fmt.Errorf("no value for parameter %s", parameter.Name)
+		}
+		if parameter.Type != nil && !literalHasType(value, parameter.Type) {
+			return nil, fmt.Errorf("parameter %s must be of %s type, got: %s", parameter.Name, parameter.Type.Value, value.String())
+		}
+	}
+	if len(values) != len(ps.Parameters) {
+		return nil, fmt.Errorf("statement has %d parameters, got: %d arguments", len(ps.Parameters), len(values))
+	}
+
+	// Statement is copied through JSON, so that prepared statement can be
+	// bound again
+	bytes, err := json.Marshal(ps.Statement)
+	if err != nil {
+		return nil, err
+	}
+	var statement Statement
+	if err := json.Unmarshal(bytes, &statement); err != nil {
+		return nil, err
+	}
+	substitute := func(token *tokenizer.Token) {
+		value := values[ps.names[token.Position]]
+		token.Value, token.Kind = value.Value, value.Kind
+	}
+	visit := func(expression *Expression) {
+		if expression.Kind == ParameterExpression {
+			substitute(expression.Token)
+			expression.Kind = LiteralExpression
+		}
+	}
+	switch {
+	case statement.SelectStatement != nil:
+		walkSelect(statement.SelectStatement, visit)
+	case statement.CompoundStatement != nil:
+		walkCompound(statement.CompoundStatement, visit)
+	case statement.InsertStatement != nil:
+		for _, value := range statement.InsertStatement.Values {
+			if value.Kind == tokenizer.ParameterKind {
+				substitute(value)
+			}
+		}
+	}
+	return &statement, nil
+}
+
+// literalToken converts value of parameter to literal token
+func literalToken(value interface{}) (*tokenizer.Token, error) {
+	var result string
+	switch typed := value.(type) {
+	case int:
+		result = strconv.FormatInt(int64(typed), 10)
+	case int8:
+		result = strconv.FormatInt(int64(typed), 10)
+	case int16:
+		result = strconv.FormatInt(int64(typed), 10)
+	case int32:
+		result = strconv.FormatInt(int64(typed), 10)
+	case int64:
+		result = strconv.FormatInt(typed, 10)
+	case uint:
+		result = strconv.FormatUint(uint64(typed), 10)
+	case uint8:
+		result = strconv.FormatUint(uint64(typed), 10)
+	case uint16:
+		result = strconv.FormatUint(uint64(typed), 10)
+	case uint32:
+		result = strconv.FormatUint(uint64(typed), 10)
+	case uint64:
+		result = strconv.FormatUint(typed, 10)
+	case string:
+		return &tokenizer.Token{Value: typed, Kind: tokenizer.StringKind}, nil
+	case nil:
+		return nil, fmt.Errorf("NULL values are not supported")
+	default:
+		return nil, fmt.Errorf("unsupported type of value: %T", value)
+	}
+	return &tokenizer.Token{Value: result, Kind: tokenizer.NumericKind}, nil
+}
+
+func literalHasType(literal *tokenizer.Token, datatype *tokenizer.Token) bool {
+	switch datatype.Value {
+	case tokenizer.IntType:
+		return literal.Kind == tokenizer.NumericKind
+	case tokenizer.TextType:
+		return literal.Kind == tokenizer.StringKind
+	}
+	return true
+}
+
+// walkSelect calls visit for every expression of statement including the ones
+// of subqueries and derived tables
+func walkSelect(slct *SelectStatement, visit func(*Expression)) {
+	if slct.FromSubquery != nil {
+		walkSelect(slct.FromSubquery, visit)
+	}
+	expressions := slct.expressions()
+	for len(expressions) != 0 {
+		expression := expressions[0]
+		expressions = expressions[1:]
+		if expression == nil {
+			continue
+		}
+		visit(expression)
+		if expression.Subquery != nil {
+			walkSelect(expression.Subquery, visit)
+		}
+		expressions = append(expressions, expression.Children()...)
+	}
+}
+
+func walkCompound(statement *CompoundStatement, visit func(*Expression)) {
+	if statement.With != nil {
+		for _, cte := range statement.With.Tables {
+			walkCompound(cte.Query, visit)
+		}
+	}
+	for _, slct := range statement.Selects {
+		walkSelect(slct, visit)
+	}
+}
+
+// typeInference collects types of parameters by their names
+type typeInference struct {
+	names map[int]string
+	types map[string]*tokenizer.Token
+}
+
+func (ti *typeInference) set(parameter *tokenizer.Token, datatype *tokenizer.Token) error {
+	name := ti.names[parameter.Position]
+	if known, ok := ti.types[name]; ok && !known.Equals(datatype) {
+		return fmt.Errorf("parameter %s is used both as %s and %s", name, known.Value, datatype.Value)
+	}
+	ti.types[name] = datatype
+	return nil
+}
+
+func (ti *typeInference) insertStatement(statement *InsertStatement, schema Schema) error {
+	definition, ok := schema[statement.Table.Value]
+	if !ok {
+		return fmt.Errorf("table %s does not exist", statement.Table.Value)
+	}
+	for index, value := range statement.Values {
+		if value.Kind != tokenizer.ParameterKind {
+			continue
+		}
+		var datatype *tokenizer.Token
+		switch {
+		case statement.ColumnNames != nil && index < len(statement.ColumnNames):
+			var err error
+			datatype, err = schema.ColumnType(statement.Table.Value, statement.ColumnNames[index].Value)
+			if err != nil {
+				return err
+			}
+		case statement.ColumnNames == nil && index < len(definition.Cols):
+			datatype = &definition.Cols[index].Datatype
+		default:
+			return fmt.Errorf("INSERT has more values than columns")
+		}
+		if err := ti.set(value, datatype); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (ti *typeInference) compoundStatement(statement *CompoundStatement, schema Schema) error {
+	if statement.With != nil {
+		for _, cte := range statement.With.Tables {
+			if err := ti.compoundStatement(cte.Query, schema); err != nil {
+				return err
+			}
+		}
+		var err error
+		if schema, err = statement.With.Schema(schema); err != nil {
+			return err
+		}
+	}
+	for _, slct := range statement.Selects {
+		if err := ti.selectStatement(slct, schema); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// selectStatement infers types of parameters of statement. Subqueries are
+// processed separately, since their columns are resolved against their own
+// FROM clauses
+func (ti *typeInference) selectStatement(slct *SelectStatement, schema Schema) error {
+	if slct.FromSubquery != nil {
+		if err := ti.selectStatement(slct.FromSubquery, schema); err != nil {
+			return err
+		}
+	}
+	expressions := slct.expressions()
+	for len(expressions) != 0 {
+		expression := expressions[0]
+		expressions = expressions[1:]
+		if expression == nil {
+			continue
+		}
+		if err := ti.expression(slct, expression, schema); err != nil {
+			return err
+		}
+		if expression.Subquery != nil {
+			if err := ti.selectStatement(expression.Subquery, schema); err != nil {
+				return err
+			}
+		}
+		expressions = append(expressions, expression.Children()...)
+	}
+	return nil
+}
+
+// expression infers types of parameters which are direct operands of
+// expression
+func (ti *typeInference) expression(slct *SelectStatement, expression *Expression, schema Schema) error {
+	var groups [][]*Expression
+	switch expression.Kind {
+	case BinaryExpression:
+		// Operands of AND and OR are conditions, not values
+		if expression.Token.Kind == tokenizer.SymbolKind {
+			groups = append(groups, []*Expression{expression.Left, expression.Right})
+		}
+	case InExpression:
+		if expression.Subquery != nil {
+			groups = append(groups, []*Expression{
+				expression.Left,
+				{Kind: SubqueryExpression, Subquery: expression.Subquery},
+			})
+		} else {
+			groups = append(groups, append([]*Expression{expression.Left}, expression.Arguments...))
+		}
+	case BetweenExpression:
+		groups = append(groups, append([]*Expression{expression.Left}, expression.Arguments...))
+	case LikeExpression:
+		for _, operand := range append([]*Expression{expression.Left, expression.Right}, expression.Arguments...) {
+			if operand.Kind == ParameterExpression {
+				if err := ti.set(operand.Token, tokenizer.ParseTypeToken(tokenizer.TextType)); err != nil {
+					return err
+				}
+			}
+		}
+	case CaseExpression:
+		// WHEN values are compared with operand, THEN and ELSE results must
+		// have the same type
+		var conditions, results []*Expression
+		if expression.Left != nil {
+			conditions = append(conditions, expression.Left)
+		}
+		for index, argument := range expression.Arguments {
+			if index%2 == 0 && expression.Left != nil {
+				conditions = append(conditions, argument)
+			} else if index%2 == 1 {
+				results = append(results, argument)
+			}
+		}
+		if expression.Right != nil {
+			results = append(results, expression.Right)
+		}
+		groups = append(groups, conditions, results)
+	}
+	for _, group := range groups {
+		if err := ti.unify(slct, group, schema); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// unify sets type of parameters of group to the type of the first operand of
+// group which is not a parameter
+func (ti *typeInference) unify(slct *SelectStatement, operands []*Expression, schema Schema) error {
+	var (
+		values     []*Expression
+		parameters []*tokenizer.Token
+	)
+	for _, operand := range operands {
+		if operand.Kind == ParameterExpression {
+			parameters = append(parameters, operand.Token)
+		} else {
+			values = append(values, operand)
+		}
+	}
+	if parameters == nil || values == nil {
+		return nil
+	}
+	datatype, err := slct.ExpressionType(values[0], schema)
+	if err != nil {
+		return err
+	}
+	for _, parameter := range parameters {
+		if err := ti.set(parameter, datatype); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package parser
+
+import (
+	"github.com/VorobevPavel-dev/congenial-disco/function"
+	"github.com/VorobevPavel-dev/congenial-disco/tokenizer"
+)
+
+// RewritePrefixLike replaces column LIKE 'prefix%' conditions of expression
+// with range conditions column >= 'prefix' AND column < 'prefiy', which let
+// scans skip parts of tables by zone maps. LIKE itself is kept as a filter of the range
+// unless pattern is a prefix followed by a single "%", and patterns without
+// wildcards are replaced with equality. ILIKE, NOT LIKE, LIKE over non-column
+// values and patterns starting with a wildcard are left untouched, just like
+// subqueries.
+func RewritePrefixLike(expression *Expression) (*Expression, error) {
+	if expression == nil {
+		return nil, nil
+	}
+	switch expression.Kind {
+	case BinaryExpression, UnaryExpression:
+		rewritten := *expression
+		var err error
+		if rewritten.Left, err = RewritePrefixLike(expression.Left); err != nil {
+			return nil, err
+		}
+		if rewritten.Right, err = RewritePrefixLike(expression.Right); err != nil {
+			return nil, err
+		}
+		return &rewritten, nil
+	case LikeExpression:
+		return rewriteLike(expression)
+	}
+	return expression, nil
+}
+
+func rewriteLike(like *Expression) (*Expression, error) {
+	if like.Not || !like.Token.Equals(tokenizer.TokenFromKeyword("like")) ||
+		like.Left.Kind != ColumnExpression ||
+		like.Right.Kind != LiteralExpression || like.Right.Token.Kind != tokenizer.StringKind {
+		return like, nil
+	}
+	escape := ""
+	if like.Arguments != nil {
+		escape = like.Arguments[0].Token.Value
+	}
+	prefix, exact, err := function.LikePrefix(like.Right.Token.Value, escape)
+	if err != nil {
+		return nil, err
+	}
+	if exact {
+		return comparison("=", like.Left, prefix, like.Token.Position), nil
+	}
+	if prefix == "" {
+		return like, nil
+	}
+
+	condition := comparison(">=", like.Left, prefix, like.Token.Position)
+	if bound, ok := function.PrefixUpperBound(prefix); ok {
+		condition = conjunction(condition, comparison("<", like.Left, bound, like.Token.Position))
+	}
+	// "prefix%" is matched by every value of range
+	if like.Right.Token.Value != prefix+string(function.AnySequenceWildcard) || escape != "" {
+		condition = conjunction(condition, like)
+	}
+	return condition, nil
+}
+
+func comparison(operator string, column *Expression, value string, position int) *Expression {
+	return &Expression{
+		Kind:  BinaryExpression,
+		Token: &tokenizer.Token{Value: operator, Kind: tokenizer.SymbolKind, Position: position},
+		Left:  column,
+		Right: &Expression{
+			Kind:  LiteralExpression,
+			Token: &tokenizer.Token{Value: value, Kind: tokenizer.StringKind, Position: position},
+		},
+	}
+}
+
+func conjunction(left *Expression, right *Expression) *Expression {
+	and := tokenizer.TokenFromKeyword("and")
+	and.Position = left.Token.Position
+	return &Expression{Kind: BinaryExpression, Token: and, Left: left, Right: right}
+}
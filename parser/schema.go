@@ -0,0 +1,250 @@
+package parser
+
+import (
+	"fmt"
+
+	"github.com/VorobevPavel-dev/congenial-disco/function"
+	"github.com/VorobevPavel-dev/congenial-disco/tokenizer"
+	"github.com/VorobevPavel-dev/congenial-disco/utility"
+)
+
+// Schema maps table names to their definitions. It is used to resolve types of
+// columns referenced by statements.
+type Schema map[string]*CreateTableStatement
+
+// NewSchema creates schema from a set of table definitions
+func NewSchema(tables ...*CreateTableStatement) Schema {
+	schema := make(Schema, len(tables))
+	for _, table := range tables {
+		schema[table.Name.Value] = table
+	}
+	return schema
+}
+
+// ColumnType returns type token of column in given table
+func (s Schema) ColumnType(table string, column string) (*tokenizer.Token, error) {
+	definition, ok := s[table]
+	if !ok {
+		return nil, fmt.Errorf("table %s does not exist", table)
+	}
+	for _, col := range definition.Cols {
+		if col.Name.Value == column {
+			return &col.Datatype, nil
+		}
+	}
+	return nil, fmt.Errorf("column %s does not exist in table %s", column, table)
+}
+
+// ColumnTypes returns types of columns produced by statement in order they
+// are listed in select list
+func (slct *SelectStatement) ColumnTypes(schema Schema) ([]*tokenizer.Token, error) {
+	var result []*tokenizer.Token
+	for _, item := range slct.Item {
+		datatype, err := slct.ExpressionType(item, schema)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, datatype)
+	}
+	return result, nil
+}
+
+// CheckTypes resolves types of select list and checks that every function
+// call of statement (including the ones in WHERE clause and subqueries)
+// matches one of signatures of called function and that values compared
+// with each other have the same type
+func (slct *SelectStatement) CheckTypes(schema Schema) error {
+	if slct.FromSubquery != nil {
+		if err := slct.FromSubquery.CheckTypes(schema); err != nil {
+			return err
+		}
+	}
+	if _, err := slct.ColumnTypes(schema); err != nil {
+		return err
+	}
+	expressions := slct.expressions()
+	for len(expressions) != 0 {
+		expression := expressions[0]
+		expressions = expressions[1:]
+		if expression == nil {
+			continue
+		}
+		if expression.Kind == FunctionExpression {
+			if _, err := slct.ExpressionType(expression, schema); err != nil {
+				return err
+			}
+		}
+		if expression.Subquery != nil {
+			if err := expression.Subquery.CheckTypes(schema); err != nil {
+				return err
+			}
+		}
+		if err := slct.checkComparison(expression, schema); err != nil {
+			return err
+		}
+		expressions = append(expressions, expression.Children()...)
+	}
+	return nil
+}
+
+// comparisonOperators are operators of binary expressions comparing values
+var comparisonOperators = []string{"=", "<>", "!=", "<", "<=", ">", ">="}
+
+// checkComparison checks that operands of comparison, BETWEEN and IN have the
+// same type. Operands of unknown type (like parameters) match any type
+func (slct *SelectStatement) checkComparison(expression *Expression, schema Schema) error {
+	var operands []*Expression
+	switch expression.Kind {
+	case BinaryExpression:
+		if !utility.StringIsIn(expression.Token.Value, comparisonOperators) {
+			return nil
+		}
+		operands = []*Expression{expression.Left, expression.Right}
+	case BetweenExpression:
+		operands = append([]*Expression{expression.Left}, expression.Arguments...)
+	case InExpression:
+		if expression.Subquery != nil {
+			operands = []*Expression{expression.Left, {Kind: SubqueryExpression, Subquery: expression.Subquery}}
+		} else {
+			operands = append([]*Expression{expression.Left}, expression.Arguments...)
+		}
+	default:
+		return nil
+	}
+	var first *tokenizer.Token
+	for _, operand := range operands {
+		datatype, err := slct.ExpressionType(operand, schema)
+		if err != nil {
+			continue
+		}
+		if first == nil {
+			first = datatype
+			continue
+		}
+		if !first.Equals(datatype) {
+			return fmt.Errorf("cannot compare %s with %s", first.Value, datatype.Value)
+		}
+	}
+	return nil
+}
+
+// ExpressionType returns type of value of expression used in statement
+func (slct *SelectStatement) ExpressionType(expression *Expression, schema Schema) (*tokenizer.Token, error) {
+	switch expression.Kind {
+	case LiteralExpression:
+		switch expression.Token.Kind {
+		case tokenizer.NumericKind:
+			return tokenizer.ParseTypeToken(tokenizer.IntType), nil
+		case tokenizer.StringKind:
+			return tokenizer.ParseTypeToken(tokenizer.TextType), nil
+		case tokenizer.TypeKind:
+			return expression.Token, nil
+		}
+	case ColumnExpression:
+		return slct.columnType(expression, schema)
+	case SubqueryExpression:
+		if len(expression.Subquery.Item) != 1 {
+			return nil, fmt.Errorf("scalar subquery must return exactly one column, got: %d", len(expression.Subquery.Item))
+		}
+		types, err := expression.Subquery.ColumnTypes(schema)
+		if err != nil {
+			return nil, err
+		}
+		return types[0], nil
+	case CaseExpression:
+		// Results are THEN expressions (odd arguments) and ELSE expression
+		var results []*Expression
+		for index := 1; index < len(expression.Arguments); index += 2 {
+			results = append(results, expression.Arguments[index])
+		}
+		if expression.Right != nil {
+			results = append(results, expression.Right)
+		}
+		var result *tokenizer.Token
+		for _, item := range results {
+			datatype, err := slct.ExpressionType(item, schema)
+			if err != nil {
+				return nil, err
+			}
+			if result != nil && !result.Equals(datatype) {
+				return nil, fmt.Errorf("CASE results have different types: %s and %s", result.Value, datatype.Value)
+			}
+			result = datatype
+		}
+		return result, nil
+	case FunctionExpression:
+		definition, err := function.Builtin.Lookup(expression.Token.Value)
+		if err != nil {
+			return nil, err
+		}
+		var types []string
+		for _, argument := range expression.Arguments {
+			// Asterisk of count(*) has no type
+			if argument.Token != nil && argument.Token.Equals(tokenizer.TokenFromSymbol("*")) {
+				types = append(types, function.AnyType)
+				continue
+			}
+			datatype, err := slct.ExpressionType(argument, schema)
+			if err != nil {
+				return nil, err
+			}
+			types = append(types, datatype.Value)
+		}
+		result, err := definition.ResultType(types)
+		if err != nil {
+			return nil, err
+		}
+		if result == function.AnyType {
+			return nil, fmt.Errorf("cannot resolve type of %s result", expression.Token.Value)
+		}
+		return tokenizer.ParseTypeToken(result), nil
+	}
+	return nil, fmt.Errorf("cannot resolve type of %s", expression.String())
+}
+
+// columnType resolves column against tables of statement. Unqualified column
+// must belong to exactly one of them
+func (slct *SelectStatement) columnType(column *Expression, schema Schema) (*tokenizer.Token, error) {
+	tables := slct.Tables()
+	if column.Table != nil {
+		if !utility.StringIsIn(column.Table.Value, tables) {
+			return nil, fmt.Errorf("table %s is not listed in FROM clause", column.Table.Value)
+		}
+		return slct.tableColumnType(column.Table.Value, column.Token.Value, schema)
+	}
+	if len(tables) == 1 {
+		return slct.tableColumnType(tables[0], column.Token.Value, schema)
+	}
+	var result *tokenizer.Token
+	for _, table := range tables {
+		datatype, err := slct.tableColumnType(table, column.Token.Value, schema)
+		if err != nil {
+			continue
+		}
+		if result != nil {
+			return nil, fmt.Errorf("column %s is ambiguous", column.Token.Value)
+		}
+		result = datatype
+	}
+	if result == nil {
+		return nil, fmt.Errorf("column %s does not exist in any of tables %v", column.Token.Value, tables)
+	}
+	return result, nil
+}
+
+func (slct *SelectStatement) tableColumnType(table string, column string, schema Schema) (*tokenizer.Token, error) {
+	if table != slct.From.Value || slct.FromSubquery == nil {
+		return schema.ColumnType(table, column)
+	}
+	// Derived table columns are named after items of its select list
+	types, err := slct.FromSubquery.ColumnTypes(schema)
+	if err != nil {
+		return nil, err
+	}
+	for index, inner := range slct.FromSubquery.Item {
+		if inner.Kind == ColumnExpression && inner.Token.Value == column {
+			return types[index], nil
+		}
+	}
+	return nil, fmt.Errorf("column %s does not exist in %s", column, table)
+}
@@ -3,13 +3,38 @@ package parser
 import (
 	"encoding/json"
 	"fmt"
+	"strings"
 
 	"github.com/VorobevPavel-dev/congenial-disco/tokenizer"
+	"github.com/VorobevPavel-dev/congenial-disco/utility"
 )
 
+// JoinClause is [INNER] JOIN table ON condition
+type JoinClause struct {
+	Table tokenizer.Token `json:"table"`
+	On    *Expression     `json:"on"`
+}
+
+func (jc *JoinClause) Equals(other *JoinClause) bool {
+	return jc.Table.Equals(&other.Table) && jc.On.Equals(other.On)
+}
+
 type SelectStatement struct {
-	Item []*tokenizer.Token `json:"item"`
-	From tokenizer.Token    `json:"from"`
+	Distinct bool            `json:"distinct,omitempty"`
+	Item     []*Expression   `json:"item"`
+	From     tokenizer.Token `json:"from"`
+	// FromSubquery is set for derived tables (FROM (SELECT ...) AS alias),
+	// From holds alias in that case
+	FromSubquery *SelectStatement `json:"from_subquery,omitempty"`
+	Joins        []*JoinClause    `json:"joins,omitempty"`
+	Where        *Expression      `json:"where,omitempty"`
+	GroupBy      []*Expression    `json:"group_by,omitempty"`
+	OrderBy      []*OrderingTerm  `json:"order_by,omitempty"`
+	Limit        *tokenizer.Token `json:"limit,omitempty"`
+	Offset       *tokenizer.Token `json:"offset,omitempty"`
+	// Locking holds UPDATE or SHARE keyword of FOR UPDATE and FOR SHARE
+	// clauses
+	Locking *tokenizer.Token `json:"locking,omitempty"`
 }
 
 func (slct *SelectStatement) String() string {
@@ -17,7 +42,7 @@ func (slct *SelectStatement) String() string {
 	return string(bytes)
 }
 func (slct *SelectStatement) Equals(other *SelectStatement) bool {
-	if len(slct.Item) != len(other.Item) {
+	if slct.Distinct != other.Distinct || len(slct.Item) != len(other.Item) {
 		return false
 	}
 	for index := range slct.Item {
@@ -25,55 +50,348 @@ func (slct *SelectStatement) Equals(other *SelectStatement) bool {
 			return false
 		}
 	}
+	if slct.FromSubquery == nil || other.FromSubquery == nil {
+		if slct.FromSubquery != other.FromSubquery {
+			return false
+		}
+	} else if !slct.FromSubquery.Equals(other.FromSubquery) {
+		return false
+	}
+	if !slct.Where.Equals(other.Where) || !tokensEqual(slct.Locking, other.Locking) {
+		return false
+	}
+	if len(slct.Joins) != len(other.Joins) || len(slct.OrderBy) != len(other.OrderBy) {
+		return false
+	}
+	for index := range slct.Joins {
+		if !slct.Joins[index].Equals(other.Joins[index]) {
+			return false
+		}
+	}
+	for index := range slct.OrderBy {
+		if !slct.OrderBy[index].Equals(other.OrderBy[index]) {
+			return false
+		}
+	}
+	if !expressionsEqual(slct.GroupBy, other.GroupBy) {
+		return false
+	}
+	if !tokensEqual(slct.Limit, other.Limit) || !tokensEqual(slct.Offset, other.Offset) {
+		return false
+	}
 	return slct.From.Equals(&other.From)
 }
 
+// Tables returns names of tables statement reads from: table of FROM clause
+// (or alias of derived table) followed by joined tables
+func (slct *SelectStatement) Tables() []string {
+	tables := []string{slct.From.Value}
+	for _, join := range slct.Joins {
+		tables = append(tables, join.Table.Value)
+	}
+	return tables
+}
+
+// expressions returns top-level expressions of all clauses of statement (not
+// including the ones of derived table)
+func (slct *SelectStatement) expressions() []*Expression {
+	result := append([]*Expression{}, slct.Item...)
+	for _, join := range slct.Joins {
+		result = append(result, join.On)
+	}
+	if slct.Where != nil {
+		result = append(result, slct.Where)
+	}
+	result = append(result, slct.GroupBy...)
+	for _, term := range slct.OrderBy {
+		result = append(result, term.Expression)
+	}
+	return result
+}
+
+// OuterReferences returns qualified columns (table.column) of statement and
+// its subqueries which refer to tables not bound by their FROM clauses, i.e.
+// columns of enclosing queries. Non-empty result means that statement is a
+// correlated subquery. Unqualified columns are never treated as outer ones.
+func (slct *SelectStatement) OuterReferences() []*Expression {
+	var result []*Expression
+	// Derived tables can not see sibling tables, only the enclosing ones
+	if slct.FromSubquery != nil {
+		result = append(result, slct.FromSubquery.OuterReferences()...)
+	}
+	for _, expression := range slct.expressions() {
+		result = append(result, outerReferences(expression, slct.Tables())...)
+	}
+	return result
+}
+
+// outerReferences walks through expression and returns qualified columns
+// which are not bound by given tables
+func outerReferences(expression *Expression, tables []string) []*Expression {
+	if expression == nil {
+		return nil
+	}
+	var result []*Expression
+	if expression.Kind == ColumnExpression && expression.Table != nil &&
+		!utility.StringIsIn(expression.Table.Value, tables) {
+		result = append(result, expression)
+	}
+	for _, child := range expression.Children() {
+		result = append(result, outerReferences(child, tables)...)
+	}
+	if expression.Subquery != nil {
+		for _, reference := range expression.Subquery.OuterReferences() {
+			if !utility.StringIsIn(reference.Table.Value, tables) {
+				result = append(result, reference)
+			}
+		}
+	}
+	return result
+}
+
 func parseSelectStatement(tokens []*tokenizer.Token) (*SelectStatement, error) {
-	// SELECT ... FROM table;
+	// SELECT ... FROM table [JOIN ...] [WHERE ...] [GROUP BY ...] [ORDER BY ...]
+	// [LIMIT n [OFFSET m]] [FOR {UPDATE | SHARE}];
+	statement, position, err := parseSelect(tokens, 0)
+	if err != nil {
+		return nil, err
+	}
+	//	Check if ";" exists
+	if !isToken(tokens, position, tokenizer.TokenFromSymbol(";")) {
+		return nil, fmt.Errorf("cannot find \";\"  in the end of request")
+	}
+	return statement, nil
+}
 
-	var items []*tokenizer.Token
+// parseSelect parses SELECT statement starting from given position and returns
+// it with the position of the first token after the statement
+func parseSelect(tokens []*tokenizer.Token, position int) (*SelectStatement, int, error) {
+	var (
+		items []*Expression
+		err   error
+	)
 
 	//Process SELECT keyword
-	if !tokens[0].Equals(tokenizer.TokenFromKeyword("select")) {
-		return nil, fmt.Errorf("expected SELECT keyword at %d", tokens[0].Position)
+	if !isToken(tokens, position, tokenizer.TokenFromKeyword("select")) {
+		return nil, position, fmt.Errorf("expected SELECT keyword at %d", endPosition(tokens, position))
+	}
+	position++
+
+	statement := &SelectStatement{}
+	if isToken(tokens, position, tokenizer.TokenFromKeyword("distinct")) {
+		statement.Distinct = true
+		position++
 	}
 
 	//Process columns
-	//	Get FROM token position
-	fromPosition := tokenizer.FindToken(tokens, tokenizer.TokenFromKeyword("from"))
-	if fromPosition == -1 {
-		return nil, fmt.Errorf("cannot find FROM keword in request")
-	}
-	//	Parse identifiers in loop
-	for _, item := range tokens[1:fromPosition] {
-		if item.Equals(tokenizer.TokenFromSymbol(",")) ||
-			item.Equals(tokenizer.TokenFromSymbol(" ")) {
+	for !isToken(tokens, position, tokenizer.TokenFromKeyword("from")) {
+		item := tokenAt(tokens, position)
+		if item == nil {
+			return nil, position, fmt.Errorf("cannot find FROM keword in request")
+		}
+		if item.Equals(tokenizer.TokenFromSymbol(",")) {
+			position++
 			continue
 		}
+		var expression *Expression
+		switch {
+		// Scalar subquery
+		case startsSubquery(tokens, position):
+			var subquery *SelectStatement
+			subquery, position, err = parseSubquery(tokens, position)
+			if err != nil {
+				return nil, position, err
+			}
+			expression = &Expression{Kind: SubqueryExpression, Subquery: subquery}
+		// CASE expression
+		case item.Equals(tokenizer.TokenFromKeyword("case")):
+			expression, position, err = parseCase(tokens, position)
+			if err != nil {
+				return nil, position, err
+			}
+		// Function call
+		case tokenizer.AsIdentifier(item) != nil &&
+			isToken(tokens, position+1, tokenizer.TokenFromSymbol("(")):
+			expression, position, err = parseFunction(tokens, position)
+			if err != nil {
+				return nil, position, err
+			}
 		// if current token is a name
-		if item.Kind != tokenizer.IdentifierKind {
-			return nil, fmt.Errorf("only Identifiers allowed to be SELECTed")
+		case tokenizer.AsIdentifier(item) != nil:
+			expression, position, err = parseColumn(tokens, position)
+			if err != nil {
+				return nil, position, err
+			}
+		default:
+			return nil, position, fmt.Errorf("only Identifiers allowed to be SELECTed")
 		}
-		items = append(items, item)
+		items = append(items, expression)
 	}
 	if items == nil {
-		return nil, fmt.Errorf("no identifiers provided for select")
+		return nil, position, fmt.Errorf("no identifiers provided for select")
 	}
+	position++
+	statement.Item = items
 
-	//Process table name
-	//	Check if ";" exists
-	endPosition := tokenizer.FindToken(tokens, tokenizer.TokenFromSymbol(";"))
-	if endPosition == -1 {
-		return nil, fmt.Errorf("cannot find \";\"  in the end of request")
+	//Process table name or derived table
+	switch table := tokenizer.AsIdentifier(tokenAt(tokens, position)); {
+	case startsSubquery(tokens, position):
+		statement.FromSubquery, position, err = parseSubquery(tokens, position)
+		if err != nil {
+			return nil, position, err
+		}
+		// Without AS keywords of clauses following FROM are not aliases
+		alias := tokenAt(tokens, position)
+		if isToken(tokens, position, tokenizer.TokenFromKeyword("as")) {
+			position++
+			alias = tokenAt(tokens, position)
+		} else if startsClause(alias) {
+			alias = nil
+		}
+		if alias = tokenizer.AsIdentifier(alias); alias == nil {
+			return nil, position, fmt.Errorf("expected alias for derived table at %d", endPosition(tokens, position))
+		}
+		statement.From = *alias
+		position++
+	case table == nil:
+		return nil, position, fmt.Errorf("no table name provided in request")
+	default:
+		statement.From = *table
+		position++
+	}
+
+	//Process JOIN clauses
+	for isToken(tokens, position, tokenizer.TokenFromKeyword("join")) ||
+		isToken(tokens, position, tokenizer.TokenFromKeyword("inner")) {
+		var join *JoinClause
+		join, position, err = parseJoin(tokens, position)
+		if err != nil {
+			return nil, position, err
+		}
+		if utility.StringIsIn(join.Table.Value, statement.Tables()) {
+			return nil, position, fmt.Errorf("table %s is listed in FROM clause more than once", join.Table.Value)
+		}
+		statement.Joins = append(statement.Joins, join)
+	}
+
+	//Process WHERE clause
+	if isToken(tokens, position, tokenizer.TokenFromKeyword("where")) {
+		statement.Where, position, err = parseExpression(tokens, position+1)
+		if err != nil {
+			return nil, position, err
+		}
+		if windowFunctionsOf(statement.Where) != nil {
+			return nil, position, fmt.Errorf("window functions are not allowed in WHERE clause")
+		}
+	}
+
+	//Process GROUP BY clause
+	if isToken(tokens, position, tokenizer.TokenFromKeyword("group")) {
+		if !isToken(tokens, position+1, tokenizer.TokenFromKeyword("by")) {
+			return nil, position + 1, fmt.Errorf("expected BY keyword at %d", endPosition(tokens, position+1))
+		}
+		position += 2
+		for {
+			var expression *Expression
+			expression, position, err = parseOperand(tokens, position)
+			if err != nil {
+				return nil, position, err
+			}
+			statement.GroupBy = append(statement.GroupBy, expression)
+			if !isToken(tokens, position, tokenizer.TokenFromSymbol(",")) {
+				break
+			}
+			position++
+		}
+	}
+
+	//Process ORDER BY clause
+	if isToken(tokens, position, tokenizer.TokenFromKeyword("order")) {
+		statement.OrderBy, position, err = parseOrderBy(tokens, position)
+		if err != nil {
+			return nil, position, err
+		}
 	}
-	//	Check if there is a token between FROM and ; tokens
-	if endPosition == fromPosition {
-		return nil, fmt.Errorf("no table name provided in request")
+
+	//Process LIMIT and OFFSET
+	if isToken(tokens, position, tokenizer.TokenFromKeyword("limit")) {
+		statement.Limit, position, err = parseRowCount(tokens, position+1)
+		if err != nil {
+			return nil, position, err
+		}
+		if isToken(tokens, position, tokenizer.TokenFromKeyword("offset")) {
+			statement.Offset, position, err = parseRowCount(tokens, position+1)
+			if err != nil {
+				return nil, position, err
+			}
+		}
+	}
+
+	//Process locking clause
+	if isToken(tokens, position, tokenizer.TokenFromKeyword("for")) {
+		strength := tokenAt(tokens, position+1)
+		if strength == nil || !(strength.Equals(tokenizer.TokenFromKeyword("update")) ||
+			strength.Equals(tokenizer.TokenFromKeyword("share"))) {
+			return nil, position + 1, fmt.Errorf("expected UPDATE or SHARE keyword at %d", endPosition(tokens, position+1))
+		}
+		if statement.Distinct {
+			return nil, position, fmt.Errorf("FOR %s is not allowed with DISTINCT", strings.ToUpper(strength.Value))
+		}
+		statement.Locking = strength
+		position += 2
 	}
-	tableToken := tokens[fromPosition+1]
 
-	return &SelectStatement{
-		Item: items,
-		From: *tableToken,
-	}, nil
+	return statement, position, nil
+}
+
+// parseJoin parses [INNER] JOIN table ON condition
+func parseJoin(tokens []*tokenizer.Token, position int) (*JoinClause, int, error) {
+	if isToken(tokens, position, tokenizer.TokenFromKeyword("inner")) {
+		position++
+	}
+	if !isToken(tokens, position, tokenizer.TokenFromKeyword("join")) {
+		return nil, position, fmt.Errorf("expected JOIN keyword at %d", endPosition(tokens, position))
+	}
+	position++
+	table := tokenizer.AsIdentifier(tokenAt(tokens, position))
+	if table == nil {
+		return nil, position, fmt.Errorf("expected table name identifier at %d", endPosition(tokens, position))
+	}
+	position++
+	if !isToken(tokens, position, tokenizer.TokenFromKeyword("on")) {
+		return nil, position, fmt.Errorf("expected ON keyword at %d", endPosition(tokens, position))
+	}
+	condition, position, err := parseExpression(tokens, position+1)
+	if err != nil {
+		return nil, position, err
+	}
+	if windowFunctionsOf(condition) != nil {
+		return nil, position, fmt.Errorf("window functions are not allowed in JOIN conditions")
+	}
+	return &JoinClause{Table: *table, On: condition}, position, nil
+}
+
+// parseRowCount parses non-negative number of LIMIT and OFFSET clauses
+func parseRowCount(tokens []*tokenizer.Token, position int) (*tokenizer.Token, int, error) {
+	count := tokenAt(tokens, position)
+	if count == nil || count.Kind != tokenizer.NumericKind {
+		return nil, position, fmt.Errorf("expected number of rows at %d", endPosition(tokens, position))
+	}
+	if strings.HasPrefix(count.Value, "-") {
+		return nil, position, fmt.Errorf("number of rows must not be negative, got: %s", count.Value)
+	}
+	return count, position + 1, nil
+}
+
+// startsClause checks if token is a keyword starting a clause which may
+// follow FROM, such keywords are not reserved but can not be aliases
+// without AS
+func startsClause(token *tokenizer.Token) bool {
+	for _, keyword := range []string{tokenizer.GroupKeyword, tokenizer.OrderKeyword,
+		tokenizer.LimitKeyword, tokenizer.OffsetKeyword} {
+		if token != nil && token.Equals(tokenizer.TokenFromKeyword(keyword)) {
+			return true
+		}
+	}
+	return false
 }
@@ -0,0 +1,50 @@
+package parser
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/VorobevPavel-dev/congenial-disco/tokenizer"
+)
+
+// SetStatement changes a setting of a session:
+//
+//	SET name { = | TO } value;
+//
+// Value is a number, a quoted string, an identifier or a keyword like ON.
+// SET TRANSACTION is parsed as TransactionStatement
+type SetStatement struct {
+	Name  tokenizer.Token `json:"name"`
+	Value tokenizer.Token `json:"value"`
+}
+
+func (ss *SetStatement) String() string {
+	bytes, _ := json.Marshal(ss)
+	return string(bytes)
+}
+
+func (ss *SetStatement) Equals(other *SetStatement) bool {
+	return ss.Name.Equals(&other.Name) && ss.Value.Equals(&other.Value)
+}
+
+func parseSetStatement(tokens []*tokenizer.Token) (*SetStatement, error) {
+	if !isToken(tokens, 0, tokenizer.TokenFromKeyword("set")) {
+		return nil, fmt.Errorf("expected SET keyword at %d", endPosition(tokens, 0))
+	}
+	name := tokenizer.AsIdentifier(tokenAt(tokens, 1))
+	if name == nil {
+		return nil, fmt.Errorf("expected setting name at %d", endPosition(tokens, 1))
+	}
+	if !isToken(tokens, 2, tokenizer.TokenFromSymbol("=")) && !isToken(tokens, 2, tokenizer.TokenFromKeyword("to")) {
+		return nil, fmt.Errorf("expected \"=\" or TO at %d", endPosition(tokens, 2))
+	}
+	value := tokenAt(tokens, 3)
+	if value == nil || (value.Kind != tokenizer.NumericKind && value.Kind != tokenizer.StringKind &&
+		value.Kind != tokenizer.IdentifierKind && value.Kind != tokenizer.KeywordKind) {
+		return nil, fmt.Errorf("expected value of setting at %d", endPosition(tokens, 3))
+	}
+	if !isToken(tokens, 4, tokenizer.TokenFromSymbol(";")) {
+		return nil, fmt.Errorf("cannot find \";\"  in the end of request")
+	}
+	return &SetStatement{Name: *name, Value: *value}, nil
+}
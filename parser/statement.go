@@ -1,11 +1,154 @@
 package parser
 
+import (
+	"context"
+	"fmt"
+
+	"github.com/VorobevPavel-dev/congenial-disco/tokenizer"
+)
+
 type Statement struct {
 	SelectStatement      *SelectStatement
 	CreateTableStatement *CreateTableStatement
 	InsertStatement      *InsertStatement
+	CompoundStatement    *CompoundStatement
+	TransactionStatement *TransactionStatement
+	LockTableStatement   *LockTableStatement
+	ExplainStatement     *ExplainStatement
+	AnalyzeStatement     *AnalyzeStatement
+	SetStatement         *SetStatement
+	CancelStatement      *CancelStatement
+}
+
+// Parse will tokenize request and parse it to statement of kind defined by
+// the first keyword of request
+func Parse(request string) (*Statement, error) {
+	return ParseContext(context.Background(), request)
+}
+
+// ParseContext is Parse which is aborted with error of context if it is done
+func ParseContext(ctx context.Context, request string) (*Statement, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	tokens := tokenizer.ParseTokenSequence(request)
+	if tokens == nil || len(*tokens) == 0 {
+		return nil, fmt.Errorf("empty request")
+	}
+	return parseStatement(*tokens)
+}
+
+// ParseScript will tokenize request consisting of several statements, each
+// terminated with ";", and parse them in order they are listed
+func ParseScript(request string) ([]*Statement, error) {
+	return ParseScriptContext(context.Background(), request)
+}
+
+// ParseScriptContext is ParseScript which is aborted with error of context
+// if it is done before all statements are parsed
+func ParseScriptContext(ctx context.Context, request string) ([]*Statement, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	tokens := tokenizer.ParseTokenSequence(request)
+	if tokens == nil || len(*tokens) == 0 {
+		return nil, fmt.Errorf("empty request")
+	}
+	var (
+		result []*Statement
+		start  int
+	)
+	for position, token := range *tokens {
+		if !token.Equals(tokenizer.TokenFromSymbol(";")) && position != len(*tokens)-1 {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		statement, err := parseStatement((*tokens)[start : position+1])
+		if err != nil {
+			return nil, fmt.Errorf("statement #%d: %v", len(result)+1, err)
+		}
+		result = append(result, statement)
+		start = position + 1
+	}
+	return result, nil
 }
 
-func Parse(request string) *Statement {
-	return nil
+func parseStatement(tokens []*tokenizer.Token) (*Statement, error) {
+	first := tokens[0]
+	switch {
+	case first.Equals(tokenizer.TokenFromKeyword("select")),
+		first.Equals(tokenizer.TokenFromKeyword("with")):
+		statement, err := parseCompoundStatement(tokens)
+		if err != nil {
+			return nil, err
+		}
+		// Plain SELECT is not wrapped into compound statement
+		if len(statement.Selects) == 1 && statement.With == nil {
+			return &Statement{SelectStatement: statement.Selects[0]}, nil
+		}
+		return &Statement{CompoundStatement: statement}, nil
+	case first.Equals(tokenizer.TokenFromKeyword("create")):
+		statement, err := parseCreateTableStatement(tokens)
+		if err != nil {
+			return nil, err
+		}
+		return &Statement{CreateTableStatement: statement}, nil
+	case first.Equals(tokenizer.TokenFromKeyword("insert")):
+		statement, err := parseInsertIntoStatement(tokens)
+		if err != nil {
+			return nil, err
+		}
+		return &Statement{InsertStatement: statement}, nil
+	case first.Equals(tokenizer.TokenFromKeyword("begin")),
+		first.Equals(tokenizer.TokenFromKeyword("commit")),
+		first.Equals(tokenizer.TokenFromKeyword("rollback")),
+		first.Equals(tokenizer.TokenFromKeyword("savepoint")),
+		first.Equals(tokenizer.TokenFromKeyword("release")):
+		statement, err := parseTransactionStatement(tokens)
+		if err != nil {
+			return nil, err
+		}
+		return &Statement{TransactionStatement: statement}, nil
+	case first.Equals(tokenizer.TokenFromKeyword("set")):
+		if isToken(tokens, 1, tokenizer.TokenFromKeyword("transaction")) {
+			statement, err := parseTransactionStatement(tokens)
+			if err != nil {
+				return nil, err
+			}
+			return &Statement{TransactionStatement: statement}, nil
+		}
+		statement, err := parseSetStatement(tokens)
+		if err != nil {
+			return nil, err
+		}
+		return &Statement{SetStatement: statement}, nil
+	case first.Equals(tokenizer.TokenFromKeyword("cancel")),
+		first.Equals(tokenizer.TokenFromKeyword("kill")):
+		statement, err := parseCancelStatement(tokens)
+		if err != nil {
+			return nil, err
+		}
+		return &Statement{CancelStatement: statement}, nil
+	case first.Equals(tokenizer.TokenFromKeyword("lock")):
+		statement, err := parseLockTableStatement(tokens)
+		if err != nil {
+			return nil, err
+		}
+		return &Statement{LockTableStatement: statement}, nil
+	case first.Equals(tokenizer.TokenFromKeyword("explain")):
+		statement, err := parseExplainStatement(tokens)
+		if err != nil {
+			return nil, err
+		}
+		return &Statement{ExplainStatement: statement}, nil
+	case first.Equals(tokenizer.TokenFromKeyword("analyze")):
+		statement, err := parseAnalyzeStatement(tokens)
+		if err != nil {
+			return nil, err
+		}
+		return &Statement{AnalyzeStatement: statement}, nil
+	}
+	return nil, fmt.Errorf("unsupported statement at %d: %s", first.Position, first.String())
 }
@@ -1,8 +1,12 @@
 package parser
 
 import (
+	"context"
+	"fmt"
+	"strings"
 	"testing"
 
+	"github.com/VorobevPavel-dev/congenial-disco/lock"
 	"github.com/VorobevPavel-dev/congenial-disco/tokenizer"
 )
 
@@ -14,10 +18,10 @@ func TestSelectStatementParsing(t *testing.T) {
 		}
 		expectedOutputs := []*SelectStatement{
 			{
-				Item: []*tokenizer.Token{
-					{Value: "a", Kind: tokenizer.IdentifierKind},
-					{Value: "b", Kind: tokenizer.IdentifierKind},
-					{Value: "c", Kind: tokenizer.IdentifierKind},
+				Item: []*Expression{
+					{Kind: ColumnExpression, Token: &tokenizer.Token{Value: "a", Kind: tokenizer.IdentifierKind}},
+					{Kind: ColumnExpression, Token: &tokenizer.Token{Value: "b", Kind: tokenizer.IdentifierKind}},
+					{Kind: ColumnExpression, Token: &tokenizer.Token{Value: "c", Kind: tokenizer.IdentifierKind}},
 				},
 				From: tokenizer.Token{
 					Value: "test",
@@ -25,8 +29,8 @@ func TestSelectStatementParsing(t *testing.T) {
 				},
 			},
 			{
-				Item: []*tokenizer.Token{
-					{Value: "a1", Kind: tokenizer.IdentifierKind},
+				Item: []*Expression{
+					{Kind: ColumnExpression, Token: &tokenizer.Token{Value: "a1", Kind: tokenizer.IdentifierKind}},
 				},
 				From: tokenizer.Token{
 					Value: "test",
@@ -66,6 +70,1507 @@ func TestSelectStatementParsing(t *testing.T) {
 	})
 }
 
+func TestSubqueryParsing(t *testing.T) {
+	column := func(table string, name string) *Expression {
+		expression := &Expression{
+			Kind:  ColumnExpression,
+			Token: &tokenizer.Token{Value: name, Kind: tokenizer.IdentifierKind},
+		}
+		if table != "" {
+			expression.Table = &tokenizer.Token{Value: table, Kind: tokenizer.IdentifierKind}
+		}
+		return expression
+	}
+	t.Run("Test valid subquery parsing", func(t *testing.T) {
+		inputs := []string{
+			"select a, (select max from limits) from test;",
+			"select a from test where a in (select b from other);",
+			"select a from test where not exists (select b from other where other.b = test.a);",
+			"select x from (select a from test) as t where x >= 10;",
+		}
+		expectedOutputs := []*SelectStatement{
+			{
+				Item: []*Expression{
+					column("", "a"),
+					{
+						Kind: SubqueryExpression,
+						Subquery: &SelectStatement{
+							Item: []*Expression{column("", "max")},
+							From: tokenizer.Token{Value: "limits", Kind: tokenizer.IdentifierKind},
+						},
+					},
+				},
+				From: tokenizer.Token{Value: "test", Kind: tokenizer.IdentifierKind},
+			},
+			{
+				Item: []*Expression{column("", "a")},
+				From: tokenizer.Token{Value: "test", Kind: tokenizer.IdentifierKind},
+				Where: &Expression{
+					Kind: InExpression,
+					Left: column("", "a"),
+					Subquery: &SelectStatement{
+						Item: []*Expression{column("", "b")},
+						From: tokenizer.Token{Value: "other", Kind: tokenizer.IdentifierKind},
+					},
+				},
+			},
+			{
+				Item: []*Expression{column("", "a")},
+				From: tokenizer.Token{Value: "test", Kind: tokenizer.IdentifierKind},
+				Where: &Expression{
+					Kind:  UnaryExpression,
+					Token: &tokenizer.Token{Value: "not", Kind: tokenizer.KeywordKind},
+					Left: &Expression{
+						Kind: ExistsExpression,
+						Subquery: &SelectStatement{
+							Item: []*Expression{column("", "b")},
+							From: tokenizer.Token{Value: "other", Kind: tokenizer.IdentifierKind},
+							Where: &Expression{
+								Kind:  BinaryExpression,
+								Token: &tokenizer.Token{Value: "=", Kind: tokenizer.SymbolKind},
+								Left:  column("other", "b"),
+								Right: column("test", "a"),
+							},
+						},
+					},
+				},
+			},
+			{
+				Item: []*Expression{column("", "x")},
+				From: tokenizer.Token{Value: "t", Kind: tokenizer.IdentifierKind},
+				FromSubquery: &SelectStatement{
+					Item: []*Expression{column("", "a")},
+					From: tokenizer.Token{Value: "test", Kind: tokenizer.IdentifierKind},
+				},
+				Where: &Expression{
+					Kind:  BinaryExpression,
+					Token: &tokenizer.Token{Value: ">=", Kind: tokenizer.SymbolKind},
+					Left:  column("", "x"),
+					Right: &Expression{
+						Kind:  LiteralExpression,
+						Token: &tokenizer.Token{Value: "10", Kind: tokenizer.NumericKind},
+					},
+				},
+			},
+		}
+		for testCase := range inputs {
+			tokenList := *tokenizer.ParseTokenSequence(inputs[testCase])
+			actualResult, err := parseSelectStatement(tokenList)
+			if err != nil {
+				t.Errorf("Parsing failed on set #%d: %v",
+					testCase, err)
+				continue
+			}
+			if !actualResult.Equals(expectedOutputs[testCase]) {
+				t.Errorf("Assertion failed. Expected: %s, got: %s",
+					expectedOutputs[testCase].String(), actualResult.String())
+			}
+		}
+	})
+	t.Run("Test invalid subquery parsing", func(t *testing.T) {
+		inputs := []string{
+			"select a from (select a from test);",
+			"select a from test where a in (select b from other;",
+			"select a from test where exists select b from other;",
+			"select a from test where a in ();",
+			"select a from test where a = ;",
+			"select a from (select a from test) order by a;",
+			"select a from (select a from test) limit 1;",
+		}
+		for testCase := range inputs {
+			tokenList := *tokenizer.ParseTokenSequence(inputs[testCase])
+			actualResult, err := parseSelectStatement(tokenList)
+			if err == nil {
+				t.Errorf("Expected error on set #%d. Values got: %v",
+					testCase, actualResult)
+			}
+		}
+		// Keywords of clauses following derived table are not its alias
+		_, err := Parse("select a from (select a from test) group by a;")
+		if err == nil || !strings.Contains(err.Error(), "expected alias") {
+			t.Errorf("Expected missing alias error, got: %v", err)
+		}
+	})
+	t.Run("Test correlated subquery detection", func(t *testing.T) {
+		inputs := []string{
+			"select a from test where exists (select b from other where other.b = test.a);",
+			"select a from test where a in (select b from other where other.c = 1);",
+		}
+		expectedOuter := []int{1, 0}
+		for testCase := range inputs {
+			tokenList := *tokenizer.ParseTokenSequence(inputs[testCase])
+			statement, err := parseSelectStatement(tokenList)
+			if err != nil {
+				t.Errorf("Parsing failed on set #%d: %v", testCase, err)
+				continue
+			}
+			if outer := statement.OuterReferences(); len(outer) != 0 {
+				t.Errorf("Top-level statement can not be correlated, got: %v", outer)
+			}
+			subquery := statement.Where.Subquery
+			outer := subquery.OuterReferences()
+			if len(outer) != expectedOuter[testCase] {
+				t.Errorf("Unexpected outer references on set #%d: %v", testCase, outer)
+				continue
+			}
+			for _, reference := range outer {
+				if reference.Table.Value != "test" {
+					t.Errorf("Unexpected outer reference on set #%d: %s", testCase, reference.String())
+				}
+			}
+		}
+	})
+}
+
+func TestCompoundStatementParsing(t *testing.T) {
+	column := func(name string) *Expression {
+		return &Expression{
+			Kind:  ColumnExpression,
+			Token: &tokenizer.Token{Value: name, Kind: tokenizer.IdentifierKind},
+		}
+	}
+	t.Run("Test valid compound statement parsing", func(t *testing.T) {
+		inputs := []string{
+			"select distinct a from test union all select b from other except select c from third;",
+			"select a, b from test intersect select c, d from other;",
+		}
+		expectedOutputs := []*CompoundStatement{
+			{
+				Selects: []*SelectStatement{
+					{
+						Distinct: true,
+						Item:     []*Expression{column("a")},
+						From:     tokenizer.Token{Value: "test", Kind: tokenizer.IdentifierKind},
+					},
+					{
+						Item: []*Expression{column("b")},
+						From: tokenizer.Token{Value: "other", Kind: tokenizer.IdentifierKind},
+					},
+					{
+						Item: []*Expression{column("c")},
+						From: tokenizer.Token{Value: "third", Kind: tokenizer.IdentifierKind},
+					},
+				},
+				Operators: []*SetOperator{
+					{Token: tokenizer.Token{Value: "union", Kind: tokenizer.KeywordKind}, All: true},
+					{Token: tokenizer.Token{Value: "except", Kind: tokenizer.KeywordKind}},
+				},
+			},
+			{
+				Selects: []*SelectStatement{
+					{
+						Item: []*Expression{column("a"), column("b")},
+						From: tokenizer.Token{Value: "test", Kind: tokenizer.IdentifierKind},
+					},
+					{
+						Item: []*Expression{column("c"), column("d")},
+						From: tokenizer.Token{Value: "other", Kind: tokenizer.IdentifierKind},
+					},
+				},
+				Operators: []*SetOperator{
+					{Token: tokenizer.Token{Value: "intersect", Kind: tokenizer.KeywordKind}},
+				},
+			},
+		}
+		for testCase := range inputs {
+			actualResult, err := Parse(inputs[testCase])
+			if err != nil {
+				t.Errorf("Parsing failed on set #%d: %v",
+					testCase, err)
+				continue
+			}
+			if actualResult.CompoundStatement == nil {
+				t.Errorf("Expected compound statement on set #%d, got: %v", testCase, actualResult)
+				continue
+			}
+			if !actualResult.CompoundStatement.Equals(expectedOutputs[testCase]) {
+				t.Errorf("Assertion failed. Expected: %s, got: %s",
+					expectedOutputs[testCase].String(), actualResult.CompoundStatement.String())
+			}
+		}
+	})
+	t.Run("Test plain select is not compound", func(t *testing.T) {
+		actualResult, err := Parse("select distinct a from test;")
+		if err != nil {
+			t.Fatalf("Parsing failed: %v", err)
+		}
+		if actualResult.SelectStatement == nil || !actualResult.SelectStatement.Distinct {
+			t.Errorf("Expected DISTINCT select statement, got: %v", actualResult)
+		}
+	})
+	t.Run("Test invalid compound statement parsing", func(t *testing.T) {
+		inputs := []string{
+			"select a from test union select b, c from other;",
+			"select a from test intersect all select b from other;",
+			"select a from test union;",
+			"select a from test union select b from other",
+		}
+		for testCase := range inputs {
+			actualResult, err := Parse(inputs[testCase])
+			if err == nil {
+				t.Errorf("Expected error on set #%d. Values got: %v",
+					testCase, actualResult)
+			}
+		}
+	})
+	t.Run("Test compound statement type checking", func(t *testing.T) {
+		schema := NewSchema(
+			&CreateTableStatement{
+				Name: tokenizer.Token{Value: "test", Kind: tokenizer.IdentifierKind},
+				Cols: []*ColumnDefinition{
+					{
+						Name:     tokenizer.Token{Value: "id", Kind: tokenizer.IdentifierKind},
+						Datatype: tokenizer.Token{Value: "int", Kind: tokenizer.TypeKind},
+					},
+					{
+						Name:     tokenizer.Token{Value: "name", Kind: tokenizer.IdentifierKind},
+						Datatype: tokenizer.Token{Value: "text", Kind: tokenizer.TypeKind},
+					},
+				},
+			},
+		)
+		inputs := []string{
+			"select id, name from test union select id, name from test;",
+			"select id from test except select id from (select id from test) t;",
+			"select id from test union select name from test;",
+			"select id from test union select missing from test;",
+		}
+		expectedValid := []bool{true, true, false, false}
+		for testCase := range inputs {
+			tokenList := *tokenizer.ParseTokenSequence(inputs[testCase])
+			statement, err := parseCompoundStatement(tokenList)
+			if err != nil {
+				t.Errorf("Parsing failed on set #%d: %v", testCase, err)
+				continue
+			}
+			err = statement.CheckTypes(schema)
+			if (err == nil) != expectedValid[testCase] {
+				t.Errorf("Unexpected type check result on set #%d: %v", testCase, err)
+			}
+		}
+	})
+}
+
+func TestWithClauseParsing(t *testing.T) {
+	schema := NewSchema(
+		&CreateTableStatement{
+			Name: tokenizer.Token{Value: "nodes", Kind: tokenizer.IdentifierKind},
+			Cols: []*ColumnDefinition{
+				{
+					Name:     tokenizer.Token{Value: "id", Kind: tokenizer.IdentifierKind},
+					Datatype: tokenizer.Token{Value: "int", Kind: tokenizer.TypeKind},
+				},
+				{
+					Name:     tokenizer.Token{Value: "parent", Kind: tokenizer.IdentifierKind},
+					Datatype: tokenizer.Token{Value: "int", Kind: tokenizer.TypeKind},
+				},
+				{
+					Name:     tokenizer.Token{Value: "name", Kind: tokenizer.IdentifierKind},
+					Datatype: tokenizer.Token{Value: "text", Kind: tokenizer.TypeKind},
+				},
+			},
+		},
+	)
+	t.Run("Test valid WITH clause parsing", func(t *testing.T) {
+		input := "with roots (node) as (select id from nodes where parent = 0), " +
+			"named as (select name from nodes where id in (select node from roots)) " +
+			"select name from named;"
+		expectedOutput := &WithClause{
+			Tables: []*CommonTableExpression{
+				{
+					Name:    tokenizer.Token{Value: "roots", Kind: tokenizer.IdentifierKind},
+					Columns: []*tokenizer.Token{{Value: "node", Kind: tokenizer.IdentifierKind}},
+				},
+				{
+					Name: tokenizer.Token{Value: "named", Kind: tokenizer.IdentifierKind},
+				},
+			},
+		}
+		actualResult, err := Parse(input)
+		if err != nil {
+			t.Fatalf("Parsing failed: %v", err)
+		}
+		statement := actualResult.CompoundStatement
+		if statement == nil || statement.With == nil {
+			t.Fatalf("Expected statement with WITH clause, got: %v", actualResult)
+		}
+		if statement.With.Recursive || len(statement.With.Tables) != len(expectedOutput.Tables) {
+			t.Fatalf("Assertion failed. Expected: %s, got: %s",
+				expectedOutput.String(), statement.With.String())
+		}
+		for index, cte := range statement.With.Tables {
+			expected := expectedOutput.Tables[index]
+			if !cte.Name.Equals(&expected.Name) || len(cte.Columns) != len(expected.Columns) || cte.IsRecursive() {
+				t.Errorf("Assertion failed on expression #%d. Expected: %s, got: %s",
+					index, expected.Name.String(), cte.Name.String())
+			}
+		}
+		if err := statement.CheckTypes(schema); err != nil {
+			t.Errorf("Type checking failed: %v", err)
+		}
+	})
+	t.Run("Test valid WITH RECURSIVE clause parsing", func(t *testing.T) {
+		input := "with recursive subtree (node) as (" +
+			"select id from nodes where id = 1 " +
+			"union all " +
+			"select id from nodes where parent in (select node from subtree)" +
+			") select node from subtree;"
+		actualResult, err := Parse(input)
+		if err != nil {
+			t.Fatalf("Parsing failed: %v", err)
+		}
+		with := actualResult.CompoundStatement.With
+		if !with.Recursive || len(with.Tables) != 1 || !with.Tables[0].IsRecursive() {
+			t.Errorf("Expected single recursive expression, got: %s", with.String())
+		}
+		if err := actualResult.CompoundStatement.CheckTypes(schema); err != nil {
+			t.Errorf("Type checking failed: %v", err)
+		}
+	})
+	t.Run("Test invalid WITH clause parsing", func(t *testing.T) {
+		inputs := []string{
+			"with t as select id from nodes select id from t;",
+			"with t (a, b) as (select id from nodes) select a from t;",
+			"with t as (select id from nodes), t as (select id from nodes) select id from t;",
+			"with t as (select id from nodes union select id from t) select id from t;",
+			"with recursive t as (select id from t union select id from nodes) select id from t;",
+			"with recursive t as (select id from nodes except select id from t) select id from t;",
+			"with t as (select id from nodes);",
+		}
+		for testCase := range inputs {
+			actualResult, err := Parse(inputs[testCase])
+			if err == nil {
+				t.Errorf("Expected error on set #%d. Values got: %v",
+					testCase, actualResult)
+			}
+		}
+	})
+	t.Run("Test WITH clause type checking", func(t *testing.T) {
+		inputs := []string{
+			"with t as (select name from nodes) select id from nodes union select name from t;",
+			"with recursive t (node) as (select id from nodes union select name from nodes where id in (select node from t)) select node from t;",
+		}
+		for testCase := range inputs {
+			actualResult, err := Parse(inputs[testCase])
+			if err != nil {
+				t.Errorf("Parsing failed on set #%d: %v", testCase, err)
+				continue
+			}
+			if err := actualResult.CompoundStatement.CheckTypes(schema); err == nil {
+				t.Errorf("Expected type error on set #%d", testCase)
+			}
+		}
+	})
+}
+
+func TestWindowFunctionParsing(t *testing.T) {
+	column := func(name string) *Expression {
+		return &Expression{
+			Kind:  ColumnExpression,
+			Token: &tokenizer.Token{Value: name, Kind: tokenizer.IdentifierKind},
+		}
+	}
+	t.Run("Test valid window function parsing", func(t *testing.T) {
+		input := "select name, ROW_NUMBER() over (partition by dept order by salary desc), " +
+			"sum(salary) over (partition by dept order by salary desc rows between 1 preceding and current row) " +
+			"from employees;"
+		expectedOutput := []*Expression{
+			column("name"),
+			{
+				Kind:  FunctionExpression,
+				Token: &tokenizer.Token{Value: "row_number", Kind: tokenizer.IdentifierKind},
+				Window: &WindowSpecification{
+					PartitionBy: []*Expression{column("dept")},
+					OrderBy:     []*OrderingTerm{{Expression: column("salary"), Descending: true}},
+				},
+			},
+			{
+				Kind:      FunctionExpression,
+				Token:     &tokenizer.Token{Value: "sum", Kind: tokenizer.IdentifierKind},
+				Arguments: []*Expression{column("salary")},
+				Window: &WindowSpecification{
+					PartitionBy: []*Expression{column("dept")},
+					OrderBy:     []*OrderingTerm{{Expression: column("salary"), Descending: true}},
+					Frame: &WindowFrame{
+						Unit: tokenizer.Token{Value: "rows", Kind: tokenizer.KeywordKind},
+						Start: &FrameBound{
+							Kind:   PrecedingBound,
+							Offset: &tokenizer.Token{Value: "1", Kind: tokenizer.NumericKind},
+						},
+						End: &FrameBound{Kind: CurrentRowBound},
+					},
+				},
+			},
+		}
+		tokenList := *tokenizer.ParseTokenSequence(input)
+		actualResult, err := parseSelectStatement(tokenList)
+		if err != nil {
+			t.Fatalf("Parsing failed: %v", err)
+		}
+		if !expressionsEqual(actualResult.Item, expectedOutput) {
+			t.Errorf("Assertion failed. Expected: %v, got: %s",
+				expectedOutput, actualResult.String())
+		}
+	})
+	t.Run("Test window functions grouping", func(t *testing.T) {
+		input := "select rank() over (order by a), lag(a, 1) over (partition by b), " +
+			"dense_rank() over (order by a), count(*) over (partition by b), first_value(a) over () from test;"
+		tokenList := *tokenizer.ParseTokenSequence(input)
+		statement, err := parseSelectStatement(tokenList)
+		if err != nil {
+			t.Fatalf("Parsing failed: %v", err)
+		}
+		expectedGroups := [][]string{{"rank", "dense_rank"}, {"lag", "count"}, {"first_value"}}
+		groups := statement.WindowGroups()
+		if len(groups) != len(expectedGroups) {
+			t.Fatalf("Expected %d groups, got: %d", len(expectedGroups), len(groups))
+		}
+		for index, group := range groups {
+			if len(group) != len(expectedGroups[index]) {
+				t.Errorf("Unexpected size of group #%d: %d", index, len(group))
+				continue
+			}
+			for function := range group {
+				if group[function].Token.Value != expectedGroups[index][function] {
+					t.Errorf("Unexpected function in group #%d: %s", index, group[function].Token.Value)
+				}
+			}
+		}
+	})
+	t.Run("Test invalid window function parsing", func(t *testing.T) {
+		inputs := []string{
+			"select row_number() from test;",
+			"select row_number(a) over () from test;",
+			"select lead() over (order by a) from test;",
+			"select upper(a) over () from test;",
+			"select sum(*) over () from test;",
+			"select sum(a) over (rows between current row and 1 preceding) from test;",
+			"select sum(a) over (rows unbounded following) from test;",
+			"select sum(a) over (range 1 preceding) from test;",
+			"select sum(a) over (partition a) from test;",
+			"select a from test where rank() over (order by a) = 1;",
+		}
+		for testCase := range inputs {
+			tokenList := *tokenizer.ParseTokenSequence(inputs[testCase])
+			actualResult, err := parseSelectStatement(tokenList)
+			if err == nil {
+				t.Errorf("Expected error on set #%d. Values got: %v",
+					testCase, actualResult)
+			}
+		}
+	})
+}
+
+func TestFunctionCallParsing(t *testing.T) {
+	schema := NewSchema(
+		&CreateTableStatement{
+			Name: tokenizer.Token{Value: "users", Kind: tokenizer.IdentifierKind},
+			Cols: []*ColumnDefinition{
+				{
+					Name:     tokenizer.Token{Value: "id", Kind: tokenizer.IdentifierKind},
+					Datatype: tokenizer.Token{Value: "int", Kind: tokenizer.TypeKind},
+				},
+				{
+					Name:     tokenizer.Token{Value: "name", Kind: tokenizer.IdentifierKind},
+					Datatype: tokenizer.Token{Value: "text", Kind: tokenizer.TypeKind},
+				},
+			},
+		},
+	)
+	t.Run("Test valid function call parsing", func(t *testing.T) {
+		input := "select UPPER(trim(name, ' ')), cast(id as TEXT) from users;"
+		expectedOutput := []*Expression{
+			{
+				Kind:  FunctionExpression,
+				Token: &tokenizer.Token{Value: "upper", Kind: tokenizer.IdentifierKind},
+				Arguments: []*Expression{
+					{
+						Kind:  FunctionExpression,
+						Token: &tokenizer.Token{Value: "trim", Kind: tokenizer.IdentifierKind},
+						Arguments: []*Expression{
+							{Kind: ColumnExpression, Token: &tokenizer.Token{Value: "name", Kind: tokenizer.IdentifierKind}},
+							{Kind: LiteralExpression, Token: &tokenizer.Token{Value: " ", Kind: tokenizer.StringKind}},
+						},
+					},
+				},
+			},
+			{
+				Kind:  FunctionExpression,
+				Token: &tokenizer.Token{Value: "cast", Kind: tokenizer.IdentifierKind},
+				Arguments: []*Expression{
+					{Kind: ColumnExpression, Token: &tokenizer.Token{Value: "id", Kind: tokenizer.IdentifierKind}},
+					{Kind: LiteralExpression, Token: &tokenizer.Token{Value: "text", Kind: tokenizer.TypeKind}},
+				},
+			},
+		}
+		tokenList := *tokenizer.ParseTokenSequence(input)
+		actualResult, err := parseSelectStatement(tokenList)
+		if err != nil {
+			t.Fatalf("Parsing failed: %v", err)
+		}
+		if !expressionsEqual(actualResult.Item, expectedOutput) {
+			t.Errorf("Assertion failed. Expected: %v, got: %s",
+				expectedOutput, actualResult.String())
+		}
+	})
+	t.Run("Test function call type checking", func(t *testing.T) {
+		inputs := []string{
+			"select upper(name), length(name), cast(name as int) from users;",
+			"select coalesce(id, 0), mod(id, 2), count(*) from users where length(name) > 3;",
+			"select name from users where id in (select abs(id) from users);",
+			"select upper(id) from users;",
+			"select name from users where substr(name) = 'a';",
+			"select coalesce(id, name) from users;",
+			"select unknown(id) from users;",
+			"select name from users where id in (select lower(id) from users);",
+		}
+		expectedValid := []bool{true, true, true, false, false, false, false, false}
+		expectedTypes := [][]string{{"text", "int", "int"}, {"int", "int", "int"}, {"text"}}
+		for testCase := range inputs {
+			tokenList := *tokenizer.ParseTokenSequence(inputs[testCase])
+			statement, err := parseSelectStatement(tokenList)
+			if err != nil {
+				t.Errorf("Parsing failed on set #%d: %v", testCase, err)
+				continue
+			}
+			err = statement.CheckTypes(schema)
+			if (err == nil) != expectedValid[testCase] {
+				t.Errorf("Unexpected type check result on set #%d: %v", testCase, err)
+			}
+			if err != nil {
+				continue
+			}
+			types, _ := statement.ColumnTypes(schema)
+			for index := range types {
+				if types[index].Value != expectedTypes[testCase][index] {
+					t.Errorf("Unexpected type of column #%d on set #%d: %s",
+						index, testCase, types[index].Value)
+				}
+			}
+		}
+	})
+	t.Run("Test comparison type checking", func(t *testing.T) {
+		inputs := []string{
+			"select name from users where id = 1 and name <> 'a';",
+			"select name from users where id between 1 and length(name) and name in ('a', 'b');",
+			"select name from users where id in (select id from users where name = 'a');",
+			"select name from users where id = 'a';",
+			"select name from users where 'a' < id;",
+			"select name from users where id between 1 and 'z';",
+			"select name from users where name in ('a', 1);",
+			"select name from users where id in (select name from users);",
+		}
+		expectedValid := []bool{true, true, true, false, false, false, false, false}
+		for testCase := range inputs {
+			tokenList := *tokenizer.ParseTokenSequence(inputs[testCase])
+			statement, err := parseSelectStatement(tokenList)
+			if err != nil {
+				t.Errorf("Parsing failed on set #%d: %v", testCase, err)
+				continue
+			}
+			err = statement.CheckTypes(schema)
+			if (err == nil) != expectedValid[testCase] {
+				t.Errorf("Unexpected type check result on set #%d: %v", testCase, err)
+			}
+		}
+	})
+	t.Run("Test invalid function call parsing", func(t *testing.T) {
+		inputs := []string{
+			"select cast(id) from users;",
+			"select cast(id as name) from users;",
+			"select upper(name from users;",
+			"select upper(name name) from users;",
+			"select upper(*) from users;",
+		}
+		for testCase := range inputs {
+			tokenList := *tokenizer.ParseTokenSequence(inputs[testCase])
+			actualResult, err := parseSelectStatement(tokenList)
+			if err == nil {
+				t.Errorf("Expected error on set #%d. Values got: %v",
+					testCase, actualResult)
+			}
+		}
+	})
+}
+
+func TestPredicateParsing(t *testing.T) {
+	column := func(name string) *Expression {
+		return &Expression{
+			Kind:  ColumnExpression,
+			Token: &tokenizer.Token{Value: name, Kind: tokenizer.IdentifierKind},
+		}
+	}
+	literal := func(value string, kind tokenizer.TokenKind) *Expression {
+		return &Expression{Kind: LiteralExpression, Token: &tokenizer.Token{Value: value, Kind: kind}}
+	}
+	t.Run("Test valid predicate parsing", func(t *testing.T) {
+		inputs := []string{
+			"select a from test where a not in (1, 2, b);",
+			"select a from test where name like '100!%%' escape '!';",
+			"select a from test where name not ilike 'a_c';",
+			"select a from test where a between 1 and b and c = 0;",
+		}
+		expectedOutputs := []*Expression{
+			{
+				Kind:      InExpression,
+				Left:      column("a"),
+				Arguments: []*Expression{literal("1", tokenizer.NumericKind), literal("2", tokenizer.NumericKind), column("b")},
+				Not:       true,
+			},
+			{
+				Kind:      LikeExpression,
+				Token:     &tokenizer.Token{Value: "like", Kind: tokenizer.KeywordKind},
+				Left:      column("name"),
+				Right:     literal("100!%%", tokenizer.StringKind),
+				Arguments: []*Expression{literal("!", tokenizer.StringKind)},
+			},
+			{
+				Kind:  LikeExpression,
+				Token: &tokenizer.Token{Value: "ilike", Kind: tokenizer.KeywordKind},
+				Left:  column("name"),
+				Right: literal("a_c", tokenizer.StringKind),
+				Not:   true,
+			},
+			{
+				Kind:  BinaryExpression,
+				Token: &tokenizer.Token{Value: "and", Kind: tokenizer.KeywordKind},
+				Left: &Expression{
+					Kind:      BetweenExpression,
+					Left:      column("a"),
+					Arguments: []*Expression{literal("1", tokenizer.NumericKind), column("b")},
+				},
+				Right: &Expression{
+					Kind:  BinaryExpression,
+					Token: &tokenizer.Token{Value: "=", Kind: tokenizer.SymbolKind},
+					Left:  column("c"),
+					Right: literal("0", tokenizer.NumericKind),
+				},
+			},
+		}
+		for testCase := range inputs {
+			tokenList := *tokenizer.ParseTokenSequence(inputs[testCase])
+			actualResult, err := parseSelectStatement(tokenList)
+			if err != nil {
+				t.Errorf("Parsing failed on set #%d: %v",
+					testCase, err)
+				continue
+			}
+			if !actualResult.Where.Equals(expectedOutputs[testCase]) {
+				t.Errorf("Assertion failed. Expected: %s, got: %s",
+					expectedOutputs[testCase].String(), actualResult.Where.String())
+			}
+		}
+	})
+	t.Run("Test valid CASE parsing", func(t *testing.T) {
+		input := "select case when a > 1 then 'big' else 'small' end, case a when 1 then b end from test;"
+		expectedOutput := []*Expression{
+			{
+				Kind: CaseExpression,
+				Arguments: []*Expression{
+					{
+						Kind:  BinaryExpression,
+						Token: &tokenizer.Token{Value: ">", Kind: tokenizer.SymbolKind},
+						Left:  column("a"),
+						Right: literal("1", tokenizer.NumericKind),
+					},
+					literal("big", tokenizer.StringKind),
+				},
+				Right: literal("small", tokenizer.StringKind),
+			},
+			{
+				Kind:      CaseExpression,
+				Left:      column("a"),
+				Arguments: []*Expression{literal("1", tokenizer.NumericKind), column("b")},
+			},
+		}
+		tokenList := *tokenizer.ParseTokenSequence(input)
+		actualResult, err := parseSelectStatement(tokenList)
+		if err != nil {
+			t.Fatalf("Parsing failed: %v", err)
+		}
+		if !expressionsEqual(actualResult.Item, expectedOutput) {
+			t.Errorf("Assertion failed. Expected: %v, got: %s",
+				expectedOutput, actualResult.String())
+		}
+		schema := NewSchema(&CreateTableStatement{
+			Name: tokenizer.Token{Value: "test", Kind: tokenizer.IdentifierKind},
+			Cols: []*ColumnDefinition{
+				{
+					Name:     tokenizer.Token{Value: "a", Kind: tokenizer.IdentifierKind},
+					Datatype: tokenizer.Token{Value: "int", Kind: tokenizer.TypeKind},
+				},
+				{
+					Name:     tokenizer.Token{Value: "b", Kind: tokenizer.IdentifierKind},
+					Datatype: tokenizer.Token{Value: "text", Kind: tokenizer.TypeKind},
+				},
+			},
+		})
+		types, err := actualResult.ColumnTypes(schema)
+		if err != nil || types[0].Value != "text" || types[1].Value != "text" {
+			t.Errorf("Unexpected CASE types: %v (%v)", types, err)
+		}
+		tokenList = *tokenizer.ParseTokenSequence("select case when a > 1 then 'big' else a end from test;")
+		actualResult, err = parseSelectStatement(tokenList)
+		if err != nil {
+			t.Fatalf("Parsing failed: %v", err)
+		}
+		if _, err := actualResult.ColumnTypes(schema); err == nil {
+			t.Errorf("Expected type error on CASE with results of different types")
+		}
+	})
+	t.Run("Test invalid predicate parsing", func(t *testing.T) {
+		inputs := []string{
+			"select a from test where a between 1;",
+			"select a from test where a not = 1;",
+			"select a from test where a like 'x' escape 'xy';",
+			"select a from test where a in (1, 2;",
+			"select case a end from test;",
+			"select case when a then 1 from test;",
+		}
+		for testCase := range inputs {
+			tokenList := *tokenizer.ParseTokenSequence(inputs[testCase])
+			actualResult, err := parseSelectStatement(tokenList)
+			if err == nil {
+				t.Errorf("Expected error on set #%d. Values got: %v",
+					testCase, actualResult)
+			}
+		}
+	})
+	t.Run("Test prefix LIKE rewriting", func(t *testing.T) {
+		inputs := []string{
+			"select a from test where name like 'ab%';",
+			"select a from test where name like 'ab_%' and a = 1;",
+			"select a from test where name like 'abc';",
+			"select a from test where name like '%ab';",
+			"select a from test where name not like 'ab%';",
+		}
+		expectedOutputs := []string{
+			"((name >= ab) and (name < ac))",
+			"((((name >= ab) and (name < ac)) and (name like ab_%)) and (a = 1))",
+			"(name = abc)",
+			"(name like %ab)",
+			"(name not like ab%)",
+		}
+		var format func(expression *Expression) string
+		format = func(expression *Expression) string {
+			switch expression.Kind {
+			case BinaryExpression:
+				return "(" + format(expression.Left) + " " + expression.Token.Value + " " + format(expression.Right) + ")"
+			case LikeExpression:
+				not := ""
+				if expression.Not {
+					not = "not "
+				}
+				return "(" + format(expression.Left) + " " + not + expression.Token.Value + " " + format(expression.Right) + ")"
+			}
+			return expression.Token.Value
+		}
+		for testCase := range inputs {
+			tokenList := *tokenizer.ParseTokenSequence(inputs[testCase])
+			statement, err := parseSelectStatement(tokenList)
+			if err != nil {
+				t.Errorf("Parsing failed on set #%d: %v", testCase, err)
+				continue
+			}
+			rewritten, err := RewritePrefixLike(statement.Where)
+			if err != nil {
+				t.Errorf("Rewriting failed on set #%d: %v", testCase, err)
+				continue
+			}
+			if actual := format(rewritten); actual != expectedOutputs[testCase] {
+				t.Errorf("Unexpected rewriting on set #%d: expected: %s, got: %s",
+					testCase, expectedOutputs[testCase], actual)
+			}
+		}
+	})
+}
+
+func TestTransactionStatementParsing(t *testing.T) {
+	t.Run("Test valid transaction statement parsing", func(t *testing.T) {
+		inputs := []string{
+			"BEGIN;",
+			"begin transaction;",
+			"Commit;",
+			"rollback transaction;",
+			"set transaction isolation level serializable;",
+			"begin isolation level repeatable read;",
+			"Begin Transaction Isolation Level Read Committed;",
+			"savepoint before_migration;",
+			"rollback to savepoint before_migration;",
+			"rollback transaction to before_migration;",
+			"release savepoint before_migration;",
+			"release before_migration;",
+		}
+		expectedOutputs := []*TransactionStatement{
+			{Action: tokenizer.Token{Value: "begin", Kind: tokenizer.KeywordKind}},
+			{Action: tokenizer.Token{Value: "begin", Kind: tokenizer.KeywordKind}},
+			{Action: tokenizer.Token{Value: "commit", Kind: tokenizer.KeywordKind}},
+			{Action: tokenizer.Token{Value: "rollback", Kind: tokenizer.KeywordKind}},
+			{
+				Action:         tokenizer.Token{Value: "set", Kind: tokenizer.KeywordKind},
+				IsolationLevel: SerializableIsolation,
+			},
+			{
+				Action:         tokenizer.Token{Value: "begin", Kind: tokenizer.KeywordKind},
+				IsolationLevel: RepeatableReadIsolation,
+			},
+			{
+				Action:         tokenizer.Token{Value: "begin", Kind: tokenizer.KeywordKind},
+				IsolationLevel: ReadCommittedIsolation,
+			},
+			{
+				Action:    tokenizer.Token{Value: "savepoint", Kind: tokenizer.KeywordKind},
+				Savepoint: &tokenizer.Token{Value: "before_migration", Kind: tokenizer.IdentifierKind},
+			},
+			{
+				Action:    tokenizer.Token{Value: "rollback", Kind: tokenizer.KeywordKind},
+				Savepoint: &tokenizer.Token{Value: "before_migration", Kind: tokenizer.IdentifierKind},
+			},
+			{
+				Action:    tokenizer.Token{Value: "rollback", Kind: tokenizer.KeywordKind},
+				Savepoint: &tokenizer.Token{Value: "before_migration", Kind: tokenizer.IdentifierKind},
+			},
+			{
+				Action:    tokenizer.Token{Value: "release", Kind: tokenizer.KeywordKind},
+				Savepoint: &tokenizer.Token{Value: "before_migration", Kind: tokenizer.IdentifierKind},
+			},
+			{
+				Action:    tokenizer.Token{Value: "release", Kind: tokenizer.KeywordKind},
+				Savepoint: &tokenizer.Token{Value: "before_migration", Kind: tokenizer.IdentifierKind},
+			},
+		}
+		for testCase := range inputs {
+			actualResult, err := Parse(inputs[testCase])
+			if err != nil {
+				t.Errorf("Parsing failed on set #%d: %v",
+					testCase, err)
+				continue
+			}
+			if actualResult.TransactionStatement == nil ||
+				!actualResult.TransactionStatement.Equals(expectedOutputs[testCase]) {
+				t.Errorf("Assertion failed. Expected: %s, got: %v",
+					expectedOutputs[testCase].String(), actualResult)
+			}
+		}
+	})
+	t.Run("Test invalid transaction statement parsing", func(t *testing.T) {
+		inputs := []string{
+			"begin",
+			"commit work;",
+			"rollback transaction test;",
+			"set transaction;",
+			"set transaction isolation level read;",
+			"commit isolation level serializable;",
+			"set isolation level serializable;",
+			"savepoint;",
+			"savepoint 1;",
+			"rollback to;",
+			"rollback savepoint before_migration;",
+			"release savepoint;",
+		}
+		for testCase := range inputs {
+			actualResult, err := Parse(inputs[testCase])
+			if err == nil {
+				t.Errorf("Expected error on set #%d. Values got: %v",
+					testCase, actualResult)
+			}
+		}
+	})
+}
+
+func TestScriptParsing(t *testing.T) {
+	t.Run("Test valid script parsing", func(t *testing.T) {
+		request := `begin;
+savepoint before_insert;
+insert into test (a) values (1);
+rollback to savepoint before_insert;
+commit;`
+		expectedActions := []string{"begin", "savepoint", "insert", "rollback", "commit"}
+		statements, err := ParseScript(request)
+		if err != nil {
+			t.Fatalf("Parsing failed: %v", err)
+		}
+		if len(statements) != len(expectedActions) {
+			t.Fatalf("Expected %d statements, got: %d", len(expectedActions), len(statements))
+		}
+		for index, statement := range statements {
+			var action string
+			switch {
+			case statement.TransactionStatement != nil:
+				action = statement.TransactionStatement.Action.Value
+			case statement.InsertStatement != nil:
+				action = "insert"
+			}
+			if action != expectedActions[index] {
+				t.Errorf("Assertion failed on statement #%d. Expected: %s, got: %v",
+					index, expectedActions[index], statement)
+			}
+		}
+		if !statements[3].TransactionStatement.Savepoint.Equals(
+			&tokenizer.Token{Value: "before_insert", Kind: tokenizer.IdentifierKind}) {
+			t.Errorf("Expected savepoint before_insert, got: %v", statements[3].TransactionStatement)
+		}
+	})
+	t.Run("Test invalid script parsing", func(t *testing.T) {
+		inputs := []string{
+			"",
+			"begin; savepoint a",
+			"begin; select from test; commit;",
+			"savepoint a; release savepoint;",
+		}
+		for testCase := range inputs {
+			actualResult, err := ParseScript(inputs[testCase])
+			if err == nil {
+				t.Errorf("Expected error on set #%d. Values got: %v",
+					testCase, actualResult)
+			}
+		}
+	})
+	t.Run("Test truncated statement parsing", func(t *testing.T) {
+		inputs := []string{
+			"create",
+			"create table t",
+			"create table t (a int",
+			"insert",
+			"insert into t values (1",
+			"insert into t (a, b",
+		}
+		for testCase := range inputs {
+			if actualResult, err := Parse(inputs[testCase]); err == nil {
+				t.Errorf("Expected error on set #%d. Values got: %v", testCase, actualResult)
+			}
+			if actualResult, err := ParseScript("begin; " + inputs[testCase]); err == nil {
+				t.Errorf("Expected error of script on set #%d. Values got: %v", testCase, actualResult)
+			}
+			if actualResult, err := Prepare(inputs[testCase]); err == nil {
+				t.Errorf("Expected error of prepared statement on set #%d. Values got: %v", testCase, actualResult)
+			}
+		}
+	})
+}
+
+func TestKeywordIdentifierParsing(t *testing.T) {
+	t.Run("Test non-reserved keywords as names", func(t *testing.T) {
+		inputs := []string{
+			"create table t (id int, level int);",
+			"create table t (id int, rows int);",
+			"create table query (row int, range int, current int, end int, mode int);",
+			"select level from t;",
+			"select rows, t.range from t where level = 1 and end > 2;",
+			"select group, limit from t order by update desc limit 1;",
+			"select count(level) over (partition by format order by rows) from t;",
+			"insert into t (set, to) values (1, 2);",
+			"select order from (select level from t) as order order by order;",
+			"analyze level;",
+		}
+		for testCase := range inputs {
+			if _, err := Parse(inputs[testCase]); err != nil {
+				t.Errorf("Parsing failed on set #%d: %v", testCase, err)
+			}
+		}
+		statement, _ := Parse(inputs[0])
+		expected := &tokenizer.Token{Value: "level", Kind: tokenizer.IdentifierKind}
+		if actual := statement.CreateTableStatement.Cols[1].Name; !actual.Equals(expected) {
+			t.Errorf("Assertion failed. Expected: %s, got: %s", expected.String(), actual.String())
+		}
+	})
+	t.Run("Test reserved keywords as names", func(t *testing.T) {
+		inputs := []string{
+			"create table t (id int, from int);",
+			"create table select (id int);",
+			"select where from t;",
+			"select a from t where and = 1;",
+		}
+		for testCase := range inputs {
+			actualResult, err := Parse(inputs[testCase])
+			if err == nil {
+				t.Errorf("Expected error on set #%d. Values got: %v",
+					testCase, actualResult)
+			}
+		}
+	})
+}
+
+func TestLockingParsing(t *testing.T) {
+	t.Run("Test valid locking clause parsing", func(t *testing.T) {
+		inputs := []string{
+			"select a from test where a = 1 for update;",
+			"select a from test for share;",
+		}
+		expectedModes := []lock.Mode{lock.Exclusive, lock.Shared}
+		for testCase := range inputs {
+			actualResult, err := Parse(inputs[testCase])
+			if err != nil {
+				t.Errorf("Parsing failed on set #%d: %v",
+					testCase, err)
+				continue
+			}
+			mode, ok := actualResult.SelectStatement.RowLockMode()
+			if !ok || mode != expectedModes[testCase] {
+				t.Errorf("Assertion failed on set #%d. Expected: %s, got: %s",
+					testCase, expectedModes[testCase], mode)
+			}
+		}
+	})
+	t.Run("Test valid lock table statement parsing", func(t *testing.T) {
+		inputs := []string{
+			"lock table test;",
+			"lock test in share mode;",
+			"lock table test in row exclusive mode;",
+			"lock table test in share row exclusive mode;",
+			"lock table test in row share mode;",
+		}
+		expectedOutputs := []*LockTableStatement{
+			{Table: tokenizer.Token{Value: "test", Kind: tokenizer.IdentifierKind}, Mode: lock.Exclusive},
+			{Table: tokenizer.Token{Value: "test", Kind: tokenizer.IdentifierKind}, Mode: lock.Shared},
+			{Table: tokenizer.Token{Value: "test", Kind: tokenizer.IdentifierKind}, Mode: lock.IntentionExclusive},
+			{Table: tokenizer.Token{Value: "test", Kind: tokenizer.IdentifierKind}, Mode: lock.SharedIntentionExclusive},
+			{Table: tokenizer.Token{Value: "test", Kind: tokenizer.IdentifierKind}, Mode: lock.IntentionShared},
+		}
+		for testCase := range inputs {
+			actualResult, err := Parse(inputs[testCase])
+			if err != nil {
+				t.Errorf("Parsing failed on set #%d: %v",
+					testCase, err)
+				continue
+			}
+			if actualResult.LockTableStatement == nil ||
+				!actualResult.LockTableStatement.Equals(expectedOutputs[testCase]) {
+				t.Errorf("Assertion failed. Expected: %s, got: %v",
+					expectedOutputs[testCase].String(), actualResult)
+			}
+		}
+	})
+	t.Run("Test invalid locking parsing", func(t *testing.T) {
+		inputs := []string{
+			"select a from test for;",
+			"select a from test for delete;",
+			"select distinct a from test for update;",
+			"select a from test for update union select a from test;",
+			"lock table;",
+			"lock table test in mode;",
+			"lock table test in row mode;",
+			"lock table test in share;",
+		}
+		for testCase := range inputs {
+			actualResult, err := Parse(inputs[testCase])
+			if err == nil {
+				t.Errorf("Expected error on set #%d. Values got: %v",
+					testCase, actualResult)
+			}
+		}
+	})
+}
+
+func TestClauseParsing(t *testing.T) {
+	t.Run("Test valid clause parsing", func(t *testing.T) {
+		inputs := []string{
+			"select a from test join other on test.a = other.b;",
+			"select a, count(b) from test group by a order by a desc, count(b);",
+			"select a from test limit 10 offset 5;",
+			"select a from test join other on a = b join third on b = c where a > 1 group by a limit 0;",
+		}
+		expectedOutputs := []string{
+			"tables: test other, group by: 0, order by: , limit: , offset: ",
+			"tables: test, group by: 1, order by: desc asc, limit: , offset: ",
+			"tables: test, group by: 0, order by: , limit: 10, offset: 5",
+			"tables: test other third, group by: 1, order by: , limit: 0, offset: ",
+		}
+		for testCase := range inputs {
+			actualResult, err := Parse(inputs[testCase])
+			if err != nil {
+				t.Errorf("Parsing failed on set #%d: %v",
+					testCase, err)
+				continue
+			}
+			slct := actualResult.SelectStatement
+			tables := slct.Tables()
+			var directions []string
+			for _, term := range slct.OrderBy {
+				if term.Descending {
+					directions = append(directions, "desc")
+				} else {
+					directions = append(directions, "asc")
+				}
+			}
+			var limit, offset string
+			if slct.Limit != nil {
+				limit = slct.Limit.Value
+			}
+			if slct.Offset != nil {
+				offset = slct.Offset.Value
+			}
+			actual := fmt.Sprintf("tables: %s, group by: %d, order by: %s, limit: %s, offset: %s",
+				strings.Join(tables, " "), len(slct.GroupBy), strings.Join(directions, " "), limit, offset)
+			if actual != expectedOutputs[testCase] {
+				t.Errorf("Assertion failed on set #%d. Expected: %s, got: %s",
+					testCase, expectedOutputs[testCase], actual)
+			}
+		}
+	})
+	t.Run("Test invalid clause parsing", func(t *testing.T) {
+		inputs := []string{
+			"select a from test join other;",
+			"select a from test join test on a = a;",
+			"select a from test group a;",
+			"select a from test order by;",
+			"select a from test limit a;",
+			"select a from test limit -1;",
+			"select a from test offset 1;",
+			"select a from test union select a from test order by a;",
+		}
+		for testCase := range inputs {
+			actualResult, err := Parse(inputs[testCase])
+			if err == nil {
+				t.Errorf("Expected error on set #%d. Values got: %v",
+					testCase, actualResult)
+			}
+		}
+	})
+}
+
+func TestExplainStatementParsing(t *testing.T) {
+	t.Run("Test valid EXPLAIN parsing", func(t *testing.T) {
+		inputs := []string{
+			"explain select a from test;",
+			"explain analyze select a from test where a > 1;",
+			"explain format json select a from test;",
+			"EXPLAIN ANALYZE FORMAT TEXT select a from test;",
+			"explain select a from test union select a from test;",
+			"explain analyze with t as (select a from test) select a from t;",
+		}
+		expectedOutputs := []struct {
+			analyze bool
+			format  string
+		}{
+			{false, ExplainTextFormat},
+			{true, ExplainTextFormat},
+			{false, ExplainJSONFormat},
+			{true, ExplainTextFormat},
+			{false, ExplainTextFormat},
+			{true, ExplainTextFormat},
+		}
+		for testCase := range inputs {
+			actualResult, err := Parse(inputs[testCase])
+			if err != nil {
+				t.Errorf("Parsing failed on set #%d: %v",
+					testCase, err)
+				continue
+			}
+			explain := actualResult.ExplainStatement
+			if explain == nil || (explain.Statement.SelectStatement == nil && explain.Statement.CompoundStatement == nil) ||
+				explain.Analyze != expectedOutputs[testCase].analyze ||
+				explain.Format != expectedOutputs[testCase].format {
+				t.Errorf("Assertion failed on set #%d. Expected: %v, got: %v",
+					testCase, expectedOutputs[testCase], explain)
+			}
+		}
+	})
+	t.Run("Test invalid EXPLAIN parsing", func(t *testing.T) {
+		inputs := []string{
+			"explain;",
+			"explain format select a from test;",
+			"explain format xml select a from test;",
+			"explain analyze analyze select a from test;",
+			"explain insert into test values (1);",
+			"explain select a from test union select a, b from test;",
+			"explain select a from test",
+		}
+		for testCase := range inputs {
+			actualResult, err := Parse(inputs[testCase])
+			if err == nil {
+				t.Errorf("Expected error on set #%d. Values got: %v",
+					testCase, actualResult)
+			}
+		}
+	})
+}
+
+func TestAnalyzeStatementParsing(t *testing.T) {
+	t.Run("Test valid ANALYZE parsing", func(t *testing.T) {
+		inputs := []string{
+			"analyze;",
+			"ANALYZE test;",
+		}
+		expectedOutputs := []*AnalyzeStatement{
+			{},
+			{Table: &tokenizer.Token{Value: "test", Kind: tokenizer.IdentifierKind}},
+		}
+		for testCase := range inputs {
+			actualResult, err := Parse(inputs[testCase])
+			if err != nil {
+				t.Errorf("Parsing failed on set #%d: %v",
+					testCase, err)
+				continue
+			}
+			if actualResult.AnalyzeStatement == nil ||
+				!actualResult.AnalyzeStatement.Equals(expectedOutputs[testCase]) {
+				t.Errorf("Assertion failed. Expected: %s, got: %v",
+					expectedOutputs[testCase].String(), actualResult)
+			}
+		}
+	})
+	t.Run("Test invalid ANALYZE parsing", func(t *testing.T) {
+		inputs := []string{
+			"analyze",
+			"analyze test other;",
+			"analyze 1;",
+		}
+		for testCase := range inputs {
+			actualResult, err := Parse(inputs[testCase])
+			if err == nil {
+				t.Errorf("Expected error on set #%d. Values got: %v",
+					testCase, actualResult)
+			}
+		}
+	})
+}
+
+func TestSetStatementParsing(t *testing.T) {
+	t.Run("Test valid SET parsing", func(t *testing.T) {
+		inputs := []string{
+			"set max_parallel_workers = 4;",
+			"SET work_memory TO '64MB';",
+			"set vectorized = on;",
+		}
+		expectedOutputs := []*SetStatement{
+			{
+				Name:  tokenizer.Token{Value: "max_parallel_workers", Kind: tokenizer.IdentifierKind},
+				Value: tokenizer.Token{Value: "4", Kind: tokenizer.NumericKind},
+			},
+			{
+				Name:  tokenizer.Token{Value: "work_memory", Kind: tokenizer.IdentifierKind},
+				Value: tokenizer.Token{Value: "64MB", Kind: tokenizer.StringKind},
+			},
+			{
+				Name:  tokenizer.Token{Value: "vectorized", Kind: tokenizer.IdentifierKind},
+				Value: tokenizer.Token{Value: "on", Kind: tokenizer.KeywordKind},
+			},
+		}
+		for testCase := range inputs {
+			actualResult, err := Parse(inputs[testCase])
+			if err != nil {
+				t.Errorf("Parsing failed on set #%d: %v",
+					testCase, err)
+				continue
+			}
+			if actualResult.SetStatement == nil ||
+				!actualResult.SetStatement.Equals(expectedOutputs[testCase]) {
+				t.Errorf("Assertion failed. Expected: %s, got: %v",
+					expectedOutputs[testCase].String(), actualResult)
+			}
+		}
+	})
+	t.Run("Test invalid SET parsing", func(t *testing.T) {
+		inputs := []string{
+			"set max_parallel_workers = 4",
+			"set max_parallel_workers 4;",
+			"set = 4;",
+			"set max_parallel_workers = ;",
+			"set max_parallel_workers = 4 5;",
+		}
+		for testCase := range inputs {
+			actualResult, err := Parse(inputs[testCase])
+			if err == nil {
+				t.Errorf("Expected error on set #%d. Values got: %v",
+					testCase, actualResult)
+			}
+		}
+	})
+}
+
+func TestCancelStatementParsing(t *testing.T) {
+	t.Run("Test valid CANCEL parsing", func(t *testing.T) {
+		inputs := []string{
+			"cancel 12;",
+			"CANCEL QUERY 7;",
+			"kill query 3;",
+		}
+		expectedOutputs := []*CancelStatement{
+			{Query: tokenizer.Token{Value: "12", Kind: tokenizer.NumericKind}},
+			{Query: tokenizer.Token{Value: "7", Kind: tokenizer.NumericKind}},
+			{Query: tokenizer.Token{Value: "3", Kind: tokenizer.NumericKind}},
+		}
+		for testCase := range inputs {
+			actualResult, err := Parse(inputs[testCase])
+			if err != nil {
+				t.Errorf("Parsing failed on set #%d: %v",
+					testCase, err)
+				continue
+			}
+			if actualResult.CancelStatement == nil ||
+				!actualResult.CancelStatement.Equals(expectedOutputs[testCase]) {
+				t.Errorf("Assertion failed. Expected: %s, got: %v",
+					expectedOutputs[testCase].String(), actualResult)
+			}
+		}
+	})
+	t.Run("Test invalid CANCEL parsing", func(t *testing.T) {
+		inputs := []string{
+			"cancel 12",
+			"kill 12;",
+			"cancel query;",
+			"cancel query name;",
+			"kill query 1 2;",
+		}
+		for testCase := range inputs {
+			actualResult, err := Parse(inputs[testCase])
+			if err == nil {
+				t.Errorf("Expected error on set #%d. Values got: %v",
+					testCase, actualResult)
+			}
+		}
+	})
+	t.Run("Test canceled parsing", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		if _, err := ParseContext(ctx, "select a from test;"); err != context.Canceled {
+			t.Errorf("Expected canceled parsing, got: %v", err)
+		}
+		if _, err := ParseScriptContext(ctx, "begin; commit;"); err != context.Canceled {
+			t.Errorf("Expected canceled parsing of script, got: %v", err)
+		}
+	})
+}
+
+func TestPreparedStatement(t *testing.T) {
+	schema := NewSchema(
+		&CreateTableStatement{
+			Name: tokenizer.Token{Value: "users", Kind: tokenizer.IdentifierKind},
+			Cols: []*ColumnDefinition{
+				{
+					Name:     tokenizer.Token{Value: "id", Kind: tokenizer.IdentifierKind},
+					Datatype: tokenizer.Token{Value: "int", Kind: tokenizer.TypeKind},
+				},
+				{
+					Name:     tokenizer.Token{Value: "name", Kind: tokenizer.IdentifierKind},
+					Datatype: tokenizer.Token{Value: "text", Kind: tokenizer.TypeKind},
+				},
+			},
+		},
+	)
+	intType := tokenizer.Token{Value: "int", Kind: tokenizer.TypeKind}
+	textType := tokenizer.Token{Value: "text", Kind: tokenizer.TypeKind}
+	t.Run("Test parameter type inference", func(t *testing.T) {
+		inputs := []string{
+			"select id from users where id = ? and name like ?;",
+			"select id from users where $2 = name or id between $1 and 10;",
+			"select id from users where id in (:id, 1, :other) and :name <> name;",
+			"insert into users (name, id) values (?, ?);",
+			"insert into users values ($1, 'test');",
+			"select id from users where name in (select name from users where id = ?);",
+		}
+		expectedOutputs := [][]*Parameter{
+			{{Name: "1", Type: &intType}, {Name: "2", Type: &textType}},
+			{{Name: "1", Type: &intType}, {Name: "2", Type: &textType}},
+			{{Name: "id", Type: &intType}, {Name: "other", Type: &intType}, {Name: "name", Type: &textType}},
+			{{Name: "1", Type: &textType}, {Name: "2", Type: &intType}},
+			{{Name: "1", Type: &intType}},
+			{{Name: "1", Type: &intType}},
+		}
+		for testCase := range inputs {
+			prepared, err := Prepare(inputs[testCase])
+			if err != nil {
+				t.Errorf("Preparing failed on set #%d: %v", testCase, err)
+				continue
+			}
+			if err := prepared.InferTypes(schema); err != nil {
+				t.Errorf("Type inference failed on set #%d: %v", testCase, err)
+				continue
+			}
+			if len(prepared.Parameters) != len(expectedOutputs[testCase]) {
+				t.Errorf("Assertion failed on set #%d. Expected %d parameters, got: %s",
+					testCase, len(expectedOutputs[testCase]), prepared.String())
+				continue
+			}
+			for index, expected := range expectedOutputs[testCase] {
+				actual := prepared.Parameters[index]
+				if actual.Name != expected.Name || !tokensEqual(actual.Type, expected.Type) {
+					t.Errorf("Assertion failed on set #%d. Expected: %v, got: %s",
+						testCase, expected, prepared.String())
+				}
+			}
+		}
+	})
+	t.Run("Test invalid prepared statements", func(t *testing.T) {
+		inputs := []string{
+			"select id from users where id = ? and name = $1;",
+			"select id from users where id = $2;",
+			"select id from users where id = :id and :id = name;",
+			"select id from users where ? = ?;",
+		}
+		for testCase := range inputs {
+			prepared, err := Prepare(inputs[testCase])
+			if err == nil {
+				err = prepared.InferTypes(schema)
+			}
+			if err == nil {
+				t.Errorf("Expected error on set #%d. Values got: %v",
+					testCase, prepared)
+			}
+		}
+	})
+	t.Run("Test binding parameters", func(t *testing.T) {
+		prepared, err := Prepare("select id from users where name = :name and id > :id;")
+		if err != nil {
+			t.Fatalf("Preparing failed: %v", err)
+		}
+		if err := prepared.InferTypes(schema); err != nil {
+			t.Fatalf("Type inference failed: %v", err)
+		}
+		injection := "x' or '1' = '1"
+		statement, err := prepared.Bind(Named("id", 10), Named("name", injection))
+		if err != nil {
+			t.Fatalf("Binding failed: %v", err)
+		}
+		expected, _ := Parse("select id from users where name = 'x'' or ''1'' = ''1' and id > 10;")
+		if !statement.SelectStatement.Equals(expected.SelectStatement) {
+			t.Errorf("Assertion failed. Expected: %s, got: %s",
+				expected.SelectStatement.String(), statement.SelectStatement.String())
+		}
+		// Prepared statement is not changed by binding
+		if _, err := prepared.Bind(Named("id", 20), Named("name", "test")); err != nil {
+			t.Errorf("Second binding failed: %v", err)
+		}
+
+		invalidArguments := [][]interface{}{
+			{Named("id", 10)},
+			{Named("id", "10"), Named("name", "test")},
+			{Named("id", 10), Named("name", "test"), Named("other", 1)},
+			{Named("id", 10), Named("name", nil)},
+			{10, "test"},
+		}
+		for testCase, arguments := range invalidArguments {
+			if statement, err := prepared.Bind(arguments...); err == nil {
+				t.Errorf("Expected error on set #%d. Values got: %v", testCase, statement)
+			}
+		}
+	})
+	t.Run("Test binding insert values", func(t *testing.T) {
+		prepared, err := Prepare("insert into users (id, name) values (?, ?);")
+		if err != nil {
+			t.Fatalf("Preparing failed: %v", err)
+		}
+		statement, err := prepared.Bind(int64(1), "test")
+		if err != nil {
+			t.Fatalf("Binding failed: %v", err)
+		}
+		expected := []*tokenizer.Token{
+			{Value: "1", Kind: tokenizer.NumericKind},
+			{Value: "test", Kind: tokenizer.StringKind},
+		}
+		if len(statement.InsertStatement.Values) != len(expected) {
+			t.Fatalf("Assertion failed. Expected 2 values, got: %s", statement.InsertStatement.String())
+		}
+		for index := range expected {
+			if !statement.InsertStatement.Values[index].Equals(expected[index]) {
+				t.Errorf("Assertion failed. Expected: %s, got: %s",
+					expected[index].String(), statement.InsertStatement.Values[index].String())
+			}
+		}
+	})
+}
+
 func TestInsertStatementParsing(t *testing.T) {
 	t.Run("Test valid select parsing", func(t *testing.T) {
 		inputs := []string{
@@ -98,6 +1603,23 @@ func TestInsertStatementParsing(t *testing.T) {
 			}
 		}
 	})
+	t.Run("Test invalid insert parsing", func(t *testing.T) {
+		inputs := []string{
+			"insert",
+			"insert into",
+			"insert into t values (1",
+			"insert into t (a, b",
+			"insert into t (a) values 1;",
+		}
+		for testCase := range inputs {
+			tokenList := *tokenizer.ParseTokenSequence(inputs[testCase])
+			actualResult, err := parseInsertIntoStatement(tokenList)
+			if err == nil {
+				t.Errorf("Expected error on set #%d. Values got: %v",
+					testCase, actualResult)
+			}
+		}
+	})
 	// t.Run("Test invalid select parsing", func(t *testing.T) {
 	// 	inputs := []string{
 	// 		"Select 1,b,c from test;",
@@ -121,6 +1643,9 @@ func TestCreateTableParsing(t *testing.T) {
 	t.Run("Test valid select parsing", func(t *testing.T) {
 		inputs := []string{
 			"create table test (id int, name text);",
+			"create table test (id int) with (storage = column);",
+			"create table test (id int) with (storage = row);",
+			"create table test (id int) with (storage = lsm);",
 		}
 		expectedOutputs := []*CreateTableStatement{
 			{
@@ -136,6 +1661,36 @@ func TestCreateTableParsing(t *testing.T) {
 					},
 				},
 			},
+			{
+				Name: tokenizer.Token{Value: "test", Kind: tokenizer.IdentifierKind},
+				Cols: []*ColumnDefinition{
+					{
+						Name:     tokenizer.Token{Value: "id", Kind: tokenizer.IdentifierKind},
+						Datatype: tokenizer.Token{Value: "int", Kind: tokenizer.TypeKind},
+					},
+				},
+				Storage: ColumnStorage,
+			},
+			{
+				Name: tokenizer.Token{Value: "test", Kind: tokenizer.IdentifierKind},
+				Cols: []*ColumnDefinition{
+					{
+						Name:     tokenizer.Token{Value: "id", Kind: tokenizer.IdentifierKind},
+						Datatype: tokenizer.Token{Value: "int", Kind: tokenizer.TypeKind},
+					},
+				},
+				Storage: RowStorage,
+			},
+			{
+				Name: tokenizer.Token{Value: "test", Kind: tokenizer.IdentifierKind},
+				Cols: []*ColumnDefinition{
+					{
+						Name:     tokenizer.Token{Value: "id", Kind: tokenizer.IdentifierKind},
+						Datatype: tokenizer.Token{Value: "int", Kind: tokenizer.TypeKind},
+					},
+				},
+				Storage: LSMStorage,
+			},
 		}
 		for testCase := range inputs {
 			tokenList := *tokenizer.ParseTokenSequence(inputs[testCase])
@@ -154,6 +1709,15 @@ func TestCreateTableParsing(t *testing.T) {
 		inputs := []string{
 			"create table test (id int, name text)",
 			"create table test id int, name text;",
+			"create table test (id int) with storage = column;",
+			"create table test (id int) with (storage column);",
+			"create table test (id int) with (storage = heap);",
+			"create table test (id int) with (format = column);",
+			"create table test (id int) with (storage = column, storage = row);",
+			"create table test (id int) with (storage = column;",
+			"create",
+			"create table t",
+			"create table t (a int",
 		}
 		for testCase := range inputs {
 			tokenList := *tokenizer.ParseTokenSequence(inputs[testCase])
@@ -0,0 +1,158 @@
+package parser
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/VorobevPavel-dev/congenial-disco/tokenizer"
+)
+
+// Transaction isolation levels
+const (
+	ReadUncommittedIsolation string = "read uncommitted"
+	ReadCommittedIsolation   string = "read committed"
+	RepeatableReadIsolation  string = "repeatable read"
+	SerializableIsolation    string = "serializable"
+)
+
+// TransactionStatement controls transaction of a session:
+//
+//	BEGIN [TRANSACTION] [ISOLATION LEVEL level];
+//	COMMIT [TRANSACTION];
+//	ROLLBACK [TRANSACTION] [TO [SAVEPOINT] name];
+//	SET TRANSACTION ISOLATION LEVEL level;
+//	SAVEPOINT name;
+//	RELEASE [SAVEPOINT] name;
+type TransactionStatement struct {
+	Action tokenizer.Token `json:"action"`
+	// IsolationLevel is one of isolation level constants. It can be set for
+	// BEGIN and SET only, empty value means default level of session
+	IsolationLevel string `json:"isolation_level,omitempty"`
+	// Savepoint is set for SAVEPOINT, RELEASE and ROLLBACK TO only
+	Savepoint *tokenizer.Token `json:"savepoint,omitempty"`
+}
+
+func (ts *TransactionStatement) String() string {
+	bytes, _ := json.Marshal(ts)
+	return string(bytes)
+}
+
+func (ts *TransactionStatement) Equals(other *TransactionStatement) bool {
+	return ts.Action.Equals(&other.Action) && ts.IsolationLevel == other.IsolationLevel &&
+		tokensEqual(ts.Savepoint, other.Savepoint)
+}
+
+func parseTransactionStatement(tokens []*tokenizer.Token) (*TransactionStatement, error) {
+	var (
+		statement = &TransactionStatement{}
+		position  = 1
+		err       error
+	)
+	action := tokenAt(tokens, 0)
+	switch {
+	case action == nil:
+		return nil, fmt.Errorf("expected transaction control keyword at %d", endPosition(tokens, 0))
+	case action.Equals(tokenizer.TokenFromKeyword("begin")):
+		// BEGIN [TRANSACTION] [ISOLATION LEVEL level];
+		if isToken(tokens, position, tokenizer.TokenFromKeyword("transaction")) {
+			position++
+		}
+		if isToken(tokens, position, tokenizer.TokenFromKeyword("isolation")) {
+			statement.IsolationLevel, position, err = parseIsolationLevel(tokens, position)
+			if err != nil {
+				return nil, err
+			}
+		}
+	case action.Equals(tokenizer.TokenFromKeyword("commit")):
+		// COMMIT [TRANSACTION];
+		if isToken(tokens, position, tokenizer.TokenFromKeyword("transaction")) {
+			position++
+		}
+	case action.Equals(tokenizer.TokenFromKeyword("rollback")):
+		// ROLLBACK [TRANSACTION] [TO [SAVEPOINT] name];
+		if isToken(tokens, position, tokenizer.TokenFromKeyword("transaction")) {
+			position++
+		}
+		if isToken(tokens, position, tokenizer.TokenFromKeyword("to")) {
+			position++
+			if isToken(tokens, position, tokenizer.TokenFromKeyword("savepoint")) {
+				position++
+			}
+			statement.Savepoint, position, err = parseSavepointName(tokens, position)
+			if err != nil {
+				return nil, err
+			}
+		}
+	case action.Equals(tokenizer.TokenFromKeyword("savepoint")):
+		// SAVEPOINT name;
+		statement.Savepoint, position, err = parseSavepointName(tokens, position)
+		if err != nil {
+			return nil, err
+		}
+	case action.Equals(tokenizer.TokenFromKeyword("release")):
+		// RELEASE [SAVEPOINT] name;
+		if isToken(tokens, position, tokenizer.TokenFromKeyword("savepoint")) {
+			position++
+		}
+		statement.Savepoint, position, err = parseSavepointName(tokens, position)
+		if err != nil {
+			return nil, err
+		}
+	case action.Equals(tokenizer.TokenFromKeyword("set")):
+		// SET TRANSACTION ISOLATION LEVEL level;
+		if !isToken(tokens, position, tokenizer.TokenFromKeyword("transaction")) {
+			return nil, fmt.Errorf("expected TRANSACTION keyword at %d", endPosition(tokens, position))
+		}
+		statement.IsolationLevel, position, err = parseIsolationLevel(tokens, position+1)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("expected transaction control keyword at %d", action.Position)
+	}
+	if !isToken(tokens, position, tokenizer.TokenFromSymbol(";")) {
+		return nil, fmt.Errorf("cannot find \";\"  in the end of request")
+	}
+	statement.Action = *action
+	return statement, nil
+}
+
+func parseSavepointName(tokens []*tokenizer.Token, position int) (*tokenizer.Token, int, error) {
+	name := tokenizer.AsIdentifier(tokenAt(tokens, position))
+	if name == nil {
+		return nil, position, fmt.Errorf("expected savepoint name at %d", endPosition(tokens, position))
+	}
+	return name, position + 1, nil
+}
+
+// parseIsolationLevel parses ISOLATION LEVEL clause and returns one of
+// isolation level constants
+func parseIsolationLevel(tokens []*tokenizer.Token, position int) (string, int, error) {
+	if !isToken(tokens, position, tokenizer.TokenFromKeyword("isolation")) {
+		return "", position, fmt.Errorf("expected ISOLATION keyword at %d", endPosition(tokens, position))
+	}
+	if !isToken(tokens, position+1, tokenizer.TokenFromKeyword("level")) {
+		return "", position + 1, fmt.Errorf("expected LEVEL keyword at %d", endPosition(tokens, position+1))
+	}
+	position += 2
+
+	levels := map[string][]string{
+		ReadUncommittedIsolation: {tokenizer.ReadKeyword, tokenizer.UncommittedKeyword},
+		ReadCommittedIsolation:   {tokenizer.ReadKeyword, tokenizer.CommittedKeyword},
+		RepeatableReadIsolation:  {tokenizer.RepeatableKeyword, tokenizer.ReadKeyword},
+		SerializableIsolation:    {tokenizer.SerializableKeyword},
+	}
+	for level, keywords := range levels {
+		matched := true
+		for index, keyword := range keywords {
+			if !isToken(tokens, position+index, tokenizer.TokenFromKeyword(keyword)) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return level, position + len(keywords), nil
+		}
+	}
+	return "", position, fmt.Errorf("expected isolation level at %d", endPosition(tokens, position))
+}
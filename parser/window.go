@@ -0,0 +1,304 @@
+package parser
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/VorobevPavel-dev/congenial-disco/function"
+	"github.com/VorobevPavel-dev/congenial-disco/tokenizer"
+)
+
+const (
+	// UnboundedPrecedingBound will correspond to UNBOUNDED PRECEDING
+	UnboundedPrecedingBound FrameBoundKind = iota
+	// PrecedingBound will correspond to <offset> PRECEDING
+	PrecedingBound
+	// CurrentRowBound will correspond to CURRENT ROW
+	CurrentRowBound
+	// FollowingBound will correspond to <offset> FOLLOWING
+	FollowingBound
+	// UnboundedFollowingBound will correspond to UNBOUNDED FOLLOWING
+	UnboundedFollowingBound
+)
+
+// FrameBoundKind defines bound of window frame. Kinds are ordered, so frame
+// start must never be greater than frame end
+type FrameBoundKind uint
+
+type FrameBound struct {
+	Kind FrameBoundKind `json:"kind"`
+	// Offset is set for PrecedingBound and FollowingBound only
+	Offset *tokenizer.Token `json:"offset,omitempty"`
+}
+
+func (fb *FrameBound) Equals(other *FrameBound) bool {
+	return fb.Kind == other.Kind && tokensEqual(fb.Offset, other.Offset)
+}
+
+// WindowFrame is ROWS or RANGE frame clause. Frame defined with a single bound
+// ends with the current row.
+type WindowFrame struct {
+	Unit  tokenizer.Token `json:"unit"`
+	Start *FrameBound     `json:"start"`
+	End   *FrameBound     `json:"end"`
+}
+
+func (wf *WindowFrame) Equals(other *WindowFrame) bool {
+	if wf == nil || other == nil {
+		return wf == other
+	}
+	return wf.Unit.Equals(&other.Unit) && wf.Start.Equals(other.Start) && wf.End.Equals(other.End)
+}
+
+type OrderingTerm struct {
+	Expression *Expression `json:"expression"`
+	Descending bool        `json:"descending,omitempty"`
+}
+
+func (ot *OrderingTerm) Equals(other *OrderingTerm) bool {
+	return ot.Descending == other.Descending && ot.Expression.Equals(other.Expression)
+}
+
+// WindowSpecification is content of OVER clause:
+//
+//	OVER ([PARTITION BY ...] [ORDER BY ... [ASC | DESC]] [ROWS | RANGE ...])
+type WindowSpecification struct {
+	PartitionBy []*Expression   `json:"partition_by,omitempty"`
+	OrderBy     []*OrderingTerm `json:"order_by,omitempty"`
+	Frame       *WindowFrame    `json:"frame,omitempty"`
+}
+
+func (ws *WindowSpecification) Equals(other *WindowSpecification) bool {
+	if ws == nil || other == nil {
+		return ws == other
+	}
+	if !expressionsEqual(ws.PartitionBy, other.PartitionBy) || len(ws.OrderBy) != len(other.OrderBy) {
+		return false
+	}
+	for index := range ws.OrderBy {
+		if !ws.OrderBy[index].Equals(other.OrderBy[index]) {
+			return false
+		}
+	}
+	return ws.Frame.Equals(other.Frame)
+}
+
+// WindowGroups returns window functions of select list grouped by their
+// window specifications, so that functions of one group can be evaluated
+// over partitions sorted only once
+func (slct *SelectStatement) WindowGroups() [][]*Expression {
+	var groups [][]*Expression
+	for _, function := range windowFunctionsOf(slct.Item...) {
+		found := false
+		for index, group := range groups {
+			if group[0].Window.Equals(function.Window) {
+				groups[index] = append(group, function)
+				found = true
+				break
+			}
+		}
+		if !found {
+			groups = append(groups, []*Expression{function})
+		}
+	}
+	return groups
+}
+
+// windowFunctionsOf returns all window functions used in given expressions
+// (but not in their subqueries)
+func windowFunctionsOf(expressions ...*Expression) []*Expression {
+	var result []*Expression
+	for _, expression := range expressions {
+		if expression == nil {
+			continue
+		}
+		if expression.Window != nil {
+			result = append(result, expression)
+		}
+		result = append(result, windowFunctionsOf(expression.Children()...)...)
+	}
+	return result
+}
+
+// checkWindowFunction checks that window functions have OVER clause, that
+// OVER is used only with window and aggregate functions and that they have
+// correct number of arguments ("*" is accepted by count only). Calls of
+// scalar functions are checked against their signatures by
+// SelectStatement.CheckTypes
+func checkWindowFunction(expression *Expression) error {
+	name := expression.Token.Value
+	for _, argument := range expression.Arguments {
+		if argument.Token != nil && argument.Token.Equals(tokenizer.TokenFromSymbol("*")) && name != "count" {
+			return fmt.Errorf("\"*\" argument is allowed only for count, got: %s", name)
+		}
+	}
+	definition, err := function.Builtin.Lookup(name)
+	switch {
+	case (err != nil || definition.Kind == function.ScalarFunction) && expression.Window != nil:
+		return fmt.Errorf("%s is not a window function", name)
+	case err != nil || definition.Kind == function.ScalarFunction:
+		return nil
+	case definition.Kind == function.WindowFunction && expression.Window == nil:
+		return fmt.Errorf("window function %s requires OVER clause", name)
+	}
+	return definition.CheckArity(len(expression.Arguments))
+}
+
+// parseWindowSpecification parses parenthesized window specification starting
+// from given position
+func parseWindowSpecification(tokens []*tokenizer.Token, position int) (*WindowSpecification, int, error) {
+	if !isToken(tokens, position, tokenizer.TokenFromSymbol("(")) {
+		return nil, position, fmt.Errorf("expected \"(\" symbol at %d", endPosition(tokens, position))
+	}
+	position++
+
+	var (
+		window = &WindowSpecification{}
+		err    error
+	)
+
+	// Process PARTITION BY
+	if isToken(tokens, position, tokenizer.TokenFromKeyword("partition")) {
+		if !isToken(tokens, position+1, tokenizer.TokenFromKeyword("by")) {
+			return nil, position + 1, fmt.Errorf("expected BY keyword at %d", endPosition(tokens, position+1))
+		}
+		position += 2
+		for {
+			var expression *Expression
+			expression, position, err = parseOperand(tokens, position)
+			if err != nil {
+				return nil, position, err
+			}
+			window.PartitionBy = append(window.PartitionBy, expression)
+			if !isToken(tokens, position, tokenizer.TokenFromSymbol(",")) {
+				break
+			}
+			position++
+		}
+	}
+
+	// Process ORDER BY
+	if isToken(tokens, position, tokenizer.TokenFromKeyword("order")) {
+		window.OrderBy, position, err = parseOrderBy(tokens, position)
+		if err != nil {
+			return nil, position, err
+		}
+	}
+
+	// Process frame clause
+	if isToken(tokens, position, tokenizer.TokenFromKeyword("rows")) ||
+		isToken(tokens, position, tokenizer.TokenFromKeyword("range")) {
+		window.Frame, position, err = parseWindowFrame(tokens, position)
+		if err != nil {
+			return nil, position, err
+		}
+		if window.Frame.Unit.Value == tokenizer.RangeKeyword &&
+			(window.Frame.Start.Offset != nil || window.Frame.End.Offset != nil) &&
+			len(window.OrderBy) != 1 {
+			return nil, position, fmt.Errorf("RANGE with offset requires exactly one ORDER BY column")
+		}
+	}
+
+	if !isToken(tokens, position, tokenizer.TokenFromSymbol(")")) {
+		return nil, position, fmt.Errorf("expected \")\" symbol at %d", endPosition(tokens, position))
+	}
+	return window, position + 1, nil
+}
+
+// parseOrderBy parses ORDER BY list starting from ORDER keyword
+func parseOrderBy(tokens []*tokenizer.Token, position int) ([]*OrderingTerm, int, error) {
+	if !isToken(tokens, position, tokenizer.TokenFromKeyword("order")) {
+		return nil, position, fmt.Errorf("expected ORDER keyword at %d", endPosition(tokens, position))
+	}
+	if !isToken(tokens, position+1, tokenizer.TokenFromKeyword("by")) {
+		return nil, position + 1, fmt.Errorf("expected BY keyword at %d", endPosition(tokens, position+1))
+	}
+	position += 2
+
+	var terms []*OrderingTerm
+	for {
+		expression, nextPosition, err := parseOperand(tokens, position)
+		if err != nil {
+			return nil, nextPosition, err
+		}
+		position = nextPosition
+		term := &OrderingTerm{Expression: expression}
+		if isToken(tokens, position, tokenizer.TokenFromKeyword("desc")) {
+			term.Descending = true
+			position++
+		} else if isToken(tokens, position, tokenizer.TokenFromKeyword("asc")) {
+			position++
+		}
+		terms = append(terms, term)
+		if !isToken(tokens, position, tokenizer.TokenFromSymbol(",")) {
+			break
+		}
+		position++
+	}
+	return terms, position, nil
+}
+
+// parseWindowFrame parses frame clause starting from ROWS or RANGE keyword:
+//
+//	{ROWS | RANGE} {bound | BETWEEN bound AND bound}
+func parseWindowFrame(tokens []*tokenizer.Token, position int) (*WindowFrame, int, error) {
+	frame := &WindowFrame{Unit: *tokens[position]}
+	position++
+
+	var err error
+	if isToken(tokens, position, tokenizer.TokenFromKeyword("between")) {
+		frame.Start, position, err = parseFrameBound(tokens, position+1)
+		if err != nil {
+			return nil, position, err
+		}
+		if !isToken(tokens, position, tokenizer.TokenFromKeyword("and")) {
+			return nil, position, fmt.Errorf("expected AND keyword at %d", endPosition(tokens, position))
+		}
+		frame.End, position, err = parseFrameBound(tokens, position+1)
+		if err != nil {
+			return nil, position, err
+		}
+	} else {
+		frame.Start, position, err = parseFrameBound(tokens, position)
+		if err != nil {
+			return nil, position, err
+		}
+		frame.End = &FrameBound{Kind: CurrentRowBound}
+	}
+
+	switch {
+	case frame.Start.Kind == UnboundedFollowingBound:
+		return nil, position, fmt.Errorf("frame can not start with UNBOUNDED FOLLOWING")
+	case frame.End.Kind == UnboundedPrecedingBound:
+		return nil, position, fmt.Errorf("frame can not end with UNBOUNDED PRECEDING")
+	case frame.Start.Kind > frame.End.Kind:
+		return nil, position, fmt.Errorf("frame start can not be after frame end")
+	}
+	return frame, position, nil
+}
+
+func parseFrameBound(tokens []*tokenizer.Token, position int) (*FrameBound, int, error) {
+	token := tokenAt(tokens, position)
+	if token == nil {
+		return nil, position, fmt.Errorf("expected frame bound at %d", endPosition(tokens, position))
+	}
+	next := tokenAt(tokens, position+1)
+	preceding := next != nil && next.Equals(tokenizer.TokenFromKeyword("preceding"))
+	following := next != nil && next.Equals(tokenizer.TokenFromKeyword("following"))
+	switch {
+	case token.Equals(tokenizer.TokenFromKeyword("unbounded")) && preceding:
+		return &FrameBound{Kind: UnboundedPrecedingBound}, position + 2, nil
+	case token.Equals(tokenizer.TokenFromKeyword("unbounded")) && following:
+		return &FrameBound{Kind: UnboundedFollowingBound}, position + 2, nil
+	case token.Equals(tokenizer.TokenFromKeyword("current")) &&
+		next != nil && next.Equals(tokenizer.TokenFromKeyword("row")):
+		return &FrameBound{Kind: CurrentRowBound}, position + 2, nil
+	case token.Kind == tokenizer.NumericKind && strings.HasPrefix(token.Value, "-"):
+		return nil, position, fmt.Errorf("frame offset must not be negative, got: %s", token.Value)
+	case token.Kind == tokenizer.NumericKind && preceding:
+		return &FrameBound{Kind: PrecedingBound, Offset: token}, position + 2, nil
+	case token.Kind == tokenizer.NumericKind && following:
+		return &FrameBound{Kind: FollowingBound, Offset: token}, position + 2, nil
+	}
+	return nil, position, fmt.Errorf("expected frame bound at %d, got: %s", token.Position, token.String())
+}
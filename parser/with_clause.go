@@ -0,0 +1,255 @@
+package parser
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/VorobevPavel-dev/congenial-disco/tokenizer"
+)
+
+// CommonTableExpression is a named query of WITH clause:
+//
+//	name [(column, ...)] AS (SELECT ...)
+type CommonTableExpression struct {
+	Name    tokenizer.Token    `json:"name"`
+	Columns []*tokenizer.Token `json:"columns,omitempty"`
+	Query   *CompoundStatement `json:"query"`
+}
+
+func (cte *CommonTableExpression) Equals(other *CommonTableExpression) bool {
+	if len(cte.Columns) != len(other.Columns) {
+		return false
+	}
+	for index := range cte.Columns {
+		if !cte.Columns[index].Equals(other.Columns[index]) {
+			return false
+		}
+	}
+	return cte.Name.Equals(&other.Name) && cte.Query.Equals(other.Query)
+}
+
+// IsRecursive checks if query of expression refers to expression itself
+func (cte *CommonTableExpression) IsRecursive() bool {
+	for _, statement := range cte.Query.Selects {
+		if statement.References(cte.Name.Value) {
+			return true
+		}
+	}
+	return false
+}
+
+// WithClause is a set of common table expressions preceding a query. Every
+// expression can refer to the ones defined before it and, if clause is
+// RECURSIVE, to itself.
+type WithClause struct {
+	Recursive bool                     `json:"recursive,omitempty"`
+	Tables    []*CommonTableExpression `json:"tables"`
+}
+
+func (w *WithClause) String() string {
+	bytes, _ := json.Marshal(w)
+	return string(bytes)
+}
+
+func (w *WithClause) Equals(other *WithClause) bool {
+	if w == nil || other == nil {
+		return w == other
+	}
+	if w.Recursive != other.Recursive || len(w.Tables) != len(other.Tables) {
+		return false
+	}
+	for index := range w.Tables {
+		if !w.Tables[index].Equals(other.Tables[index]) {
+			return false
+		}
+	}
+	return true
+}
+
+// Schema returns copy of given schema extended with definitions of common
+// table expressions. Types of columns of recursive expressions are taken from
+// their non-recursive (first) SELECT.
+func (w *WithClause) Schema(base Schema) (Schema, error) {
+	schema := make(Schema, len(base)+len(w.Tables))
+	for name, table := range base {
+		schema[name] = table
+	}
+	for _, cte := range w.Tables {
+		if !cte.IsRecursive() {
+			if err := cte.Query.CheckTypes(schema); err != nil {
+				return nil, err
+			}
+		}
+		types, err := cte.Query.Selects[0].ColumnTypes(schema)
+		if err != nil {
+			return nil, err
+		}
+		definition := &CreateTableStatement{Name: cte.Name}
+		for index, item := range cte.Query.Selects[0].Item {
+			var name tokenizer.Token
+			switch {
+			case cte.Columns != nil:
+				name = *cte.Columns[index]
+			case item.Kind == ColumnExpression:
+				name = *item.Token
+			default:
+				return nil, fmt.Errorf("column #%d of %s must be named in column list", index+1, cte.Name.Value)
+			}
+			definition.Cols = append(definition.Cols, &ColumnDefinition{Name: name, Datatype: *types[index]})
+		}
+		schema[cte.Name.Value] = definition
+		if cte.IsRecursive() {
+			if err := cte.Query.CheckTypes(schema); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return schema, nil
+}
+
+// References checks if statement or any of its subqueries reads from given
+// table
+func (slct *SelectStatement) References(table string) bool {
+	if slct.FromSubquery != nil {
+		if slct.FromSubquery.References(table) {
+			return true
+		}
+	} else if slct.From.Value == table {
+		return true
+	}
+	for _, join := range slct.Joins {
+		if join.Table.Value == table {
+			return true
+		}
+	}
+	for _, expression := range slct.expressions() {
+		if expressionReferences(expression, table) {
+			return true
+		}
+	}
+	return false
+}
+
+func expressionReferences(expression *Expression, table string) bool {
+	if expression == nil {
+		return false
+	}
+	if expression.Subquery != nil && expression.Subquery.References(table) {
+		return true
+	}
+	for _, child := range expression.Children() {
+		if expressionReferences(child, table) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseWithClause parses WITH [RECURSIVE] clause starting from given position
+func parseWithClause(tokens []*tokenizer.Token, position int) (*WithClause, int, error) {
+	// WITH [RECURSIVE] name [(column, ...)] AS (SELECT ...) [, ...]
+	if !isToken(tokens, position, tokenizer.TokenFromKeyword("with")) {
+		return nil, position, fmt.Errorf("expected WITH keyword at %d", endPosition(tokens, position))
+	}
+	position++
+
+	clause := &WithClause{}
+	if isToken(tokens, position, tokenizer.TokenFromKeyword("recursive")) {
+		clause.Recursive = true
+		position++
+	}
+
+	for {
+		cte, nextPosition, err := parseCommonTableExpression(tokens, position)
+		if err != nil {
+			return nil, nextPosition, err
+		}
+		position = nextPosition
+		for _, defined := range clause.Tables {
+			if defined.Name.Value == cte.Name.Value {
+				return nil, position, fmt.Errorf("common table expression %s is defined more than once", cte.Name.Value)
+			}
+		}
+		if cte.IsRecursive() {
+			if err := checkRecursiveExpression(clause, cte); err != nil {
+				return nil, position, err
+			}
+		}
+		clause.Tables = append(clause.Tables, cte)
+
+		if !isToken(tokens, position, tokenizer.TokenFromSymbol(",")) {
+			break
+		}
+		position++
+	}
+	return clause, position, nil
+}
+
+func parseCommonTableExpression(tokens []*tokenizer.Token, position int) (*CommonTableExpression, int, error) {
+	name := tokenizer.AsIdentifier(tokenAt(tokens, position))
+	if name == nil {
+		return nil, position, fmt.Errorf("expected common table expression name at %d", endPosition(tokens, position))
+	}
+	cte := &CommonTableExpression{Name: *name}
+	position++
+
+	// Process optional list of column names
+	if isToken(tokens, position, tokenizer.TokenFromSymbol("(")) {
+		position++
+		for !isToken(tokens, position, tokenizer.TokenFromSymbol(")")) {
+			column := tokenAt(tokens, position)
+			switch {
+			case column == nil:
+				return nil, position, fmt.Errorf("expected \")\" symbol at %d", endPosition(tokens, position))
+			case column.Equals(tokenizer.TokenFromSymbol(",")):
+			case tokenizer.AsIdentifier(column) != nil:
+				cte.Columns = append(cte.Columns, tokenizer.AsIdentifier(column))
+			default:
+				return nil, position, fmt.Errorf("column names are only can be identifiers, got: %s", column.String())
+			}
+			position++
+		}
+		position++
+	}
+
+	if !isToken(tokens, position, tokenizer.TokenFromKeyword("as")) {
+		return nil, position, fmt.Errorf("expected AS keyword at %d", endPosition(tokens, position))
+	}
+	position++
+	if !startsSubquery(tokens, position) {
+		return nil, position, fmt.Errorf("expected \"(\" symbol at %d", endPosition(tokens, position))
+	}
+	query, position, err := parseCompound(tokens, position+1)
+	if err != nil {
+		return nil, position, err
+	}
+	if !isToken(tokens, position, tokenizer.TokenFromSymbol(")")) {
+		return nil, position, fmt.Errorf("expected \")\" symbol at %d", endPosition(tokens, position))
+	}
+	cte.Query = query
+	position++
+
+	if cte.Columns != nil && len(cte.Columns) != len(query.Selects[0].Item) {
+		return nil, position, fmt.Errorf("%s has %d columns, but %d columns are specified",
+			cte.Name.Value, len(query.Selects[0].Item), len(cte.Columns))
+	}
+	return cte, position, nil
+}
+
+// checkRecursiveExpression checks that recursive expression has a form of
+//
+//	non-recursive SELECT UNION [ALL] recursive SELECT [UNION [ALL] ...]
+func checkRecursiveExpression(clause *WithClause, cte *CommonTableExpression) error {
+	if !clause.Recursive {
+		return fmt.Errorf("%s refers to itself, but WITH clause is not RECURSIVE", cte.Name.Value)
+	}
+	if cte.Query.Selects[0].References(cte.Name.Value) {
+		return fmt.Errorf("first SELECT of recursive %s must not refer to it", cte.Name.Value)
+	}
+	for _, operator := range cte.Query.Operators {
+		if !operator.Token.Equals(tokenizer.TokenFromKeyword("union")) {
+			return fmt.Errorf("recursive %s must use UNION [ALL], got: %s", cte.Name.Value, operator.Token.Value)
+		}
+	}
+	return nil
+}
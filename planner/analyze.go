@@ -0,0 +1,63 @@
+package planner
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/VorobevPavel-dev/congenial-disco/parser"
+	"github.com/VorobevPavel-dev/congenial-disco/statistics"
+)
+
+// Analyze collects statistics of table of ANALYZE statement (or of every
+// table of schema) from rows of source and saves them to catalog
+func Analyze(statement *parser.AnalyzeStatement, schema parser.Schema, source Source, catalog *statistics.Catalog) error {
+	var tables []string
+	if statement.Table != nil {
+		if _, ok := schema[statement.Table.Value]; !ok {
+			return fmt.Errorf("table %s does not exist", statement.Table.Value)
+		}
+		tables = append(tables, statement.Table.Value)
+	} else {
+		for table := range schema {
+			tables = append(tables, table)
+		}
+		sort.Strings(tables)
+	}
+	for _, table := range tables {
+		var columns []string
+		for _, column := range schema[table].Cols {
+			columns = append(columns, column.Name.Value)
+		}
+		result, err := analyzeTable(source, table, columns)
+		if err != nil {
+			return err
+		}
+		catalog.Set(table, result)
+	}
+	return nil
+}
+
+func analyzeTable(source Source, table string, columns []string) (result *statistics.TableStatistics, err error) {
+	iterator, err := source.Scan(table, columns)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if closeErr := iterator.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+	builder := statistics.NewBuilder(columns)
+	for {
+		row, err := iterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		if row == nil {
+			return builder.Finish(), nil
+		}
+		if err := builder.Add(row); err != nil {
+			return nil, err
+		}
+	}
+}
@@ -0,0 +1,211 @@
+package planner
+
+import (
+	"fmt"
+
+	"github.com/VorobevPavel-dev/congenial-disco/parser"
+)
+
+// apply lowers apply to operator evaluating subquery for input rows. Outer
+// rows are found by numbers of applies, so that subqueries nested in
+// subquery read rows of their own applies. Like the recursive part of
+// recursive union, subquery is evaluated again and again, so it is lowered to
+// serial operators
+func (l *lowering) apply(apply *Apply) (Operator, error) {
+	input, err := l.lower(apply.Input)
+	if err != nil {
+		return nil, err
+	}
+	outer := &outerRow{columns: apply.Input.Columns()}
+	rows, workers := l.outer, l.workers
+	defer func() { l.outer, l.workers = rows, workers }()
+	l.outer = map[int]*outerRow{apply.Number: outer}
+	for number, other := range rows {
+		if number != apply.Number {
+			l.outer[number] = other
+		}
+	}
+	l.workers = 1
+	subquery, err := l.lower(apply.Subquery)
+	if err != nil {
+		return nil, err
+	}
+	operator := &applyOperator{
+		input:      input,
+		subquery:   subquery,
+		outer:      outer,
+		expression: apply.Expression,
+		correlated: apply.Correlated(),
+	}
+	if apply.Expression.Kind == parser.InExpression {
+		if operator.left, err = compile(apply.Expression.Left, apply.Input.Columns()); err != nil {
+			return nil, err
+		}
+	}
+	return operator, nil
+}
+
+// outerRowScan lowers outer row to scan of the row apply evaluates subquery
+// for
+func (l *lowering) outerRowScan(row *OuterRow) (Operator, error) {
+	outer, ok := l.outer[row.Number]
+	if !ok {
+		return nil, fmt.Errorf("outer row of $subquery%d is read outside of its apply", row.Number)
+	}
+	scan := &outerRowScan{outer: outer}
+	for _, column := range row.Output {
+		index := -1
+		for candidate, input := range outer.columns {
+			if input.Table == column.Table && input.Name == column.Name {
+				index = candidate
+				break
+			}
+		}
+		if index == -1 {
+			return nil, fmt.Errorf("column %s is not an input column of $subquery%d", column, row.Number)
+		}
+		scan.indexes = append(scan.indexes, index)
+	}
+	return scan, nil
+}
+
+// outerRow keeps input row apply operator evaluates subquery for
+type outerRow struct {
+	columns []Column
+	row     Row
+}
+
+// outerRowScan produces one row of values of outer row columns
+type outerRowScan struct {
+	outer   *outerRow
+	indexes []int
+	done    bool
+}
+
+func (ors *outerRowScan) Open() error {
+	ors.done = false
+	return nil
+}
+
+func (ors *outerRowScan) Next() (Row, error) {
+	if ors.done {
+		return nil, nil
+	}
+	ors.done = true
+	row := make(Row, len(ors.indexes))
+	for index, column := range ors.indexes {
+		row[index] = ors.outer.row[column]
+	}
+	return row, nil
+}
+
+func (ors *outerRowScan) Close() error { return nil }
+
+// applyOperator evaluates subquery for every input row and appends value of
+// expression to it: the only value produced by scalar subquery (NULL if it
+// produces no rows), whether EXISTS subquery produces rows or whether the left
+// operand of IN is one of values produced by subquery. Rows of uncorrelated
+// subquery are read only once
+type applyOperator struct {
+	input, subquery Operator
+	outer           *outerRow
+	expression      *parser.Expression
+	// left is the left operand of IN
+	left       evaluator
+	correlated bool
+
+	rows []Row
+	// candidates are values produced by subquery of IN
+	candidates *candidateSet
+	evaluated  bool
+}
+
+// candidateSet keeps distinct values produced by subquery of IN, so that
+// each input row is checked in constant time
+type candidateSet struct {
+	values      map[string]bool
+	empty, null bool
+}
+
+func newCandidateSet(rows []Row) *candidateSet {
+	result := &candidateSet{values: map[string]bool{}, empty: len(rows) == 0}
+	for _, row := range rows {
+		if row[0] == nil {
+			result.null = true
+			continue
+		}
+		result.values[encodeKey(row[:1])] = true
+	}
+	return result
+}
+
+// in checks if value is one of candidates. Like IN with a list of values, it
+// is NULL if value is not found and value or some candidates are NULL, not
+// negates other results. Nothing is in the empty set, even NULL
+func (cs *candidateSet) in(value interface{}, not bool) interface{} {
+	switch {
+	case cs.empty:
+		return not
+	case value == nil:
+		return nil
+	case cs.values[encodeKey([]interface{}{value})]:
+		return !not
+	case cs.null:
+		return nil
+	}
+	return not
+}
+
+func (ao *applyOperator) Open() error {
+	ao.rows, ao.candidates, ao.evaluated = nil, nil, false
+	return ao.input.Open()
+}
+
+func (ao *applyOperator) Next() (Row, error) {
+	row, err := ao.input.Next()
+	if err != nil || row == nil {
+		return nil, err
+	}
+	value, err := ao.evaluate(row)
+	if err != nil {
+		return nil, err
+	}
+	return append(append(make(Row, 0, len(row)+1), row...), value), nil
+}
+
+// evaluate computes value of expression for input row
+func (ao *applyOperator) evaluate(row Row) (interface{}, error) {
+	if ao.correlated || !ao.evaluated {
+		ao.outer.row = row
+		rows, err := collect(ao.subquery)
+		if err != nil {
+			return nil, err
+		}
+		ao.rows, ao.evaluated = rows, true
+		if ao.expression.Kind == parser.InExpression {
+			ao.rows, ao.candidates = nil, newCandidateSet(rows)
+		}
+	}
+	switch ao.expression.Kind {
+	case parser.ExistsExpression:
+		return len(ao.rows) != 0, nil
+	case parser.InExpression:
+		value, err := ao.left(row)
+		if err != nil {
+			return nil, err
+		}
+		return ao.candidates.in(value, ao.expression.Not), nil
+	}
+	switch len(ao.rows) {
+	case 0:
+		return nil, nil
+	case 1:
+		return ao.rows[0][0], nil
+	}
+	return nil, fmt.Errorf("scalar subquery %s produced more than one row", formatExpression(ao.expression))
+}
+
+func (ao *applyOperator) Close() error {
+	ao.rows, ao.candidates, ao.outer.row = nil, nil, nil
+	return ao.input.Close()
+}
@@ -0,0 +1,169 @@
+package planner
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/VorobevPavel-dev/congenial-disco/engine"
+	"github.com/VorobevPavel-dev/congenial-disco/parser"
+)
+
+// cancelInterval is a number of rows or batches produced by operator between
+// checks of context of query
+const cancelInterval = 64
+
+// cancellable stops operator with error of context of query when context is
+// done. Operators reading all rows of their inputs on Open are stopped by
+// their inputs
+type cancellable struct {
+	Operator
+	context context.Context
+	rows    int
+}
+
+func (c *cancellable) Open() error {
+	if err := c.context.Err(); err != nil {
+		return err
+	}
+	return c.Operator.Open()
+}
+
+func (c *cancellable) Next() (Row, error) {
+	if c.rows++; c.rows%cancelInterval == 0 {
+		if err := c.context.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return c.Operator.Next()
+}
+
+// cancellableBatches stops batch operator like cancellable does
+type cancellableBatches struct {
+	BatchOperator
+	context context.Context
+}
+
+func (cb *cancellableBatches) Open() error {
+	if err := cb.context.Err(); err != nil {
+		return err
+	}
+	return cb.BatchOperator.Open()
+}
+
+func (cb *cancellableBatches) NextBatch() (*Batch, error) {
+	if err := cb.context.Err(); err != nil {
+		return nil, err
+	}
+	return cb.BatchOperator.NextBatch()
+}
+
+// cancellable wraps operator to stop it when context of lowering is done.
+// Operators are not wrapped for contexts which are never done
+func (l *lowering) cancellable(operator Operator) Operator {
+	if l.context == nil || l.context.Done() == nil {
+		return operator
+	}
+	return &cancellable{Operator: operator, context: l.context}
+}
+
+func (l *lowering) cancellableBatches(operator BatchOperator) BatchOperator {
+	if l.context == nil || l.context.Done() == nil {
+		return operator
+	}
+	return &cancellableBatches{BatchOperator: operator, context: l.context}
+}
+
+// QueryID identifies running query among queries of all sessions
+type QueryID uint64
+
+// Queries keeps running queries of all sessions, so that a session can
+// cancel query of another one with CANCEL or KILL QUERY statement
+type Queries struct {
+	mutex   sync.Mutex
+	last    QueryID
+	running map[QueryID]context.CancelFunc
+}
+
+func NewQueries() *Queries {
+	return &Queries{running: map[QueryID]context.CancelFunc{}}
+}
+
+// Start registers a new query. Query should be executed with returned
+// context, which is done when query is canceled, and finish must be called
+// when query ends
+func (q *Queries) Start(parent context.Context) (id QueryID, ctx context.Context, finish func()) {
+	ctx, cancel := context.WithCancel(parent)
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	q.last++
+	id = q.last
+	q.running[id] = cancel
+	return id, ctx, func() {
+		q.mutex.Lock()
+		delete(q.running, id)
+		q.mutex.Unlock()
+		cancel()
+	}
+}
+
+// StartIn registers a new query running in transaction like Start does.
+// Context of query is also done after timeout unless it is zero. When query
+// is canceled or times out, finish rolls its transaction back, so that none
+// of its changes can be committed
+func (q *Queries) StartIn(parent context.Context, transaction engine.Transaction, timeout time.Duration) (id QueryID, ctx context.Context, finish func()) {
+	stop := func() {}
+	if timeout > 0 {
+		parent, stop = context.WithTimeout(parent, timeout)
+	}
+	id, ctx, finishQuery := q.Start(parent)
+	return id, ctx, func() {
+		// Transaction is rolled back by session, since executor may still
+		// use it while query is being canceled
+		interrupted := ctx.Err() != nil
+		finishQuery()
+		stop()
+		if interrupted {
+			transaction.Rollback()
+		}
+	}
+}
+
+// Cancel cancels running query. Operators of query return context.Canceled
+// error and transaction of query started with StartIn is rolled back when
+// query finishes
+func (q *Queries) Cancel(id QueryID) error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	cancel, ok := q.running[id]
+	if !ok {
+		return fmt.Errorf("query %d is not running", id)
+	}
+	delete(q.running, id)
+	cancel()
+	return nil
+}
+
+// Execute cancels query of CANCEL or KILL QUERY statement
+func (q *Queries) Execute(statement *parser.CancelStatement) error {
+	id, err := strconv.ParseUint(statement.Query.Value, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid query id %s", statement.Query.Value)
+	}
+	return q.Cancel(QueryID(id))
+}
+
+// Running returns ids of running queries in the order they were started
+func (q *Queries) Running() []QueryID {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	result := make([]QueryID, 0, len(q.running))
+	for id := range q.running {
+		result = append(result, id)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i] < result[j] })
+	return result
+}
@@ -0,0 +1,111 @@
+package planner
+
+import (
+	"fmt"
+
+	"github.com/VorobevPavel-dev/congenial-disco/parser"
+	"github.com/VorobevPavel-dev/congenial-disco/tokenizer"
+)
+
+// Column is a column of rows produced by plan node
+type Column struct {
+	// Table is empty for computed columns
+	Table string `json:"table,omitempty"`
+	Name  string `json:"name"`
+	Type  string `json:"type"`
+	// Expression is set for columns computed by Project and Aggregate nodes.
+	// Expressions of parent nodes equal to it are read from the column
+	// instead of being evaluated again.
+	Expression *parser.Expression `json:"-"`
+	// Outer is set for columns of the enclosing query read by correlated
+	// subquery, they are referenced by qualified columns only
+	Outer bool `json:"-"`
+}
+
+func (c Column) String() string {
+	if c.Table == "" {
+		return c.Name
+	}
+	return c.Table + "." + c.Name
+}
+
+// resolveColumn returns index of column referenced by column expression.
+// Unqualified column must match exactly one of columns which are not outer.
+func resolveColumn(columns []Column, column *parser.Expression) (int, error) {
+	result := -1
+	for index, candidate := range columns {
+		if candidate.Name != column.Token.Value || candidate.Outer && column.Table == nil {
+			continue
+		}
+		if column.Table != nil && candidate.Table != column.Table.Value {
+			continue
+		}
+		if result != -1 {
+			return -1, fmt.Errorf("column %s is ambiguous", formatExpression(column))
+		}
+		result = index
+	}
+	if result == -1 {
+		return -1, fmt.Errorf("column %s does not exist", formatExpression(column))
+	}
+	return result, nil
+}
+
+// computedColumn returns index of column computed from expression equal to
+// given one
+func computedColumn(columns []Column, expression *parser.Expression) int {
+	for index, column := range columns {
+		if column.Expression != nil && column.Expression.Equals(expression) {
+			return index
+		}
+	}
+	return -1
+}
+
+// containsColumn checks if column is listed in columns
+func containsColumn(columns []Column, column Column) bool {
+	for _, candidate := range columns {
+		if candidate == column {
+			return true
+		}
+	}
+	return false
+}
+
+// subqueries returns scalar subqueries, EXISTS and IN (SELECT ...)
+// expressions used in expressions (not including the ones of subqueries).
+// Nested expressions are returned before expressions using them
+func subqueries(expressions ...*parser.Expression) []*parser.Expression {
+	var result []*parser.Expression
+	for _, expression := range expressions {
+		if expression == nil {
+			continue
+		}
+		result = append(result, subqueries(expression.Children()...)...)
+		if expression.Subquery != nil {
+			result = append(result, expression)
+		}
+	}
+	return result
+}
+
+// columnReferences returns all columns referenced by expressions (not
+// including the ones of subqueries)
+func columnReferences(expressions ...*parser.Expression) []*parser.Expression {
+	var result []*parser.Expression
+	for _, expression := range expressions {
+		if expression == nil {
+			continue
+		}
+		if expression.Kind == parser.ColumnExpression && !isAsterisk(expression) {
+			result = append(result, expression)
+		}
+		result = append(result, columnReferences(expression.Children()...)...)
+	}
+	return result
+}
+
+// isAsterisk checks if expression is "*" argument of count(*)
+func isAsterisk(expression *parser.Expression) bool {
+	return expression.Kind == parser.ColumnExpression && expression.Token.Kind == tokenizer.SymbolKind
+}
@@ -0,0 +1,256 @@
+package planner
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/VorobevPavel-dev/congenial-disco/columnar"
+	"github.com/VorobevPavel-dev/congenial-disco/parser"
+	"github.com/VorobevPavel-dev/congenial-disco/tokenizer"
+)
+
+// ColumnSource is a Source of tables stored by columns. Every table is kept
+// in subdirectory of Directory named after table
+type ColumnSource struct {
+	Directory string
+
+	mutex  sync.Mutex
+	tables map[string]*columnar.Table
+}
+
+func NewColumnSource(directory string) *ColumnSource {
+	return &ColumnSource{Directory: directory, tables: map[string]*columnar.Table{}}
+}
+
+// CreateTable creates empty table of CREATE TABLE statement with column
+// storage
+func (cs *ColumnSource) CreateTable(statement *parser.CreateTableStatement) error {
+	if statement.Storage != parser.ColumnStorage {
+		return fmt.Errorf("table %s is not created with column storage", statement.Name.Value)
+	}
+	var columns []columnar.Column
+	for _, column := range statement.Cols {
+		columns = append(columns, columnar.Column{Name: column.Name.Value, Type: column.Datatype.Value})
+	}
+	cs.mutex.Lock()
+	defer cs.mutex.Unlock()
+	table, err := columnar.Create(filepath.Join(cs.Directory, statement.Name.Value), columns)
+	if err != nil {
+		return err
+	}
+	cs.tables[statement.Name.Value] = table
+	return nil
+}
+
+// table returns table opening it on the first use
+func (cs *ColumnSource) table(name string) (*columnar.Table, error) {
+	cs.mutex.Lock()
+	defer cs.mutex.Unlock()
+	if table, ok := cs.tables[name]; ok {
+		return table, nil
+	}
+	table, err := columnar.Open(filepath.Join(cs.Directory, name))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("table %s does not exist", name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	cs.tables[name] = table
+	return table, nil
+}
+
+// Insert appends rows with values of all columns of table
+func (cs *ColumnSource) Insert(table string, rows []Row) error {
+	return cs.InsertContext(context.Background(), table, rows)
+}
+
+// InsertContext is Insert which is aborted once context is done. None of rows
+// of aborted insert are added to table
+func (cs *ColumnSource) InsertContext(ctx context.Context, table string, rows []Row) error {
+	data, err := cs.table(table)
+	if err != nil {
+		return err
+	}
+	values := make([][]interface{}, len(rows))
+	for index, row := range rows {
+		values[index] = row
+	}
+	return data.AppendContext(ctx, values)
+}
+
+func (cs *ColumnSource) Scan(table string, columns []string) (Iterator, error) {
+	return cs.scan(table, columns, nil)
+}
+
+// ScanPartition reads chunks of table which indexes give partition as
+// remainder of division by number of partitions
+func (cs *ColumnSource) ScanPartition(table string, columns []string, partition, partitions int) (Iterator, error) {
+	data, err := cs.table(table)
+	if err != nil {
+		return nil, err
+	}
+	scanner, err := data.ScanPartition(columns, nil, partition, partitions)
+	if err != nil {
+		return nil, err
+	}
+	return &columnIterator{scanner: scanner}, nil
+}
+
+func (cs *ColumnSource) ScanBatches(table string, columns []string, predicates []columnar.Predicate) (BatchIterator, error) {
+	return cs.scan(table, columns, predicates)
+}
+
+func (cs *ColumnSource) scan(table string, columns []string, predicates []columnar.Predicate) (*columnIterator, error) {
+	data, err := cs.table(table)
+	if err != nil {
+		return nil, err
+	}
+	scanner, err := data.Scan(columns, predicates)
+	if err != nil {
+		return nil, err
+	}
+	return &columnIterator{scanner: scanner}, nil
+}
+
+func (cs *ColumnSource) RowCount(table string) (int, bool) {
+	data, err := cs.table(table)
+	if err != nil {
+		return 0, false
+	}
+	return data.RowCount(), true
+}
+
+// columnIterator returns rows of decoded chunks one by one or by batches.
+// Batches share values with chunks instead of copying them
+type columnIterator struct {
+	scanner *columnar.Scanner
+	chunk   *columnar.ChunkData
+	next    int
+}
+
+// advance reads the next chunk if all rows of current one were returned, it
+// returns false after the last chunk
+func (ci *columnIterator) advance() (bool, error) {
+	for ci.chunk == nil || ci.next >= ci.chunk.Rows {
+		chunk, err := ci.scanner.Next()
+		if err != nil || chunk == nil {
+			return false, err
+		}
+		ci.chunk, ci.next = chunk, 0
+	}
+	return true, nil
+}
+
+func (ci *columnIterator) Next() (Row, error) {
+	if ok, err := ci.advance(); !ok {
+		return nil, err
+	}
+	row := make(Row, len(ci.chunk.Columns))
+	for index, column := range ci.chunk.Columns {
+		row[index] = column.Value(ci.next)
+	}
+	ci.next++
+	return row, nil
+}
+
+func (ci *columnIterator) NextBatch() (*Batch, error) {
+	if ok, err := ci.advance(); !ok {
+		return nil, err
+	}
+	// Chunks are split at multiples of BatchSize, so NULL bitmaps of batches
+	// start at word boundaries
+	start, end := ci.next, ci.next+BatchSize
+	if end > ci.chunk.Rows {
+		end = ci.chunk.Rows
+	}
+	ci.next = end
+	batch := &Batch{Length: end - start}
+	for _, column := range ci.chunk.Columns {
+		vector := &Vector{
+			Type:   column.Type,
+			Nulls:  Bitmap(column.Nulls[start/64 : (end+63)/64]),
+			length: end - start,
+		}
+		if column.Type == tokenizer.IntType {
+			vector.Ints = column.Ints[start:end]
+		} else {
+			vector.Texts = column.Texts[start:end]
+		}
+		batch.Vectors = append(batch.Vectors, vector)
+	}
+	return batch, nil
+}
+
+func (ci *columnIterator) Close() error { return ci.scanner.Close() }
+
+// zonePredicates returns comparisons of columns of scan with literals among
+// conjuncts of condition, which let source skip parts of table. Prefix LIKE
+// conditions are turned into ranges of values they match
+func zonePredicates(condition *parser.Expression, scan *Scan) []columnar.Predicate {
+	var comparisons []*parser.Expression
+	for _, conjunct := range conjuncts(condition) {
+		if conjunct.Kind == parser.LikeExpression {
+			if rewritten, err := parser.RewritePrefixLike(conjunct); err == nil {
+				comparisons = append(comparisons, conjuncts(rewritten)...)
+				continue
+			}
+		}
+		comparisons = append(comparisons, conjunct)
+	}
+	var result []columnar.Predicate
+	for _, conjunct := range comparisons {
+		if conjunct.Kind != parser.BinaryExpression {
+			continue
+		}
+		if _, ok := comparisonOperators[conjunct.Token.Value]; !ok {
+			continue
+		}
+		column, other, operator := conjunct.Left, conjunct.Right, conjunct.Token.Value
+		if column.Kind != parser.ColumnExpression {
+			column, other = other, column
+			if flipped, ok := flippedOperators[operator]; ok {
+				operator = flipped
+			}
+		}
+		if column.Kind != parser.ColumnExpression || isAsterisk(column) {
+			continue
+		}
+		value, ok := literalOf(other)
+		if !ok {
+			continue
+		}
+		index, err := resolveColumn(scan.Output, column)
+		if err != nil {
+			continue
+		}
+		result = append(result, columnar.Predicate{Column: scan.Output[index].Name, Operator: operator, Value: value})
+	}
+	return result
+}
+
+// batchScan reads table from BatchSource by batches
+type batchScan struct {
+	source     BatchSource
+	table      string
+	columns    []string
+	predicates []columnar.Predicate
+	iterator   BatchIterator
+}
+
+func (bs *batchScan) Open() (err error) {
+	bs.iterator, err = bs.source.ScanBatches(bs.table, bs.columns, bs.predicates)
+	return err
+}
+
+func (bs *batchScan) NextBatch() (*Batch, error) { return bs.iterator.NextBatch() }
+
+func (bs *batchScan) Close() error {
+	if bs.iterator == nil {
+		return nil
+	}
+	return bs.iterator.Close()
+}
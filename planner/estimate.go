@@ -0,0 +1,451 @@
+package planner
+
+import (
+	"math"
+	"math/bits"
+
+	"github.com/VorobevPavel-dev/congenial-disco/parser"
+	"github.com/VorobevPavel-dev/congenial-disco/statistics"
+	"github.com/VorobevPavel-dev/congenial-disco/tokenizer"
+)
+
+// Estimates used when nothing is known about tables and values of columns
+const (
+	defaultTableRows = 1000
+	// equalitySelectivity is a share of rows for which x = y is true
+	equalitySelectivity = 0.1
+	// rangeSelectivity is a share of rows for which x < y is true
+	rangeSelectivity = 1.0 / 3
+	// defaultSelectivity is used for conditions like LIKE, which can be
+	// neither selective nor unselective
+	defaultSelectivity = 0.25
+	// groupsPerRow is an estimated number of distinct groups per input row
+	groupsPerRow = 0.1
+	// recursiveIterations is an estimated number of iterations of recursive
+	// union, workingTableRows is a number of rows produced by each of them
+	recursiveIterations = 10
+	workingTableRows    = 10
+)
+
+// Costs are measured in units of reading one row of a table
+const (
+	// rowCost is a cost of reading a row of a table
+	rowCost = 1.0
+	// operatorCost is a cost of evaluating expressions for a row
+	operatorCost = 0.25
+	// maxJoinRelations limits number of relations joins of which are
+	// reordered, since number of considered orders grows exponentially
+	maxJoinRelations = 12
+)
+
+// RowCounter is implemented by sources which know number of rows of tables
+type RowCounter interface {
+	RowCount(table string) (int, bool)
+}
+
+// Estimator estimates number of rows produced by plan nodes and costs of
+// running them. Statistics of analyzed tables are used if they are known,
+// otherwise number of rows is taken from counter and selectivity of
+// conditions is guessed. Nil estimator guesses everything.
+type Estimator struct {
+	Statistics *statistics.Catalog
+	Counter    RowCounter
+}
+
+func NewEstimator(catalog *statistics.Catalog, counter RowCounter) *Estimator {
+	return &Estimator{Statistics: catalog, Counter: counter}
+}
+
+// tableRows returns number of rows of table
+func (e *Estimator) tableRows(table string) float64 {
+	if e == nil {
+		return defaultTableRows
+	}
+	if e.Statistics != nil {
+		if table, ok := e.Statistics.Get(table); ok {
+			return float64(table.RowCount)
+		}
+	}
+	if e.Counter != nil {
+		if rows, ok := e.Counter.RowCount(table); ok {
+			return float64(rows)
+		}
+	}
+	return defaultTableRows
+}
+
+// columnStatistics returns statistics of column referenced by expression
+func (e *Estimator) columnStatistics(expression *parser.Expression, columns []Column) (*statistics.ColumnStatistics, bool) {
+	if e == nil || e.Statistics == nil || expression.Kind != parser.ColumnExpression || isAsterisk(expression) {
+		return nil, false
+	}
+	index, err := resolveColumn(columns, expression)
+	if err != nil || columns[index].Table == "" {
+		return nil, false
+	}
+	return e.Statistics.Column(columns[index].Table, columns[index].Name)
+}
+
+// Rows estimates number of rows produced by node
+func (e *Estimator) Rows(node Node) float64 {
+	switch typed := node.(type) {
+	case *Scan:
+		return e.tableRows(typed.Table)
+	case *Filter:
+		return e.Rows(typed.Input) * e.Selectivity(typed.Condition, typed.Input.Columns())
+	case *Project, *Sort, *Window, *Apply:
+		return e.Rows(node.Children()[0])
+	case *Join:
+		return e.joinRows(typed.Kind, e.Rows(typed.Left), e.Rows(typed.Right),
+			e.Selectivity(typed.Condition, append(append([]Column{}, typed.Left.Columns()...), typed.Right.Columns()...)))
+	case *Aggregate:
+		return e.groups(typed)
+	case *Limit:
+		rows := math.Max(0, e.Rows(typed.Input)-float64(typed.Offset))
+		if typed.Count >= 0 {
+			rows = math.Min(rows, float64(typed.Count))
+		}
+		return rows
+	case *SetOperation:
+		return e.setOperationRows(typed)
+	case *RecursiveUnion:
+		return e.Rows(typed.Initial) + e.Rows(typed.Recursive)*recursiveIterations
+	case *WorkingTable:
+		return workingTableRows
+	case *OuterRow:
+		return 1
+	}
+	return defaultTableRows
+}
+
+func (e *Estimator) joinRows(kind JoinKind, left, right, selectivity float64) float64 {
+	switch kind {
+	case SemiJoin:
+		// Every row of the left input matches right rows with probability
+		// of condition
+		return left * math.Min(1, right*selectivity)
+	case AntiJoin:
+		return left * (1 - math.Min(1, right*selectivity))
+	}
+	return left * right * selectivity
+}
+
+// setOperationRows estimates rows of set operation assuming that inputs
+// have no duplicates and rows of the smaller input are found in the larger
+// one if it is possible
+func (e *Estimator) setOperationRows(operation *SetOperation) float64 {
+	left, right := e.Rows(operation.Left), e.Rows(operation.Right)
+	switch {
+	case operation.All:
+		return left + right
+	case operation.Operator == tokenizer.IntersectKeyword:
+		return math.Min(left, right)
+	case operation.Operator == tokenizer.ExceptKeyword:
+		return math.Max(0, left-right)
+	}
+	return math.Max(left, right)
+}
+
+// groups estimates number of groups of aggregate as a product of numbers of
+// distinct values of grouping columns
+func (e *Estimator) groups(aggregate *Aggregate) float64 {
+	if len(aggregate.GroupBy) == 0 {
+		return 1
+	}
+	input := e.Rows(aggregate.Input)
+	groups := 1.0
+	for _, expression := range aggregate.GroupBy {
+		column, ok := e.columnStatistics(expression, aggregate.Input.Columns())
+		if !ok {
+			return math.Max(1, input*groupsPerRow)
+		}
+		distinct := column.DistinctCount
+		if column.NullFraction > 0 {
+			distinct++
+		}
+		groups *= math.Max(1, distinct)
+	}
+	return math.Max(1, math.Min(input, groups))
+}
+
+// Cost estimates cost of running node including its inputs
+func (e *Estimator) Cost(node Node) float64 {
+	switch typed := node.(type) {
+	case *Scan:
+		return e.Rows(typed) * rowCost
+	case *Filter, *Project, *Aggregate:
+		input := node.Children()[0]
+		return e.Cost(input) + e.Rows(input)*operatorCost
+	case *Join:
+		return e.joinCost(e.Cost(typed.Left), e.Cost(typed.Right), e.Rows(typed.Left), e.Rows(typed.Right))
+	case *Sort:
+		rows := e.Rows(typed.Input)
+		return e.Cost(typed.Input) + rows*math.Log2(math.Max(2, rows))*operatorCost
+	case *Window:
+		// Rows are sorted once for all functions of window
+		rows := e.Rows(typed.Input)
+		return e.Cost(typed.Input) + rows*(math.Log2(math.Max(2, rows))+float64(len(typed.Functions)))*operatorCost
+	case *Limit:
+		return e.Cost(typed.Input)
+	case *SetOperation:
+		return e.Cost(typed.Left) + e.Cost(typed.Right) + (e.Rows(typed.Left)+e.Rows(typed.Right))*operatorCost
+	case *RecursiveUnion:
+		return e.Cost(typed.Initial) + e.Cost(typed.Recursive)*recursiveIterations + e.Rows(typed)*operatorCost
+	case *WorkingTable:
+		return workingTableRows * rowCost
+	case *Apply:
+		// Correlated subquery is evaluated for every input row
+		evaluations := 1.0
+		if typed.Correlated() {
+			evaluations = e.Rows(typed.Input)
+		}
+		return e.Cost(typed.Input) + e.Cost(typed.Subquery)*evaluations + e.Rows(typed.Input)*operatorCost
+	case *OuterRow:
+		return rowCost
+	}
+	return 0
+}
+
+// joinCost is a cost of nested loop join which keeps rows of the right input
+// in memory and evaluates condition for every pair of rows
+func (e *Estimator) joinCost(leftCost, rightCost, leftRows, rightRows float64) float64 {
+	return leftCost + rightCost + rightRows*operatorCost + leftRows*rightRows*operatorCost
+}
+
+// Selectivity estimates share of rows with given columns for which condition
+// is true
+func (e *Estimator) Selectivity(condition *parser.Expression, columns []Column) float64 {
+	if condition == nil {
+		return 1
+	}
+	switch condition.Kind {
+	case parser.BinaryExpression:
+		switch operator := condition.Token.Value; operator {
+		case tokenizer.AndKeyword:
+			return e.Selectivity(condition.Left, columns) * e.Selectivity(condition.Right, columns)
+		case tokenizer.OrKeyword:
+			left, right := e.Selectivity(condition.Left, columns), e.Selectivity(condition.Right, columns)
+			return left + right - left*right
+		default:
+			if isTrue(condition) {
+				return 1
+			}
+			return e.comparisonSelectivity(operator, condition.Left, condition.Right, columns)
+		}
+	case parser.UnaryExpression:
+		return 1 - e.Selectivity(condition.Left, columns)
+	case parser.InExpression:
+		result := defaultSelectivity
+		if condition.Subquery == nil {
+			result = 0
+			for _, argument := range condition.Arguments {
+				result += e.comparisonSelectivity("=", condition.Left, argument, columns)
+			}
+			result = math.Min(1, result)
+		}
+		if condition.Not {
+			return 1 - result
+		}
+		return result
+	case parser.BetweenExpression:
+		result := rangeSelectivity * rangeSelectivity
+		column, ok := e.columnStatistics(condition.Left, columns)
+		low, lowOk := literalOf(condition.Arguments[0])
+		high, highOk := literalOf(condition.Arguments[1])
+		if ok && lowOk && highOk {
+			below, belowOk := column.LessFraction(low, false)
+			upTo, upToOk := column.LessFraction(high, true)
+			if belowOk && upToOk {
+				result = math.Max(0, upTo-below)
+			}
+		}
+		if condition.Not {
+			return 1 - result
+		}
+		return result
+	}
+	return defaultSelectivity
+}
+
+// comparisonSelectivity estimates selectivity of left operator right
+func (e *Estimator) comparisonSelectivity(operator string, left, right *parser.Expression, columns []Column) float64 {
+	// Comparison with literal on the left is turned around
+	if _, ok := literalOf(left); ok {
+		left, right = right, left
+		if flipped, ok := flippedOperators[operator]; ok {
+			operator = flipped
+		}
+	}
+	column, leftOk := e.columnStatistics(left, columns)
+	other, rightOk := e.columnStatistics(right, columns)
+	value, literal := literalOf(right)
+	switch operator {
+	case "=", "<>", "!=":
+		result := equalitySelectivity
+		switch {
+		case leftOk && literal:
+			result = column.EqualFraction(value)
+		case leftOk && rightOk:
+			result = (1 - column.NullFraction) * (1 - other.NullFraction) /
+				math.Max(1, math.Max(column.DistinctCount, other.DistinctCount))
+		case leftOk:
+			result = (1 - column.NullFraction) / math.Max(1, column.DistinctCount)
+		case rightOk:
+			result = (1 - other.NullFraction) / math.Max(1, other.DistinctCount)
+		}
+		if operator != "=" {
+			nulls := 0.0
+			if leftOk {
+				nulls = column.NullFraction
+			}
+			return math.Max(0, 1-result-nulls)
+		}
+		return result
+	case "<", "<=", ">", ">=":
+		if !leftOk || !literal {
+			return rangeSelectivity
+		}
+		inclusive := operator == "<=" || operator == ">"
+		less, ok := column.LessFraction(value, inclusive)
+		if !ok {
+			return rangeSelectivity
+		}
+		if operator == "<" || operator == "<=" {
+			return less
+		}
+		return math.Max(0, 1-column.NullFraction-less)
+	}
+	return defaultSelectivity
+}
+
+// flippedOperators are operators giving the same result when operands are
+// swapped
+var flippedOperators = map[string]string{"<": ">", "<=": ">=", ">": "<", ">=": "<="}
+
+// literalOf returns value of literal expression
+func literalOf(expression *parser.Expression) (interface{}, bool) {
+	if expression == nil || expression.Kind != parser.LiteralExpression {
+		return nil, false
+	}
+	value, err := literalValue(expression.Token)
+	return value, err == nil
+}
+
+// ReorderJoins chooses order of inner joins with the least estimated cost.
+// Orders are enumerated with dynamic programming: the cheapest join of every
+// subset of relations is built from the cheapest joins of its two parts.
+// Cross joins are considered only for subsets which can not be joined by
+// conditions.
+func (e *Estimator) ReorderJoins(node Node) (Node, error) {
+	join, ok := node.(*Join)
+	if !ok || join.Kind != InnerJoin {
+		return replaceChildrenWith(node, e.ReorderJoins)
+	}
+	relations, conditions := flattenJoins(join)
+	if len(relations) > maxJoinRelations {
+		return replaceChildrenWith(node, e.ReorderJoins)
+	}
+	for index, relation := range relations {
+		var err error
+		if relations[index], err = e.ReorderJoins(relation); err != nil {
+			return nil, err
+		}
+	}
+
+	// Every condition is applied by the join of the smallest subset of
+	// relations it refers to. Conditions of a single relation (or of none)
+	// filter that relation
+	var (
+		joinConditions []*parser.Expression
+		masks          []uint
+	)
+	for _, condition := range conditions {
+		mask := relationMask(condition, relations)
+		if bits.OnesCount(mask) > 1 {
+			joinConditions = append(joinConditions, condition)
+			masks = append(masks, mask)
+			continue
+		}
+		index := 0
+		if mask != 0 {
+			index = bits.TrailingZeros(mask)
+		}
+		relations[index] = &Filter{Input: relations[index], Condition: condition}
+	}
+
+	type plan struct {
+		node       Node
+		rows, cost float64
+	}
+	full := uint(1)<<len(relations) - 1
+	best := make([]*plan, full+1)
+	for index, relation := range relations {
+		best[1<<index] = &plan{node: relation, rows: e.Rows(relation), cost: e.Cost(relation)}
+	}
+	for subset := uint(1); subset <= full; subset++ {
+		if bits.OnesCount(subset) < 2 {
+			continue
+		}
+		for _, allowCross := range []bool{false, true} {
+			for left := (subset - 1) & subset; left != 0; left = (left - 1) & subset {
+				right := subset ^ left
+				var applied []*parser.Expression
+				for index, mask := range masks {
+					if mask&subset == mask && mask&left != mask && mask&right != mask {
+						applied = append(applied, joinConditions[index])
+					}
+				}
+				if applied == nil && !allowCross {
+					continue
+				}
+				candidate := &Join{Kind: InnerJoin, Left: best[left].node, Right: best[right].node}
+				selectivity := 1.0
+				if applied != nil {
+					candidate.Condition = conjunction(applied)
+					selectivity = e.Selectivity(candidate.Condition, candidate.Columns())
+				}
+				cost := e.joinCost(best[left].cost, best[right].cost, best[left].rows, best[right].rows)
+				if best[subset] == nil || cost < best[subset].cost {
+					best[subset] = &plan{
+						node: candidate,
+						rows: e.joinRows(InnerJoin, best[left].rows, best[right].rows, selectivity),
+						cost: cost,
+					}
+				}
+			}
+			if best[subset] != nil {
+				break
+			}
+		}
+	}
+	return best[full].node, nil
+}
+
+// flattenJoins returns relations joined by tree of inner joins and
+// conjuncts of conditions of the joins
+func flattenJoins(node Node) ([]Node, []*parser.Expression) {
+	join, ok := node.(*Join)
+	if !ok || join.Kind != InnerJoin {
+		return []Node{node}, nil
+	}
+	leftRelations, leftConditions := flattenJoins(join.Left)
+	rightRelations, rightConditions := flattenJoins(join.Right)
+	conditions := append(leftConditions, rightConditions...)
+	if join.Condition != nil {
+		conditions = append(conditions, conjuncts(join.Condition)...)
+	}
+	return append(leftRelations, rightRelations...), conditions
+}
+
+// relationMask returns set of relations condition refers to as a bit mask
+func relationMask(condition *parser.Expression, relations []Node) uint {
+	var mask uint
+	for _, reference := range columnReferences(condition) {
+		for index, relation := range relations {
+			if _, err := resolveColumn(relation.Columns(), reference); err == nil {
+				mask |= 1 << index
+			}
+		}
+	}
+	return mask
+}
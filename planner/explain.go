@@ -0,0 +1,234 @@
+package planner
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/VorobevPavel-dev/congenial-disco/parser"
+	"github.com/VorobevPavel-dev/congenial-disco/statistics"
+)
+
+// OperatorStats are collected by operators of plans run by EXPLAIN ANALYZE
+type OperatorStats struct {
+	// Rows is a number of rows produced in all loops
+	Rows int `json:"rows"`
+	// Loops is a number of times operator was opened
+	Loops int `json:"loops"`
+	// Time is spent in operator and its inputs, it is marshalled to JSON in
+	// nanoseconds
+	Time time.Duration `json:"time"`
+}
+
+// instrumented collects statistics of operator
+type instrumented struct {
+	Operator
+	stats OperatorStats
+}
+
+func (i *instrumented) Open() error {
+	start := time.Now()
+	err := i.Operator.Open()
+	i.stats.Loops++
+	i.stats.Time += time.Since(start)
+	return err
+}
+
+func (i *instrumented) Next() (Row, error) {
+	start := time.Now()
+	row, err := i.Operator.Next()
+	if row != nil {
+		i.stats.Rows++
+	}
+	i.stats.Time += time.Since(start)
+	return row, err
+}
+
+func (i *instrumented) Close() error {
+	start := time.Now()
+	err := i.Operator.Close()
+	i.stats.Time += time.Since(start)
+	return err
+}
+
+// Explanation describes operator of physical plan
+type Explanation struct {
+	Operator      string         `json:"operator"`
+	Details       string         `json:"details,omitempty"`
+	EstimatedCost float64        `json:"estimatedCost"`
+	EstimatedRows int            `json:"estimatedRows"`
+	Actual        *OperatorStats `json:"actual,omitempty"`
+	Children      []*Explanation `json:"children,omitempty"`
+}
+
+func (e *Explanation) String() string {
+	bytes, _ := json.Marshal(e)
+	return string(bytes)
+}
+
+// Text renders explanation as a tree the same way Format renders logical
+// plans
+func (e *Explanation) Text() string {
+	var builder strings.Builder
+	e.text(&builder, 0)
+	return builder.String()
+}
+
+func (e *Explanation) text(builder *strings.Builder, depth int) {
+	builder.WriteString(strings.Repeat("  ", depth))
+	if depth > 0 {
+		builder.WriteString("-> ")
+	}
+	builder.WriteString(e.Operator)
+	if e.Details != "" {
+		builder.WriteString(" " + e.Details)
+	}
+	fmt.Fprintf(builder, " (cost=%.2f rows=%d)", e.EstimatedCost, e.EstimatedRows)
+	if e.Actual != nil {
+		fmt.Fprintf(builder, " (actual rows=%d loops=%d time=%.3fms)",
+			e.Actual.Rows, e.Actual.Loops, float64(e.Actual.Time)/float64(time.Millisecond))
+	}
+	builder.WriteString("\n")
+	for _, child := range e.Children {
+		child.text(builder, depth+1)
+	}
+}
+
+// Explain describes operators plan is lowered to with their costs and
+// numbers of rows estimated by estimator. With analyze plan is run and
+// statistics of every operator are added to explanation
+func Explain(node Node, source Source, estimator *Estimator, analyze bool) (*Explanation, error) {
+	l := &lowering{source: source, instrument: analyze, operators: map[Node]Operator{}}
+	operator, err := l.lower(node)
+	if err != nil {
+		return nil, err
+	}
+	if analyze {
+		if _, err := collect(operator); err != nil {
+			return nil, err
+		}
+	}
+	return explain(node, l.operators, estimator), nil
+}
+
+func explain(node Node, operators map[Node]Operator, estimator *Estimator) *Explanation {
+	operator := operators[node]
+	result := &Explanation{
+		// Like in other databases estimates are at least one row, so that
+		// they do not look like a guarantee of empty result
+		EstimatedRows: int(math.Max(1, math.Round(estimator.Rows(node)))),
+		EstimatedCost: estimator.Cost(node),
+		Details:       details(node),
+	}
+	if wrapper, ok := operator.(*instrumented); ok {
+		stats := wrapper.stats
+		result.Actual = &stats
+		operator = wrapper.Operator
+	}
+	result.Operator = operatorName(operator)
+	for _, child := range node.Children() {
+		result.Children = append(result.Children, explain(child, operators, estimator))
+	}
+	return result
+}
+
+// operatorName returns name of operator type
+func operatorName(operator Operator) string {
+	switch typed := operator.(type) {
+	case *scanOperator:
+		return "Seq Scan"
+	case *filterOperator:
+		return "Filter"
+	case *projectOperator:
+		return "Project"
+	case *nestedLoopJoin:
+		return "Nested Loop " + joinName(typed.kind)
+	case *hashJoin:
+		return "Hash " + joinName(typed.kind)
+	case *hashAggregate:
+		return "Hash Aggregate"
+	case *parallelAggregate:
+		return "Parallel Hash Aggregate"
+	case *gather:
+		return "Gather"
+	case *windowOperator:
+		return "Window"
+	case *sortOperator:
+		return "Sort"
+	case *limitOperator:
+		return "Limit"
+	case *appendOperator:
+		return "Append"
+	case *recursiveUnion:
+		return "Recursive Union"
+	case *workingTableScan:
+		return "Working Table Scan"
+	case *hashSetOperation:
+		return "Hash " + strings.ToUpper(typed.operator[:1]) + typed.operator[1:]
+	case *applyOperator:
+		return "Apply"
+	case *outerRowScan:
+		return "Outer Row Scan"
+	}
+	return fmt.Sprintf("%T", operator)
+}
+
+// joinName names join of given kind
+func joinName(kind JoinKind) string {
+	switch kind {
+	case SemiJoin:
+		return "Semi Join"
+	case AntiJoin:
+		return "Anti Join"
+	}
+	return "Join"
+}
+
+// details describes node without its kind, which is a part of operator name
+func details(node Node) string {
+	switch typed := node.(type) {
+	case *Join:
+		if typed.Condition == nil {
+			return ""
+		}
+		return "on " + formatExpression(typed.Condition)
+	case *SetOperation:
+		return ""
+	case *RecursiveUnion:
+		return typed.Name
+	case *WorkingTable:
+		return fmt.Sprintf("%s [%s]", typed.Name, formatColumns(typed.Output))
+	case *OuterRow:
+		return fmt.Sprintf("$subquery%d [%s]", typed.Number, formatColumns(typed.Output))
+	}
+	description := node.String()
+	if index := strings.Index(description, " "); index != -1 {
+		return description[index+1:]
+	}
+	return ""
+}
+
+// ExplainStatement plans statement of EXPLAIN using statistics of catalog
+// and renders its explanation in requested format
+func ExplainStatement(statement *parser.ExplainStatement, schema parser.Schema, source Source,
+	catalog *statistics.Catalog) (string, error) {
+	node, err := Plan(statement.Statement, schema)
+	if err != nil {
+		return "", err
+	}
+	counter, _ := source.(RowCounter)
+	estimator := NewEstimator(catalog, counter)
+	if node, err = Optimize(node, estimator); err != nil {
+		return "", err
+	}
+	explanation, err := Explain(node, source, estimator, statement.Analyze)
+	if err != nil {
+		return "", err
+	}
+	if statement.Format == parser.ExplainJSONFormat {
+		return explanation.String(), nil
+	}
+	return explanation.Text(), nil
+}
@@ -0,0 +1,396 @@
+package planner
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/VorobevPavel-dev/congenial-disco/engine"
+	"github.com/VorobevPavel-dev/congenial-disco/function"
+	"github.com/VorobevPavel-dev/congenial-disco/parser"
+	"github.com/VorobevPavel-dev/congenial-disco/tokenizer"
+)
+
+// Row is a tuple of values. Values are int, string, bool (results of
+// conditions) or nil for NULL. Rows are shared with storage engines
+type Row = engine.Row
+
+// evaluator computes value of expression for a row
+type evaluator func(row Row) (interface{}, error)
+
+// compile resolves columns of expression against columns of input rows and
+// returns function evaluating expression for a row. Conditions are evaluated
+// with three-valued logic: comparisons with NULL are NULL.
+func compile(expression *parser.Expression, columns []Column) (evaluator, error) {
+	if index := computedColumn(columns, expression); index != -1 {
+		return columnEvaluator(index), nil
+	}
+	switch expression.Kind {
+	case parser.LiteralExpression:
+		value, err := literalValue(expression.Token)
+		if err != nil {
+			return nil, err
+		}
+		return func(Row) (interface{}, error) { return value, nil }, nil
+	case parser.ColumnExpression:
+		if isAsterisk(expression) {
+			return nil, fmt.Errorf("\"*\" is allowed only as argument of count")
+		}
+		index, err := resolveColumn(columns, expression)
+		if err != nil {
+			return nil, err
+		}
+		return columnEvaluator(index), nil
+	case parser.ParameterExpression:
+		return nil, fmt.Errorf("parameter %s is not bound", expression.Token.Value)
+	case parser.BinaryExpression:
+		return compileBinary(expression, columns)
+	case parser.UnaryExpression:
+		operand, err := compile(expression.Left, columns)
+		if err != nil {
+			return nil, err
+		}
+		return func(row Row) (interface{}, error) {
+			value, err := operand(row)
+			if err != nil || value == nil {
+				return nil, err
+			}
+			return value != true, nil
+		}, nil
+	case parser.InExpression:
+		return compileIn(expression, columns)
+	case parser.LikeExpression:
+		return compileLike(expression, columns)
+	case parser.BetweenExpression:
+		return compileBetween(expression, columns)
+	case parser.CaseExpression:
+		return compileCase(expression, columns)
+	case parser.FunctionExpression:
+		return compileFunction(expression, columns)
+	case parser.SubqueryExpression, parser.ExistsExpression:
+		return nil, fmt.Errorf("subquery %s is not supported here", formatExpression(expression))
+	}
+	return nil, fmt.Errorf("cannot evaluate %s", formatExpression(expression))
+}
+
+func compileAll(expressions []*parser.Expression, columns []Column) ([]evaluator, error) {
+	result := make([]evaluator, len(expressions))
+	for index, expression := range expressions {
+		var err error
+		if result[index], err = compile(expression, columns); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+func evaluateAll(evaluators []evaluator, row Row) ([]interface{}, error) {
+	result := make([]interface{}, len(evaluators))
+	for index, evaluate := range evaluators {
+		var err error
+		if result[index], err = evaluate(row); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+func columnEvaluator(index int) evaluator {
+	return func(row Row) (interface{}, error) { return row[index], nil }
+}
+
+func literalValue(token *tokenizer.Token) (interface{}, error) {
+	if token.Kind == tokenizer.NumericKind {
+		value, err := strconv.Atoi(token.Value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %s", token.Value)
+		}
+		return value, nil
+	}
+	// Type of CAST is passed to cast function by name
+	return token.Value, nil
+}
+
+func compileBinary(expression *parser.Expression, columns []Column) (evaluator, error) {
+	left, err := compile(expression.Left, columns)
+	if err != nil {
+		return nil, err
+	}
+	right, err := compile(expression.Right, columns)
+	if err != nil {
+		return nil, err
+	}
+	operator := expression.Token.Value
+	switch operator {
+	case tokenizer.AndKeyword, tokenizer.OrKeyword:
+		// Result is decided by the first operand equal to decisive value,
+		// otherwise it is NULL if any of operands is NULL
+		decisive := operator == tokenizer.OrKeyword
+		return func(row Row) (interface{}, error) {
+			var unknown bool
+			for _, operand := range []evaluator{left, right} {
+				value, err := operand(row)
+				if err != nil {
+					return nil, err
+				}
+				if value == nil {
+					unknown = true
+				} else if value == decisive {
+					return decisive, nil
+				}
+			}
+			if unknown {
+				return nil, nil
+			}
+			return !decisive, nil
+		}, nil
+	}
+	return func(row Row) (interface{}, error) {
+		first, err := left(row)
+		if err != nil {
+			return nil, err
+		}
+		second, err := right(row)
+		if err != nil || first == nil || second == nil {
+			return nil, err
+		}
+		order, err := compareValues(first, second)
+		if err != nil {
+			return nil, err
+		}
+		switch operator {
+		case "=":
+			return order == 0, nil
+		case "<>", "!=":
+			return order != 0, nil
+		case "<":
+			return order < 0, nil
+		case "<=":
+			return order <= 0, nil
+		case ">":
+			return order > 0, nil
+		case ">=":
+			return order >= 0, nil
+		}
+		return nil, fmt.Errorf("unsupported operator %s", operator)
+	}, nil
+}
+
+// compareValues compares two non-NULL values of the same type
+func compareValues(first interface{}, second interface{}) (int, error) {
+	switch value := first.(type) {
+	case int:
+		if other, ok := second.(int); ok {
+			switch {
+			case value < other:
+				return -1, nil
+			case value > other:
+				return 1, nil
+			}
+			return 0, nil
+		}
+	case string:
+		if other, ok := second.(string); ok {
+			return strings.Compare(value, other), nil
+		}
+	case bool:
+		if other, ok := second.(bool); ok {
+			switch {
+			case value == other:
+				return 0, nil
+			case !value:
+				return -1, nil
+			}
+			return 1, nil
+		}
+	}
+	return 0, fmt.Errorf("cannot compare %v (%T) with %v (%T)", first, first, second, second)
+}
+
+func compileIn(expression *parser.Expression, columns []Column) (evaluator, error) {
+	if expression.Subquery != nil {
+		return nil, fmt.Errorf("subquery %s is not supported here", formatExpression(expression))
+	}
+	left, err := compile(expression.Left, columns)
+	if err != nil {
+		return nil, err
+	}
+	list, err := compileAll(expression.Arguments, columns)
+	if err != nil {
+		return nil, err
+	}
+	not := expression.Not
+	return func(row Row) (interface{}, error) {
+		value, err := left(row)
+		if err != nil || value == nil {
+			return nil, err
+		}
+		candidates, err := evaluateAll(list, row)
+		if err != nil {
+			return nil, err
+		}
+		return in(value, candidates, not)
+	}, nil
+}
+
+// in checks if value equals one of candidates. Result is NULL if it does not
+// and some candidates are NULL, not negates other results
+func in(value interface{}, candidates []interface{}, not bool) (interface{}, error) {
+	var unknown bool
+	for _, candidate := range candidates {
+		if candidate == nil {
+			unknown = true
+			continue
+		}
+		order, err := compareValues(value, candidate)
+		if err != nil {
+			return nil, err
+		}
+		if order == 0 {
+			return !not, nil
+		}
+	}
+	if unknown {
+		return nil, nil
+	}
+	return not, nil
+}
+
+func compileLike(expression *parser.Expression, columns []Column) (evaluator, error) {
+	operands, err := compileAll(append([]*parser.Expression{expression.Left, expression.Right}, expression.Arguments...), columns)
+	if err != nil {
+		return nil, err
+	}
+	ignoreCase := expression.Token.Value == tokenizer.ILikeKeyword
+	not := expression.Not
+	return func(row Row) (interface{}, error) {
+		values, err := evaluateAll(operands, row)
+		if err != nil {
+			return nil, err
+		}
+		texts := make([]string, 3)
+		for index, value := range values {
+			if value == nil {
+				return nil, nil
+			}
+			text, ok := value.(string)
+			if !ok {
+				return nil, fmt.Errorf("LIKE operands must be text, got: %v", value)
+			}
+			texts[index] = text
+		}
+		matched, err := function.Like(texts[0], texts[1], texts[2], ignoreCase)
+		if err != nil {
+			return nil, err
+		}
+		return matched != not, nil
+	}, nil
+}
+
+func compileBetween(expression *parser.Expression, columns []Column) (evaluator, error) {
+	operands, err := compileAll(append([]*parser.Expression{expression.Left}, expression.Arguments...), columns)
+	if err != nil {
+		return nil, err
+	}
+	not := expression.Not
+	return func(row Row) (interface{}, error) {
+		values, err := evaluateAll(operands, row)
+		if err != nil {
+			return nil, err
+		}
+		for _, value := range values {
+			if value == nil {
+				return nil, nil
+			}
+		}
+		low, err := compareValues(values[0], values[1])
+		if err != nil {
+			return nil, err
+		}
+		high, err := compareValues(values[0], values[2])
+		if err != nil {
+			return nil, err
+		}
+		return (low >= 0 && high <= 0) != not, nil
+	}, nil
+}
+
+func compileCase(expression *parser.Expression, columns []Column) (evaluator, error) {
+	var (
+		operand, otherwise evaluator
+		err                error
+	)
+	if expression.Left != nil {
+		if operand, err = compile(expression.Left, columns); err != nil {
+			return nil, err
+		}
+	}
+	if expression.Right != nil {
+		if otherwise, err = compile(expression.Right, columns); err != nil {
+			return nil, err
+		}
+	}
+	branches, err := compileAll(expression.Arguments, columns)
+	if err != nil {
+		return nil, err
+	}
+	return func(row Row) (interface{}, error) {
+		var subject interface{}
+		if operand != nil {
+			value, err := operand(row)
+			if err != nil {
+				return nil, err
+			}
+			subject = value
+		}
+		for index := 0; index+1 < len(branches); index += 2 {
+			condition, err := branches[index](row)
+			if err != nil {
+				return nil, err
+			}
+			matched := condition == true
+			// Simple CASE compares operand with WHEN values
+			if operand != nil {
+				matched = false
+				if subject != nil && condition != nil {
+					order, err := compareValues(subject, condition)
+					if err != nil {
+						return nil, err
+					}
+					matched = order == 0
+				}
+			}
+			if matched {
+				return branches[index+1](row)
+			}
+		}
+		if otherwise != nil {
+			return otherwise(row)
+		}
+		return nil, nil
+	}, nil
+}
+
+func compileFunction(expression *parser.Expression, columns []Column) (evaluator, error) {
+	definition, err := function.Builtin.Lookup(expression.Token.Value)
+	if err != nil {
+		return nil, err
+	}
+	switch definition.Kind {
+	case function.AggregateFunction:
+		return nil, fmt.Errorf("aggregate function %s is not allowed here", formatExpression(expression))
+	case function.WindowFunction:
+		return nil, fmt.Errorf("window function %s is not allowed here", formatExpression(expression))
+	}
+	arguments, err := compileAll(expression.Arguments, columns)
+	if err != nil {
+		return nil, err
+	}
+	return func(row Row) (interface{}, error) {
+		values, err := evaluateAll(arguments, row)
+		if err != nil {
+			return nil, err
+		}
+		return definition.Call(values)
+	}, nil
+}
@@ -0,0 +1,149 @@
+package planner
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/VorobevPavel-dev/congenial-disco/parser"
+	"github.com/VorobevPavel-dev/congenial-disco/tokenizer"
+)
+
+// Format renders plan as a tree, one node per line with children indented
+// under their parent
+func Format(node Node) string {
+	var builder strings.Builder
+	formatNode(&builder, node, 0)
+	return builder.String()
+}
+
+func formatNode(builder *strings.Builder, node Node, depth int) {
+	builder.WriteString(strings.Repeat("  ", depth))
+	if depth > 0 {
+		builder.WriteString("-> ")
+	}
+	builder.WriteString(node.String())
+	builder.WriteString("\n")
+	for _, child := range node.Children() {
+		formatNode(builder, child, depth+1)
+	}
+}
+
+// formatExpression renders expression in SQL syntax
+func formatExpression(expression *parser.Expression) string {
+	if expression == nil {
+		return ""
+	}
+	switch expression.Kind {
+	case parser.LiteralExpression:
+		if expression.Token.Kind == tokenizer.StringKind {
+			return "'" + strings.ReplaceAll(expression.Token.Value, "'", "''") + "'"
+		}
+		return expression.Token.Value
+	case parser.ParameterExpression:
+		return expression.Token.Value
+	case parser.ColumnExpression:
+		if expression.Table != nil {
+			return expression.Table.Value + "." + expression.Token.Value
+		}
+		return expression.Token.Value
+	case parser.BinaryExpression:
+		return fmt.Sprintf("(%s %s %s)", formatExpression(expression.Left),
+			strings.ToUpper(expression.Token.Value), formatExpression(expression.Right))
+	case parser.UnaryExpression:
+		return fmt.Sprintf("(%s %s)", strings.ToUpper(expression.Token.Value), formatExpression(expression.Left))
+	case parser.SubqueryExpression:
+		return "(SELECT ...)"
+	case parser.ExistsExpression:
+		return "EXISTS (SELECT ...)"
+	case parser.InExpression:
+		list := "SELECT ..."
+		if expression.Subquery == nil {
+			list = formatExpressions(expression.Arguments)
+		}
+		return fmt.Sprintf("(%s%s IN (%s))", formatExpression(expression.Left), formatNot(expression), list)
+	case parser.FunctionExpression:
+		result := expression.Token.Value + "(" + formatExpressions(expression.Arguments) + ")"
+		if expression.Window != nil {
+			result += " OVER (...)"
+		}
+		return result
+	case parser.CaseExpression:
+		var builder strings.Builder
+		builder.WriteString("CASE")
+		if expression.Left != nil {
+			builder.WriteString(" " + formatExpression(expression.Left))
+		}
+		for index := 0; index+1 < len(expression.Arguments); index += 2 {
+			fmt.Fprintf(&builder, " WHEN %s THEN %s",
+				formatExpression(expression.Arguments[index]), formatExpression(expression.Arguments[index+1]))
+		}
+		if expression.Right != nil {
+			builder.WriteString(" ELSE " + formatExpression(expression.Right))
+		}
+		builder.WriteString(" END")
+		return builder.String()
+	case parser.LikeExpression:
+		result := fmt.Sprintf("(%s%s %s %s", formatExpression(expression.Left), formatNot(expression),
+			strings.ToUpper(expression.Token.Value), formatExpression(expression.Right))
+		if len(expression.Arguments) != 0 {
+			result += " ESCAPE " + formatExpression(expression.Arguments[0])
+		}
+		return result + ")"
+	case parser.BetweenExpression:
+		return fmt.Sprintf("(%s%s BETWEEN %s AND %s)", formatExpression(expression.Left), formatNot(expression),
+			formatExpression(expression.Arguments[0]), formatExpression(expression.Arguments[1]))
+	}
+	return expression.String()
+}
+
+func formatExpressions(expressions []*parser.Expression) string {
+	parts := make([]string, len(expressions))
+	for index, expression := range expressions {
+		parts[index] = formatExpression(expression)
+	}
+	return strings.Join(parts, ", ")
+}
+
+func formatOrderBy(terms []*parser.OrderingTerm) string {
+	parts := make([]string, len(terms))
+	for index, term := range terms {
+		parts[index] = formatExpression(term.Expression)
+		if term.Descending {
+			parts[index] += " DESC"
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// formatFrame renders frame clause of window in SQL syntax
+func formatFrame(frame *parser.WindowFrame) string {
+	bound := func(bound *parser.FrameBound) string {
+		switch bound.Kind {
+		case parser.UnboundedPrecedingBound:
+			return "UNBOUNDED PRECEDING"
+		case parser.PrecedingBound:
+			return bound.Offset.Value + " PRECEDING"
+		case parser.CurrentRowBound:
+			return "CURRENT ROW"
+		case parser.FollowingBound:
+			return bound.Offset.Value + " FOLLOWING"
+		}
+		return "UNBOUNDED FOLLOWING"
+	}
+	return fmt.Sprintf("%s BETWEEN %s AND %s", strings.ToUpper(frame.Unit.Value), bound(frame.Start), bound(frame.End))
+}
+
+func formatNot(expression *parser.Expression) string {
+	if expression.Not {
+		return " NOT"
+	}
+	return ""
+}
+
+func formatColumns(columns []Column) string {
+	parts := make([]string, len(columns))
+	for index, column := range columns {
+		parts[index] = column.String()
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,45 @@
+package planner
+
+import (
+	"fmt"
+
+	"github.com/VorobevPavel-dev/congenial-disco/engine"
+	"github.com/VorobevPavel-dev/congenial-disco/parser"
+)
+
+// ExecuteInsert adds row of INSERT statement in transaction. Columns not
+// listed by statement are NULL
+func ExecuteInsert(transaction engine.Transaction, statement *parser.InsertStatement) error {
+	columns, err := transaction.Columns(statement.Table.Value)
+	if err != nil {
+		return err
+	}
+	names := statement.ColumnNames
+	if len(names) == 0 {
+		if len(statement.Values) != len(columns) {
+			return fmt.Errorf("expected %d values for table %s, got %d", len(columns), statement.Table.Value, len(statement.Values))
+		}
+	} else if len(names) != len(statement.Values) {
+		return fmt.Errorf("expected %d values for listed columns, got %d", len(names), len(statement.Values))
+	}
+	row := make(Row, len(columns))
+	for position, token := range statement.Values {
+		index := position
+		if len(names) != 0 {
+			index = -1
+			for candidate, column := range columns {
+				if column == names[position].Value {
+					index = candidate
+					break
+				}
+			}
+			if index == -1 {
+				return fmt.Errorf("column %s does not exist in table %s", names[position].Value, statement.Table.Value)
+			}
+		}
+		if row[index], err = literalValue(token); err != nil {
+			return err
+		}
+	}
+	return transaction.Insert(statement.Table.Value, []Row{row})
+}
@@ -0,0 +1,385 @@
+package planner
+
+import (
+	"github.com/VorobevPavel-dev/congenial-disco/parser"
+)
+
+// join lowers join to hash join if its condition compares expressions of
+// the left input with expressions of the right one for equality, other joins
+// are lowered to nested loop joins. Hash tables of parallel plans are built
+// by several workers and shared by all workers probing them
+func (l *lowering) join(join *Join) (Operator, error) {
+	left, err := l.lower(join.Left)
+	if err != nil {
+		return nil, err
+	}
+	columns := append(append([]Column{}, join.Left.Columns()...), join.Right.Columns()...)
+	var leftKeys, rightKeys, rest []*parser.Expression
+	if join.Condition != nil {
+		leftKeys, rightKeys, rest = equiJoinKeys(join.Condition, join.Left.Columns(), join.Right.Columns())
+	}
+	var right Operator
+	var shared *sharedTable
+	switch {
+	case len(leftKeys) != 0 && l.parent != nil:
+		shared, err = l.parent.sharedTable(join, rightKeys)
+	case len(leftKeys) != 0 && l.workers > 1 && l.budget == nil:
+		shared, err = l.sharedTable(join, rightKeys)
+	default:
+		right, err = l.lower(join.Right)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(leftKeys) == 0 {
+		operator := &nestedLoopJoin{left: left, right: right, kind: join.Kind}
+		if join.Condition != nil {
+			if operator.condition, err = compile(join.Condition, columns); err != nil {
+				return nil, err
+			}
+		}
+		return operator, nil
+	}
+	operator := &hashJoin{left: left, right: right, kind: join.Kind, budget: l.budget, shared: shared}
+	if operator.leftKeys, err = compileAll(leftKeys, join.Left.Columns()); err != nil {
+		return nil, err
+	}
+	if shared == nil {
+		if operator.rightKeys, err = compileAll(rightKeys, join.Right.Columns()); err != nil {
+			return nil, err
+		}
+	}
+	if len(rest) != 0 {
+		if operator.condition, err = compile(conjunction(rest), columns); err != nil {
+			return nil, err
+		}
+	}
+	return operator, nil
+}
+
+// equiJoinKeys splits condition of join into equalities of expressions of the
+// left input with expressions of the right one and the rest of conjuncts
+func equiJoinKeys(condition *parser.Expression, left, right []Column) (leftKeys, rightKeys, rest []*parser.Expression) {
+	for _, conjunct := range conjuncts(condition) {
+		if conjunct.Kind == parser.BinaryExpression && conjunct.Token.Value == "=" {
+			first, second := conjunct.Left, conjunct.Right
+			if !refersOnlyTo(first, left) || !refersOnlyTo(second, right) {
+				first, second = second, first
+			}
+			if refersOnlyTo(first, left) && refersOnlyTo(second, right) {
+				leftKeys = append(leftKeys, first)
+				rightKeys = append(rightKeys, second)
+				continue
+			}
+		}
+		rest = append(rest, conjunct)
+	}
+	return leftKeys, rightKeys, rest
+}
+
+// hashJoin builds hash table of rows of the right input on Open and looks up
+// rows of the left input in it by values of keys. Rows with NULL keys never
+// match, so anti join produces left rows with NULL keys. When rows of the
+// right input do not fit into memory budget, both inputs are partitioned to
+// files by hashes of keys and pairs of partitions are joined one by one
+type hashJoin struct {
+	left, right         Operator
+	leftKeys, rightKeys []evaluator
+	// condition is the rest of join condition checked for matching rows
+	condition evaluator
+	kind      JoinKind
+	budget    *budget
+	// shared is a table built in parallel instead of the right input, it is
+	// never spilled
+	shared *sharedTable
+
+	table    map[string][]Row
+	reserved int64
+	// probe returns rows looked up in the table, they are read from the left
+	// input if it is opened or from the left partition otherwise
+	probe   func() (Row, error)
+	opened  bool
+	probing *spillReader
+	pending []joinPartitions
+
+	current Row
+	matches []Row
+	next    int
+}
+
+// joinPartitions are partitions of inputs with the same hashes of keys
+type joinPartitions struct {
+	left, right *spillReader
+	depth       int
+}
+
+// joinKey returns encoded values of keys of row, ok is false if any of them is
+// NULL
+func joinKey(keys []evaluator, row Row) (result string, ok bool, err error) {
+	values, err := evaluateAll(keys, row)
+	if err != nil {
+		return "", false, err
+	}
+	for _, value := range values {
+		if value == nil {
+			return "", false, nil
+		}
+	}
+	return encodeKey(values), true, nil
+}
+
+// readRows passes all rows of spilled partition to consume and closes it
+func readRows(reader *spillReader, consume func(Row) error) (err error) {
+	defer func() {
+		if closeErr := reader.close(); err == nil {
+			err = closeErr
+		}
+	}()
+	for {
+		row, err := reader.read()
+		if err != nil || row == nil {
+			return err
+		}
+		if err := consume(row); err != nil {
+			return err
+		}
+	}
+}
+
+func (hj *hashJoin) Open() error {
+	if err := hj.Close(); err != nil {
+		return err
+	}
+	if hj.shared != nil {
+		table, err := hj.shared.build()
+		if err != nil {
+			return err
+		}
+		if err := hj.left.Open(); err != nil {
+			return err
+		}
+		hj.table, hj.opened, hj.probe = table, true, hj.left.Next
+		return nil
+	}
+	spilled, err := hj.build(func(consume func(Row) error) error { return each(hj.right, consume) }, 0)
+	if err != nil {
+		return err
+	}
+	if spilled == nil {
+		if err := hj.left.Open(); err != nil {
+			return err
+		}
+		hj.opened, hj.probe = true, hj.left.Next
+		return nil
+	}
+	return hj.partition(func(consume func(Row) error) error { return each(hj.left, consume) }, spilled, 0)
+}
+
+// build fills hash table with rows produced by produce function. If rows do
+// not fit into memory, all of them are written to partitions which are
+// returned
+func (hj *hashJoin) build(produce func(consume func(Row) error) error, depth int) (*partitions, error) {
+	hj.table = map[string][]Row{}
+	var spilled *partitions
+	err := produce(func(row Row) error {
+		encoded, ok, err := joinKey(hj.rightKeys, row)
+		if err != nil || !ok {
+			return err
+		}
+		if spilled != nil {
+			return spilled.write(encoded, row)
+		}
+		size := rowSize(row) + int64(len(encoded))
+		switch {
+		case hj.budget.reserve(size):
+		case depth < maxSpillDepth:
+			// Rows of the table are moved to partitions
+			if spilled, err = newPartitions(hj.budget, depth); err != nil {
+				return err
+			}
+			for encoded, rows := range hj.table {
+				for _, row := range rows {
+					if err := spilled.write(encoded, row); err != nil {
+						return err
+					}
+				}
+			}
+			hj.table = map[string][]Row{}
+			hj.budget.release(hj.reserved)
+			hj.reserved = 0
+			return spilled.write(encoded, row)
+		default:
+			hj.budget.take(size)
+		}
+		hj.reserved += size
+		hj.table[encoded] = append(hj.table[encoded], row)
+		return nil
+	})
+	if err != nil {
+		if spilled != nil {
+			spilled.discard()
+		}
+		return nil, err
+	}
+	return spilled, nil
+}
+
+// partition writes rows of the left input to partitions matching spilled
+// partitions of the right input and schedules joining of them before other
+// pending partitions
+func (hj *hashJoin) partition(produce func(consume func(Row) error) error, right *partitions, depth int) error {
+	left, err := newPartitions(hj.budget, depth)
+	if err != nil {
+		right.discard()
+		return err
+	}
+	err = produce(func(row Row) error {
+		encoded, ok, err := joinKey(hj.leftKeys, row)
+		if err != nil || !ok && hj.kind != AntiJoin {
+			return err
+		}
+		return left.write(encoded, row)
+	})
+	if err != nil {
+		left.discard()
+		right.discard()
+		return err
+	}
+	rightReaders, err := right.readers()
+	if err != nil {
+		left.discard()
+		return err
+	}
+	leftReaders, err := left.readers()
+	if err != nil {
+		for _, reader := range rightReaders {
+			reader.close()
+		}
+		return err
+	}
+	var scheduled []joinPartitions
+	for index := range leftReaders {
+		scheduled = append(scheduled, joinPartitions{left: leftReaders[index], right: rightReaders[index], depth: depth + 1})
+	}
+	hj.pending = append(scheduled, hj.pending...)
+	hj.probe = noRows
+	return nil
+}
+
+// noRows is a probe of hash join when all rows of current partitions are
+// read
+func noRows() (Row, error) { return nil, nil }
+
+// advance starts joining the next pair of partitions, it returns false when
+// there are no more partitions
+func (hj *hashJoin) advance() (bool, error) {
+	if hj.probing != nil {
+		err := hj.probing.close()
+		hj.probing, hj.probe = nil, noRows
+		if err != nil {
+			return false, err
+		}
+	}
+	for len(hj.pending) != 0 {
+		pair := hj.pending[0]
+		hj.pending = hj.pending[1:]
+		hj.budget.release(hj.reserved)
+		hj.reserved = 0
+		spilled, err := hj.build(func(consume func(Row) error) error { return readRows(pair.right, consume) }, pair.depth)
+		if err != nil {
+			pair.left.close()
+			return false, err
+		}
+		if spilled == nil {
+			hj.probing = pair.left
+			hj.probe = pair.left.read
+			return true, nil
+		}
+		// Partition does not fit into memory, so it is partitioned again
+		produce := func(consume func(Row) error) error { return readRows(pair.left, consume) }
+		if err := hj.partition(produce, spilled, pair.depth); err != nil {
+			return false, err
+		}
+	}
+	return false, nil
+}
+
+func (hj *hashJoin) Next() (Row, error) {
+	for {
+		for hj.next < len(hj.matches) {
+			combined := append(append(Row{}, hj.current...), hj.matches[hj.next]...)
+			hj.next++
+			if hj.condition != nil {
+				value, err := hj.condition(combined)
+				if err != nil {
+					return nil, err
+				}
+				if value != true {
+					continue
+				}
+			}
+			switch hj.kind {
+			case SemiJoin:
+				// The rest of matches are skipped, so that left row is
+				// produced only once
+				hj.next = len(hj.matches)
+				return hj.current, nil
+			case AntiJoin:
+				// Matching left row is skipped
+				hj.next, hj.current = len(hj.matches), nil
+				continue
+			}
+			return combined, nil
+		}
+		if hj.kind == AntiJoin && hj.current != nil {
+			row := hj.current
+			hj.current = nil
+			return row, nil
+		}
+		row, err := hj.probe()
+		if err != nil {
+			return nil, err
+		}
+		if row == nil {
+			ok, err := hj.advance()
+			if err != nil || !ok {
+				return nil, err
+			}
+			continue
+		}
+		encoded, ok, err := joinKey(hj.leftKeys, row)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			hj.current, hj.matches, hj.next = row, hj.table[encoded], 0
+		} else if hj.kind == AntiJoin {
+			hj.current, hj.matches, hj.next = row, nil, 0
+		}
+	}
+}
+
+func (hj *hashJoin) Close() error {
+	var result error
+	if hj.opened {
+		result = hj.left.Close()
+		hj.opened = false
+	}
+	if hj.probing != nil {
+		if err := hj.probing.close(); err != nil && result == nil {
+			result = err
+		}
+		hj.probing = nil
+	}
+	for _, pair := range hj.pending {
+		for _, reader := range []*spillReader{pair.left, pair.right} {
+			if err := reader.close(); err != nil && result == nil {
+				result = err
+			}
+		}
+	}
+	hj.pending, hj.table, hj.current, hj.matches, hj.next = nil, nil, nil, nil, 0
+	hj.budget.release(hj.reserved)
+	hj.reserved = 0
+	return result
+}
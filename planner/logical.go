@@ -0,0 +1,1119 @@
+package planner
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/VorobevPavel-dev/congenial-disco/function"
+	"github.com/VorobevPavel-dev/congenial-disco/parser"
+	"github.com/VorobevPavel-dev/congenial-disco/tokenizer"
+)
+
+const (
+	// InnerJoin produces pairs of matching rows of both inputs
+	InnerJoin JoinKind = iota
+	// SemiJoin produces rows of the left input which have at least one
+	// matching row in the right input
+	SemiJoin
+	// AntiJoin produces rows of the left input which have no matching rows
+	// in the right input
+	AntiJoin
+)
+
+// JoinKind defines which rows are produced by join
+type JoinKind uint
+
+func (jk JoinKind) String() string {
+	switch jk {
+	case SemiJoin:
+		return "semi"
+	case AntiJoin:
+		return "anti"
+	}
+	return "inner"
+}
+
+// Node is a node of logical plan. Nodes produce rows with columns returned by
+// Columns from rows produced by their children.
+type Node interface {
+	Columns() []Column
+	Children() []Node
+	// String describes node without its children
+	String() string
+}
+
+// Scan reads rows of table. Columns holds only columns required by the rest
+// of plan
+type Scan struct {
+	Table  string
+	Output []Column
+}
+
+func (s *Scan) Columns() []Column { return s.Output }
+func (s *Scan) Children() []Node  { return nil }
+func (s *Scan) String() string {
+	return fmt.Sprintf("Scan %s [%s]", s.Table, formatColumns(s.Output))
+}
+
+// Filter passes rows for which condition is true
+type Filter struct {
+	Input     Node
+	Condition *parser.Expression
+}
+
+func (f *Filter) Columns() []Column { return f.Input.Columns() }
+func (f *Filter) Children() []Node  { return []Node{f.Input} }
+func (f *Filter) String() string {
+	return "Filter " + formatExpression(f.Condition)
+}
+
+// Project computes expressions for every input row
+type Project struct {
+	Input       Node
+	Expressions []*parser.Expression
+	Output      []Column
+}
+
+func (p *Project) Columns() []Column { return p.Output }
+func (p *Project) Children() []Node  { return []Node{p.Input} }
+func (p *Project) String() string {
+	return "Project [" + formatExpressions(p.Expressions) + "]"
+}
+
+// Join combines rows of two inputs for which condition is true. Rows of join
+// consist of columns of the left input followed by columns of the right one
+// (semi and anti joins produce columns of the left input only). Join without
+// condition is a cross join.
+type Join struct {
+	Kind      JoinKind
+	Left      Node
+	Right     Node
+	Condition *parser.Expression
+}
+
+func (j *Join) Columns() []Column {
+	if j.Kind != InnerJoin {
+		return j.Left.Columns()
+	}
+	return append(append([]Column{}, j.Left.Columns()...), j.Right.Columns()...)
+}
+func (j *Join) Children() []Node { return []Node{j.Left, j.Right} }
+func (j *Join) String() string {
+	if j.Condition == nil {
+		return fmt.Sprintf("Join %s", j.Kind)
+	}
+	return fmt.Sprintf("Join %s on %s", j.Kind, formatExpression(j.Condition))
+}
+
+// Aggregate groups input rows by values of GroupBy expressions and computes
+// aggregate functions for every group. Its rows consist of values of GroupBy
+// expressions followed by values of aggregates. Aggregate without GroupBy
+// produces exactly one row.
+type Aggregate struct {
+	Input      Node
+	GroupBy    []*parser.Expression
+	Aggregates []*parser.Expression
+	Output     []Column
+}
+
+func (a *Aggregate) Columns() []Column { return a.Output }
+func (a *Aggregate) Children() []Node  { return []Node{a.Input} }
+func (a *Aggregate) String() string {
+	return fmt.Sprintf("Aggregate group by [%s] compute [%s]",
+		formatExpressions(a.GroupBy), formatExpressions(a.Aggregates))
+}
+
+// Window computes window functions sharing the same window specification
+// over partitions of input rows. Its rows consist of input columns followed
+// by values of functions
+type Window struct {
+	Input     Node
+	Functions []*parser.Expression
+	// Output are columns of values of functions
+	Output []Column
+}
+
+// Specification returns window specification shared by functions
+func (w *Window) Specification() *parser.WindowSpecification { return w.Functions[0].Window }
+
+func (w *Window) Columns() []Column {
+	return append(append([]Column{}, w.Input.Columns()...), w.Output...)
+}
+func (w *Window) Children() []Node { return []Node{w.Input} }
+func (w *Window) String() string {
+	result := "Window"
+	specification := w.Specification()
+	if specification.PartitionBy != nil {
+		result += " partition by [" + formatExpressions(specification.PartitionBy) + "]"
+	}
+	if specification.OrderBy != nil {
+		result += " order by [" + formatOrderBy(specification.OrderBy) + "]"
+	}
+	if specification.Frame != nil {
+		result += " " + formatFrame(specification.Frame)
+	}
+	functions := make([]string, len(w.Functions))
+	for index, function := range w.Functions {
+		functions[index] = function.Token.Value + "(" + formatExpressions(function.Arguments) + ")"
+	}
+	return result + " compute [" + strings.Join(functions, ", ") + "]"
+}
+
+// Sort orders input rows. NULLs are greater than any other value
+type Sort struct {
+	Input   Node
+	OrderBy []*parser.OrderingTerm
+}
+
+func (s *Sort) Columns() []Column { return s.Input.Columns() }
+func (s *Sort) Children() []Node  { return []Node{s.Input} }
+func (s *Sort) String() string {
+	return "Sort [" + formatOrderBy(s.OrderBy) + "]"
+}
+
+// Limit skips Offset rows and passes at most Count of the following ones.
+// Negative Count means no limit
+type Limit struct {
+	Input  Node
+	Count  int
+	Offset int
+}
+
+func (l *Limit) Columns() []Column { return l.Input.Columns() }
+func (l *Limit) Children() []Node  { return []Node{l.Input} }
+func (l *Limit) String() string {
+	result := "Limit"
+	if l.Count >= 0 {
+		result += " " + strconv.Itoa(l.Count)
+	}
+	if l.Offset > 0 {
+		result += " offset " + strconv.Itoa(l.Offset)
+	}
+	return result
+}
+
+// SetOperation combines rows of two inputs with UNION, INTERSECT or EXCEPT
+// operator. Inputs produce the same number of columns of the same types,
+// columns of the result are named after columns of the left input. Duplicate
+// rows are removed unless All is set, which is allowed only for UNION
+type SetOperation struct {
+	Operator string
+	All      bool
+	Left     Node
+	Right    Node
+	Output   []Column
+}
+
+func (so *SetOperation) Columns() []Column { return so.Output }
+func (so *SetOperation) Children() []Node  { return []Node{so.Left, so.Right} }
+func (so *SetOperation) String() string {
+	result := strings.ToUpper(so.Operator[:1]) + so.Operator[1:]
+	if so.All {
+		result += " all"
+	}
+	return result
+}
+
+// RecursiveUnion evaluates recursive common table expression. Rows produced
+// by Initial are the first working table. Recursive reads working table and
+// is evaluated again and again over rows it produced on the previous
+// iteration until it produces no new rows. Unless All is set, rows produced
+// before are not produced again
+type RecursiveUnion struct {
+	Name      string
+	All       bool
+	Initial   Node
+	Recursive Node
+	Output    []Column
+}
+
+func (ru *RecursiveUnion) Columns() []Column { return ru.Output }
+func (ru *RecursiveUnion) Children() []Node  { return []Node{ru.Initial, ru.Recursive} }
+func (ru *RecursiveUnion) String() string {
+	if ru.All {
+		return "Recursive union all " + ru.Name
+	}
+	return "Recursive union " + ru.Name
+}
+
+// WorkingTable reads rows produced on the previous iteration of recursive
+// union of common table expression Name
+type WorkingTable struct {
+	Name   string
+	Output []Column
+}
+
+func (wt *WorkingTable) Columns() []Column { return wt.Output }
+func (wt *WorkingTable) Children() []Node  { return nil }
+func (wt *WorkingTable) String() string {
+	return fmt.Sprintf("Working table %s [%s]", wt.Name, formatColumns(wt.Output))
+}
+
+// Apply evaluates subquery of Expression (scalar subquery, EXISTS or IN) for
+// every input row. Subquery reads input row it is evaluated for from OuterRow
+// leaves with the same Number. Its rows consist of input columns followed by
+// value of expression
+type Apply struct {
+	Input      Node
+	Subquery   Node
+	Expression *parser.Expression
+	Number     int
+	Output     Column
+}
+
+func (a *Apply) Columns() []Column {
+	return append(append([]Column{}, a.Input.Columns()...), a.Output)
+}
+func (a *Apply) Children() []Node { return []Node{a.Input, a.Subquery} }
+func (a *Apply) String() string {
+	return fmt.Sprintf("Apply $subquery%d %s", a.Number, formatExpression(a.Expression))
+}
+
+// Correlated checks if subquery reads rows apply evaluates it for
+func (a *Apply) Correlated() bool {
+	var found bool
+	var walk func(node Node)
+	walk = func(node Node) {
+		if outer, ok := node.(*OuterRow); ok && outer.Number == a.Number {
+			found = true
+		}
+		for _, child := range node.Children() {
+			walk(child)
+		}
+	}
+	walk(a.Subquery)
+	return found
+}
+
+// OuterRow produces one row with columns of the enclosing query which
+// correlated subquery of Apply with the same Number refers to
+type OuterRow struct {
+	Number int
+	Output []Column
+}
+
+func (or *OuterRow) Columns() []Column { return or.Output }
+func (or *OuterRow) Children() []Node  { return nil }
+func (or *OuterRow) String() string {
+	return fmt.Sprintf("Outer row $subquery%d [%s]", or.Number, formatColumns(or.Output))
+}
+
+// Plan converts statement to logical plan. Plan is built in the order SQL
+// clauses are evaluated: FROM and JOIN, WHERE, GROUP BY, window functions,
+// ORDER BY, select list, DISTINCT, LIMIT. SELECT statements combined with set
+// operators are planned one by one and their results are combined from left
+// to right. Common table expressions of WITH clause are planned at every
+// reference to them like derived tables. Subqueries filtering rows of WHERE
+// clause are turned into joins where possible, other subqueries are
+// evaluated for every row of the enclosing query.
+func Plan(statement *parser.Statement, schema parser.Schema) (Node, error) {
+	return PlanContext(context.Background(), statement, schema)
+}
+
+// PlanContext is Plan which is aborted with error of context if it is done
+// before statement and all its subqueries are planned
+func PlanContext(ctx context.Context, statement *parser.Statement, schema parser.Schema) (Node, error) {
+	builder := &planBuilder{context: ctx, schema: schema}
+	switch {
+	case statement.SelectStatement != nil:
+		return builder.selectStatement(statement.SelectStatement)
+	case statement.CompoundStatement != nil:
+		return builder.compound(statement.CompoundStatement)
+	}
+	return nil, fmt.Errorf("only SELECT statements can be planned")
+}
+
+type planBuilder struct {
+	context context.Context
+	schema  parser.Schema
+	// tables are common table expressions visible to the query being
+	// planned by their names
+	tables map[string]*commonTable
+	// subqueries counts subqueries turned into joins or applies to give
+	// unique names to their columns
+	subqueries int
+	// outer are columns of the enclosing query visible to correlated
+	// subquery being planned and number is a number of its apply
+	outer  []Column
+	number int
+}
+
+func (pb *planBuilder) selectStatement(slct *parser.SelectStatement) (Node, error) {
+	if err := pb.context.Err(); err != nil {
+		return nil, err
+	}
+	if err := slct.CheckTypes(pb.schema); err != nil {
+		return nil, err
+	}
+	outer, err := pb.outerRow(slct)
+	if err != nil {
+		return nil, err
+	}
+	node, err := pb.from(slct, outer)
+	if err != nil {
+		return nil, err
+	}
+
+	// Process WHERE clause. Uncorrelated IN (SELECT ...) conditions are
+	// turned into semi joins, correlated EXISTS and IN conditions are turned
+	// into semi and anti joins where possible. The rest of conditions are
+	// checked by filter, the ones using other subqueries are checked after
+	// applies evaluating them
+	if slct.Where != nil {
+		var conditions, evaluated []*parser.Expression
+		for _, condition := range conjuncts(slct.Where) {
+			switch {
+			case condition.Kind == parser.InExpression && condition.Subquery != nil && !condition.Not &&
+				condition.Subquery.OuterReferences() == nil:
+				if node, err = pb.semiJoin(node, condition); err != nil {
+					return nil, err
+				}
+			case subqueries(condition) == nil:
+				conditions = append(conditions, condition)
+			default:
+				if join := pb.decorrelate(node, condition); join != nil {
+					node = join
+				} else {
+					evaluated = append(evaluated, condition)
+				}
+			}
+		}
+		if conditions != nil {
+			if node, err = pb.filter(node, conjunction(conditions)); err != nil {
+				return nil, err
+			}
+		}
+		if evaluated != nil {
+			condition := conjunction(evaluated)
+			if node, err = pb.applies(node, condition); err != nil {
+				return nil, err
+			}
+			if node, err = pb.filter(node, condition); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// Process GROUP BY clause and aggregate functions
+	var aggregates []*parser.Expression
+	for _, expression := range slct.Item {
+		aggregates = appendAggregates(aggregates, expression)
+	}
+	for _, term := range slct.OrderBy {
+		aggregates = appendAggregates(aggregates, term.Expression)
+	}
+	if slct.GroupBy != nil || aggregates != nil {
+		if node, err = pb.aggregate(node, slct, slct.GroupBy, aggregates); err != nil {
+			return nil, err
+		}
+	}
+
+	// Window functions are computed over grouped rows. Functions sharing
+	// window specification are computed together over rows sorted once
+	for _, functions := range slct.WindowGroups() {
+		if node, err = pb.window(node, slct, functions); err != nil {
+			return nil, err
+		}
+	}
+
+	// Subqueries of select list and ORDER BY clause are evaluated for every
+	// row
+	expressions := append([]*parser.Expression{}, slct.Item...)
+	for _, term := range slct.OrderBy {
+		expressions = append(expressions, term.Expression)
+	}
+	if node, err = pb.applies(node, expressions...); err != nil {
+		return nil, err
+	}
+
+	// ORDER BY of DISTINCT statement can refer to selected columns only, so
+	// rows are sorted after duplicates are removed
+	if slct.OrderBy != nil && !slct.Distinct {
+		if node, err = pb.sort(node, slct.OrderBy); err != nil {
+			return nil, err
+		}
+	}
+	if node, err = pb.project(node, slct); err != nil {
+		return nil, err
+	}
+	if slct.Distinct {
+		if node, err = pb.aggregate(node, slct, slct.Item, nil); err != nil {
+			return nil, err
+		}
+		if slct.OrderBy != nil {
+			if node, err = pb.sort(node, slct.OrderBy); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if slct.Limit != nil {
+		limit := &Limit{Input: node}
+		if limit.Count, err = strconv.Atoi(slct.Limit.Value); err != nil {
+			return nil, err
+		}
+		if slct.Offset != nil {
+			if limit.Offset, err = strconv.Atoi(slct.Offset.Value); err != nil {
+				return nil, err
+			}
+		}
+		node = limit
+	}
+	return node, nil
+}
+
+// compound combines plans of SELECT statements with set operators from left
+// to right
+func (pb *planBuilder) compound(compound *parser.CompoundStatement) (Node, error) {
+	if err := compound.CheckTypes(pb.schema); err != nil {
+		return nil, err
+	}
+	if compound.With != nil {
+		tables, schema := pb.tables, pb.schema
+		defer func() { pb.tables, pb.schema = tables, schema }()
+		if err := pb.with(compound.With); err != nil {
+			return nil, err
+		}
+	}
+	node, err := pb.selectStatement(compound.Selects[0])
+	if err != nil {
+		return nil, err
+	}
+	for index, operator := range compound.Operators {
+		right, err := pb.selectStatement(compound.Selects[index+1])
+		if err != nil {
+			return nil, err
+		}
+		node = setOperation(operator.Token.Value, operator.All, node, right)
+	}
+	return node, nil
+}
+
+func setOperation(operator string, all bool, left, right Node) *SetOperation {
+	operation := &SetOperation{Operator: operator, All: all, Left: left, Right: right}
+	// Values of the result are not computed from expressions of the left
+	// input, so columns do not keep them
+	for _, column := range left.Columns() {
+		operation.Output = append(operation.Output, Column{Table: column.Table, Name: column.Name, Type: column.Type})
+	}
+	return operation
+}
+
+// commonTable is a common table expression visible to the query being
+// planned. Expressions are planned anew at every reference to them, so that
+// rules optimize every reference on its own
+type commonTable struct {
+	expression *parser.CommonTableExpression
+	// columns are named by column list of expression and belong to table
+	// named after it
+	columns []Column
+	// tables and schema are the ones visible to query of expression
+	tables map[string]*commonTable
+	schema parser.Schema
+	// working is set for references of recursive expression to itself,
+	// they read working table of recursive union
+	working bool
+}
+
+// with makes common table expressions of clause visible to the rest of
+// query. Every expression can refer to the ones defined before it, recursive
+// expressions can refer to themselves too
+func (pb *planBuilder) with(clause *parser.WithClause) error {
+	definitions, err := clause.Schema(pb.schema)
+	if err != nil {
+		return err
+	}
+	for _, expression := range clause.Tables {
+		name := expression.Name.Value
+		table := &commonTable{expression: expression, tables: pb.tables, schema: pb.schema}
+		for _, column := range definitions[name].Cols {
+			table.columns = append(table.columns, Column{Table: name, Name: column.Name.Value, Type: column.Datatype.Value})
+		}
+		tables := map[string]*commonTable{name: table}
+		for other, visible := range pb.tables {
+			if other != name {
+				tables[other] = visible
+			}
+		}
+		schema := parser.Schema{name: definitions[name]}
+		for other, definition := range pb.schema {
+			if other != name {
+				schema[other] = definition
+			}
+		}
+		if expression.IsRecursive() {
+			table.tables, table.schema = tables, schema
+		}
+		pb.tables, pb.schema = tables, schema
+	}
+	return nil
+}
+
+// reference plans reference to common table expression
+func (pb *planBuilder) reference(table *commonTable) (Node, error) {
+	if table.working {
+		return &WorkingTable{Name: table.expression.Name.Value, Output: table.columns}, nil
+	}
+	// Common table expressions can not refer to columns of queries using
+	// them
+	tables, schema, outer, number := pb.tables, pb.schema, pb.outer, pb.number
+	defer func() { pb.tables, pb.schema, pb.outer, pb.number = tables, schema, outer, number }()
+	pb.tables, pb.schema, pb.outer, pb.number = table.tables, table.schema, nil, 0
+	var (
+		node Node
+		err  error
+	)
+	if table.expression.IsRecursive() {
+		node, err = pb.recursive(table)
+	} else {
+		node, err = pb.compound(table.expression.Query)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return relabel(node, table.columns), nil
+}
+
+// recursive plans recursive common table expression. SELECT statements which
+// do not refer to expression produce initial rows, the rest of them are
+// evaluated over rows produced on the previous iteration. Rows produced
+// before are skipped unless all SELECT statements are combined with UNION
+// ALL
+func (pb *planBuilder) recursive(table *commonTable) (Node, error) {
+	query, name := table.expression.Query, table.expression.Name.Value
+	if query.With != nil {
+		if err := pb.with(query.With); err != nil {
+			return nil, err
+		}
+	}
+	working := *table
+	working.working = true
+	tables := map[string]*commonTable{name: &working}
+	for other, visible := range pb.tables {
+		if other != name {
+			tables[other] = visible
+		}
+	}
+	pb.tables = tables
+
+	union := &RecursiveUnion{Name: name, All: true}
+	for _, operator := range query.Operators {
+		union.All = union.All && operator.All
+	}
+	for _, slct := range query.Selects {
+		node, err := pb.selectStatement(slct)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case !slct.References(name) && union.Initial == nil:
+			union.Initial = node
+		case !slct.References(name):
+			union.Initial = setOperation(tokenizer.UnionKeyword, true, union.Initial, node)
+		case union.Recursive == nil:
+			union.Recursive = node
+		default:
+			union.Recursive = setOperation(tokenizer.UnionKeyword, true, union.Recursive, node)
+		}
+	}
+	for _, column := range union.Initial.Columns() {
+		union.Output = append(union.Output, Column{Table: column.Table, Name: column.Name, Type: column.Type})
+	}
+	return union, nil
+}
+
+// from builds scan or derived table plan joined with outer row (if it is
+// not nil) and tables of JOIN clauses
+func (pb *planBuilder) from(slct *parser.SelectStatement, outer *OuterRow) (Node, error) {
+	var (
+		node Node
+		err  error
+	)
+	if slct.FromSubquery != nil {
+		if node, err = pb.selectStatement(slct.FromSubquery); err != nil {
+			return nil, err
+		}
+		node = rename(node, slct.From.Value)
+	} else if node, err = pb.scan(slct.From.Value); err != nil {
+		return nil, err
+	}
+	if outer != nil {
+		node = &Join{Kind: InnerJoin, Left: node, Right: outer}
+	}
+	for _, clause := range slct.Joins {
+		right, err := pb.scan(clause.Table.Value)
+		if err != nil {
+			return nil, err
+		}
+		join := &Join{Kind: InnerJoin, Left: node, Right: right, Condition: clause.On}
+		if err := check(join.Condition, join.Columns()); err != nil {
+			return nil, err
+		}
+		node = join
+	}
+	return node, nil
+}
+
+// outerRow builds outer row with columns of the enclosing query statement
+// refers to, it returns nil for statements which are not correlated
+func (pb *planBuilder) outerRow(slct *parser.SelectStatement) (*OuterRow, error) {
+	references := slct.OuterReferences()
+	if references == nil {
+		return nil, nil
+	}
+	outer := &OuterRow{Number: pb.number}
+	for _, reference := range references {
+		index, err := resolveColumn(pb.outer, reference)
+		if err != nil {
+			return nil, err
+		}
+		column := Column{Table: pb.outer[index].Table, Name: pb.outer[index].Name, Type: pb.outer[index].Type, Outer: true}
+		if !containsColumn(outer.Output, column) {
+			outer.Output = append(outer.Output, column)
+		}
+	}
+	return outer, nil
+}
+
+// scan builds scan of table or plan of common table expression with its name
+func (pb *planBuilder) scan(table string) (Node, error) {
+	if common, ok := pb.tables[table]; ok {
+		return pb.reference(common)
+	}
+	definition, ok := pb.schema[table]
+	if !ok {
+		return nil, fmt.Errorf("table %s does not exist", table)
+	}
+	scan := &Scan{Table: table}
+	for _, column := range definition.Cols {
+		scan.Output = append(scan.Output, Column{Table: table, Name: column.Name.Value, Type: column.Datatype.Value})
+	}
+	return scan, nil
+}
+
+// semiJoin joins node with uncorrelated subquery of x IN (SELECT y ...)
+// condition on x = y
+func (pb *planBuilder) semiJoin(node Node, condition *parser.Expression) (Node, error) {
+	subquery, err := pb.selectStatement(condition.Subquery)
+	if err != nil {
+		return nil, err
+	}
+	if len(subquery.Columns()) != 1 {
+		return nil, fmt.Errorf("subquery of IN must return exactly one column, got: %d", len(subquery.Columns()))
+	}
+	// Columns of x are resolved before column of subquery is added, since
+	// it may have the same name
+	left, err := qualify(condition.Left, node.Columns())
+	if err != nil {
+		return nil, err
+	}
+	pb.subqueries++
+	alias := fmt.Sprintf("$subquery%d", pb.subqueries)
+	subquery = rename(subquery, alias)
+	column := subquery.Columns()[0]
+	join := &Join{
+		Kind:  SemiJoin,
+		Left:  node,
+		Right: subquery,
+		Condition: &parser.Expression{
+			Kind:  parser.BinaryExpression,
+			Token: tokenizer.TokenFromSymbol("="),
+			Left:  left,
+			Right: &parser.Expression{
+				Kind:  parser.ColumnExpression,
+				Token: &tokenizer.Token{Value: column.Name, Kind: tokenizer.IdentifierKind},
+				Table: &tokenizer.Token{Value: alias, Kind: tokenizer.IdentifierKind},
+			},
+		},
+	}
+	if err := check(join.Condition, append(node.Columns(), column)); err != nil {
+		return nil, err
+	}
+	return join, nil
+}
+
+// decorrelate turns correlated EXISTS, NOT EXISTS or IN (SELECT ...)
+// condition into semi or anti join of node with tables of subquery on
+// conditions of its WHERE clause. It returns nil if subquery does more than
+// filtering rows of its tables or if its conditions can not be evaluated over
+// joined rows, such subqueries are evaluated for every row by applies
+func (pb *planBuilder) decorrelate(node Node, condition *parser.Expression) Node {
+	kind, expression := SemiJoin, condition
+	if condition.Kind == parser.UnaryExpression && condition.Left.Kind == parser.ExistsExpression {
+		kind, expression = AntiJoin, condition.Left
+	}
+	if expression.Kind != parser.ExistsExpression &&
+		(expression.Kind != parser.InExpression || expression.Subquery == nil || expression.Not) {
+		return nil
+	}
+	slct := expression.Subquery
+	references := slct.OuterReferences()
+	if references == nil || slct.FromSubquery != nil || slct.GroupBy != nil || slct.Limit != nil ||
+		slct.WindowGroups() != nil || subqueries(slct.Where) != nil {
+		return nil
+	}
+	for _, item := range slct.Item {
+		if appendAggregates(nil, item) != nil {
+			return nil
+		}
+	}
+	for _, term := range slct.OrderBy {
+		if appendAggregates(nil, term.Expression) != nil {
+			return nil
+		}
+	}
+	tables, err := pb.from(slct, nil)
+	if err != nil {
+		return nil
+	}
+	// Unqualified columns of conditions belong to tables of subquery
+	visible := append([]Column{}, tables.Columns()...)
+	for _, reference := range references {
+		index, err := resolveColumn(node.Columns(), reference)
+		if err != nil {
+			return nil
+		}
+		column := node.Columns()[index]
+		column.Outer = true
+		visible = append(visible, column)
+	}
+	var conditions []*parser.Expression
+	if expression.Kind == parser.InExpression {
+		if len(slct.Item) != 1 {
+			return nil
+		}
+		left, err := qualify(expression.Left, node.Columns())
+		if err != nil {
+			return nil
+		}
+		right, err := qualify(slct.Item[0], tables.Columns())
+		if err != nil {
+			return nil
+		}
+		conditions = append(conditions, &parser.Expression{
+			Kind:  parser.BinaryExpression,
+			Token: tokenizer.TokenFromSymbol("="),
+			Left:  left,
+			Right: right,
+		})
+	}
+	if slct.Where != nil {
+		where, err := qualify(slct.Where, visible)
+		if err != nil {
+			return nil
+		}
+		conditions = append(conditions, where)
+	}
+	join := &Join{Kind: kind, Left: node, Right: tables}
+	if conditions != nil {
+		join.Condition = conjunction(conditions)
+		if check(join.Condition, append(append([]Column{}, node.Columns()...), tables.Columns()...)) != nil {
+			return nil
+		}
+	}
+	return join
+}
+
+// applies adds applies evaluating subqueries used in expressions to node
+func (pb *planBuilder) applies(node Node, expressions ...*parser.Expression) (Node, error) {
+	for _, expression := range subqueries(expressions...) {
+		if computedColumn(node.Columns(), expression) != -1 {
+			continue
+		}
+		var err error
+		if node, err = pb.apply(node, expression); err != nil {
+			return nil, err
+		}
+	}
+	return node, nil
+}
+
+// apply plans subquery of expression evaluated for every row of node.
+// Columns of node are visible to subquery as columns of enclosing query
+func (pb *planBuilder) apply(node Node, expression *parser.Expression) (Node, error) {
+	pb.subqueries++
+	apply := &Apply{Input: node, Expression: expression, Number: pb.subqueries}
+	outer, number := pb.outer, pb.number
+	pb.outer, pb.number = node.Columns(), apply.Number
+	subquery, err := pb.selectStatement(expression.Subquery)
+	pb.outer, pb.number = outer, number
+	if err != nil {
+		return nil, err
+	}
+	apply.Subquery = subquery
+	apply.Output = Column{Name: "?column?", Type: boolType, Expression: expression}
+	switch expression.Kind {
+	case parser.SubqueryExpression:
+		if len(subquery.Columns()) != 1 {
+			return nil, fmt.Errorf("scalar subquery must return exactly one column, got: %d", len(subquery.Columns()))
+		}
+		apply.Output.Type = subquery.Columns()[0].Type
+	case parser.InExpression:
+		if len(subquery.Columns()) != 1 {
+			return nil, fmt.Errorf("subquery of IN must return exactly one column, got: %d", len(subquery.Columns()))
+		}
+		if err := check(expression.Left, node.Columns()); err != nil {
+			return nil, err
+		}
+	}
+	return apply, nil
+}
+
+func (pb *planBuilder) filter(node Node, condition *parser.Expression) (Node, error) {
+	if err := check(condition, node.Columns()); err != nil {
+		return nil, err
+	}
+	return &Filter{Input: node, Condition: condition}, nil
+}
+
+func (pb *planBuilder) aggregate(node Node, slct *parser.SelectStatement, groupBy []*parser.Expression,
+	aggregates []*parser.Expression) (Node, error) {
+	aggregate := &Aggregate{Input: node, GroupBy: groupBy, Aggregates: aggregates}
+	for _, expression := range groupBy {
+		if err := check(expression, node.Columns()); err != nil {
+			return nil, err
+		}
+		column, err := pb.column(node, slct, expression)
+		if err != nil {
+			return nil, err
+		}
+		aggregate.Output = append(aggregate.Output, column)
+	}
+	for _, expression := range aggregates {
+		if err := checkAggregate(expression, node.Columns()); err != nil {
+			return nil, err
+		}
+		column, err := pb.column(node, slct, expression)
+		if err != nil {
+			return nil, err
+		}
+		aggregate.Output = append(aggregate.Output, column)
+	}
+	return aggregate, nil
+}
+
+func (pb *planBuilder) window(node Node, slct *parser.SelectStatement, functions []*parser.Expression) (Node, error) {
+	window := &Window{Input: node, Functions: functions}
+	// Arguments of functions can refer to aggregates computed by input
+	expressions := append([]*parser.Expression{}, window.Specification().PartitionBy...)
+	for _, term := range window.Specification().OrderBy {
+		expressions = append(expressions, term.Expression)
+	}
+	for _, function := range functions {
+		for _, argument := range function.Arguments {
+			if !isAsterisk(argument) {
+				expressions = append(expressions, argument)
+			}
+		}
+	}
+	for _, expression := range expressions {
+		if err := check(expression, node.Columns()); err != nil {
+			return nil, err
+		}
+	}
+	for _, function := range functions {
+		column, err := pb.column(node, slct, function)
+		if err != nil {
+			return nil, err
+		}
+		window.Output = append(window.Output, column)
+	}
+	return window, nil
+}
+
+func (pb *planBuilder) sort(node Node, orderBy []*parser.OrderingTerm) (Node, error) {
+	for _, term := range orderBy {
+		if err := check(term.Expression, node.Columns()); err != nil {
+			return nil, err
+		}
+	}
+	return &Sort{Input: node, OrderBy: orderBy}, nil
+}
+
+func (pb *planBuilder) project(node Node, slct *parser.SelectStatement) (Node, error) {
+	project := &Project{Input: node, Expressions: slct.Item}
+	for _, expression := range slct.Item {
+		if err := check(expression, node.Columns()); err != nil {
+			return nil, err
+		}
+		column, err := pb.column(node, slct, expression)
+		if err != nil {
+			return nil, err
+		}
+		project.Output = append(project.Output, column)
+	}
+	return project, nil
+}
+
+// column describes column computed from expression over rows of node.
+// Columns keep names of the columns they are copied from
+func (pb *planBuilder) column(node Node, slct *parser.SelectStatement, expression *parser.Expression) (Column, error) {
+	column := Column{Name: "?column?", Expression: expression}
+	if expression.Kind == parser.ColumnExpression {
+		index, err := resolveColumn(node.Columns(), expression)
+		if err == nil {
+			source := node.Columns()[index]
+			column.Table, column.Name, column.Type = source.Table, source.Name, source.Type
+			return column, nil
+		}
+	}
+	if expression.Kind == parser.FunctionExpression {
+		column.Name = expression.Token.Value
+	}
+	if index := computedColumn(node.Columns(), expression); index != -1 {
+		column.Type = node.Columns()[index].Type
+		return column, nil
+	}
+	datatype, err := slct.ExpressionType(expression, pb.schema)
+	if err != nil {
+		return Column{}, err
+	}
+	column.Type = datatype.Value
+	return column, nil
+}
+
+// rename makes columns produced by node belong to table with given name, like
+// columns of derived table belong to its alias
+func rename(node Node, table string) Node {
+	var columns []Column
+	for _, column := range node.Columns() {
+		columns = append(columns, Column{Table: table, Name: column.Name, Type: column.Type})
+	}
+	return relabel(node, columns)
+}
+
+// relabel replaces columns produced by node with given ones, which have the
+// same types
+func relabel(node Node, columns []Column) Node {
+	// Columns of set operations are not computed by expressions, so they are
+	// replaced in place
+	switch typed := node.(type) {
+	case *SetOperation:
+		typed.Output = columns
+		return typed
+	case *RecursiveUnion:
+		typed.Output = columns
+		return typed
+	}
+	project := &Project{Input: node, Output: columns}
+	for _, column := range node.Columns() {
+		reference := &parser.Expression{
+			Kind:  parser.ColumnExpression,
+			Token: &tokenizer.Token{Value: column.Name, Kind: tokenizer.IdentifierKind},
+		}
+		if column.Table != "" {
+			reference.Table = &tokenizer.Token{Value: column.Table, Kind: tokenizer.IdentifierKind}
+		}
+		// Columns with the same names, like ones computed from expressions,
+		// are read by expressions computing them
+		if _, err := resolveColumn(node.Columns(), reference); err != nil && column.Expression != nil {
+			reference = column.Expression
+		}
+		project.Expressions = append(project.Expressions, reference)
+	}
+	return project
+}
+
+// qualify returns copy of expression with columns qualified by names of
+// tables of columns they resolve to
+func qualify(expression *parser.Expression, columns []Column) (*parser.Expression, error) {
+	if err := check(expression, columns); err != nil {
+		return nil, err
+	}
+	var err error
+	var walk func(expression *parser.Expression) *parser.Expression
+	walk = func(expression *parser.Expression) *parser.Expression {
+		if expression == nil || err != nil {
+			return expression
+		}
+		copied := *expression
+		if expression.Kind == parser.ColumnExpression && !isAsterisk(expression) {
+			var index int
+			if index, err = resolveColumn(columns, expression); err == nil && columns[index].Table != "" {
+				copied.Table = &tokenizer.Token{Value: columns[index].Table, Kind: tokenizer.IdentifierKind}
+			}
+			return &copied
+		}
+		copied.Left, copied.Right = walk(expression.Left), walk(expression.Right)
+		if expression.Arguments != nil {
+			copied.Arguments = make([]*parser.Expression, len(expression.Arguments))
+			for index, argument := range expression.Arguments {
+				copied.Arguments[index] = walk(argument)
+			}
+		}
+		return &copied
+	}
+	result := walk(expression)
+	return result, err
+}
+
+// check verifies that expression can be evaluated over rows with given
+// columns
+func check(expression *parser.Expression, columns []Column) error {
+	_, err := compile(expression, columns)
+	return err
+}
+
+// checkAggregate verifies that arguments of aggregate function can be
+// evaluated over rows with given columns
+func checkAggregate(expression *parser.Expression, columns []Column) error {
+	for _, argument := range expression.Arguments {
+		if isAsterisk(argument) {
+			continue
+		}
+		if appendAggregates(nil, argument) != nil {
+			return fmt.Errorf("aggregate function calls can not be nested: %s", formatExpression(expression))
+		}
+		if err := check(argument, columns); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// appendAggregates appends aggregate function calls used in expression to
+// list, skipping the ones already listed
+func appendAggregates(list []*parser.Expression, expression *parser.Expression) []*parser.Expression {
+	if expression == nil {
+		return list
+	}
+	if expression.Kind == parser.FunctionExpression && expression.Window == nil {
+		definition, err := function.Builtin.Lookup(expression.Token.Value)
+		if err == nil && definition.Kind == function.AggregateFunction {
+			for _, listed := range list {
+				if listed.Equals(expression) {
+					return list
+				}
+			}
+			return append(list, expression)
+		}
+	}
+	for _, child := range expression.Children() {
+		list = appendAggregates(list, child)
+	}
+	return list
+}
+
+// conjuncts splits condition into operands of top-level AND operators
+func conjuncts(condition *parser.Expression) []*parser.Expression {
+	if condition.Kind == parser.BinaryExpression && condition.Token.Value == tokenizer.AndKeyword {
+		return append(conjuncts(condition.Left), conjuncts(condition.Right)...)
+	}
+	return []*parser.Expression{condition}
+}
+
+// conjunction combines conditions with AND, it is reverse of conjuncts
+func conjunction(conditions []*parser.Expression) *parser.Expression {
+	result := conditions[0]
+	for _, condition := range conditions[1:] {
+		result = &parser.Expression{
+			Kind:  parser.BinaryExpression,
+			Token: tokenizer.TokenFromKeyword(tokenizer.AndKeyword),
+			Left:  result,
+			Right: condition,
+		}
+	}
+	return result
+}
@@ -0,0 +1,411 @@
+package planner
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/VorobevPavel-dev/congenial-disco/parser"
+)
+
+// Parallel plans run copies of a subplan in worker goroutines. Every copy
+// scans its own partition of tables, so rows of copies together are rows of
+// the subplan. Subplans consisting of scans, filters, projections and hash
+// joins probed by rows of the left input are run in parallel, other nodes
+// read rows of workers gathered by exchange operators. Plans limited by
+// memory budget are never run in parallel, because shared hash tables are
+// not spilled
+
+// parallel checks if node is executed by several workers
+func (l *lowering) parallel(node Node) bool {
+	return l.workers > 1 && l.budget == nil && l.parallelSafe(node)
+}
+
+// parallelSafe checks if rows of node are produced by copies of its subplan
+// reading partitions of tables
+func (l *lowering) parallelSafe(node Node) bool {
+	switch typed := node.(type) {
+	case *Scan:
+		_, ok := l.source.(PartitionedSource)
+		return ok
+	case *Filter:
+		return l.parallelSafe(typed.Input)
+	case *Project:
+		return l.parallelSafe(typed.Input)
+	case *Join:
+		if typed.Condition == nil {
+			return false
+		}
+		// Every row of the right input must be seen by every worker, so only
+		// hash joins with shared table are run in parallel
+		leftKeys, _, _ := equiJoinKeys(typed.Condition, typed.Left.Columns(), typed.Right.Columns())
+		return len(leftKeys) != 0 && l.parallelSafe(typed.Left)
+	}
+	return false
+}
+
+// partitionSource scans one partition of tables of source
+type partitionSource struct {
+	source                PartitionedSource
+	partition, partitions int
+}
+
+func (ps partitionSource) Scan(table string, columns []string) (Iterator, error) {
+	return ps.source.ScanPartition(table, columns, ps.partition, ps.partitions)
+}
+
+// workerLowerings returns lowerings of subplans of workers, each of them
+// reads its own partition of tables
+func (l *lowering) workerLowerings() []*lowering {
+	source := l.source.(PartitionedSource)
+	result := make([]*lowering, l.workers)
+	for index := range result {
+		result[index] = &lowering{
+			source:  partitionSource{source: source, partition: index, partitions: l.workers},
+			parent:  l,
+			context: l.context,
+		}
+	}
+	return result
+}
+
+// inputs lowers node to operators producing its rows together. It is one
+// operator unless node is executed by several workers
+func (l *lowering) inputs(node Node) ([]Operator, error) {
+	if !l.parallel(node) {
+		operator, err := l.lower(node)
+		if err != nil {
+			return nil, err
+		}
+		return []Operator{operator}, nil
+	}
+	var result []Operator
+	for _, worker := range l.workerLowerings() {
+		operator, err := worker.lower(node)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, operator)
+	}
+	return result, nil
+}
+
+// runParallel calls work for every index in its own goroutine, waits for all
+// of them and returns the first error
+func runParallel(count int, work func(index int) error) error {
+	errs := make([]error, count)
+	var group sync.WaitGroup
+	for index := 0; index < count; index++ {
+		group.Add(1)
+		go func(index int) {
+			defer group.Done()
+			errs[index] = work(index)
+		}(index)
+	}
+	group.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (l *lowering) gather(node Node) (Operator, error) {
+	inputs, err := l.inputs(node)
+	if err != nil {
+		return nil, err
+	}
+	return &gather{inputs: inputs}, nil
+}
+
+// gatherBatch is a number of rows passed by workers of gather at once
+const gatherBatch = 256
+
+// errGatherClosed stops workers of gather when it is closed before all rows
+// are read
+var errGatherClosed = errors.New("gather is closed")
+
+// gathered are rows sent by worker of gather. Worker sends empty rows with
+// error of its input when it finishes
+type gathered struct {
+	rows []Row
+	err  error
+}
+
+// gather is an exchange operator which runs its inputs in worker goroutines
+// and produces their rows in the order they arrive
+type gather struct {
+	inputs []Operator
+
+	received chan gathered
+	done     chan struct{}
+	group    sync.WaitGroup
+	running  int
+	rows     []Row
+}
+
+func (g *gather) Open() error {
+	if err := g.Close(); err != nil {
+		return err
+	}
+	received, done := make(chan gathered, len(g.inputs)), make(chan struct{})
+	g.received, g.done, g.running = received, done, len(g.inputs)
+	for _, input := range g.inputs {
+		g.group.Add(1)
+		go func(input Operator) {
+			defer g.group.Done()
+			send := func(message gathered) bool {
+				select {
+				case received <- message:
+					return true
+				case <-done:
+					return false
+				}
+			}
+			var rows []Row
+			err := each(input, func(row Row) error {
+				if rows = append(rows, row); len(rows) < gatherBatch {
+					return nil
+				}
+				if !send(gathered{rows: rows}) {
+					return errGatherClosed
+				}
+				rows = nil
+				return nil
+			})
+			if err == nil && len(rows) != 0 && !send(gathered{rows: rows}) {
+				return
+			}
+			send(gathered{err: err})
+		}(input)
+	}
+	return nil
+}
+
+func (g *gather) Next() (Row, error) {
+	for len(g.rows) == 0 {
+		if g.running == 0 {
+			return nil, nil
+		}
+		message := <-g.received
+		if message.rows == nil {
+			g.running--
+			if message.err != nil {
+				return nil, message.err
+			}
+		}
+		g.rows = message.rows
+	}
+	row := g.rows[0]
+	g.rows = g.rows[1:]
+	return row, nil
+}
+
+// Close stops workers which are still running and waits for them
+func (g *gather) Close() error {
+	if g.done != nil {
+		close(g.done)
+		g.group.Wait()
+	}
+	g.received, g.done, g.running, g.rows = nil, nil, 0, nil
+	return nil
+}
+
+// sharedTable is a hash table of the right input of join. It is built once
+// by several workers reading partitions of the input at once and probed by
+// all workers of the left input
+type sharedTable struct {
+	inputs []Operator
+	// keys are evaluators of keys of every input
+	keys [][]evaluator
+
+	once  sync.Once
+	table map[string][]Row
+	err   error
+}
+
+// sharedTable returns table of join creating it on the first use
+func (l *lowering) sharedTable(join *Join, rightKeys []*parser.Expression) (*sharedTable, error) {
+	if table, ok := l.tables[join]; ok {
+		return table, nil
+	}
+	inputs, err := l.inputs(join.Right)
+	if err != nil {
+		return nil, err
+	}
+	table := &sharedTable{inputs: inputs}
+	for range inputs {
+		keys, err := compileAll(rightKeys, join.Right.Columns())
+		if err != nil {
+			return nil, err
+		}
+		table.keys = append(table.keys, keys)
+	}
+	if l.tables == nil {
+		l.tables = map[*Join]*sharedTable{}
+	}
+	l.tables[join] = table
+	return table, nil
+}
+
+// build builds table on the first call and returns it. Every input fills its
+// own table, then they are merged in order of inputs
+func (st *sharedTable) build() (map[string][]Row, error) {
+	st.once.Do(func() {
+		tables := make([]map[string][]Row, len(st.inputs))
+		st.err = runParallel(len(st.inputs), func(index int) error {
+			table := map[string][]Row{}
+			tables[index] = table
+			return each(st.inputs[index], func(row Row) error {
+				encoded, ok, err := joinKey(st.keys[index], row)
+				if err != nil || !ok {
+					return err
+				}
+				table[encoded] = append(table[encoded], row)
+				return nil
+			})
+		})
+		if st.err != nil {
+			return
+		}
+		st.table = tables[0]
+		for _, table := range tables[1:] {
+			for encoded, rows := range table {
+				st.table[encoded] = append(st.table[encoded], rows...)
+			}
+		}
+	})
+	return st.table, st.err
+}
+
+// parallelAggregate aggregates rows of every worker to partial groups in
+// worker goroutines. Partial groups with the same key are merged into final
+// groups which are produced in order of workers and of groups of every
+// worker
+type parallelAggregate struct {
+	inputs     []Operator
+	aggregates []*parser.Expression
+	// keys and arguments are evaluators of every input
+	keys, arguments [][]evaluator
+
+	groups []Row
+	next   int
+}
+
+// partialGroup is a group of rows of one worker
+type partialGroup struct {
+	encoded      string
+	key          Row
+	accumulators []accumulator
+}
+
+func (l *lowering) parallelAggregate(aggregate *Aggregate) (Operator, error) {
+	inputs, err := l.inputs(aggregate.Input)
+	if err != nil {
+		return nil, err
+	}
+	operator := &parallelAggregate{inputs: inputs, aggregates: aggregate.Aggregates}
+	for range inputs {
+		keys, arguments, err := compileAggregate(aggregate)
+		if err != nil {
+			return nil, err
+		}
+		operator.keys = append(operator.keys, keys)
+		operator.arguments = append(operator.arguments, arguments)
+	}
+	return operator, nil
+}
+
+func (pa *parallelAggregate) newGroup(encoded string, key Row) *partialGroup {
+	current := &partialGroup{encoded: encoded, key: key}
+	for _, expression := range pa.aggregates {
+		accumulator, _ := newAccumulator(expression.Token.Value)
+		current.accumulators = append(current.accumulators, accumulator)
+	}
+	return current
+}
+
+// partial aggregates rows of input to groups in the order they were first
+// seen
+func (pa *parallelAggregate) partial(index int) ([]*partialGroup, error) {
+	var groups []*partialGroup
+	indexes := map[string]*partialGroup{}
+	err := each(pa.inputs[index], func(row Row) error {
+		key, err := evaluateAll(pa.keys[index], row)
+		if err != nil {
+			return err
+		}
+		encoded := encodeKey(key)
+		current, ok := indexes[encoded]
+		if !ok {
+			current = pa.newGroup(encoded, key)
+			indexes[encoded] = current
+			groups = append(groups, current)
+		}
+		for position, argument := range pa.arguments[index] {
+			value, err := argument(row)
+			if err != nil {
+				return err
+			}
+			if err := current.accumulators[position].add(value); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return groups, err
+}
+
+func (pa *parallelAggregate) Open() error {
+	pa.groups, pa.next = nil, 0
+	partials := make([][]*partialGroup, len(pa.inputs))
+	err := runParallel(len(pa.inputs), func(index int) (err error) {
+		partials[index], err = pa.partial(index)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	var groups []*partialGroup
+	indexes := map[string]*partialGroup{}
+	for _, partial := range partials {
+		for _, current := range partial {
+			final, ok := indexes[current.encoded]
+			if !ok {
+				indexes[current.encoded] = current
+				groups = append(groups, current)
+				continue
+			}
+			for position, accumulator := range final.accumulators {
+				if err := accumulator.merge(current.accumulators[position]); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	// Aggregation without grouping produces a row even for empty input
+	if len(pa.keys[0]) == 0 && len(groups) == 0 {
+		groups = append(groups, pa.newGroup("", nil))
+	}
+	for _, current := range groups {
+		row := append(Row{}, current.key...)
+		for _, accumulator := range current.accumulators {
+			row = append(row, accumulator.result())
+		}
+		pa.groups = append(pa.groups, row)
+	}
+	return nil
+}
+
+func (pa *parallelAggregate) Next() (Row, error) {
+	if pa.next >= len(pa.groups) {
+		return nil, nil
+	}
+	pa.next++
+	return pa.groups[pa.next-1], nil
+}
+
+func (pa *parallelAggregate) Close() error {
+	pa.groups, pa.next = nil, 0
+	return nil
+}
@@ -0,0 +1,978 @@
+package planner
+
+import (
+	"container/heap"
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/VorobevPavel-dev/congenial-disco/parser"
+)
+
+// Operator is a node of physical plan. Operators are pulled: Next returns the
+// next row produced by operator or nil row after the last one
+type Operator interface {
+	Open() error
+	Next() (Row, error)
+	Close() error
+}
+
+// Lower converts logical plan to operators reading tables from source
+func Lower(node Node, source Source) (Operator, error) {
+	return (&lowering{source: source}).lower(node)
+}
+
+// lowering converts nodes of logical plan to operators
+type lowering struct {
+	source Source
+	// instrument wraps every operator to collect its statistics
+	instrument bool
+	// operators maps nodes to operators created for them if it is not nil
+	operators map[Node]Operator
+	// vectorized makes operators process batches of rows where possible
+	vectorized bool
+	// budget limits memory of operators, it is nil if memory is not limited
+	budget *budget
+	// workers is a number of goroutines executing parallel parts of plan
+	workers int
+	// parent is a lowering which created this one for a worker, tables of
+	// hash joins built for all workers are kept by parent
+	parent *lowering
+	tables map[*Join]*sharedTable
+	// context stops operators when query is canceled, it is nil for queries
+	// which are never canceled
+	context context.Context
+	// working are working tables of recursive unions being lowered by
+	// names of their common table expressions
+	working map[string]*workingTable
+	// maxRecursion limits iterations of recursive unions, zero means
+	// defaultMaxRecursion
+	maxRecursion int
+	// outer are rows of applies being lowered by their numbers
+	outer map[int]*outerRow
+}
+
+func (l *lowering) lower(node Node) (Operator, error) {
+	if l.vectorized && !l.parallel(node) && l.isVectorized(node) {
+		batches, err := l.batches(node)
+		if err != nil {
+			return nil, err
+		}
+		return l.cancellable(&batchRows{input: batches}), nil
+	}
+	operator, err := l.operator(node)
+	if err != nil {
+		return nil, err
+	}
+	if l.instrument {
+		operator = &instrumented{Operator: operator}
+	}
+	if l.operators != nil {
+		l.operators[node] = operator
+	}
+	return l.cancellable(operator), nil
+}
+
+func (l *lowering) operator(node Node) (Operator, error) {
+	if l.parallel(node) {
+		return l.gather(node)
+	}
+	switch typed := node.(type) {
+	case *Scan:
+		return &scanOperator{source: l.source, table: typed.Table, columns: scanColumns(typed)}, nil
+	case *Filter:
+		input, err := l.lower(typed.Input)
+		if err != nil {
+			return nil, err
+		}
+		condition, err := compile(typed.Condition, typed.Input.Columns())
+		if err != nil {
+			return nil, err
+		}
+		return &filterOperator{input: input, condition: condition}, nil
+	case *Project:
+		input, err := l.lower(typed.Input)
+		if err != nil {
+			return nil, err
+		}
+		expressions, err := compileAll(typed.Expressions, typed.Input.Columns())
+		if err != nil {
+			return nil, err
+		}
+		return &projectOperator{input: input, expressions: expressions}, nil
+	case *Join:
+		return l.join(typed)
+	case *Aggregate:
+		return l.aggregate(typed)
+	case *Window:
+		return l.window(typed)
+	case *Sort:
+		input, err := l.lower(typed.Input)
+		if err != nil {
+			return nil, err
+		}
+		sorter := &sortOperator{input: input, budget: l.budget}
+		for _, term := range typed.OrderBy {
+			key, err := compile(term.Expression, typed.Input.Columns())
+			if err != nil {
+				return nil, err
+			}
+			sorter.keys = append(sorter.keys, key)
+			sorter.descending = append(sorter.descending, term.Descending)
+		}
+		return sorter, nil
+	case *Limit:
+		input, err := l.lower(typed.Input)
+		if err != nil {
+			return nil, err
+		}
+		return &limitOperator{input: input, count: typed.Count, offset: typed.Offset}, nil
+	case *SetOperation:
+		return l.setOperation(typed)
+	case *RecursiveUnion:
+		return l.recursiveUnion(typed)
+	case *WorkingTable:
+		table, ok := l.working[typed.Name]
+		if !ok {
+			return nil, fmt.Errorf("working table %s is read outside of its recursive union", typed.Name)
+		}
+		return &workingTableScan{table: table}, nil
+	case *Apply:
+		return l.apply(typed)
+	case *OuterRow:
+		return l.outerRowScan(typed)
+	}
+	return nil, fmt.Errorf("unsupported plan node %s", node)
+}
+
+// Execute lowers plan, runs it and returns all rows it produced
+func Execute(node Node, source Source) ([]Row, error) {
+	operator, err := Lower(node, source)
+	if err != nil {
+		return nil, err
+	}
+	return collect(operator)
+}
+
+// collect opens operator and reads all its rows
+func collect(operator Operator) (rows []Row, err error) {
+	err = each(operator, func(row Row) error {
+		rows = append(rows, row)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
+
+// each opens operator, passes its rows to consume one by one and closes it.
+// Operator is closed even if it fails to open, so that rows it spilled
+// before failing are removed
+func each(operator Operator, consume func(Row) error) (err error) {
+	if err := operator.Open(); err != nil {
+		operator.Close()
+		return err
+	}
+	defer func() {
+		if closeErr := operator.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+	for {
+		row, err := operator.Next()
+		if err != nil || row == nil {
+			return err
+		}
+		if err := consume(row); err != nil {
+			return err
+		}
+	}
+}
+
+// scanColumns returns names of columns read by scan
+func scanColumns(scan *Scan) []string {
+	columns := make([]string, len(scan.Output))
+	for index, column := range scan.Output {
+		columns[index] = column.Name
+	}
+	return columns
+}
+
+type scanOperator struct {
+	source   Source
+	table    string
+	columns  []string
+	iterator Iterator
+}
+
+func (so *scanOperator) Open() (err error) {
+	so.iterator, err = so.source.Scan(so.table, so.columns)
+	return err
+}
+
+func (so *scanOperator) Next() (Row, error) { return so.iterator.Next() }
+
+func (so *scanOperator) Close() error {
+	if so.iterator == nil {
+		return nil
+	}
+	return so.iterator.Close()
+}
+
+type filterOperator struct {
+	input     Operator
+	condition evaluator
+}
+
+func (fo *filterOperator) Open() error  { return fo.input.Open() }
+func (fo *filterOperator) Close() error { return fo.input.Close() }
+
+func (fo *filterOperator) Next() (Row, error) {
+	for {
+		row, err := fo.input.Next()
+		if err != nil || row == nil {
+			return nil, err
+		}
+		value, err := fo.condition(row)
+		if err != nil {
+			return nil, err
+		}
+		if value == true {
+			return row, nil
+		}
+	}
+}
+
+type projectOperator struct {
+	input       Operator
+	expressions []evaluator
+}
+
+func (po *projectOperator) Open() error  { return po.input.Open() }
+func (po *projectOperator) Close() error { return po.input.Close() }
+
+func (po *projectOperator) Next() (Row, error) {
+	row, err := po.input.Next()
+	if err != nil || row == nil {
+		return nil, err
+	}
+	return evaluateAll(po.expressions, row)
+}
+
+// nestedLoopJoin reads all rows of the right input on Open and compares every
+// row of the left input with each of them
+type nestedLoopJoin struct {
+	left, right Operator
+	condition   evaluator
+	kind        JoinKind
+
+	inner   []Row
+	current Row
+	next    int
+}
+
+func (nlj *nestedLoopJoin) Open() (err error) {
+	if nlj.inner, err = collect(nlj.right); err != nil {
+		return err
+	}
+	nlj.current, nlj.next = nil, 0
+	return nlj.left.Open()
+}
+
+func (nlj *nestedLoopJoin) Close() error { return nlj.left.Close() }
+
+func (nlj *nestedLoopJoin) Next() (Row, error) {
+	for {
+		if nlj.current == nil || nlj.next >= len(nlj.inner) {
+			row, err := nlj.left.Next()
+			if err != nil || row == nil {
+				return nil, err
+			}
+			nlj.current, nlj.next = row, 0
+		}
+		for nlj.next < len(nlj.inner) {
+			combined := append(append(Row{}, nlj.current...), nlj.inner[nlj.next]...)
+			nlj.next++
+			if nlj.condition != nil {
+				value, err := nlj.condition(combined)
+				if err != nil {
+					return nil, err
+				}
+				if value != true {
+					continue
+				}
+			}
+			switch nlj.kind {
+			case SemiJoin:
+				// The rest of inner rows are skipped, so that left row is
+				// produced only once
+				nlj.next = len(nlj.inner)
+				return nlj.current, nil
+			case AntiJoin:
+				// Matching left row is skipped
+				nlj.next, nlj.current = len(nlj.inner), nil
+				continue
+			}
+			return combined, nil
+		}
+		if nlj.kind == AntiJoin && nlj.current != nil {
+			row := nlj.current
+			nlj.current = nil
+			return row, nil
+		}
+	}
+}
+
+// accumulator computes aggregate function over values of a group. Partial
+// results of the same function computed over parts of a group are combined
+// with merge
+type accumulator interface {
+	add(value interface{}) error
+	merge(other accumulator) error
+	result() interface{}
+}
+
+type countAccumulator struct {
+	count int
+}
+
+func (ca *countAccumulator) add(value interface{}) error {
+	if value != nil {
+		ca.count++
+	}
+	return nil
+}
+
+func (ca *countAccumulator) merge(other accumulator) error {
+	ca.count += other.(*countAccumulator).count
+	return nil
+}
+
+func (ca *countAccumulator) result() interface{} { return ca.count }
+
+type sumAccumulator struct {
+	sum, count int
+	average    bool
+}
+
+func (sa *sumAccumulator) add(value interface{}) error {
+	if value == nil {
+		return nil
+	}
+	number, ok := value.(int)
+	if !ok {
+		return fmt.Errorf("cannot sum %v (%T)", value, value)
+	}
+	sa.sum += number
+	sa.count++
+	return nil
+}
+
+func (sa *sumAccumulator) merge(other accumulator) error {
+	partial := other.(*sumAccumulator)
+	sa.sum += partial.sum
+	sa.count += partial.count
+	return nil
+}
+
+func (sa *sumAccumulator) result() interface{} {
+	switch {
+	case sa.count == 0:
+		return nil
+	case sa.average:
+		return sa.sum / sa.count
+	}
+	return sa.sum
+}
+
+type extremeAccumulator struct {
+	value   interface{}
+	maximum bool
+}
+
+func (ea *extremeAccumulator) add(value interface{}) error {
+	if value == nil {
+		return nil
+	}
+	if ea.value == nil {
+		ea.value = value
+		return nil
+	}
+	order, err := compareValues(value, ea.value)
+	if err != nil {
+		return err
+	}
+	if (order > 0) == ea.maximum && order != 0 {
+		ea.value = value
+	}
+	return nil
+}
+
+func (ea *extremeAccumulator) merge(other accumulator) error {
+	return ea.add(other.(*extremeAccumulator).value)
+}
+
+func (ea *extremeAccumulator) result() interface{} { return ea.value }
+
+func newAccumulator(name string) (accumulator, error) {
+	switch name {
+	case "count":
+		return &countAccumulator{}, nil
+	case "sum":
+		return &sumAccumulator{}, nil
+	case "avg":
+		return &sumAccumulator{average: true}, nil
+	case "min":
+		return &extremeAccumulator{}, nil
+	case "max":
+		return &extremeAccumulator{maximum: true}, nil
+	}
+	return nil, fmt.Errorf("aggregate function %s is not supported", name)
+}
+
+// hashAggregate reads all input rows on Open, groups them by values of keys
+// and produces groups in the order they were first seen. When groups do not
+// fit into memory budget, rows of new groups are spilled to partitions which
+// are aggregated after groups kept in memory are produced
+type hashAggregate struct {
+	input      Operator
+	keys       []evaluator
+	aggregates []*parser.Expression
+	arguments  []evaluator
+	budget     *budget
+
+	groups   []Row
+	next     int
+	reserved int64
+	// pending are partitions of spilled rows, they consist of values of keys
+	// followed by values of arguments of aggregates
+	pending []spilledPartition
+}
+
+// spilledPartition is a partition of rows spilled at given depth
+type spilledPartition struct {
+	reader *spillReader
+	depth  int
+}
+
+// groupSize estimates memory taken by group besides values of its key
+const groupSize = 64
+
+func (l *lowering) aggregate(aggregate *Aggregate) (Operator, error) {
+	if l.parallel(aggregate.Input) {
+		return l.parallelAggregate(aggregate)
+	}
+	input, err := l.lower(aggregate.Input)
+	if err != nil {
+		return nil, err
+	}
+	operator := &hashAggregate{input: input, aggregates: aggregate.Aggregates, budget: l.budget}
+	if operator.keys, operator.arguments, err = compileAggregate(aggregate); err != nil {
+		return nil, err
+	}
+	return operator, nil
+}
+
+// compileAggregate compiles keys of groups and arguments of aggregate
+// functions of aggregation
+func compileAggregate(aggregate *Aggregate) (keys, arguments []evaluator, err error) {
+	columns := aggregate.Input.Columns()
+	if keys, err = compileAll(aggregate.GroupBy, columns); err != nil {
+		return nil, nil, err
+	}
+	for _, expression := range aggregate.Aggregates {
+		if _, err := newAccumulator(expression.Token.Value); err != nil {
+			return nil, nil, err
+		}
+		if len(expression.Arguments) != 1 {
+			return nil, nil, fmt.Errorf("aggregate function %s must have exactly one argument", formatExpression(expression))
+		}
+		// count(*) counts all rows, so its argument is never NULL
+		argument := evaluator(func(Row) (interface{}, error) { return true, nil })
+		if !isAsterisk(expression.Arguments[0]) {
+			if argument, err = compile(expression.Arguments[0], columns); err != nil {
+				return nil, nil, err
+			}
+		}
+		arguments = append(arguments, argument)
+	}
+	return keys, arguments, nil
+}
+
+func (ha *hashAggregate) Open() error {
+	if err := ha.Close(); err != nil {
+		return err
+	}
+	ha.groups, ha.next = nil, 0
+	return ha.aggregate(func(consume func(Row) error) error {
+		return each(ha.input, func(row Row) error {
+			prepared, err := evaluateAll(ha.keys, row)
+			if err != nil {
+				return err
+			}
+			for _, argument := range ha.arguments {
+				value, err := argument(row)
+				if err != nil {
+					return err
+				}
+				prepared = append(prepared, value)
+			}
+			return consume(prepared)
+		})
+	}, 0)
+}
+
+// aggregate groups rows consisting of values of keys followed by values of
+// arguments. Rows produced by produce function at the given depth of
+// spilling, rows of groups which do not fit into memory are spilled to
+// partitions of the next depth
+func (ha *hashAggregate) aggregate(produce func(consume func(Row) error) error, depth int) error {
+	type group struct {
+		key          Row
+		accumulators []accumulator
+	}
+	newGroup := func(key Row) *group {
+		current := &group{key: key}
+		for _, expression := range ha.aggregates {
+			accumulator, _ := newAccumulator(expression.Token.Value)
+			current.accumulators = append(current.accumulators, accumulator)
+		}
+		return current
+	}
+	var groups []*group
+	indexes := map[string]*group{}
+	var spilled *partitions
+	keys := len(ha.keys)
+	err := produce(func(row Row) error {
+		encoded := encodeKey(row[:keys])
+		current, ok := indexes[encoded]
+		if !ok {
+			size := rowSize(row[:keys]) + groupSize*int64(len(ha.aggregates))
+			switch {
+			case spilled != nil:
+				return spilled.write(encoded, row)
+			case ha.budget.reserve(size):
+			// Aggregation without grouping has a single group, which is
+			// never spilled
+			case depth < maxSpillDepth && keys != 0:
+				var err error
+				if spilled, err = newPartitions(ha.budget, depth); err != nil {
+					return err
+				}
+				return spilled.write(encoded, row)
+			default:
+				ha.budget.take(size)
+			}
+			ha.reserved += size
+			current = newGroup(append(Row{}, row[:keys]...))
+			indexes[encoded] = current
+			groups = append(groups, current)
+		}
+		for index, accumulator := range current.accumulators {
+			if err := accumulator.add(row[keys+index]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		if spilled != nil {
+			spilled.discard()
+		}
+		return err
+	}
+	// Aggregation without grouping produces a row even for empty input
+	if keys == 0 && len(groups) == 0 {
+		groups = append(groups, newGroup(nil))
+	}
+	for _, current := range groups {
+		row := append(Row{}, current.key...)
+		for _, accumulator := range current.accumulators {
+			row = append(row, accumulator.result())
+		}
+		ha.groups = append(ha.groups, row)
+	}
+	if spilled != nil {
+		readers, err := spilled.readers()
+		if err != nil {
+			return err
+		}
+		for _, reader := range readers {
+			ha.pending = append(ha.pending, spilledPartition{reader: reader, depth: depth + 1})
+		}
+	}
+	return nil
+}
+
+func (ha *hashAggregate) Next() (Row, error) {
+	for ha.next >= len(ha.groups) {
+		if len(ha.pending) == 0 {
+			return nil, nil
+		}
+		partition := ha.pending[0]
+		ha.pending = ha.pending[1:]
+		ha.budget.release(ha.reserved)
+		ha.reserved, ha.groups, ha.next = 0, nil, 0
+		err := ha.aggregate(func(consume func(Row) error) error {
+			return readRows(partition.reader, consume)
+		}, partition.depth)
+		if err != nil {
+			return nil, err
+		}
+	}
+	ha.next++
+	return ha.groups[ha.next-1], nil
+}
+
+func (ha *hashAggregate) Close() error {
+	var result error
+	for _, partition := range ha.pending {
+		if err := partition.reader.close(); err != nil && result == nil {
+			result = err
+		}
+	}
+	ha.pending = nil
+	ha.budget.release(ha.reserved)
+	ha.reserved = 0
+	return result
+}
+
+// sortOperator reads all input rows on Open and sorts them. When rows do not
+// fit into memory budget, sorted runs of rows are written to files and
+// merged
+type sortOperator struct {
+	input      Operator
+	keys       []evaluator
+	descending []bool
+	budget     *budget
+
+	rows     []sortEntry
+	next     int
+	reserved int64
+	runs     []*spillReader
+	// merge is set when some rows were spilled to runs
+	merge *mergeHeap
+}
+
+type sortEntry struct {
+	key []interface{}
+	row Row
+}
+
+// compare compares keys of rows taking order of sorting into account
+func (so *sortOperator) compare(first []interface{}, second []interface{}) (int, error) {
+	for index, descending := range so.descending {
+		comparison, err := compareNullable(first[index], second[index])
+		if err != nil {
+			return 0, err
+		}
+		if comparison != 0 {
+			if descending {
+				return -comparison, nil
+			}
+			return comparison, nil
+		}
+	}
+	return 0, nil
+}
+
+func (so *sortOperator) sort(entries []sortEntry) error {
+	var sortErr error
+	sort.SliceStable(entries, func(i, j int) bool {
+		comparison, err := so.compare(entries[i].key, entries[j].key)
+		if err != nil {
+			sortErr = err
+		}
+		return comparison < 0
+	})
+	return sortErr
+}
+
+// Open reads and sorts all input rows. Runs spilled before an error are
+// removed, since parents may not close operators which failed to open
+func (so *sortOperator) Open() error {
+	if err := so.Close(); err != nil {
+		return err
+	}
+	if err := so.open(); err != nil {
+		so.Close()
+		return err
+	}
+	return nil
+}
+
+func (so *sortOperator) open() error {
+	var entries []sortEntry
+	err := each(so.input, func(row Row) error {
+		key, err := evaluateAll(so.keys, row)
+		if err != nil {
+			return err
+		}
+		size := rowSize(row) + rowSize(key)
+		if !so.budget.reserve(size) {
+			if len(entries) != 0 {
+				if err := so.spill(entries); err != nil {
+					return err
+				}
+				entries = nil
+			}
+			// Row is kept even if it does not fit into memory alone
+			if !so.budget.reserve(size) {
+				so.budget.take(size)
+			}
+		}
+		so.reserved += size
+		entries = append(entries, sortEntry{key: key, row: row})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if err := so.sort(entries); err != nil {
+		return err
+	}
+	so.rows, so.next = entries, 0
+	if len(so.runs) == 0 {
+		return nil
+	}
+	// Rows kept in memory are the last run, ties are resolved in favour of
+	// earlier runs, so that sort stays stable
+	so.merge = &mergeHeap{compare: so.compare}
+	for order, run := range so.runs {
+		if err := so.merge.add(so.runSource(order, run)); err != nil {
+			return err
+		}
+	}
+	memory := &mergeSource{order: len(so.runs), read: func() (sortEntry, bool, error) {
+		if so.next >= len(so.rows) {
+			return sortEntry{}, false, nil
+		}
+		so.next++
+		return so.rows[so.next-1], true, nil
+	}}
+	return so.merge.add(memory)
+}
+
+// spill sorts entries and writes them to a new run. Memory reserved for
+// entries is released
+func (so *sortOperator) spill(entries []sortEntry) error {
+	if err := so.sort(entries); err != nil {
+		return err
+	}
+	writer, err := newSpillWriter(so.budget)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if err := writer.write(append(append(Row{}, entry.key...), entry.row...)); err != nil {
+			writer.discard()
+			return err
+		}
+	}
+	run, err := writer.reader()
+	if err != nil {
+		return err
+	}
+	so.runs = append(so.runs, run)
+	so.budget.release(so.reserved)
+	so.reserved = 0
+	if len(so.runs) < maxMergedRuns {
+		return nil
+	}
+	return so.compact()
+}
+
+// maxMergedRuns limits number of runs merged at once, so that number of open
+// files stays small
+const maxMergedRuns = 64
+
+// compact merges all runs into a single one
+func (so *sortOperator) compact() error {
+	merge := &mergeHeap{compare: so.compare}
+	for order, run := range so.runs {
+		if err := merge.add(so.runSource(order, run)); err != nil {
+			return err
+		}
+	}
+	writer, err := newSpillWriter(so.budget)
+	if err != nil {
+		return err
+	}
+	for merge.Len() != 0 {
+		key := merge.sources[0].current.key
+		row, err := merge.pop()
+		if err == nil {
+			err = writer.write(append(append(Row{}, key...), row...))
+		}
+		if err != nil {
+			writer.discard()
+			return err
+		}
+	}
+	run, err := writer.reader()
+	if err != nil {
+		return err
+	}
+	for _, merged := range so.runs {
+		if err := merged.close(); err != nil {
+			run.close()
+			return err
+		}
+	}
+	so.runs = []*spillReader{run}
+	return nil
+}
+
+// runSource returns merge source reading rows of run
+func (so *sortOperator) runSource(order int, run *spillReader) *mergeSource {
+	return &mergeSource{order: order, read: func() (sortEntry, bool, error) {
+		row, err := run.read()
+		if err != nil || row == nil {
+			return sortEntry{}, false, err
+		}
+		return sortEntry{key: row[:len(so.keys)], row: row[len(so.keys):]}, true, nil
+	}}
+}
+
+func (so *sortOperator) Next() (Row, error) {
+	if so.merge != nil {
+		return so.merge.pop()
+	}
+	if so.next >= len(so.rows) {
+		return nil, nil
+	}
+	so.next++
+	return so.rows[so.next-1].row, nil
+}
+
+func (so *sortOperator) Close() error {
+	var result error
+	for _, run := range so.runs {
+		if err := run.close(); err != nil && result == nil {
+			result = err
+		}
+	}
+	so.runs, so.merge, so.rows = nil, nil, nil
+	so.budget.release(so.reserved)
+	so.reserved = 0
+	return result
+}
+
+// mergeSource is a sorted sequence of rows merged by mergeHeap
+type mergeSource struct {
+	current sortEntry
+	order   int
+	read    func() (sortEntry, bool, error)
+}
+
+// mergeHeap merges sorted sequences of rows, it keeps sequences ordered by
+// their current rows
+type mergeHeap struct {
+	sources []*mergeSource
+	compare func([]interface{}, []interface{}) (int, error)
+	err     error
+}
+
+func (mh *mergeHeap) Len() int      { return len(mh.sources) }
+func (mh *mergeHeap) Swap(i, j int) { mh.sources[i], mh.sources[j] = mh.sources[j], mh.sources[i] }
+
+func (mh *mergeHeap) Less(i, j int) bool {
+	comparison, err := mh.compare(mh.sources[i].current.key, mh.sources[j].current.key)
+	if err != nil {
+		mh.err = err
+	}
+	if comparison != 0 {
+		return comparison < 0
+	}
+	return mh.sources[i].order < mh.sources[j].order
+}
+
+func (mh *mergeHeap) Push(source interface{}) { mh.sources = append(mh.sources, source.(*mergeSource)) }
+
+func (mh *mergeHeap) Pop() interface{} {
+	last := mh.sources[len(mh.sources)-1]
+	mh.sources = mh.sources[:len(mh.sources)-1]
+	return last
+}
+
+// add reads the first row of source and adds source to heap unless it is
+// empty
+func (mh *mergeHeap) add(source *mergeSource) error {
+	entry, ok, err := source.read()
+	if err != nil || !ok {
+		return err
+	}
+	source.current = entry
+	heap.Push(mh, source)
+	return mh.err
+}
+
+// pop returns the smallest row of all sequences or nil if they are over
+func (mh *mergeHeap) pop() (Row, error) {
+	if len(mh.sources) == 0 {
+		return nil, nil
+	}
+	top := mh.sources[0]
+	result := top.current.row
+	entry, ok, err := top.read()
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		top.current = entry
+		heap.Fix(mh, 0)
+	} else {
+		heap.Pop(mh)
+	}
+	return result, mh.err
+}
+
+// compareNullable compares values treating NULL as greater than any other
+// value
+func compareNullable(first interface{}, second interface{}) (int, error) {
+	switch {
+	case first == nil && second == nil:
+		return 0, nil
+	case first == nil:
+		return 1, nil
+	case second == nil:
+		return -1, nil
+	}
+	return compareValues(first, second)
+}
+
+type limitOperator struct {
+	input         Operator
+	count, offset int
+
+	produced int
+}
+
+func (lo *limitOperator) Open() error {
+	lo.produced = 0
+	if err := lo.input.Open(); err != nil {
+		return err
+	}
+	for skipped := 0; skipped < lo.offset; skipped++ {
+		row, err := lo.input.Next()
+		if err != nil || row == nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (lo *limitOperator) Close() error { return lo.input.Close() }
+
+func (lo *limitOperator) Next() (Row, error) {
+	if lo.count >= 0 && lo.produced >= lo.count {
+		return nil, nil
+	}
+	row, err := lo.input.Next()
+	if err != nil || row == nil {
+		return nil, err
+	}
+	lo.produced++
+	return row, nil
+}
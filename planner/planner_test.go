@@ -0,0 +1,1309 @@
+package planner
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"os"
+	"reflect"
+	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/VorobevPavel-dev/congenial-disco/columnar"
+	"github.com/VorobevPavel-dev/congenial-disco/engine"
+	"github.com/VorobevPavel-dev/congenial-disco/parser"
+	"github.com/VorobevPavel-dev/congenial-disco/statistics"
+)
+
+func testSchema(t testing.TB) parser.Schema {
+	t.Helper()
+	var tables []*parser.CreateTableStatement
+	for _, request := range []string{
+		"create table users (id int, name text, city int);",
+		"create table cities (id int, title text);",
+		"create table orders (id int, user int, amount int);",
+	} {
+		statement, err := parser.Parse(request)
+		if err != nil {
+			t.Fatalf("cannot parse %s: %v", request, err)
+		}
+		tables = append(tables, statement.CreateTableStatement)
+	}
+	return parser.NewSchema(tables...)
+}
+
+func testSource() MemorySource {
+	return MemorySource{
+		"users": {
+			Columns: []string{"id", "name", "city"},
+			Rows: []Row{
+				{1, "alice", 1},
+				{2, "bob", 2},
+				{3, "carol", 1},
+				{4, "dave", nil},
+			},
+		},
+		"cities": {
+			Columns: []string{"id", "title"},
+			Rows: []Row{
+				{1, "paris"},
+				{2, "rome"},
+			},
+		},
+		"orders": {
+			Columns: []string{"id", "user", "amount"},
+			Rows: []Row{
+				{1, 1, 10},
+				{2, 1, 20},
+				{3, 2, 5},
+				{4, 3, 7},
+			},
+		},
+	}
+}
+
+func plan(t testing.TB, schema parser.Schema, request string) (Node, error) {
+	t.Helper()
+	statement, err := parser.Parse(request)
+	if err != nil {
+		t.Fatalf("cannot parse %s: %v", request, err)
+	}
+	node, err := Plan(statement, schema)
+	if err != nil {
+		return nil, err
+	}
+	return Optimize(node, nil)
+}
+
+func TestPlan(t *testing.T) {
+	schema := testSchema(t)
+	t.Run("Test plan shapes", func(t *testing.T) {
+		inputs := []string{
+			"select name from users where id > 1;",
+			"select name from users where upper('a') = 'A' and 1 = 1;",
+			"select name, title from users join cities on users.city = cities.id where title = 'paris' and users.id > 1;",
+			"select city, count(*) from users group by city order by city limit 1 offset 1;",
+			"select name from users where id in (select user from orders where amount > 5);",
+			"select distinct city from users;",
+			"select city from users union all select id from cities where title = 'rome';",
+			"select id from users intersect select user from orders except select id from cities;",
+			"with recursive reach (city) as (select city from users where id = 1 " +
+				"union select id from cities join reach on cities.id = reach.city) select city from reach;",
+			"select name, rank() over (partition by city order by id), lag(id) over (partition by city order by id), " +
+				"count(*) over () from users;",
+			"select name from users where not exists (select id from orders where orders.user = users.id and amount > 5);",
+			"select name, (select title from cities where cities.id = users.city) from users where id not in (select user from orders);",
+		}
+		expectedOutputs := []string{
+			"Project [name]\n" +
+				"  -> Filter (id > 1)\n" +
+				"    -> Scan users [users.id, users.name]\n",
+			"Project [name]\n" +
+				"  -> Scan users [users.name]\n",
+			"Project [name, title]\n" +
+				"  -> Join inner on (users.city = cities.id)\n" +
+				"    -> Filter (users.id > 1)\n" +
+				"      -> Scan users [users.id, users.name, users.city]\n" +
+				"    -> Filter (title = 'paris')\n" +
+				"      -> Scan cities [cities.id, cities.title]\n",
+			"Limit 1 offset 1\n" +
+				"  -> Project [city, count(*)]\n" +
+				"    -> Sort [city]\n" +
+				"      -> Aggregate group by [city] compute [count(*)]\n" +
+				"        -> Scan users [users.city]\n",
+			"Project [name]\n" +
+				"  -> Join semi on (users.id = $subquery1.user)\n" +
+				"    -> Scan users [users.id, users.name]\n" +
+				"    -> Project [orders.user]\n" +
+				"      -> Project [user]\n" +
+				"        -> Filter (amount > 5)\n" +
+				"          -> Scan orders [orders.user, orders.amount]\n",
+			"Aggregate group by [city] compute []\n" +
+				"  -> Project [city]\n" +
+				"    -> Scan users [users.city]\n",
+			"Union all\n" +
+				"  -> Project [city]\n" +
+				"    -> Scan users [users.city]\n" +
+				"  -> Project [id]\n" +
+				"    -> Filter (title = 'rome')\n" +
+				"      -> Scan cities [cities.id, cities.title]\n",
+			"Except\n" +
+				"  -> Intersect\n" +
+				"    -> Project [id]\n" +
+				"      -> Scan users [users.id]\n" +
+				"    -> Project [user]\n" +
+				"      -> Scan orders [orders.user]\n" +
+				"  -> Project [id]\n" +
+				"    -> Scan cities [cities.id]\n",
+			"Project [city]\n" +
+				"  -> Recursive union reach\n" +
+				"    -> Project [city]\n" +
+				"      -> Filter (id = 1)\n" +
+				"        -> Scan users [users.id, users.city]\n" +
+				"    -> Project [id]\n" +
+				"      -> Join inner on (cities.id = reach.city)\n" +
+				"        -> Scan cities [cities.id]\n" +
+				"        -> Working table reach [reach.city]\n",
+			"Project [name, rank() OVER (...), lag(id) OVER (...), count(*) OVER (...)]\n" +
+				"  -> Window compute [count(*)]\n" +
+				"    -> Window partition by [city] order by [id] compute [rank(), lag(id)]\n" +
+				"      -> Scan users [users.id, users.name, users.city]\n",
+			"Project [name]\n" +
+				"  -> Join anti on ((orders.user = users.id) AND (orders.amount > 5))\n" +
+				"    -> Scan users [users.id, users.name]\n" +
+				"    -> Scan orders [orders.user, orders.amount]\n",
+			"Project [name, (SELECT ...)]\n" +
+				"  -> Apply $subquery2 (SELECT ...)\n" +
+				"    -> Filter (id NOT IN (SELECT ...))\n" +
+				"      -> Apply $subquery1 (id NOT IN (SELECT ...))\n" +
+				"        -> Scan users [users.id, users.name, users.city]\n" +
+				"        -> Project [user]\n" +
+				"          -> Scan orders [orders.user]\n" +
+				"    -> Project [title]\n" +
+				"      -> Join inner on (cities.id = users.city)\n" +
+				"        -> Scan cities [cities.id, cities.title]\n" +
+				"        -> Outer row $subquery2 [users.city]\n",
+		}
+		for testCase := range inputs {
+			node, err := plan(t, schema, inputs[testCase])
+			if err != nil {
+				t.Errorf("Planning failed on set #%d: %v", testCase, err)
+				continue
+			}
+			if actual := Format(node); actual != expectedOutputs[testCase] {
+				t.Errorf("Assertion failed on set #%d. Expected:\n%s\ngot:\n%s",
+					testCase, expectedOutputs[testCase], actual)
+			}
+		}
+	})
+	t.Run("Test invalid plans", func(t *testing.T) {
+		inputs := []string{
+			"select name from users where id in (select id from cities where title = orders.name);",
+			"select name, (select id, title from cities) from users;",
+			"select name from users where exists (select id from orders where orders.user = users.title);",
+			"select id from users join cities on users.id = cities.id;",
+			"select count(sum(id)) from users;",
+			"insert into users values (1, 'a', 1);",
+			"select name from users where city = 'a';",
+			"select id from users union select name from users;",
+			"with big as (select id from users) select name from big;",
+			"with first as (select id from second), second as (select id from users) select id from first;",
+			"select sum(row_number() over (order by id)) over () from users;",
+			"select lag(id, name) over (order by id) from users;",
+		}
+		for testCase := range inputs {
+			if node, err := plan(t, schema, inputs[testCase]); err == nil {
+				t.Errorf("Expected error on set #%d. Plan got:\n%s", testCase, Format(node))
+			}
+		}
+	})
+}
+
+func TestExecute(t *testing.T) {
+	schema := testSchema(t)
+	source := testSource()
+	inputs := []string{
+		"select name from users where id > 1;",
+		"select name, title from users join cities on users.city = cities.id order by name desc;",
+		"select city, count(*), count(city) from users group by city order by city;",
+		"select sum(amount), avg(amount), min(amount), max(amount) from orders;",
+		"select count(*), sum(amount) from orders where amount > 100;",
+		"select name from users where id in (select user from orders where amount > 5) order by id;",
+		"select distinct city from users order by city;",
+		"select name from users order by id limit 2 offset 1;",
+		"select name, sum(amount) from users join orders on users.id = orders.user group by name order by name;",
+		"select upper(name) from users where name like '%o%';",
+		"select name from users where id = 1 and 1 = 0;",
+		"select name from users where id in (select id from cities);",
+		"select city from users union select id from cities;",
+		"select name from users where id < 2 union all select title from cities;",
+		"select id from users intersect select user from orders;",
+		"select id from users except select user from orders;",
+		"select city from users intersect select id from cities except select id from cities where title = 'rome';",
+		"with big as (select id, name from users where id > 1) select name from big where id < 4;",
+		"with pair (first, second) as (select name, name from users) select second from pair where first = 'bob';",
+		"with small as (select id from users), big as (select id from small where id > 2) " +
+			"select id from big union select id from small where id = 1;",
+		"with recursive chain (id) as (select id from users where id = 1 " +
+			"union select orders.id from orders join chain on orders.user = chain.id) select id from chain;",
+		"with recursive swap (first, second) as (select id, city from users where id = 3 " +
+			"union select second, first from swap) select first, second from swap;",
+		"select name, row_number() over (order by id), rank() over (order by city), dense_rank() over (order by city) " +
+			"from users order by id;",
+		"select id, sum(amount) over (partition by user order by id), count(*) over (partition by user) " +
+			"from orders order by id;",
+		"select id, lag(amount) over (order by id), lead(amount, 2, 0) over (order by id), " +
+			"first_value(amount) over (order by amount desc) from orders order by id;",
+		"select id, sum(amount) over (order by id rows between 1 preceding and 1 following), " +
+			"max(amount) over (order by amount range between 5 preceding and current row) from orders order by id;",
+		"select id, sum(amount) over (order by amount desc range between 3 preceding and 5 following) " +
+			"from orders order by id;",
+		"select city, count(*), rank() over (order by count(*) desc) from users group by city order by city;",
+		"select name from users where exists (select id from orders where orders.user = users.id) order by id;",
+		"select name from users where not exists (select id from orders where orders.user = users.id and amount > 5);",
+		"select name from users where id in (select user from orders where amount > users.id) order by id;",
+		"select name from users where city not in (select id from cities where title = 'rome');",
+		"select name from users where id not in (select city from users);",
+		"select name, (select count(*) from orders where orders.user = users.id) from users order by id;",
+		"select name from users where id = (select max(user) from orders) or " +
+			"exists (select id from cities where cities.id = users.city and title = 'rome');",
+		"select title from cities where exists (select id from users where users.city = cities.id and " +
+			"exists (select id from orders where orders.user = users.id and amount < 10)) order by title;",
+		"select name from users where city in (select id from cities where title = users.name or title = 'rome');",
+		"select name from users where city not in (select id from cities where id = 3) order by id;",
+		"select name from users where not (city in (select id from cities where id = 3)) order by id;",
+	}
+	expectedOutputs := [][]Row{
+		{{"bob"}, {"carol"}, {"dave"}},
+		{{"carol", "paris"}, {"bob", "rome"}, {"alice", "paris"}},
+		{{1, 2, 2}, {2, 1, 1}, {nil, 1, 0}},
+		{{42, 10, 5, 20}},
+		{{0, nil}},
+		{{"alice"}, {"carol"}},
+		{{1}, {2}, {nil}},
+		{{"bob"}, {"carol"}},
+		{{"alice", 30}, {"bob", 5}, {"carol", 7}},
+		{{"BOB"}, {"CAROL"}},
+		nil,
+		{{"alice"}, {"bob"}},
+		{{1}, {2}, {nil}},
+		{{"alice"}, {"paris"}, {"rome"}},
+		{{1}, {2}, {3}},
+		{{4}},
+		{{1}},
+		{{"bob"}, {"carol"}},
+		{{"bob"}},
+		{{3}, {4}, {1}},
+		{{1}, {2}, {3}, {4}},
+		{{3, 1}, {1, 3}},
+		{{"alice", 1, 1, 1}, {"bob", 2, 3, 2}, {"carol", 3, 1, 1}, {"dave", 4, 4, 3}},
+		{{1, 10, 2}, {2, 30, 2}, {3, 5, 1}, {4, 7, 1}},
+		{{1, nil, 5, 20}, {2, 10, 7, 20}, {3, 20, 0, 20}, {4, 5, 0, 20}},
+		{{1, 30, 10}, {2, 35, 20}, {3, 32, 5}, {4, 12, 7}},
+		{{1, 22}, {2, 20}, {3, 12}, {4, 22}},
+		{{1, 2, 1}, {2, 1, 2}, {nil, 1, 2}},
+		{{"alice"}, {"bob"}, {"carol"}},
+		{{"bob"}, {"dave"}},
+		{{"alice"}, {"bob"}, {"carol"}},
+		{{"alice"}, {"carol"}},
+		nil,
+		{{"alice", 2}, {"bob", 1}, {"carol", 1}, {"dave", 0}},
+		{{"bob"}, {"carol"}},
+		{{"paris"}, {"rome"}},
+		{{"bob"}},
+		{{"alice"}, {"bob"}, {"carol"}, {"dave"}},
+		{{"alice"}, {"bob"}, {"carol"}, {"dave"}},
+	}
+	executors := map[string]func(Node, Source) ([]Row, error){
+		"row":        Execute,
+		"vectorized": ExecuteVectorized,
+	}
+	for testCase := range inputs {
+		node, err := plan(t, schema, inputs[testCase])
+		if err != nil {
+			t.Errorf("Planning failed on set #%d: %v", testCase, err)
+			continue
+		}
+		for name, execute := range executors {
+			rows, err := execute(node, source)
+			if err != nil {
+				t.Errorf("Execution failed on set #%d (%s): %v", testCase, name, err)
+				continue
+			}
+			if !reflect.DeepEqual(rows, expectedOutputs[testCase]) {
+				t.Errorf("Assertion failed on set #%d (%s). Expected: %v, got: %v\nPlan:\n%s",
+					testCase, name, expectedOutputs[testCase], rows, Format(node))
+			}
+		}
+	}
+	// Scalar subquery must produce at most one row
+	node, err := plan(t, schema, "select name, (select id from orders where orders.user = users.id) from users;")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Execute(node, source); err == nil {
+		t.Errorf("Expected error on scalar subquery producing several rows")
+	}
+}
+
+// generatedSource creates orders of users with amounts of orders being NULL
+// sometimes
+func generatedSource(orders int) MemorySource {
+	source := MemorySource{
+		"users":  {Columns: []string{"id", "name", "city"}},
+		"cities": {Columns: []string{"id", "title"}},
+		"orders": {Columns: []string{"id", "user", "amount"}},
+	}
+	for id := 0; id < 100; id++ {
+		source["users"].Rows = append(source["users"].Rows, Row{id, "user" + strconv.Itoa(id), id % 7})
+	}
+	for id := 0; id < orders; id++ {
+		var amount interface{} = id * 7919 % 1000
+		if id%13 == 0 {
+			amount = nil
+		}
+		source["orders"].Rows = append(source["orders"].Rows, Row{id, id % 100, amount})
+	}
+	return source
+}
+
+var vectorizedQueries = map[string]string{
+	"filter":     "select id from orders where amount > 500 and user <> 3;",
+	"projection": "select user, amount, id from orders;",
+	"aggregation": "select user, count(*), count(amount), sum(amount), avg(amount), min(amount), max(amount) " +
+		"from orders group by user;",
+}
+
+// columnSource copies tables of source to tables with column storage
+func columnSource(t testing.TB, schema parser.Schema, source MemorySource) *ColumnSource {
+	t.Helper()
+	result := NewColumnSource(t.TempDir())
+	for name, table := range source {
+		statement := *schema[name]
+		statement.Storage = parser.ColumnStorage
+		if err := result.CreateTable(&statement); err != nil {
+			t.Fatal(err)
+		}
+		if err := result.Insert(name, table.Rows); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return result
+}
+
+func TestVectorized(t *testing.T) {
+	schema := testSchema(t)
+	source := generatedSource(10000)
+	columns := columnSource(t, schema, source)
+	inputs := []string{
+		vectorizedQueries["filter"],
+		vectorizedQueries["projection"],
+		vectorizedQueries["aggregation"],
+		"select count(*), sum(amount), min(id) from orders where amount < 10 or not amount < 990;",
+		"select amount, count(*) from orders where user < 10 group by amount order by amount limit 5;",
+		"select name, max(amount) from users join orders on users.id = orders.user group by name, city;",
+		"select count(*), max(amount) from orders where id < 0;",
+		"select upper(name), city from users where name like 'user1%' or city in (1, 2);",
+	}
+	for testCase := range inputs {
+		node, err := plan(t, schema, inputs[testCase])
+		if err != nil {
+			t.Errorf("Planning failed on set #%d: %v", testCase, err)
+			continue
+		}
+		expected, err := Execute(node, source)
+		if err != nil {
+			t.Errorf("Row execution failed on set #%d: %v", testCase, err)
+			continue
+		}
+		executions := []struct {
+			name    string
+			execute func(Node, Source) ([]Row, error)
+			source  Source
+		}{
+			{"vectorized", ExecuteVectorized, source},
+			{"row columnar", Execute, columns},
+			{"vectorized columnar", ExecuteVectorized, columns},
+		}
+		for _, execution := range executions {
+			actual, err := execution.execute(node, execution.source)
+			if err != nil {
+				t.Errorf("Execution failed on set #%d (%s): %v", testCase, execution.name, err)
+				continue
+			}
+			if !reflect.DeepEqual(actual, expected) {
+				t.Errorf("Assertion failed on set #%d (%s). Execution returned %d rows, expected %d",
+					testCase, execution.name, len(actual), len(expected))
+			}
+		}
+	}
+}
+
+// sortedRows sorts rows, so that rows of queries without ORDER BY are
+// compared regardless of their order
+func sortedRows(rows []Row) []Row {
+	result := append([]Row{}, rows...)
+	sort.Slice(result, func(i, j int) bool { return encodeKey(result[i]) < encodeKey(result[j]) })
+	return result
+}
+
+func TestSpilling(t *testing.T) {
+	schema := testSchema(t)
+	source := generatedSource(10000)
+	inputs := []string{
+		"select id, amount from orders order by amount desc, id;",
+		"select user, count(*), sum(amount), min(amount) from orders group by user;",
+		"select amount, count(*) from orders group by amount order by amount;",
+		"select name, amount from users join orders on users.id = orders.user and amount > city;",
+		"select name, amount from orders join users on orders.user = users.id where amount > 900;",
+		"select name from users where id in (select user from orders where amount > 900);",
+		"select user, amount from orders except select id, city from users;",
+		"select id, amount from orders where not exists (select id from users where users.id = orders.user and city > 50);",
+		"select id, sum(amount) over (partition by user order by amount rows between 2 preceding and current row) from orders;",
+	}
+	for testCase := range inputs {
+		node, err := plan(t, schema, inputs[testCase])
+		if err != nil {
+			t.Errorf("Planning failed on set #%d: %v", testCase, err)
+			continue
+		}
+		expected, err := Execute(node, source)
+		if err != nil {
+			t.Errorf("Execution failed on set #%d: %v", testCase, err)
+			continue
+		}
+		for _, vectorized := range []bool{false, true} {
+			directory := t.TempDir()
+			settings := Settings{WorkMemory: 4096, TempDirectory: directory, Vectorized: vectorized}
+			operator, err := LowerWith(node, source, settings)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := operator.Open(); err != nil {
+				t.Fatal(err)
+			}
+			var actual []Row
+			for {
+				row, err := operator.Next()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if row == nil {
+					break
+				}
+				// Rows which did not fit into memory are in files. Vectorized
+				// operators read rows by batches, so files can be already
+				// removed when they produce the first row
+				if len(actual) == 0 && !vectorized {
+					if files, _ := os.ReadDir(directory); len(files) == 0 {
+						t.Errorf("Expected rows to be spilled on set #%d", testCase)
+					}
+				}
+				actual = append(actual, row)
+			}
+			if err := operator.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(inputs[testCase], "order by") {
+				actual, expected = sortedRows(actual), sortedRows(expected)
+			}
+			if !reflect.DeepEqual(actual, expected) {
+				t.Errorf("Assertion failed on set #%d (vectorized: %v). Got %d rows, expected %d",
+					testCase, vectorized, len(actual), len(expected))
+			}
+			if files, _ := os.ReadDir(directory); len(files) != 0 {
+				t.Errorf("Expected spilled files to be removed on set #%d, got %d files", testCase, len(files))
+			}
+		}
+	}
+
+	// Spilled rows are removed when query fails or is canceled after rows
+	// were spilled
+	numbers := MemorySource{"users": {Columns: []string{"id", "name", "city"}}}
+	for id := 0; id < 10000; id++ {
+		numbers["users"].Rows = append(numbers["users"].Rows, Row{id, strconv.Itoa(id), 1})
+	}
+	numbers["users"].Rows = append(numbers["users"].Rows, Row{10000, "disco", 1})
+	failures := []struct {
+		source MemorySource
+		query  string
+		cancel bool
+	}{
+		{numbers, "select id from users order by cast(name as int);", false},
+		{source, "select id, amount from orders order by amount desc, id;", true},
+		{source, "select user, count(*) from orders group by user, amount;", true},
+	}
+	for testCase, failure := range failures {
+		node, err := plan(t, schema, failure.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		var read int64
+		canceling := hookSource{MemorySource: failure.source, hook: func() {
+			if atomic.AddInt64(&read, 1) == 5000 && failure.cancel {
+				cancel()
+			}
+		}}
+		directory := t.TempDir()
+		settings := Settings{WorkMemory: 50000, TempDirectory: directory}
+		_, err = ExecuteContext(ctx, node, canceling, settings)
+		cancel()
+		if err == nil || failure.cancel != (err == context.Canceled) {
+			t.Errorf("Expected execution to fail on failure #%d, got: %v", testCase, err)
+		}
+		if files, _ := os.ReadDir(directory); len(files) != 0 {
+			t.Errorf("Expected spilled files to be removed on failure #%d, got %d files", testCase, len(files))
+		}
+	}
+}
+
+func TestParallel(t *testing.T) {
+	schema := testSchema(t)
+	source := generatedSource(10000)
+	columns := columnSource(t, schema, source)
+	inputs := []string{
+		"select id, amount from orders where amount > 500;",
+		"select user, count(*), sum(amount), avg(amount), min(amount), max(amount) from orders group by user;",
+		"select count(*), sum(amount), avg(amount), max(id) from orders;",
+		"select count(*), max(amount) from orders where id < 0;",
+		"select name, count(*) from users join orders on users.id = orders.user group by name;",
+		"select name, amount from orders join users on orders.user = users.id and amount > city order by amount, name;",
+		"select name from users where id in (select user from orders where amount > 900);",
+		"select amount, count(*) from orders group by amount order by amount limit 5;",
+		"select name from users where not exists (select id from orders where orders.user = users.id and amount > 900);",
+	}
+	// Plans are expected to contain parallel operators
+	expectedOperators := []string{
+		"Gather",
+		"Parallel Hash Aggregate",
+		"Parallel Hash Aggregate",
+		"Parallel Hash Aggregate",
+		"Parallel Hash Aggregate",
+		"Gather",
+		"Gather",
+		"Parallel Hash Aggregate",
+		"Gather",
+	}
+	for testCase := range inputs {
+		node, err := plan(t, schema, inputs[testCase])
+		if err != nil {
+			t.Errorf("Planning failed on set #%d: %v", testCase, err)
+			continue
+		}
+		expected, err := Execute(node, source)
+		if err != nil {
+			t.Errorf("Serial execution failed on set #%d: %v", testCase, err)
+			continue
+		}
+		for _, execution := range []struct {
+			name   string
+			source Source
+		}{{"memory", source}, {"columnar", columns}} {
+			for _, vectorized := range []bool{false, true} {
+				l := &lowering{
+					source:     execution.source,
+					vectorized: vectorized,
+					workers:    4,
+					operators:  map[Node]Operator{},
+				}
+				operator, err := l.lower(node)
+				if err != nil {
+					t.Fatal(err)
+				}
+				found := false
+				for _, lowered := range l.operators {
+					found = found || operatorName(lowered) == expectedOperators[testCase]
+				}
+				if !found {
+					t.Errorf("Expected %s operator on set #%d (%s)", expectedOperators[testCase], testCase, execution.name)
+				}
+				actual, err := collect(operator)
+				if err != nil {
+					t.Errorf("Parallel execution failed on set #%d (%s): %v", testCase, execution.name, err)
+					continue
+				}
+				if !strings.Contains(inputs[testCase], "order by") {
+					actual, expected = sortedRows(actual), sortedRows(expected)
+				}
+				if !reflect.DeepEqual(actual, expected) {
+					t.Errorf("Assertion failed on set #%d (%s, vectorized: %v). Got %d rows, expected %d",
+						testCase, execution.name, vectorized, len(actual), len(expected))
+				}
+			}
+		}
+	}
+
+	// Workers are stopped when gather is closed before all rows are read
+	node, err := plan(t, schema, "select id from orders limit 3;")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows, err := ExecuteWith(node, source, Settings{MaxParallelWorkers: 8})
+	if err != nil || len(rows) != 3 {
+		t.Errorf("Expected 3 rows, got %d rows and error: %v", len(rows), err)
+	}
+	// Errors of workers are returned by operators reading their rows
+	node, err = plan(t, schema, "select count(*) from users where cast(name as int) = 1;")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ExecuteWith(node, source, Settings{MaxParallelWorkers: 4}); err == nil {
+		t.Errorf("Expected error of worker to be returned")
+	}
+}
+
+func TestSettings(t *testing.T) {
+	inputs := []string{
+		"set max_parallel_workers = 4;",
+		"set work_memory to 65536;",
+		"set vectorized = on;",
+		"set temp_directory = '/tmp';",
+		"set statement_timeout = 1500;",
+		"set statement_timeout = '2m';",
+		"set max_recursion = 10;",
+		"set lock_timeout = '100ms';",
+		"set max_parallel_workers = 'many';",
+		"set statement_timeout = 'soon';",
+		"set vectorized = 1;",
+		"set unknown = 1;",
+	}
+	expectedOutputs := []Settings{
+		{MaxParallelWorkers: 4},
+		{MaxParallelWorkers: 4, WorkMemory: 65536},
+		{MaxParallelWorkers: 4, WorkMemory: 65536, Vectorized: true},
+		{MaxParallelWorkers: 4, WorkMemory: 65536, Vectorized: true, TempDirectory: "/tmp"},
+		{MaxParallelWorkers: 4, WorkMemory: 65536, Vectorized: true, TempDirectory: "/tmp",
+			StatementTimeout: 1500 * time.Millisecond},
+		{MaxParallelWorkers: 4, WorkMemory: 65536, Vectorized: true, TempDirectory: "/tmp",
+			StatementTimeout: 2 * time.Minute},
+		{MaxParallelWorkers: 4, WorkMemory: 65536, Vectorized: true, TempDirectory: "/tmp",
+			StatementTimeout: 2 * time.Minute, MaxRecursion: 10},
+		{MaxParallelWorkers: 4, WorkMemory: 65536, Vectorized: true, TempDirectory: "/tmp",
+			StatementTimeout: 2 * time.Minute, MaxRecursion: 10, LockTimeout: 100 * time.Millisecond},
+	}
+	var settings Settings
+	for testCase := range inputs {
+		statement, err := parser.Parse(inputs[testCase])
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = settings.Apply(statement.SetStatement)
+		if testCase >= len(expectedOutputs) {
+			if err == nil {
+				t.Errorf("Expected error on set #%d", testCase)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Applying failed on set #%d: %v", testCase, err)
+			continue
+		}
+		if settings != expectedOutputs[testCase] {
+			t.Errorf("Assertion failed on set #%d. Expected: %+v, got: %+v", testCase, expectedOutputs[testCase], settings)
+		}
+	}
+}
+
+func TestRecursion(t *testing.T) {
+	schema := testSchema(t)
+	source := testSource()
+	// Chain of orders is found in 3 iterations producing rows, the 4th one
+	// produces nothing
+	node, err := plan(t, schema, "with recursive chain (id) as (select id from users where id = 1 "+
+		"union select orders.id from orders join chain on orders.user = chain.id) select id from chain;")
+	if err != nil {
+		t.Fatal(err)
+	}
+	inputs := []Settings{
+		{MaxRecursion: 3},
+		{MaxRecursion: 3, MaxParallelWorkers: 4},
+		{MaxRecursion: 3, WorkMemory: 64, TempDirectory: t.TempDir()},
+		{MaxRecursion: 2},
+	}
+	for testCase, settings := range inputs {
+		rows, err := ExecuteWith(node, source, settings)
+		if testCase == len(inputs)-1 {
+			if err == nil {
+				t.Errorf("Expected error on exceeding limit of iterations, got: %v", rows)
+			}
+			continue
+		}
+		if expected := []Row{{1}, {2}, {3}, {4}}; err != nil || !reflect.DeepEqual(rows, expected) {
+			t.Errorf("Assertion failed on set #%d. Expected: %v, got: %v (%v)", testCase, expected, rows, err)
+		}
+	}
+	// UNION ALL produces the same rows again and again until default limit
+	// is exceeded
+	node, err = plan(t, schema, "with recursive loop (id) as (select id from users where id = 1 "+
+		"union all select id from loop) select id from loop;")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rows, err := Execute(node, source); err == nil {
+		t.Errorf("Expected error on exceeding default limit of iterations, got %d rows", len(rows))
+	}
+}
+
+// hookSource calls hook before every row of its tables is returned
+type hookSource struct {
+	MemorySource
+	hook func()
+}
+
+type hookIterator struct {
+	Iterator
+	hook func()
+}
+
+func (hi hookIterator) Next() (Row, error) {
+	hi.hook()
+	return hi.Iterator.Next()
+}
+
+func (hs hookSource) Scan(table string, columns []string) (Iterator, error) {
+	return hs.ScanPartition(table, columns, 0, 1)
+}
+
+func (hs hookSource) ScanPartition(table string, columns []string, partition, partitions int) (Iterator, error) {
+	iterator, err := hs.MemorySource.ScanPartition(table, columns, partition, partitions)
+	if err != nil {
+		return nil, err
+	}
+	return hookIterator{Iterator: iterator, hook: hs.hook}, nil
+}
+
+func TestCancellation(t *testing.T) {
+	schema := testSchema(t)
+	source := generatedSource(10000)
+	inputs := []string{
+		"select id, amount from orders where amount > 10;",
+		"select user, count(*), sum(amount) from orders group by user;",
+		"select id from orders order by amount desc;",
+		"select name, amount from orders join users on orders.user = users.id;",
+	}
+	settings := []Settings{{}, {Vectorized: true}, {MaxParallelWorkers: 4}, {WorkMemory: 4096, TempDirectory: t.TempDir()}}
+	for testCase := range inputs {
+		node, err := plan(t, schema, inputs[testCase])
+		if err != nil {
+			t.Errorf("Planning failed on set #%d: %v", testCase, err)
+			continue
+		}
+		for _, setting := range settings {
+			// Query is canceled after some rows of tables are read
+			ctx, cancel := context.WithCancel(context.Background())
+			var read int64
+			canceling := hookSource{MemorySource: source, hook: func() {
+				if atomic.AddInt64(&read, 1) == 1000 {
+					cancel()
+				}
+			}}
+			rows, err := ExecuteContext(ctx, node, canceling, setting)
+			cancel()
+			if err != context.Canceled {
+				t.Errorf("Expected canceled execution on set #%d (%+v), got %d rows and error: %v",
+					testCase, setting, len(rows), err)
+			}
+			if read > 2000 {
+				t.Errorf("Expected execution to stop soon after cancellation on set #%d (%+v), read %d rows",
+					testCase, setting, read)
+			}
+		}
+	}
+
+	// Queries running longer than statement timeout are canceled
+	node, err := plan(t, schema, inputs[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	slow := hookSource{MemorySource: source, hook: func() { time.Sleep(10 * time.Microsecond) }}
+	if _, err := ExecuteContext(context.Background(), node, slow, Settings{StatementTimeout: time.Millisecond}); err != context.DeadlineExceeded {
+		t.Errorf("Expected statement timeout, got: %v", err)
+	}
+	if _, err := ExecuteContext(context.Background(), node, source, Settings{StatementTimeout: time.Minute}); err != nil {
+		t.Errorf("Expected query to finish before timeout, got: %v", err)
+	}
+
+	// Nothing is done for queries canceled before they start
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	statement, err := parser.Parse(inputs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := PlanContext(ctx, statement, schema); err != context.Canceled {
+		t.Errorf("Expected canceled planning, got: %v", err)
+	}
+	if _, err := LowerContext(ctx, node, source, Settings{}); err != context.Canceled {
+		t.Errorf("Expected canceled lowering, got: %v", err)
+	}
+}
+
+func TestQueries(t *testing.T) {
+	queries := NewQueries()
+	first, firstContext, finishFirst := queries.Start(context.Background())
+	second, secondContext, finishSecond := queries.Start(context.Background())
+	if first == second || !reflect.DeepEqual(queries.Running(), []QueryID{first, second}) {
+		t.Fatalf("Expected two running queries, got: %v", queries.Running())
+	}
+	statement, err := parser.Parse("kill query " + strconv.FormatUint(uint64(second), 10) + ";")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := queries.Execute(statement.CancelStatement); err != nil {
+		t.Fatal(err)
+	}
+	if secondContext.Err() != context.Canceled || firstContext.Err() != nil {
+		t.Errorf("Expected only the second query to be canceled")
+	}
+	if err := queries.Cancel(second); err == nil {
+		t.Errorf("Expected error on canceling query twice")
+	}
+	finishSecond()
+	finishFirst()
+	if len(queries.Running()) != 0 || firstContext.Err() == nil {
+		t.Errorf("Expected finished queries to be removed, got: %v", queries.Running())
+	}
+}
+
+func TestQueryTransactions(t *testing.T) {
+	schema := testSchema(t)
+	heap := engine.NewHeapEngine()
+	statement, _ := parser.Parse("create table users (id int, name text, city int);")
+	if err := heap.CreateTable(statement.CreateTableStatement); err != nil {
+		t.Fatal(err)
+	}
+	node, err := plan(t, schema, "select name from users;")
+	if err != nil {
+		t.Fatal(err)
+	}
+	queries := NewQueries()
+	for index, interrupt := range []func(id QueryID){
+		func(id QueryID) {},
+		func(id QueryID) { queries.Cancel(id) },
+		func(id QueryID) { time.Sleep(10 * time.Millisecond) },
+	} {
+		transaction, _ := heap.Begin()
+		statement, _ := parser.Parse("insert into users values (" + strconv.Itoa(index) + ", 'alice', 1);")
+		if err := ExecuteInsert(transaction, statement.InsertStatement); err != nil {
+			t.Fatal(err)
+		}
+		// Query which finishes in time keeps its changes
+		timeout := time.Millisecond
+		if index == 0 {
+			timeout = time.Minute
+		}
+		id, ctx, finish := queries.StartIn(context.Background(), transaction, timeout)
+		interrupt(id)
+		_, err := ExecuteContext(ctx, node, transaction, Settings{})
+		finish()
+		if index == 0 {
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := transaction.Commit(); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		if err == nil {
+			t.Errorf("Expected interrupted query on set #%d", index)
+		}
+		if err := transaction.Commit(); err == nil {
+			t.Errorf("Expected transaction of interrupted query to be rolled back on set #%d", index)
+		}
+	}
+	transaction, _ := heap.Begin()
+	defer transaction.Rollback()
+	rows, err := Execute(node, transaction)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []Row{{"alice"}}; !reflect.DeepEqual(rows, expected) {
+		t.Errorf("Expected only rows of finished query, got: %v", rows)
+	}
+}
+
+func TestColumnSource(t *testing.T) {
+	schema := testSchema(t)
+	source := NewColumnSource(t.TempDir())
+	for _, request := range []string{
+		"create table orders (id int, user int, amount int) with (storage = column);",
+		"create table users (id int, name text, city int) with (storage = row);",
+	} {
+		statement, err := parser.Parse(request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = source.CreateTable(statement.CreateTableStatement)
+		if statement.CreateTableStatement.Storage == parser.ColumnStorage && err != nil {
+			t.Fatal(err)
+		}
+		if statement.CreateTableStatement.Storage != parser.ColumnStorage && err == nil {
+			t.Errorf("Expected error on creating table without column storage")
+		}
+	}
+	if err := source.Insert("orders", []Row{{1, 1, 10}, {2, 1, nil}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := source.Insert("orders", []Row{{3, "bob", 10}}); err == nil {
+		t.Errorf("Expected error on inserting text to int column")
+	}
+	if count, ok := source.RowCount("orders"); !ok || count != 2 {
+		t.Errorf("Expected 2 rows in orders, got: %d", count)
+	}
+	if _, err := source.Scan("users", []string{"id"}); err == nil {
+		t.Errorf("Expected error on scanning table which does not exist")
+	}
+
+	// Tables are opened again by new source
+	reopened := NewColumnSource(source.Directory)
+	node, err := plan(t, schema, "select id, amount from orders where 5 < amount and id <= 1;")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows, err := ExecuteVectorized(node, reopened)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(rows, []Row{{1, 10}}) {
+		t.Errorf("Unexpected rows: %v", rows)
+	}
+
+	// Comparisons of columns with literals are passed to scan
+	for current := node; current != nil; {
+		filter, ok := current.(*Filter)
+		if !ok {
+			current = current.Children()[0]
+			continue
+		}
+		predicates := zonePredicates(filter.Condition, filter.Input.(*Scan))
+		expected := []columnar.Predicate{
+			{Column: "amount", Operator: ">", Value: 5},
+			{Column: "id", Operator: "<=", Value: 1},
+		}
+		if !reflect.DeepEqual(predicates, expected) {
+			t.Errorf("Expected predicates %v, got: %v", expected, predicates)
+		}
+		break
+	}
+
+	// Prefix LIKE conditions are passed to scan as ranges
+	node, err = plan(t, schema, "select name from users where name like 'ca%' and id > 1;")
+	if err != nil {
+		t.Fatal(err)
+	}
+	filter := node.Children()[0].(*Filter)
+	predicates := zonePredicates(filter.Condition, filter.Input.(*Scan))
+	expected := []columnar.Predicate{
+		{Column: "name", Operator: ">=", Value: "ca"},
+		{Column: "name", Operator: "<", Value: "cb"},
+		{Column: "id", Operator: ">", Value: 1},
+	}
+	if !reflect.DeepEqual(predicates, expected) {
+		t.Errorf("Expected predicates %v, got: %v", expected, predicates)
+	}
+	rows, err = ExecuteVectorized(node, columnSource(t, schema, testSource()))
+	if err != nil || !reflect.DeepEqual(rows, []Row{{"carol"}}) {
+		t.Errorf("Unexpected rows: %v (%v)", rows, err)
+	}
+}
+
+func TestStorageEngines(t *testing.T) {
+	schema := testSchema(t)
+	storage, err := engine.OpenLSMEngine(t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	engines := engine.NewEngines(map[string]engine.StorageEngine{parser.RowStorage: engine.NewHeapEngine(), parser.LSMStorage: storage})
+	defer engines.Close()
+	for _, request := range []string{
+		"create table users (id int, name text, city int);",
+		"create table orders (id int, user int, amount int) with (storage = lsm);",
+	} {
+		statement, err := parser.Parse(request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := engines.CreateTable(statement.CreateTableStatement); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	transaction, err := engines.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer transaction.Rollback()
+	for _, request := range []string{
+		"insert into users values (1, 'alice', 1);",
+		"insert into users (id, name) values (2, 'bob');",
+		"insert into orders values (1, 1, 10);",
+		"insert into orders values (2, 2, 20);",
+		"insert into orders (id, user) values (3, 1);",
+	} {
+		statement, err := parser.Parse(request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ExecuteInsert(transaction, statement.InsertStatement); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, request := range []string{
+		"insert into users values (3, 'carol');",
+		"insert into users (id, title) values (3, 'carol');",
+		"insert into cities values (1, 'paris');",
+	} {
+		statement, _ := parser.Parse(request)
+		if err := ExecuteInsert(transaction, statement.InsertStatement); err == nil {
+			t.Errorf("Expected error on %s", request)
+		}
+	}
+	if row, _ := transaction.Get("users", 2); !reflect.DeepEqual(row, Row{2, "bob", nil}) {
+		t.Errorf("Unexpected row: %v", row)
+	}
+
+	// Query joins tables of different engines
+	node, err := plan(t, schema, "select name, count(*), sum(amount) from users join orders on users.id = orders.user group by name;")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows, err := Execute(node, transaction)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []Row{{"alice", 2, 10}, {"bob", 1, 20}}; !reflect.DeepEqual(sortedRows(rows), sortedRows(expected)) {
+		t.Errorf("Expected rows %v, got: %v", expected, rows)
+	}
+}
+
+func benchmarkExecutor(b *testing.B, execute func(Node, Source) ([]Row, error), columnar bool) {
+	schema := testSchema(b)
+	var source Source = generatedSource(100000)
+	if columnar {
+		source = columnSource(b, schema, source.(MemorySource))
+	}
+	for _, name := range []string{"filter", "projection", "aggregation"} {
+		node, err := plan(b, schema, vectorizedQueries[name])
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.Run(name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := execute(node, source); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkRowExecutor(b *testing.B) { benchmarkExecutor(b, Execute, false) }
+
+func BenchmarkVectorizedExecutor(b *testing.B) { benchmarkExecutor(b, ExecuteVectorized, false) }
+
+func BenchmarkColumnarExecutor(b *testing.B) { benchmarkExecutor(b, ExecuteVectorized, true) }
+
+func BenchmarkParallelExecutor(b *testing.B) {
+	benchmarkExecutor(b, func(node Node, source Source) ([]Row, error) {
+		return ExecuteWith(node, source, Settings{MaxParallelWorkers: runtime.NumCPU()})
+	}, false)
+}
+
+func TestFoldConstants(t *testing.T) {
+	schema := testSchema(t)
+	inputs := []string{
+		"select upper('a'), name from users;",
+		"select name from users where id = length('abc');",
+		"select now() from users;",
+		"select name from users where 1 = 0;",
+		"select name from users where id = 1 and 'a' like 'b';",
+	}
+	expectedOutputs := []string{
+		"Project ['A', name]",
+		"Filter (id = 3)",
+		"Project [now()]",
+		"Filter (1 <> 1)",
+		"Filter ((id = 1) AND (1 <> 1))",
+	}
+	for testCase := range inputs {
+		node, err := plan(t, schema, inputs[testCase])
+		if err != nil {
+			t.Errorf("Planning failed on set #%d: %v", testCase, err)
+			continue
+		}
+		if actual := Format(node); !strings.Contains(actual, expectedOutputs[testCase]) {
+			t.Errorf("Assertion failed on set #%d. Expected plan containing %s, got:\n%s",
+				testCase, expectedOutputs[testCase], actual)
+		}
+	}
+	// Statement is not changed by optimization, so it can be planned again
+	statement, _ := parser.Parse(inputs[1])
+	before := statement.SelectStatement.String()
+	node, err := Plan(statement, schema)
+	if err == nil {
+		_, err = Optimize(node, nil)
+	}
+	if err != nil || statement.SelectStatement.String() != before {
+		t.Errorf("Statement was changed by optimization: %v", err)
+	}
+}
+
+func TestExplain(t *testing.T) {
+	schema := testSchema(t)
+	source := testSource()
+	elapsed := regexp.MustCompile(`time=[0-9.]+ms`)
+	t.Run("Test EXPLAIN text format", func(t *testing.T) {
+		inputs := []string{
+			"explain select name from users where id > 1;",
+			"explain analyze select name, title from users join cities on users.city = cities.id order by name;",
+			"explain analyze format text select city, count(*) from users group by city limit 1;",
+			"explain select id from users except select user from orders;",
+		}
+		expectedOutputs := []string{
+			"Project [name] (cost=5.33 rows=1)\n" +
+				"  -> Filter (id > 1) (cost=5.00 rows=1)\n" +
+				"    -> Seq Scan users [users.id, users.name] (cost=4.00 rows=4)\n",
+			"Project [name, title] (cost=8.90 rows=1) (actual rows=3 loops=1 time=?)\n" +
+				"  -> Sort [name] (cost=8.70 rows=1) (actual rows=3 loops=1 time=?)\n" +
+				"    -> Hash Join on (users.city = cities.id) (cost=8.50 rows=1) (actual rows=3 loops=1 time=?)\n" +
+				"      -> Seq Scan users [users.name, users.city] (cost=4.00 rows=4) (actual rows=4 loops=1 time=?)\n" +
+				"      -> Seq Scan cities [cities.id, cities.title] (cost=2.00 rows=2) (actual rows=2 loops=1 time=?)\n",
+			"Limit 1 (cost=5.25 rows=1) (actual rows=1 loops=1 time=?)\n" +
+				"  -> Project [city, count(*)] (cost=5.25 rows=1) (actual rows=1 loops=1 time=?)\n" +
+				"    -> Hash Aggregate group by [city] compute [count(*)] (cost=5.00 rows=1) (actual rows=1 loops=1 time=?)\n" +
+				"      -> Seq Scan users [users.city] (cost=4.00 rows=4) (actual rows=4 loops=1 time=?)\n",
+			"Hash Except (cost=12.00 rows=1)\n" +
+				"  -> Project [id] (cost=5.00 rows=4)\n" +
+				"    -> Seq Scan users [users.id] (cost=4.00 rows=4)\n" +
+				"  -> Project [user] (cost=5.00 rows=4)\n" +
+				"    -> Seq Scan orders [orders.user] (cost=4.00 rows=4)\n",
+		}
+		for testCase := range inputs {
+			statement, err := parser.Parse(inputs[testCase])
+			if err != nil {
+				t.Fatalf("cannot parse %s: %v", inputs[testCase], err)
+			}
+			output, err := ExplainStatement(statement.ExplainStatement, schema, source, nil)
+			if err != nil {
+				t.Errorf("Explaining failed on set #%d: %v", testCase, err)
+				continue
+			}
+			if actual := elapsed.ReplaceAllString(output, "time=?"); actual != expectedOutputs[testCase] {
+				t.Errorf("Assertion failed on set #%d. Expected:\n%s\ngot:\n%s",
+					testCase, expectedOutputs[testCase], actual)
+			}
+		}
+	})
+	t.Run("Test EXPLAIN JSON format", func(t *testing.T) {
+		statement, err := parser.Parse("explain analyze format json select name from users where id in (select user from orders);")
+		if err != nil {
+			t.Fatal(err)
+		}
+		output, err := ExplainStatement(statement.ExplainStatement, schema, source, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var explanation Explanation
+		if err := json.Unmarshal([]byte(output), &explanation); err != nil {
+			t.Fatalf("output is not JSON: %v", err)
+		}
+		join := explanation.Children[0]
+		if join.Operator != "Hash Semi Join" || join.Actual == nil || join.Actual.Rows != 3 ||
+			len(join.Children) != 2 || join.Children[0].Actual.Rows != 4 {
+			t.Errorf("Unexpected explanation: %s", output)
+		}
+	})
+}
+
+func TestStatistics(t *testing.T) {
+	schema := testSchema(t)
+	// Users live in few cities and make many orders, only a few orders are
+	// large
+	source := MemorySource{
+		"users":  {Columns: []string{"id", "name", "city"}},
+		"cities": {Columns: []string{"id", "title"}},
+		"orders": {Columns: []string{"id", "user", "amount"}},
+	}
+	for id := 0; id < 5; id++ {
+		source["cities"].Rows = append(source["cities"].Rows, Row{id, "city" + strconv.Itoa(id)})
+	}
+	for id := 0; id < 200; id++ {
+		source["users"].Rows = append(source["users"].Rows, Row{id, "user" + strconv.Itoa(id), id % 5})
+	}
+	for id := 0; id < 2000; id++ {
+		amount := id % 100
+		if id%500 == 0 {
+			amount = 1000
+		}
+		source["orders"].Rows = append(source["orders"].Rows, Row{id, id % 200, amount})
+	}
+	catalog := statistics.NewCatalog()
+	statement, err := parser.Parse("analyze;")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Analyze(statement.AnalyzeStatement, schema, source, catalog); err != nil {
+		t.Fatal(err)
+	}
+	estimator := NewEstimator(catalog, nil)
+
+	t.Run("Test row estimation", func(t *testing.T) {
+		inputs := []string{
+			"select id from orders;",
+			"select id from orders where amount = 1000;",
+			"select id from orders where amount < 50;",
+			"select id from orders where amount between 10 and 19;",
+			"select id from users where city in (1, 2);",
+			"select city from users group by city;",
+			"select title from users join cities on users.city = cities.id;",
+		}
+		// 1000 is one of 101 distinct amounts, it is not frequent enough to
+		// be listed as most common value
+		expectedOutputs := []float64{2000, 20, 1000, 200, 80, 5, 200}
+		for testCase := range inputs {
+			node, err := plan(t, schema, inputs[testCase])
+			if err != nil {
+				t.Errorf("Planning failed on set #%d: %v", testCase, err)
+				continue
+			}
+			// Project does not change number of rows
+			actual := estimator.Rows(node)
+			if math.Abs(actual-expectedOutputs[testCase]) > 0.1*expectedOutputs[testCase] {
+				t.Errorf("Assertion failed on set #%d. Expected about %f rows, got: %f",
+					testCase, expectedOutputs[testCase], actual)
+			}
+		}
+	})
+	t.Run("Test join ordering", func(t *testing.T) {
+		request := "select title, amount from orders join users on orders.user = users.id " +
+			"join cities on users.city = cities.id where amount = 1000 and title = 'city0';"
+		parsed, err := parser.Parse(request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		node, err := Plan(parsed, schema)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if node, err = Optimize(node, estimator); err != nil {
+			t.Fatal(err)
+		}
+		// Filtered cities leave a fifth of users, which are joined with
+		// large orders afterwards
+		expected := "Project [title, amount]\n" +
+			"  -> Join inner on (orders.user = users.id)\n" +
+			"    -> Join inner on (users.city = cities.id)\n" +
+			"      -> Scan users [users.id, users.city]\n" +
+			"      -> Filter (title = 'city0')\n" +
+			"        -> Scan cities [cities.id, cities.title]\n" +
+			"    -> Filter (amount = 1000)\n" +
+			"      -> Scan orders [orders.user, orders.amount]\n"
+		if actual := Format(node); actual != expected {
+			t.Errorf("Assertion failed. Expected:\n%s\ngot:\n%s", expected, actual)
+		}
+		rows, err := Execute(node, source)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(rows, []Row{{"city0", 1000}, {"city0", 1000}, {"city0", 1000}, {"city0", 1000}}) {
+			t.Errorf("Unexpected rows: %v", rows)
+		}
+	})
+	t.Run("Test ANALYZE of unknown table", func(t *testing.T) {
+		statement, err := parser.Parse("analyze unknown;")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := Analyze(statement.AnalyzeStatement, schema, source, catalog); err == nil {
+			t.Errorf("Expected error on analyzing unknown table")
+		}
+	})
+}
@@ -0,0 +1,156 @@
+package planner
+
+import "fmt"
+
+// defaultMaxRecursion limits iterations of recursive unions when limit is not
+// set
+const defaultMaxRecursion = 1000
+
+// recursiveUnion lowers recursive union. Working tables are found by names of
+// common table expressions, so that references inside the recursive part
+// read rows of the innermost recursive union with their name. Tables of
+// parallel hash joins are built once, while the recursive part is evaluated
+// again on every iteration, so it is lowered to serial operators
+func (l *lowering) recursiveUnion(union *RecursiveUnion) (Operator, error) {
+	initial, err := l.lower(union.Initial)
+	if err != nil {
+		return nil, err
+	}
+	table := &workingTable{}
+	working, workers := l.working, l.workers
+	defer func() { l.working, l.workers = working, workers }()
+	l.working = map[string]*workingTable{union.Name: table}
+	for name, other := range working {
+		if name != union.Name {
+			l.working[name] = other
+		}
+	}
+	l.workers = 1
+	recursive, err := l.lower(union.Recursive)
+	if err != nil {
+		return nil, err
+	}
+	operator := &recursiveUnion{
+		name:      union.Name,
+		initial:   initial,
+		recursive: recursive,
+		working:   table,
+		all:       union.All,
+		limit:     l.maxRecursion,
+		budget:    l.budget,
+	}
+	if operator.limit == 0 {
+		operator.limit = defaultMaxRecursion
+	}
+	return operator, nil
+}
+
+// workingTable keeps rows produced on the previous iteration of recursive
+// union
+type workingTable struct {
+	rows []Row
+}
+
+// workingTableScan reads rows of working table
+type workingTableScan struct {
+	table *workingTable
+	next  int
+}
+
+func (wts *workingTableScan) Open() error {
+	wts.next = 0
+	return nil
+}
+
+func (wts *workingTableScan) Next() (Row, error) {
+	if wts.next >= len(wts.table.rows) {
+		return nil, nil
+	}
+	wts.next++
+	return wts.table.rows[wts.next-1], nil
+}
+
+func (wts *workingTableScan) Close() error { return nil }
+
+// recursiveUnion produces rows of the initial input and then evaluates the
+// recursive input over rows produced on the previous iteration until it
+// produces no new rows. It fails when recursive input still produces rows
+// after limit iterations. Unless all is set, distinct rows produced so far
+// are kept in memory to skip them
+type recursiveUnion struct {
+	name               string
+	initial, recursive Operator
+	working            *workingTable
+	all                bool
+	limit              int
+	budget             *budget
+
+	seen       map[string]bool
+	rows       []Row
+	next       int
+	iterations int
+	reserved   int64
+}
+
+func (ru *recursiveUnion) Open() error {
+	if err := ru.Close(); err != nil {
+		return err
+	}
+	if !ru.all {
+		ru.seen = map[string]bool{}
+	}
+	ru.iterations = 0
+	rows, err := collect(ru.initial)
+	if err != nil {
+		return err
+	}
+	ru.rows, ru.next = ru.distinct(rows), 0
+	return nil
+}
+
+// distinct keeps rows which were not produced before unless all rows are
+// produced
+func (ru *recursiveUnion) distinct(rows []Row) []Row {
+	var result []Row
+	for _, row := range rows {
+		if ru.seen != nil {
+			key := encodeKey(row)
+			if ru.seen[key] {
+				continue
+			}
+			ru.seen[key] = true
+			size := int64(len(key)) + groupSize
+			ru.budget.take(size)
+			ru.reserved += size
+		}
+		result = append(result, row)
+	}
+	return result
+}
+
+func (ru *recursiveUnion) Next() (Row, error) {
+	for ru.next >= len(ru.rows) {
+		if len(ru.rows) == 0 {
+			return nil, nil
+		}
+		ru.working.rows = ru.rows
+		rows, err := collect(ru.recursive)
+		if err != nil {
+			return nil, err
+		}
+		ru.iterations++
+		ru.rows, ru.next = ru.distinct(rows), 0
+		if len(ru.rows) != 0 && ru.iterations > ru.limit {
+			return nil, fmt.Errorf("recursive query %s exceeded limit of %d iterations", ru.name, ru.limit)
+		}
+	}
+	ru.next++
+	return ru.rows[ru.next-1], nil
+}
+
+func (ru *recursiveUnion) Close() error {
+	ru.seen, ru.rows, ru.working.rows = nil, nil, nil
+	ru.budget.release(ru.reserved)
+	ru.reserved = 0
+	return nil
+}
@@ -0,0 +1,365 @@
+package planner
+
+import (
+	"strconv"
+
+	"github.com/VorobevPavel-dev/congenial-disco/function"
+	"github.com/VorobevPavel-dev/congenial-disco/parser"
+	"github.com/VorobevPavel-dev/congenial-disco/tokenizer"
+)
+
+// Rule rewrites logical plan into equivalent one. Rules may modify nodes of
+// given plan in place
+type Rule func(node Node) (Node, error)
+
+// Optimize simplifies plan, pushes conditions down to scans and chooses the
+// cheapest order of joins according to estimator
+func Optimize(node Node, estimator *Estimator) (Node, error) {
+	for _, rule := range []Rule{FoldConstants, PushDownPredicates, estimator.ReorderJoins, PruneColumns} {
+		var err error
+		if node, err = rule(node); err != nil {
+			return nil, err
+		}
+	}
+	return node, nil
+}
+
+// FoldConstants replaces expressions which do not depend on rows (like
+// upper('a') or 1 = 1) with their values. Filters with conditions which are
+// always true are removed.
+func FoldConstants(node Node) (Node, error) {
+	var err error
+	fold := func(expression *parser.Expression) *parser.Expression {
+		if err != nil {
+			return expression
+		}
+		var folded *parser.Expression
+		folded, err = foldExpression(expression)
+		return folded
+	}
+	foldColumns := func(columns []Column) {
+		for index := range columns {
+			if columns[index].Expression != nil {
+				columns[index].Expression = fold(columns[index].Expression)
+			}
+		}
+	}
+
+	if _, err := replaceChildrenWith(node, FoldConstants); err != nil {
+		return nil, err
+	}
+	switch typed := node.(type) {
+	case *Filter:
+		typed.Condition = fold(typed.Condition)
+		if err == nil && isTrue(typed.Condition) {
+			return typed.Input, nil
+		}
+	case *Join:
+		if typed.Condition != nil {
+			typed.Condition = fold(typed.Condition)
+			if err == nil && isTrue(typed.Condition) {
+				typed.Condition = nil
+			}
+		}
+	case *Project:
+		typed.Expressions = foldAll(typed.Expressions, fold)
+		foldColumns(typed.Output)
+	case *Aggregate:
+		typed.GroupBy = foldAll(typed.GroupBy, fold)
+		typed.Aggregates = foldAll(typed.Aggregates, fold)
+		foldColumns(typed.Output)
+	case *Window:
+		typed.Functions = foldAll(typed.Functions, fold)
+		foldColumns(typed.Output)
+	case *Sort:
+		// Terms are shared with statement, so they are copied
+		orderBy := make([]*parser.OrderingTerm, len(typed.OrderBy))
+		for index, term := range typed.OrderBy {
+			folded := *term
+			folded.Expression = fold(term.Expression)
+			orderBy[index] = &folded
+		}
+		typed.OrderBy = orderBy
+	}
+	if err != nil {
+		return nil, err
+	}
+	return node, nil
+}
+
+func foldAll(expressions []*parser.Expression, fold func(*parser.Expression) *parser.Expression) []*parser.Expression {
+	result := make([]*parser.Expression, len(expressions))
+	for index, expression := range expressions {
+		result[index] = fold(expression)
+	}
+	return result
+}
+
+// foldExpression returns copy of expression with constant subexpressions
+// replaced by literals. Boolean constants are kept as they are, since there
+// are no boolean literals.
+func foldExpression(expression *parser.Expression) (*parser.Expression, error) {
+	if expression == nil || expression.Kind == parser.LiteralExpression {
+		return expression, nil
+	}
+	if isConstant(expression) {
+		evaluate, err := compile(expression, nil)
+		if err != nil {
+			return nil, err
+		}
+		value, err := evaluate(nil)
+		if err != nil {
+			return nil, err
+		}
+		switch typed := value.(type) {
+		case int:
+			return &parser.Expression{
+				Kind:  parser.LiteralExpression,
+				Token: &tokenizer.Token{Value: strconv.Itoa(typed), Kind: tokenizer.NumericKind},
+			}, nil
+		case string:
+			return &parser.Expression{
+				Kind:  parser.LiteralExpression,
+				Token: &tokenizer.Token{Value: typed, Kind: tokenizer.StringKind},
+			}, nil
+		case bool:
+			return constantCondition(typed), nil
+		}
+		return expression, nil
+	}
+	folded := *expression
+	var err error
+	if folded.Left, err = foldExpression(expression.Left); err != nil {
+		return nil, err
+	}
+	if folded.Right, err = foldExpression(expression.Right); err != nil {
+		return nil, err
+	}
+	if expression.Arguments != nil {
+		folded.Arguments = make([]*parser.Expression, len(expression.Arguments))
+		for index, argument := range expression.Arguments {
+			if folded.Arguments[index], err = foldExpression(argument); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return &folded, nil
+}
+
+// constantCondition returns condition which is always true or always false
+func constantCondition(value bool) *parser.Expression {
+	operator := "<>"
+	if value {
+		operator = "="
+	}
+	one := &parser.Expression{Kind: parser.LiteralExpression, Token: &tokenizer.Token{Value: "1", Kind: tokenizer.NumericKind}}
+	// "<>" is not a symbol of tokenizer ("!=" is), so token is built directly
+	token := &tokenizer.Token{Value: operator, Kind: tokenizer.SymbolKind}
+	return &parser.Expression{Kind: parser.BinaryExpression, Token: token, Left: one, Right: one}
+}
+
+func isTrue(condition *parser.Expression) bool {
+	if !isConstant(condition) {
+		return false
+	}
+	evaluate, err := compile(condition, nil)
+	if err != nil {
+		return false
+	}
+	value, err := evaluate(nil)
+	return err == nil && value == true
+}
+
+// isConstant checks if expression has the same value for every row: it does
+// not refer to columns, parameters, subqueries, aggregate and volatile
+// functions
+func isConstant(expression *parser.Expression) bool {
+	switch expression.Kind {
+	case parser.LiteralExpression:
+		return true
+	case parser.ColumnExpression, parser.ParameterExpression, parser.SubqueryExpression, parser.ExistsExpression:
+		return false
+	case parser.InExpression:
+		if expression.Subquery != nil {
+			return false
+		}
+	case parser.FunctionExpression:
+		definition, err := function.Builtin.Lookup(expression.Token.Value)
+		if err != nil || definition.Kind != function.ScalarFunction || definition.Volatile {
+			return false
+		}
+	}
+	for _, child := range expression.Children() {
+		if !isConstant(child) {
+			return false
+		}
+	}
+	return true
+}
+
+// PushDownPredicates moves conditions of filters and joins as close to scans
+// as possible, so that rows are filtered out before they are joined
+func PushDownPredicates(node Node) (Node, error) {
+	switch typed := node.(type) {
+	case *Filter:
+		// Merge filters, so that all conditions are pushed together
+		if input, ok := typed.Input.(*Filter); ok {
+			typed.Input = input.Input
+			typed.Condition = conjunction([]*parser.Expression{input.Condition, typed.Condition})
+			return PushDownPredicates(typed)
+		}
+		if join, ok := typed.Input.(*Join); ok {
+			var remaining []*parser.Expression
+			for _, condition := range conjuncts(typed.Condition) {
+				if !pushIntoJoin(join, condition, true) {
+					remaining = append(remaining, condition)
+				}
+			}
+			if remaining == nil {
+				return PushDownPredicates(join)
+			}
+			typed.Condition = conjunction(remaining)
+		}
+	case *Join:
+		if typed.Condition != nil && typed.Kind == InnerJoin {
+			conditions := conjuncts(typed.Condition)
+			typed.Condition = nil
+			for _, condition := range conditions {
+				pushIntoJoin(typed, condition, false)
+			}
+		}
+	}
+	return replaceChildrenWith(node, PushDownPredicates)
+}
+
+// pushIntoJoin pushes condition to the input of join it refers to. Condition
+// referring to both inputs of inner join becomes a part of join condition.
+// Condition which can not be pushed is kept in join condition unless
+// mayReject is set, in which case false is returned.
+func pushIntoJoin(join *Join, condition *parser.Expression, mayReject bool) bool {
+	switch {
+	case refersOnlyTo(condition, join.Left.Columns()):
+		join.Left = &Filter{Input: join.Left, Condition: condition}
+	case join.Kind == InnerJoin && refersOnlyTo(condition, join.Right.Columns()):
+		join.Right = &Filter{Input: join.Right, Condition: condition}
+	case join.Kind == InnerJoin || !mayReject:
+		if join.Condition == nil {
+			join.Condition = condition
+		} else {
+			join.Condition = conjunction([]*parser.Expression{join.Condition, condition})
+		}
+	default:
+		return false
+	}
+	return true
+}
+
+// refersOnlyTo checks if expression can be evaluated over rows with given
+// columns
+func refersOnlyTo(expression *parser.Expression, columns []Column) bool {
+	return check(expression, columns) == nil
+}
+
+// PruneColumns removes columns which are not referenced by any expression of
+// plan from scans, so that they are not read at all
+func PruneColumns(node Node) (Node, error) {
+	referenced := map[Column]bool{}
+	collectReferences(node, referenced)
+	var prune func(node Node)
+	prune = func(node Node) {
+		if scan, ok := node.(*Scan); ok {
+			var output []Column
+			for _, column := range scan.Output {
+				if referenced[Column{Table: column.Table, Name: column.Name}] {
+					output = append(output, column)
+				}
+			}
+			scan.Output = output
+		}
+		for _, child := range node.Children() {
+			prune(child)
+		}
+	}
+	prune(node)
+	return node, nil
+}
+
+// collectReferences resolves columns referenced by expressions of all nodes
+// of plan and marks them in referenced map by table and name
+func collectReferences(node Node, referenced map[Column]bool) {
+	var expressions []*parser.Expression
+	switch typed := node.(type) {
+	case *Filter:
+		expressions = append(expressions, typed.Condition)
+	case *Join:
+		expressions = append(expressions, typed.Condition)
+	case *Project:
+		expressions = append(expressions, typed.Expressions...)
+	case *Aggregate:
+		expressions = append(append(expressions, typed.GroupBy...), typed.Aggregates...)
+	case *Window:
+		expressions = append(expressions, typed.Functions...)
+	case *Apply:
+		if typed.Expression.Kind == parser.InExpression {
+			expressions = append(expressions, typed.Expression.Left)
+		}
+	case *Sort:
+		for _, term := range typed.OrderBy {
+			expressions = append(expressions, term.Expression)
+		}
+	}
+	var input []Column
+	for _, child := range node.Children() {
+		input = append(input, child.Columns()...)
+		collectReferences(child, referenced)
+	}
+	for _, reference := range columnReferences(expressions...) {
+		if index, err := resolveColumn(input, reference); err == nil {
+			referenced[Column{Table: input[index].Table, Name: input[index].Name}] = true
+		}
+	}
+}
+
+// replaceChildrenWith applies rule to children of node and replaces them with
+// results
+func replaceChildrenWith(node Node, rule Rule) (Node, error) {
+	var err error
+	apply := func(child Node) Node {
+		if err != nil {
+			return child
+		}
+		var result Node
+		result, err = rule(child)
+		return result
+	}
+	switch typed := node.(type) {
+	case *Filter:
+		typed.Input = apply(typed.Input)
+	case *Project:
+		typed.Input = apply(typed.Input)
+	case *Join:
+		typed.Left = apply(typed.Left)
+		typed.Right = apply(typed.Right)
+	case *Aggregate:
+		typed.Input = apply(typed.Input)
+	case *Window:
+		typed.Input = apply(typed.Input)
+	case *Sort:
+		typed.Input = apply(typed.Input)
+	case *Limit:
+		typed.Input = apply(typed.Input)
+	case *SetOperation:
+		typed.Left = apply(typed.Left)
+		typed.Right = apply(typed.Right)
+	case *RecursiveUnion:
+		typed.Initial = apply(typed.Initial)
+		typed.Recursive = apply(typed.Recursive)
+	case *Apply:
+		typed.Input = apply(typed.Input)
+		typed.Subquery = apply(typed.Subquery)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return node, nil
+}
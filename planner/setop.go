@@ -0,0 +1,205 @@
+package planner
+
+import (
+	"fmt"
+
+	"github.com/VorobevPavel-dev/congenial-disco/tokenizer"
+)
+
+// setOperation lowers UNION ALL to appending rows of the right input to rows
+// of the left one, other set operations are lowered to hash set operations
+func (l *lowering) setOperation(operation *SetOperation) (Operator, error) {
+	left, err := l.lower(operation.Left)
+	if err != nil {
+		return nil, err
+	}
+	right, err := l.lower(operation.Right)
+	if err != nil {
+		return nil, err
+	}
+	if operation.All {
+		if operation.Operator != tokenizer.UnionKeyword {
+			return nil, fmt.Errorf("ALL is supported only for UNION")
+		}
+		return &appendOperator{inputs: []Operator{left, right}}, nil
+	}
+	switch operation.Operator {
+	case tokenizer.UnionKeyword, tokenizer.IntersectKeyword, tokenizer.ExceptKeyword:
+	default:
+		return nil, fmt.Errorf("unsupported set operator %s", operation.Operator)
+	}
+	return &hashSetOperation{left: left, right: right, operator: operation.Operator, budget: l.budget}, nil
+}
+
+// appendOperator produces rows of its inputs one input after another
+type appendOperator struct {
+	inputs []Operator
+	// current is index of input rows are read from, inputs before it are
+	// closed already
+	current int
+}
+
+func (ao *appendOperator) Open() error {
+	ao.current = 0
+	return ao.inputs[0].Open()
+}
+
+func (ao *appendOperator) Next() (Row, error) {
+	for {
+		row, err := ao.inputs[ao.current].Next()
+		if err != nil || row != nil || ao.current == len(ao.inputs)-1 {
+			return row, err
+		}
+		if err := ao.inputs[ao.current].Close(); err != nil {
+			return nil, err
+		}
+		ao.current++
+		if err := ao.inputs[ao.current].Open(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (ao *appendOperator) Close() error { return ao.inputs[ao.current].Close() }
+
+// hashSetOperation reads rows of both inputs on Open and keeps distinct rows
+// in hash table together with inputs they were read from. UNION produces all
+// of them, INTERSECT the ones read from both inputs and EXCEPT the ones read
+// from the left input only. NULLs are equal to each other like in DISTINCT.
+// Like hashAggregate, rows which do not fit into memory budget are spilled
+// to partitions by hashes of their values and partitions are processed one
+// by one.
+type hashSetOperation struct {
+	left, right Operator
+	operator    string
+	budget      *budget
+
+	rows     []Row
+	next     int
+	reserved int64
+	// pending are partitions of spilled rows, they consist of values of row
+	// followed by index of input it was read from
+	pending []spilledPartition
+}
+
+func (hso *hashSetOperation) Open() error {
+	if err := hso.Close(); err != nil {
+		return err
+	}
+	hso.rows, hso.next = nil, 0
+	return hso.combine(func(consume func(Row) error) error {
+		for side, input := range []Operator{hso.left, hso.right} {
+			err := each(input, func(row Row) error {
+				return consume(append(append(make(Row, 0, len(row)+1), row...), side))
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}, 0)
+}
+
+// combine keeps distinct rows produced by produce function at the given depth
+// of spilling and selects the ones produced by set operation. Rows consist of
+// values followed by index of input, rows which do not fit into memory are
+// spilled to partitions of the next depth
+func (hso *hashSetOperation) combine(produce func(consume func(Row) error) error, depth int) error {
+	type entry struct {
+		row   Row
+		sides [2]bool
+	}
+	var entries []*entry
+	indexes := map[string]*entry{}
+	var spilled *partitions
+	err := produce(func(row Row) error {
+		values, side := row[:len(row)-1], row[len(row)-1].(int)
+		encoded := encodeKey(values)
+		current, ok := indexes[encoded]
+		if !ok {
+			size := rowSize(values) + groupSize
+			switch {
+			case spilled != nil:
+				return spilled.write(encoded, row)
+			case hso.budget.reserve(size):
+			case depth < maxSpillDepth:
+				var err error
+				if spilled, err = newPartitions(hso.budget, depth); err != nil {
+					return err
+				}
+				return spilled.write(encoded, row)
+			default:
+				hso.budget.take(size)
+			}
+			hso.reserved += size
+			current = &entry{row: values}
+			indexes[encoded] = current
+			entries = append(entries, current)
+		}
+		current.sides[side] = true
+		return nil
+	})
+	if err != nil {
+		if spilled != nil {
+			spilled.discard()
+		}
+		return err
+	}
+	for _, current := range entries {
+		var produced bool
+		switch hso.operator {
+		case tokenizer.UnionKeyword:
+			produced = true
+		case tokenizer.IntersectKeyword:
+			produced = current.sides[0] && current.sides[1]
+		case tokenizer.ExceptKeyword:
+			produced = current.sides[0] && !current.sides[1]
+		}
+		if produced {
+			hso.rows = append(hso.rows, current.row)
+		}
+	}
+	if spilled != nil {
+		readers, err := spilled.readers()
+		if err != nil {
+			return err
+		}
+		for _, reader := range readers {
+			hso.pending = append(hso.pending, spilledPartition{reader: reader, depth: depth + 1})
+		}
+	}
+	return nil
+}
+
+func (hso *hashSetOperation) Next() (Row, error) {
+	for hso.next >= len(hso.rows) {
+		if len(hso.pending) == 0 {
+			return nil, nil
+		}
+		partition := hso.pending[0]
+		hso.pending = hso.pending[1:]
+		hso.budget.release(hso.reserved)
+		hso.reserved, hso.rows, hso.next = 0, nil, 0
+		err := hso.combine(func(consume func(Row) error) error {
+			return readRows(partition.reader, consume)
+		}, partition.depth)
+		if err != nil {
+			return nil, err
+		}
+	}
+	hso.next++
+	return hso.rows[hso.next-1], nil
+}
+
+func (hso *hashSetOperation) Close() error {
+	var result error
+	for _, partition := range hso.pending {
+		if err := partition.reader.close(); err != nil && result == nil {
+			result = err
+		}
+	}
+	hso.pending = nil
+	hso.budget.release(hso.reserved)
+	hso.reserved = 0
+	return result
+}
@@ -0,0 +1,146 @@
+package planner
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/VorobevPavel-dev/congenial-disco/parser"
+	"github.com/VorobevPavel-dev/congenial-disco/tokenizer"
+)
+
+// Settings control execution of a query
+type Settings struct {
+	// WorkMemory limits bytes of rows kept by sorts, aggregations and joins
+	// of a query together. Zero means no limit
+	WorkMemory int64
+	// TempDirectory keeps rows spilled to disk, default directory for
+	// temporary files is used if it is empty
+	TempDirectory string
+	// Vectorized makes operators process batches of rows where possible
+	Vectorized bool
+	// MaxParallelWorkers is a number of goroutines scanning partitions of
+	// tables at once, zero and one mean serial execution. Sources must
+	// implement PartitionedSource to be scanned in parallel
+	MaxParallelWorkers int
+	// StatementTimeout cancels queries running longer, zero means no limit
+	StatementTimeout time.Duration
+	// LockTimeout limits waiting for every lock taken by sessions, zero
+	// means waiting until lock is granted or deadlock is detected
+	LockTimeout time.Duration
+	// MaxRecursion limits iterations of recursive common table expressions,
+	// queries which still produce rows after it fail. Zero means the
+	// default limit of 1000 iterations
+	MaxRecursion int
+}
+
+// Apply changes setting named by SET statement:
+//
+//	SET work_memory = bytes;
+//	SET temp_directory = 'path';
+//	SET vectorized = on | off;
+//	SET max_parallel_workers = count;
+//	SET statement_timeout = milliseconds | 'duration';
+//	SET lock_timeout = milliseconds | 'duration';
+//	SET max_recursion = iterations;
+func (s *Settings) Apply(statement *parser.SetStatement) error {
+	name, value := statement.Name.Value, statement.Value.Value
+	switch name {
+	case "statement_timeout", "lock_timeout":
+		// Numbers are milliseconds like in PostgreSQL, strings are durations
+		// like '1m30s'
+		var timeout time.Duration
+		var err error
+		if statement.Value.Kind == tokenizer.NumericKind {
+			var milliseconds int
+			milliseconds, err = strconv.Atoi(value)
+			timeout = time.Duration(milliseconds) * time.Millisecond
+		} else {
+			timeout, err = time.ParseDuration(value)
+		}
+		if err != nil || timeout < 0 {
+			return fmt.Errorf("setting %s expects non-negative duration, got: %s", name, value)
+		}
+		if name == "lock_timeout" {
+			s.LockTimeout = timeout
+		} else {
+			s.StatementTimeout = timeout
+		}
+	case "work_memory", "max_parallel_workers", "max_recursion":
+		number, err := strconv.Atoi(value)
+		if err != nil || number < 0 || statement.Value.Kind != tokenizer.NumericKind {
+			return fmt.Errorf("setting %s expects non-negative number, got: %s", name, value)
+		}
+		switch name {
+		case "work_memory":
+			s.WorkMemory = int64(number)
+		case "max_parallel_workers":
+			s.MaxParallelWorkers = number
+		default:
+			s.MaxRecursion = number
+		}
+	case "temp_directory":
+		s.TempDirectory = value
+	case "vectorized":
+		switch strings.ToLower(value) {
+		case "on", "true":
+			s.Vectorized = true
+		case "off", "false":
+			s.Vectorized = false
+		default:
+			return fmt.Errorf("setting %s expects ON or OFF, got: %s", name, value)
+		}
+	default:
+		return fmt.Errorf("unknown setting %s", name)
+	}
+	return nil
+}
+
+// LowerWith converts logical plan to operators reading tables from source
+// and executing with given settings
+func LowerWith(node Node, source Source, settings Settings) (Operator, error) {
+	return LowerContext(context.Background(), node, source, settings)
+}
+
+// LowerContext is LowerWith which operators return error of context once it
+// is done. StatementTimeout is applied by ExecuteContext, callers running
+// operators themselves should set deadline of context instead
+func LowerContext(ctx context.Context, node Node, source Source, settings Settings) (Operator, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	l := &lowering{
+		source:       source,
+		vectorized:   settings.Vectorized,
+		workers:      settings.MaxParallelWorkers,
+		context:      ctx,
+		maxRecursion: settings.MaxRecursion,
+	}
+	if settings.WorkMemory > 0 {
+		l.budget = &budget{limit: settings.WorkMemory, directory: settings.TempDirectory}
+	}
+	return l.lower(node)
+}
+
+// ExecuteWith lowers plan with LowerWith, runs it and returns all rows it
+// produced
+func ExecuteWith(node Node, source Source, settings Settings) ([]Row, error) {
+	return ExecuteContext(context.Background(), node, source, settings)
+}
+
+// ExecuteContext is ExecuteWith which is aborted with error of context once
+// it is done or after StatementTimeout of settings
+func ExecuteContext(ctx context.Context, node Node, source Source, settings Settings) ([]Row, error) {
+	if settings.StatementTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, settings.StatementTimeout)
+		defer cancel()
+	}
+	operator, err := LowerContext(ctx, node, source, settings)
+	if err != nil {
+		return nil, err
+	}
+	return collect(operator)
+}
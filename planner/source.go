@@ -0,0 +1,112 @@
+package planner
+
+import (
+	"fmt"
+
+	"github.com/VorobevPavel-dev/congenial-disco/columnar"
+	"github.com/VorobevPavel-dev/congenial-disco/engine"
+)
+
+// Source provides rows of tables to scans
+type Source interface {
+	// Scan returns iterator over rows of table consisting of values of
+	// given columns in the same order
+	Scan(table string, columns []string) (Iterator, error)
+}
+
+// Iterator returns rows one by one. Next returns nil row when there are no
+// more rows. Transactions of storage engines return the same iterators
+type Iterator = engine.Iterator
+
+// BatchSource is a Source which reads tables by batches of columns and can
+// skip parts of tables which rows cannot match predicates
+type BatchSource interface {
+	Source
+	ScanBatches(table string, columns []string, predicates []columnar.Predicate) (BatchIterator, error)
+}
+
+// BatchIterator returns batches one by one. NextBatch returns nil batch when
+// there are no more rows
+type BatchIterator interface {
+	NextBatch() (*Batch, error)
+	Close() error
+}
+
+// PartitionedSource is a Source which splits tables into partitions, so that
+// several workers read different rows of a table at once. Partitions of a
+// table together contain every row exactly once
+type PartitionedSource interface {
+	Source
+	ScanPartition(table string, columns []string, partition, partitions int) (Iterator, error)
+}
+
+// MemoryTable is a table which keeps all rows in memory
+type MemoryTable struct {
+	Columns []string
+	Rows    []Row
+}
+
+// MemorySource is a Source of tables kept in memory, keyed by table name
+type MemorySource map[string]*MemoryTable
+
+func (ms MemorySource) Scan(table string, columns []string) (Iterator, error) {
+	return ms.ScanPartition(table, columns, 0, 1)
+}
+
+// ScanPartition reads rows of partition of table, partitions are equal ranges
+// of rows
+func (ms MemorySource) ScanPartition(table string, columns []string, partition, partitions int) (Iterator, error) {
+	data, ok := ms[table]
+	if !ok {
+		return nil, fmt.Errorf("table %s does not exist", table)
+	}
+	indexes := make([]int, len(columns))
+	for position, column := range columns {
+		indexes[position] = -1
+		for index, name := range data.Columns {
+			if name == column {
+				indexes[position] = index
+				break
+			}
+		}
+		if indexes[position] == -1 {
+			return nil, fmt.Errorf("column %s does not exist in table %s", column, table)
+		}
+	}
+	return &memoryIterator{
+		table:   data,
+		indexes: indexes,
+		next:    len(data.Rows) * partition / partitions,
+		end:     len(data.Rows) * (partition + 1) / partitions,
+	}, nil
+}
+
+func (ms MemorySource) RowCount(table string) (int, bool) {
+	data, ok := ms[table]
+	if !ok {
+		return 0, false
+	}
+	return len(data.Rows), true
+}
+
+type memoryIterator struct {
+	table   *MemoryTable
+	indexes []int
+	next    int
+	end     int
+}
+
+func (mi *memoryIterator) Next() (Row, error) {
+	if mi.next >= mi.end {
+		return nil, nil
+	}
+	source := mi.table.Rows[mi.next]
+	mi.next++
+	row := make(Row, len(mi.indexes))
+	for position, index := range mi.indexes {
+		row[position] = source[index]
+	}
+	return row, nil
+}
+
+func (mi *memoryIterator) Close() error { return nil }
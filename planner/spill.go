@@ -0,0 +1,224 @@
+package planner
+
+import (
+	"bufio"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/VorobevPavel-dev/congenial-disco/engine"
+)
+
+// Rows kept by operators are spilled to partitions of files when memory
+// budget is exceeded. Partitions larger than budget are partitioned again
+// up to maxSpillDepth times, after that they are kept in memory anyway
+const (
+	spillFanout   = 8
+	maxSpillDepth = 4
+)
+
+// budget is memory shared by operators of a query. Nil budget has no limit
+type budget struct {
+	limit, used int64
+	directory   string
+	mutex       sync.Mutex
+}
+
+// reserve takes size bytes of budget, it returns false if there is not
+// enough memory left
+func (b *budget) reserve(size int64) bool {
+	if b == nil {
+		return true
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.used+size > b.limit {
+		return false
+	}
+	b.used += size
+	return true
+}
+
+// take takes size bytes of budget even if it exceeds limit
+func (b *budget) take(size int64) {
+	if b == nil {
+		return
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.used += size
+}
+
+func (b *budget) release(size int64) {
+	if b == nil {
+		return
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.used -= size
+}
+
+// rowSize estimates memory taken by row
+func rowSize(row Row) int64 {
+	size := int64(24 + 16*len(row))
+	for _, value := range row {
+		if text, ok := value.(string); ok {
+			size += int64(len(text))
+		}
+	}
+	return size
+}
+
+// encodeKey encodes values so that keys are equal only for equal values of
+// the same types
+func encodeKey(values []interface{}) string {
+	var result strings.Builder
+	for _, value := range values {
+		switch typed := value.(type) {
+		case nil:
+			result.WriteString("n;")
+		case int:
+			result.WriteString("i" + strconv.Itoa(typed) + ";")
+		case string:
+			result.WriteString("s" + strconv.Itoa(len(typed)) + ":" + typed)
+		case bool:
+			result.WriteString("b" + strconv.FormatBool(typed) + ";")
+		default:
+			result.WriteString(fmt.Sprintf("?%v;", typed))
+		}
+	}
+	return result.String()
+}
+
+// partitionOf returns partition of key. Hash depends on depth, so that rows
+// of one partition are split when it is partitioned again
+func partitionOf(key string, depth int) int {
+	hash := fnv.New32a()
+	hash.Write([]byte{byte(depth)})
+	hash.Write([]byte(key))
+	return int(hash.Sum32() % spillFanout)
+}
+
+// spillWriter writes rows to temporary file
+type spillWriter struct {
+	file   *os.File
+	writer *bufio.Writer
+	buffer []byte
+}
+
+func newSpillWriter(b *budget) (*spillWriter, error) {
+	var directory string
+	if b != nil {
+		directory = b.directory
+	}
+	file, err := os.CreateTemp(directory, "spill-")
+	if err != nil {
+		return nil, err
+	}
+	return &spillWriter{file: file, writer: bufio.NewWriter(file)}, nil
+}
+
+func (sw *spillWriter) write(row Row) error {
+	buffer, err := engine.AppendRow(sw.buffer[:0], row)
+	if err != nil {
+		return err
+	}
+	sw.buffer = buffer
+	_, err = sw.writer.Write(buffer)
+	return err
+}
+
+// reader finishes writing and returns reader of written rows
+func (sw *spillWriter) reader() (*spillReader, error) {
+	if err := sw.writer.Flush(); err != nil {
+		sw.discard()
+		return nil, err
+	}
+	if _, err := sw.file.Seek(0, io.SeekStart); err != nil {
+		sw.discard()
+		return nil, err
+	}
+	return &spillReader{file: sw.file, reader: bufio.NewReader(sw.file)}, nil
+}
+
+// discard removes file without reading it
+func (sw *spillWriter) discard() {
+	sw.file.Close()
+	os.Remove(sw.file.Name())
+}
+
+// spillReader reads rows written by spillWriter and removes file when it is
+// closed
+type spillReader struct {
+	file   *os.File
+	reader *bufio.Reader
+}
+
+// read returns the next row or nil after the last one
+func (sr *spillReader) read() (Row, error) {
+	row, err := engine.ReadRow(sr.reader)
+	if err == io.EOF {
+		return nil, nil
+	}
+	return row, err
+}
+
+func (sr *spillReader) close() error {
+	err := sr.file.Close()
+	if removeErr := os.Remove(sr.file.Name()); err == nil {
+		err = removeErr
+	}
+	return err
+}
+
+// partitions spreads rows among spill files by hashes of their keys
+type partitions struct {
+	writers []*spillWriter
+	depth   int
+}
+
+func newPartitions(b *budget, depth int) (*partitions, error) {
+	result := &partitions{depth: depth}
+	for index := 0; index < spillFanout; index++ {
+		writer, err := newSpillWriter(b)
+		if err != nil {
+			result.discard()
+			return nil, err
+		}
+		result.writers = append(result.writers, writer)
+	}
+	return result, nil
+}
+
+func (p *partitions) write(key string, row Row) error {
+	return p.writers[partitionOf(key, p.depth)].write(row)
+}
+
+// readers finishes writing and returns readers of partitions
+func (p *partitions) readers() ([]*spillReader, error) {
+	var result []*spillReader
+	for index, writer := range p.writers {
+		reader, err := writer.reader()
+		if err != nil {
+			for _, opened := range result {
+				opened.close()
+			}
+			for _, rest := range p.writers[index+1:] {
+				rest.discard()
+			}
+			return nil, err
+		}
+		result = append(result, reader)
+	}
+	return result, nil
+}
+
+func (p *partitions) discard() {
+	for _, writer := range p.writers {
+		writer.discard()
+	}
+}
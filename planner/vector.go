@@ -0,0 +1,162 @@
+package planner
+
+import (
+	"fmt"
+
+	"github.com/VorobevPavel-dev/congenial-disco/tokenizer"
+)
+
+// BatchSize is a maximal number of rows in a batch
+const BatchSize = 1024
+
+// boolType is a type of vectors holding results of conditions
+const boolType = "bool"
+
+// Bitmap is a set of row indexes
+type Bitmap []uint64
+
+func newBitmap(length int) Bitmap {
+	return make(Bitmap, (length+63)/64)
+}
+
+func (b Bitmap) Set(index int) { b[index/64] |= 1 << (index % 64) }
+
+func (b Bitmap) Get(index int) bool { return b[index/64]&(1<<(index%64)) != 0 }
+
+// Vector holds values of one column of a batch. Values are stored in the
+// slice matching Type, NULLs are marked in Nulls bitmap. Type is empty while
+// vector has no non-NULL values. Constant vector has the same value for all
+// rows, which is stored at index 0.
+type Vector struct {
+	Type     string
+	Ints     []int
+	Texts    []string
+	Bools    []bool
+	Nulls    Bitmap
+	Constant bool
+	length   int
+}
+
+func newVector(length int) *Vector {
+	return &Vector{Nulls: newBitmap(length), length: length}
+}
+
+// newConstantVector creates vector with the same value in every row
+func newConstantVector(value interface{}) (*Vector, error) {
+	vector := newVector(1)
+	vector.Constant = true
+	return vector, vector.Set(0, value)
+}
+
+// newTypedVector creates vector of given type which values are set later
+func newTypedVector(datatype string, length int) *Vector {
+	vector := newVector(length)
+	vector.setType(datatype)
+	return vector
+}
+
+func (v *Vector) setType(datatype string) {
+	v.Type = datatype
+	switch datatype {
+	case tokenizer.IntType:
+		v.Ints = make([]int, v.length)
+	case tokenizer.TextType:
+		v.Texts = make([]string, v.length)
+	case boolType:
+		v.Bools = make([]bool, v.length)
+	}
+}
+
+// IsNull checks if value of row is NULL
+func (v *Vector) IsNull(index int) bool {
+	if v.Constant {
+		index = 0
+	}
+	return v.Nulls.Get(index)
+}
+
+// Value returns value of row as int, string, bool or nil for NULL
+func (v *Vector) Value(index int) interface{} {
+	if v.Constant {
+		index = 0
+	}
+	if v.Nulls.Get(index) {
+		return nil
+	}
+	switch v.Type {
+	case tokenizer.IntType:
+		return v.Ints[index]
+	case tokenizer.TextType:
+		return v.Texts[index]
+	case boolType:
+		return v.Bools[index]
+	}
+	return nil
+}
+
+// Set sets value of row. The first non-NULL value defines type of vector
+func (v *Vector) Set(index int, value interface{}) error {
+	if value == nil {
+		v.Nulls.Set(index)
+		return nil
+	}
+	var datatype string
+	switch value.(type) {
+	case int:
+		datatype = tokenizer.IntType
+	case string:
+		datatype = tokenizer.TextType
+	case bool:
+		datatype = boolType
+	default:
+		return fmt.Errorf("unsupported value %v (%T)", value, value)
+	}
+	if v.Type == "" {
+		v.setType(datatype)
+	} else if v.Type != datatype {
+		return fmt.Errorf("cannot store %v (%T) in %s vector", value, value, v.Type)
+	}
+	switch typed := value.(type) {
+	case int:
+		v.Ints[index] = typed
+	case string:
+		v.Texts[index] = typed
+	case bool:
+		v.Bools[index] = typed
+	}
+	return nil
+}
+
+// Batch is a set of rows stored by columns. Only rows listed in Selection
+// belong to batch, other rows were filtered out. Nil Selection means all rows
+type Batch struct {
+	Vectors   []*Vector
+	Length    int
+	Selection []int
+}
+
+// allRows lists indexes of all rows of a full batch
+var allRows = func() []int {
+	result := make([]int, BatchSize)
+	for index := range result {
+		result[index] = index
+	}
+	return result
+}()
+
+// Selected returns indexes of rows of batch
+func (b *Batch) Selected() []int {
+	if b.Selection == nil {
+		return allRows[:b.Length]
+	}
+	return b.Selection
+}
+
+// Row returns values of row of batch
+func (b *Batch) Row(index int) Row {
+	row := make(Row, len(b.Vectors))
+	for column, vector := range b.Vectors {
+		row[column] = vector.Value(index)
+	}
+	return row
+}
@@ -0,0 +1,825 @@
+package planner
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/VorobevPavel-dev/congenial-disco/parser"
+	"github.com/VorobevPavel-dev/congenial-disco/tokenizer"
+)
+
+// BatchOperator is an operator of vectorized plan. NextBatch returns the next
+// batch of rows produced by operator or nil after the last one
+type BatchOperator interface {
+	Open() error
+	NextBatch() (*Batch, error)
+	Close() error
+}
+
+// LowerVectorized converts logical plan to operators reading tables from
+// source. Filters, projections and aggregations process batches of rows,
+// the rest of nodes process rows one by one. Tables of BatchSource are read
+// by batches and filters directly above scans let it skip parts of tables
+func LowerVectorized(node Node, source Source) (Operator, error) {
+	return LowerWith(node, source, Settings{Vectorized: true})
+}
+
+// ExecuteVectorized lowers plan with LowerVectorized, runs it and returns all
+// rows it produced
+func ExecuteVectorized(node Node, source Source) ([]Row, error) {
+	operator, err := LowerVectorized(node, source)
+	if err != nil {
+		return nil, err
+	}
+	return collect(operator)
+}
+
+// isVectorized checks if node is processed by batches in vectorized plans.
+// Vectorized aggregation keeps all groups in memory, so aggregations are
+// processed by rows when memory is limited
+func (l *lowering) isVectorized(node Node) bool {
+	switch typed := node.(type) {
+	case *Filter, *Project:
+		return true
+	case *Aggregate:
+		// Aggregation of parallel input is done by workers instead
+		return l.budget == nil && !l.parallel(typed.Input)
+	}
+	return false
+}
+
+// batches converts node of logical plan to batch operator. Nodes which are
+// not vectorized produce rows which are collected into batches
+func (l *lowering) batches(node Node) (BatchOperator, error) {
+	operator, err := l.batchOperator(node)
+	if err != nil {
+		return nil, err
+	}
+	return l.cancellableBatches(operator), nil
+}
+
+func (l *lowering) batchOperator(node Node) (BatchOperator, error) {
+	switch typed := node.(type) {
+	case *Scan:
+		if source, ok := l.source.(BatchSource); ok {
+			return &batchScan{source: source, table: typed.Table, columns: scanColumns(typed)}, nil
+		}
+		scan, err := l.operator(typed)
+		if err != nil {
+			return nil, err
+		}
+		return &rowBatches{input: scan}, nil
+	case *Filter:
+		input, err := l.batches(typed.Input)
+		if err != nil {
+			return nil, err
+		}
+		// Sources reading batches skip parts of table by conditions of
+		// filter, the whole condition is still checked for every row
+		scan := input
+		if wrapped, ok := scan.(*cancellableBatches); ok {
+			scan = wrapped.BatchOperator
+		}
+		if scan, ok := scan.(*batchScan); ok {
+			scan.predicates = zonePredicates(typed.Condition, typed.Input.(*Scan))
+		}
+		condition, err := compileVector(typed.Condition, typed.Input.Columns())
+		if err != nil {
+			return nil, err
+		}
+		return &vectorFilter{input: input, condition: condition}, nil
+	case *Project:
+		input, err := l.batches(typed.Input)
+		if err != nil {
+			return nil, err
+		}
+		expressions := make([]vectorEvaluator, len(typed.Expressions))
+		for index, expression := range typed.Expressions {
+			if expressions[index], err = compileVector(expression, typed.Input.Columns()); err != nil {
+				return nil, err
+			}
+		}
+		return &vectorProject{input: input, expressions: expressions}, nil
+	case *Aggregate:
+		if l.isVectorized(typed) {
+			return l.vectorAggregate(typed)
+		}
+	}
+	input, err := l.lower(node)
+	if err != nil {
+		return nil, err
+	}
+	return &rowBatches{input: input}, nil
+}
+
+// rowBatches collects rows of operator into batches
+type rowBatches struct {
+	input Operator
+}
+
+func (rb *rowBatches) Open() error  { return rb.input.Open() }
+func (rb *rowBatches) Close() error { return rb.input.Close() }
+
+func (rb *rowBatches) NextBatch() (*Batch, error) {
+	var rows []Row
+	for len(rows) < BatchSize {
+		row, err := rb.input.Next()
+		if err != nil {
+			return nil, err
+		}
+		if row == nil {
+			break
+		}
+		rows = append(rows, row)
+	}
+	if rows == nil {
+		return nil, nil
+	}
+	batch := &Batch{Length: len(rows)}
+	for column := range rows[0] {
+		vector := newVector(len(rows))
+		for index, row := range rows {
+			if err := vector.Set(index, row[column]); err != nil {
+				return nil, err
+			}
+		}
+		batch.Vectors = append(batch.Vectors, vector)
+	}
+	return batch, nil
+}
+
+// batchRows returns rows of batches one by one
+type batchRows struct {
+	input BatchOperator
+	batch *Batch
+	next  int
+}
+
+func (br *batchRows) Open() error {
+	br.batch, br.next = nil, 0
+	return br.input.Open()
+}
+
+func (br *batchRows) Close() error { return br.input.Close() }
+
+func (br *batchRows) Next() (Row, error) {
+	for br.batch == nil || br.next >= len(br.batch.Selected()) {
+		batch, err := br.input.NextBatch()
+		if err != nil || batch == nil {
+			return nil, err
+		}
+		br.batch, br.next = batch, 0
+	}
+	br.next++
+	return br.batch.Row(br.batch.Selected()[br.next-1]), nil
+}
+
+// vectorFilter narrows selection of batches to rows for which condition is
+// true. Batches without selected rows are skipped
+type vectorFilter struct {
+	input     BatchOperator
+	condition vectorEvaluator
+}
+
+func (vf *vectorFilter) Open() error  { return vf.input.Open() }
+func (vf *vectorFilter) Close() error { return vf.input.Close() }
+
+func (vf *vectorFilter) NextBatch() (*Batch, error) {
+	for {
+		batch, err := vf.input.NextBatch()
+		if err != nil || batch == nil {
+			return nil, err
+		}
+		condition, err := vf.condition(batch)
+		if err != nil {
+			return nil, err
+		}
+		selected := batch.Selected()
+		selection := make([]int, 0, len(selected))
+		for _, index := range selected {
+			if condition.Value(index) == true {
+				selection = append(selection, index)
+			}
+		}
+		if len(selection) != 0 {
+			return &Batch{Vectors: batch.Vectors, Length: batch.Length, Selection: selection}, nil
+		}
+	}
+}
+
+// vectorProject computes vectors of expressions for batches
+type vectorProject struct {
+	input       BatchOperator
+	expressions []vectorEvaluator
+}
+
+func (vp *vectorProject) Open() error  { return vp.input.Open() }
+func (vp *vectorProject) Close() error { return vp.input.Close() }
+
+func (vp *vectorProject) NextBatch() (*Batch, error) {
+	batch, err := vp.input.NextBatch()
+	if err != nil || batch == nil {
+		return nil, err
+	}
+	result := &Batch{Length: batch.Length, Selection: batch.Selection}
+	for _, expression := range vp.expressions {
+		vector, err := expression(batch)
+		if err != nil {
+			return nil, err
+		}
+		result.Vectors = append(result.Vectors, vector)
+	}
+	return result, nil
+}
+
+// vectorEvaluator computes values of expression for selected rows of batch.
+// Returned vector must not be modified, since it can be a vector of batch
+type vectorEvaluator func(batch *Batch) (*Vector, error)
+
+// compileVector compiles expression to vectorized kernels. Comparisons and
+// logical operators are evaluated by kernels, other expressions are
+// evaluated row by row
+func compileVector(expression *parser.Expression, columns []Column) (vectorEvaluator, error) {
+	if index := computedColumn(columns, expression); index != -1 {
+		return func(batch *Batch) (*Vector, error) { return batch.Vectors[index], nil }, nil
+	}
+	switch expression.Kind {
+	case parser.LiteralExpression:
+		value, err := literalValue(expression.Token)
+		if err != nil {
+			return nil, err
+		}
+		constant, err := newConstantVector(value)
+		if err != nil {
+			return nil, err
+		}
+		return func(*Batch) (*Vector, error) { return constant, nil }, nil
+	case parser.ColumnExpression:
+		if isAsterisk(expression) {
+			break
+		}
+		index, err := resolveColumn(columns, expression)
+		if err != nil {
+			return nil, err
+		}
+		return func(batch *Batch) (*Vector, error) { return batch.Vectors[index], nil }, nil
+	case parser.BinaryExpression:
+		operator := expression.Token.Value
+		if _, ok := comparisonOperators[operator]; !ok && operator != tokenizer.AndKeyword && operator != tokenizer.OrKeyword {
+			break
+		}
+		left, err := compileVector(expression.Left, columns)
+		if err != nil {
+			return nil, err
+		}
+		right, err := compileVector(expression.Right, columns)
+		if err != nil {
+			return nil, err
+		}
+		kernel := func(batch *Batch, left, right *Vector) (*Vector, error) {
+			return compareVectors(operator, left, right, batch)
+		}
+		if operator == tokenizer.AndKeyword || operator == tokenizer.OrKeyword {
+			kernel = func(batch *Batch, left, right *Vector) (*Vector, error) {
+				return combineVectors(operator == tokenizer.OrKeyword, left, right, batch)
+			}
+		}
+		return func(batch *Batch) (*Vector, error) {
+			first, err := left(batch)
+			if err != nil {
+				return nil, err
+			}
+			second, err := right(batch)
+			if err != nil {
+				return nil, err
+			}
+			return kernel(batch, first, second)
+		}, nil
+	case parser.UnaryExpression:
+		operand, err := compileVector(expression.Left, columns)
+		if err != nil {
+			return nil, err
+		}
+		return func(batch *Batch) (*Vector, error) {
+			vector, err := operand(batch)
+			if err != nil {
+				return nil, err
+			}
+			return negateVector(vector, batch)
+		}, nil
+	}
+	return compileRowFallback(expression, columns)
+}
+
+// compileRowFallback evaluates expression for every selected row separately
+func compileRowFallback(expression *parser.Expression, columns []Column) (vectorEvaluator, error) {
+	evaluate, err := compile(expression, columns)
+	if err != nil {
+		return nil, err
+	}
+	return func(batch *Batch) (*Vector, error) {
+		result := newVector(batch.Length)
+		for _, index := range batch.Selected() {
+			value, err := evaluate(batch.Row(index))
+			if err != nil {
+				return nil, err
+			}
+			if err := result.Set(index, value); err != nil {
+				return nil, err
+			}
+		}
+		return result, nil
+	}, nil
+}
+
+// Comparison operators in the order kernels check them
+const (
+	equalOperator = iota
+	notEqualOperator
+	lessOperator
+	lessOrEqualOperator
+	greaterOperator
+	greaterOrEqualOperator
+)
+
+var comparisonOperators = map[string]int{
+	"=":  equalOperator,
+	"<>": notEqualOperator,
+	"!=": notEqualOperator,
+	"<":  lessOperator,
+	"<=": lessOrEqualOperator,
+	">":  greaterOperator,
+	">=": greaterOrEqualOperator,
+}
+
+// compareVectors compares values of vectors in selected rows. Comparisons of
+// ints and texts are done by specialized loops
+func compareVectors(operator string, left, right *Vector, batch *Batch) (*Vector, error) {
+	code, ok := comparisonOperators[operator]
+	if !ok {
+		return nil, fmt.Errorf("unsupported operator %s", operator)
+	}
+	result := newTypedVector(boolType, batch.Length)
+	selected := batch.Selected()
+	switch {
+	case left.Type == tokenizer.IntType && right.Type == tokenizer.IntType:
+		compareInts(code, left, right, selected, result)
+	case left.Type == tokenizer.TextType && right.Type == tokenizer.TextType:
+		compareTexts(code, left, right, selected, result)
+	default:
+		for _, index := range selected {
+			first, second := left.Value(index), right.Value(index)
+			if first == nil || second == nil {
+				result.Nulls.Set(index)
+				continue
+			}
+			order, err := compareValues(first, second)
+			if err != nil {
+				return nil, err
+			}
+			result.Bools[index] = orderMatches(code, order)
+		}
+	}
+	return result, nil
+}
+
+func orderMatches(code int, order int) bool {
+	switch code {
+	case equalOperator:
+		return order == 0
+	case notEqualOperator:
+		return order != 0
+	case lessOperator:
+		return order < 0
+	case lessOrEqualOperator:
+		return order <= 0
+	case greaterOperator:
+		return order > 0
+	}
+	return order >= 0
+}
+
+func compareInts(code int, left, right *Vector, selected []int, result *Vector) {
+	for _, index := range selected {
+		first, second := index, index
+		if left.Constant {
+			first = 0
+		}
+		if right.Constant {
+			second = 0
+		}
+		if left.Nulls.Get(first) || right.Nulls.Get(second) {
+			result.Nulls.Set(index)
+			continue
+		}
+		a, b := left.Ints[first], right.Ints[second]
+		switch code {
+		case equalOperator:
+			result.Bools[index] = a == b
+		case notEqualOperator:
+			result.Bools[index] = a != b
+		case lessOperator:
+			result.Bools[index] = a < b
+		case lessOrEqualOperator:
+			result.Bools[index] = a <= b
+		case greaterOperator:
+			result.Bools[index] = a > b
+		default:
+			result.Bools[index] = a >= b
+		}
+	}
+}
+
+func compareTexts(code int, left, right *Vector, selected []int, result *Vector) {
+	for _, index := range selected {
+		first, second := index, index
+		if left.Constant {
+			first = 0
+		}
+		if right.Constant {
+			second = 0
+		}
+		if left.Nulls.Get(first) || right.Nulls.Get(second) {
+			result.Nulls.Set(index)
+			continue
+		}
+		a, b := left.Texts[first], right.Texts[second]
+		switch code {
+		case equalOperator:
+			result.Bools[index] = a == b
+		case notEqualOperator:
+			result.Bools[index] = a != b
+		case lessOperator:
+			result.Bools[index] = a < b
+		case lessOrEqualOperator:
+			result.Bools[index] = a <= b
+		case greaterOperator:
+			result.Bools[index] = a > b
+		default:
+			result.Bools[index] = a >= b
+		}
+	}
+}
+
+// combineVectors computes AND (or OR if or is set) of boolean vectors with
+// three-valued logic
+func combineVectors(or bool, left, right *Vector, batch *Batch) (*Vector, error) {
+	result := newTypedVector(boolType, batch.Length)
+	for _, index := range batch.Selected() {
+		first, second := left.Value(index), right.Value(index)
+		switch {
+		case first == or || second == or:
+			result.Bools[index] = or
+		case first == nil || second == nil:
+			result.Nulls.Set(index)
+		default:
+			result.Bools[index] = !or
+		}
+	}
+	return result, nil
+}
+
+func negateVector(vector *Vector, batch *Batch) (*Vector, error) {
+	result := newTypedVector(boolType, batch.Length)
+	for _, index := range batch.Selected() {
+		if vector.IsNull(index) {
+			result.Nulls.Set(index)
+			continue
+		}
+		result.Bools[index] = vector.Value(index) != true
+	}
+	return result, nil
+}
+
+// vectorAccumulator computes aggregate function for all groups at once
+type vectorAccumulator struct {
+	function string
+	// counts are numbers of non-NULL values of groups
+	counts []int
+	// sums of groups, they also hold minimums and maximums of int values
+	sums []int
+	// extremes hold minimums and maximums of non-int values
+	extremes []interface{}
+}
+
+func (va *vectorAccumulator) grow() {
+	va.counts = append(va.counts, 0)
+	va.sums = append(va.sums, 0)
+	va.extremes = append(va.extremes, nil)
+}
+
+// update adds values of selected rows of vector to their groups. Nil vector
+// is an argument of count(*)
+func (va *vectorAccumulator) update(vector *Vector, groups []int, selected []int) error {
+	if vector == nil {
+		for _, index := range selected {
+			va.counts[groups[index]]++
+		}
+		return nil
+	}
+	switch va.function {
+	case "count":
+		for _, index := range selected {
+			if !vector.IsNull(index) {
+				va.counts[groups[index]]++
+			}
+		}
+		return nil
+	case "sum", "avg", "min", "max":
+	default:
+		return fmt.Errorf("aggregate function %s is not supported", va.function)
+	}
+	if vector.Type == tokenizer.IntType {
+		for _, index := range selected {
+			position := index
+			if vector.Constant {
+				position = 0
+			}
+			if vector.Nulls.Get(position) {
+				continue
+			}
+			group, value := groups[index], vector.Ints[position]
+			switch {
+			case va.function == "sum" || va.function == "avg":
+				va.sums[group] += value
+			case va.counts[group] == 0,
+				va.function == "min" && value < va.sums[group],
+				va.function == "max" && value > va.sums[group]:
+				va.sums[group] = value
+			}
+			va.counts[group]++
+		}
+		return nil
+	}
+	if va.function == "sum" || va.function == "avg" {
+		for _, index := range selected {
+			if value := vector.Value(index); value != nil {
+				return fmt.Errorf("cannot sum %v (%T)", value, value)
+			}
+		}
+		return nil
+	}
+	for _, index := range selected {
+		value := vector.Value(index)
+		if value == nil {
+			continue
+		}
+		group := groups[index]
+		if va.counts[group] != 0 {
+			order, err := compareValues(value, va.extremes[group])
+			if err != nil {
+				return err
+			}
+			if (order < 0) != (va.function == "min") || order == 0 {
+				va.counts[group]++
+				continue
+			}
+		}
+		va.extremes[group] = value
+		va.counts[group]++
+	}
+	return nil
+}
+
+func (va *vectorAccumulator) result(group int) interface{} {
+	switch {
+	case va.function == "count":
+		return va.counts[group]
+	case va.counts[group] == 0:
+		return nil
+	case va.function == "avg":
+		return va.sums[group] / va.counts[group]
+	case va.extremes[group] != nil:
+		return va.extremes[group]
+	}
+	return va.sums[group]
+}
+
+// vectorAggregate reads all input batches on Open, assigns their rows to
+// groups and updates accumulators of groups vector by vector
+type vectorAggregate struct {
+	input     BatchOperator
+	keys      []vectorEvaluator
+	functions []string
+	// arguments are nil for count(*)
+	arguments []vectorEvaluator
+
+	results []*Batch
+	next    int
+}
+
+func (l *lowering) vectorAggregate(aggregate *Aggregate) (BatchOperator, error) {
+	input, err := l.batches(aggregate.Input)
+	if err != nil {
+		return nil, err
+	}
+	columns := aggregate.Input.Columns()
+	operator := &vectorAggregate{input: input}
+	for _, expression := range aggregate.GroupBy {
+		key, err := compileVector(expression, columns)
+		if err != nil {
+			return nil, err
+		}
+		operator.keys = append(operator.keys, key)
+	}
+	for _, expression := range aggregate.Aggregates {
+		if _, err := newAccumulator(expression.Token.Value); err != nil {
+			return nil, err
+		}
+		if len(expression.Arguments) != 1 {
+			return nil, fmt.Errorf("aggregate function %s must have exactly one argument", formatExpression(expression))
+		}
+		var argument vectorEvaluator
+		if !isAsterisk(expression.Arguments[0]) {
+			if argument, err = compileVector(expression.Arguments[0], columns); err != nil {
+				return nil, err
+			}
+		}
+		operator.functions = append(operator.functions, expression.Token.Value)
+		operator.arguments = append(operator.arguments, argument)
+	}
+	return operator, nil
+}
+
+// groupIndex assigns numbers to groups in the order they are met
+type groupIndex struct {
+	ints   map[int]int
+	texts  map[string]int
+	keys   map[string]int
+	null   int
+	values [][]interface{}
+	buffer strings.Builder
+}
+
+func newGroupIndex() *groupIndex {
+	return &groupIndex{ints: map[int]int{}, texts: map[string]int{}, keys: map[string]int{}, null: -1}
+}
+
+// assign writes numbers of groups of selected rows to groups slice and
+// returns number of groups created
+func (gi *groupIndex) assign(keys []*Vector, selected []int, groups []int) int {
+	created := 0
+	add := func(index int) int {
+		values := make([]interface{}, len(keys))
+		for column, key := range keys {
+			values[column] = key.Value(index)
+		}
+		gi.values = append(gi.values, values)
+		created++
+		return len(gi.values) - 1
+	}
+	// Groups by single int or text column are looked up by value, other
+	// groups are looked up by encoded values of all keys
+	single := len(keys) == 1 && (keys[0].Type == tokenizer.IntType || keys[0].Type == tokenizer.TextType)
+	for _, index := range selected {
+		switch {
+		case single && keys[0].IsNull(index):
+			if gi.null == -1 {
+				gi.null = add(index)
+			}
+			groups[index] = gi.null
+		case single && keys[0].Type == tokenizer.IntType:
+			value := keys[0].Value(index).(int)
+			group, ok := gi.ints[value]
+			if !ok {
+				group = add(index)
+				gi.ints[value] = group
+			}
+			groups[index] = group
+		case single:
+			value := keys[0].Value(index).(string)
+			group, ok := gi.texts[value]
+			if !ok {
+				group = add(index)
+				gi.texts[value] = group
+			}
+			groups[index] = group
+		default:
+			gi.buffer.Reset()
+			for _, key := range keys {
+				switch value := key.Value(index).(type) {
+				case nil:
+					gi.buffer.WriteString("n;")
+				case int:
+					gi.buffer.WriteString("i" + strconv.Itoa(value) + ";")
+				case string:
+					gi.buffer.WriteString("s" + strconv.Itoa(len(value)) + ":" + value)
+				case bool:
+					gi.buffer.WriteString("b" + strconv.FormatBool(value) + ";")
+				}
+			}
+			encoded := gi.buffer.String()
+			// Single NULL keys share group with NULLs of single key lookups
+			if len(keys) == 1 && encoded == "n;" {
+				if gi.null == -1 {
+					gi.null = add(index)
+				}
+				groups[index] = gi.null
+				continue
+			}
+			group, ok := gi.keys[encoded]
+			if !ok {
+				group = add(index)
+				gi.keys[encoded] = group
+			}
+			groups[index] = group
+		}
+	}
+	return created
+}
+
+func (va *vectorAggregate) Open() error {
+	if err := va.input.Open(); err != nil {
+		return err
+	}
+	defer va.input.Close()
+	index := newGroupIndex()
+	accumulators := make([]*vectorAccumulator, len(va.functions))
+	for position, function := range va.functions {
+		accumulators[position] = &vectorAccumulator{function: function}
+	}
+	grow := func(count int) {
+		for ; count > 0; count-- {
+			for _, accumulator := range accumulators {
+				accumulator.grow()
+			}
+		}
+	}
+	// Aggregation without grouping produces a row even for empty input
+	if len(va.keys) == 0 {
+		index.values = append(index.values, nil)
+		grow(1)
+	}
+	groups := make([]int, BatchSize)
+	for {
+		batch, err := va.input.NextBatch()
+		if err != nil {
+			return err
+		}
+		if batch == nil {
+			break
+		}
+		selected := batch.Selected()
+		if len(va.keys) != 0 {
+			keys := make([]*Vector, len(va.keys))
+			for position, key := range va.keys {
+				if keys[position], err = key(batch); err != nil {
+					return err
+				}
+			}
+			grow(index.assign(keys, selected, groups))
+		} else {
+			for _, row := range selected {
+				groups[row] = 0
+			}
+		}
+		for position, argument := range va.arguments {
+			var vector *Vector
+			if argument != nil {
+				if vector, err = argument(batch); err != nil {
+					return err
+				}
+			}
+			if err := accumulators[position].update(vector, groups, selected); err != nil {
+				return err
+			}
+		}
+	}
+
+	va.results, va.next = nil, 0
+	for start := 0; start < len(index.values); start += BatchSize {
+		end := start + BatchSize
+		if end > len(index.values) {
+			end = len(index.values)
+		}
+		batch := &Batch{Length: end - start}
+		for column := 0; column < len(va.keys)+len(accumulators); column++ {
+			vector := newVector(batch.Length)
+			for group := start; group < end; group++ {
+				var value interface{}
+				if column < len(va.keys) {
+					value = index.values[group][column]
+				} else {
+					value = accumulators[column-len(va.keys)].result(group)
+				}
+				if err := vector.Set(group-start, value); err != nil {
+					return err
+				}
+			}
+			batch.Vectors = append(batch.Vectors, vector)
+		}
+		va.results = append(va.results, batch)
+	}
+	return nil
+}
+
+func (va *vectorAggregate) NextBatch() (*Batch, error) {
+	if va.next >= len(va.results) {
+		return nil, nil
+	}
+	va.next++
+	return va.results[va.next-1], nil
+}
+
+func (va *vectorAggregate) Close() error { return nil }
@@ -0,0 +1,449 @@
+package planner
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/VorobevPavel-dev/congenial-disco/parser"
+	"github.com/VorobevPavel-dev/congenial-disco/tokenizer"
+)
+
+// window lowers window node to window operator reading input sorted by
+// partition keys followed by ordering keys, so that rows of every partition
+// are read together in order of window
+func (l *lowering) window(window *Window) (Operator, error) {
+	input, err := l.lower(window.Input)
+	if err != nil {
+		return nil, err
+	}
+	specification := window.Specification()
+	columns := window.Input.Columns()
+	operator := &windowOperator{budget: l.budget, frame: newWindowFrame(specification)}
+	if operator.partition, err = compileAll(specification.PartitionBy, columns); err != nil {
+		return nil, err
+	}
+	for _, term := range specification.OrderBy {
+		key, err := compile(term.Expression, columns)
+		if err != nil {
+			return nil, err
+		}
+		operator.order = append(operator.order, key)
+		operator.descending = append(operator.descending, term.Descending)
+	}
+	if keys := append(append([]evaluator{}, operator.partition...), operator.order...); len(keys) != 0 {
+		descending := append(make([]bool, len(operator.partition)), operator.descending...)
+		input = &sortOperator{input: input, keys: keys, descending: descending, budget: l.budget}
+	}
+	operator.input = input
+	for _, expression := range window.Functions {
+		function := windowFunction{name: expression.Token.Value}
+		if _, ok := windowFunctions[function.name]; !ok {
+			if _, err := newAccumulator(function.name); err != nil {
+				return nil, err
+			}
+		}
+		for _, argument := range expression.Arguments {
+			// count(*) counts all rows, so its argument is never NULL
+			evaluate := evaluator(func(Row) (interface{}, error) { return true, nil })
+			if !isAsterisk(argument) {
+				if evaluate, err = compile(argument, columns); err != nil {
+					return nil, err
+				}
+			}
+			function.arguments = append(function.arguments, evaluate)
+		}
+		operator.functions = append(operator.functions, function)
+	}
+	return operator, nil
+}
+
+// windowFrame is a frame of window with offsets of bounds converted to
+// numbers. Window without frame clause has frame of RANGE BETWEEN UNBOUNDED
+// PRECEDING AND CURRENT ROW, which is the whole partition if window has no
+// ORDER BY, since all rows of partition are peers then
+type windowFrame struct {
+	rows                   bool
+	start, end             parser.FrameBoundKind
+	startOffset, endOffset int
+}
+
+func newWindowFrame(specification *parser.WindowSpecification) windowFrame {
+	frame := windowFrame{start: parser.UnboundedPrecedingBound, end: parser.CurrentRowBound}
+	if specification.Frame == nil {
+		return frame
+	}
+	frame.rows = specification.Frame.Unit.Value == tokenizer.RowsKeyword
+	frame.start, frame.end = specification.Frame.Start.Kind, specification.Frame.End.Kind
+	// Offsets are non-negative numbers checked by parser
+	if specification.Frame.Start.Offset != nil {
+		frame.startOffset, _ = strconv.Atoi(specification.Frame.Start.Offset.Value)
+	}
+	if specification.Frame.End.Offset != nil {
+		frame.endOffset, _ = strconv.Atoi(specification.Frame.End.Offset.Value)
+	}
+	return frame
+}
+
+// windowFunction is a window or aggregate function with compiled arguments
+type windowFunction struct {
+	name      string
+	arguments []evaluator
+}
+
+// windowFunctions compute values of window functions for all rows of a
+// partition, functions not listed here are aggregates computed over frames
+var windowFunctions = map[string]func(wp *windowPartition, function windowFunction) ([]interface{}, error){
+	"row_number": func(wp *windowPartition, _ windowFunction) ([]interface{}, error) {
+		values := make([]interface{}, len(wp.rows))
+		for index := range values {
+			values[index] = index + 1
+		}
+		return values, nil
+	},
+	"rank": func(wp *windowPartition, _ windowFunction) ([]interface{}, error) {
+		values := make([]interface{}, len(wp.rows))
+		for index := range values {
+			values[index] = wp.peerStart[index] + 1
+		}
+		return values, nil
+	},
+	"dense_rank": func(wp *windowPartition, _ windowFunction) ([]interface{}, error) {
+		values := make([]interface{}, len(wp.rows))
+		rank := 0
+		for index := range values {
+			if wp.peerStart[index] == index {
+				rank++
+			}
+			values[index] = rank
+		}
+		return values, nil
+	},
+	"lag":  offsetFunction(-1),
+	"lead": offsetFunction(1),
+	"first_value": func(wp *windowPartition, function windowFunction) ([]interface{}, error) {
+		arguments, err := wp.evaluate(function.arguments[0])
+		if err != nil {
+			return nil, err
+		}
+		values := make([]interface{}, len(wp.rows))
+		for index := range values {
+			start, end, err := wp.bounds(index)
+			if err != nil {
+				return nil, err
+			}
+			if start < end {
+				values[index] = arguments[start]
+			}
+		}
+		return values, nil
+	},
+}
+
+// offsetFunction returns LAG (direction is -1) or LEAD (direction is 1)
+// function. Their value is read from row at given offset (1 by default) from
+// the current row, default value (NULL by default) is used if there is no
+// such row in partition
+func offsetFunction(direction int) func(wp *windowPartition, function windowFunction) ([]interface{}, error) {
+	return func(wp *windowPartition, function windowFunction) ([]interface{}, error) {
+		arguments, err := wp.evaluate(function.arguments[0])
+		if err != nil {
+			return nil, err
+		}
+		values := make([]interface{}, len(wp.rows))
+		for index, row := range wp.rows {
+			offset := 1
+			if len(function.arguments) > 1 {
+				value, err := function.arguments[1](row)
+				if err != nil {
+					return nil, err
+				}
+				number, ok := value.(int)
+				if !ok {
+					return nil, fmt.Errorf("offset of %s must be a number, got: %v", function.name, value)
+				}
+				offset = number
+			}
+			if target := index + direction*offset; target >= 0 && target < len(wp.rows) {
+				values[index] = arguments[target]
+			} else if len(function.arguments) > 2 {
+				if values[index], err = function.arguments[2](row); err != nil {
+					return nil, err
+				}
+			}
+		}
+		return values, nil
+	}
+}
+
+// windowOperator reads rows sorted by partition and ordering keys, keeps rows
+// of one partition at a time and computes all functions of the window for
+// them. Rows with equal ordering keys are peers: they have the same rank and
+// are in the same RANGE frames
+type windowOperator struct {
+	input      Operator
+	partition  []evaluator
+	order      []evaluator
+	descending []bool
+	frame      windowFrame
+	functions  []windowFunction
+	budget     *budget
+
+	rows []Row
+	next int
+	// pending is the first row of the next partition
+	pending  Row
+	reserved int64
+}
+
+// windowPartition is a partition of rows sorted by ordering keys
+type windowPartition struct {
+	rows  []Row
+	frame windowFrame
+	// keys are values of ordering keys of rows, descending is set if rows
+	// are sorted by the first of them in descending order
+	keys       [][]interface{}
+	descending bool
+	// peerStart and peerEnd are bounds of peers of rows
+	peerStart, peerEnd []int
+}
+
+func (wo *windowOperator) Open() error {
+	wo.release()
+	wo.rows, wo.next, wo.pending = nil, 0, nil
+	if err := wo.input.Open(); err != nil {
+		return err
+	}
+	row, err := wo.input.Next()
+	wo.pending = row
+	return err
+}
+
+func (wo *windowOperator) Next() (Row, error) {
+	for wo.next >= len(wo.rows) {
+		if wo.pending == nil {
+			return nil, nil
+		}
+		if err := wo.advance(); err != nil {
+			return nil, err
+		}
+	}
+	wo.next++
+	return wo.rows[wo.next-1], nil
+}
+
+// advance reads rows of the next partition and computes functions for them
+func (wo *windowOperator) advance() error {
+	wo.release()
+	key, err := evaluateAll(wo.partition, wo.pending)
+	if err != nil {
+		return err
+	}
+	partitionKey := encodeKey(key)
+	partition := &windowPartition{frame: wo.frame, descending: len(wo.descending) != 0 && wo.descending[0]}
+	for row := wo.pending; row != nil; {
+		size := rowSize(row)
+		wo.budget.take(size)
+		wo.reserved += size
+		partition.rows = append(partition.rows, row)
+		if row, err = wo.input.Next(); err != nil || row == nil {
+			wo.pending = nil
+			break
+		}
+		if key, err = evaluateAll(wo.partition, row); err != nil {
+			return err
+		}
+		if encodeKey(key) != partitionKey {
+			wo.pending = row
+			break
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if err := partition.findPeers(wo.order); err != nil {
+		return err
+	}
+
+	wo.rows, wo.next = make([]Row, len(partition.rows)), 0
+	for index, row := range partition.rows {
+		wo.rows[index] = append(make(Row, 0, len(row)+len(wo.functions)), row...)
+	}
+	for _, function := range wo.functions {
+		compute, ok := windowFunctions[function.name]
+		if !ok {
+			compute = aggregateOverFrames
+		}
+		values, err := compute(partition, function)
+		if err != nil {
+			return err
+		}
+		for index, value := range values {
+			wo.rows[index] = append(wo.rows[index], value)
+		}
+	}
+	return nil
+}
+
+// release returns memory of rows of the current partition to budget
+func (wo *windowOperator) release() {
+	wo.budget.release(wo.reserved)
+	wo.reserved = 0
+}
+
+func (wo *windowOperator) Close() error {
+	wo.release()
+	wo.rows, wo.pending = nil, nil
+	return wo.input.Close()
+}
+
+// findPeers evaluates ordering keys of rows and finds bounds of their peers
+func (wp *windowPartition) findPeers(order []evaluator) error {
+	count := len(wp.rows)
+	wp.keys = make([][]interface{}, count)
+	wp.peerStart, wp.peerEnd = make([]int, count), make([]int, count)
+	var previous string
+	for index, row := range wp.rows {
+		key, err := evaluateAll(order, row)
+		if err != nil {
+			return err
+		}
+		wp.keys[index] = key
+		encoded := encodeKey(key)
+		if index == 0 || encoded != previous {
+			wp.peerStart[index] = index
+		} else {
+			wp.peerStart[index] = wp.peerStart[index-1]
+		}
+		previous = encoded
+	}
+	for index := count - 1; index >= 0; index-- {
+		if index == count-1 || wp.peerStart[index+1] != wp.peerStart[index] {
+			wp.peerEnd[index] = index + 1
+		} else {
+			wp.peerEnd[index] = wp.peerEnd[index+1]
+		}
+	}
+	return nil
+}
+
+// evaluate computes expression for every row of partition
+func (wp *windowPartition) evaluate(expression evaluator) ([]interface{}, error) {
+	values := make([]interface{}, len(wp.rows))
+	for index, row := range wp.rows {
+		var err error
+		if values[index], err = expression(row); err != nil {
+			return nil, err
+		}
+	}
+	return values, nil
+}
+
+// bounds returns bounds of frame of row: indexes of its first row and of the
+// row following the last one. Frame is empty if start is not less than end
+func (wp *windowPartition) bounds(index int) (start, end int, err error) {
+	if start, err = wp.bound(index, wp.frame.start, wp.frame.startOffset, false); err != nil {
+		return 0, 0, err
+	}
+	if end, err = wp.bound(index, wp.frame.end, wp.frame.endOffset, true); err != nil {
+		return 0, 0, err
+	}
+	return start, end, nil
+}
+
+// bound returns index of the first row of frame or, if end is set, index of
+// the row following the last row of frame
+func (wp *windowPartition) bound(index int, kind parser.FrameBoundKind, offset int, end bool) (int, error) {
+	count := len(wp.rows)
+	switch kind {
+	case parser.UnboundedPrecedingBound:
+		return 0, nil
+	case parser.UnboundedFollowingBound:
+		return count, nil
+	case parser.PrecedingBound:
+		offset = -offset
+	case parser.CurrentRowBound:
+		offset = 0
+	}
+	if wp.frame.rows {
+		position := index + offset
+		if end {
+			position++
+		}
+		if position < 0 {
+			return 0, nil
+		} else if position > count {
+			return count, nil
+		}
+		return position, nil
+	}
+	if kind == parser.CurrentRowBound || wp.keys[index][0] == nil {
+		if end {
+			return wp.peerEnd[index], nil
+		}
+		return wp.peerStart[index], nil
+	}
+	// RANGE frame with offset includes rows which value of the only ordering
+	// key differs from value of the current row by offset at most. Distances
+	// of sorted rows from the current one grow, NULLs are the last in
+	// ascending order and the first in descending one
+	current, ok := wp.keys[index][0].(int)
+	if !ok {
+		return 0, fmt.Errorf("RANGE with offset requires numeric ORDER BY column, got: %v", wp.keys[index][0])
+	}
+	var searchErr error
+	distance := func(position int) int {
+		value := wp.keys[position][0]
+		number, ok := value.(int)
+		switch {
+		case value == nil && wp.descending:
+			return -int(^uint(0) >> 1)
+		case value == nil:
+			return int(^uint(0) >> 1)
+		case !ok:
+			searchErr = fmt.Errorf("RANGE with offset requires numeric ORDER BY column, got: %v", value)
+		case wp.descending:
+			return current - number
+		}
+		return number - current
+	}
+	position := sort.Search(count, func(position int) bool {
+		if end {
+			return distance(position) > offset
+		}
+		return distance(position) >= offset
+	})
+	return position, searchErr
+}
+
+// aggregateOverFrames computes aggregate function over frames of rows. Frames
+// starting with the first row of partition only grow, so their aggregates are
+// computed incrementally
+func aggregateOverFrames(wp *windowPartition, function windowFunction) ([]interface{}, error) {
+	arguments, err := wp.evaluate(function.arguments[0])
+	if err != nil {
+		return nil, err
+	}
+	values := make([]interface{}, len(wp.rows))
+	var (
+		running accumulator
+		added   int
+	)
+	for index := range wp.rows {
+		start, end, err := wp.bounds(index)
+		if err != nil {
+			return nil, err
+		}
+		if start != 0 || running == nil {
+			running, _ = newAccumulator(function.name)
+			added = start
+		}
+		for ; added < end; added++ {
+			if err := running.add(arguments[added]); err != nil {
+				return nil, err
+			}
+		}
+		values[index] = running.result()
+	}
+	return values, nil
+}
@@ -0,0 +1,130 @@
+package session
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/VorobevPavel-dev/congenial-disco/engine"
+	"github.com/VorobevPavel-dev/congenial-disco/lock"
+	"github.com/VorobevPavel-dev/congenial-disco/parser"
+	"github.com/VorobevPavel-dev/congenial-disco/planner"
+)
+
+// lockingTransaction takes exclusive locks on rows it changes and releases
+// all its locks when it ends, so that rows locked by other sessions with
+// FOR UPDATE, FOR SHARE or LOCK TABLE are not changed. Reads do not take
+// locks, since transactions read snapshots
+type lockingTransaction struct {
+	engine.Transaction
+	session *Session
+	id      lock.TransactionID
+}
+
+// rowResource returns resource of row with key of table. Keys of different
+// types get different resources
+func rowResource(table string, key interface{}) lock.Resource {
+	return lock.Row(table, fmt.Sprintf("%#v", key))
+}
+
+// lock waits for lock no longer than lock timeout of session. Waiting
+// transaction chosen as a victim of deadlock gets lock.ErrDeadlock
+func (lt *lockingTransaction) lock(resource lock.Resource, mode lock.Mode) error {
+	return lt.session.database.locks.Lock(lt.id, resource, mode, lt.session.Settings.LockTimeout)
+}
+
+func (lt *lockingTransaction) Insert(table string, rows []engine.Row) error {
+	for _, row := range rows {
+		if len(row) == 0 {
+			continue
+		}
+		if err := lt.lock(rowResource(table, row[0]), lock.Exclusive); err != nil {
+			return err
+		}
+	}
+	return lt.Transaction.Insert(table, rows)
+}
+
+func (lt *lockingTransaction) Delete(table string, key interface{}) error {
+	if err := lt.lock(rowResource(table, key), lock.Exclusive); err != nil {
+		return err
+	}
+	return lt.Transaction.Delete(table, key)
+}
+
+func (lt *lockingTransaction) Commit() error {
+	defer lt.session.database.locks.Release(lt.id)
+	return lt.Transaction.Commit()
+}
+
+func (lt *lockingTransaction) Rollback() error {
+	defer lt.session.database.locks.Release(lt.id)
+	return lt.Transaction.Rollback()
+}
+
+// lockTable executes LOCK TABLE statement
+func (lt *lockingTransaction) lockTable(statement *parser.LockTableStatement, schema parser.Schema) error {
+	if _, ok := schema[statement.Table.Value]; !ok {
+		return fmt.Errorf("table %s does not exist", statement.Table.Value)
+	}
+	return lt.lock(lock.Table(statement.Table.Value), statement.Mode)
+}
+
+// lockRows locks rows read by query with FOR UPDATE or FOR SHARE clause
+// before query is executed. Query of one table locks rows matching its
+// WHERE clause, which may be more rows than it returns with LIMIT. Query
+// joining tables or reading derived table locks all tables it reads
+func (lt *lockingTransaction) lockRows(ctx context.Context, slct *parser.SelectStatement, schema parser.Schema,
+	settings planner.Settings) error {
+	mode, ok := slct.RowLockMode()
+	if !ok {
+		return nil
+	}
+	if slct.FromSubquery != nil || len(slct.Joins) != 0 {
+		for _, table := range readTables(slct) {
+			if err := lt.lock(lock.Table(table), mode); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	definition, ok := schema[slct.From.Value]
+	if !ok {
+		return fmt.Errorf("table %s does not exist", slct.From.Value)
+	}
+	key := definition.Cols[0].Name
+	keys := &parser.Statement{SelectStatement: &parser.SelectStatement{
+		Item:  []*parser.Expression{{Kind: parser.ColumnExpression, Token: &key}},
+		From:  slct.From,
+		Where: slct.Where,
+	}}
+	node, err := planner.PlanContext(ctx, keys, schema)
+	if err != nil {
+		return err
+	}
+	rows, err := planner.ExecuteContext(ctx, node, lt, settings)
+	if err != nil {
+		return err
+	}
+	for _, row := range rows {
+		if err := lt.lock(rowResource(slct.From.Value, row[0]), mode); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readTables returns tables read by FROM and JOIN clauses of statement and
+// of its derived tables
+func readTables(slct *parser.SelectStatement) []string {
+	var tables []string
+	if slct.FromSubquery != nil {
+		tables = readTables(slct.FromSubquery)
+	} else {
+		tables = append(tables, slct.From.Value)
+	}
+	for _, join := range slct.Joins {
+		tables = append(tables, join.Table.Value)
+	}
+	return tables
+}
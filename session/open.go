@@ -0,0 +1,84 @@
+package session
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/VorobevPavel-dev/congenial-disco/engine"
+	"github.com/VorobevPavel-dev/congenial-disco/parser"
+)
+
+const schemaFile = "SCHEMA"
+
+// Open opens database stored in directory. Tables of row and LSM storages
+// are kept in one LSM tree, so that all of them survive restart. Engines do
+// not keep types of columns, so definitions of tables are saved to schema
+// file of directory after every CREATE TABLE
+func Open(directory string) (*Database, error) {
+	tree, err := engine.OpenLSMEngine(directory, nil)
+	if err != nil {
+		return nil, err
+	}
+	schema, err := loadSchema(directory)
+	if err != nil {
+		tree.Close()
+		return nil, err
+	}
+	database := New(engine.NewEngines(map[string]engine.StorageEngine{
+		parser.RowStorage: tree,
+		parser.LSMStorage: tree,
+	}), schema)
+	database.directory = directory
+	return database, nil
+}
+
+// loadSchema reads definitions of tables saved by saveSchema, schema is
+// empty for directory without schema file
+func loadSchema(directory string) (parser.Schema, error) {
+	data, err := os.ReadFile(filepath.Join(directory, schemaFile))
+	if os.IsNotExist(err) {
+		return parser.NewSchema(), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var tables []*parser.CreateTableStatement
+	if err := json.Unmarshal(data, &tables); err != nil {
+		return nil, fmt.Errorf("cannot read schema: %v", err)
+	}
+	return parser.NewSchema(tables...), nil
+}
+
+// saveSchema writes definitions of tables to temporary file and renames it
+// to replace previous version at once
+func saveSchema(directory string, schema parser.Schema) error {
+	tables := make([]*parser.CreateTableStatement, 0, len(schema))
+	for _, table := range schema {
+		tables = append(tables, table)
+	}
+	sort.Slice(tables, func(i, j int) bool { return tables[i].Name.Value < tables[j].Name.Value })
+	data, err := json.Marshal(tables)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(directory, schemaFile)
+	file, err := os.Create(path + ".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
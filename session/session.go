@@ -0,0 +1,410 @@
+// Package session executes statements of clients. Sessions of one database
+// share its tables and running queries, while every session has its own
+// settings and transaction.
+package session
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/VorobevPavel-dev/congenial-disco/engine"
+	"github.com/VorobevPavel-dev/congenial-disco/lock"
+	"github.com/VorobevPavel-dev/congenial-disco/parser"
+	"github.com/VorobevPavel-dev/congenial-disco/planner"
+	"github.com/VorobevPavel-dev/congenial-disco/statistics"
+)
+
+// deadlockInterval is a period of looking for deadlocks of sessions
+const deadlockInterval = 100 * time.Millisecond
+
+var (
+	errNoTransaction = errors.New("there is no transaction in progress")
+	// errTransactionAborted is returned for statements of transaction which
+	// had a failed statement until it is rolled back
+	errTransactionAborted = errors.New("current transaction is aborted, statements are ignored until ROLLBACK")
+)
+
+// Database is a set of tables kept by storage engines and shared by sessions
+type Database struct {
+	engines *engine.Engines
+	queries *planner.Queries
+	catalog *statistics.Catalog
+	locks   *lock.Manager
+	// stopDetection stops looking for deadlocks
+	stopDetection func()
+	// directory keeps schema of database opened with Open
+	directory string
+
+	mutex sync.Mutex
+	// schema is replaced on every CREATE TABLE, so that sessions plan
+	// queries with schema they got without locking
+	schema parser.Schema
+	// last is id of locks of the latest transaction
+	last lock.TransactionID
+}
+
+// New creates database of tables of engines. Engines do not keep types of
+// columns, so schema must define tables which already exist in them
+func New(engines *engine.Engines, schema parser.Schema) *Database {
+	if schema == nil {
+		schema = parser.NewSchema()
+	}
+	locks := lock.NewManager()
+	return &Database{
+		engines:       engines,
+		queries:       planner.NewQueries(),
+		catalog:       statistics.NewCatalog(),
+		locks:         locks,
+		stopDetection: locks.DetectDeadlocksEvery(deadlockInterval),
+		schema:        schema,
+	}
+}
+
+// Running returns ids of queries running in sessions of database, which
+// can be canceled with CANCEL statement
+func (d *Database) Running() []planner.QueryID {
+	return d.queries.Running()
+}
+
+func (d *Database) Close() error {
+	d.stopDetection()
+	return d.engines.Close()
+}
+
+func (d *Database) tables() parser.Schema {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return d.schema
+}
+
+func (d *Database) createTable(statement *parser.CreateTableStatement) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if err := d.engines.CreateTable(statement); err != nil {
+		return err
+	}
+	schema := make(parser.Schema, len(d.schema)+1)
+	for name, table := range d.schema {
+		schema[name] = table
+	}
+	schema[statement.Name.Value] = statement
+	d.schema = schema
+	if d.directory != "" {
+		return saveSchema(d.directory, schema)
+	}
+	return nil
+}
+
+// Result is a result of statement. Queries and EXPLAIN return rows with
+// their columns, INSERT returns number of added rows
+type Result struct {
+	Columns      []planner.Column
+	Rows         []planner.Row
+	RowsAffected int
+}
+
+// Session executes statements of one client one by one. Changes made
+// between BEGIN and COMMIT become visible to other sessions at once on
+// COMMIT and are discarded by ROLLBACK, other statements run in
+// transactions of their own.
+type Session struct {
+	database *Database
+	// Settings are changed by SET statements
+	Settings planner.Settings
+
+	// isolation is isolation level of transactions set by SET TRANSACTION
+	// outside of transaction
+	isolation   string
+	transaction *lockingTransaction
+	savepoints  []savepoint
+	// used is set once a statement of transaction is executed, failed once
+	// it fails
+	used, failed bool
+}
+
+// savepoint is a named savepoint of transaction of session
+type savepoint struct {
+	name string
+	mark int
+}
+
+func (d *Database) NewSession() *Session {
+	return &Session{database: d}
+}
+
+// Close rolls back transaction of session if it is in progress
+func (s *Session) Close() error {
+	if s.transaction == nil {
+		return nil
+	}
+	return s.finish(false)
+}
+
+// InTransaction checks if session is between BEGIN and COMMIT or ROLLBACK
+func (s *Session) InTransaction() bool {
+	return s.transaction != nil
+}
+
+// Execute executes statement. Failed statement of transaction aborts it:
+// nothing but ROLLBACK or COMMIT, which rolls it back too, is executed
+// until then. Tables are not created in transactions, since CREATE TABLE
+// takes effect at once.
+func (s *Session) Execute(ctx context.Context, statement *parser.Statement) (*Result, error) {
+	if statement.TransactionStatement != nil {
+		return &Result{}, s.control(statement.TransactionStatement)
+	}
+	if s.failed {
+		return nil, errTransactionAborted
+	}
+	switch {
+	case statement.CreateTableStatement != nil:
+		if s.transaction != nil {
+			return nil, fmt.Errorf("CREATE TABLE cannot run inside a transaction")
+		}
+		return &Result{}, s.database.createTable(statement.CreateTableStatement)
+	case statement.SetStatement != nil:
+		return &Result{}, s.Settings.Apply(statement.SetStatement)
+	case statement.CancelStatement != nil:
+		return &Result{}, s.database.queries.Execute(statement.CancelStatement)
+	case statement.LockTableStatement != nil && s.transaction == nil:
+		return nil, fmt.Errorf("LOCK TABLE can only be used in transaction")
+	}
+
+	if s.transaction != nil {
+		s.used = true
+		result, err := s.execute(ctx, s.transaction, statement)
+		if err != nil {
+			s.failed = true
+		}
+		return result, err
+	}
+	transaction, err := s.begin(s.isolation)
+	if err != nil {
+		return nil, err
+	}
+	result, err := s.execute(ctx, transaction, statement)
+	if err != nil {
+		transaction.Rollback()
+		return nil, err
+	}
+	if err := transaction.Commit(); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// ExecuteScript parses request of several statements and executes them in
+// order until one of them fails. When statement fails in transaction with
+// savepoints, the transaction is rolled back to the latest savepoint instead
+// of being aborted, so that the rest of its changes can still be committed
+func (s *Session) ExecuteScript(ctx context.Context, request string) ([]*Result, error) {
+	statements, err := parser.ParseScriptContext(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+	var results []*Result
+	for index, statement := range statements {
+		result, err := s.Execute(ctx, statement)
+		if err != nil {
+			if s.failed && len(s.savepoints) != 0 {
+				if rollbackErr := s.rollbackTo(s.savepoints[len(s.savepoints)-1].name); rollbackErr != nil {
+					err = fmt.Errorf("%v, rolling back to savepoint failed: %v", err, rollbackErr)
+				}
+			}
+			return results, fmt.Errorf("statement #%d: %v", index+1, err)
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// execute runs statement reading and changing tables in transaction
+func (s *Session) execute(ctx context.Context, transaction *lockingTransaction, statement *parser.Statement) (*Result, error) {
+	schema := s.database.tables()
+	switch {
+	case statement.SelectStatement != nil, statement.CompoundStatement != nil:
+		_, ctx, finish := s.database.queries.StartIn(ctx, transaction, s.Settings.StatementTimeout)
+		defer finish()
+		if statement.SelectStatement != nil {
+			if err := transaction.lockRows(ctx, statement.SelectStatement, schema, s.Settings); err != nil {
+				return nil, err
+			}
+		}
+		node, err := planner.PlanContext(ctx, statement, schema)
+		if err != nil {
+			return nil, err
+		}
+		if node, err = planner.Optimize(node, planner.NewEstimator(s.database.catalog, nil)); err != nil {
+			return nil, err
+		}
+		rows, err := planner.ExecuteContext(ctx, node, transaction, s.Settings)
+		if err != nil {
+			return nil, err
+		}
+		return &Result{Columns: node.Columns(), Rows: rows}, nil
+	case statement.InsertStatement != nil:
+		if err := planner.ExecuteInsert(transaction, statement.InsertStatement); err != nil {
+			return nil, err
+		}
+		return &Result{RowsAffected: 1}, nil
+	case statement.ExplainStatement != nil:
+		text, err := planner.ExplainStatement(statement.ExplainStatement, schema, transaction, s.database.catalog)
+		if err != nil {
+			return nil, err
+		}
+		return &Result{
+			Columns: []planner.Column{{Name: "plan", Type: "text"}},
+			Rows:    []planner.Row{{text}},
+		}, nil
+	case statement.AnalyzeStatement != nil:
+		return &Result{}, planner.Analyze(statement.AnalyzeStatement, schema, transaction, s.database.catalog)
+	case statement.LockTableStatement != nil:
+		return &Result{}, transaction.lockTable(statement.LockTableStatement, schema)
+	}
+	return nil, fmt.Errorf("statement is not supported")
+}
+
+// control executes transaction control statement. Serializable
+// transactions fail with engine.ErrSerializationFailure when they might
+// produce result which no serial order of concurrent serializable
+// transactions produces, transactions of other levels read snapshots,
+// which satisfies them all
+func (s *Session) control(statement *parser.TransactionStatement) error {
+	switch statement.Action.Value {
+	case "begin":
+		if s.transaction != nil {
+			return fmt.Errorf("there is already a transaction in progress")
+		}
+		transaction, err := s.begin(statement.IsolationLevel)
+		if err != nil {
+			return err
+		}
+		s.transaction = transaction
+		return nil
+	case "commit":
+		if s.transaction == nil {
+			return errNoTransaction
+		}
+		if s.failed {
+			s.finish(false)
+			return fmt.Errorf("transaction is rolled back since one of its statements failed")
+		}
+		return s.finish(true)
+	case "rollback":
+		if s.transaction == nil {
+			return errNoTransaction
+		}
+		if statement.Savepoint != nil {
+			return s.rollbackTo(statement.Savepoint.Value)
+		}
+		return s.finish(false)
+	case "set":
+		// Level of transaction is set before its first statement, outside
+		// of transaction it is set for the following ones
+		if s.transaction == nil {
+			s.isolation = statement.IsolationLevel
+			return nil
+		}
+		if s.used {
+			return fmt.Errorf("SET TRANSACTION ISOLATION LEVEL must be called before any statement of transaction")
+		}
+		transaction, err := s.begin(statement.IsolationLevel)
+		if err != nil {
+			return err
+		}
+		s.transaction.Rollback()
+		s.transaction = transaction
+		return nil
+	case "savepoint":
+		if s.transaction == nil {
+			return errNoTransaction
+		}
+		if s.failed {
+			return errTransactionAborted
+		}
+		mark, err := s.transaction.Savepoint()
+		if err != nil {
+			return err
+		}
+		s.used = true
+		s.savepoints = append(s.savepoints, savepoint{name: statement.Savepoint.Value, mark: mark})
+		return nil
+	case "release":
+		if s.transaction == nil {
+			return errNoTransaction
+		}
+		index, err := s.savepoint(statement.Savepoint.Value)
+		if err != nil {
+			return err
+		}
+		s.savepoints = s.savepoints[:index]
+		return nil
+	}
+	return fmt.Errorf("unknown transaction control statement %s", statement.Action.Value)
+}
+
+// savepoint returns index of the latest savepoint with given name
+func (s *Session) savepoint(name string) (int, error) {
+	for index := len(s.savepoints) - 1; index >= 0; index-- {
+		if s.savepoints[index].name == name {
+			return index, nil
+		}
+	}
+	return 0, fmt.Errorf("savepoint %s does not exist", name)
+}
+
+// rollbackTo undoes changes made after savepoint and releases savepoints
+// taken after it. Transaction which failed after savepoint is no longer
+// aborted
+func (s *Session) rollbackTo(name string) error {
+	if s.transaction == nil {
+		return errNoTransaction
+	}
+	index, err := s.savepoint(name)
+	if err != nil {
+		return err
+	}
+	if err := s.transaction.RollbackTo(s.savepoints[index].mark); err != nil {
+		return err
+	}
+	s.savepoints = s.savepoints[:index+1]
+	s.failed = false
+	return nil
+}
+
+// begin starts transaction of isolation level, default level of session is
+// used if it is empty. Transactions get ids of locks in order they begin
+func (s *Session) begin(isolation string) (*lockingTransaction, error) {
+	if isolation == "" {
+		isolation = s.isolation
+	}
+	var (
+		transaction engine.Transaction
+		err         error
+	)
+	if isolation == parser.SerializableIsolation {
+		transaction, err = s.database.engines.BeginSerializable()
+	} else {
+		transaction, err = s.database.engines.Begin()
+	}
+	if err != nil {
+		return nil, err
+	}
+	s.database.mutex.Lock()
+	defer s.database.mutex.Unlock()
+	s.database.last++
+	return &lockingTransaction{Transaction: transaction, session: s, id: s.database.last}, nil
+}
+
+// finish commits or rolls back transaction of session and ends it
+func (s *Session) finish(commit bool) error {
+	transaction := s.transaction
+	s.transaction, s.savepoints, s.used, s.failed = nil, nil, false, false
+	if commit {
+		return transaction.Commit()
+	}
+	return transaction.Rollback()
+}
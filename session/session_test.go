@@ -0,0 +1,356 @@
+package session
+
+import (
+	"context"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/VorobevPavel-dev/congenial-disco/engine"
+	"github.com/VorobevPavel-dev/congenial-disco/lock"
+	"github.com/VorobevPavel-dev/congenial-disco/lsm"
+	"github.com/VorobevPavel-dev/congenial-disco/parser"
+	"github.com/VorobevPavel-dev/congenial-disco/planner"
+)
+
+// openDatabase opens database keeping tables without storage option in heap
+// engine and tables of LSM storage in directory
+func openDatabase(t *testing.T, directory string, schema parser.Schema) *Database {
+	t.Helper()
+	tree, err := engine.OpenLSMEngine(directory, &lsm.Options{MemtableSize: 4096})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return New(engine.NewEngines(map[string]engine.StorageEngine{
+		parser.RowStorage: engine.NewHeapEngine(),
+		parser.LSMStorage: tree,
+	}), schema)
+}
+
+func run(t *testing.T, session *Session, request string) (*Result, error) {
+	t.Helper()
+	statement, err := parser.Parse(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return session.Execute(context.Background(), statement)
+}
+
+// mustRun executes request and fails test on error
+func mustRun(t *testing.T, session *Session, request string) *Result {
+	t.Helper()
+	result, err := run(t, session, request)
+	if err != nil {
+		t.Fatalf("%s: %v", request, err)
+	}
+	return result
+}
+
+// query returns rows of query
+func query(t *testing.T, session *Session, request string) []planner.Row {
+	t.Helper()
+	return mustRun(t, session, request).Rows
+}
+
+func TestTransactions(t *testing.T) {
+	database := openDatabase(t, t.TempDir(), nil)
+	defer database.Close()
+	first, second := database.NewSession(), database.NewSession()
+	defer first.Close()
+	defer second.Close()
+	mustRun(t, first, "create table users (id int, name text);")
+	mustRun(t, first, "create table orders (id int, user int) with (storage = lsm);")
+	mustRun(t, first, "insert into users values (1, 'alice');")
+
+	// Changes of transaction are visible to its session only until COMMIT
+	// and are discarded by ROLLBACK
+	for _, finish := range []string{"rollback;", "commit;"} {
+		mustRun(t, first, "begin;")
+		mustRun(t, first, "insert into users values (2, 'bob');")
+		mustRun(t, first, "insert into orders values (1, 2);")
+		if rows := query(t, first, "select name from users order by id;"); len(rows) != 2 {
+			t.Errorf("Expected own changes to be visible, got: %v", rows)
+		}
+		if rows := query(t, second, "select name from users order by id;"); len(rows) != 1 {
+			t.Errorf("Expected changes of other transaction to be hidden, got: %v", rows)
+		}
+		mustRun(t, first, finish)
+		if first.InTransaction() {
+			t.Errorf("Expected transaction to end on %s", finish)
+		}
+	}
+	users := query(t, second, "select name from users join orders on users.id = orders.user;")
+	if expected := []planner.Row{{"bob"}}; !reflect.DeepEqual(users, expected) {
+		t.Errorf("Expected committed changes of both engines, got: %v", users)
+	}
+
+	// Failed statement aborts transaction
+	mustRun(t, first, "begin transaction isolation level repeatable read;")
+	mustRun(t, first, "insert into users values (3, 'carol');")
+	if _, err := run(t, first, "insert into users values (1, 'dave');"); err == nil {
+		t.Errorf("Expected error on duplicate key")
+	}
+	if _, err := run(t, first, "select name from users;"); err != errTransactionAborted {
+		t.Errorf("Expected aborted transaction, got: %v", err)
+	}
+	if _, err := run(t, first, "commit;"); err == nil {
+		t.Errorf("Expected commit of aborted transaction to fail")
+	}
+	if rows := query(t, second, "select name from users;"); len(rows) != 2 {
+		t.Errorf("Expected changes of aborted transaction to be discarded, got: %v", rows)
+	}
+
+	// Conflicting commits fail
+	mustRun(t, first, "begin;")
+	mustRun(t, second, "begin;")
+	mustRun(t, first, "insert into users values (4, 'dave');")
+	mustRun(t, first, "commit;")
+	mustRun(t, second, "insert into users values (4, 'erin');")
+	if _, err := run(t, second, "commit;"); err != engine.ErrWriteConflict {
+		t.Errorf("Expected write conflict, got: %v", err)
+	}
+
+	for index, request := range []string{
+		"commit;",
+		"rollback;",
+		"begin; begin;",
+		"begin; create table events (id int);",
+	} {
+		statements, err := parser.ParseScript(request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, statement := range statements {
+			if _, err = first.Execute(context.Background(), statement); err != nil {
+				break
+			}
+		}
+		if err == nil {
+			t.Errorf("Expected error on set #%d", index)
+		}
+		first.Close()
+	}
+}
+
+func TestRecovery(t *testing.T) {
+	directory := t.TempDir()
+	database := openDatabase(t, directory, nil)
+	session := database.NewSession()
+	mustRun(t, session, "create table orders (id int, amount int) with (storage = lsm);")
+	mustRun(t, session, "begin;")
+	mustRun(t, session, "insert into orders values (1, 10);")
+	mustRun(t, session, "insert into orders values (2, 20);")
+	mustRun(t, session, "commit;")
+	mustRun(t, session, "begin;")
+	for _, request := range []string{
+		"insert into orders values (3, 30);",
+		"insert into orders values (4, 40);",
+	} {
+		mustRun(t, session, request)
+	}
+	// Database stops while transaction is in progress. Changes of
+	// transaction are written to the log of LSM tree at once on commit, so
+	// there is nothing to undo after restart
+	schema := database.tables()
+	if err := database.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened := openDatabase(t, directory, schema)
+	defer reopened.Close()
+	rows := query(t, reopened.NewSession(), "select amount from orders order by id;")
+	if expected := []planner.Row{{10}, {20}}; !reflect.DeepEqual(rows, expected) {
+		t.Errorf("Expected committed rows only, got: %v", rows)
+	}
+}
+
+func TestSerializable(t *testing.T) {
+	database := openDatabase(t, t.TempDir(), nil)
+	defer database.Close()
+	first, second := database.NewSession(), database.NewSession()
+	defer first.Close()
+	defer second.Close()
+	mustRun(t, first, "create table bookings (id int, room int);")
+
+	// Both sessions book the room after checking it is free. Snapshot
+	// isolation lets both of them commit, serializable transactions may not
+	mustRun(t, first, "set transaction isolation level serializable;")
+	mustRun(t, first, "begin;")
+	mustRun(t, second, "begin isolation level serializable;")
+	for index, session := range []*Session{first, second} {
+		if rows := query(t, session, "select count(*) from bookings where room = 1;"); !reflect.DeepEqual(rows, []planner.Row{{0}}) {
+			t.Errorf("Expected free room, got: %v", rows)
+		}
+		mustRun(t, session, "insert into bookings values ("+strconv.Itoa(index)+", 1);")
+	}
+	var failures int
+	for _, session := range []*Session{first, second} {
+		if _, err := run(t, session, "commit;"); engine.IsRetryable(err) {
+			failures++
+		} else if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if rows := query(t, first, "select id from bookings;"); failures != 1 || len(rows) != 1 {
+		t.Errorf("Expected one booking and one serialization failure, got %d failures: %v", failures, rows)
+	}
+
+	// Isolation level is set before the first statement of transaction
+	mustRun(t, second, "begin;")
+	mustRun(t, second, "set transaction isolation level serializable;")
+	mustRun(t, second, "select id from bookings;")
+	if _, err := run(t, second, "set transaction isolation level read committed;"); err == nil {
+		t.Errorf("Expected error on changing isolation level of running transaction")
+	}
+	mustRun(t, second, "rollback;")
+}
+
+func TestSavepoints(t *testing.T) {
+	database := openDatabase(t, t.TempDir(), nil)
+	defer database.Close()
+	session := database.NewSession()
+	defer session.Close()
+	mustRun(t, session, "create table users (id int, name text);")
+	mustRun(t, session, "create table orders (id int, user int) with (storage = lsm);")
+
+	script := `
+		begin;
+		insert into users values (1, 'alice');
+		savepoint users;
+		insert into users values (2, 'bob');
+		savepoint orders;
+		insert into orders values (1, 1);
+		insert into orders values (1, 2);
+		insert into orders values (2, 2);`
+	results, err := session.ExecuteScript(context.Background(), script)
+	if err == nil || len(results) != 6 {
+		t.Fatalf("Expected the 7th statement to fail, got %d results: %v", len(results), err)
+	}
+	// Failed statement rolls transaction back to the latest savepoint
+	if !session.InTransaction() {
+		t.Fatalf("Expected transaction to be in progress")
+	}
+	if _, err := session.ExecuteScript(context.Background(), `
+		insert into orders values (2, 1);
+		rollback to savepoint users;
+		insert into orders values (3, 1);
+		release savepoint users;
+		commit;`); err != nil {
+		t.Fatal(err)
+	}
+	for request, expected := range map[string][]planner.Row{
+		"select name from users;": {{"alice"}},
+		"select id from orders;":  {{3}},
+	} {
+		if rows := query(t, session, request); !reflect.DeepEqual(rows, expected) {
+			t.Errorf("Expected %v for %s, got: %v", expected, request, rows)
+		}
+	}
+
+	// Transaction without savepoints is aborted
+	if _, err := session.ExecuteScript(context.Background(), "begin; insert into users values (1, 'bob'); insert into users values (5, 'erin');"); err == nil {
+		t.Errorf("Expected error on duplicate key")
+	}
+	if _, err := run(t, session, "savepoint late;"); err != errTransactionAborted {
+		t.Errorf("Expected aborted transaction, got: %v", err)
+	}
+	mustRun(t, session, "rollback;")
+
+	for index, request := range []string{
+		"savepoint first;",
+		"begin; release savepoint first;",
+		"begin; savepoint first; release first; rollback to first;",
+		"begin; savepoint first; savepoint second; rollback to first; release second;",
+	} {
+		if _, err := session.ExecuteScript(context.Background(), request); err == nil {
+			t.Errorf("Expected error on set #%d", index)
+		}
+		session.Close()
+	}
+}
+
+func TestLocks(t *testing.T) {
+	database := openDatabase(t, t.TempDir(), nil)
+	defer database.Close()
+	first, second := database.NewSession(), database.NewSession()
+	defer first.Close()
+	defer second.Close()
+	mustRun(t, first, "create table users (id int, name text);")
+	mustRun(t, first, "create table orders (id int, user int);")
+	for _, request := range []string{
+		"insert into users values (1, 'alice');",
+		"insert into users values (2, 'bob');",
+		"insert into orders values (1, 1);",
+	} {
+		mustRun(t, first, request)
+	}
+	mustRun(t, second, "set lock_timeout = 20;")
+
+	// Rows read with FOR UPDATE are locked until the end of transaction,
+	// other rows and tables are not
+	mustRun(t, first, "begin;")
+	if rows := query(t, first, "select name from users where id = 1 for update;"); !reflect.DeepEqual(rows, []planner.Row{{"alice"}}) {
+		t.Errorf("Unexpected rows: %v", rows)
+	}
+	for request, expected := range map[string]error{
+		"select name from users where id = 2 for update;": nil,
+		"select name from users where id < 3 for share;":  lock.ErrLockTimeout,
+		"lock table users in share mode;":                 lock.ErrLockTimeout,
+		"lock table orders in share mode;":                nil,
+		"select name from users;":                         nil,
+	} {
+		mustRun(t, second, "begin;")
+		if _, err := run(t, second, request); err != expected {
+			t.Errorf("Expected %v on %s, got: %v", expected, request, err)
+		}
+		mustRun(t, second, "rollback;")
+	}
+	mustRun(t, first, "commit;")
+	mustRun(t, second, "begin;")
+	mustRun(t, second, "select name from users where id < 3 for share;")
+
+	// Shared locks keep rows and tables from being changed
+	mustRun(t, first, "set lock_timeout = '20ms';")
+	mustRun(t, first, "begin;")
+	mustRun(t, first, "lock table orders;")
+	mustRun(t, first, "rollback;")
+	if _, err := run(t, first, "insert into users values (3, 'carol');"); err != nil {
+		t.Errorf("Expected insert of unlocked row, got: %v", err)
+	}
+	if _, err := run(t, first, "select name from users join orders on users.id = orders.user for update;"); err != lock.ErrLockTimeout {
+		t.Errorf("Expected timeout on locking table with shared locks, got: %v", err)
+	}
+	mustRun(t, second, "lock table users in share mode;")
+	if _, err := run(t, first, "insert into users values (4, 'dave');"); err != lock.ErrLockTimeout {
+		t.Errorf("Expected timeout on insert into locked table, got: %v", err)
+	}
+	mustRun(t, second, "commit;")
+	if _, err := run(t, first, "lock table users;"); err == nil {
+		t.Errorf("Expected error on LOCK TABLE outside of transaction")
+	}
+
+	// Deadlock aborts the youngest transaction
+	mustRun(t, first, "set lock_timeout = 0;")
+	mustRun(t, second, "set lock_timeout = 0;")
+	mustRun(t, first, "begin;")
+	mustRun(t, second, "begin;")
+	mustRun(t, first, "select id from users where id = 1 for update;")
+	mustRun(t, second, "select id from users where id = 2 for update;")
+	done := make(chan error)
+	go func() {
+		_, err := run(t, first, "select id from users where id = 2 for update;")
+		done <- err
+	}()
+	for len(database.locks.WaitsFor()) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	if _, err := run(t, second, "select id from users where id = 1 for update;"); err != lock.ErrDeadlock {
+		t.Errorf("Expected deadlock, got: %v", err)
+	}
+	mustRun(t, second, "rollback;")
+	if err := <-done; err != nil {
+		t.Errorf("Expected lock to be granted after deadlock is broken, got: %v", err)
+	}
+	mustRun(t, first, "commit;")
+}
@@ -0,0 +1,135 @@
+package statistics
+
+import (
+	"sort"
+	"strings"
+)
+
+// DefaultBuckets is a number of buckets of histograms built by Builder
+const DefaultBuckets = 20
+
+// Histogram is an equi-depth histogram: every bucket holds the same number of
+// values. Bucket i holds values from Bounds[i] to Bounds[i+1]
+type Histogram struct {
+	Bounds []interface{} `json:"bounds"`
+}
+
+// NewHistogram builds histogram of at most given number of buckets over
+// non-NULL int or string values. Returns nil if there are no values
+func NewHistogram(values []interface{}, buckets int) *Histogram {
+	if len(values) == 0 || buckets < 1 {
+		return nil
+	}
+	sorted := append([]interface{}{}, values...)
+	sort.Slice(sorted, func(i, j int) bool { return Compare(sorted[i], sorted[j]) < 0 })
+	if buckets > len(sorted) {
+		buckets = len(sorted)
+	}
+	histogram := &Histogram{}
+	for bucket := 0; bucket <= buckets; bucket++ {
+		index := bucket * (len(sorted) - 1) / buckets
+		histogram.Bounds = append(histogram.Bounds, sorted[index])
+	}
+	return histogram
+}
+
+// LessFraction estimates share of values of histogram less than value (or
+// less than or equal if inclusive is set)
+func (h *Histogram) LessFraction(value interface{}, inclusive bool) float64 {
+	buckets := len(h.Bounds) - 1
+	first, last := h.Bounds[0], h.Bounds[buckets]
+	switch {
+	case Compare(value, first) < 0, !inclusive && Compare(value, first) == 0:
+		return 0
+	case Compare(value, last) > 0, inclusive && Compare(value, last) == 0:
+		return 1
+	}
+	// Find bucket which contains value and assume values are spread evenly
+	// over the bucket
+	bucket := sort.Search(buckets, func(i int) bool { return Compare(h.Bounds[i+1], value) >= 0 })
+	if bucket == buckets {
+		return 1
+	}
+	return (float64(bucket) + position(h.Bounds[bucket], h.Bounds[bucket+1], value)) / float64(buckets)
+}
+
+// position estimates position of value between low and high bounds as a
+// number from 0 to 1
+func position(low, high, value interface{}) float64 {
+	switch typed := value.(type) {
+	case int:
+		lowInt, lowOk := low.(int)
+		highInt, highOk := high.(int)
+		if lowOk && highOk && highInt > lowInt {
+			return float64(typed-lowInt) / float64(highInt-lowInt)
+		}
+	case string:
+		lowText, lowOk := low.(string)
+		highText, highOk := high.(string)
+		if lowOk && highOk {
+			return textPosition(lowText, highText, typed)
+		}
+	}
+	return 0.5
+}
+
+// textPosition interpolates position of text between bounds by the first
+// bytes following their common prefix
+func textPosition(low, high, value string) float64 {
+	common := 0
+	for common < len(low) && common < len(high) && low[common] == high[common] {
+		common++
+	}
+	code := func(text string) float64 {
+		var result, scale float64 = 0, 1
+		for index := common; index < common+4; index++ {
+			scale /= 256
+			if index < len(text) {
+				result += float64(text[index]) * scale
+			}
+		}
+		return result
+	}
+	if !strings.HasPrefix(value, low[:common]) || code(high) <= code(low) {
+		return 0.5
+	}
+	result := (code(value) - code(low)) / (code(high) - code(low))
+	if result < 0 {
+		return 0
+	}
+	if result > 1 {
+		return 1
+	}
+	return result
+}
+
+// Compare orders values: NULLs go first, then numbers and then texts
+func Compare(first, second interface{}) int {
+	rank := func(value interface{}) int {
+		switch value.(type) {
+		case nil:
+			return 0
+		case int:
+			return 1
+		}
+		return 2
+	}
+	if rank(first) != rank(second) {
+		return rank(first) - rank(second)
+	}
+	switch typed := first.(type) {
+	case int:
+		other := second.(int)
+		switch {
+		case typed < other:
+			return -1
+		case typed > other:
+			return 1
+		}
+	case string:
+		if other, ok := second.(string); ok {
+			return strings.Compare(typed, other)
+		}
+	}
+	return 0
+}
@@ -0,0 +1,103 @@
+package statistics
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"math/bits"
+)
+
+// DefaultPrecision gives HyperLogLog of 4096 registers with standard error
+// about 1.6%
+const DefaultPrecision = 12
+
+// HyperLogLog estimates number of distinct values using fixed amount of
+// memory: every value is hashed, values are spread over registers by the
+// first bits of hash and every register keeps the longest run of leading
+// zeros seen in the rest of bits
+type HyperLogLog struct {
+	precision uint
+	registers []uint8
+}
+
+// NewHyperLogLog creates HyperLogLog with 2^precision registers, precision
+// must be from 4 to 16
+func NewHyperLogLog(precision uint) (*HyperLogLog, error) {
+	if precision < 4 || precision > 16 {
+		return nil, fmt.Errorf("precision must be from 4 to 16, got: %d", precision)
+	}
+	return &HyperLogLog{precision: precision, registers: make([]uint8, 1<<precision)}, nil
+}
+
+// Add adds int or string value to HyperLogLog
+func (hll *HyperLogLog) Add(value interface{}) {
+	hash := hashValue(value)
+	index := hash >> (64 - hll.precision)
+	// Register index bits are shifted out, so that they are not counted as
+	// leading zeros
+	rank := uint8(bits.LeadingZeros64(hash<<hll.precision|1<<(hll.precision-1)) + 1)
+	if rank > hll.registers[index] {
+		hll.registers[index] = rank
+	}
+}
+
+// Merge adds all values of other HyperLogLog of the same precision
+func (hll *HyperLogLog) Merge(other *HyperLogLog) error {
+	if hll.precision != other.precision {
+		return fmt.Errorf("cannot merge HyperLogLog of precision %d into precision %d", other.precision, hll.precision)
+	}
+	for index, rank := range other.registers {
+		if rank > hll.registers[index] {
+			hll.registers[index] = rank
+		}
+	}
+	return nil
+}
+
+// Estimate returns estimated number of distinct values added
+func (hll *HyperLogLog) Estimate() float64 {
+	count := float64(len(hll.registers))
+	var (
+		sum   float64
+		empty int
+	)
+	for _, rank := range hll.registers {
+		sum += math.Ldexp(1, -int(rank))
+		if rank == 0 {
+			empty++
+		}
+	}
+	alpha := 0.7213 / (1 + 1.079/count)
+	estimate := alpha * count * count / sum
+	// Small cardinalities are estimated better by number of empty registers
+	if estimate <= 2.5*count && empty != 0 {
+		return count * math.Log(count/float64(empty))
+	}
+	return estimate
+}
+
+// hashValue hashes value with FNV-1a and mixes bits of result, since FNV
+// spreads short inputs like small numbers poorly over high bits
+func hashValue(value interface{}) uint64 {
+	hasher := fnv.New64a()
+	switch typed := value.(type) {
+	case int:
+		var buffer [9]byte
+		buffer[0] = 'i'
+		binary.LittleEndian.PutUint64(buffer[1:], uint64(typed))
+		hasher.Write(buffer[:])
+	case string:
+		hasher.Write([]byte{'s'})
+		hasher.Write([]byte(typed))
+	default:
+		fmt.Fprintf(hasher, "%T:%v", value, value)
+	}
+	hash := hasher.Sum64()
+	hash ^= hash >> 33
+	hash *= 0xff51afd7ed558ccd
+	hash ^= hash >> 33
+	hash *= 0xc4ceb9fe1a85ec53
+	hash ^= hash >> 33
+	return hash
+}
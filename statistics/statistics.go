@@ -0,0 +1,244 @@
+// Package statistics collects statistics of table columns which are used by
+// planner to estimate number of rows produced by queries
+package statistics
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+)
+
+const (
+	// DefaultSampleSize is a number of rows Builder keeps to build
+	// histograms and lists of most common values
+	DefaultSampleSize = 30000
+	// DefaultMostCommonValues is a maximal length of lists of most common
+	// values built by Builder
+	DefaultMostCommonValues = 10
+)
+
+// ColumnStatistics describes values of a column
+type ColumnStatistics struct {
+	// NullFraction is a share of rows with NULL value
+	NullFraction float64 `json:"nullFraction"`
+	// DistinctCount is an estimated number of distinct non-NULL values
+	DistinctCount float64 `json:"distinctCount"`
+	// MostCommonValues are listed with shares of rows they are stored in
+	MostCommonValues      []interface{} `json:"mostCommonValues,omitempty"`
+	MostCommonFrequencies []float64     `json:"mostCommonFrequencies,omitempty"`
+	// Histogram describes the rest of non-NULL values
+	Histogram *Histogram `json:"histogram,omitempty"`
+}
+
+// TableStatistics describes rows of a table
+type TableStatistics struct {
+	RowCount int                          `json:"rowCount"`
+	Columns  map[string]*ColumnStatistics `json:"columns"`
+}
+
+func (ts *TableStatistics) String() string {
+	bytes, _ := json.Marshal(ts)
+	return string(bytes)
+}
+
+// Builder collects statistics of rows added one by one. Number of distinct
+// values is estimated over all rows, histograms and most common values are
+// built over a random sample of rows.
+type Builder struct {
+	columns    []string
+	sampleSize int
+	rows       int
+	nulls      []int
+	distinct   []*HyperLogLog
+	sample     [][]interface{}
+	random     *rand.Rand
+}
+
+// NewBuilder creates builder of statistics of rows consisting of values of
+// given columns
+func NewBuilder(columns []string) *Builder {
+	builder := &Builder{
+		columns:    columns,
+		sampleSize: DefaultSampleSize,
+		nulls:      make([]int, len(columns)),
+		// Fixed seed makes statistics of the same data the same
+		random: rand.New(rand.NewSource(1)),
+	}
+	for range columns {
+		hll, _ := NewHyperLogLog(DefaultPrecision)
+		builder.distinct = append(builder.distinct, hll)
+	}
+	return builder
+}
+
+// Add adds row to statistics. Row must have a value for every column
+func (b *Builder) Add(row []interface{}) error {
+	if len(row) != len(b.columns) {
+		return fmt.Errorf("expected %d values in row, got: %d", len(b.columns), len(row))
+	}
+	b.rows++
+	for index, value := range row {
+		if value == nil {
+			b.nulls[index]++
+			continue
+		}
+		b.distinct[index].Add(value)
+	}
+	// Reservoir sampling keeps every row in sample with the same probability
+	if len(b.sample) < b.sampleSize {
+		b.sample = append(b.sample, append([]interface{}{}, row...))
+	} else if replaced := b.random.Intn(b.rows); replaced < b.sampleSize {
+		b.sample[replaced] = append([]interface{}{}, row...)
+	}
+	return nil
+}
+
+// Finish returns statistics of rows added to builder
+func (b *Builder) Finish() *TableStatistics {
+	result := &TableStatistics{RowCount: b.rows, Columns: map[string]*ColumnStatistics{}}
+	for index, column := range b.columns {
+		result.Columns[column] = b.column(index)
+	}
+	return result
+}
+
+func (b *Builder) column(index int) *ColumnStatistics {
+	result := &ColumnStatistics{}
+	if b.rows == 0 {
+		return result
+	}
+	result.NullFraction = float64(b.nulls[index]) / float64(b.rows)
+
+	counts := map[interface{}]int{}
+	var values []interface{}
+	for _, row := range b.sample {
+		if row[index] != nil {
+			counts[row[index]]++
+			values = append(values, row[index])
+		}
+	}
+	// Number of distinct values is known exactly if all rows are sampled
+	result.DistinctCount = math.Round(b.distinct[index].Estimate())
+	if len(b.sample) == b.rows {
+		result.DistinctCount = float64(len(counts))
+	}
+	if len(values) == 0 {
+		return result
+	}
+
+	// Values are most common if they are noticeably more frequent than an
+	// average value
+	average := float64(len(values)) / float64(len(counts))
+	var common []interface{}
+	for value, count := range counts {
+		if count > 1 && float64(count) > 1.25*average {
+			common = append(common, value)
+		}
+	}
+	sort.Slice(common, func(i, j int) bool {
+		if counts[common[i]] != counts[common[j]] {
+			return counts[common[i]] > counts[common[j]]
+		}
+		return Compare(common[i], common[j]) < 0
+	})
+	if len(common) > DefaultMostCommonValues {
+		common = common[:DefaultMostCommonValues]
+	}
+	isCommon := map[interface{}]bool{}
+	for _, value := range common {
+		isCommon[value] = true
+		result.MostCommonValues = append(result.MostCommonValues, value)
+		result.MostCommonFrequencies = append(result.MostCommonFrequencies,
+			float64(counts[value])/float64(len(b.sample)))
+	}
+
+	var rest []interface{}
+	for _, value := range values {
+		if !isCommon[value] {
+			rest = append(rest, value)
+		}
+	}
+	result.Histogram = NewHistogram(rest, DefaultBuckets)
+	return result
+}
+
+// EqualFraction estimates share of rows with value equal to given one
+func (cs *ColumnStatistics) EqualFraction(value interface{}) float64 {
+	for index, common := range cs.MostCommonValues {
+		if Compare(common, value) == 0 {
+			return cs.MostCommonFrequencies[index]
+		}
+	}
+	// The rest of values are assumed to be equally frequent
+	distinct := cs.DistinctCount - float64(len(cs.MostCommonValues))
+	if distinct < 1 {
+		distinct = 1
+	}
+	return cs.restFraction() / distinct
+}
+
+// LessFraction estimates share of rows with value less than given one (or
+// less than or equal if inclusive is set). Returns false if there is no
+// histogram to estimate by
+func (cs *ColumnStatistics) LessFraction(value interface{}, inclusive bool) (float64, bool) {
+	var result float64
+	for index, common := range cs.MostCommonValues {
+		order := Compare(common, value)
+		if order < 0 || inclusive && order == 0 {
+			result += cs.MostCommonFrequencies[index]
+		}
+	}
+	if cs.Histogram == nil {
+		return result, cs.restFraction() == 0
+	}
+	return result + cs.restFraction()*cs.Histogram.LessFraction(value, inclusive), true
+}
+
+// restFraction is a share of rows with non-NULL values which are not most
+// common
+func (cs *ColumnStatistics) restFraction() float64 {
+	result := 1 - cs.NullFraction
+	for _, frequency := range cs.MostCommonFrequencies {
+		result -= frequency
+	}
+	return math.Max(0, result)
+}
+
+// Catalog keeps statistics of tables. It is safe for concurrent use
+type Catalog struct {
+	mutex  sync.RWMutex
+	tables map[string]*TableStatistics
+}
+
+func NewCatalog() *Catalog {
+	return &Catalog{tables: map[string]*TableStatistics{}}
+}
+
+// Set replaces statistics of table
+func (c *Catalog) Set(table string, statistics *TableStatistics) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.tables[table] = statistics
+}
+
+// Get returns statistics of table or false if table was not analyzed
+func (c *Catalog) Get(table string) (*TableStatistics, bool) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	statistics, ok := c.tables[table]
+	return statistics, ok
+}
+
+// Column returns statistics of column of table or false if table was not
+// analyzed
+func (c *Catalog) Column(table string, column string) (*ColumnStatistics, bool) {
+	statistics, ok := c.Get(table)
+	if !ok {
+		return nil, false
+	}
+	result, ok := statistics.Columns[column]
+	return result, ok
+}
@@ -0,0 +1,160 @@
+package statistics
+
+import (
+	"math"
+	"strconv"
+	"testing"
+)
+
+func TestHyperLogLog(t *testing.T) {
+	t.Run("Test distinct count estimation", func(t *testing.T) {
+		inputs := []int{0, 10, 1000, 100000}
+		for testCase, count := range inputs {
+			hll, err := NewHyperLogLog(DefaultPrecision)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Every value is added twice and half of values are texts
+			for repeat := 0; repeat < 2; repeat++ {
+				for value := 0; value < count; value++ {
+					if value%2 == 0 {
+						hll.Add(value)
+					} else {
+						hll.Add(strconv.Itoa(value))
+					}
+				}
+			}
+			estimate := hll.Estimate()
+			if math.Abs(estimate-float64(count)) > 0.05*float64(count)+0.5 {
+				t.Errorf("Assertion failed on set #%d. Expected about %d, got: %f", testCase, count, estimate)
+			}
+		}
+	})
+	t.Run("Test merging", func(t *testing.T) {
+		first, _ := NewHyperLogLog(DefaultPrecision)
+		second, _ := NewHyperLogLog(DefaultPrecision)
+		for value := 0; value < 20000; value++ {
+			first.Add(value)
+			second.Add(value + 10000)
+		}
+		if err := first.Merge(second); err != nil {
+			t.Fatal(err)
+		}
+		if estimate := first.Estimate(); math.Abs(estimate-30000) > 1500 {
+			t.Errorf("Expected about 30000 values after merge, got: %f", estimate)
+		}
+		other, _ := NewHyperLogLog(DefaultPrecision + 1)
+		if err := first.Merge(other); err == nil {
+			t.Errorf("Expected error on merging HyperLogLog of different precision")
+		}
+	})
+	t.Run("Test invalid precision", func(t *testing.T) {
+		for _, precision := range []uint{0, 3, 17} {
+			if _, err := NewHyperLogLog(precision); err == nil {
+				t.Errorf("Expected error for precision %d", precision)
+			}
+		}
+	})
+}
+
+func TestHistogram(t *testing.T) {
+	var values []interface{}
+	for value := 100; value > 0; value-- {
+		values = append(values, value)
+	}
+	histogram := NewHistogram(values, 10)
+	if len(histogram.Bounds) != 11 || histogram.Bounds[0] != 1 || histogram.Bounds[10] != 100 {
+		t.Fatalf("Unexpected bounds: %v", histogram.Bounds)
+	}
+	inputs := []struct {
+		value     interface{}
+		inclusive bool
+	}{
+		{0, false}, {1, false}, {1, true}, {50, false}, {75, true}, {100, true}, {1000, false},
+	}
+	expectedOutputs := []float64{0, 0, 0.01, 0.5, 0.75, 1, 1}
+	for testCase, input := range inputs {
+		actual := histogram.LessFraction(input.value, input.inclusive)
+		if math.Abs(actual-expectedOutputs[testCase]) > 0.02 {
+			t.Errorf("Assertion failed on set #%d. Expected: %f, got: %f", testCase, expectedOutputs[testCase], actual)
+		}
+	}
+	texts := NewHistogram([]interface{}{"apple", "banana", "cherry", "date", "fig"}, 4)
+	if fraction := texts.LessFraction("c", false); fraction <= 0.25 || fraction >= 0.75 {
+		t.Errorf("Expected \"c\" to be in the middle of texts, got: %f", fraction)
+	}
+	if NewHistogram(nil, 10) != nil {
+		t.Errorf("Expected no histogram without values")
+	}
+}
+
+func TestBuilder(t *testing.T) {
+	builder := NewBuilder([]string{"id", "status", "note"})
+	for id := 0; id < 1000; id++ {
+		status := "active"
+		switch {
+		case id%10 == 0:
+			status = "deleted"
+		case id%4 == 0:
+			status = "new" + strconv.Itoa(id)
+		}
+		var note interface{}
+		if id%2 == 0 {
+			note = "note"
+		}
+		if err := builder.Add([]interface{}{id, status, note}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := builder.Add([]interface{}{1}); err == nil {
+		t.Errorf("Expected error on row of wrong length")
+	}
+	result := builder.Finish()
+	if result.RowCount != 1000 {
+		t.Errorf("Expected 1000 rows, got: %d", result.RowCount)
+	}
+
+	id := result.Columns["id"]
+	if id.DistinctCount != 1000 || id.NullFraction != 0 || id.MostCommonValues != nil {
+		t.Errorf("Unexpected statistics of unique column: %+v", id)
+	}
+	if fraction, ok := id.LessFraction(250, false); !ok || math.Abs(fraction-0.25) > 0.02 {
+		t.Errorf("Expected a quarter of ids to be less than 250, got: %f", fraction)
+	}
+	if fraction := id.EqualFraction(5); math.Abs(fraction-0.001) > 0.0001 {
+		t.Errorf("Expected one of 1000 ids to be equal to 5, got: %f", fraction)
+	}
+
+	status := result.Columns["status"]
+	if len(status.MostCommonValues) != 2 || status.MostCommonValues[0] != "active" ||
+		status.MostCommonValues[1] != "deleted" {
+		t.Errorf("Unexpected most common values: %v", status.MostCommonValues)
+	}
+	if fraction := status.EqualFraction("deleted"); math.Abs(fraction-0.1) > 0.001 {
+		t.Errorf("Expected 10%% of rows to be deleted, got: %f", fraction)
+	}
+	if fraction := status.EqualFraction("new4"); math.Abs(fraction-0.001) > 0.0005 {
+		t.Errorf("Expected rare value to be in one row, got: %f", fraction)
+	}
+
+	note := result.Columns["note"]
+	if note.NullFraction != 0.5 || note.DistinctCount != 1 {
+		t.Errorf("Unexpected statistics of nullable column: %+v", note)
+	}
+}
+
+func TestCatalog(t *testing.T) {
+	catalog := NewCatalog()
+	if _, ok := catalog.Get("test"); ok {
+		t.Errorf("Expected no statistics of not analyzed table")
+	}
+	builder := NewBuilder([]string{"a"})
+	builder.Add([]interface{}{1})
+	catalog.Set("test", builder.Finish())
+	if column, ok := catalog.Column("test", "a"); !ok || column.DistinctCount != 1 {
+		t.Errorf("Unexpected statistics of column: %v", column)
+	}
+	if _, ok := catalog.Column("test", "b"); ok {
+		t.Errorf("Expected no statistics of unknown column")
+	}
+}
@@ -5,20 +5,89 @@ import (
 	"errors"
 	"strconv"
 	"strings"
+	"unicode"
 
 	"github.com/VorobevPavel-dev/congenial-disco/utility"
 )
 
-//SQL-reserved words
+// SQL-reserved words
 const (
-	SelectKeyword string = "select"
-	FromKeyword   string = "from"
-	AsKeyword     string = "as"
-	TableKeyword  string = "table"
-	CreateKeyword string = "create"
-	InsertKeyword string = "insert"
-	IntoKeyword   string = "into"
-	ValuesKeyword string = "values"
+	SelectKeyword       string = "select"
+	FromKeyword         string = "from"
+	AsKeyword           string = "as"
+	TableKeyword        string = "table"
+	CreateKeyword       string = "create"
+	InsertKeyword       string = "insert"
+	IntoKeyword         string = "into"
+	ValuesKeyword       string = "values"
+	WhereKeyword        string = "where"
+	AndKeyword          string = "and"
+	OrKeyword           string = "or"
+	NotKeyword          string = "not"
+	InKeyword           string = "in"
+	ExistsKeyword       string = "exists"
+	DistinctKeyword     string = "distinct"
+	UnionKeyword        string = "union"
+	AllKeyword          string = "all"
+	IntersectKeyword    string = "intersect"
+	ExceptKeyword       string = "except"
+	WithKeyword         string = "with"
+	RecursiveKeyword    string = "recursive"
+	OverKeyword         string = "over"
+	PartitionKeyword    string = "partition"
+	ByKeyword           string = "by"
+	OrderKeyword        string = "order"
+	AscKeyword          string = "asc"
+	DescKeyword         string = "desc"
+	RowsKeyword         string = "rows"
+	RangeKeyword        string = "range"
+	BetweenKeyword      string = "between"
+	UnboundedKeyword    string = "unbounded"
+	PrecedingKeyword    string = "preceding"
+	FollowingKeyword    string = "following"
+	CurrentKeyword      string = "current"
+	RowKeyword          string = "row"
+	CaseKeyword         string = "case"
+	WhenKeyword         string = "when"
+	ThenKeyword         string = "then"
+	ElseKeyword         string = "else"
+	EndKeyword          string = "end"
+	LikeKeyword         string = "like"
+	ILikeKeyword        string = "ilike"
+	EscapeKeyword       string = "escape"
+	BeginKeyword        string = "begin"
+	CommitKeyword       string = "commit"
+	RollbackKeyword     string = "rollback"
+	TransactionKeyword  string = "transaction"
+	SetKeyword          string = "set"
+	IsolationKeyword    string = "isolation"
+	LevelKeyword        string = "level"
+	SerializableKeyword string = "serializable"
+	RepeatableKeyword   string = "repeatable"
+	ReadKeyword         string = "read"
+	CommittedKeyword    string = "committed"
+	UncommittedKeyword  string = "uncommitted"
+	SavepointKeyword    string = "savepoint"
+	ReleaseKeyword      string = "release"
+	ToKeyword           string = "to"
+	ForKeyword          string = "for"
+	UpdateKeyword       string = "update"
+	ShareKeyword        string = "share"
+	LockKeyword         string = "lock"
+	ModeKeyword         string = "mode"
+	ExclusiveKeyword    string = "exclusive"
+	JoinKeyword         string = "join"
+	InnerKeyword        string = "inner"
+	OnKeyword           string = "on"
+	GroupKeyword        string = "group"
+	LimitKeyword        string = "limit"
+	OffsetKeyword       string = "offset"
+	ExplainKeyword      string = "explain"
+	AnalyzeKeyword      string = "analyze"
+	FormatKeyword       string = "format"
+	CancelKeyword       string = "cancel"
+	KillKeyword         string = "kill"
+	QueryKeyword        string = "query"
 )
 
 // Symbol constants
@@ -29,6 +98,22 @@ const (
 	LeftParenSymbol  string = "("
 	RightParenSymbol string = ")"
 	SpaceSymbol      string = " "
+	// Tabs and line breaks separate tokens the same way spaces do
+	TabSymbol            string = "\t"
+	NewlineSymbol        string = "\n"
+	CarriageReturnSymbol string = "\r"
+	DotSymbol            string = "."
+	EqualSymbol          string = "="
+	LessSymbol           string = "<"
+	GreaterSymbol        string = ">"
+	BangSymbol           string = "!"
+	// QuoteSymbol opens and closes string literals, quote inside of literal
+	// is escaped by doubling it ('it''s')
+	QuoteSymbol string = "'"
+	// BacktickSymbol quotes identifiers (MySQL dialect), so that they can
+	// contain spaces and symbols or match keywords (`order`). Backtick inside
+	// of identifier is escaped by doubling it
+	BacktickSymbol string = "`"
 )
 
 const (
@@ -47,6 +132,11 @@ const (
 	IdentifierKind
 	// TypeKind will correspond to every column type in request
 	TypeKind
+	// StringKind will correspond to quoted string literals
+	StringKind
+	// ParameterKind will correspond to placeholders of prepared statements:
+	// "?", "$1" or ":name"
+	ParameterKind
 )
 
 type TokenKind uint
@@ -61,14 +151,128 @@ var (
 		InsertKeyword,
 		IntoKeyword,
 		ValuesKeyword,
+		WhereKeyword,
+		AndKeyword,
+		OrKeyword,
+		NotKeyword,
+		InKeyword,
+		ExistsKeyword,
+		DistinctKeyword,
+		UnionKeyword,
+		AllKeyword,
+		IntersectKeyword,
+		ExceptKeyword,
+		WithKeyword,
+		RecursiveKeyword,
+		OverKeyword,
+		PartitionKeyword,
+		ByKeyword,
+		OrderKeyword,
+		AscKeyword,
+		DescKeyword,
+		RowsKeyword,
+		RangeKeyword,
+		BetweenKeyword,
+		UnboundedKeyword,
+		PrecedingKeyword,
+		FollowingKeyword,
+		CurrentKeyword,
+		RowKeyword,
+		CaseKeyword,
+		WhenKeyword,
+		ThenKeyword,
+		ElseKeyword,
+		EndKeyword,
+		LikeKeyword,
+		ILikeKeyword,
+		EscapeKeyword,
+		BeginKeyword,
+		CommitKeyword,
+		RollbackKeyword,
+		TransactionKeyword,
+		SetKeyword,
+		IsolationKeyword,
+		LevelKeyword,
+		SerializableKeyword,
+		RepeatableKeyword,
+		ReadKeyword,
+		CommittedKeyword,
+		UncommittedKeyword,
+		SavepointKeyword,
+		ReleaseKeyword,
+		ToKeyword,
+		ForKeyword,
+		UpdateKeyword,
+		ShareKeyword,
+		LockKeyword,
+		ModeKeyword,
+		ExclusiveKeyword,
+		JoinKeyword,
+		InnerKeyword,
+		OnKeyword,
+		GroupKeyword,
+		LimitKeyword,
+		OffsetKeyword,
+		ExplainKeyword,
+		AnalyzeKeyword,
+		FormatKeyword,
+		CancelKeyword,
+		KillKeyword,
+		QueryKeyword,
+	}
+	// reserved keywords start or separate clauses of statements, so they can
+	// not be used as names. Other keywords have a meaning only in particular
+	// places ("rows" of window frame, "level" of isolation level) and may
+	// name tables and columns as well
+	reserved = []string{
+		SelectKeyword,
+		FromKeyword,
+		AsKeyword,
+		TableKeyword,
+		CreateKeyword,
+		InsertKeyword,
+		IntoKeyword,
+		ValuesKeyword,
+		WhereKeyword,
+		AndKeyword,
+		OrKeyword,
+		NotKeyword,
+		InKeyword,
+		ExistsKeyword,
+		DistinctKeyword,
+		UnionKeyword,
+		AllKeyword,
+		IntersectKeyword,
+		ExceptKeyword,
+		WithKeyword,
+		RecursiveKeyword,
+		CaseKeyword,
+		WhenKeyword,
+		ThenKeyword,
+		ElseKeyword,
+		LikeKeyword,
+		ILikeKeyword,
+		BetweenKeyword,
+		JoinKeyword,
+		InnerKeyword,
+		OnKeyword,
+		ForKeyword,
 	}
 	symbols = []string{
 		CommaSymbol,
 		SemicolonSymbol,
 		SpaceSymbol,
+		TabSymbol,
+		NewlineSymbol,
+		CarriageReturnSymbol,
 		AsteriskSymbol,
 		LeftParenSymbol,
 		RightParenSymbol,
+		DotSymbol,
+		EqualSymbol,
+		LessSymbol,
+		GreaterSymbol,
+		BangSymbol,
 	}
 	types = []string{
 		IntType,
@@ -104,6 +308,7 @@ func TokenFromString(value string, cursorPosition int) (*Token, error) {
 		ParseTypeToken,
 		ParseKeywordToken,
 		ParseSymbolToken,
+		ParseParameterToken,
 		ParseIdentifierToken,
 	}
 	for _, function := range tokenizers {
@@ -138,6 +343,21 @@ func ParseKeywordToken(value string) *Token {
 	return nil
 }
 
+// AsIdentifier returns token as identifier if it may name table or column:
+// identifiers are returned as they are, non-reserved keywords are converted
+// to identifiers. Nil is returned for any other token.
+func AsIdentifier(token *Token) *Token {
+	switch {
+	case token == nil:
+		return nil
+	case token.Kind == IdentifierKind:
+		return token
+	case token.Kind == KeywordKind && !utility.StringIsIn(token.Value, reserved):
+		return &Token{Value: token.Value, Kind: IdentifierKind, Position: token.Position}
+	}
+	return nil
+}
+
 func ParseSymbolToken(value string) *Token {
 	if utility.StringIsIn(value, symbols) {
 		return &Token{
@@ -150,7 +370,7 @@ func ParseSymbolToken(value string) *Token {
 
 func ParseTypeToken(value string) *Token {
 	loweredValue := strings.ToLower(value)
-	if utility.StringIsIn(value, types) {
+	if utility.StringIsIn(loweredValue, types) {
 		return &Token{
 			Value: loweredValue,
 			Kind:  TypeKind,
@@ -159,6 +379,46 @@ func ParseTypeToken(value string) *Token {
 	return nil
 }
 
+// ParseParameterToken parses placeholder of prepared statement which can be
+// positional ("?"), numbered ("$1", numbers start from 1) or named (":name")
+func ParseParameterToken(value string) *Token {
+	switch {
+	case value == "?":
+	case strings.HasPrefix(value, "$"):
+		number, err := strconv.Atoi(value[1:])
+		if err != nil || number < 1 || strconv.Itoa(number) != value[1:] {
+			return nil
+		}
+	case strings.HasPrefix(value, ":"):
+		if !isName(value[1:]) {
+			return nil
+		}
+	default:
+		return nil
+	}
+	return &Token{
+		Value: value,
+		Kind:  ParameterKind,
+	}
+}
+
+// isName checks if value consists of letters, digits and underscores and does
+// not start with a digit
+func isName(value string) bool {
+	if value == "" {
+		return false
+	}
+	for index, character := range value {
+		switch {
+		case character == '_', unicode.IsLetter(character):
+		case unicode.IsDigit(character) && index > 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
 func ParseIdentifierToken(value string) *Token {
 	return &Token{
 		Value: value,
@@ -191,23 +451,80 @@ func ParseTokenSequence(expression string) *[]*Token {
 		startPosition = 0
 		resultTokens  []*Token
 	)
-	parts := utility.DivideBySeparators(expression, symbols)
-	for _, part := range parts {
-		token, err := TokenFromString(part, startPosition)
-		if err != nil {
+	for len(expression) != 0 {
+		// Everything before string literal or quoted identifier is divided by
+		// symbols
+		quotePosition, quote := len(expression), QuoteSymbol
+		for _, candidate := range []string{QuoteSymbol, BacktickSymbol} {
+			if index := strings.Index(expression, candidate); index != -1 && index < quotePosition {
+				quotePosition, quote = index, candidate
+			}
+		}
+		parts := utility.DivideBySeparators(expression[:quotePosition], symbols)
+		for _, part := range parts {
+			token, err := TokenFromString(part, startPosition)
+			if err != nil {
+				return nil
+			}
+			token.Position = startPosition
+			// FIXME: replace it with actual length (for different languages)
+			startPosition += len(token.Value)
+			// Removing spaces from token list
+			if strings.TrimSpace(token.Value) != "" {
+				resultTokens = append(resultTokens, token)
+			}
+		}
+		expression = expression[quotePosition:]
+		if len(expression) == 0 {
+			break
+		}
+
+		kind := StringKind
+		if quote == BacktickSymbol {
+			kind = IdentifierKind
+		}
+		token, length := scanQuotedToken(expression, quote, kind)
+		if token == nil {
 			return nil
 		}
 		token.Position = startPosition
-		// FIXME: replace it with actual length (for different languages)
-		startPosition += len(token.Value)
-		// Removing spaces from token list
-		if strings.TrimSpace(token.Value) != "" {
-			resultTokens = append(resultTokens, token)
-		}
+		startPosition += length
+		resultTokens = append(resultTokens, token)
+		expression = expression[length:]
 	}
 	return &resultTokens
 }
 
+// scanQuotedToken reads string literal or identifier enclosed in given quotes
+// from the beginning of expression and returns it with the length of token
+// including quotes. Returns nil if token is not closed or if it is an empty
+// identifier
+func scanQuotedToken(expression string, quote string, kind TokenKind) (*Token, int) {
+	var value strings.Builder
+	position := len(quote)
+	for {
+		closing := strings.Index(expression[position:], quote)
+		if closing == -1 {
+			return nil, 0
+		}
+		value.WriteString(expression[position : position+closing])
+		position += closing + len(quote)
+		// Doubled quote is a quote inside of token
+		if !strings.HasPrefix(expression[position:], quote) {
+			break
+		}
+		value.WriteString(quote)
+		position += len(quote)
+	}
+	if kind == IdentifierKind && value.Len() == 0 {
+		return nil, 0
+	}
+	return &Token{
+		Value: value.String(),
+		Kind:  kind,
+	}, position
+}
+
 func FindToken(tokens []*Token, expected *Token) int {
 	for index := range tokens {
 		if tokens[index].Equals(expected) {
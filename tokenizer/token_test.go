@@ -72,6 +72,32 @@ func TestTokenParsing(t *testing.T) {
 			}
 		}
 	})
+	t.Run("Converting keywords to identifiers", func(t *testing.T) {
+		input := []*Token{
+			{Value: "level", Kind: KeywordKind},
+			{Value: "rows", Kind: KeywordKind},
+			{Value: "users", Kind: IdentifierKind},
+			{Value: "select", Kind: KeywordKind},
+			{Value: "from", Kind: KeywordKind},
+			{Value: "1", Kind: NumericKind},
+		}
+		output := []*Token{
+			{Value: "level", Kind: IdentifierKind},
+			{Value: "rows", Kind: IdentifierKind},
+			{Value: "users", Kind: IdentifierKind},
+			nil,
+			nil,
+			nil,
+		}
+		for index, testCase := range input {
+			actualValue := AsIdentifier(testCase)
+			if (actualValue == nil) != (output[index] == nil) ||
+				(actualValue != nil && !actualValue.Equals(output[index])) {
+				t.Errorf("Error in test case #%d: expected: %v, got: %v",
+					index, output[index], actualValue)
+			}
+		}
+	})
 }
 
 func TestTokenSequenceParsing(t *testing.T) {
@@ -191,4 +217,127 @@ func TestTokenSequenceParsing(t *testing.T) {
 			}
 		}
 	})
+	t.Run("Parse string literals", func(t *testing.T) {
+		inputs := []string{
+			"select 'hello, world' from test",
+			"where a = 'it''s'",
+			"''",
+			"select a\n\tfrom test;\r\n",
+		}
+		expectedResults := [][]*Token{
+			{
+				{Value: "select", Kind: KeywordKind, Position: 0},
+				{Value: "hello, world", Kind: StringKind, Position: 7},
+				{Value: "from", Kind: KeywordKind, Position: 22},
+				{Value: "test", Kind: IdentifierKind, Position: 27},
+			},
+			{
+				{Value: "where", Kind: KeywordKind, Position: 0},
+				{Value: "a", Kind: IdentifierKind, Position: 6},
+				{Value: "=", Kind: SymbolKind, Position: 8},
+				{Value: "it's", Kind: StringKind, Position: 10},
+			},
+			{
+				{Value: "", Kind: StringKind, Position: 0},
+			},
+			{
+				{Value: "select", Kind: KeywordKind, Position: 0},
+				{Value: "a", Kind: IdentifierKind, Position: 7},
+				{Value: "from", Kind: KeywordKind, Position: 10},
+				{Value: "test", Kind: IdentifierKind, Position: 15},
+				{Value: ";", Kind: SymbolKind, Position: 19},
+			},
+		}
+		for testCase := range inputs {
+			actualResult := *ParseTokenSequence(inputs[testCase])
+			if len(actualResult) != len(expectedResults[testCase]) {
+				t.Errorf("Function have returned unexpected number of tokens: %d (expected %d)",
+					len(actualResult), len(expectedResults[testCase]))
+				continue
+			}
+			for index := range actualResult {
+				if !actualResult[index].Equals(expectedResults[testCase][index]) ||
+					actualResult[index].Position != expectedResults[testCase][index].Position {
+					t.Errorf("Tokens on position %d are different. Expected: %s, got: %s",
+						index+1,
+						expectedResults[testCase][index],
+						actualResult[index])
+				}
+			}
+		}
+	})
+	t.Run("Parse backtick-quoted identifiers", func(t *testing.T) {
+		inputs := []string{
+			"select `order` from `my table`",
+			"select `a``b`,'`c`' from test",
+		}
+		expectedResults := [][]*Token{
+			{
+				{Value: "select", Kind: KeywordKind, Position: 0},
+				{Value: "order", Kind: IdentifierKind, Position: 7},
+				{Value: "from", Kind: KeywordKind, Position: 15},
+				{Value: "my table", Kind: IdentifierKind, Position: 20},
+			},
+			{
+				{Value: "select", Kind: KeywordKind, Position: 0},
+				{Value: "a`b", Kind: IdentifierKind, Position: 7},
+				{Value: ",", Kind: SymbolKind, Position: 13},
+				{Value: "`c`", Kind: StringKind, Position: 14},
+				{Value: "from", Kind: KeywordKind, Position: 20},
+				{Value: "test", Kind: IdentifierKind, Position: 25},
+			},
+		}
+		for testCase := range inputs {
+			actualResult := *ParseTokenSequence(inputs[testCase])
+			if len(actualResult) != len(expectedResults[testCase]) {
+				t.Errorf("Function have returned unexpected number of tokens: %d (expected %d)",
+					len(actualResult), len(expectedResults[testCase]))
+				continue
+			}
+			for index := range actualResult {
+				if !actualResult[index].Equals(expectedResults[testCase][index]) ||
+					actualResult[index].Position != expectedResults[testCase][index].Position {
+					t.Errorf("Tokens on position %d are different. Expected: %s, got: %s",
+						index+1,
+						expectedResults[testCase][index],
+						actualResult[index])
+				}
+			}
+		}
+		for _, input := range []string{"select `a from test", "select `` from test"} {
+			if actualResult := ParseTokenSequence(input); actualResult != nil {
+				t.Errorf("Expected nil on invalid quoted identifier %q, got: %v", input, *actualResult)
+			}
+		}
+	})
+	t.Run("Parse parameter tokens", func(t *testing.T) {
+		inputs := []string{"?", "$1", "$12", ":name", ":_id2"}
+		for _, input := range inputs {
+			actualResult := ParseParameterToken(input)
+			if actualResult == nil || !actualResult.Equals(&Token{Value: input, Kind: ParameterKind}) {
+				t.Errorf("Expected parameter token from %q, got: %v", input, actualResult)
+			}
+		}
+		invalidInputs := []string{"??", "$", "$0", "$01", "$a", ":", ":1a", "a"}
+		for _, input := range invalidInputs {
+			if actualResult := ParseParameterToken(input); actualResult != nil {
+				t.Errorf("Expected nil from %q, got: %s", input, actualResult)
+			}
+		}
+		tokens := *ParseTokenSequence("where a=? and b in ($1,:c)")
+		var parameters []string
+		for _, token := range tokens {
+			if token.Kind == ParameterKind {
+				parameters = append(parameters, token.Value)
+			}
+		}
+		if len(parameters) != 3 || parameters[0] != "?" || parameters[1] != "$1" || parameters[2] != ":c" {
+			t.Errorf("Unexpected parameters in token sequence: %v", parameters)
+		}
+	})
+	t.Run("Parse unterminated string literal", func(t *testing.T) {
+		if actualResult := ParseTokenSequence("select 'test from test"); actualResult != nil {
+			t.Errorf("Expected nil on unterminated literal, got: %v", *actualResult)
+		}
+	})
 }
@@ -0,0 +1,69 @@
+package utility
+
+import "encoding/binary"
+
+// AppendUvarint appends varint encoding of unsigned value to buffer
+func AppendUvarint(buffer []byte, value uint64) []byte {
+	var encoded [binary.MaxVarintLen64]byte
+	return append(buffer, encoded[:binary.PutUvarint(encoded[:], value)]...)
+}
+
+// AppendVarint appends varint encoding of signed value to buffer
+func AppendVarint(buffer []byte, value int64) []byte {
+	var encoded [binary.MaxVarintLen64]byte
+	return append(buffer, encoded[:binary.PutVarint(encoded[:], value)]...)
+}
+
+// AppendBytes appends value prefixed with its length to buffer
+func AppendBytes(buffer []byte, value []byte) []byte {
+	return append(AppendUvarint(buffer, uint64(len(value))), value...)
+}
+
+// Decoder reads values appended by AppendUvarint and AppendBytes. After the
+// first malformed value it keeps returning zero values and Err returns error
+// it was created with
+type Decoder struct {
+	bytes     []byte
+	corrupted error
+	err       error
+}
+
+// NewDecoder returns Decoder of bytes which fails with corrupted error
+func NewDecoder(bytes []byte, corrupted error) *Decoder {
+	return &Decoder{bytes: bytes, corrupted: corrupted}
+}
+
+func (d *Decoder) Uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	value, size := binary.Uvarint(d.bytes)
+	if size <= 0 {
+		d.err = d.corrupted
+		return 0
+	}
+	d.bytes = d.bytes[size:]
+	return value
+}
+
+// Take returns the next size bytes
+func (d *Decoder) Take(size uint64) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if size > uint64(len(d.bytes)) {
+		d.err = d.corrupted
+		return nil
+	}
+	result := d.bytes[:size:size]
+	d.bytes = d.bytes[size:]
+	return result
+}
+
+// Bytes returns value appended by AppendBytes
+func (d *Decoder) Bytes() []byte { return d.Take(d.Uvarint()) }
+
+// Remaining returns number of bytes which are not read yet
+func (d *Decoder) Remaining() int { return len(d.bytes) }
+
+func (d *Decoder) Err() error { return d.err }
@@ -1,6 +1,8 @@
 package utility
 
 import (
+	"bytes"
+	"errors"
 	"strings"
 	"testing"
 )
@@ -86,3 +88,42 @@ func TestStringMethods(t *testing.T) {
 		}
 	})
 }
+
+func TestEncoding(t *testing.T) {
+	t.Run("Test decoding appended values", func(t *testing.T) {
+		buffer := AppendUvarint(nil, 300)
+		buffer = AppendBytes(buffer, []byte("disco"))
+		buffer = AppendBytes(buffer, nil)
+		buffer = append(buffer, 7)
+		decoder := NewDecoder(buffer, nil)
+		if value := decoder.Uvarint(); value != 300 {
+			t.Errorf("Expected 300, got: %d", value)
+		}
+		if value := decoder.Bytes(); !bytes.Equal(value, []byte("disco")) {
+			t.Errorf("Expected disco, got: %q", value)
+		}
+		if value := decoder.Bytes(); len(value) != 0 {
+			t.Errorf("Expected empty value, got: %q", value)
+		}
+		if value := decoder.Take(1); !bytes.Equal(value, []byte{7}) || decoder.Remaining() != 0 || decoder.Err() != nil {
+			t.Errorf("Expected the last byte, got: %v (%v)", value, decoder.Err())
+		}
+	})
+	t.Run("Test decoding malformed values", func(t *testing.T) {
+		corrupted := errors.New("corrupted")
+		inputs := [][]byte{
+			{},
+			{0x80},
+			AppendUvarint(nil, 10),
+		}
+		for testCase := range inputs {
+			decoder := NewDecoder(inputs[testCase], corrupted)
+			if value := decoder.Bytes(); value != nil || decoder.Err() != corrupted {
+				t.Errorf("Expected error on set #%d. Values got: %v", testCase, value)
+			}
+			if value := decoder.Uvarint(); value != 0 {
+				t.Errorf("Expected zero after error on set #%d, got: %d", testCase, value)
+			}
+		}
+	})
+}